@@ -0,0 +1,27 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/acl"
+)
+
+type (
+	// ACL 路由名对应需要的角色或 scope  在认证之后检查
+	ACL struct {
+		// 路由名 type.action 或 type  例如 "user.delete": ["admin"]  空数组为公开
+		Rules map[string][]string `json:"rules,omitempty"`
+
+		// 没有规则或者没有路由名的请求也允许  默认 403
+		Allow bool `json:"allow,omitempty"`
+	}
+)
+
+func (config *ACL) init(server *Server, handler *Handler) {
+}
+
+func (config *ACL) middleware() gin.HandlerFunc {
+	return acl.Middleware(acl.Config{
+		Rules: config.Rules,
+		Allow: config.Allow,
+	})
+}
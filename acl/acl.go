@@ -0,0 +1,148 @@
+package acl
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/apikey"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/jwt"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	"github.com/otamoe/gin-server/rbac"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	Config struct {
+		// 路由名 type.action 或 type  => 角色或 scope  满足其中一个即可  空数组为公开
+		// 例如 "user.delete": ["admin"]
+		Rules map[string][]string
+
+		// 没有规则或者没有路由名的请求也允许  默认 403
+		Allow bool
+
+		// 默认 Grants
+		Grants func(ctx *gin.Context) []string
+	}
+)
+
+var CONTEXT = "GIN.SERVER.ACL"
+
+var (
+	ErrRequired = &errs.Error{
+		Message:    "Authentication is required",
+		Type:       "acl",
+		StatusCode: http.StatusUnauthorized,
+	}
+	ErrForbidden = &errs.Error{
+		Message:    "Permission denied",
+		Type:       "acl",
+		StatusCode: http.StatusForbidden,
+	}
+)
+
+// Middleware 在认证之后使用  apikey basicauth 需要在它之前  路由上的认证之后检查时在路由上使用
+// 默认拒绝  没有规则的路由  没有路由名的请求返回 403  公开的路由使用空数组
+func Middleware(c Config) gin.HandlerFunc {
+	if c.Grants == nil {
+		c.Grants = Grants
+	}
+	return func(ctx *gin.Context) {
+		var required []string
+		ok := false
+		if val, exists := ctx.Get(ginResource.CONTEXT); exists && val != nil {
+			resource := val.(*ginResource.Resource)
+			if resource.Type != "" {
+				required, ok = c.Rules[resource.Name()]
+				if !ok {
+					required, ok = c.Rules[resource.Type]
+				}
+			}
+		}
+		if !ok {
+			if !c.Allow {
+				ctx.Error(ErrForbidden)
+				ctx.Abort()
+				return
+			}
+			ctx.Next()
+			return
+		}
+		ctx.Set(CONTEXT, required)
+		if len(required) == 0 {
+			ctx.Next()
+			return
+		}
+
+		if rbac.Subject(ctx) == "" {
+			ctx.Error(ErrRequired)
+			ctx.Abort()
+			return
+		}
+		grants := c.Grants(ctx)
+		for _, name := range required {
+			if Match(grants, name) {
+				ctx.Next()
+				return
+			}
+		}
+		err := ErrForbidden.Clone()
+		err.Params = map[string]interface{}{
+			"required": required,
+		}
+		ctx.Error(err)
+		ctx.Abort()
+	}
+}
+
+// Get 当前路由需要的角色或 scope
+func Get(ctx *gin.Context) []string {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.([]string)
+	}
+	return nil
+}
+
+// Grants jwt 的 roles scope  apikey 的 scopes  有 mongo 中间件时 rbac 的角色和权限
+func Grants(ctx *gin.Context) (grants []string) {
+	if claims := jwt.Get(ctx); claims != nil {
+		grants = append(grants, strings.Fields(claims.String("scope"))...)
+		for _, name := range []string{"roles", "scopes"} {
+			if values, ok := claims[name].([]interface{}); ok {
+				for _, value := range values {
+					if value, ok := value.(string); ok {
+						grants = append(grants, value)
+					}
+				}
+			}
+		}
+	}
+	if key := apikey.Get(ctx); key != nil {
+		grants = append(grants, key.Scopes...)
+	}
+	if val, ok := ctx.Get(mongoMiddleware.CONTEXT); ok && val != nil {
+		if subject := rbac.Subject(ctx); subject != "" {
+			if roles, err := rbac.RolesOf(ctx, subject); err == nil {
+				grants = append(grants, roles...)
+			}
+			if permissions, err := rbac.Permissions(ctx); err == nil {
+				grants = append(grants, permissions...)
+			}
+		}
+	}
+	return
+}
+
+// Match 支持 *  type.*  和 type:*
+func Match(grants []string, name string) bool {
+	for _, val := range grants {
+		if val == "*" || val == name {
+			return true
+		}
+		if (strings.HasSuffix(val, ".*") || strings.HasSuffix(val, ":*")) && strings.HasPrefix(name, val[:len(val)-1]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package acl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/rbac"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rules := map[string][]string{
+		"article":        {},
+		"article.delete": {"admin"},
+	}
+	tests := []struct {
+		name     string
+		resource *ginResource.Resource
+		allow    bool
+		subject  string
+		grants   []string
+		status   int
+	}{
+		{"no resource", nil, false, "", nil, http.StatusForbidden},
+		{"no resource allow", nil, true, "", nil, http.StatusOK},
+		{"empty resource", &ginResource.Resource{}, false, "", nil, http.StatusForbidden},
+		{"no rule", &ginResource.Resource{Type: "user", Action: "read"}, false, "", nil, http.StatusForbidden},
+		{"no rule allow", &ginResource.Resource{Type: "user", Action: "read"}, true, "", nil, http.StatusOK},
+		{"public", &ginResource.Resource{Type: "article", Action: "read"}, false, "", nil, http.StatusOK},
+		{"anonymous", &ginResource.Resource{Type: "article", Action: "delete"}, false, "", nil, http.StatusUnauthorized},
+		{"forbidden", &ginResource.Resource{Type: "article", Action: "delete"}, false, "1", []string{"user"}, http.StatusForbidden},
+		{"granted", &ginResource.Resource{Type: "article", Action: "delete"}, false, "1", []string{"admin"}, http.StatusOK},
+	}
+	for _, test := range tests {
+		engine := gin.New()
+		engine.Use(func(ctx *gin.Context) {
+			if test.resource != nil {
+				ctx.Set(ginResource.CONTEXT, test.resource)
+			}
+			ctx.Set(rbac.CONTEXT_CONFIG, rbac.Config{
+				Subject: func(ctx *gin.Context) string {
+					return test.subject
+				},
+			})
+			ctx.Next()
+			if len(ctx.Errors) != 0 {
+				ctx.Status(ctx.Errors.Last().Err.(*errs.Error).StatusCode)
+			}
+		})
+		grants := test.grants
+		engine.Use(Middleware(Config{
+			Rules: rules,
+			Allow: test.allow,
+			Grants: func(ctx *gin.Context) []string {
+				return grants
+			},
+		}))
+		engine.GET("/", func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, recorder.Code, test.status)
+		}
+	}
+}
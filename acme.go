@@ -0,0 +1,80 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type (
+	ACME struct {
+		// 从目录读取 token 文件
+		Dir string `json:"dir,omitempty"`
+
+		// token => key authorization
+		Tokens map[string]string `json:"tokens,omitempty"`
+
+		// 例如 autocert.Manager.HTTPHandler(nil)
+		Handler http.Handler `json:"-"`
+
+		mutex sync.RWMutex
+	}
+)
+
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+func (config *ACME) init(server *Server, handler *Handler) {
+	if config.Tokens == nil {
+		config.Tokens = map[string]string{}
+	}
+}
+
+// SetToken 运行时添加 token
+func (config *ACME) SetToken(token string, keyAuthorization string) {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	if config.Tokens == nil {
+		config.Tokens = map[string]string{}
+	}
+	config.Tokens[token] = keyAuthorization
+}
+
+func (config *ACME) DeleteToken(token string) {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	delete(config.Tokens, token)
+}
+
+func (config *ACME) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	if config.Handler != nil {
+		config.Handler.ServeHTTP(writer, req)
+		return
+	}
+
+	token := strings.TrimPrefix(req.URL.Path, acmeChallengePrefix)
+	if token == "" || strings.ContainsAny(token, "/\\.") {
+		http.NotFound(writer, req)
+		return
+	}
+
+	config.mutex.RLock()
+	keyAuthorization, ok := config.Tokens[token]
+	config.mutex.RUnlock()
+
+	if !ok && config.Dir != "" {
+		if body, err := ioutil.ReadFile(filepath.Join(config.Dir, token)); err == nil {
+			keyAuthorization = strings.TrimSpace(string(body))
+			ok = true
+		}
+	}
+
+	if !ok {
+		http.NotFound(writer, req)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/plain")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte(keyAuthorization))
+}
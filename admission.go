@@ -0,0 +1,66 @@
+package server
+
+import (
+	"time"
+
+	"github.com/otamoe/gin-server/admission"
+	"github.com/otamoe/gin-server/metrics"
+)
+
+type (
+	// Admission 按路由分类别限制并发  超过时排队  后台和报表不会占满交互请求
+	Admission struct {
+		// 类别名 => 限制
+		Classes map[string]*AdmissionClass `json:"classes,omitempty"`
+
+		// 路由名 type.action 或 type => 类别名  例如 "report.generate": "report"
+		Routes map[string]string `json:"routes,omitempty"`
+
+		// 没有匹配的路由使用的类别  为空时不限制
+		Default string `json:"default,omitempty"`
+
+		RetryAfter time.Duration `json:"retry_after,omitempty"`
+
+		controller *admission.Controller
+	}
+
+	AdmissionClass struct {
+		Concurrency  int           `json:"concurrency,omitempty"`
+		Queue        int           `json:"queue,omitempty"`
+		QueueTimeout time.Duration `json:"queue_timeout,omitempty"`
+	}
+)
+
+// init server 的配置所有 handler 共用一个 Controller
+func (config *Admission) init(server *Server, handler *Handler) {
+	if config.controller != nil {
+		return
+	}
+	c := admission.Config{
+		Classes:    map[string]admission.Class{},
+		Routes:     config.Routes,
+		Default:    config.Default,
+		RetryAfter: config.RetryAfter,
+	}
+	for name, val := range config.Classes {
+		c.Classes[name] = admission.Class{
+			Concurrency:  val.Concurrency,
+			Queue:        val.Queue,
+			QueueTimeout: val.QueueTimeout,
+		}
+	}
+	if handler != nil {
+		c.Name = handler.Name
+	} else if server != nil {
+		c.Name = server.Name
+	}
+	if server != nil && server.Metrics != nil {
+		c.Registry = metrics.Default
+	}
+	config.controller = admission.New(c)
+}
+
+// Get 每个类别正在处理和排队的数量
+func (config *Admission) Get() *admission.Controller {
+	return config.controller
+}
@@ -0,0 +1,197 @@
+package admission
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/backpressure"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/metrics"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	Config struct {
+		// metrics 的 name 标签
+		Name string
+
+		// 类别名 => 限制  例如 interactive background report
+		Classes map[string]Class
+
+		// 路由名 type.action 或 type => 类别名
+		Routes map[string]string
+
+		// 没有匹配的路由使用的类别  为空时不限制
+		Default string
+
+		// 默认 1 秒
+		RetryAfter time.Duration
+
+		// 有值时输出 admission_rejected_total admission_queue_seconds_total
+		Registry *metrics.Registry
+	}
+
+	Class struct {
+		// 同时处理的请求数  0 不限制
+		Concurrency int
+
+		// 等待的请求数  0 不排队  超过直接拒绝
+		Queue int
+
+		// 排队的最长时间  0 只受请求的 deadline 限制
+		QueueTimeout time.Duration
+	}
+
+	Controller struct {
+		config  Config
+		classes map[string]*class
+
+		rejected  *metrics.Counter
+		queueTime *metrics.Counter
+	}
+
+	class struct {
+		Class
+		slots  chan struct{}
+		queued int64
+	}
+
+	Stats struct {
+		Active int `json:"active"`
+		Queued int `json:"queued"`
+	}
+)
+
+var CONTEXT = "GIN.SERVER.ADMISSION"
+
+var (
+	ErrQueueFull    = backpressure.Error(http.StatusServiceUnavailable, "admission", time.Second)
+	ErrQueueTimeout = backpressure.Error(http.StatusServiceUnavailable, "admission_timeout", time.Second)
+)
+
+func New(c Config) *Controller {
+	if c.RetryAfter <= 0 {
+		c.RetryAfter = time.Second
+	}
+	controller := &Controller{
+		config:  c,
+		classes: map[string]*class{},
+	}
+	for name, val := range c.Classes {
+		cl := &class{Class: val}
+		if val.Concurrency > 0 {
+			cl.slots = make(chan struct{}, val.Concurrency)
+		}
+		controller.classes[name] = cl
+	}
+	if c.Registry != nil {
+		controller.rejected = c.Registry.Counter("admission_rejected_total", "Requests rejected by admission control.", "name", "class", "reason")
+		controller.queueTime = c.Registry.Counter("admission_queue_seconds_total", "Time spent waiting in admission queues.", "name", "class")
+	}
+	return controller
+}
+
+// Middleware 超过类别的并发时排队  队列满或排队超时返回 503
+func Middleware(controller *Controller) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		name := controller.class(ctx)
+		if name == "" {
+			ctx.Next()
+			return
+		}
+		ctx.Set(CONTEXT, name)
+		release, err := controller.Acquire(ctx.Request.Context(), name)
+		if err != nil {
+			backpressure.Abort(ctx, err, controller.config.RetryAfter)
+			return
+		}
+		defer release()
+		ctx.Next()
+	}
+}
+
+// Get 当前请求的类别  不限制时为空
+func Get(ctx *gin.Context) string {
+	return ctx.GetString(CONTEXT)
+}
+
+// Acquire 不经过 gin 时使用  成功时 release 在完成时调用  未知的类别不限制
+func (controller *Controller) Acquire(ctx context.Context, name string) (release func(), err *errs.Error) {
+	cl, ok := controller.classes[name]
+	if !ok || cl.slots == nil {
+		return func() {}, nil
+	}
+	release = func() {
+		<-cl.slots
+	}
+
+	select {
+	case cl.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if atomic.AddInt64(&cl.queued, 1) > int64(cl.Queue) {
+		atomic.AddInt64(&cl.queued, -1)
+		controller.reject(name, "queue_full")
+		return nil, ErrQueueFull
+	}
+	defer atomic.AddInt64(&cl.queued, -1)
+
+	start := time.Now()
+	var timeout <-chan time.Time
+	if cl.QueueTimeout > 0 {
+		timer := time.NewTimer(cl.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	defer func() {
+		if controller.queueTime != nil {
+			controller.queueTime.Add(time.Since(start).Seconds(), controller.config.Name, name)
+		}
+	}()
+
+	select {
+	case cl.slots <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		controller.reject(name, "queue_timeout")
+		return nil, ErrQueueTimeout
+	case <-ctx.Done():
+		controller.reject(name, "canceled")
+		return nil, ErrQueueTimeout
+	}
+}
+
+// Stats 每个类别正在处理和排队的数量
+func (controller *Controller) Stats() map[string]Stats {
+	stats := map[string]Stats{}
+	for name, cl := range controller.classes {
+		stats[name] = Stats{
+			Active: len(cl.slots),
+			Queued: int(atomic.LoadInt64(&cl.queued)),
+		}
+	}
+	return stats
+}
+
+func (controller *Controller) class(ctx *gin.Context) string {
+	if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+		resource := val.(*ginResource.Resource)
+		if val, ok := controller.config.Routes[resource.Name()]; ok {
+			return val
+		} else if val, ok := controller.config.Routes[resource.Type]; ok {
+			return val
+		}
+	}
+	return controller.config.Default
+}
+
+func (controller *Controller) reject(name string, reason string) {
+	if controller.rejected != nil {
+		controller.rejected.Inc(controller.config.Name, name, reason)
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"time"
+
+	"github.com/otamoe/gin-server/alerts"
+)
+
+type (
+	// Alerts 只在 server 上生效
+	Alerts struct {
+		Window      time.Duration `json:"window,omitempty"`
+		ErrorRate   float64       `json:"error_rate,omitempty"`
+		Latency     time.Duration `json:"latency,omitempty"`
+		Percentile  float64       `json:"percentile,omitempty"`
+		MinRequests int           `json:"min_requests,omitempty"`
+		Consecutive int           `json:"consecutive,omitempty"`
+		Webhooks    []string      `json:"webhooks,omitempty"`
+
+		Callbacks []func(alert alerts.Alert) `json:"-"`
+
+		monitor *alerts.Monitor
+	}
+)
+
+func (config *Alerts) init(server *Server, handler *Handler) {
+	if config.monitor != nil {
+		return
+	}
+	callbacks := config.Callbacks
+	if server.Notify != nil && server.Notify.Alerts {
+		callbacks = append(callbacks[:len(callbacks):len(callbacks)], server.Notify.alert)
+	}
+	config.monitor = alerts.New(alerts.Config{
+		Window:      config.Window,
+		ErrorRate:   config.ErrorRate,
+		Latency:     config.Latency,
+		Percentile:  config.Percentile,
+		MinRequests: config.MinRequests,
+		Consecutive: config.Consecutive,
+		Callbacks:   callbacks,
+		Webhooks:    config.Webhooks,
+		Logger:      server.Logger.Get(),
+	})
+}
+
+func (config *Alerts) Get() *alerts.Monitor {
+	return config.monitor
+}
@@ -0,0 +1,315 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	Config struct {
+		// 统计窗口
+		Window time.Duration
+
+		// 5xx 比例 0 - 1  0 不检查
+		ErrorRate float64
+
+		// 延迟百分位  0 不检查
+		Latency    time.Duration
+		Percentile float64
+
+		// 窗口内请求数小于 MinRequests 不检查
+		MinRequests int
+
+		// 连续 N 个窗口超过阈值才触发
+		Consecutive int
+
+		Callbacks []func(alert Alert)
+
+		// POST json
+		Webhooks []string
+
+		Logger *logrus.Logger
+	}
+
+	Alert struct {
+		Host      string        `json:"host"`
+		Route     string        `json:"route"`
+		Kind      string        `json:"kind"`
+		Value     float64       `json:"value"`
+		Threshold float64       `json:"threshold"`
+		Requests  int           `json:"requests"`
+		Windows   int           `json:"windows"`
+		Window    time.Duration `json:"window"`
+		Resolved  bool          `json:"resolved,omitempty"`
+		CreatedAt time.Time     `json:"created_at"`
+	}
+
+	Monitor struct {
+		config  Config
+		client  *http.Client
+		mutex   sync.Mutex
+		windows map[key]*window
+		states  map[key]*state
+		stop    chan struct{}
+		once    sync.Once
+	}
+
+	key struct {
+		host  string
+		route string
+	}
+
+	window struct {
+		requests  int
+		errors    int
+		latencies []time.Duration
+	}
+
+	state struct {
+		errorRate int
+		latency   int
+		firing    map[string]bool
+	}
+)
+
+const (
+	KindErrorRate = "error_rate"
+	KindLatency   = "latency"
+)
+
+var CONTEXT = "GIN.SERVER.ALERTS"
+
+// 每个窗口保留的延迟样本数
+var maxSamples = 1024
+
+func New(c Config) *Monitor {
+	if c.Window == 0 {
+		c.Window = time.Minute
+	}
+	if c.Percentile == 0 {
+		c.Percentile = 0.99
+	}
+	if c.MinRequests == 0 {
+		c.MinRequests = 20
+	}
+	if c.Consecutive == 0 {
+		c.Consecutive = 3
+	}
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	monitor := &Monitor{
+		config:  c,
+		client:  &http.Client{Timeout: time.Second * 10},
+		windows: map[key]*window{},
+		states:  map[key]*state{},
+		stop:    make(chan struct{}),
+	}
+	go monitor.run()
+	return monitor
+}
+
+// Middleware 按 host 和 resource type.action 统计
+func Middleware(monitor *Monitor) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, monitor)
+		now := time.Now()
+		ctx.Next()
+
+		route := ginResource.RoutePath(ctx)
+		if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+			resource := val.(*ginResource.Resource)
+			resource.Pre()
+			if name := resource.Name(); name != "" {
+				route = name
+			}
+		}
+		monitor.Observe(ctx.Request.Host, route, ctx.Writer.Status(), time.Since(now))
+	}
+}
+
+func (monitor *Monitor) Observe(host string, route string, status int, latency time.Duration) {
+	k := key{host: host, route: route}
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	w, ok := monitor.windows[k]
+	if !ok {
+		w = &window{}
+		monitor.windows[k] = w
+	}
+	w.requests++
+	if status >= 500 {
+		w.errors++
+	}
+	// 蓄水池采样
+	if len(w.latencies) < maxSamples {
+		w.latencies = append(w.latencies, latency)
+	} else if i := rand.Intn(w.requests); i < maxSamples {
+		w.latencies[i] = latency
+	}
+}
+
+func (monitor *Monitor) Stop() {
+	monitor.once.Do(func() {
+		close(monitor.stop)
+	})
+}
+
+func (monitor *Monitor) run() {
+	ticker := time.NewTicker(monitor.config.Window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-monitor.stop:
+			return
+		case <-ticker.C:
+			for _, alert := range monitor.evaluate() {
+				monitor.fire(alert)
+			}
+		}
+	}
+}
+
+// evaluate 结束当前窗口  返回需要触发的告警
+func (monitor *Monitor) evaluate() (alerts []Alert) {
+	monitor.mutex.Lock()
+	windows := monitor.windows
+	monitor.windows = map[key]*window{}
+	monitor.mutex.Unlock()
+
+	now := time.Now()
+	c := monitor.config
+	for k, s := range monitor.states {
+		if _, ok := windows[k]; !ok && len(s.firing) == 0 {
+			delete(monitor.states, k)
+		}
+	}
+	for k, w := range windows {
+		s, ok := monitor.states[k]
+		if !ok {
+			s = &state{firing: map[string]bool{}}
+			monitor.states[k] = s
+		}
+		if w.requests < c.MinRequests {
+			continue
+		}
+		alert := Alert{
+			Host:      k.host,
+			Route:     k.route,
+			Requests:  w.requests,
+			Window:    c.Window,
+			CreatedAt: now,
+		}
+
+		if c.ErrorRate > 0 {
+			rate := float64(w.errors) / float64(w.requests)
+			if rate > c.ErrorRate {
+				s.errorRate++
+			} else {
+				s.errorRate = 0
+			}
+			alert.Kind = KindErrorRate
+			alert.Value = rate
+			alert.Threshold = c.ErrorRate
+			alert.Windows = s.errorRate
+			if val, ok := s.transition(KindErrorRate, s.errorRate, c.Consecutive, alert); ok {
+				alerts = append(alerts, val)
+			}
+		}
+
+		if c.Latency > 0 {
+			latency := percentile(w.latencies, c.Percentile)
+			if latency > c.Latency {
+				s.latency++
+			} else {
+				s.latency = 0
+			}
+			alert.Kind = KindLatency
+			alert.Value = latency.Seconds()
+			alert.Threshold = c.Latency.Seconds()
+			alert.Windows = s.latency
+			if val, ok := s.transition(KindLatency, s.latency, c.Consecutive, alert); ok {
+				alerts = append(alerts, val)
+			}
+		}
+	}
+	return
+}
+
+// transition 达到 consecutive 时触发一次  恢复后发送 resolved
+func (s *state) transition(kind string, count int, consecutive int, alert Alert) (Alert, bool) {
+	if count >= consecutive && !s.firing[kind] {
+		s.firing[kind] = true
+		return alert, true
+	}
+	if count == 0 && s.firing[kind] {
+		delete(s.firing, kind)
+		alert.Resolved = true
+		return alert, true
+	}
+	return alert, false
+}
+
+func (monitor *Monitor) fire(alert Alert) {
+	entry := monitor.config.Logger.WithFields(logrus.Fields{
+		"host":      alert.Host,
+		"route":     alert.Route,
+		"kind":      alert.Kind,
+		"value":     alert.Value,
+		"threshold": alert.Threshold,
+	})
+	if alert.Resolved {
+		entry.Info("Alert resolved")
+	} else {
+		entry.Warn("Alert firing")
+	}
+
+	for _, callback := range monitor.config.Callbacks {
+		callback(alert)
+	}
+	if len(monitor.config.Webhooks) == 0 {
+		return
+	}
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+	for _, webhook := range monitor.config.Webhooks {
+		go func(webhook string) {
+			res, err := monitor.client.Post(webhook, "application/json", bytes.NewReader(data))
+			if err != nil {
+				monitor.config.Logger.Error("Alert Webhook:", err)
+				return
+			}
+			res.Body.Close()
+			if res.StatusCode >= 300 {
+				monitor.config.Logger.Error("Alert Webhook: ", webhook, " ", res.Status)
+			}
+		}(webhook)
+	}
+}
+
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	index := int(float64(len(latencies))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	} else if index >= len(latencies) {
+		index = len(latencies) - 1
+	}
+	return latencies[index]
+}
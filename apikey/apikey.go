@@ -0,0 +1,256 @@
+package apikey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/errs"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/otamoe/gin-server/scope"
+	"github.com/otamoe/gin-server/utils"
+	mgoModel "github.com/otamoe/mgo-model"
+)
+
+type (
+	Config struct {
+		Header   string
+		Query    string
+		Required bool
+
+		// redis 缓存时间
+		CacheTTL time.Duration
+
+		// 不存在的 key 的缓存时间  默认 10s  -1 不缓存  随机 key 不会长期占用 redis
+		NegativeTTL time.Duration
+	}
+
+	Key struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    bson.ObjectId `json:"_id" bson:"_id"`
+		Hash                  string        `json:"-" bson:"hash"`
+		Prefix                string        `json:"prefix,omitempty" bson:"prefix,omitempty"`
+		Name                  string        `json:"name,omitempty" bson:"name,omitempty"`
+		OwnerID               bson.ObjectId `json:"owner_id,omitempty" bson:"owner,omitempty"`
+		Scopes                []string      `json:"scopes,omitempty" bson:"scopes,omitempty"`
+		RateLimit             int64         `json:"rate_limit,omitempty" bson:"rate_limit,omitempty"`
+		DailyQuota            int64         `json:"daily_quota,omitempty" bson:"daily_quota,omitempty"`
+		MonthlyQuota          int64         `json:"monthly_quota,omitempty" bson:"monthly_quota,omitempty"`
+		Disabled              bool          `json:"disabled,omitempty" bson:"disabled,omitempty"`
+		ExpiresAt             *time.Time    `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+		CreatedAt             *time.Time    `json:"created_at" bson:"created_at"`
+	}
+)
+
+var (
+	CONTEXT = "GIN.SERVER.APIKEY"
+	PREFIX  = "apikey"
+	Model   = &mgoModel.Model{
+		Name:     "api_keys",
+		Document: &Key{},
+		Indexs: []mgo.Index{
+			mgo.Index{
+				Key:        []string{"hash"},
+				Unique:     true,
+				Background: true,
+			},
+			mgo.Index{
+				Key:        []string{"owner"},
+				Background: true,
+			},
+		},
+	}
+
+	ErrRequired = &errs.Error{
+		Message:    "API key is required",
+		Type:       "apikey",
+		StatusCode: http.StatusUnauthorized,
+	}
+	ErrInvalid = &errs.Error{
+		Message:    "Invalid API key",
+		Type:       "apikey",
+		StatusCode: http.StatusUnauthorized,
+	}
+	ErrScope = &errs.Error{
+		Message:    "API key does not have the required scope",
+		Type:       "apikey",
+		StatusCode: http.StatusForbidden,
+	}
+)
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.Header == "" {
+		c.Header = "X-API-Key"
+	}
+	if c.CacheTTL == 0 {
+		c.CacheTTL = time.Minute * 5
+	}
+	if c.NegativeTTL == 0 {
+		c.NegativeTTL = time.Second * 10
+	}
+
+	return func(ctx *gin.Context) {
+		value := strings.TrimSpace(ctx.GetHeader(c.Header))
+		if value == "" && c.Query != "" {
+			value = ctx.Query(c.Query)
+		}
+		if value == "" {
+			if c.Required {
+				ctx.Error(ErrRequired)
+				ctx.Abort()
+				return
+			}
+			ctx.Next()
+			return
+		}
+
+		key, err := find(ctx, Hash(value), c.CacheTTL, c.NegativeTTL)
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		if key == nil || !key.Valid() {
+			ctx.Error(ErrInvalid)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Set(CONTEXT, key)
+		// scope 中间件
+		ctx.Set(scope.CONTEXT, key)
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Key {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Key)
+	}
+	return nil
+}
+
+// Limit 用于 rate.Config.Limit  key 没有配置时使用 defaultLimit
+func Limit(defaultLimit int64) func(ctx *gin.Context) int64 {
+	return func(ctx *gin.Context) int64 {
+		if key := Get(ctx); key != nil && key.RateLimit != 0 {
+			return key.RateLimit
+		}
+		return defaultLimit
+	}
+}
+
+// Generate 返回明文 key 和需要保存的文档
+func Generate(ownerID bson.ObjectId, scopes []string) (value string, key *Key) {
+	value = string(utils.RandByte(40, utils.RandAlphaNumber))
+	now := time.Now()
+	key = &Key{
+		ID:        bson.NewObjectId(),
+		Hash:      Hash(value),
+		Prefix:    value[:8],
+		OwnerID:   ownerID,
+		Scopes:    scopes,
+		CreatedAt: &now,
+	}
+	return
+}
+
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// Invalidate 修改或删除 key 后清除缓存
+func Invalidate(redisClient *redis.Client, hash string) error {
+	return InvalidatePrefix(redisClient, "", hash)
+}
+
+// InvalidatePrefix redis 设置了 key 前缀时使用
+func InvalidatePrefix(redisClient *redis.Client, prefix string, hash string) error {
+	return redisClient.Del(prefix + PREFIX + "." + hash).Err()
+}
+
+func (key *Key) Valid() bool {
+	if key.Disabled {
+		return false
+	}
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(time.Now()) {
+		return false
+	}
+	return true
+}
+
+// HasScope 支持 * 和 type.*
+func (key *Key) HasScope(name string) bool {
+	for _, val := range key.Scopes {
+		if val == "*" || val == name {
+			return true
+		}
+		if strings.HasSuffix(val, ".*") && strings.HasPrefix(name, val[:len(val)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (key *Key) ValidateScope(resource *ginResource.Resource) (params map[string]interface{}, err error) {
+	if !key.HasScope(resource.Name()) {
+		err = ErrScope
+		return
+	}
+	params = map[string]interface{}{
+		"owner": key.OwnerID,
+	}
+	return
+}
+
+func find(ctx *gin.Context, hash string, ttl time.Duration, negativeTTL time.Duration) (key *Key, err error) {
+	var redisClient *redis.Client
+	if val, ok := ctx.Get(redisMiddleware.CONTEXT); ok && val != nil {
+		redisClient = val.(*redis.Client)
+	}
+	cacheKey := redisMiddleware.Key(ctx, PREFIX+"."+hash)
+
+	// 缓存
+	if redisClient != nil {
+		if data, e := redisClient.Get(cacheKey).Bytes(); e == nil {
+			if string(data) == "null" {
+				return
+			}
+			key = &Key{}
+			if e = json.Unmarshal(data, key); e == nil {
+				key.Hash = hash
+				return
+			}
+			key = nil
+		}
+	}
+
+	key = &Key{}
+	if err = mongoMiddleware.C(ctx, Model.Name).Find(bson.M{"hash": hash}).One(key); err != nil {
+		key = nil
+		if err != mgo.ErrNotFound {
+			return
+		}
+		err = nil
+	}
+
+	switch {
+	case redisClient == nil:
+	case key != nil:
+		data, _ := json.Marshal(key)
+		redisClient.Set(cacheKey, data, ttl)
+	case negativeTTL > 0:
+		redisClient.Set(cacheKey, "null", negativeTTL)
+	}
+	return
+}
@@ -0,0 +1,377 @@
+package apikey
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/backpressure"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+	mgoModel "github.com/otamoe/mgo-model"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	QuotaConfig struct {
+		// key 没有设置时使用  0 不限制
+		DailyQuota   int64
+		MonthlyQuota int64
+
+		// 按这个时区计算日和月  默认 UTC
+		Location *time.Location
+	}
+
+	// Usage 每个 key 每天一条  由 Flusher 从 redis 写入
+	Usage struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    string        `json:"_id" bson:"_id"`
+		KeyID                 bson.ObjectId `json:"key_id" bson:"key_id"`
+		OwnerID               bson.ObjectId `json:"owner_id,omitempty" bson:"owner,omitempty"`
+		Day                   string        `json:"day" bson:"day"`
+		Month                 string        `json:"month" bson:"month"`
+		Count                 int64         `json:"count" bson:"count"`
+		UpdatedAt             time.Time     `json:"updated_at" bson:"updated_at"`
+	}
+
+	FlusherConfig struct {
+		Client *redis.Client
+
+		// 每次新建 session
+		Session func() *mgo.Session
+
+		// 和 redis 中间件相同的 key 前缀
+		KeyPrefix string
+
+		// mongo 集合前缀
+		CollectionPrefix string
+
+		Interval time.Duration
+
+		Logger *logrus.Logger
+	}
+
+	// Flusher 定时把 redis 中的计数写入 mongo
+	Flusher struct {
+		config FlusherConfig
+
+		once  sync.Once
+		close sync.Once
+		stop  chan struct{}
+		done  chan struct{}
+	}
+)
+
+var UsageModel = &mgoModel.Model{
+	Name:     "api_key_usage",
+	Document: &Usage{},
+	Indexs: []mgo.Index{
+		mgo.Index{
+			Key:        []string{"key_id", "day"},
+			Background: true,
+		},
+		mgo.Index{
+			Key:        []string{"owner", "day"},
+			Background: true,
+		},
+	},
+}
+
+var ErrQuotaRedis = errors.New("apikey: quota requires redis middleware")
+
+// 计数保留的时间  Flusher 停止时不丢失
+const usageTTL = time.Hour * 24 * 40
+
+// Quota 在 Middleware 之后使用  超过每日或每月的配额时 429
+func Quota(c QuotaConfig) gin.HandlerFunc {
+	if c.Location == nil {
+		c.Location = time.UTC
+	}
+	return func(ctx *gin.Context) {
+		key := Get(ctx)
+		if key == nil || redisMiddleware.Degraded(ctx) {
+			ctx.Next()
+			return
+		}
+		// 没有 redis 中间件是配置错误  不能不计数
+		if !redisMiddleware.Available(ctx) {
+			ctx.Error(ErrQuotaRedis)
+			ctx.Abort()
+			return
+		}
+		daily := key.DailyQuota
+		if daily == 0 {
+			daily = c.DailyQuota
+		}
+		monthly := key.MonthlyQuota
+		if monthly == 0 {
+			monthly = c.MonthlyQuota
+		}
+
+		redisClient := ctx.MustGet(redisMiddleware.CONTEXT).(*redis.Client)
+		now := time.Now().In(c.Location)
+		day, month := now.Format("2006-01-02"), now.Format("2006-01")
+		id := key.ID.Hex()
+		owner := ""
+		if key.OwnerID != "" {
+			owner = key.OwnerID.Hex()
+		}
+
+		var dayCmd, monthCmd *redis.IntCmd
+		if _, err := redisClient.Pipelined(func(pipe redis.Pipeliner) error {
+			dayCmd = pipe.Incr(redisMiddleware.Key(ctx, usageKey(id, day)))
+			pipe.Expire(redisMiddleware.Key(ctx, usageKey(id, day)), usageTTL)
+			monthCmd = pipe.Incr(redisMiddleware.Key(ctx, usageKey(id, month)))
+			pipe.Expire(redisMiddleware.Key(ctx, usageKey(id, month)), usageTTL)
+			pipe.SAdd(redisMiddleware.Key(ctx, PREFIX+".usage.dirty"), id+"|"+owner+"|"+day)
+			return nil
+		}); err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+
+		var limit, remaining int64
+		var reset time.Time
+		var over bool
+		if daily > 0 {
+			limit, remaining = daily, daily-dayCmd.Val()
+			reset = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, c.Location)
+			over = dayCmd.Val() > daily
+		}
+		// 每月的用完时  要到下个月才重置
+		if monthly > 0 && (limit == 0 || monthCmd.Val() > monthly || !over && monthly-monthCmd.Val() < remaining) {
+			limit, remaining = monthly, monthly-monthCmd.Val()
+			reset = time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, c.Location)
+			over = over || monthCmd.Val() > monthly
+		}
+		if limit == 0 {
+			ctx.Next()
+			return
+		}
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		// rate 中间件设置了更小的剩余时不覆盖
+		header := ctx.Writer.Header()
+		if val, err := strconv.ParseInt(header.Get("X-RateLimit-Remaining"), 10, 64); over || err != nil || remaining <= val {
+			header.Set("X-RateLimit-Limit", strconv.FormatInt(limit, 10))
+			header.Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+			header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		}
+		if over {
+			retry := time.Until(reset)
+			backpressure.RetryAfter(header, retry)
+			e := backpressure.Error(http.StatusTooManyRequests, "quota", retry)
+			e.Params["limit"] = limit
+			e.Params["reset"] = reset
+			ctx.Error(e)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// CurrentUsage redis 中当天和当月的计数  包括还没有写入 mongo 的
+func CurrentUsage(ctx *gin.Context, keyID bson.ObjectId, location *time.Location) (day int64, month int64, err error) {
+	if location == nil {
+		location = time.UTC
+	}
+	redisClient := ctx.MustGet(redisMiddleware.CONTEXT).(*redis.Client)
+	now := time.Now().In(location)
+	id := keyID.Hex()
+	values, err := redisClient.MGet(
+		redisMiddleware.Key(ctx, usageKey(id, now.Format("2006-01-02"))),
+		redisMiddleware.Key(ctx, usageKey(id, now.Format("2006-01"))),
+	).Result()
+	if err != nil {
+		return
+	}
+	if val, ok := values[0].(string); ok {
+		day, _ = strconv.ParseInt(val, 10, 64)
+	}
+	if val, ok := values[1].(string); ok {
+		month, _ = strconv.ParseInt(val, 10, 64)
+	}
+	return
+}
+
+// UsageOf 每天的用量  from to 为 2006-01-02  包括两端
+func UsageOf(ctx *gin.Context, keyID bson.ObjectId, from string, to string) (usages []*Usage, err error) {
+	err = mongoMiddleware.C(ctx, UsageModel.Name).Find(bson.M{
+		"key_id": keyID,
+		"day":    bson.M{"$gte": from, "$lte": to},
+	}).Sort("day").All(&usages)
+	return
+}
+
+// OwnerUsage 所有者全部 key 每天的用量
+func OwnerUsage(ctx *gin.Context, ownerID bson.ObjectId, from string, to string) (usages []*Usage, err error) {
+	err = mongoMiddleware.C(ctx, UsageModel.Name).Find(bson.M{
+		"owner": ownerID,
+		"day":   bson.M{"$gte": from, "$lte": to},
+	}).Sort("day", "key_id").All(&usages)
+	return
+}
+
+// UsageHandler 当前 key 的用量  ?from=2006-01-02&to=2006-01-02  默认最近 30 天
+func UsageHandler(c QuotaConfig) gin.HandlerFunc {
+	if c.Location == nil {
+		c.Location = time.UTC
+	}
+	return func(ctx *gin.Context) {
+		key := Get(ctx)
+		if key == nil {
+			ctx.Error(ErrRequired)
+			ctx.Abort()
+			return
+		}
+		now := time.Now().In(c.Location)
+		from := ctx.DefaultQuery("from", now.AddDate(0, 0, -30).Format("2006-01-02"))
+		to := ctx.DefaultQuery("to", now.Format("2006-01-02"))
+		usages, err := UsageOf(ctx, key.ID, from, to)
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		day, month, err := CurrentUsage(ctx, key.ID, c.Location)
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		daily := key.DailyQuota
+		if daily == 0 {
+			daily = c.DailyQuota
+		}
+		monthly := key.MonthlyQuota
+		if monthly == 0 {
+			monthly = c.MonthlyQuota
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"key_id":        key.ID,
+			"daily_quota":   daily,
+			"monthly_quota": monthly,
+			"day":           day,
+			"month":         month,
+			"usage":         usages,
+		})
+	}
+}
+
+func NewFlusher(c FlusherConfig) *Flusher {
+	if c.Client == nil {
+		panic("API key: redis client is empty")
+	}
+	if c.Session == nil {
+		panic("API key: mongo session is empty")
+	}
+	if c.Interval == 0 {
+		c.Interval = time.Minute
+	}
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	return &Flusher{
+		config: c,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (flusher *Flusher) Start() {
+	flusher.once.Do(func() {
+		go flusher.run()
+	})
+}
+
+// Stop 停止前写入一次
+func (flusher *Flusher) Stop() {
+	flusher.close.Do(func() {
+		close(flusher.stop)
+	})
+	flusher.once.Do(func() {
+		close(flusher.done)
+	})
+	<-flusher.done
+}
+
+func (flusher *Flusher) run() {
+	defer close(flusher.done)
+	ticker := time.NewTicker(flusher.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-flusher.stop:
+			flusher.Flush()
+			return
+		case <-ticker.C:
+			flusher.Flush()
+		}
+	}
+}
+
+// Flush redis 中的计数是当天的总数  直接覆盖  多个实例同时写入也没有问题
+func (flusher *Flusher) Flush() {
+	client := flusher.config.Client
+	prefix := flusher.config.KeyPrefix
+	dirty := prefix + PREFIX + ".usage.dirty"
+
+	session := flusher.config.Session()
+	defer session.Close()
+	collection := session.DB("").C(flusher.config.CollectionPrefix + UsageModel.Name)
+
+	for {
+		member, err := client.SPop(dirty).Result()
+		if err == redis.Nil {
+			return
+		}
+		if err != nil {
+			flusher.config.Logger.Errorf("[APIKEY] usage %s", err)
+			return
+		}
+		parts := strings.Split(member, "|")
+		if len(parts) != 3 || !bson.IsObjectIdHex(parts[0]) || len(parts[2]) < 7 {
+			continue
+		}
+		count, err := client.Get(prefix + usageKey(parts[0], parts[2])).Int64()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			client.SAdd(dirty, member)
+			flusher.config.Logger.Errorf("[APIKEY] usage %s %s", member, err)
+			return
+		}
+		update := bson.M{
+			"key_id":     bson.ObjectIdHex(parts[0]),
+			"day":        parts[2],
+			"month":      parts[2][:7],
+			"count":      count,
+			"updated_at": time.Now(),
+		}
+		if bson.IsObjectIdHex(parts[1]) {
+			update["owner"] = bson.ObjectIdHex(parts[1])
+		}
+		if _, err = collection.UpsertId(parts[0]+":"+parts[2], bson.M{"$set": update}); err != nil {
+			// 下次重试
+			client.SAdd(dirty, member)
+			flusher.config.Logger.Errorf("[APIKEY] usage %s %s", member, err)
+			return
+		}
+	}
+}
+
+func usageKey(id string, period string) string {
+	return PREFIX + ".usage." + id + "." + period
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/otamoe/gin-server/assets"
+	"github.com/otamoe/gin-server/static"
+)
+
+type (
+	// Assets 文件名带 hash  永久缓存  模板中使用 {{ asset "app.js" }}
+	Assets struct {
+		// 有 FS 时为 FS 中的目录  否则为磁盘目录
+		Root string `json:"root,omitempty"`
+
+		// url 前缀  默认 /assets
+		Prefix string `json:"prefix,omitempty"`
+
+		// 构建时生成的 manifest 在 Root 中的路径  为空或不存在时启动时计算
+		Manifest string `json:"manifest,omitempty"`
+	}
+)
+
+func (config *Assets) init(server *Server, handler *Handler) {
+	if config.Prefix == "" {
+		config.Prefix = "/assets"
+	}
+}
+
+func (config *Assets) manifest(fs http.FileSystem) *assets.Manifest {
+	if fs != nil {
+		fs = static.Sub(fs, config.Root)
+	} else {
+		fs = http.Dir(config.Root)
+	}
+	manifest, err := assets.New(assets.Config{
+		FS:       fs,
+		Prefix:   config.Prefix,
+		Manifest: config.Manifest,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return manifest
+}
@@ -0,0 +1,240 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/static"
+)
+
+type (
+	Config struct {
+		FS http.FileSystem
+
+		// url 前缀  默认 /assets
+		Prefix string
+
+		// 构建时生成的 manifest  {"app.js": "app.3f2a9c1b.js"}  为空或不存在时启动时计算
+		Manifest string
+
+		// hash 长度  默认 8
+		Length int
+	}
+
+	Manifest struct {
+		config Config
+
+		// 逻辑名 => hash 后的名字
+		names map[string]string
+
+		// hash 后的名字 => 文件
+		files map[string]string
+	}
+)
+
+var CONTEXT = "GIN.SERVER.ASSETS"
+
+// Immutable hash 后的文件内容不会变
+var Immutable = "public, max-age=31536000, immutable"
+
+func New(c Config) (*Manifest, error) {
+	if c.FS == nil {
+		panic("Assets: fs is empty")
+	}
+	if c.Prefix == "" {
+		c.Prefix = "/assets"
+	}
+	c.Prefix = "/" + strings.Trim(c.Prefix, "/")
+	if c.Length <= 0 || c.Length > sha256.Size*2 {
+		c.Length = 8
+	}
+	manifest := &Manifest{
+		config: c,
+		names:  map[string]string{},
+		files:  map[string]string{},
+	}
+
+	if c.Manifest != "" {
+		names, err := manifest.read(c.Manifest)
+		if err == nil {
+			for name, hashed := range names {
+				name, hashed = strings.TrimPrefix(name, "/"), strings.TrimPrefix(hashed, "/")
+				manifest.names[name] = hashed
+				manifest.files[hashed] = hashed
+			}
+			return manifest, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	names, err := manifest.walk("/")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		sum, err := manifest.hash(name)
+		if err != nil {
+			return nil, err
+		}
+		ext := path.Ext(name)
+		hashed := strings.TrimSuffix(name, ext) + "." + sum + ext
+		manifest.names[name] = hashed
+		manifest.files[hashed] = name
+	}
+	return manifest, nil
+}
+
+// Middleware 只响应 hash 后的名字  原来的名字交给下一个
+func Middleware(manifest *Manifest) gin.HandlerFunc {
+	handler := static.Middleware(static.Config{
+		FS:            manifest.FS(),
+		Prefix:        manifest.config.Prefix,
+		Precompressed: true,
+		CacheControl: map[string]string{
+			"": Immutable,
+		},
+	})
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, manifest)
+		handler(ctx)
+	}
+}
+
+func Get(ctx *gin.Context) *Manifest {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Manifest)
+	}
+	return nil
+}
+
+// Path 没有 middleware 时原样返回
+func Path(ctx *gin.Context, name string) string {
+	if manifest := Get(ctx); manifest != nil {
+		return manifest.Path(name)
+	}
+	return name
+}
+
+// Path 逻辑名的 url  不在 manifest 中时不带 hash
+func (manifest *Manifest) Path(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if hashed, ok := manifest.names[name]; ok {
+		name = hashed
+	}
+	return path.Join(manifest.config.Prefix, name)
+}
+
+func (manifest *Manifest) Names() map[string]string {
+	names := make(map[string]string, len(manifest.names))
+	for key, val := range manifest.names {
+		names[key] = val
+	}
+	return names
+}
+
+// Funcs 模板中 {{ asset "app.js" }}
+func (manifest *Manifest) Funcs() template.FuncMap {
+	return template.FuncMap{
+		"asset": manifest.Path,
+	}
+}
+
+// FS 只能打开 hash 后的名字  包括 .br .gz
+func (manifest *Manifest) FS() http.FileSystem {
+	return &manifestFS{manifest: manifest}
+}
+
+type manifestFS struct {
+	manifest *Manifest
+}
+
+func (fs *manifestFS) Open(name string) (http.File, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	var suffix string
+	for _, ext := range []string{".br", ".gz"} {
+		if strings.HasSuffix(name, ext) {
+			name, suffix = strings.TrimSuffix(name, ext), ext
+			break
+		}
+	}
+	file, ok := fs.manifest.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return fs.manifest.config.FS.Open("/" + file + suffix)
+}
+
+func (manifest *Manifest) read(name string) (names map[string]string, err error) {
+	var file http.File
+	if file, err = manifest.config.FS.Open(path.Clean("/" + name)); err != nil {
+		return
+	}
+	defer file.Close()
+	var data []byte
+	if data, err = ioutil.ReadAll(file); err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &names)
+	return
+}
+
+func (manifest *Manifest) hash(name string) (sum string, err error) {
+	var file http.File
+	if file, err = manifest.config.FS.Open("/" + name); err != nil {
+		return
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return
+	}
+	sum = hex.EncodeToString(h.Sum(nil))[:manifest.config.Length]
+	return
+}
+
+// walk 不包括 . 开头的和预压缩的文件
+func (manifest *Manifest) walk(dir string) (names []string, err error) {
+	var file http.File
+	if file, err = manifest.config.FS.Open(dir); err != nil {
+		return
+	}
+	defer file.Close()
+	infos, err := file.Readdir(-1)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		name := path.Join(dir, info.Name())
+		if info.IsDir() {
+			var children []string
+			if children, err = manifest.walk(name); err != nil {
+				return
+			}
+			names = append(names, children...)
+			continue
+		}
+		switch path.Ext(name) {
+		case ".br", ".gz":
+			continue
+		}
+		if manifest.config.Manifest != "" && name == path.Clean("/"+manifest.config.Manifest) {
+			continue
+		}
+		names = append(names, strings.TrimPrefix(name, "/"))
+	}
+	return
+}
@@ -0,0 +1,233 @@
+package audit
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/apikey"
+	"github.com/otamoe/gin-server/basicauth"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/jwt"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	ginResource "github.com/otamoe/gin-server/resource"
+	mgoModel "github.com/otamoe/mgo-model"
+)
+
+type (
+	Config struct {
+		// 路由名 type.action 或 type  为空时记录全部非 GET HEAD OPTIONS 请求
+		Names []string
+
+		// 记录的请求头
+		Headers []string
+
+		// body 截断长度
+		MaxBody int
+
+		Actor func(ctx *gin.Context) string
+
+		// 另外发送  例如 kafka
+		Sink func(audit *Audit)
+	}
+
+	Audit struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    bson.ObjectId     `json:"_id" bson:"_id"`
+		Method                string            `json:"method" bson:"method"`
+		Host                  string            `json:"host,omitempty" bson:"host,omitempty"`
+		Path                  string            `json:"path" bson:"path"`
+		Name                  string            `json:"name,omitempty" bson:"name,omitempty"`
+		Value                 string            `json:"value,omitempty" bson:"value,omitempty"`
+		Actor                 string            `json:"actor,omitempty" bson:"actor,omitempty"`
+		IP                    string            `json:"ip,omitempty" bson:"ip,omitempty"`
+		Headers               map[string]string `json:"headers,omitempty" bson:"headers,omitempty"`
+		RequestBody           string            `json:"request_body,omitempty" bson:"request_body,omitempty"`
+		ResponseBody          string            `json:"response_body,omitempty" bson:"response_body,omitempty"`
+		StatusCode            int               `json:"status_code" bson:"status_code"`
+		Latency               time.Duration     `json:"latency" bson:"latency"`
+		CreatedAt             *time.Time        `json:"created_at" bson:"created_at"`
+	}
+
+	auditWriter struct {
+		gin.ResponseWriter
+		body  bytes.Buffer
+		limit int
+	}
+)
+
+var CONTEXT = "GIN.SERVER.AUDIT"
+
+var Model = &mgoModel.Model{
+	Name:     "audits",
+	Document: &Audit{},
+	Indexs: []mgo.Index{
+		mgo.Index{
+			Key:        []string{"actor", "-created_at"},
+			Background: true,
+		},
+		mgo.Index{
+			Key:        []string{"name", "-created_at"},
+			Background: true,
+		},
+	},
+}
+
+// Setup 创建 capped 集合  或 ttl 索引  两者为 0 时不处理
+func Setup(session *mgo.Session, cappedBytes int, ttl time.Duration) (err error) {
+	collection := session.DB("").C(Model.Name)
+	if cappedBytes > 0 {
+		err = collection.Create(&mgo.CollectionInfo{
+			Capped:   true,
+			MaxBytes: cappedBytes,
+		})
+		// 已存在
+		if err != nil && strings.Contains(err.Error(), "already exists") {
+			err = nil
+		}
+		return
+	}
+	if ttl > 0 {
+		err = collection.EnsureIndex(mgo.Index{
+			Key:         []string{"created_at"},
+			ExpireAfter: ttl,
+			Background:  true,
+		})
+	}
+	return
+}
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.MaxBody == 0 {
+		c.MaxBody = 1024 * 4
+	}
+	if c.Actor == nil {
+		c.Actor = Actor
+	}
+	names := map[string]bool{}
+	for _, name := range c.Names {
+		names[name] = true
+	}
+
+	return func(ctx *gin.Context) {
+		name := ""
+		var resource *ginResource.Resource
+		if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+			resource = val.(*ginResource.Resource)
+			name = resource.Name()
+		}
+
+		if len(names) == 0 {
+			switch ctx.Request.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				ctx.Next()
+				return
+			}
+		} else if resource == nil || (!names[name] && !names[resource.Type]) {
+			ctx.Next()
+			return
+		}
+
+		now := time.Now()
+		audit := &Audit{
+			ID:        bson.NewObjectId(),
+			Method:    ctx.Request.Method,
+			Host:      ctx.Request.Host,
+			Path:      ctx.Request.URL.Path,
+			Name:      name,
+			IP:        clientip.ClientIP(ctx),
+			CreatedAt: &now,
+		}
+		for _, key := range c.Headers {
+			if val := ctx.GetHeader(key); val != "" {
+				if audit.Headers == nil {
+					audit.Headers = map[string]string{}
+				}
+				audit.Headers[http.CanonicalHeaderKey(key)] = val
+			}
+		}
+
+		// 请求 body 截断 原 body 不变
+		if ctx.Request.Body != nil {
+			body := ctx.Request.Body
+			data, _ := ioutil.ReadAll(io.LimitReader(body, int64(c.MaxBody)))
+			audit.RequestBody = string(data)
+			ctx.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(data), body), body}
+		}
+
+		writer := &auditWriter{
+			ResponseWriter: ctx.Writer,
+			limit:          c.MaxBody,
+		}
+		ctx.Writer = writer
+		ctx.Set(CONTEXT, audit)
+
+		ctx.Next()
+
+		audit.StatusCode = ctx.Writer.Status()
+		audit.Latency = time.Since(now)
+		audit.ResponseBody = writer.body.String()
+		audit.Actor = c.Actor(ctx)
+		if resource != nil {
+			// 认证之后再执行 Pre
+			resource.Pre()
+			audit.Value = resource.Value
+		}
+
+		if val, ok := ctx.Get(mongoMiddleware.CONTEXT); ok && val != nil {
+			if err := mongoMiddleware.C(ctx, Model.Name).Insert(audit); err != nil {
+				ctx.Error(err)
+			}
+		}
+		if c.Sink != nil {
+			c.Sink(audit)
+		}
+	}
+}
+
+// Actor 依次使用 jwt subject  api key owner  basic auth 用户名
+func Actor(ctx *gin.Context) string {
+	if claims := jwt.Get(ctx); claims != nil {
+		if sub := claims.Subject(); sub != "" {
+			return sub
+		}
+	}
+	if key := apikey.Get(ctx); key != nil {
+		if key.OwnerID != "" {
+			return key.OwnerID.Hex()
+		}
+		return "apikey:" + key.ID.Hex()
+	}
+	if val := ctx.GetString(basicauth.CONTEXT); val != "" {
+		return val
+	}
+	return ""
+}
+
+func (w *auditWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *auditWriter) capture(data []byte) {
+	if remaining := w.limit - w.body.Len(); remaining > 0 {
+		if len(data) > remaining {
+			data = data[:remaining]
+		}
+		w.body.Write(data)
+	}
+}
@@ -0,0 +1,345 @@
+package audittrail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/audit"
+	"github.com/otamoe/gin-server/clientip"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	ginResource "github.com/otamoe/gin-server/resource"
+	mgoModel "github.com/otamoe/mgo-model"
+)
+
+type (
+	Config struct {
+		// 路由名 type.action 或 type
+		Names []string
+
+		// 默认 audit.Actor
+		Actor func(ctx *gin.Context) string
+
+		// 失败的请求也记录
+		Failed bool
+	}
+
+	// Entry 只追加  Hash 为 sha256(PrevHash + 内容)  修改或删除会使之后的 hash 不连续
+	Entry struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    bson.ObjectId `json:"_id" bson:"_id"`
+		Seq                   int64         `json:"seq" bson:"seq"`
+		Actor                 string        `json:"actor,omitempty" bson:"actor,omitempty"`
+		Action                string        `json:"action" bson:"action"`
+		Targets               []string      `json:"targets,omitempty" bson:"targets,omitempty"`
+		Changes               []Change      `json:"changes,omitempty" bson:"changes,omitempty"`
+		Method                string        `json:"method" bson:"method"`
+		Path                  string        `json:"path" bson:"path"`
+		IP                    string        `json:"ip,omitempty" bson:"ip,omitempty"`
+		StatusCode            int           `json:"status_code" bson:"status_code"`
+		CreatedAt             time.Time     `json:"created_at" bson:"created_at"`
+		PrevHash              string        `json:"prev_hash" bson:"prev_hash"`
+		Hash                  string        `json:"hash" bson:"hash"`
+	}
+
+	// Change 值为 json  字段为 a.b.c
+	Change struct {
+		Field  string `json:"field" bson:"field"`
+		Before string `json:"before,omitempty" bson:"before,omitempty"`
+		After  string `json:"after,omitempty" bson:"after,omitempty"`
+	}
+
+	record struct {
+		targets []string
+		before  interface{}
+		after   interface{}
+	}
+)
+
+var CONTEXT = "GIN.SERVER.AUDITTRAIL"
+
+var Model = &mgoModel.Model{
+	Name:     "audit_trail",
+	Document: &Entry{},
+	Indexs: []mgo.Index{
+		mgo.Index{
+			Key:    []string{"seq"},
+			Unique: true,
+		},
+		mgo.Index{
+			Key:        []string{"actor", "-seq"},
+			Background: true,
+		},
+		mgo.Index{
+			Key:        []string{"targets", "-seq"},
+			Background: true,
+		},
+	},
+}
+
+var ErrConflict = errors.New("Audit trail: too many concurrent appends")
+
+// 并发追加时 seq 冲突的重试次数
+var maxRetries = 10
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.Actor == nil {
+		c.Actor = audit.Actor
+	}
+	names := map[string]bool{}
+	for _, name := range c.Names {
+		names[name] = true
+	}
+	return func(ctx *gin.Context) {
+		val, ok := ctx.Get(ginResource.CONTEXT)
+		if !ok || val == nil {
+			ctx.Next()
+			return
+		}
+		resource := val.(*ginResource.Resource)
+		name := resource.Name()
+		if !names[name] && !names[resource.Type] {
+			ctx.Next()
+			return
+		}
+
+		r := &record{}
+		ctx.Set(CONTEXT, r)
+		ctx.Next()
+
+		statusCode := ctx.Writer.Status()
+		if statusCode >= 400 && !c.Failed {
+			return
+		}
+		if val, ok := ctx.Get(mongoMiddleware.CONTEXT); !ok || val == nil {
+			return
+		}
+		targets := r.targets
+		// ValueKeys 依赖认证中间件  handler 之后解析
+		resource.Pre()
+		if len(targets) == 0 && resource.Value != "" {
+			targets = []string{resource.Value}
+		}
+		changes, err := Diff(r.before, r.after)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		if _, err := Append(mongoMiddleware.C(ctx, Model.Name), &Entry{
+			Actor:      c.Actor(ctx),
+			Action:     name,
+			Targets:    targets,
+			Changes:    changes,
+			Method:     ctx.Request.Method,
+			Path:       ctx.Request.URL.Path,
+			IP:         clientip.ClientIP(ctx),
+			StatusCode: statusCode,
+		}); err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// Target 修改的文档 id  默认为路由的 resource.Value
+func Target(ctx *gin.Context, ids ...string) {
+	if r := get(ctx); r != nil {
+		r.targets = append(r.targets, ids...)
+	}
+}
+
+// Before 修改前的文档
+func Before(ctx *gin.Context, doc interface{}) {
+	if r := get(ctx); r != nil {
+		r.before = doc
+	}
+}
+
+// After 修改后的文档
+func After(ctx *gin.Context, doc interface{}) {
+	if r := get(ctx); r != nil {
+		r.after = doc
+	}
+}
+
+func get(ctx *gin.Context) *record {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*record)
+	}
+	return nil
+}
+
+// Append 接在最后一条之后  seq 唯一索引保证链不分叉
+func Append(collection *mgo.Collection, entry *Entry) (*Entry, error) {
+	for i := 0; i < maxRetries; i++ {
+		last := &Entry{}
+		if err := collection.Find(nil).Sort("-seq").One(last); err != nil {
+			if err != mgo.ErrNotFound {
+				return nil, err
+			}
+			last = nil
+		}
+		entry.ID = bson.NewObjectId()
+		// mongo 只保存到毫秒
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+		entry.Seq = 1
+		entry.PrevHash = ""
+		if last != nil {
+			entry.Seq = last.Seq + 1
+			entry.PrevHash = last.Hash
+		}
+		entry.Hash = entry.hash()
+		err := collection.Insert(entry)
+		if err == nil {
+			return entry, nil
+		}
+		if !mgo.IsDup(err) {
+			return nil, err
+		}
+	}
+	return nil, ErrConflict
+}
+
+// Verify 从 seq 开始检查  返回第一条不连续的 seq  0 为完整
+func Verify(collection *mgo.Collection, seq int64) (int64, error) {
+	var prev string
+	if seq > 1 {
+		last := &Entry{}
+		if err := collection.Find(bson.M{"seq": seq - 1}).One(last); err != nil {
+			return 0, err
+		}
+		prev = last.Hash
+	} else {
+		seq = 1
+	}
+	iter := collection.Find(bson.M{"seq": bson.M{"$gte": seq}}).Sort("seq").Iter()
+	entry := &Entry{}
+	expected := seq
+	for iter.Next(entry) {
+		if entry.Seq != expected || entry.PrevHash != prev || entry.hash() != entry.Hash {
+			iter.Close()
+			return entry.Seq, nil
+		}
+		prev = entry.Hash
+		expected++
+		entry = &Entry{}
+	}
+	return 0, iter.Close()
+}
+
+func (entry *Entry) hash() string {
+	data, _ := json.Marshal([]interface{}{
+		entry.Seq,
+		entry.Actor,
+		entry.Action,
+		entry.Targets,
+		entry.Changes,
+		entry.Method,
+		entry.Path,
+		entry.IP,
+		entry.StatusCode,
+		entry.CreatedAt.UnixNano(),
+		entry.PrevHash,
+	})
+	sum := sha256.Sum256(append([]byte(entry.PrevHash), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// Diff 按 json 比较  嵌套字段展开为 a.b.c
+func Diff(before interface{}, after interface{}) (changes []Change, err error) {
+	if before == nil && after == nil {
+		return
+	}
+	a := map[string]interface{}{}
+	b := map[string]interface{}{}
+	if err = flatten(before, a); err != nil {
+		return
+	}
+	if err = flatten(after, b); err != nil {
+		return
+	}
+	fields := map[string]bool{}
+	for field := range a {
+		fields[field] = true
+	}
+	for field := range b {
+		fields[field] = true
+	}
+	var names []string
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	for _, field := range names {
+		va, oka := a[field]
+		vb, okb := b[field]
+		if oka && okb && reflect.DeepEqual(va, vb) {
+			continue
+		}
+		change := Change{Field: field}
+		if oka {
+			data, _ := json.Marshal(va)
+			change.Before = string(data)
+		}
+		if okb {
+			data, _ := json.Marshal(vb)
+			change.After = string(data)
+		}
+		changes = append(changes, change)
+	}
+	return
+}
+
+func flatten(doc interface{}, fields map[string]interface{}) error {
+	if doc == nil {
+		return nil
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	var val interface{}
+	if err = json.Unmarshal(data, &val); err != nil {
+		return err
+	}
+	walk("", val, fields)
+	return nil
+}
+
+func walk(prefix string, val interface{}, fields map[string]interface{}) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 && prefix != "" {
+			fields[prefix] = v
+		}
+		for key, item := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			walk(key, item, fields)
+		}
+	case []interface{}:
+		if len(v) == 0 && prefix != "" {
+			fields[prefix] = v
+		}
+		for i, item := range v {
+			key := strconv.Itoa(i)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			walk(key, item, fields)
+		}
+	default:
+		if prefix == "" {
+			prefix = "value"
+		}
+		fields[prefix] = v
+	}
+}
@@ -0,0 +1,210 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type (
+	// Params 修改后旧的 hash 在登录成功时重新生成
+	Params struct {
+		// argon2id 或 bcrypt  默认 argon2id
+		Algorithm string
+
+		BcryptCost int
+
+		// argon2id  Memory 单位 KiB
+		Memory      uint32
+		Iterations  uint32
+		Parallelism uint8
+		SaltLength  uint32
+		KeyLength   uint32
+	}
+
+	Hasher struct {
+		params Params
+	}
+)
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+var (
+	ErrAlgorithm = errors.New("Password: unknown algorithm")
+	ErrHash      = errors.New("Password: invalid hash")
+	ErrTooLong   = errors.New("Password: bcrypt password longer than 72 bytes")
+)
+
+var Default = New(Params{})
+
+func New(p Params) *Hasher {
+	if p.Algorithm == "" {
+		p.Algorithm = Argon2id
+	}
+	if p.Algorithm != Argon2id && p.Algorithm != Bcrypt {
+		panic(ErrAlgorithm)
+	}
+	if p.BcryptCost == 0 {
+		p.BcryptCost = 12
+	}
+	if p.Memory == 0 {
+		p.Memory = 64 * 1024
+	}
+	if p.Iterations == 0 {
+		p.Iterations = 3
+	}
+	if p.Parallelism == 0 {
+		p.Parallelism = 2
+	}
+	if p.SaltLength == 0 {
+		p.SaltLength = 16
+	}
+	if p.KeyLength == 0 {
+		p.KeyLength = 32
+	}
+	return &Hasher{
+		params: p,
+	}
+}
+
+// Hash 使用 Default
+func Hash(password string) (string, error) {
+	return Default.Hash(password)
+}
+
+// Verify 使用 Default
+func Verify(password string, encoded string) (ok bool, rehash bool, err error) {
+	return Default.Verify(password, encoded)
+}
+
+// Hash argon2id 为 PHC 格式 $argon2id$v=19$m=65536,t=3,p=2$salt$key  bcrypt 为 $2a$cost$
+func (hasher *Hasher) Hash(password string) (string, error) {
+	p := hasher.params
+	if p.Algorithm == Bcrypt {
+		if len(password) > 72 {
+			return "", ErrTooLong
+		}
+		data, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		p.Memory,
+		p.Iterations,
+		p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify rehash 为 true 时  算法或参数和当前的不同  需要用 Hash 重新生成并保存
+func (hasher *Hasher) Verify(password string, encoded string) (ok bool, rehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		var p Params
+		var salt, key []byte
+		if p, salt, key, err = decodeArgon2id(encoded); err != nil {
+			return
+		}
+		actual := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+		ok = subtle.ConstantTimeCompare(actual, key) == 1
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		if err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); err != nil {
+			if err == bcrypt.ErrMismatchedHashAndPassword {
+				err = nil
+			}
+			return
+		}
+		ok = true
+	default:
+		err = ErrAlgorithm
+		return
+	}
+	if ok {
+		rehash = hasher.NeedsRehash(encoded)
+	}
+	return
+}
+
+// NeedsRehash 算法或参数和当前的不同
+func (hasher *Hasher) NeedsRehash(encoded string) bool {
+	p := hasher.params
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		if p.Algorithm != Argon2id {
+			return true
+		}
+		val, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return true
+		}
+		return val.Memory != p.Memory || val.Iterations != p.Iterations || val.Parallelism != p.Parallelism || uint32(len(salt)) != p.SaltLength || uint32(len(key)) != p.KeyLength
+	case strings.HasPrefix(encoded, "$2"):
+		if p.Algorithm != Bcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != p.BcryptCost
+	}
+	return true
+}
+
+// Upgrade 验证成功并且需要时  用 save 保存新的 hash  保存失败不影响登录
+func (hasher *Hasher) Upgrade(password string, encoded string, save func(encoded string) error) (ok bool, err error) {
+	var rehash bool
+	if ok, rehash, err = hasher.Verify(password, encoded); !ok || !rehash || save == nil {
+		return
+	}
+	if encoded, err = hasher.Hash(password); err != nil {
+		err = nil
+		return
+	}
+	save(encoded)
+	return
+}
+
+func decodeArgon2id(encoded string) (p Params, salt []byte, key []byte, err error) {
+	// "" argon2id v=19 m=65536,t=3,p=2 salt key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		err = ErrHash
+		return
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		err = ErrHash
+		return
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		err = ErrHash
+		return
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		err = ErrHash
+		return
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		err = ErrHash
+		return
+	}
+	p.Algorithm = Argon2id
+	return
+}
@@ -0,0 +1,78 @@
+package backpressure
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+)
+
+// DefaultRetry 不知道什么时候恢复时使用  例如关闭 维护
+var DefaultRetry = time.Second * 5
+
+// MaxSeconds 超过时 Retry-After 使用 HTTP-date
+var MaxSeconds = time.Hour * 24
+
+// RetryAfter 设置 Retry-After  向上取整到秒  最少 1 秒
+func RetryAfter(header http.Header, retry time.Duration) {
+	if retry > MaxSeconds {
+		header.Set("Retry-After", time.Now().Add(retry).UTC().Format(http.TimeFormat))
+		return
+	}
+	header.Set("Retry-After", strconv.FormatInt(Seconds(retry), 10))
+}
+
+func Seconds(retry time.Duration) int64 {
+	seconds := int64((retry + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// Error 429 503 统一的响应  params 包括 retry_after
+func Error(statusCode int, typ string, retry time.Duration) *errs.Error {
+	return &errs.Error{
+		Message:    http.StatusText(statusCode),
+		Type:       typ,
+		StatusCode: statusCode,
+		Params: map[string]interface{}{
+			"retry_after": Seconds(retry),
+		},
+	}
+}
+
+// Abort gin 中间件使用  err 会被复制
+func Abort(ctx *gin.Context, err *errs.Error, retry time.Duration) {
+	err = withRetry(err, retry)
+	RetryAfter(ctx.Writer.Header(), retry)
+	ctx.Error(err)
+	ctx.Abort()
+}
+
+// Write 不经过 gin 时使用  例如 readyz 关闭时  响应和 errs json 格式相同
+func Write(writer http.ResponseWriter, req *http.Request, err *errs.Error, retry time.Duration) {
+	err = withRetry(err, retry)
+	RetryAfter(writer.Header(), retry)
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(err.StatusCode)
+	if req.Method != http.MethodHead {
+		json.NewEncoder(writer).Encode(&errs.Errors{
+			Errors:     []*errs.Error{err},
+			StatusCode: err.StatusCode,
+		})
+	}
+}
+
+func withRetry(err *errs.Error, retry time.Duration) *errs.Error {
+	err = err.Clone()
+	if err.Params == nil {
+		err.Params = map[string]interface{}{}
+	}
+	err.Params["retry_after"] = Seconds(retry)
+	return err
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/globalsign/mgo"
+	"github.com/otamoe/gin-server/backup"
+	"github.com/otamoe/gin-server/uploads"
+)
+
+type (
+	// Backup 导出集合到本地目录  Storage 设置后代替 Dir
+	Backup struct {
+		Dir         string   `json:"dir,omitempty"`
+		Collections []string `json:"collections,omitempty"`
+		Format      string   `json:"format,omitempty"`
+		Collection  string   `json:"collection,omitempty"`
+
+		Storage uploads.Storage `json:"-"`
+	}
+)
+
+func (config *Backup) init(server *Server, handler *Handler) {
+	if config.Format == "" {
+		config.Format = "bson"
+	}
+	if config.Collection == "" {
+		config.Collection = backup.Model.Name
+	}
+	if config.Storage == nil {
+		config.Storage = &uploads.Local{Dir: config.Dir}
+	}
+}
+
+// Config 用于 backup.Handler  记录集合的前缀由 mongo 中间件添加
+func (config *Backup) Config() backup.Config {
+	return backup.Config{
+		Storage:     config.Storage,
+		Collections: config.Collections,
+		Format:      config.Format,
+		Collection:  config.Collection,
+	}
+}
+
+func (server *Server) backup(run func(c backup.Config, db *mgo.Database) error) error {
+	provider := server.mongoProvider()
+	if provider == nil {
+		return errors.New("Backup: mongo is empty")
+	}
+	if server.Backup == nil {
+		return errors.New("Backup: config is empty")
+	}
+	c := server.Backup.Config()
+	c.Collection = server.mongoPrefix() + c.Collection
+	session, err := server.mongoSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return run(c, session.DB(""))
+}
+
+// ExportBackup collections 为空时使用配置的 Collections 或全部
+func (server *Server) ExportBackup(collections []string) (result *backup.Backup, err error) {
+	err = server.backup(func(c backup.Config, db *mgo.Database) (err error) {
+		result, err = backup.Export(c, db, collections)
+		return
+	})
+	return
+}
+
+// RestoreBackup drop 为 true 时先清空集合
+func (server *Server) RestoreBackup(id string, drop bool) error {
+	return server.backup(func(c backup.Config, db *mgo.Database) error {
+		result, err := backup.Find(c, db, id)
+		if err != nil {
+			return err
+		}
+		return backup.Restore(c, db, result, drop)
+	})
+}
+
+func (server *Server) ListBackups(limit int) (backups []*backup.Backup, err error) {
+	err = server.backup(func(c backup.Config, db *mgo.Database) (err error) {
+		backups, err = backup.List(c, db, limit)
+		return
+	})
+	return
+}
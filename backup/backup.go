@@ -0,0 +1,351 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/errs"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	"github.com/otamoe/gin-server/uploads"
+	mgoModel "github.com/otamoe/mgo-model"
+)
+
+type (
+	// Config 小型部署使用  大的数据库用 mongodump
+	Config struct {
+		Storage uploads.Storage
+
+		// 可导出的集合  为空时全部  不包括 system. 和 backups
+		Collections []string
+
+		// bson 或 json  默认 bson
+		Format string
+
+		// 备份记录  默认 backups
+		Collection string
+	}
+
+	Backup struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    bson.ObjectId   `json:"_id" bson:"_id"`
+		Format                string          `json:"format" bson:"format"`
+		Files                 []*uploads.File `json:"files" bson:"files"`
+		CreatedAt             time.Time       `json:"created_at" bson:"created_at"`
+	}
+)
+
+var Model = &mgoModel.Model{
+	Name:     "backups",
+	Document: &Backup{},
+}
+
+var ErrNotFound = &errs.Error{
+	Message:    "Backup not found",
+	Type:       "backup",
+	StatusCode: http.StatusNotFound,
+}
+
+var ErrCollection = &errs.Error{
+	Message:    "Collection is not allowed",
+	Type:       "backup",
+	StatusCode: http.StatusBadRequest,
+}
+
+const batchSize = 1000
+
+// Export 每个集合一个文件保存到 Storage  记录保存到 backups
+func Export(c Config, db *mgo.Database, collections []string) (backup *Backup, err error) {
+	if collections, err = c.collections(db, collections); err != nil {
+		return
+	}
+	backup = &Backup{
+		ID:        bson.NewObjectId(),
+		Format:    c.format(),
+		CreatedAt: time.Now(),
+	}
+	for _, name := range collections {
+		var file *uploads.File
+		if file, err = c.export(db.C(name), backup.Format); err != nil {
+			return
+		}
+		backup.Files = append(backup.Files, file)
+	}
+	err = db.C(c.collection()).Insert(backup)
+	return
+}
+
+func (c Config) export(collection *mgo.Collection, format string) (file *uploads.File, err error) {
+	now := time.Now()
+	file = &uploads.File{
+		ID:          bson.NewObjectId(),
+		Storage:     c.Storage.Name(),
+		Field:       collection.Name,
+		Name:        collection.Name + "." + format,
+		ContentType: "application/octet-stream",
+		CreatedAt:   &now,
+	}
+	if format == "json" {
+		file.ContentType = "application/x-ndjson"
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(dump(collection, format, writer))
+	}()
+	counter := &countReader{reader: reader}
+	err = c.Storage.Save(file, counter)
+	reader.Close()
+	file.Size = counter.size
+	return
+}
+
+// dump bson 和 mongodump 相同  json 每行一个文档
+func dump(collection *mgo.Collection, format string, writer io.Writer) error {
+	buffer := bufio.NewWriter(writer)
+	iter := collection.Find(nil).Sort("_id").Batch(batchSize).Iter()
+	var raw bson.Raw
+	for iter.Next(&raw) {
+		if format == "json" {
+			var doc bson.M
+			if err := raw.Unmarshal(&doc); err != nil {
+				iter.Close()
+				return err
+			}
+			data, err := bson.MarshalJSON(doc)
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			buffer.Write(data)
+			buffer.WriteByte('\n')
+			continue
+		}
+		buffer.Write(raw.Data)
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	return buffer.Flush()
+}
+
+// Restore drop 为 true 时先清空集合
+func Restore(c Config, db *mgo.Database, backup *Backup, drop bool) error {
+	for _, file := range backup.Files {
+		if !c.allowed(file.Field) {
+			return ErrCollection
+		}
+	}
+	for _, file := range backup.Files {
+		collection := db.C(file.Field)
+		if drop {
+			if _, err := collection.RemoveAll(nil); err != nil {
+				return err
+			}
+		}
+		reader, err := c.Storage.Open(file)
+		if err != nil {
+			return err
+		}
+		err = load(collection, backup.Format, reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func load(collection *mgo.Collection, format string, reader io.Reader) error {
+	buffer := bufio.NewReader(reader)
+	var docs []interface{}
+	flush := func() error {
+		if len(docs) == 0 {
+			return nil
+		}
+		err := collection.Insert(docs...)
+		docs = docs[:0]
+		return err
+	}
+	for {
+		var doc interface{}
+		if format == "json" {
+			line, err := buffer.ReadBytes('\n')
+			if len(strings.TrimSpace(string(line))) != 0 {
+				var val bson.M
+				if e := bson.UnmarshalJSON(line, &val); e != nil {
+					return e
+				}
+				doc = val
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			head := make([]byte, 4)
+			if _, err := io.ReadFull(buffer, head); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			size := int(binary.LittleEndian.Uint32(head))
+			if size < 5 {
+				return errors.New("Backup: invalid bson document")
+			}
+			data := make([]byte, size)
+			copy(data, head)
+			if _, err := io.ReadFull(buffer, data[4:]); err != nil {
+				return err
+			}
+			doc = bson.Raw{Kind: 0x03, Data: data}
+		}
+		if doc == nil {
+			continue
+		}
+		if docs = append(docs, doc); len(docs) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+func (c Config) collection() string {
+	if c.Collection == "" {
+		return Model.Name
+	}
+	return c.Collection
+}
+
+func (c Config) format() string {
+	if c.Format == "json" {
+		return "json"
+	}
+	return "bson"
+}
+
+// collections 为空时使用 Collections 或全部
+func (c Config) collections(db *mgo.Database, names []string) ([]string, error) {
+	if len(names) != 0 {
+		for _, name := range names {
+			if !c.allowed(name) {
+				return nil, ErrCollection
+			}
+		}
+		return names, nil
+	}
+	if len(c.Collections) != 0 {
+		return c.Collections, nil
+	}
+	all, err := db.CollectionNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range all {
+		if c.allowed(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (c Config) allowed(name string) bool {
+	if name == "" || strings.HasPrefix(name, "system.") || name == c.collection() {
+		return false
+	}
+	if len(c.Collections) == 0 {
+		return true
+	}
+	for _, val := range c.Collections {
+		if val == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler 挂载在需要管理员权限的路由组  POST 导出  GET 列表  POST :id/restore 恢复
+func Handler(group gin.IRoutes, config Config) {
+	// 记录使用当前请求的集合前缀
+	withPrefix := func(ctx *gin.Context) Config {
+		c := config
+		c.Collection = mongoMiddleware.Collection(ctx, config.collection())
+		return c
+	}
+	group.POST("", func(ctx *gin.Context) {
+		c := withPrefix(ctx)
+		var collections []string
+		if val := ctx.Query("collections"); val != "" {
+			collections = strings.Split(val, ",")
+		}
+		backup, err := Export(c, mongoMiddleware.DB(ctx), collections)
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		ctx.JSON(http.StatusCreated, backup)
+	})
+	group.GET("", func(ctx *gin.Context) {
+		backups, err := List(withPrefix(ctx), mongoMiddleware.DB(ctx), 100)
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		ctx.JSON(http.StatusOK, backups)
+	})
+	group.POST("/:id/restore", func(ctx *gin.Context) {
+		c := withPrefix(ctx)
+		db := mongoMiddleware.DB(ctx)
+		backup, err := Find(c, db, ctx.Param("id"))
+		if err == nil {
+			err = Restore(c, db, backup, ctx.Query("drop") == "true")
+		}
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		ctx.Status(http.StatusNoContent)
+	})
+}
+
+// List 最新的在前
+func List(c Config, db *mgo.Database, limit int) (backups []*Backup, err error) {
+	err = db.C(c.collection()).Find(nil).Sort("-created_at").Limit(limit).All(&backups)
+	return
+}
+
+func Find(c Config, db *mgo.Database, id string) (backup *Backup, err error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrNotFound
+	}
+	backup = &Backup{}
+	if err = db.C(c.collection()).FindId(bson.ObjectIdHex(id)).One(backup); err == mgo.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return
+}
+
+type countReader struct {
+	reader io.Reader
+	size   int64
+}
+
+func (r *countReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.size += int64(n)
+	return
+}
@@ -0,0 +1,297 @@
+package basicauth
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/loginguard"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+)
+
+type (
+	Config struct {
+		Realm string
+
+		// username => password
+		Users map[string]string
+
+		// Digest 认证 否则 Basic
+		Digest bool
+
+		// 连续失败 Attempts 次后锁定 Lockout  需要 redis 中间件
+		Attempts int64
+		Lockout  time.Duration
+
+		// 按用户名和 IP 限制  设置后不使用 Attempts
+		Guard *loginguard.Guard
+	}
+
+	// nonceCounts 没有 redis 中间件时 nonce => 最大的 nc
+	nonceCounts struct {
+		mutex  sync.Mutex
+		counts map[string]nonceCount
+		swept  time.Time
+	}
+
+	nonceCount struct {
+		nc      uint64
+		expires time.Time
+	}
+)
+
+var CONTEXT = "GIN.SERVER.BASICAUTH"
+
+var PREFIX = "basicauth"
+
+// nonce 有效时间
+var nonceTTL = time.Minute * 5
+
+// ncScript nc 必须大于同一个 nonce 之前的
+var ncScript = `local last = tonumber(redis.call("get", KEYS[1]) or "0") if tonumber(ARGV[1]) <= last then return 0 end redis.call("set", KEYS[1], ARGV[1], "px", ARGV[2]) return 1`
+
+var ErrLocked = &errs.Error{
+	Message:    "Too many failed attempts",
+	Type:       "basicauth",
+	StatusCode: http.StatusTooManyRequests,
+}
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.Realm == "" {
+		c.Realm = "Authorization Required"
+	}
+	if c.Lockout == 0 {
+		c.Lockout = time.Minute * 15
+	}
+
+	// nonce 签名
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	// opaque 不能暴露 secret
+	opaque := signNonce(secret, "opaque")[:32]
+	counts := &nonceCounts{counts: map[string]nonceCount{}}
+
+	return func(ctx *gin.Context) {
+		var redisClient *redis.Client
+		var lockKey string
+		var account string
+		if c.Guard != nil {
+			account = c.account(ctx)
+			if err := c.Guard.Check(ctx, account); err != nil {
+				ctx.Error(err)
+				ctx.Abort()
+				return
+			}
+		} else if c.Attempts > 0 {
+			if val, ok := ctx.Get(redisMiddleware.CONTEXT); ok && val != nil {
+				redisClient = val.(*redis.Client)
+				lockKey = redisMiddleware.Key(ctx, PREFIX+"."+base64.StdEncoding.EncodeToString([]byte(clientip.ClientIP(ctx))))
+				if count, _ := redisClient.Get(lockKey).Int64(); count >= c.Attempts {
+					ctx.Error(ErrLocked)
+					ctx.Abort()
+					return
+				}
+			}
+		}
+
+		var username string
+		var ok bool
+		if c.Digest {
+			username, ok = c.digest(ctx, secret, opaque, counts)
+		} else {
+			username, ok = c.basic(ctx)
+		}
+
+		if !ok {
+			if c.Guard != nil && ctx.GetHeader("Authorization") != "" {
+				c.Guard.Fail(ctx, account)
+			}
+			if redisClient != nil && ctx.GetHeader("Authorization") != "" {
+				redisClient.Pipelined(func(pipe redis.Pipeliner) error {
+					pipe.Incr(lockKey)
+					pipe.Expire(lockKey, c.Lockout)
+					return nil
+				})
+			}
+			if c.Digest {
+				nonce := newNonce(secret)
+				ctx.Header("WWW-Authenticate", fmt.Sprintf(`Digest realm=%q, qop="auth", algorithm=MD5, nonce=%q, opaque=%q`, c.Realm, nonce, opaque))
+			} else {
+				ctx.Header("WWW-Authenticate", "Basic realm="+strconv.Quote(c.Realm))
+			}
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		if redisClient != nil {
+			redisClient.Del(lockKey)
+		}
+		if c.Guard != nil {
+			c.Guard.Success(ctx, username)
+		}
+		ctx.Set(CONTEXT, username)
+		ctx.Next()
+	}
+}
+
+// account 认证之前的用户名
+func (c Config) account(ctx *gin.Context) string {
+	if c.Digest {
+		auth := ctx.GetHeader("Authorization")
+		if !strings.HasPrefix(auth, "Digest ") {
+			return ""
+		}
+		return parseDigest(auth[7:])["username"]
+	}
+	username, _, _ := ctx.Request.BasicAuth()
+	return username
+}
+
+func (c Config) basic(ctx *gin.Context) (username string, ok bool) {
+	var password string
+	if username, password, ok = ctx.Request.BasicAuth(); !ok {
+		return
+	}
+	ok = false
+	// 用户不存在也比较一次 避免时间差
+	expected, exists := c.Users[username]
+	if subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1 && exists {
+		ok = true
+	}
+	return
+}
+
+// digest uri 必须是当前请求  同一个 nonce 的 nc 必须递增  防止重放
+func (c Config) digest(ctx *gin.Context, secret []byte, opaque string, counts *nonceCounts) (username string, ok bool) {
+	auth := ctx.GetHeader("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		return
+	}
+	params := parseDigest(auth[7:])
+	username = params["username"]
+	password, exists := c.Users[username]
+	if !exists || params["realm"] != c.Realm || !validNonce(secret, params["nonce"]) {
+		return
+	}
+	if params["qop"] != "auth" || params["opaque"] != opaque || params["uri"] != ctx.Request.RequestURI {
+		return
+	}
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil || nc == 0 {
+		return
+	}
+
+	ha1 := md5Hex(username + ":" + c.Realm + ":" + password)
+	ha2 := md5Hex(ctx.Request.Method + ":" + params["uri"])
+	response := md5Hex(strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], ha2}, ":"))
+	if subtle.ConstantTimeCompare([]byte(response), []byte(params["response"])) != 1 {
+		return
+	}
+	ok = useNonce(ctx, counts, params["nonce"], nc)
+	return
+}
+
+// useNonce 记录 nonce 的 nc  重复或者没有递增时返回 false  有 redis 中间件时多个实例共享
+func useNonce(ctx *gin.Context, counts *nonceCounts, nonce string, nc uint64) bool {
+	if val, ok := ctx.Get(redisMiddleware.CONTEXT); ok && val != nil {
+		key := redisMiddleware.Key(ctx, PREFIX+".nc."+nonce)
+		n, err := val.(*redis.Client).Eval(ncScript, []string{key}, nc, int64(nonceTTL/time.Millisecond)).Int64()
+		return err == nil && n == 1
+	}
+	return counts.use(nonce, nc)
+}
+
+func (counts *nonceCounts) use(nonce string, nc uint64) bool {
+	counts.mutex.Lock()
+	defer counts.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(counts.swept) > time.Minute {
+		for key, count := range counts.counts {
+			if now.After(count.expires) {
+				delete(counts.counts, key)
+			}
+		}
+		counts.swept = now
+	}
+	if count, ok := counts.counts[nonce]; ok && nc <= count.nc {
+		return false
+	}
+	counts.counts[nonce] = nonceCount{nc: nc, expires: now.Add(nonceTTL)}
+	return true
+}
+
+// newNonce 时间戳 + 签名  5 分钟有效
+func newNonce(secret []byte) string {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	return timestamp + ":" + signNonce(secret, timestamp)
+}
+
+func validNonce(secret []byte, nonce string) bool {
+	index := strings.Index(nonce, ":")
+	if index == -1 {
+		return false
+	}
+	timestamp, err := strconv.ParseInt(nonce[:index], 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > nonceTTL {
+		return false
+	}
+	return hmac.Equal([]byte(nonce[index+1:]), []byte(signNonce(secret, nonce[:index])))
+}
+
+func signNonce(secret []byte, value string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func parseDigest(value string) map[string]string {
+	params := map[string]string{}
+	for _, part := range splitDigest(value) {
+		index := strings.Index(part, "=")
+		if index == -1 {
+			continue
+		}
+		params[strings.TrimSpace(part[:index])] = strings.Trim(strings.TrimSpace(part[index+1:]), `"`)
+	}
+	return params
+}
+
+// splitDigest 按逗号分割 忽略引号中的逗号
+func splitDigest(value string) (parts []string) {
+	var quoted bool
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				parts = append(parts, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, value[start:])
+	return
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,60 @@
+package basicauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDigest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Middleware(Config{
+		Realm:  "test",
+		Users:  map[string]string{"user": "password"},
+		Digest: true,
+	}))
+	engine.GET("/*path", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/a", nil))
+	challenge := parseDigest(strings.TrimPrefix(recorder.Header().Get("WWW-Authenticate"), "Digest "))
+	if challenge["nonce"] == "" || challenge["opaque"] == "" {
+		t.Fatalf("challenge %v", challenge)
+	}
+
+	authorization := func(uri string, nc string) string {
+		ha1 := md5Hex("user:test:password")
+		ha2 := md5Hex(http.MethodGet + ":" + uri)
+		response := md5Hex(strings.Join([]string{ha1, challenge["nonce"], nc, "cnonce", "auth", ha2}, ":"))
+		return fmt.Sprintf(`Digest username="user", realm="test", nonce=%q, uri=%q, qop=auth, nc=%s, cnonce="cnonce", response=%q, opaque=%q`, challenge["nonce"], uri, nc, response, challenge["opaque"])
+	}
+	tests := []struct {
+		name   string
+		path   string
+		uri    string
+		nc     string
+		status int
+	}{
+		{"first", "/a", "/a", "00000001", http.StatusOK},
+		{"replay", "/a", "/a", "00000001", http.StatusUnauthorized},
+		{"other path", "/b", "/a", "00000002", http.StatusUnauthorized},
+		{"next", "/b", "/b", "00000003", http.StatusOK},
+		{"lower nc", "/b", "/b", "00000002", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		req.Header.Set("Authorization", authorization(test.uri, test.nc))
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, recorder.Code, test.status)
+		}
+	}
+}
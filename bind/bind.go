@@ -4,10 +4,18 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/otamoe/gin-server/errs"
+	validator9 "gopkg.in/go-playground/validator.v9"
 )
 
 type (
 	DataFunc func(ctx *gin.Context) interface{}
+
+	// Validator 绑定后执行的自定义规则
+	Validator interface {
+		Validate() error
+	}
 )
 
 var CONTEXT = "GIN.SERVER.BIND"
@@ -50,3 +58,84 @@ func MiddlewareJSON(dataFunc DataFunc) gin.HandlerFunc {
 		ctx.Next()
 	}
 }
+
+// Bind 根据 Content-Type 绑定 失败时返回 false 并写入 422
+func Bind(ctx *gin.Context, data interface{}) bool {
+	return With(ctx, data, binding.Default(ctx.Request.Method, ctx.ContentType()))
+}
+
+func JSON(ctx *gin.Context, data interface{}) bool {
+	return With(ctx, data, binding.JSON)
+}
+
+// ProtoBuf data 必须是 proto.Message
+func ProtoBuf(ctx *gin.Context, data interface{}) bool {
+	return With(ctx, data, binding.ProtoBuf)
+}
+
+func MsgPack(ctx *gin.Context, data interface{}) bool {
+	return With(ctx, data, binding.MsgPack)
+}
+
+func Query(ctx *gin.Context, data interface{}) bool {
+	return With(ctx, data, binding.Query)
+}
+
+func Form(ctx *gin.Context, data interface{}) bool {
+	return With(ctx, data, binding.Form)
+}
+
+func URI(ctx *gin.Context, data interface{}) bool {
+	err := ctx.ShouldBindUri(data)
+	if err == nil {
+		err = validate(data)
+	}
+	if err != nil {
+		abort(ctx, err)
+		return false
+	}
+	return true
+}
+
+func With(ctx *gin.Context, data interface{}, b binding.Binding) bool {
+	err := ctx.ShouldBindWith(data, b)
+	if err == nil {
+		err = validate(data)
+	}
+	if err != nil {
+		abort(ctx, err)
+		return false
+	}
+	return true
+}
+
+func validate(data interface{}) error {
+	if val, ok := data.(Validator); ok {
+		return val.Validate()
+	}
+	return nil
+}
+
+func abort(ctx *gin.Context, err error) {
+	switch e := err.(type) {
+	case validator9.ValidationErrors:
+		for _, val := range errs.ValidationErrors(e, http.StatusUnprocessableEntity) {
+			ctx.Error(val)
+		}
+	case *errs.Error:
+		if e.StatusCode == 0 {
+			e = e.Clone()
+			e.StatusCode = http.StatusUnprocessableEntity
+		}
+		ctx.Error(e)
+	default:
+		// 解析失败
+		ctx.Error(&errs.Error{
+			Err:        err,
+			Message:    err.Error(),
+			Type:       "bind",
+			StatusCode: http.StatusBadRequest,
+		})
+	}
+	ctx.Abort()
+}
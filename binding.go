@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin/binding"
+	validator9 "gopkg.in/go-playground/validator.v9"
+)
+
+type (
+	// ValidationRegisterer Validator 不是 validator.v9 时实现  用于 RegisterValidation
+	ValidationRegisterer interface {
+		RegisterValidation(tag string, fn validator9.Func) error
+	}
+)
+
+// RegisterValidation 注册 binding 的验证 tag  gin 的 binding.Validator 是全局的  所有 handler 共用
+// Init 替换 Validator 后重新注册  不支持时 Init 返回错误
+func (server *Server) RegisterValidation(tag string, fn validator9.Func) error {
+	if tag == "" || fn == nil {
+		return errors.New("Server: validation tag and func are required")
+	}
+	server.mutex.Lock()
+	if server.validations == nil {
+		server.validations = map[string]validator9.Func{}
+	}
+	server.validations[tag] = fn
+	server.mutex.Unlock()
+	return registerValidation(tag, fn)
+}
+
+// initBinding 使用 Validator 代替 gin 默认的  例如 validator/v10 RegisterTagNameFunc
+func (server *Server) initBinding() error {
+	if server.Validator == nil {
+		return nil
+	}
+	binding.Validator = server.Validator
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for tag, fn := range server.validations {
+		if err := registerValidation(tag, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registerValidation(tag string, fn validator9.Func) error {
+	if binding.Validator == nil {
+		return errors.New("Server: binding validator is nil")
+	}
+	if registerer, ok := binding.Validator.(ValidationRegisterer); ok {
+		return registerer.RegisterValidation(tag, fn)
+	}
+	validate, ok := binding.Validator.Engine().(*validator9.Validate)
+	if !ok {
+		return errors.New("Server: binding validator is not validator.v9 and does not implement ValidationRegisterer  register " + tag + " on its engine")
+	}
+	return validate.RegisterValidation(tag, fn)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin/binding"
+	validator9 "gopkg.in/go-playground/validator.v9"
+)
+
+type otherValidator struct{}
+
+func (otherValidator) ValidateStruct(obj interface{}) error {
+	return nil
+}
+
+func (otherValidator) Engine() interface{} {
+	return nil
+}
+
+type registererValidator struct {
+	otherValidator
+	tags []string
+}
+
+func (validator *registererValidator) RegisterValidation(tag string, fn validator9.Func) error {
+	validator.tags = append(validator.tags, tag)
+	return nil
+}
+
+func TestRegisterValidationUnsupported(t *testing.T) {
+	defer func(val binding.StructValidator) {
+		binding.Validator = val
+	}(binding.Validator)
+
+	srv := &Server{ENV: "test", Validator: otherValidator{}}
+	srv.RegisterValidation("even", func(fl validator9.FieldLevel) bool {
+		return true
+	})
+	if err := srv.Validate(); err == nil {
+		t.Fatal("Validate: expected error")
+	}
+	binding.Validator = srv.Validator
+	if err := srv.initBinding(); err == nil {
+		t.Fatal("initBinding: expected error")
+	}
+}
+
+func TestRegisterValidationRegisterer(t *testing.T) {
+	defer func(val binding.StructValidator) {
+		binding.Validator = val
+	}(binding.Validator)
+
+	validator := &registererValidator{}
+	srv := &Server{ENV: "test", Validator: validator}
+	srv.RegisterValidation("even", func(fl validator9.FieldLevel) bool {
+		return true
+	})
+	if err := srv.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.initBinding(); err != nil {
+		t.Fatal(err)
+	}
+	if len(validator.tags) != 1 || validator.tags[0] != "even" {
+		t.Fatalf("tags %v", validator.tags)
+	}
+}
@@ -0,0 +1,340 @@
+package breaker
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/backpressure"
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	Config struct {
+		Name string
+
+		// 统计窗口
+		Window time.Duration
+		// 窗口内最少请求数 少于不打开
+		MinRequests int64
+		// 失败率 0-1
+		FailureRate float64
+		// 打开后多久进入 half-open
+		OpenTimeout time.Duration
+		// half-open 允许的探测请求数
+		HalfOpenRequests int64
+
+		// 哪些错误算失败 默认 非 nil
+		IsFailure func(err error) bool
+	}
+
+	State int
+
+	Breaker struct {
+		config Config
+
+		mutex     sync.Mutex
+		state     State
+		windowAt  time.Time
+		openedAt  time.Time
+		requests  int64
+		failures  int64
+		halfOpen  int64
+		rejected  int64
+		opened    int64
+		lastError string
+	}
+
+	Stats struct {
+		Name      string    `json:"name"`
+		State     string    `json:"state"`
+		Requests  int64     `json:"requests"`
+		Failures  int64     `json:"failures"`
+		Rejected  int64     `json:"rejected"`
+		Opened    int64     `json:"opened"`
+		OpenedAt  time.Time `json:"opened_at,omitempty"`
+		LastError string    `json:"last_error,omitempty"`
+	}
+
+	transport struct {
+		breaker *Breaker
+		next    http.RoundTripper
+	}
+)
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+var ErrOpen = &errs.Error{
+	Message:    http.StatusText(http.StatusServiceUnavailable),
+	Type:       "breaker",
+	StatusCode: http.StatusServiceUnavailable,
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]*Breaker{}
+)
+
+// New 同名返回已有的
+func New(c Config) *Breaker {
+	if c.Name == "" {
+		panic("breaker: name is empty")
+	}
+	if c.Window == 0 {
+		c.Window = time.Second * 10
+	}
+	if c.MinRequests == 0 {
+		c.MinRequests = 20
+	}
+	if c.FailureRate == 0 {
+		c.FailureRate = 0.5
+	}
+	if c.OpenTimeout == 0 {
+		c.OpenTimeout = time.Second * 5
+	}
+	if c.HalfOpenRequests == 0 {
+		c.HalfOpenRequests = 1
+	}
+	if c.IsFailure == nil {
+		c.IsFailure = func(err error) bool {
+			return err != nil
+		}
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if breaker, ok := registry[c.Name]; ok {
+		return breaker
+	}
+	breaker := &Breaker{
+		config:   c,
+		windowAt: time.Now(),
+	}
+	registry[c.Name] = breaker
+	return breaker
+}
+
+func Get(name string) *Breaker {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return registry[name]
+}
+
+// All 全部 breaker 的状态 用于 metrics
+func All() (stats []Stats) {
+	registryMutex.RLock()
+	for _, breaker := range registry {
+		stats = append(stats, breaker.Stats())
+	}
+	registryMutex.RUnlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return
+}
+
+// Middleware 依赖打开时直接返回 503
+func Middleware(breakers ...*Breaker) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		for _, breaker := range breakers {
+			if breaker.State() == StateOpen {
+				backpressure.Abort(ctx, ErrOpen, breaker.retryAfter())
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
+
+// Handler 输出 breaker 状态
+func Handler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, All())
+	}
+}
+
+// Transport http client 使用  5xx 算失败
+func Transport(breaker *Breaker, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{
+		breaker: breaker,
+		next:    next,
+	}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	var done func(error)
+	if done, err = t.breaker.Allow(); err != nil {
+		return
+	}
+	res, err = t.next.RoundTrip(req)
+	if err == nil && res.StatusCode >= http.StatusInternalServerError {
+		done(&errs.Error{
+			Message:    res.Status,
+			Type:       "breaker",
+			StatusCode: res.StatusCode,
+		})
+	} else {
+		done(err)
+	}
+	return
+}
+
+// Do 执行 打开时返回 ErrOpen
+func (breaker *Breaker) Do(fn func() error) (err error) {
+	var done func(error)
+	if done, err = breaker.Allow(); err != nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			done(ErrOpen)
+			panic(r)
+		}
+	}()
+	err = fn()
+	done(err)
+	return
+}
+
+// Allow 允许时返回 done  调用结束必须执行 done(err)
+func (breaker *Breaker) Allow() (done func(error), err error) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	now := time.Now()
+	breaker.update(now)
+
+	switch breaker.state {
+	case StateOpen:
+		breaker.rejected++
+		err = ErrOpen
+		return
+	case StateHalfOpen:
+		if breaker.halfOpen >= breaker.config.HalfOpenRequests {
+			breaker.rejected++
+			err = ErrOpen
+			return
+		}
+		breaker.halfOpen++
+	}
+
+	state := breaker.state
+	done = func(err error) {
+		breaker.done(state, err)
+	}
+	return
+}
+
+func (breaker *Breaker) State() State {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	breaker.update(time.Now())
+	return breaker.state
+}
+
+func (breaker *Breaker) Stats() Stats {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	breaker.update(time.Now())
+	return Stats{
+		Name:      breaker.config.Name,
+		State:     breaker.state.String(),
+		Requests:  breaker.requests,
+		Failures:  breaker.failures,
+		Rejected:  breaker.rejected,
+		Opened:    breaker.opened,
+		OpenedAt:  breaker.openedAt,
+		LastError: breaker.lastError,
+	}
+}
+
+func (breaker *Breaker) retryAfter() time.Duration {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	retry := breaker.config.OpenTimeout - time.Since(breaker.openedAt)
+	if retry < 0 {
+		retry = 0
+	}
+	return retry
+}
+
+func (breaker *Breaker) done(state State, err error) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	failure := breaker.config.IsFailure(err)
+	if failure {
+		breaker.lastError = err.Error()
+	}
+
+	switch state {
+	case StateHalfOpen:
+		if breaker.state != StateHalfOpen {
+			return
+		}
+		if failure {
+			breaker.open(time.Now())
+			return
+		}
+		// 探测成功 关闭
+		breaker.state = StateClosed
+		breaker.halfOpen = 0
+		breaker.reset(time.Now())
+	case StateClosed:
+		if breaker.state != StateClosed {
+			return
+		}
+		breaker.requests++
+		if failure {
+			breaker.failures++
+		}
+		if breaker.requests >= breaker.config.MinRequests && float64(breaker.failures)/float64(breaker.requests) >= breaker.config.FailureRate {
+			breaker.open(time.Now())
+		}
+	}
+}
+
+func (breaker *Breaker) update(now time.Time) {
+	switch breaker.state {
+	case StateClosed:
+		if now.Sub(breaker.windowAt) >= breaker.config.Window {
+			breaker.reset(now)
+		}
+	case StateOpen:
+		if now.Sub(breaker.openedAt) >= breaker.config.OpenTimeout {
+			breaker.state = StateHalfOpen
+			breaker.halfOpen = 0
+		}
+	}
+}
+
+func (breaker *Breaker) open(now time.Time) {
+	breaker.state = StateOpen
+	breaker.openedAt = now
+	breaker.opened++
+	breaker.reset(now)
+}
+
+func (breaker *Breaker) reset(now time.Time) {
+	breaker.windowAt = now
+	breaker.requests = 0
+	breaker.failures = 0
+}
+
+func (state State) String() string {
+	switch state {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
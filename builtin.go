@@ -0,0 +1,144 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+type (
+	Builtin struct {
+		Content string `json:"content,omitempty"`
+
+		// 有 FS 时为 FS 中的文件
+		File        string `json:"file,omitempty"`
+		ContentType string `json:"content_type,omitempty"`
+
+		// 不处理 交给 handler
+		Disabled bool `json:"disabled,omitempty"`
+
+		body []byte
+	}
+
+	Builtins struct {
+		Robots      *Builtin `json:"robots,omitempty"`
+		Favicon     *Builtin `json:"favicon,omitempty"`
+		Crossdomain *Builtin `json:"crossdomain,omitempty"`
+		SecurityTxt *Builtin `json:"security_txt,omitempty"`
+	}
+)
+
+func (config *Builtins) init(server *Server, handler *Handler) {
+	var parent *Builtins
+	fs := server.FS
+	if handler != nil {
+		parent = server.Builtins
+		if handler.FS != nil {
+			fs = handler.FS
+		}
+	}
+
+	if config.Robots == nil {
+		if parent != nil {
+			config.Robots = parent.Robots.inherit(fs)
+		} else if server.ENV == "production" {
+			config.Robots = &Builtin{Content: "User-agent: *\nDisallow:\n"}
+		} else {
+			config.Robots = &Builtin{Content: "User-agent: *\nDisallow: /\n"}
+		}
+	}
+	if config.Favicon == nil {
+		if parent != nil {
+			config.Favicon = parent.Favicon.inherit(fs)
+		} else {
+			config.Favicon = &Builtin{}
+		}
+	}
+	if config.Crossdomain == nil {
+		if parent != nil {
+			config.Crossdomain = parent.Crossdomain.inherit(fs)
+		} else {
+			config.Crossdomain = &Builtin{Content: "<?xml version=\"1.0\"?><cross-domain-policy></cross-domain-policy>\n"}
+		}
+	}
+	if config.SecurityTxt == nil {
+		if parent != nil {
+			config.SecurityTxt = parent.SecurityTxt.inherit(fs)
+		} else {
+			config.SecurityTxt = &Builtin{Disabled: true}
+		}
+	}
+
+	config.Robots.init("text/plain; charset=utf-8", fs)
+	config.Favicon.init("image/x-icon", fs)
+	config.Crossdomain.init("application/xml; charset=utf-8", fs)
+	config.SecurityTxt.init("text/plain; charset=utf-8", fs)
+}
+
+func (config *Builtins) get(urlPath string) (builtin *Builtin) {
+	switch urlPath {
+	case "/robots.txt":
+		builtin = config.Robots
+	case "/favicon.ico":
+		builtin = config.Favicon
+	case "/crossdomain.xml":
+		builtin = config.Crossdomain
+	case "/.well-known/security.txt":
+		builtin = config.SecurityTxt
+	}
+	if builtin != nil && builtin.Disabled {
+		builtin = nil
+	}
+	return
+}
+
+// inherit host 有自己的 FS 时重新读取文件
+func (config *Builtin) inherit(fs http.FileSystem) *Builtin {
+	if config.File == "" || fs == nil {
+		return config
+	}
+	builtin := *config
+	builtin.body = nil
+	return &builtin
+}
+
+func (config *Builtin) init(contentType string, fs http.FileSystem) {
+	if config.body != nil {
+		return
+	}
+	if config.ContentType == "" {
+		config.ContentType = contentType
+	}
+	if config.File != "" && fs != nil {
+		file, err := fs.Open(path.Clean("/" + config.File))
+		if err != nil {
+			panic(err)
+		}
+		defer file.Close()
+		if config.body, err = ioutil.ReadAll(file); err != nil {
+			panic(err)
+		}
+	} else if config.File != "" {
+		var err error
+		if config.body, err = ioutil.ReadFile(config.File); err != nil {
+			panic(err)
+		}
+	} else {
+		config.body = []byte(config.Content)
+	}
+}
+
+func (config *Builtin) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writer.Header().Set("Allow", "GET, HEAD")
+		http.Error(writer, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	writer.Header().Set("Content-Type", config.ContentType)
+	writer.Header().Set("Content-Length", strconv.Itoa(len(config.body)))
+	writer.WriteHeader(http.StatusOK)
+	if req.Method != http.MethodHead {
+		writer.Write(config.body)
+	}
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"github.com/otamoe/gin-server/cachecontrol"
+)
+
+type (
+	CacheControl struct {
+		// 路由名 type.action 或 type
+		Policies map[string]*cachecontrol.Policy `json:"policies,omitempty"`
+		Default  *cachecontrol.Policy            `json:"default,omitempty"`
+	}
+)
+
+func (config *CacheControl) init(server *Server, handler *Handler) {
+	if config.Default == nil {
+		policy := cachecontrol.Revalidate
+		config.Default = &policy
+	}
+}
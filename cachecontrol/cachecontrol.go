@@ -0,0 +1,160 @@
+package cachecontrol
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	Policy struct {
+		Public               bool          `json:"public,omitempty"`
+		Private              bool          `json:"private,omitempty"`
+		NoStore              bool          `json:"no_store,omitempty"`
+		NoCache              bool          `json:"no_cache,omitempty"`
+		MustRevalidate       bool          `json:"must_revalidate,omitempty"`
+		Immutable            bool          `json:"immutable,omitempty"`
+		MaxAge               time.Duration `json:"max_age,omitempty"`
+		SMaxAge              time.Duration `json:"s_max_age,omitempty"`
+		StaleWhileRevalidate time.Duration `json:"stale_while_revalidate,omitempty"`
+		StaleIfError         time.Duration `json:"stale_if_error,omitempty"`
+		Vary                 []string      `json:"vary,omitempty"`
+	}
+
+	Config struct {
+		// 路由名 type.action 或 type => policy
+		Policies map[string]*Policy
+		Default  *Policy
+	}
+)
+
+var (
+	NoStore = Policy{
+		NoStore: true,
+	}
+	Revalidate = Policy{
+		NoCache: true,
+	}
+)
+
+// Middleware 路由上使用
+func Middleware(policy Policy) gin.HandlerFunc {
+	value := policy.String()
+	return func(ctx *gin.Context) {
+		policy.apply(ctx, value)
+		ctx.Next()
+	}
+}
+
+// MiddlewareNames 根据 resource 名称选择 policy
+func MiddlewareNames(c Config) gin.HandlerFunc {
+	values := map[string]string{}
+	for name, policy := range c.Policies {
+		values[name] = policy.String()
+	}
+	var defaultValue string
+	if c.Default != nil {
+		defaultValue = c.Default.String()
+	}
+
+	return func(ctx *gin.Context) {
+		policy := c.Default
+		value := defaultValue
+		if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+			resource := val.(*ginResource.Resource)
+			if val, ok := c.Policies[resource.Name()]; ok {
+				policy = val
+				value = values[resource.Name()]
+			} else if val, ok := c.Policies[resource.Type]; ok {
+				policy = val
+				value = values[resource.Type]
+			}
+		}
+		if policy != nil {
+			policy.apply(ctx, value)
+		}
+		ctx.Next()
+	}
+}
+
+func (policy Policy) String() string {
+	var values []string
+	if policy.NoStore {
+		return "no-store"
+	}
+	if policy.Public {
+		values = append(values, "public")
+	}
+	if policy.Private {
+		values = append(values, "private")
+	}
+	if policy.NoCache {
+		values = append(values, "no-cache")
+	}
+	if policy.MaxAge > 0 {
+		values = append(values, "max-age="+seconds(policy.MaxAge))
+	}
+	if policy.SMaxAge > 0 {
+		values = append(values, "s-maxage="+seconds(policy.SMaxAge))
+	}
+	if policy.StaleWhileRevalidate > 0 {
+		values = append(values, "stale-while-revalidate="+seconds(policy.StaleWhileRevalidate))
+	}
+	if policy.StaleIfError > 0 {
+		values = append(values, "stale-if-error="+seconds(policy.StaleIfError))
+	}
+	if policy.MustRevalidate {
+		values = append(values, "must-revalidate")
+	}
+	if policy.Immutable {
+		values = append(values, "immutable")
+	}
+	return strings.Join(values, ", ")
+}
+
+func (policy Policy) apply(ctx *gin.Context, value string) {
+	// 只有 GET HEAD 可以缓存
+	if ctx.Request.Method != http.MethodGet && ctx.Request.Method != http.MethodHead {
+		ctx.Header("Cache-Control", NoStore.String())
+		return
+	}
+	if value != "" {
+		ctx.Header("Cache-Control", value)
+	}
+	if len(policy.Vary) != 0 {
+		AddVary(ctx, policy.Vary...)
+	}
+}
+
+// AddVary 合并 Vary 头 去重
+func AddVary(ctx *gin.Context, values ...string) {
+	header := ctx.Writer.Header()
+	var vary []string
+	exists := map[string]bool{}
+	for _, val := range header["Vary"] {
+		for _, name := range strings.Split(val, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || exists[strings.ToLower(name)] {
+				continue
+			}
+			exists[strings.ToLower(name)] = true
+			vary = append(vary, name)
+		}
+	}
+	for _, name := range values {
+		if exists[strings.ToLower(name)] {
+			continue
+		}
+		exists[strings.ToLower(name)] = true
+		vary = append(vary, http.CanonicalHeaderKey(name))
+	}
+	header.Set("Vary", strings.Join(vary, ", "))
+}
+
+func seconds(duration time.Duration) string {
+	return strconv.FormatInt(int64(duration/time.Second), 10)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/otamoe/gin-server/canary"
+	"github.com/otamoe/gin-server/metrics"
+	"github.com/otamoe/gin-server/proxy"
+)
+
+type (
+	// Canary 一部分请求交给另一个 handler 或 upstream  按 cookie 分桶保持不变
+	Canary struct {
+		// 0 - 100
+		Percent float64 `json:"percent,omitempty"`
+
+		// handler 的名字  或 upstream 地址  二选一
+		Handler  string `json:"handler,omitempty"`
+		Upstream string `json:"upstream,omitempty"`
+
+		// 请求头强制使用 canary
+		Header      string `json:"header,omitempty"`
+		HeaderValue string `json:"header_value,omitempty"`
+
+		Cookie string        `json:"cookie,omitempty"`
+		MaxAge time.Duration `json:"max_age,omitempty"`
+
+		splitter *canary.Splitter
+	}
+)
+
+func (config *Canary) init(server *Server, handler *Handler) {
+}
+
+func (config *Canary) wrap(server *Server, handler *Handler, next http.Handler) http.Handler {
+	if config.splitter != nil {
+		return config.splitter
+	}
+	var alternate http.Handler
+	if config.Upstream != "" {
+		alternate = proxy.New(proxy.Config{
+			Upstream:       config.Upstream,
+			TrustedProxies: server.trustedProxies,
+			Retries:        2,
+		})
+	} else {
+		val := server.Get(config.Handler, false)
+		if val == nil {
+			panic("Canary: handler " + config.Handler + " not found")
+		}
+		alternate = val.http(server)
+	}
+	c := canary.Config{
+		Name:        handler.Name,
+		Percent:     config.Percent,
+		Header:      config.Header,
+		HeaderValue: config.HeaderValue,
+		Cookie:      config.Cookie,
+		MaxAge:      config.MaxAge,
+	}
+	if handler.Metrics != nil {
+		c.Registry = metrics.Default
+	}
+	config.splitter = canary.New(c, next, alternate)
+	return config.splitter
+}
+
+// Get 运行时调整比例  handler 开始处理请求之后才有值
+func (config *Canary) Get() *canary.Splitter {
+	return config.splitter
+}
@@ -0,0 +1,192 @@
+package canary
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/otamoe/gin-server/metrics"
+)
+
+type (
+	Config struct {
+		// metrics 的 name 标签
+		Name string
+
+		// 0 - 100  分到 canary 的比例
+		Percent float64
+
+		// 请求头强制使用 canary  例如 X-Canary: 1  HeaderValue 为空时任意非空值
+		Header      string
+		HeaderValue string
+
+		// 保存 0 - 9999 的分桶  调整比例时已经在 canary 的用户不会切回
+		Cookie string
+		MaxAge time.Duration
+
+		// 有值时输出 canary_requests_total
+		Registry *metrics.Registry
+	}
+
+	Splitter struct {
+		config Config
+		stable http.Handler
+		canary http.Handler
+
+		mutex   sync.RWMutex
+		percent float64
+
+		requests *metrics.Counter
+	}
+
+	statusWriter struct {
+		http.ResponseWriter
+		status int
+	}
+
+	contextKey struct{}
+)
+
+const (
+	Stable = "stable"
+	Canary = "canary"
+)
+
+const buckets = 10000
+
+func New(c Config, stable http.Handler, canary http.Handler) *Splitter {
+	if c.Cookie == "" {
+		c.Cookie = "canary"
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = time.Hour * 24 * 30
+	}
+	splitter := &Splitter{
+		config:  c,
+		stable:  stable,
+		canary:  canary,
+		percent: c.Percent,
+	}
+	if c.Registry != nil {
+		splitter.requests = c.Registry.Counter("canary_requests_total", "Requests by canary variant.", "name", "variant", "code")
+	}
+	return splitter
+}
+
+// Variant 当前请求的分组  不经过 Splitter 时为空
+func Variant(ctx context.Context) string {
+	if val, ok := ctx.Value(contextKey{}).(string); ok {
+		return val
+	}
+	return ""
+}
+
+// SetPercent 运行时调整  0 为回滚
+func (splitter *Splitter) SetPercent(percent float64) {
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	splitter.mutex.Lock()
+	splitter.percent = percent
+	splitter.mutex.Unlock()
+}
+
+func (splitter *Splitter) Percent() float64 {
+	splitter.mutex.RLock()
+	defer splitter.mutex.RUnlock()
+	return splitter.percent
+}
+
+func (splitter *Splitter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	variant := splitter.variant(w, req)
+	req = req.WithContext(context.WithValue(req.Context(), contextKey{}, variant))
+	w.Header().Set("X-Canary", variant)
+
+	next := splitter.stable
+	if variant == Canary {
+		next = splitter.canary
+	}
+	if splitter.requests == nil {
+		next.ServeHTTP(w, req)
+		return
+	}
+	writer := &statusWriter{ResponseWriter: w}
+	next.ServeHTTP(writer, req)
+	status := writer.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	splitter.requests.Inc(splitter.config.Name, variant, strconv.Itoa(status/100)+"xx")
+}
+
+func (splitter *Splitter) variant(w http.ResponseWriter, req *http.Request) string {
+	if splitter.config.Header != "" {
+		if val := req.Header.Get(splitter.config.Header); val != "" && (splitter.config.HeaderValue == "" || val == splitter.config.HeaderValue) {
+			return Canary
+		}
+	}
+	percent := splitter.Percent()
+	if percent <= 0 {
+		return Stable
+	}
+
+	bucket := -1
+	if cookie, err := req.Cookie(splitter.config.Cookie); err == nil {
+		if val, err := strconv.Atoi(cookie.Value); err == nil && val >= 0 && val < buckets {
+			bucket = val
+		}
+	}
+	if bucket == -1 {
+		bucket = rand.Intn(buckets)
+		http.SetCookie(w, &http.Cookie{
+			Name:     splitter.config.Cookie,
+			Value:    strconv.Itoa(bucket),
+			Path:     "/",
+			MaxAge:   int(splitter.config.MaxAge / time.Second),
+			HttpOnly: true,
+			Secure:   req.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	if float64(bucket) < percent*buckets/100 {
+		return Canary
+	}
+	return Stable
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.status = http.StatusSwitchingProtocols
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("canary: response writer is not a hijacker")
+}
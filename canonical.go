@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/otamoe/gin-server/canonical"
+)
+
+type (
+	// Canonical 规范 URL  替代 gin 的 RedirectTrailingSlash RedirectFixedPath
+	Canonical struct {
+		LowercaseHost bool `json:"lowercase_host,omitempty"`
+
+		// strip 或 add
+		TrailingSlash string `json:"trailing_slash,omitempty"`
+
+		CollapseSlashes bool `json:"collapse_slashes,omitempty"`
+
+		// 不处理的路径前缀
+		Skip []string `json:"skip,omitempty"`
+	}
+)
+
+func (config *Canonical) init(server *Server, handler *Handler) {
+}
+
+func (config *Canonical) wrap(server *Server, next http.Handler) http.Handler {
+	return canonical.Handler(canonical.Config{
+		LowercaseHost:   config.LowercaseHost,
+		TrailingSlash:   config.TrailingSlash,
+		CollapseSlashes: config.CollapseSlashes,
+		Skip:            config.Skip,
+		Scheme: func(req *http.Request) string {
+			return server.getServerHandler().scheme(req)
+		},
+	}, next)
+}
@@ -0,0 +1,98 @@
+package canonical
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+type (
+	Config struct {
+		// 大写的 host 重定向到小写
+		LowercaseHost bool
+
+		// "strip" 去掉结尾的 /  "add" 没有扩展名的路径添加 /  为空不处理
+		TrailingSlash string
+
+		// 合并重复的 /
+		CollapseSlashes bool
+
+		// 不处理的路径前缀  例如 /api/
+		Skip []string
+
+		// 重定向到其他 host 时使用  为空时根据 TLS
+		Scheme func(req *http.Request) string
+	}
+)
+
+const (
+	TrailingSlashStrip = "strip"
+	TrailingSlashAdd   = "add"
+)
+
+// Handler 路由之前检查  不是规范的 URL 时 308 重定向
+func Handler(c Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if target, ok := URL(c, req); ok {
+			http.Redirect(writer, req, target, http.StatusPermanentRedirect)
+			return
+		}
+		next.ServeHTTP(writer, req)
+	})
+}
+
+// URL 返回规范的 URL  ok 为是否需要重定向
+func URL(c Config, req *http.Request) (target string, ok bool) {
+	urlPath := req.URL.Path
+	for _, prefix := range c.Skip {
+		if strings.HasPrefix(urlPath, prefix) {
+			return
+		}
+	}
+
+	host := req.Host
+	if c.LowercaseHost {
+		host = strings.ToLower(host)
+	}
+
+	if c.CollapseSlashes {
+		for strings.Contains(urlPath, "//") {
+			urlPath = strings.Replace(urlPath, "//", "/", -1)
+		}
+	}
+
+	if urlPath != "/" {
+		switch c.TrailingSlash {
+		case TrailingSlashStrip:
+			urlPath = strings.TrimRight(urlPath, "/")
+			if urlPath == "" {
+				urlPath = "/"
+			}
+		case TrailingSlashAdd:
+			if !strings.HasSuffix(urlPath, "/") && path.Ext(urlPath) == "" {
+				urlPath += "/"
+			}
+		}
+	}
+
+	if host == req.Host && urlPath == req.URL.Path {
+		return
+	}
+
+	u := *req.URL
+	u.Path = urlPath
+	u.RawPath = ""
+	u.Scheme = ""
+	u.Host = ""
+	if host != req.Host {
+		u.Host = host
+		if c.Scheme != nil {
+			u.Scheme = c.Scheme(req)
+		} else if req.TLS != nil {
+			u.Scheme = "https"
+		} else {
+			u.Scheme = "http"
+		}
+	}
+	return u.String(), true
+}
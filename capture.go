@@ -0,0 +1,49 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/capture"
+)
+
+type (
+	// Capture 采样保存请求  用 capture.Replay 重放
+	Capture struct {
+		Sample  float64  `json:"sample,omitempty"`
+		MaxBody int      `json:"max_body,omitempty"`
+		Fields  []string `json:"fields,omitempty"`
+
+		// json lines 文件  为空时保存到 mongo
+		File string `json:"file,omitempty"`
+
+		store capture.Store
+	}
+)
+
+func (config *Capture) init(server *Server, handler *Handler) {
+	if config.store != nil {
+		return
+	}
+	if config.File != "" {
+		config.store = &capture.File{Path: config.File}
+		return
+	}
+	var provider MongoProvider
+	if handler != nil {
+		provider = handler.MongoProvider
+	} else {
+		provider = server.mongoProvider()
+	}
+	if provider != nil {
+		config.store = &capture.Mongo{Provider: provider}
+	}
+}
+
+func (config *Capture) middleware(handler *Handler) gin.HandlerFunc {
+	return capture.Middleware(capture.Config{
+		Sample:  config.Sample,
+		MaxBody: config.MaxBody,
+		Fields:  config.Fields,
+		Store:   config.store,
+		Logger:  handler.Logger.Get(),
+	})
+}
@@ -0,0 +1,216 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/otamoe/gin-server/mongo"
+	"github.com/otamoe/gin-server/recorder"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	Config struct {
+		// 采样率 0 - 1
+		Sample float64
+
+		// body 截断长度
+		MaxBody int
+
+		// 隐藏值的 json 字段  query 参数
+		Fields []string
+
+		Store Store
+
+		// 队列满时丢弃
+		QueueSize int
+
+		Skip   func(ctx *gin.Context) bool
+		Logger *logrus.Logger
+	}
+
+	Store interface {
+		Save(entries []*recorder.Entry) error
+	}
+
+	// File json lines
+	File struct {
+		Path  string
+		mutex sync.Mutex
+	}
+
+	// Mongo 保存到 captures 集合
+	Mongo struct {
+		Provider mongo.Provider
+	}
+)
+
+var Collection = "captures"
+
+var DefaultFields = []string{"password", "token", "secret", "access_token", "refresh_token", "client_secret"}
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.MaxBody == 0 {
+		c.MaxBody = 1024 * 64
+	}
+	if c.Fields == nil {
+		c.Fields = DefaultFields
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = 1024
+	}
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	fields := map[string]bool{}
+	for _, field := range c.Fields {
+		fields[strings.ToLower(field)] = true
+	}
+
+	queue := make(chan *recorder.Entry, c.QueueSize)
+	go func() {
+		for entry := range queue {
+			entries := []*recorder.Entry{entry}
+			for len(queue) != 0 && len(entries) < 100 {
+				entries = append(entries, <-queue)
+			}
+			if err := c.Store.Save(entries); err != nil {
+				c.Logger.Error("Capture Save:", err)
+			}
+		}
+	}()
+
+	return func(ctx *gin.Context) {
+		if c.Sample <= 0 || (c.Sample < 1 && rand.Float64() >= c.Sample) || (c.Skip != nil && c.Skip(ctx)) {
+			ctx.Next()
+			return
+		}
+		entry := recorder.Record(ctx, c.MaxBody)
+		Sanitize(entry, fields)
+		select {
+		case queue <- entry:
+		default:
+		}
+	}
+}
+
+// Sanitize 隐藏 query 和 json body 中的字段  fields 为小写
+func Sanitize(entry *recorder.Entry, fields map[string]bool) {
+	if entry.Query != "" {
+		values := strings.Split(entry.Query, "&")
+		for i, value := range values {
+			if index := strings.Index(value, "="); index != -1 && fields[strings.ToLower(value[:index])] {
+				values[i] = value[:index+1] + "******"
+			}
+		}
+		entry.Query = strings.Join(values, "&")
+	}
+	entry.RequestBody = sanitizeJSON(entry.RequestBody, fields)
+	entry.ResponseBody = sanitizeJSON(entry.ResponseBody, fields)
+}
+
+func sanitizeJSON(body string, fields map[string]bool) string {
+	body2 := strings.TrimSpace(body)
+	if body2 == "" || (body2[0] != '{' && body2[0] != '[') {
+		return body
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(body2), &value); err != nil {
+		return body
+	}
+	if !sanitizeValue(value, fields) {
+		return body
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return string(data)
+}
+
+func sanitizeValue(value interface{}, fields map[string]bool) (changed bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, val := range value {
+			if fields[strings.ToLower(key)] {
+				value[key] = "******"
+				changed = true
+			} else if sanitizeValue(val, fields) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, val := range value {
+			if sanitizeValue(val, fields) {
+				changed = true
+			}
+		}
+	}
+	return
+}
+
+func (store *File) Save(entries []*recorder.Entry) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	var file *os.File
+	if file, err = os.OpenFile(store.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if err = encoder.Encode(entry); err != nil {
+			return
+		}
+	}
+	err = writer.Flush()
+	return
+}
+
+func (store *Mongo) Save(entries []*recorder.Entry) (err error) {
+	// Lazy 的 provider 连接失败时 panic  在后台 goroutine 中不能 panic
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	session := store.Provider.Get()
+	defer session.Close()
+	docs := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		docs[i] = entry
+	}
+	return session.DB("").C(Collection).Insert(docs...)
+}
+
+// ReadFile 读取 File 保存的记录
+func ReadFile(path string) (entries []*recorder.Entry, err error) {
+	var file *os.File
+	if file, err = os.Open(path); err != nil {
+		return
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for decoder.More() {
+		entry := &recorder.Entry{}
+		if err = decoder.Decode(entry); err != nil {
+			return
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+// ReadMongo query 为空时读取全部  按时间排序
+func ReadMongo(session *mgo.Session, query interface{}, limit int) (entries []*recorder.Entry, err error) {
+	err = session.DB("").C(Collection).Find(query).Sort("created_at").Limit(limit).All(&entries)
+	return
+}
@@ -0,0 +1,164 @@
+package capture
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/otamoe/gin-server/recorder"
+)
+
+type (
+	ReplayConfig struct {
+		// 目标 例如 https://staging.example.com  为空时使用 Handler
+		Target  string
+		Handler http.Handler
+		Client  *http.Client
+
+		// 覆盖请求头  例如 Authorization
+		Header http.Header
+
+		Concurrency int
+
+		// 按原始时间间隔发送  2 为两倍速  0 不等待
+		Speed float64
+	}
+
+	Result struct {
+		Entry      *recorder.Entry
+		StatusCode int
+		Latency    time.Duration
+		Err        error
+	}
+
+	Report struct {
+		Total    int
+		Errors   int
+		Mismatch int
+		Latency  time.Duration
+		Results  []*Result
+	}
+)
+
+// 不重放的请求头
+var skipHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+	"Accept-Encoding":   true,
+}
+
+// Replay 重放记录  被隐藏的请求头不发送  状态码和记录不同计入 Mismatch
+func Replay(ctx context.Context, entries []*recorder.Entry, c ReplayConfig) *Report {
+	if c.Concurrency <= 0 {
+		c.Concurrency = 1
+	}
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+
+	report := &Report{
+		Results: make([]*Result, len(entries)),
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, c.Concurrency)
+	start := time.Now()
+	for i, entry := range entries {
+		if c.Speed > 0 && i != 0 {
+			offset := time.Duration(float64(entry.CreatedAt.Sub(entries[0].CreatedAt)) / c.Speed)
+			if wait := offset - time.Since(start); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			report.Results = report.Results[:i]
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, entry *recorder.Entry) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			report.Results[i] = replay(ctx, entry, c)
+		}(i, entry)
+	}
+	wg.Wait()
+
+	for _, result := range report.Results {
+		report.Total++
+		report.Latency += result.Latency
+		if result.Err != nil {
+			report.Errors++
+		} else if result.StatusCode != result.Entry.StatusCode {
+			report.Mismatch++
+		}
+	}
+	return report
+}
+
+func replay(ctx context.Context, entry *recorder.Entry, c ReplayConfig) (result *Result) {
+	result = &Result{
+		Entry: entry,
+	}
+	var req *http.Request
+	if req, result.Err = NewRequest(entry, c.Target); result.Err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	for key, values := range c.Header {
+		req.Header[key] = values
+	}
+
+	now := time.Now()
+	if c.Target == "" {
+		recorder := httptest.NewRecorder()
+		c.Handler.ServeHTTP(recorder, req)
+		result.StatusCode = recorder.Code
+	} else {
+		var res *http.Response
+		if res, result.Err = c.Client.Do(req); result.Err != nil {
+			return
+		}
+		res.Body.Close()
+		result.StatusCode = res.StatusCode
+	}
+	result.Latency = time.Since(now)
+	return
+}
+
+// NewRequest 根据记录创建请求  target 为空时创建服务端请求
+func NewRequest(entry *recorder.Entry, target string) (req *http.Request, err error) {
+	rawURL := entry.Path
+	if entry.Query != "" {
+		rawURL += "?" + entry.Query
+	}
+	body := strings.NewReader(entry.RequestBody)
+	if target == "" {
+		req = httptest.NewRequest(entry.Method, "http://"+entry.Host+rawURL, body)
+	} else {
+		var u *url.URL
+		if u, err = url.Parse(strings.TrimSuffix(target, "/") + rawURL); err != nil {
+			return
+		}
+		if req, err = http.NewRequest(entry.Method, u.String(), body); err != nil {
+			return
+		}
+		req.Host = entry.Host
+	}
+	for key, values := range entry.RequestHeader {
+		if skipHeaders[key] || (len(values) == 1 && values[0] == "******") {
+			continue
+		}
+		req.Header[key] = values
+	}
+	return
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"time"
+
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/utils"
+)
+
+type (
+	// CDN 客户端 IP 来自 CDN 的请求头  只有来自 CDN 地址时使用
+	CDN struct {
+		// cloudflare 使用内置的 header ranges urls
+		Provider string `json:"provider,omitempty"`
+
+		Header string   `json:"header,omitempty"`
+		Ranges []string `json:"ranges,omitempty"`
+
+		// 每行一个 CIDR
+		URLs    []string      `json:"urls,omitempty"`
+		Refresh time.Duration `json:"refresh,omitempty"`
+
+		cdn *clientip.CDN
+	}
+)
+
+func (config *CDN) init(server *Server, handler *Handler) {
+	if config.cdn != nil {
+		return
+	}
+	cdn := &clientip.CDN{}
+	if config.Provider == "cloudflare" {
+		cdn = clientip.Cloudflare()
+	}
+	if config.Header != "" {
+		cdn.Header = config.Header
+	}
+	if len(config.Ranges) != 0 {
+		ranges, err := utils.ParseCIDRs(config.Ranges)
+		if err != nil {
+			panic(err)
+		}
+		cdn.Ranges = ranges
+	}
+	if len(config.URLs) != 0 {
+		cdn.URLs = config.URLs
+	}
+	if config.Refresh != 0 {
+		cdn.Refresh = config.Refresh
+	} else if cdn.Refresh == 0 {
+		cdn.Refresh = time.Hour * 24
+	}
+	cdn.Logger = server.Logger.Get()
+	config.cdn = cdn
+}
+
+func (config *CDN) Get() *clientip.CDN {
+	return config.cdn
+}
+
+func (server *Server) cdns() (cdns []*clientip.CDN) {
+	for _, val := range server.CDN {
+		cdns = append(cdns, val.Get())
+	}
+	return
+}
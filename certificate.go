@@ -6,21 +6,36 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"math/big"
 	"time"
+
+	"github.com/otamoe/gin-server/clock"
 )
 
 type (
 	Certificate struct {
 		Certificate string `json:"certificate"`
 		PrivateKey  string `json:"private_key"`
+
+		// 优先  SIGHUP 时重新读取
+		CertificateFile string `json:"certificate_file,omitempty"`
+		PrivateKeyFile  string `json:"private_key_file,omitempty"`
 	}
 )
 
+// load 读取 pem
+func (certificate Certificate) load() (tls.Certificate, error) {
+	if certificate.CertificateFile != "" || certificate.PrivateKeyFile != "" {
+		return tls.LoadX509KeyPair(certificate.CertificateFile, certificate.PrivateKeyFile)
+	}
+	return tls.X509KeyPair([]byte(certificate.Certificate), []byte(certificate.PrivateKey))
+}
+
 func NewCertificate(name string, hosts []string, typ string, bits int) (priv crypto.PrivateKey, cert []byte, err error) {
 	var pub crypto.PublicKey
 	switch typ {
@@ -67,8 +82,8 @@ func NewCertificate(name string, hosts []string, typ string, bits int) (priv cry
 	template := &x509.Certificate{
 		SerialNumber:        serialNumber,
 		Subject:             subject,
-		NotBefore:           time.Now().Add(-(time.Hour * 24 * 30)),
-		NotAfter:            time.Now().Add(time.Hour * 24 * 365 * 20),
+		NotBefore:           clock.Now().Add(-(time.Hour * 24 * 30)),
+		NotAfter:            clock.Now().Add(time.Hour * 24 * 365 * 20),
 		KeyUsage:            x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:         []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		PermittedDNSDomains: hosts,
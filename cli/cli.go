@@ -0,0 +1,398 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	server "github.com/otamoe/gin-server"
+	"github.com/otamoe/gin-server/health"
+	"github.com/otamoe/gin-server/migrate"
+	"github.com/otamoe/gin-server/seed"
+)
+
+type (
+	Command struct {
+		Name  string
+		Usage string
+		Run   func(srv *server.Server, args []string, out io.Writer) int
+	}
+)
+
+// Commands 可以添加自定义命令
+var Commands = []*Command{
+	&Command{
+		Name:  "config",
+		Usage: "print effective config with defaults applied, secrets masked, -resolved for the merged profile",
+		Run:   runConfig,
+	},
+	&Command{
+		Name:  "gencert",
+		Usage: "generate a self-signed certificate",
+		Run:   runGencert,
+	},
+	&Command{
+		Name:  "check",
+		Usage: "dial mongo and redis, run health checks",
+		Run:   runCheck,
+	},
+	&Command{
+		Name:  "migrate",
+		Usage: "apply or roll back migrations, -status -down -to -dry-run",
+		Run:   runMigrate,
+	},
+	&Command{
+		Name:  "seed",
+		Usage: "run registered seeders, also --seed",
+		Run:   runSeed,
+	},
+	&Command{
+		Name:  "backup",
+		Usage: "export collections to storage, -list -restore -drop -collections",
+		Run:   runBackup,
+	},
+}
+
+var secretPattern = regexp.MustCompile(`(?i)(secret|password|passwd|private_key|token|keys|credential)`)
+
+// Main 处理 os.Args  命中子命令时退出  否则返回继续启动
+func Main(srv *server.Server) {
+	if len(os.Args) < 2 {
+		return
+	}
+	if code, ok := Run(srv, os.Args[1:], os.Stdout); ok {
+		os.Exit(code)
+	}
+}
+
+// Run 第一个参数不是子命令时 ok 为 false
+func Run(srv *server.Server, args []string, out io.Writer) (code int, ok bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		for _, command := range Commands {
+			fmt.Fprintf(out, "  %-10s %s\n", command.Name, command.Usage)
+		}
+		return 0, true
+	}
+	for _, command := range Commands {
+		if command.Name == args[0] || "--"+command.Name == args[0] {
+			return command.Run(srv, args[1:], out), true
+		}
+	}
+	return 0, false
+}
+
+func runConfig(srv *server.Server, args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	flags.SetOutput(out)
+	unmask := flags.Bool("unmask", false, "do not mask secrets")
+	resolved := flags.Bool("resolved", false, "print the config merged from default and env profiles, before defaults")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if *resolved {
+		data := srv.ResolvedConfig()
+		if data == nil {
+			fmt.Fprintln(out, "config is not loaded with LoadConfig")
+			return 1
+		}
+		var value interface{}
+		json.Unmarshal(data, &value)
+		if !*unmask {
+			value = Mask(value)
+		}
+		data, _ = json.MarshalIndent(value, "", "  ")
+		fmt.Fprintln(out, string(data))
+		return 0
+	}
+	if err := srv.Validate(); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	srv.Init()
+	for _, handler := range srv.Handlers {
+		handler.Init(srv)
+	}
+
+	data, err := json.Marshal(srv)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	var value interface{}
+	json.Unmarshal(data, &value)
+	if !*unmask {
+		value = Mask(value)
+	}
+	data, _ = json.MarshalIndent(value, "", "  ")
+	fmt.Fprintln(out, string(data))
+	return 0
+}
+
+func runGencert(srv *server.Server, args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("gencert", flag.ContinueOnError)
+	flags.SetOutput(out)
+	name := flags.String("name", "localhost", "common name")
+	hosts := flags.String("hosts", "localhost", "comma separated hosts")
+	typ := flags.String("type", "ecdsa", "ecdsa or rsa")
+	bits := flags.Int("bits", 384, "ecdsa 224 256 384 521  rsa 2048 4096")
+	cert := flags.String("cert", "", "certificate output file  empty prints json")
+	key := flags.String("key", "", "private key output file")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+
+	priv, der, err := server.NewCertificate(*name, strings.Split(*hosts, ","), *typ, *bits)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	certificate, err := server.EncodeCertificate(priv, der)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	if *cert == "" || *key == "" {
+		data, _ := json.MarshalIndent(certificate, "", "  ")
+		fmt.Fprintln(out, string(data))
+		return 0
+	}
+	if err = ioutil.WriteFile(*cert, []byte(certificate.Certificate), 0644); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	if err = ioutil.WriteFile(*key, []byte(certificate.PrivateKey), 0600); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	fmt.Fprintln(out, "written", *cert, *key)
+	return 0
+}
+
+func runCheck(srv *server.Server, args []string, out io.Writer) (code int) {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(out)
+	timeout := flags.Duration("timeout", time.Second*10, "timeout")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if err := srv.Validate(); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	// mongo 连接失败时 Init panic
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintln(out, "fail:", r)
+			code = 1
+		}
+	}()
+	srv.Init()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	report := health.Default.Run(ctx)
+	data, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Fprintln(out, string(data))
+	if report.Status == health.StatusFail {
+		return 1
+	}
+	return 0
+}
+
+func runMigrate(srv *server.Server, args []string, out io.Writer) (code int) {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	status := flags.Bool("status", false, "print applied and pending migrations")
+	down := flags.Bool("down", false, "roll back versions greater than -to")
+	to := flags.Int64("to", 0, "target version  0 applies all")
+	dryRun := flags.Bool("dry-run", false, "print without running")
+	timeout := flags.Duration("timeout", time.Minute*10, "timeout")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if err := srv.Validate(); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	// mongo 连接失败时 Init panic
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintln(out, "fail:", r)
+			code = 1
+		}
+	}()
+	srv.Init()
+
+	if *status {
+		applied, pending, err := srv.MigrateStatus()
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+		for _, record := range applied {
+			fmt.Fprintf(out, "applied  %d %s %s\n", record.Version, record.Name, record.AppliedAt.Format(time.RFC3339))
+		}
+		for _, migration := range pending {
+			fmt.Fprintf(out, "pending  %d %s\n", migration.Version, migration.Name)
+		}
+		return 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	var migrations []*migrate.Migration
+	var err error
+	if *down {
+		migrations, err = srv.MigrateDown(ctx, *to, *dryRun)
+	} else {
+		migrations, err = srv.MigrateUp(ctx, *to, *dryRun)
+	}
+	for _, migration := range migrations {
+		fmt.Fprintln(out, migration.Version, migration.Name)
+	}
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	return 0
+}
+
+func runSeed(srv *server.Server, args []string, out io.Writer) (code int) {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	flags.SetOutput(out)
+	names := flags.String("names", "", "comma separated seeders  empty runs all")
+	list := flags.Bool("list", false, "print seeders in order")
+	timeout := flags.Duration("timeout", time.Minute*10, "timeout")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if *list {
+		for _, name := range seed.Names() {
+			fmt.Fprintln(out, name)
+		}
+		return 0
+	}
+	if err := srv.Validate(); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	// mongo 连接失败时 Init panic
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintln(out, "fail:", r)
+			code = 1
+		}
+	}()
+	srv.Init()
+
+	var values []string
+	if *names != "" {
+		values = strings.Split(*names, ",")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := srv.RunSeeds(ctx, values); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	return 0
+}
+
+func runBackup(srv *server.Server, args []string, out io.Writer) (code int) {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	flags.SetOutput(out)
+	collections := flags.String("collections", "", "comma separated collections  empty exports all")
+	list := flags.Bool("list", false, "print backups")
+	restore := flags.String("restore", "", "backup id to restore")
+	drop := flags.Bool("drop", false, "remove documents before restore")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if err := srv.Validate(); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintln(out, "fail:", r)
+			code = 1
+		}
+	}()
+	srv.Init()
+
+	switch {
+	case *list:
+		backups, err := srv.ListBackups(100)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+		for _, val := range backups {
+			fmt.Fprintf(out, "%s  %s  %s  %d files\n", val.ID.Hex(), val.CreatedAt.Format(time.RFC3339), val.Format, len(val.Files))
+		}
+	case *restore != "":
+		if err := srv.RestoreBackup(*restore, *drop); err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+		fmt.Fprintln(out, "restored", *restore)
+	default:
+		var values []string
+		if *collections != "" {
+			values = strings.Split(*collections, ",")
+		}
+		result, err := srv.ExportBackup(values)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+		for _, file := range result.Files {
+			fmt.Fprintf(out, "%s  %s  %d\n", file.Field, file.Key, file.Size)
+		}
+		fmt.Fprintln(out, "backup", result.ID.Hex())
+	}
+	return 0
+}
+
+// Mask 隐藏 secret password key 等字段  url 中的密码
+func Mask(value interface{}) interface{} {
+	switch val := value.(type) {
+	case map[string]interface{}:
+		for key, item := range val {
+			if secretPattern.MatchString(key) && item != nil && item != "" {
+				val[key] = "******"
+			} else {
+				val[key] = Mask(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = Mask(item)
+		}
+	case string:
+		if strings.Contains(val, "@") {
+			if u, err := url.Parse(val); err == nil && u.User != nil {
+				if _, ok := u.User.Password(); ok {
+					u.User = url.UserPassword(u.User.Username(), "******")
+					return u.String()
+				}
+			}
+		}
+	}
+	return value
+}
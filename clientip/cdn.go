@@ -0,0 +1,175 @@
+package clientip
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/otamoe/gin-server/utils"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// CDN 只有来自 CDN 地址时使用 Header
+	CDN struct {
+		// 例如 CF-Connecting-IP True-Client-IP
+		Header string
+
+		// 初始地址  URLs 更新失败时继续使用
+		Ranges []*net.IPNet
+
+		// 每行一个 CIDR  为空时不更新
+		URLs    []string
+		Refresh time.Duration
+
+		Client *http.Client
+		Logger *logrus.Logger
+
+		nets  atomic.Value
+		once  sync.Once
+		close sync.Once
+		stop  chan struct{}
+	}
+)
+
+var CloudflareURLs = []string{
+	"https://www.cloudflare.com/ips-v4",
+	"https://www.cloudflare.com/ips-v6",
+}
+
+var CloudflareRanges = []string{
+	"173.245.48.0/20",
+	"103.21.244.0/22",
+	"103.22.200.0/22",
+	"103.31.4.0/22",
+	"141.101.64.0/18",
+	"108.162.192.0/18",
+	"190.93.240.0/20",
+	"188.114.96.0/20",
+	"197.234.240.0/22",
+	"198.41.128.0/17",
+	"162.158.0.0/15",
+	"104.16.0.0/13",
+	"104.24.0.0/14",
+	"172.64.0.0/13",
+	"131.0.72.0/22",
+	"2400:cb00::/32",
+	"2606:4700::/32",
+	"2803:f800::/32",
+	"2405:b500::/32",
+	"2405:8100::/32",
+	"2a06:98c0::/29",
+	"2c0f:f248::/32",
+}
+
+// Cloudflare CF-Connecting-IP  每天更新地址
+func Cloudflare() *CDN {
+	ranges, err := utils.ParseCIDRs(CloudflareRanges)
+	if err != nil {
+		panic(err)
+	}
+	return &CDN{
+		Header:  "CF-Connecting-IP",
+		Ranges:  ranges,
+		URLs:    CloudflareURLs,
+		Refresh: time.Hour * 24,
+	}
+}
+
+func (cdn *CDN) Contains(ip net.IP) bool {
+	if val, ok := cdn.nets.Load().([]*net.IPNet); ok {
+		return utils.ContainsIP(val, ip)
+	}
+	return utils.ContainsIP(cdn.Ranges, ip)
+}
+
+// Start 后台更新地址  多次调用只启动一次
+func (cdn *CDN) Start() {
+	cdn.once.Do(func() {
+		if cdn.Client == nil {
+			cdn.Client = &http.Client{Timeout: time.Second * 10}
+		}
+		if cdn.Logger == nil {
+			cdn.Logger = logrus.StandardLogger()
+		}
+		cdn.stop = make(chan struct{})
+		if len(cdn.URLs) == 0 || cdn.Refresh <= 0 {
+			return
+		}
+		go cdn.run()
+	})
+}
+
+func (cdn *CDN) Stop() {
+	cdn.close.Do(func() {
+		if cdn.stop != nil {
+			close(cdn.stop)
+		}
+	})
+}
+
+func (cdn *CDN) run() {
+	if err := cdn.Update(); err != nil {
+		cdn.Logger.Error("CDN Update: ", err)
+	}
+	ticker := time.NewTicker(cdn.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cdn.stop:
+			return
+		case <-ticker.C:
+			if err := cdn.Update(); err != nil {
+				cdn.Logger.Error("CDN Update: ", err)
+			}
+		}
+	}
+}
+
+// Update 下载全部 URLs  任何一个失败时不替换
+func (cdn *CDN) Update() error {
+	var values []string
+	for _, val := range cdn.URLs {
+		res, err := cdn.Client.Get(val)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return errors.New(val + " " + res.Status)
+		}
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				values = append(values, line)
+			}
+		}
+		err = scanner.Err()
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	nets, err := utils.ParseCIDRs(values)
+	if err != nil {
+		return err
+	}
+	if len(nets) == 0 {
+		return errors.New("CDN: ranges is empty")
+	}
+	cdn.nets.Store(nets)
+	return nil
+}
+
+// resolve peer 是 CDN 时使用 Header
+func (cdn *CDN) resolve(req *http.Request, peer net.IP) net.IP {
+	if cdn.Header == "" || !cdn.Contains(peer) {
+		return nil
+	}
+	return net.ParseIP(strings.TrimSpace(req.Header.Get(cdn.Header)))
+}
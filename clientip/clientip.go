@@ -0,0 +1,116 @@
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/utils"
+)
+
+type (
+	Config struct {
+		// 只有来自这些地址时使用 Headers
+		TrustedProxies []*net.IPNet
+
+		// 默认 X-Forwarded-For X-Real-Ip  按顺序使用第一个有效的
+		Headers []string
+
+		// 平台提供的客户端 IP  例如 PlatformCloudflare  不检查 TrustedProxies
+		Platform string
+
+		// X-Forwarded-For 解析出的地址是 CDN 时使用 CDN 的 Header
+		CDNs []*CDN
+	}
+)
+
+var CONTEXT = "GIN.SERVER.CLIENTIP"
+
+const (
+	PlatformGoogleAppEngine = "X-Appengine-Remote-Addr"
+	PlatformCloudflare      = "CF-Connecting-IP"
+)
+
+var DefaultHeaders = []string{"X-Forwarded-For", "X-Real-Ip"}
+
+// Engine gin 1.4 的 ClientIP 总是信任 X-Forwarded-For 的第一个  改为读取 Middleware 写入的 X-Appengine-Remote-Addr
+// handler 中的 ctx.ClientIP() 和 ClientIP 一致
+func Engine(engine *gin.Engine) {
+	engine.ForwardedByClientIP = false
+	engine.AppEngine = true
+}
+
+// Middleware 解析一次  logger rate geoip audit 等通过 ClientIP 获取
+func Middleware(c Config) gin.HandlerFunc {
+	if len(c.Headers) == 0 {
+		c.Headers = DefaultHeaders
+	}
+	for _, cdn := range c.CDNs {
+		cdn.Start()
+	}
+	return func(ctx *gin.Context) {
+		var ip net.IP
+		if c.Platform != "" {
+			ip = net.ParseIP(strings.TrimSpace(ctx.GetHeader(c.Platform)))
+		}
+		if ip == nil {
+			ip = ResolveHeaders(ctx.Request, c.TrustedProxies, c.Headers)
+			for _, cdn := range c.CDNs {
+				if val := cdn.resolve(ctx.Request, ip); val != nil {
+					ip = val
+					break
+				}
+			}
+		}
+		if ip != nil {
+			ctx.Set(CONTEXT, ip.String())
+			ctx.Request.Header.Set(PlatformGoogleAppEngine, ip.String())
+		} else {
+			ctx.Set(CONTEXT, "")
+			ctx.Request.Header.Del(PlatformGoogleAppEngine)
+		}
+		ctx.Next()
+	}
+}
+
+// ClientIP 没有 Middleware 时使用 gin 的 ClientIP
+func ClientIP(ctx *gin.Context) string {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(string)
+	}
+	return ctx.ClientIP()
+}
+
+// Resolve 只有来自信任的代理时使用 X-Forwarded-For  从右往左跳过信任的代理
+func Resolve(req *http.Request, trustedProxies []*net.IPNet) net.IP {
+	return ResolveHeaders(req, trustedProxies, []string{"X-Forwarded-For"})
+}
+
+// ResolveHeaders 同 Resolve  按顺序使用第一个有值的 header
+func ResolveHeaders(req *http.Request, trustedProxies []*net.IPNet, headers []string) net.IP {
+	ip := utils.RemoteIP(req)
+	if !utils.ContainsIP(trustedProxies, ip) {
+		return ip
+	}
+	for _, header := range headers {
+		// 多个同名 header 按顺序合并  Get 只返回第一个  最右边的才是最近的代理添加的
+		value := strings.Join(req.Header.Values(header), ",")
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		values := strings.Split(value, ",")
+		for i := len(values) - 1; i >= 0; i-- {
+			val := net.ParseIP(strings.TrimSpace(values[i]))
+			if val == nil {
+				break
+			}
+			ip = val
+			if !utils.ContainsIP(trustedProxies, val) {
+				break
+			}
+		}
+		break
+	}
+	return ip
+}
@@ -0,0 +1,32 @@
+package clientip
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveHeaders(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	tests := []struct {
+		name   string
+		remote string
+		lines  []string
+		want   string
+	}{
+		{"untrusted peer", "1.1.1.1:1234", []string{"2.2.2.2"}, "1.1.1.1"},
+		{"single line", "10.0.0.1:1234", []string{"9.9.9.9, 2.2.2.2, 10.0.0.2"}, "2.2.2.2"},
+		{"spoofed first line", "10.0.0.1:1234", []string{"9.9.9.9", "2.2.2.2"}, "2.2.2.2"},
+		{"trusted hops across lines", "10.0.0.1:1234", []string{"9.9.9.9", "2.2.2.2", "10.0.0.3"}, "2.2.2.2"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remote
+		for _, line := range test.lines {
+			req.Header.Add("X-Forwarded-For", line)
+		}
+		if ip := Resolve(req, []*net.IPNet{trusted}); ip.String() != test.want {
+			t.Errorf("%s: %s, want %s", test.name, ip, test.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package clock
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Clock 需要控制时间的模块使用  测试中替换为 Mock
+	Clock interface {
+		Now() time.Time
+		NewTimer(d time.Duration) Timer
+		NewTicker(d time.Duration) Ticker
+	}
+
+	Timer interface {
+		C() <-chan time.Time
+		Stop() bool
+		Reset(d time.Duration) bool
+	}
+
+	Ticker interface {
+		C() <-chan time.Time
+		Stop()
+	}
+
+	realClock struct{}
+
+	realTimer struct {
+		timer *time.Timer
+	}
+
+	realTicker struct {
+		ticker *time.Ticker
+	}
+
+	holder struct {
+		clock Clock
+	}
+)
+
+var Real Clock = realClock{}
+
+var current atomic.Value
+
+func init() {
+	current.Store(holder{Real})
+}
+
+// Set 替换全局的 Clock  nil 为 Real
+func Set(c Clock) {
+	if c == nil {
+		c = Real
+	}
+	current.Store(holder{c})
+}
+
+func Get() Clock {
+	return current.Load().(holder).clock
+}
+
+func Now() time.Time {
+	return Get().Now()
+}
+
+func Since(t time.Time) time.Duration {
+	return Get().Now().Sub(t)
+}
+
+func Until(t time.Time) time.Duration {
+	return t.Sub(Get().Now())
+}
+
+func NewTimer(d time.Duration) Timer {
+	return Get().NewTimer(d)
+}
+
+func NewTicker(d time.Duration) Ticker {
+	return Get().NewTicker(d)
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{timer: time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{ticker: time.NewTicker(d)}
+}
+
+func (t *realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t *realTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+func (t *realTimer) Reset(d time.Duration) bool {
+	return t.timer.Reset(d)
+}
+
+func (t *realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t *realTicker) Stop() {
+	t.ticker.Stop()
+}
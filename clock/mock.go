@@ -0,0 +1,123 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Mock 只有 Set Add 时时间才会变化  到期的 Timer Ticker 在 Add 中触发
+	Mock struct {
+		mutex  sync.Mutex
+		now    time.Time
+		timers map[*mockTimer]struct{}
+	}
+
+	mockTimer struct {
+		mock   *Mock
+		when   time.Time
+		period time.Duration
+		c      chan time.Time
+	}
+
+	mockTicker struct {
+		*mockTimer
+	}
+)
+
+func NewMock(now time.Time) *Mock {
+	return &Mock{
+		now:    now,
+		timers: map[*mockTimer]struct{}{},
+	}
+}
+
+func (mock *Mock) Now() time.Time {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	return mock.now
+}
+
+// Add 前进 d  依次触发到期的 Timer Ticker
+func (mock *Mock) Add(d time.Duration) {
+	mock.Set(mock.Now().Add(d))
+}
+
+// Set 不能后退
+func (mock *Mock) Set(now time.Time) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	if now.Before(mock.now) {
+		return
+	}
+	mock.now = now
+	for timer := range mock.timers {
+		if timer.when.After(now) {
+			continue
+		}
+		// 和 time.Ticker 一样  没有读取时丢弃
+		select {
+		case timer.c <- timer.when:
+		default:
+		}
+		if timer.period > 0 {
+			for !timer.when.After(now) {
+				timer.when = timer.when.Add(timer.period)
+			}
+		} else {
+			delete(mock.timers, timer)
+		}
+	}
+}
+
+func (mock *Mock) NewTimer(d time.Duration) Timer {
+	return mock.add(d, 0)
+}
+
+func (mock *Mock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return mockTicker{mock.add(d, d)}
+}
+
+func (mock *Mock) add(d time.Duration, period time.Duration) *mockTimer {
+	timer := &mockTimer{
+		mock:   mock,
+		period: period,
+		c:      make(chan time.Time, 1),
+	}
+	timer.Reset(d)
+	return timer
+}
+
+func (timer *mockTimer) C() <-chan time.Time {
+	return timer.c
+}
+
+func (timer *mockTimer) Stop() bool {
+	timer.mock.mutex.Lock()
+	defer timer.mock.mutex.Unlock()
+	_, ok := timer.mock.timers[timer]
+	delete(timer.mock.timers, timer)
+	return ok
+}
+
+func (ticker mockTicker) Stop() {
+	ticker.mockTimer.Stop()
+}
+
+// Reset d 不大于 0 时立即触发
+func (timer *mockTimer) Reset(d time.Duration) bool {
+	mock := timer.mock
+	mock.mutex.Lock()
+	_, ok := mock.timers[timer]
+	timer.when = mock.now.Add(d)
+	mock.timers[timer] = struct{}{}
+	now := mock.now
+	mock.mutex.Unlock()
+	if d <= 0 {
+		mock.Set(now)
+	}
+	return ok
+}
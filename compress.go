@@ -1,13 +1,106 @@
 package server
 
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/compress"
+	"github.com/otamoe/gin-server/metrics"
+	"github.com/otamoe/gin-server/respond"
+)
+
 type (
 	Compress struct {
-		Types []string `json:"types,omitempty"`
+		Types     []string `json:"types,omitempty"`
+		MinLength int64    `json:"min_length,omitempty"`
+		GzipLevel int      `json:"gzip_level,omitempty"`
+		BrQuality int      `json:"br_quality,omitempty"`
+		BrLGWin   int      `json:"br_lgwin,omitempty"`
+
+		// 按媒体类型设置 brotli  支持 text/*
+		BrTypes map[string]*CompressBrotli `json:"br_types,omitempty"`
+
+		// 自定义字典文件  客户端需要通过 Available-Dictionary 声明
+		BrDictionary string `json:"br_dictionary,omitempty"`
+
+		types        atomic.Value
+		brDictionary []byte
+	}
+
+	CompressBrotli struct {
+		Quality int `json:"quality,omitempty"`
+		LGWin   int `json:"lgwin,omitempty"`
 	}
 )
 
 func (config *Compress) init(server *Server, handler *Handler) {
 	if config.Types == nil {
-		config.Types = []string{"application/json", "text/plain"}
+		config.Types = append([]string{"application/json", "text/plain"}, respond.CompressTypes...)
+	}
+	if config.MinLength == 0 {
+		config.MinLength = 256
+	}
+	if config.GzipLevel == 0 {
+		config.GzipLevel = gzip.DefaultCompression
+	}
+	if config.BrQuality == 0 {
+		config.BrQuality = 6
+	}
+	if config.BrLGWin == 0 {
+		config.BrLGWin = 19
 	}
+	if config.BrTypes == nil {
+		config.BrTypes = map[string]*CompressBrotli{
+			// json 通常很小  速度优先
+			"application/json": &CompressBrotli{Quality: 4},
+			// sitemap 等大文件  需要大窗口
+			"application/xml":  &CompressBrotli{LGWin: 22},
+			"text/xml":         &CompressBrotli{LGWin: 22},
+			"application/wasm": &CompressBrotli{Quality: 9, LGWin: 22},
+		}
+	}
+	if config.BrDictionary != "" && config.brDictionary == nil {
+		var err error
+		if config.brDictionary, err = ioutil.ReadFile(config.BrDictionary); err != nil {
+			panic(err)
+		}
+	}
+	config.types.Store(config.Types)
+}
+
+func (config *Compress) getTypes() []string {
+	return config.types.Load().([]string)
+}
+
+func (config *Compress) setTypes(types []string) {
+	config.types.Store(types)
+}
+
+func (config *Compress) brTypes() map[string]compress.Brotli {
+	types := make(map[string]compress.Brotli, len(config.BrTypes))
+	for mediatype, val := range config.BrTypes {
+		if val != nil {
+			types[mediatype] = compress.Brotli{
+				Quality: val.Quality,
+				LGWin:   val.LGWin,
+			}
+		}
+	}
+	return types
+}
+
+// middleware registry 为空时不统计压缩率
+func (config *Compress) middleware(registry *metrics.Registry) gin.HandlerFunc {
+	return compress.Middleware(compress.Config{
+		GzipLevel:    config.GzipLevel,
+		MinLength:    config.MinLength,
+		BrLGWin:      config.BrLGWin,
+		BrQuality:    config.BrQuality,
+		BrTypes:      config.brTypes(),
+		BrDictionary: config.brDictionary,
+		GetTypes:     config.getTypes,
+		Registry:     registry,
+	})
 }
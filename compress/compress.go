@@ -2,6 +2,8 @@ package compress
 
 import (
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
 	"io"
 	"io/ioutil"
 	"mime"
@@ -9,29 +11,92 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/brotli/go/cbrotli"
+	"github.com/otamoe/gin-server/metrics"
 )
 
 type (
 	Config struct {
-		Types     []string
+		Types []string
+
+		// 热更新  设置后忽略 Types
+		GetTypes func() []string
+
 		MinLength int64
 		BrQuality int
 		BrLGWin   int
 		GzipLevel int
+
+		// 按媒体类型设置 brotli  支持 text/*  0 使用 BrQuality BrLGWin
+		BrTypes map[string]Brotli
+
+		// 自定义字典  客户端 Available-Dictionary 匹配时使用 dcb 编码
+		BrDictionary []byte
+
+		// 输入输出字节 编码使用次数 压缩耗时  为空不统计
+		Registry *metrics.Registry
+	}
+
+	Brotli struct {
+		Quality int
+		LGWin   int
+	}
+
+	dictionary struct {
+		prepared *cbrotli.PreparedDictionary
+		// dcb 头  magic + sha256
+		header []byte
+		// Available-Dictionary 的值
+		hash string
 	}
+
 	compressWriter struct {
 		gin.ResponseWriter
-		writer   io.Writer
-		request  *http.Request
-		config   Config
-		encoding string
-		gzipPool *sync.Pool
+		writer     io.Writer
+		request    *http.Request
+		config     Config
+		encoding   string
+		gzipPool   *sync.Pool
+		dictionary *dictionary
+
+		stats     *stats
+		mediatype string
+		bytesIn   int64
+		bytesOut  int64
+		elapsed   time.Duration
+	}
+
+	stats struct {
+		responses *metrics.Counter
+		bytesIn   *metrics.Counter
+		bytesOut  *metrics.Counter
+		seconds   *metrics.Counter
+		ratio     *metrics.Histogram
+	}
+
+	// countWriter 统计压缩后的字节
+	countWriter struct {
+		writer *compressWriter
 	}
 )
 
+var dcbMagic = []byte{0xff, 0x44, 0x43, 0x42}
+
+var RatioBuckets = []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}
+
+func newStats(registry *metrics.Registry) *stats {
+	return &stats{
+		responses: registry.Counter("http_compress_responses_total", "Responses by content encoding.", "encoding"),
+		bytesIn:   registry.Counter("http_compress_bytes_in_total", "Bytes before compression.", "encoding", "type"),
+		bytesOut:  registry.Counter("http_compress_bytes_out_total", "Bytes after compression.", "encoding", "type"),
+		seconds:   registry.Counter("http_compress_seconds_total", "Time spent compressing.", "encoding", "type"),
+		ratio:     registry.Histogram("http_compress_ratio", "Compressed size divided by original size.", RatioBuckets, "encoding", "type"),
+	}
+}
+
 func Middleware(config Config) gin.HandlerFunc {
 	gzipPool := &sync.Pool{
 		New: func() interface{} {
@@ -43,17 +108,37 @@ func Middleware(config Config) gin.HandlerFunc {
 		},
 	}
 
+	var dict *dictionary
+	varyValue := "Accept-Encoding"
+	if len(config.BrDictionary) != 0 {
+		sum := sha256.Sum256(config.BrDictionary)
+		dict = &dictionary{
+			prepared: cbrotli.NewPreparedDictionary(config.BrDictionary, cbrotli.DtRaw, config.BrQuality),
+			header:   append(append([]byte{}, dcbMagic...), sum[:]...),
+			hash:     ":" + base64.StdEncoding.EncodeToString(sum[:]) + ":",
+		}
+		varyValue += ", Available-Dictionary"
+	}
+
+	var st *stats
+	if config.Registry != nil {
+		st = newStats(config.Registry)
+	}
+
 	return func(ctx *gin.Context) {
-		encoding := getEncoding(ctx.Request)
+		encoding := getEncoding(ctx.Request, dict)
 		vary := ctx.Writer.Header().Get("Vary")
 		if vary == "" {
-			vary = "Accept-Encoding"
+			vary = varyValue
 		} else {
-			vary += ", Accept-Encoding"
+			vary += ", " + varyValue
 		}
 		ctx.Header("Vary", vary)
 		// 没有编码
 		if encoding == "" {
+			if st != nil {
+				st.responses.Inc("identity")
+			}
 			ctx.Next()
 			return
 		}
@@ -65,6 +150,8 @@ func Middleware(config Config) gin.HandlerFunc {
 			config:         config,
 			encoding:       encoding,
 			gzipPool:       gzipPool,
+			dictionary:     dict,
+			stats:          st,
 		}
 		ctx.Writer = writer
 		defer writer.close()
@@ -72,7 +159,7 @@ func Middleware(config Config) gin.HandlerFunc {
 	}
 }
 
-func getEncoding(req *http.Request) (encoding string) {
+func getEncoding(req *http.Request, dict *dictionary) (encoding string) {
 	if req.Method == http.MethodOptions {
 		return
 	}
@@ -85,6 +172,10 @@ func getEncoding(req *http.Request) (encoding string) {
 
 	for _, val := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
 		val = strings.TrimSpace(val)
+		// 字典优先
+		if val == "dcb" && dict != nil && req.Header.Get("Available-Dictionary") == dict.hash {
+			return val
+		}
 		if val == "br" {
 			encoding = val
 			break
@@ -97,14 +188,21 @@ func getEncoding(req *http.Request) (encoding string) {
 }
 
 func (w *compressWriter) WriteString(data string) (int, error) {
-	return w.writer.Write([]byte(data))
+	return w.Write([]byte(data))
 }
 
 func (w *compressWriter) Write(data []byte) (int, error) {
 	if !w.Written() {
 		w.open(int64(len(data)))
 	}
-	return w.writer.Write(data)
+	if w.stats == nil || w.mediatype == "" {
+		return w.writer.Write(data)
+	}
+	start := time.Now()
+	n, err := w.writer.Write(data)
+	w.elapsed += time.Since(start)
+	w.bytesIn += int64(n)
+	return n, err
 }
 
 func (w *compressWriter) WriteHeader(code int) {
@@ -114,12 +212,22 @@ func (w *compressWriter) WriteHeader(code int) {
 func (w *compressWriter) open(contentLength int64) {
 	header := w.Header()
 
-	// 长度过滤
-	if contentLength == -1 {
-		if val, ok := header["Content-Length"]; ok && len(val) != 0 {
-			if val, err := strconv.ParseInt(val[0], 10, 64); err != nil {
-				contentLength = val
-			}
+	// 已经编码 例如 proxy
+	if header.Get("Content-Encoding") != "" {
+		return
+	}
+
+	// 206 的 Content-Range 是原始内容的范围  不能压缩
+	if w.Status() == http.StatusPartialContent || header.Get("Content-Range") != "" {
+		return
+	}
+
+	// 长度过滤  优先使用 Content-Length  流式输出时为第一次写入的长度
+	var knownLength bool
+	if val, ok := header["Content-Length"]; ok && len(val) != 0 {
+		if val, err := strconv.ParseInt(val[0], 10, 64); err == nil {
+			contentLength = val
+			knownLength = true
 		}
 	}
 
@@ -134,8 +242,16 @@ func (w *compressWriter) open(contentLength int64) {
 		return
 	}
 	mediatype, _, _ := mime.ParseMediaType(contentType[0])
+	// sse 需要及时 flush
+	if mediatype == "text/event-stream" {
+		return
+	}
+	types := w.config.Types
+	if w.config.GetTypes != nil {
+		types = w.config.GetTypes()
+	}
 	var typeMatch bool
-	for _, typ := range w.config.Types {
+	for _, typ := range types {
 		if mediatype == typ {
 			typeMatch = true
 			break
@@ -153,21 +269,48 @@ func (w *compressWriter) open(contentLength int64) {
 		return
 	}
 
+	var output io.Writer = w.ResponseWriter
+	if w.stats != nil {
+		w.mediatype = mediatype
+		output = &countWriter{writer: w}
+	}
+
 	switch w.encoding {
-	case "br":
-		writer := cbrotli.NewWriter(w.ResponseWriter, cbrotli.WriterOptions{
-			Quality: w.config.BrQuality,
-			LGWin:   w.config.BrLGWin,
-		})
-		w.writer = writer
+	case "br", "dcb":
+		options := w.config.brotliOptions(mediatype)
+		// 窗口大于内容长度只浪费内存
+		if knownLength && options.LGWin > 10 {
+			for options.LGWin > 10 && int64(1)<<uint(options.LGWin-1)-16 >= contentLength {
+				options.LGWin--
+			}
+		}
+		if w.encoding == "dcb" {
+			options.Dictionary = w.dictionary.prepared
+			output.Write(w.dictionary.header)
+		}
+		w.writer = cbrotli.NewWriter(output, options)
 	case "gzip":
 		writer := w.gzipPool.Get().(*gzip.Writer)
-		writer.Reset(w.ResponseWriter)
+		writer.Reset(output)
 		w.writer = writer
 	}
 }
 
+// Flush 先 flush 压缩的数据  用于流式输出
+func (w *compressWriter) Flush() {
+	switch writer := w.writer.(type) {
+	case *gzip.Writer:
+		writer.Flush()
+	case *cbrotli.Writer:
+		writer.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close trailer 由 net/http 在 close 之后写入
 func (w *compressWriter) close() {
+	start := time.Now()
+	defer w.observe(start)
 	switch w.writer.(type) {
 	case *gzip.Writer:
 		writer := w.writer.(*gzip.Writer)
@@ -178,3 +321,52 @@ func (w *compressWriter) close() {
 		writer.Close()
 	}
 }
+
+// observe 没有压缩时记为 identity
+func (w *compressWriter) observe(start time.Time) {
+	if w.stats == nil {
+		return
+	}
+	if w.mediatype == "" || w.bytesIn == 0 {
+		w.stats.responses.Inc("identity")
+		return
+	}
+	w.elapsed += time.Since(start)
+	w.stats.responses.Inc(w.encoding)
+	w.stats.bytesIn.Add(float64(w.bytesIn), w.encoding, w.mediatype)
+	w.stats.bytesOut.Add(float64(w.bytesOut), w.encoding, w.mediatype)
+	w.stats.seconds.Add(w.elapsed.Seconds(), w.encoding, w.mediatype)
+	w.stats.ratio.Observe(float64(w.bytesOut)/float64(w.bytesIn), w.encoding, w.mediatype)
+}
+
+// Write 写入连接的时间不算压缩耗时
+func (w *countWriter) Write(data []byte) (int, error) {
+	start := time.Now()
+	n, err := w.writer.ResponseWriter.Write(data)
+	w.writer.elapsed -= time.Since(start)
+	w.writer.bytesOut += int64(n)
+	return n, err
+}
+
+// brotliOptions BrTypes 先匹配媒体类型  再匹配 text/*
+func (config Config) brotliOptions(mediatype string) cbrotli.WriterOptions {
+	options := cbrotli.WriterOptions{
+		Quality: config.BrQuality,
+		LGWin:   config.BrLGWin,
+	}
+	val, ok := config.BrTypes[mediatype]
+	if !ok {
+		if i := strings.IndexByte(mediatype, '/'); i != -1 {
+			val, ok = config.BrTypes[mediatype[:i]+"/*"]
+		}
+	}
+	if ok {
+		if val.Quality != 0 {
+			options.Quality = val.Quality
+		}
+		if val.LGWin != 0 {
+			options.LGWin = val.LGWin
+		}
+	}
+	return options
+}
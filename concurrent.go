@@ -0,0 +1,22 @@
+package server
+
+type (
+	// ConcurrentLimit 按客户端 IP 限制同时处理的请求数 和 连接数  超过返回 429
+	ConcurrentLimit struct {
+		Requests    int64 `json:"requests,omitempty"`
+		Connections int64 `json:"connections,omitempty"`
+
+		// 按网段合并  默认 ipv4 /32  ipv6 /64
+		IPv4Prefix int `json:"ipv4_prefix,omitempty"`
+		IPv6Prefix int `json:"ipv6_prefix,omitempty"`
+	}
+)
+
+func (config *ConcurrentLimit) init(server *Server, handler *Handler) {
+	if config.IPv4Prefix == 0 {
+		config.IPv4Prefix = 32
+	}
+	if config.IPv6Prefix == 0 {
+		config.IPv6Prefix = 64
+	}
+}
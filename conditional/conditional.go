@@ -0,0 +1,190 @@
+package conditional
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	Config struct {
+		// W/ 弱 etag
+		Weak bool
+
+		// 超过后不再缓冲 直接输出
+		MaxSize int
+	}
+
+	conditionalWriter struct {
+		gin.ResponseWriter
+		config      Config
+		buf         bytes.Buffer
+		status      int
+		passthrough bool
+	}
+)
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.MaxSize == 0 {
+		c.MaxSize = 1024 * 1024 * 4
+	}
+
+	return func(ctx *gin.Context) {
+		if ctx.Request.Method != http.MethodGet && ctx.Request.Method != http.MethodHead {
+			ctx.Next()
+			return
+		}
+
+		writer := &conditionalWriter{
+			ResponseWriter: ctx.Writer,
+			config:         c,
+			status:         http.StatusOK,
+		}
+		ctx.Writer = writer
+		ctx.Next()
+		ctx.Writer = writer.ResponseWriter
+		writer.finish(ctx.Request)
+	}
+}
+
+// Match 检查 If-None-Match If-Modified-Since
+func Match(req *http.Request, etag string, lastModified string) bool {
+	if val := req.Header.Get("If-None-Match"); val != "" {
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(val, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if val := req.Header.Get("If-Modified-Since"); val != "" && lastModified != "" {
+		since, err := http.ParseTime(val)
+		if err != nil {
+			return false
+		}
+		modified, err := http.ParseTime(lastModified)
+		if err != nil {
+			return false
+		}
+		return !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+func (w *conditionalWriter) finish(req *http.Request) {
+	if w.passthrough {
+		return
+	}
+	header := w.ResponseWriter.Header()
+
+	if w.status == http.StatusOK {
+		etag := header.Get("ETag")
+		if etag == "" && w.buf.Len() != 0 {
+			sum := sha1.Sum(w.buf.Bytes())
+			etag = "\"" + base64.RawURLEncoding.EncodeToString(sum[:]) + "\""
+			if w.config.Weak {
+				etag = "W/" + etag
+			}
+			header.Set("ETag", etag)
+		}
+
+		if Match(req, etag, header.Get("Last-Modified")) {
+			header.Del("Content-Type")
+			header.Del("Content-Length")
+			header.Del("Content-Encoding")
+			w.ResponseWriter.WriteHeader(http.StatusNotModified)
+			w.ResponseWriter.WriteHeaderNow()
+			return
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() != 0 {
+		w.ResponseWriter.Write(w.buf.Bytes())
+	} else {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+// stream 缓冲的内容先输出 之后直接写入
+func (w *conditionalWriter) stream() {
+	if w.passthrough {
+		return
+	}
+	w.passthrough = true
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.WriteHeaderNow()
+	if w.buf.Len() != 0 {
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+}
+
+func (w *conditionalWriter) WriteHeader(code int) {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *conditionalWriter) WriteHeaderNow() {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *conditionalWriter) Write(data []byte) (int, error) {
+	if !w.passthrough && w.buf.Len()+len(data) > w.config.MaxSize {
+		w.stream()
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.buf.Write(data)
+}
+
+func (w *conditionalWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *conditionalWriter) Status() int {
+	if w.passthrough {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *conditionalWriter) Size() int {
+	if w.passthrough {
+		return w.ResponseWriter.Size()
+	}
+	return w.buf.Len()
+}
+
+func (w *conditionalWriter) Written() bool {
+	return w.passthrough || w.buf.Len() != 0 || w.ResponseWriter.Written()
+}
+
+func (w *conditionalWriter) Flush() {
+	w.stream()
+	w.ResponseWriter.Flush()
+}
+
+func (w *conditionalWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.passthrough = true
+	return w.ResponseWriter.Hijack()
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/otamoe/gin-server/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	Connections struct {
+		// 空闲超过 IdleWarning 的连接输出警告  0 不检查
+		IdleWarning time.Duration `json:"idle_warning,omitempty"`
+
+		// 检查间隔  默认 IdleWarning / 2
+		Interval time.Duration `json:"interval,omitempty"`
+	}
+
+	// connTracker 通过 ConnState 记录连接  用 RemoteAddr 关联请求
+	connTracker struct {
+		mutex    sync.RWMutex
+		conns    map[string]*connInfo
+		metrics  bool
+		gauge    *metrics.Gauge
+		total    *metrics.Counter
+		hijacked *metrics.Counter
+		stop     chan struct{}
+		closed   []func(remoteAddr string)
+	}
+
+	connInfo struct {
+		createdAt time.Time
+		requests  int64
+		state     http.ConnState
+		stateAt   time.Time
+		warned    bool
+	}
+)
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		conns: map[string]*connInfo{},
+	}
+}
+
+// enableMetrics http_connections{state}  http_connections_total  http_connections_hijacked_total
+// hijacked 之后没有 ConnState 通知  只能计数
+func (tracker *connTracker) enableMetrics(registry *metrics.Registry) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.gauge = registry.Gauge("http_connections", "Current HTTP connections by state.", "state")
+	tracker.total = registry.Counter("http_connections_total", "Total accepted HTTP connections.")
+	tracker.hijacked = registry.Counter("http_connections_hijacked_total", "Total hijacked HTTP connections.")
+	tracker.metrics = true
+}
+
+// onClose 连接关闭 或 hijacked 时调用
+func (tracker *connTracker) onClose(callback func(remoteAddr string)) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.closed = append(tracker.closed, callback)
+}
+
+func (tracker *connTracker) connState(conn net.Conn, state http.ConnState) {
+	key := conn.RemoteAddr().String()
+	if state == http.StateHijacked || state == http.StateClosed {
+		tracker.mutex.RLock()
+		closed := tracker.closed
+		tracker.mutex.RUnlock()
+		for _, callback := range closed {
+			callback(key)
+		}
+	}
+
+	now := time.Now()
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	info, ok := tracker.conns[key]
+	if tracker.metrics {
+		if ok {
+			tracker.gauge.Add(-1, info.state.String())
+		}
+		switch state {
+		case http.StateNew:
+			tracker.total.Inc()
+			tracker.gauge.Add(1, state.String())
+		case http.StateHijacked:
+			tracker.hijacked.Inc()
+		case http.StateClosed:
+		default:
+			tracker.gauge.Add(1, state.String())
+		}
+	}
+
+	switch state {
+	case http.StateNew:
+		tracker.conns[key] = &connInfo{
+			createdAt: now,
+			state:     state,
+			stateAt:   now,
+		}
+	case http.StateHijacked, http.StateClosed:
+		delete(tracker.conns, key)
+	default:
+		if !ok {
+			info = &connInfo{createdAt: now}
+			tracker.conns[key] = info
+		}
+		info.state = state
+		info.stateAt = now
+		info.warned = false
+	}
+}
+
+func (tracker *connTracker) get(remoteAddr string) *connInfo {
+	tracker.mutex.RLock()
+	defer tracker.mutex.RUnlock()
+	return tracker.conns[remoteAddr]
+}
+
+// counts 各状态的连接数
+func (tracker *connTracker) counts() map[string]int {
+	tracker.mutex.RLock()
+	defer tracker.mutex.RUnlock()
+	counts := map[string]int{}
+	for _, info := range tracker.conns {
+		counts[info.state.String()]++
+	}
+	return counts
+}
+
+// watch 输出空闲太久的连接
+func (tracker *connTracker) watch(config *Connections, logger *logrus.Logger) {
+	interval := config.Interval
+	if interval == 0 {
+		interval = config.IdleWarning / 2
+	}
+	tracker.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-tracker.stop:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				tracker.mutex.Lock()
+				for key, info := range tracker.conns {
+					if info.warned || info.state != http.StateIdle || now.Sub(info.stateAt) < config.IdleWarning {
+						continue
+					}
+					info.warned = true
+					logger.WithFields(logrus.Fields{
+						"remote_addr": key,
+						"idle":        now.Sub(info.stateAt).String(),
+						"age":         now.Sub(info.createdAt).String(),
+						"requests":    atomic.LoadInt64(&info.requests),
+					}).Warn("Connection idle")
+				}
+				tracker.mutex.Unlock()
+			}
+		}
+	}()
+}
+
+func (tracker *connTracker) close() {
+	if tracker.stop != nil {
+		close(tracker.stop)
+		tracker.stop = nil
+	}
+}
@@ -0,0 +1,18 @@
+package server
+
+type (
+	Cors struct {
+		Origins       []string `json:"origins,omitempty"`
+		Methods       []string `json:"methods,omitempty"`
+		Headers       []string `json:"headers,omitempty"`
+		ExposeHeaders []string `json:"expose_headers,omitempty"`
+		Credentials   bool     `json:"credentials,omitempty"`
+		MaxAge        int      `json:"max_age,omitempty"`
+	}
+)
+
+func (config *Cors) init(server *Server, handler *Handler) {
+	if config.MaxAge == 0 {
+		config.MaxAge = 86400
+	}
+}
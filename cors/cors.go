@@ -10,25 +10,117 @@ import (
 
 type (
 	Config struct {
-		Origins []string
-		MaxAge  int
+		// 支持 * 和 *.example.com  * 不反射 Origin  不发送 Credentials
+		Origins       []string
+		Methods       []string
+		Headers       []string
+		ExposeHeaders []string
+		Credentials   bool
+		MaxAge        int
 	}
 )
 
+var CONTEXT = "GIN.SERVER.CORS"
+
+var (
+	DefaultMethods       = []string{"HEAD", "GET", "PUT", "PATCH", "POST", "DELETE"}
+	DefaultHeaders       = []string{"Content-Type", "Authorization", "Range", "If-Match", "If-Modified-Since", "If-None-Match", "If-Range", "If-Unmodified-Since"}
+	DefaultExposeHeaders = []string{"Accept-Ranges", "Content-Range", "Content-Length", "Content-Disposition", "ETag", "Date"}
+)
+
+// Middleware 路由上再次使用会覆盖 host 的配置
 func Middleware(c Config) gin.HandlerFunc {
+	if len(c.Methods) == 0 {
+		c.Methods = DefaultMethods
+	}
+	if len(c.Headers) == 0 {
+		c.Headers = DefaultHeaders
+	}
+	if len(c.ExposeHeaders) == 0 {
+		c.ExposeHeaders = DefaultExposeHeaders
+	}
+	methods := strings.Join(c.Methods, ", ")
+	headers := strings.Join(c.Headers, ", ")
+	exposeHeaders := strings.Join(c.ExposeHeaders, ", ")
+	maxAge := strconv.Itoa(c.MaxAge)
+
 	return func(ctx *gin.Context) {
-		if len(c.Origins) != 0 {
-			ctx.Header("Access-Control-Allow-Methods", "HEAD, GET, PUT, PATCH, POST, DELETE")
-			ctx.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, Range, If-Match, If-Modified-Since, If-None-Match, If-Range, If-Unmodified-Since")
-			ctx.Header("Access-Control-Expose-Headers", "Accept-Ranges, Content-Range, Content-Length, Content-Disposition, ETag, Date")
-
-			ctx.Header("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
-			ctx.Header("Access-Control-Allow-Origin", strings.Join(c.Origins, ","))
-			if ctx.Request.Method == http.MethodOptions {
-				ctx.AbortWithStatus(http.StatusOK)
+		ctx.Set(CONTEXT, c)
+
+		header := ctx.Writer.Header()
+		header.Del("Access-Control-Allow-Origin")
+		header.Del("Access-Control-Allow-Credentials")
+		header.Del("Access-Control-Allow-Methods")
+		header.Del("Access-Control-Allow-Headers")
+		header.Del("Access-Control-Expose-Headers")
+		header.Del("Access-Control-Max-Age")
+
+		origin := ctx.GetHeader("Origin")
+		if origin == "" || len(c.Origins) == 0 {
+			ctx.Next()
+			return
+		}
+		addVary(header, "Origin")
+
+		allowOrigin, ok := c.allow(origin)
+		if !ok {
+			ctx.Next()
+			return
+		}
+
+		header.Set("Access-Control-Allow-Origin", allowOrigin)
+		if c.Credentials && allowOrigin != "*" {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// 预检
+		if ctx.Request.Method == http.MethodOptions && ctx.GetHeader("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", methods)
+			header.Set("Access-Control-Allow-Headers", headers)
+			header.Set("Access-Control-Max-Age", maxAge)
+			ctx.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		header.Set("Access-Control-Expose-Headers", exposeHeaders)
+		ctx.Next()
+	}
+}
+
+func (c Config) allow(origin string) (string, bool) {
+	lower := strings.ToLower(origin)
+	host := lower
+	if index := strings.Index(host, "://"); index != -1 {
+		host = host[index+3:]
+	}
+	for _, val := range c.Origins {
+		val = strings.ToLower(strings.TrimSpace(val))
+		switch {
+		case val == "*":
+			return "*", true
+		case val == lower, val == host:
+			return origin, true
+		case strings.HasPrefix(val, "*."):
+			if strings.HasSuffix(host, val[1:]) {
+				return origin, true
+			}
+		case strings.Contains(val, "://*."):
+			index := strings.Index(val, "://*.")
+			if strings.HasPrefix(lower, val[:index+3]) && strings.HasSuffix(lower, val[index+4:]) {
+				return origin, true
+			}
+		}
+	}
+	return "", false
+}
+
+func addVary(header http.Header, value string) {
+	for _, val := range header["Vary"] {
+		for _, v := range strings.Split(val, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), value) {
 				return
 			}
 		}
-		ctx.Next()
 	}
+	header.Add("Vary", value)
 }
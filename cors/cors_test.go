@@ -0,0 +1,44 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMiddlewareOrigins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name        string
+		config      Config
+		origin      string
+		allow       string
+		credentials string
+	}{
+		{"wildcard", Config{Origins: []string{"*"}}, "https://evil.example", "*", ""},
+		// * 和 Credentials 一起时不反射 Origin
+		{"wildcard credentials", Config{Origins: []string{"*"}, Credentials: true}, "https://evil.example", "*", ""},
+		{"exact credentials", Config{Origins: []string{"https://app.example.com"}, Credentials: true}, "https://app.example.com", "https://app.example.com", "true"},
+		{"subdomain", Config{Origins: []string{"*.example.com"}, Credentials: true}, "https://app.example.com", "https://app.example.com", "true"},
+		{"not allowed", Config{Origins: []string{"https://app.example.com"}, Credentials: true}, "https://evil.example", "", ""},
+	}
+	for _, test := range tests {
+		engine := gin.New()
+		engine.Use(Middleware(test.config))
+		engine.GET("/", func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Origin", test.origin)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		if got := recorder.Header().Get("Access-Control-Allow-Origin"); got != test.allow {
+			t.Errorf("%s: Access-Control-Allow-Origin %q, want %q", test.name, got, test.allow)
+		}
+		if got := recorder.Header().Get("Access-Control-Allow-Credentials"); got != test.credentials {
+			t.Errorf("%s: Access-Control-Allow-Credentials %q, want %q", test.name, got, test.credentials)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	Config struct {
+		// 有 Secret 时 token 带签名
+		Secret []byte
+
+		CookieName string
+		HeaderName string
+		FormName   string
+
+		Path     string
+		Domain   string
+		Secure   bool
+		MaxAge   int
+		SameSite http.SameSite
+
+		// 响应头返回 token
+		ExposeHeader bool
+
+		Skip func(ctx *gin.Context) bool
+	}
+)
+
+var CONTEXT = "GIN.SERVER.CSRF"
+
+var ErrInvalid = &errs.Error{
+	Message:    "Invalid CSRF token",
+	Type:       "csrf",
+	StatusCode: http.StatusForbidden,
+}
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.CookieName == "" {
+		c.CookieName = "csrf_token"
+	}
+	if c.HeaderName == "" {
+		c.HeaderName = "X-CSRF-Token"
+	}
+	if c.FormName == "" {
+		c.FormName = "_csrf"
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = 86400 * 7
+	}
+	if c.SameSite == 0 {
+		c.SameSite = http.SameSiteLaxMode
+	}
+
+	return func(ctx *gin.Context) {
+		if c.Skip != nil && c.Skip(ctx) {
+			ctx.Next()
+			return
+		}
+
+		token, _ := ctx.Cookie(c.CookieName)
+		if token != "" && !c.valid(token) {
+			token = ""
+		}
+
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		default:
+			value := ctx.GetHeader(c.HeaderName)
+			if value == "" {
+				value = ctx.PostForm(c.FormName)
+			}
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(value)) != 1 {
+				ctx.Error(ErrInvalid)
+				ctx.Abort()
+				return
+			}
+		}
+
+		if token == "" {
+			token = c.generate()
+			http.SetCookie(ctx.Writer, &http.Cookie{
+				Name:     c.CookieName,
+				Value:    token,
+				Path:     c.Path,
+				Domain:   c.Domain,
+				MaxAge:   c.MaxAge,
+				Secure:   c.Secure,
+				HttpOnly: false,
+				SameSite: c.SameSite,
+			})
+		}
+
+		if c.ExposeHeader {
+			ctx.Header(c.HeaderName, token)
+		}
+		ctx.Set(CONTEXT, token)
+		ctx.Next()
+	}
+}
+
+// Token 用于模板
+func Token(ctx *gin.Context) string {
+	return ctx.GetString(CONTEXT)
+}
+
+func (c Config) generate() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if len(c.Secret) != 0 {
+		token += "." + c.sign(token)
+	}
+	return token
+}
+
+func (c Config) valid(token string) bool {
+	if len(c.Secret) == 0 {
+		return true
+	}
+	index := strings.LastIndex(token, ".")
+	if index == -1 {
+		return false
+	}
+	return hmac.Equal([]byte(token[index+1:]), []byte(c.sign(token[:index])))
+}
+
+func (c Config) sign(value string) string {
+	mac := hmac.New(sha256.New, c.Secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/deprecation"
+	"github.com/otamoe/gin-server/metrics"
+)
+
+type (
+	// Deprecation 废弃的路由  输出 Deprecation Sunset Link 头
+	Deprecation struct {
+		// 路由名 type.action 或 type
+		Routes map[string]*DeprecatedRoute `json:"routes,omitempty"`
+	}
+
+	DeprecatedRoute struct {
+		// RFC 3339  例如 "2020-01-01T00:00:00Z"
+		Date   time.Time `json:"date,omitempty"`
+		Sunset time.Time `json:"sunset,omitempty"`
+
+		// 路由名 type.action 或 URL
+		Successor string `json:"successor,omitempty"`
+		Link      string `json:"link,omitempty"`
+
+		// sunset 之后返回 410
+		Gone bool `json:"gone,omitempty"`
+	}
+)
+
+func (config *Deprecation) init(server *Server, handler *Handler) {
+}
+
+func (config *Deprecation) middleware(handler *Handler) gin.HandlerFunc {
+	routes := map[string]*deprecation.Route{}
+	for name, val := range config.Routes {
+		routes[name] = &deprecation.Route{
+			Date:      val.Date,
+			Sunset:    val.Sunset,
+			Successor: val.Successor,
+			Link:      val.Link,
+			Gone:      val.Gone,
+		}
+	}
+	var registry *metrics.Registry
+	if handler.Metrics != nil {
+		registry = metrics.Default
+	}
+	return deprecation.Middleware(deprecation.Config{
+		Routes:   routes,
+		Registry: registry,
+	})
+}
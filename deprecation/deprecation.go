@@ -0,0 +1,121 @@
+package deprecation
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/links"
+	"github.com/otamoe/gin-server/metrics"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	Config struct {
+		// 路由名 type.action 或 type
+		Routes map[string]*Route
+
+		// 为 nil 时不统计
+		Registry *metrics.Registry
+	}
+
+	Route struct {
+		// 废弃时间  为空时 Deprecation: true
+		Date time.Time
+
+		// 下线时间
+		Sunset time.Time
+
+		// 替代的路由名 type.action  或 URL
+		Successor string
+
+		// 说明文档
+		Link string
+
+		// Sunset 之后返回 410
+		Gone bool
+	}
+)
+
+var CONTEXT = "GIN.SERVER.DEPRECATION"
+
+var ErrGone = &errs.Error{
+	Message:    "This endpoint has been retired",
+	Type:       "deprecation",
+	StatusCode: http.StatusGone,
+}
+
+func Middleware(c Config) gin.HandlerFunc {
+	var counter *metrics.Counter
+	if c.Registry != nil {
+		counter = c.Registry.Counter("http_deprecated_requests_total", "Total HTTP requests to deprecated routes.", "type", "action", "sunset")
+	}
+	return func(ctx *gin.Context) {
+		val, ok := ctx.Get(ginResource.CONTEXT)
+		if !ok || val == nil {
+			ctx.Next()
+			return
+		}
+		resource := val.(*ginResource.Resource)
+		route, ok := c.Routes[resource.Name()]
+		if !ok {
+			route, ok = c.Routes[resource.Type]
+		}
+		if !ok || route == nil {
+			ctx.Next()
+			return
+		}
+		ctx.Set(CONTEXT, route)
+
+		now := time.Now()
+		sunset := !route.Sunset.IsZero() && !now.Before(route.Sunset)
+		if counter != nil {
+			counter.Inc(resource.Type, resource.Action, strconv.FormatBool(sunset))
+		}
+
+		if route.Date.IsZero() {
+			ctx.Header("Deprecation", "true")
+		} else {
+			// RFC 9745
+			ctx.Header("Deprecation", "@"+strconv.FormatInt(route.Date.Unix(), 10))
+		}
+		if !route.Sunset.IsZero() {
+			ctx.Header("Sunset", route.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if route.Link != "" {
+			links.Href(ctx, "deprecation", route.Link)
+		}
+		if route.Successor != "" {
+			if strings.Contains(route.Successor, "/") {
+				links.Href(ctx, "successor-version", route.Successor)
+			} else {
+				// 路由名  参数使用当前请求的
+				var params []interface{}
+				for _, param := range ctx.Params {
+					params = append(params, param.Value)
+				}
+				links.Route(ctx, "successor-version", route.Successor, params...)
+			}
+		}
+
+		if sunset && route.Gone {
+			e := ErrGone.Clone()
+			e.Params = map[string]interface{}{"sunset": route.Sunset}
+			ctx.Error(e)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// Get 当前请求废弃的信息  没有废弃为 nil
+func Get(ctx *gin.Context) *Route {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Route)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"time"
+
+	"github.com/otamoe/gin-server/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// dialRetry 指数退避加随机抖动  超过 timeout 返回最后一个错误  timeout 为 0 只执行一次
+func dialRetry(logger *logrus.Logger, name string, timeout time.Duration, dial func() error) (err error) {
+	deadline := time.Now().Add(timeout)
+	delay := time.Millisecond * 200
+	for attempt := 1; ; attempt++ {
+		if err = dial(); err == nil {
+			if attempt > 1 {
+				logger.Infof("[DIAL] %s connected after %d attempts", name, attempt)
+			}
+			return
+		}
+		if timeout <= 0 {
+			return
+		}
+		wait := delay/2 + time.Duration(utils.RandInt64(int64(delay/2)+1))
+		if remaining := time.Until(deadline); remaining <= 0 {
+			logger.Errorf("[DIAL] %s attempt %d: %s, giving up", name, attempt, err)
+			return
+		} else if wait > remaining {
+			wait = remaining
+		}
+		logger.Warnf("[DIAL] %s attempt %d: %s, retry in %s", name, attempt, err, wait)
+		time.Sleep(wait)
+		if delay *= 2; delay > time.Second*10 {
+			delay = time.Second * 10
+		}
+	}
+}
+
+func (server *Server) dialLogger() *logrus.Logger {
+	if server != nil && server.Logger != nil && server.Logger.Get() != nil {
+		return server.Logger.Get()
+	}
+	return logrus.StandardLogger()
+}
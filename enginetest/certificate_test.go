@@ -0,0 +1,21 @@
+package enginetest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+)
+
+func TestCertificate(t *testing.T) {
+	pair, err := tls.X509KeyPair([]byte(Certificate.Certificate), []byte(Certificate.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cert.VerifyHostname("localhost"); err != nil {
+		t.Fatal(err)
+	}
+}
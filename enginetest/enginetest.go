@@ -0,0 +1,177 @@
+package enginetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	server "github.com/otamoe/gin-server"
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	// Engine test env 的 server  日志写入 Logs
+	Engine struct {
+		Server  *server.Server
+		Logs    *server.LogBuffer
+		Handler http.Handler
+		t       testing.TB
+	}
+
+	Response struct {
+		*httptest.ResponseRecorder
+		t testing.TB
+	}
+
+	// Envelope errs 中间件输出的错误格式
+	Envelope struct {
+		ErrorsText string        `json:"errors_text"`
+		StatusCode int           `json:"status_code"`
+		Errors     []*errs.Error `json:"errors"`
+	}
+)
+
+// New 设置 TEST_MONGO_URL 时连接 mongo  未设置 TEST_REDIS_URL 时使用内嵌 miniredis  configure 在 Init 之前执行
+func New(t testing.TB, configure func(srv *server.Server)) *Engine {
+	srv := &server.Server{
+		ENV:  "test",
+		Name: "test",
+	}
+	if val := os.Getenv("TEST_MONGO_URL"); val != "" {
+		srv.Mongo = &server.Mongo{URLs: strings.Split(val, ",")}
+	}
+	if val := os.Getenv("TEST_REDIS_URL"); val != "" {
+		srv.Redis = &server.Redis{URLs: strings.Split(val, ",")}
+	} else {
+		srv.Redis = &server.Redis{}
+	}
+	if configure != nil {
+		configure(srv)
+	}
+	if err := srv.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	srv.Init()
+
+	for _, handler := range srv.Handlers {
+		srv.Get(handler.Name, false)
+	}
+
+	return &Engine{
+		Server:  srv,
+		Logs:    srv.Logs(),
+		Handler: srv.GetHttpServer().Handler,
+		t:       t,
+	}
+}
+
+// Request target 为完整 url 用于匹配 host  body 为 string []byte io.Reader 或 json
+func (engine *Engine) Request(method string, target string, body interface{}, headers ...http.Header) *Response {
+	var reader io.Reader
+	var contentType string
+	switch val := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(val)
+	case []byte:
+		reader = bytes.NewReader(val)
+	case io.Reader:
+		reader = val
+	default:
+		data, err := json.Marshal(val)
+		if err != nil {
+			engine.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	req := httptest.NewRequest(method, target, reader)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for _, header := range headers {
+		for key, values := range header {
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+	}
+	return engine.Do(req)
+}
+
+func (engine *Engine) Do(req *http.Request) *Response {
+	recorder := httptest.NewRecorder()
+	engine.Handler.ServeHTTP(recorder, req)
+	return &Response{
+		ResponseRecorder: recorder,
+		t:                engine.t,
+	}
+}
+
+// Close 关闭内嵌的 miniredis  一般 defer engine.Close()
+func (engine *Engine) Close() {
+	for _, handler := range engine.Server.Handlers {
+		if handler.Redis != nil && handler.Redis != engine.Server.Redis {
+			handler.Redis.Close()
+		}
+	}
+	if engine.Server.Redis != nil {
+		engine.Server.Redis.Close()
+	}
+}
+
+// Status 断言状态码
+func (response *Response) Status(code int) *Response {
+	response.t.Helper()
+	if response.Code != code {
+		response.t.Fatalf("status %d, want %d: %s", response.Code, code, response.Body.String())
+	}
+	return response
+}
+
+func (response *Response) HasHeader(key string, value string) *Response {
+	response.t.Helper()
+	if got := response.Result().Header.Get(key); got != value {
+		response.t.Fatalf("header %s %q, want %q", key, got, value)
+	}
+	return response
+}
+
+func (response *Response) JSON(data interface{}) *Response {
+	response.t.Helper()
+	if err := json.Unmarshal(response.Body.Bytes(), data); err != nil {
+		response.t.Fatalf("invalid json %s: %s", err, response.Body.String())
+	}
+	return response
+}
+
+// Envelope 解析错误格式
+func (response *Response) Envelope() *Envelope {
+	response.t.Helper()
+	envelope := &Envelope{}
+	response.JSON(envelope)
+	if len(envelope.Errors) == 0 {
+		response.t.Fatalf("no errors: %s", response.Body.String())
+	}
+	return envelope
+}
+
+// Error 断言状态码 和包含 type 的错误  path 为空时不比较
+func (response *Response) Error(code int, typ string, path string) *errs.Error {
+	response.t.Helper()
+	response.Status(code)
+	envelope := response.Envelope()
+	for _, err := range envelope.Errors {
+		if err.Type == typ && (path == "" || err.Path == path) {
+			return err
+		}
+	}
+	response.t.Fatalf("error type %s path %s not found: %s", typ, path, response.Body.String())
+	return nil
+}
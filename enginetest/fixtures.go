@@ -0,0 +1,120 @@
+package enginetest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// LoadFixtures dir 中每个 <collection>.json 是文档数组  支持 $oid $date  redis.json 是 key => value
+func (engine *Engine) LoadFixtures(dir string) {
+	engine.t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		engine.t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			engine.t.Fatal(err)
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		if name == "redis" {
+			engine.loadRedis(file, data)
+			continue
+		}
+		engine.loadMongo(file, name, data)
+	}
+}
+
+func (engine *Engine) loadMongo(file string, name string, data []byte) {
+	engine.t.Helper()
+	if engine.Server.Mongo == nil {
+		engine.t.Fatalf("%s: mongo is empty  set TEST_MONGO_URL", file)
+	}
+	var docs []bson.M
+	if err := bson.UnmarshalJSON(data, &docs); err != nil {
+		engine.t.Fatalf("%s: %s", file, err)
+	}
+	if len(docs) == 0 {
+		return
+	}
+	values := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		values[i] = doc
+	}
+	session := engine.Server.Mongo.Get()
+	defer session.Close()
+	collection := session.DB("").C(engine.Server.Mongo.CollectionPrefix() + name)
+	if err := collection.Insert(values...); err != nil {
+		engine.t.Fatalf("%s: %s", file, err)
+	}
+}
+
+func (engine *Engine) loadRedis(file string, data []byte) {
+	engine.t.Helper()
+	var values map[string]string
+	if err := bson.UnmarshalJSON(data, &values); err != nil {
+		engine.t.Fatalf("%s: %s", file, err)
+	}
+	client := engine.Server.Redis.Get()
+	defer client.Close()
+	prefix := engine.Server.Redis.KeyPrefix()
+	for key, val := range values {
+		if err := client.Set(prefix+key, val, 0).Err(); err != nil {
+			engine.t.Fatalf("%s: %s", file, err)
+		}
+	}
+}
+
+// ResetDatabase 清空集合 (保留索引) 和 redis  有前缀时只处理前缀下的
+func (engine *Engine) ResetDatabase() {
+	engine.t.Helper()
+	if engine.Server.Mongo != nil {
+		session := engine.Server.Mongo.Get()
+		defer session.Close()
+		db := session.DB("")
+		names, err := db.CollectionNames()
+		if err != nil {
+			engine.t.Fatal(err)
+		}
+		prefix := engine.Server.Mongo.CollectionPrefix()
+		for _, name := range names {
+			if strings.HasPrefix(name, "system.") || !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if _, err := db.C(name).RemoveAll(nil); err != nil {
+				engine.t.Fatal(err)
+			}
+		}
+	}
+
+	if engine.Server.Redis != nil {
+		client := engine.Server.Redis.Get()
+		defer client.Close()
+		prefix := engine.Server.Redis.KeyPrefix()
+		if prefix == "" {
+			if err := client.FlushDB().Err(); err != nil {
+				engine.t.Fatal(err)
+			}
+			return
+		}
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(cursor, prefix+"*", 1000).Result()
+			if err != nil {
+				engine.t.Fatal(err)
+			}
+			if len(keys) != 0 {
+				if err := client.Del(keys...).Err(); err != nil {
+					engine.t.Fatal(err)
+				}
+			}
+			if cursor = next; cursor == 0 {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package enginetest
+
+import (
+	"github.com/alicebob/miniredis"
+	server "github.com/otamoe/gin-server"
+)
+
+// 只有引用 enginetest 的测试链接 miniredis
+func init() {
+	server.EmbeddedRedis = func() (addr string, close func(), err error) {
+		var embedded *miniredis.Miniredis
+		if embedded, err = miniredis.Run(); err != nil {
+			return
+		}
+		addr = embedded.Addr()
+		close = embedded.Close
+		return
+	}
+}
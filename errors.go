@@ -0,0 +1,16 @@
+package server
+
+type (
+	Errors struct {
+		// json text jsonapi
+		Format string `json:"format,omitempty"`
+	}
+)
+
+func (config *Errors) init(server *Server, handler *Handler) {
+	switch config.Format {
+	case "text", "jsonapi":
+	default:
+		config.Format = "json"
+	}
+}
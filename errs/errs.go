@@ -2,7 +2,6 @@ package errs
 
 import (
 	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -14,13 +13,26 @@ import (
 	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/jsoncodec"
 	validator9 "gopkg.in/go-playground/validator.v9"
 )
 
 type (
+	Config struct {
+		// json text jsonapi
+		Format string
+
+		// 每个有错误的请求  在 context 的 callback 之前  例如 5xx 通知
+		Callback func(ctx *gin.Context, errs *Errors)
+
+		// Wrap 的字段输出到响应  开发环境使用
+		Debug bool
+	}
+
 	Errors struct {
 		Errors     []*Error               `json:"errors"`
 		StatusCode int                    `json:"status_code,omitempty"`
+		RequestID  string                 `json:"request_id,omitempty"`
 		Maps       map[string]interface{} `json:"-"`
 	}
 
@@ -39,12 +51,21 @@ type (
 
 var CONTEXT_CALLBACK = "GIN.SERVER.ERRORS.CALLBACK"
 
+// CONTEXT_REQUEST_ID tracing 设置为 trace id  为空时使用 X-Request-Id
+var CONTEXT_REQUEST_ID = "GIN.SERVER.ERRORS.REQUEST_ID"
+
+// func(message string, params map[string]interface{}) string
+var CONTEXT_TRANSLATE = "GIN.SERVER.ERRORS.TRANSLATE"
+
 func (b *Errors) JSON() map[string]interface{} {
 	json := map[string]interface{}{
 		"errors_text": b.String(),
 		"status_code": b.StatusCode,
 		"errors":      b.Errors,
 	}
+	if b.RequestID != "" {
+		json["request_id"] = b.RequestID
+	}
 	for _, e := range b.Errors {
 		for k, v := range e.Maps {
 			json[k] = v
@@ -56,6 +77,41 @@ func (b *Errors) JSON() map[string]interface{} {
 	return json
 }
 
+// JSONAPI https://jsonapi.org/format/#errors
+func (b *Errors) JSONAPI() map[string]interface{} {
+	var values []map[string]interface{}
+	for _, e := range b.Errors {
+		statusCode := e.StatusCode
+		if statusCode == 0 {
+			statusCode = b.StatusCode
+		}
+		value := map[string]interface{}{
+			"status": strconv.Itoa(statusCode),
+			"detail": e.Error(),
+		}
+		if e.Type != "" {
+			value["code"] = e.Type
+		}
+		if e.Name != "" {
+			value["title"] = e.Name
+		}
+		if e.Path != "" {
+			value["source"] = map[string]string{"pointer": "/data/attributes/" + strings.Replace(e.Path, ".", "/", -1)}
+		}
+		if len(e.Params) != 0 {
+			value["meta"] = e.Params
+		}
+		values = append(values, value)
+	}
+	json := map[string]interface{}{
+		"errors": values,
+	}
+	if b.RequestID != "" {
+		json["meta"] = map[string]string{"request_id": b.RequestID}
+	}
+	return json
+}
+
 func (b *Errors) Error() string {
 	var errorsText []string
 	for _, e := range b.Errors {
@@ -69,7 +125,7 @@ func (b *Errors) String() string {
 }
 
 func (b *Errors) MarshalJSON() ([]byte, error) {
-	return json.Marshal(b.JSON())
+	return jsoncodec.Marshal(b.JSON())
 }
 
 func (b *Errors) addStatusCode(statusCode int) {
@@ -125,7 +181,7 @@ func (e *Error) JSON() map[string]interface{} {
 }
 
 func (e *Error) MarshalJSON() ([]byte, error) {
-	return json.Marshal(e.JSON())
+	return jsoncodec.Marshal(e.JSON())
 }
 
 func (e Error) Error() string {
@@ -236,11 +292,117 @@ func getValueSubstr(value string) string {
 	return value
 }
 
+// ValidationErrors 转换为 Error 列表
+func ValidationErrors(validationErrors validator9.ValidationErrors, statusCode int) (errs []*Error) {
+	for _, fieldError := range validationErrors {
+		value := fieldError.Value()
+		tag := fieldError.Tag()
+		params := gin.H{}
+		switch tag {
+		case "required",
+			"alpha",
+			"alphanum",
+			"numeric",
+			"hexadecimal",
+			"hexcolor",
+			"rgb",
+			"rgba",
+			"hsl",
+			"hsla",
+			"email",
+			"url",
+			"uri",
+			"base64",
+			"isbn",
+			"isbn10",
+			"isbn13",
+			"uuid",
+			"uuid3",
+			"uuid4",
+			"uuid5",
+			"ascii",
+			"asciiprint",
+			"multibyte",
+			"datauri",
+			"latitude",
+			"longitude",
+			"ssn",
+			"ip",
+			"ipv4",
+			"ipv6",
+			"cidr",
+			"cidrv4",
+			"cidrv6",
+			"tcp_addr",
+			"tcp4_addr",
+			"tcp6_addr",
+			"udp_addr",
+			"udp4_addr",
+			"udp6_addr",
+			"ip_addr",
+			"ip4_addr",
+			"ip6_addr",
+			"unix_addr",
+			"mac",
+			"iscolor",
+
+			"objectid",
+			"dnsname":
+		case "max",
+			"min",
+			"len":
+			if param, e := strconv.Atoi(fieldError.Param()); e == nil {
+				params[tag] = param
+			} else {
+				params[tag] = 0
+			}
+		case "eq",
+			"ne",
+			"gt",
+			"gte",
+			"lt",
+			"lte":
+			if reflect.TypeOf(value).Kind() == reflect.String {
+				params[tag] = fieldError.Param()
+			} else if param, e := strconv.Atoi(fieldError.Param()); e == nil {
+				params[tag] = param
+			} else {
+				params[tag] = fieldError.Param()
+			}
+		default:
+			params[tag] = fieldError.Param()
+		}
+		path := fieldError.Namespace()
+		if index := strings.Index(path, "."); index != -1 {
+			path = path[index+1:]
+		}
+		errs = append(errs, &Error{
+			Message:    fieldError.Translate(nil),
+			Name:       "validation",
+			Type:       tag,
+			Path:       toNameUnderline(path),
+			Value:      getValue(value),
+			StatusCode: statusCode,
+			Params:     params,
+		})
+	}
+	return
+}
+
+// Middleware 默认配置
 func Middleware() gin.HandlerFunc {
+	return MiddlewareWithConfig(Config{})
+}
+
+func MiddlewareWithConfig(c Config) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		defer func() {
 			// 恢复线程
 			if err := recover(); err != nil {
+				// 中断连接 交给 net/http
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
 				stack := stack(3)
 				switch err.(type) {
 				case string:
@@ -258,6 +420,7 @@ func Middleware() gin.HandlerFunc {
 
 			errs := &Errors{}
 			errs.StatusCode = ctx.Writer.Status()
+			errs.RequestID = RequestID(ctx)
 
 			for _, val := range ctx.Errors {
 				switch val.Err.(type) {
@@ -266,106 +429,30 @@ func Middleware() gin.HandlerFunc {
 					errs.Errors = append(errs.Errors, e)
 					errs.addStatusCode(e.StatusCode)
 					errs.setMeta(val.Meta)
+				case *Wrapped:
+					e := c.wrapped(val.Err.(*Wrapped))
+					errs.Errors = append(errs.Errors, e)
+					errs.addStatusCode(e.StatusCode)
+					errs.setMeta(val.Meta)
 				case validator9.ValidationErrors:
-					validationErrors := val.Err.(validator9.ValidationErrors)
-					for _, fieldError := range validationErrors {
-						value := fieldError.Value()
-						tag := fieldError.Tag()
-						params := gin.H{}
-						switch tag {
-						case "required",
-							"alpha",
-							"alphanum",
-							"numeric",
-							"hexadecimal",
-							"hexcolor",
-							"rgb",
-							"rgba",
-							"hsl",
-							"hsla",
-							"email",
-							"url",
-							"uri",
-							"base64",
-							"isbn",
-							"isbn10",
-							"isbn13",
-							"uuid",
-							"uuid3",
-							"uuid4",
-							"uuid5",
-							"ascii",
-							"asciiprint",
-							"multibyte",
-							"datauri",
-							"latitude",
-							"longitude",
-							"ssn",
-							"ip",
-							"ipv4",
-							"ipv6",
-							"cidr",
-							"cidrv4",
-							"cidrv6",
-							"tcp_addr",
-							"tcp4_addr",
-							"tcp6_addr",
-							"udp_addr",
-							"udp4_addr",
-							"udp6_addr",
-							"ip_addr",
-							"ip4_addr",
-							"ip6_addr",
-							"unix_addr",
-							"mac",
-							"iscolor",
-
-							"objectid",
-							"dnsname":
-						case "max",
-							"min",
-							"len":
-							if param, e := strconv.Atoi(fieldError.Param()); e == nil {
-								params[tag] = param
-							} else {
-								params[tag] = 0
-							}
-						case "eq",
-							"ne",
-							"gt",
-							"gte",
-							"lt",
-							"lte":
-							if reflect.TypeOf(value).Kind() == reflect.String {
-								params[tag] = fieldError.Param()
-							} else if param, e := strconv.Atoi(fieldError.Param()); e == nil {
-								params[tag] = param
-							} else {
-								params[tag] = fieldError.Param()
-							}
-						default:
-							params[tag] = fieldError.Param()
-						}
-						path := fieldError.Namespace()
-						if index := strings.Index(path, "."); index != -1 {
-							path = path[index+1:]
-						}
-						errs.Errors = append(errs.Errors, &Error{
-							Message:    fieldError.Translate(nil),
-							Name:       "validation",
-							Type:       tag,
-							Path:       toNameUnderline(path),
-							Value:      getValue(value),
-							StatusCode: http.StatusBadRequest,
-							Params:     params,
-						})
-					}
+					errs.Errors = append(errs.Errors, ValidationErrors(val.Err.(validator9.ValidationErrors), http.StatusBadRequest)...)
 					errs.addStatusCode(http.StatusBadRequest)
 					errs.setMeta(val.Meta)
 				default:
-					errs.Errors = append(errs.Errors, &Error{
+					e := &Error{
 						Err: val.Err,
-					})
+					}
+					if c.Debug {
+						// panic 的 stack 在 meta
+						stack := Stack(val.Err)
+						if meta, ok := val.Meta.(string); ok && stack == "" {
+							stack = meta
+						}
+						if stack != "" {
+							e.Params = map[string]interface{}{"stack": stack}
+						}
+					}
+					errs.Errors = append(errs.Errors, e)
 					errs.setMeta(val.Meta)
 				}
 			}
@@ -374,19 +461,55 @@ func Middleware() gin.HandlerFunc {
 				errs.StatusCode = http.StatusInternalServerError
 			}
 
+			// 翻译
+			if val, ok := ctx.Get(CONTEXT_TRANSLATE); ok && val != nil {
+				if translate, ok := val.(func(string, map[string]interface{}) string); ok {
+					for i, e := range errs.Errors {
+						if e.Message == "" {
+							continue
+						}
+						e = e.Clone()
+						e.Message = translate(e.Message, e.Params)
+						errs.Errors[i] = e
+					}
+				}
+			}
+
 			// callback
+			if c.Callback != nil {
+				c.Callback(ctx, errs)
+			}
 			if val, ok := ctx.Get(CONTEXT_CALLBACK); ok && val != nil {
 				if call, ok := val.(func(*Errors)); ok {
 					call(errs)
 				}
 			}
 
-			ctx.AbortWithStatusJSON(errs.StatusCode, errs)
+			switch c.Format {
+			case "text":
+				ctx.Abort()
+				ctx.String(errs.StatusCode, "%s", errs.Error())
+			case "jsonapi":
+				ctx.Abort()
+				ctx.Header("Content-Type", "application/vnd.api+json")
+				ctx.Render(errs.StatusCode, jsoncodec.Render{Data: errs.JSONAPI()})
+			default:
+				ctx.Abort()
+				jsoncodec.JSON(ctx, errs.StatusCode, errs)
+			}
 		}()
 		ctx.Next()
 	}
 }
 
+// RequestID 错误和 respond 的响应中的 request_id
+func RequestID(ctx *gin.Context) string {
+	if val := ctx.GetString(CONTEXT_REQUEST_ID); val != "" {
+		return val
+	}
+	return ctx.GetHeader("X-Request-Id")
+}
+
 var (
 	dunno     = []byte("???")
 	centerDot = []byte("·")
@@ -0,0 +1,145 @@
+package errs
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+type (
+	// StackTrace 和 pkg/errors 一样  %+v 输出 函数 文件:行
+	StackTrace []uintptr
+
+	stackTracer interface {
+		StackTrace() StackTrace
+	}
+
+	causer interface {
+		Cause() error
+	}
+
+	unwrapper interface {
+		Unwrap() error
+	}
+)
+
+// 最多记录的帧数
+const maxDepth = 32
+
+func callers(skip int) StackTrace {
+	pcs := make([]uintptr, maxDepth)
+	n := runtime.Callers(skip+1, pcs)
+	return StackTrace(pcs[:n])
+}
+
+// WithStack 记录调用的位置  已有 stack 时不重复记录
+func WithStack(err error) error {
+	if err == nil {
+		return nil
+	}
+	if hasStack(err) {
+		return err
+	}
+	return &Wrapped{
+		Err:   err,
+		stack: callers(2),
+	}
+}
+
+func (st StackTrace) Format(s fmt.State, verb rune) {
+	frames := runtime.CallersFrames(st)
+	for {
+		frame, more := frames.Next()
+		switch {
+		case verb == 'v' && s.Flag('+'):
+			fmt.Fprintf(s, "\n%s\n\t%s:%d", frame.Function, frame.File, frame.Line)
+		default:
+			fmt.Fprintf(s, "\n%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			break
+		}
+	}
+}
+
+func (st StackTrace) String() string {
+	return strings.TrimPrefix(fmt.Sprintf("%+v", st), "\n")
+}
+
+// Format %+v 输出错误和最内层的 stack
+func (w *Wrapped) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			io.WriteString(s, w.Error())
+			if stack := Stack(w); stack != "" {
+				io.WriteString(s, "\n"+stack)
+			}
+			return
+		}
+		io.WriteString(s, w.Error())
+	case 's':
+		io.WriteString(s, w.Error())
+	case 'q':
+		fmt.Fprintf(s, "%q", w.Error())
+	}
+}
+
+func (w *Wrapped) StackTrace() StackTrace {
+	return w.stack
+}
+
+// next 支持 Wrapped  pkg/errors 的 Cause  和 Unwrap
+func next(err error) error {
+	switch e := err.(type) {
+	case *Wrapped:
+		return e.Err
+	case causer:
+		return e.Cause()
+	case unwrapper:
+		return e.Unwrap()
+	}
+	return nil
+}
+
+// Stack 最内层的 stack  包括 pkg/errors 的  没有时为空
+func Stack(err error) (stack string) {
+	for err != nil {
+		if val := stackOf(err); val != "" {
+			stack = val
+		}
+		err = next(err)
+	}
+	return
+}
+
+func hasStack(err error) bool {
+	for err != nil {
+		if stackOf(err) != "" {
+			return true
+		}
+		err = next(err)
+	}
+	return false
+}
+
+func stackOf(err error) string {
+	if val, ok := err.(stackTracer); ok {
+		if st := val.StackTrace(); len(st) != 0 {
+			return st.String()
+		}
+		return ""
+	}
+	// pkg/errors  StackTrace() errors.StackTrace
+	method := reflect.ValueOf(err).MethodByName("StackTrace")
+	if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 {
+		return ""
+	}
+	out := method.Call(nil)[0]
+	if out.Kind() != reflect.Slice || out.Len() == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%+v", out.Interface()), "\n")
+}
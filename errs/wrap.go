@@ -0,0 +1,217 @@
+package errs
+
+import (
+	"net/http"
+)
+
+type (
+	// Wrapped 应用层的错误  带操作名 类型 和字段  中间件输出到日志  Debug 时输出到响应
+	Wrapped struct {
+		Err    error
+		Op     string
+		Kind   string
+		Fields map[string]interface{}
+
+		stack StackTrace
+	}
+)
+
+const (
+	KindInvalid      = "invalid"
+	KindUnauthorized = "unauthorized"
+	KindForbidden    = "forbidden"
+	KindNotFound     = "not_found"
+	KindConflict     = "conflict"
+	KindTimeout      = "timeout"
+	KindUnavailable  = "unavailable"
+	KindInternal     = "internal"
+)
+
+var kindStatusCodes = map[string]int{
+	KindInvalid:      http.StatusBadRequest,
+	KindUnauthorized: http.StatusUnauthorized,
+	KindForbidden:    http.StatusForbidden,
+	KindNotFound:     http.StatusNotFound,
+	KindConflict:     http.StatusConflict,
+	KindTimeout:      http.StatusGatewayTimeout,
+	KindUnavailable:  http.StatusServiceUnavailable,
+	KindInternal:     http.StatusInternalServerError,
+}
+
+// Wrap err 为 nil 时返回 nil  kind 为空时使用内层的
+func Wrap(err error, kind string, fields map[string]interface{}) error {
+	if err == nil {
+		return nil
+	}
+	w := &Wrapped{
+		Err:    err,
+		Kind:   kind,
+		Fields: fields,
+	}
+	if !hasStack(err) {
+		w.stack = callers(2)
+	}
+	return w
+}
+
+// WrapOp 加上操作名  例如 users.create
+func WrapOp(err error, op string, kind string, fields map[string]interface{}) error {
+	if err == nil {
+		return nil
+	}
+	w := &Wrapped{
+		Err:    err,
+		Op:     op,
+		Kind:   kind,
+		Fields: fields,
+	}
+	if !hasStack(err) {
+		w.stack = callers(2)
+	}
+	return w
+}
+
+func (w *Wrapped) Error() string {
+	if w.Op != "" {
+		return w.Op + ": " + w.Err.Error()
+	}
+	return w.Err.Error()
+}
+
+func (w *Wrapped) Cause() error {
+	return w.Err
+}
+
+func (w *Wrapped) Unwrap() error {
+	return w.Err
+}
+
+// Cause 最内层的错误  支持 pkg/errors 和 Unwrap
+func Cause(err error) error {
+	for {
+		val := next(err)
+		if val == nil {
+			return err
+		}
+		err = val
+	}
+}
+
+// KindOf 最外层的 kind  *Error 按状态码
+func KindOf(err error) string {
+	for err != nil {
+		switch e := err.(type) {
+		case *Wrapped:
+			if e.Kind != "" {
+				return e.Kind
+			}
+			err = e.Err
+		case *Error:
+			for kind, statusCode := range kindStatusCodes {
+				if statusCode == e.StatusCode {
+					return kind
+				}
+			}
+			return ""
+		default:
+			err = next(err)
+		}
+	}
+	return ""
+}
+
+// Fields 合并所有层的字段  外层优先  操作名为 op 从外到内用 / 连接
+func Fields(err error) map[string]interface{} {
+	fields := map[string]interface{}{}
+	var ops string
+	for ; err != nil; err = next(err) {
+		w, ok := err.(*Wrapped)
+		if !ok {
+			continue
+		}
+		for key, val := range w.Fields {
+			if _, ok := fields[key]; !ok {
+				fields[key] = val
+			}
+		}
+		if w.Op != "" {
+			if ops != "" {
+				ops += "/"
+			}
+			ops += w.Op
+		}
+	}
+	if ops != "" {
+		fields["op"] = ops
+	}
+	return fields
+}
+
+// KindStatusCode 未知的 kind 为 500
+func KindStatusCode(kind string) int {
+	if statusCode, ok := kindStatusCodes[kind]; ok {
+		return statusCode
+	}
+	return http.StatusInternalServerError
+}
+
+func Is(err error, kind string) bool {
+	return KindOf(err) == kind
+}
+
+func IsInvalid(err error) bool {
+	return Is(err, KindInvalid)
+}
+
+func IsUnauthorized(err error) bool {
+	return Is(err, KindUnauthorized)
+}
+
+func IsForbidden(err error) bool {
+	return Is(err, KindForbidden)
+}
+
+func IsNotFound(err error) bool {
+	return Is(err, KindNotFound)
+}
+
+func IsConflict(err error) bool {
+	return Is(err, KindConflict)
+}
+
+func IsTimeout(err error) bool {
+	return Is(err, KindTimeout)
+}
+
+func IsUnavailable(err error) bool {
+	return Is(err, KindUnavailable)
+}
+
+// wrapped 内层为 *Error 时使用它  否则按 kind 设置状态码
+func (c Config) wrapped(w *Wrapped) (e *Error) {
+	kind := KindOf(w)
+	if val, ok := Cause(w).(*Error); ok {
+		e = val.Clone()
+	} else {
+		e = &Error{
+			Err:  w,
+			Type: kind,
+		}
+		if kind != "" {
+			e.StatusCode = KindStatusCode(kind)
+		}
+	}
+	if c.Debug {
+		if e.Params == nil {
+			e.Params = map[string]interface{}{}
+		}
+		for key, val := range Fields(w) {
+			e.Params[key] = val
+		}
+		e.Params["error"] = w.Error()
+		if stack := Stack(w); stack != "" {
+			e.Params["stack"] = stack
+		}
+	}
+	return
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"github.com/otamoe/gin-server/events"
+)
+
+type (
+	Events struct {
+		// 通过 redis 投递到其他实例
+		Redis  bool   `json:"redis,omitempty"`
+		Prefix string `json:"prefix,omitempty"`
+
+		started bool
+	}
+)
+
+func (config *Events) init(server *Server, handler *Handler) {
+	if config.started {
+		return
+	}
+	config.started = true
+	if config.Redis {
+		provider := server.redisProvider()
+		if provider == nil {
+			panic("Events: redis is empty")
+		}
+		prefix := config.Prefix
+		if prefix == "" {
+			prefix = "events"
+		}
+		events.Default.UseRedis(provider.Get(), server.redisPrefix()+prefix)
+	}
+}
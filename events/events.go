@@ -0,0 +1,277 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/utils"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	Config struct {
+		Workers   int
+		QueueSize int
+		Logger    *logrus.Logger
+	}
+
+	// Named 自定义事件名  默认为 包名.类型名
+	Named interface {
+		EventName() string
+	}
+
+	Bus struct {
+		config Config
+
+		mutex       sync.RWMutex
+		subscribers map[reflect.Type][]*subscriber
+		types       map[string]reflect.Type
+
+		once   sync.Once
+		queue  chan *delivery
+		closed bool
+		wg     sync.WaitGroup
+
+		redis  *redis.Client
+		prefix string
+		pubsub *redis.PubSub
+		origin string
+	}
+
+	subscriber struct {
+		name    string
+		handler reflect.Value
+	}
+
+	delivery struct {
+		subscriber *subscriber
+		event      reflect.Value
+	}
+
+	message struct {
+		Origin string          `json:"origin"`
+		Event  json.RawMessage `json:"event"`
+	}
+)
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+var ErrClosed = errors.New("Events: bus closed")
+
+var ErrNil = errors.New("Events: event is nil")
+
+var Default = New(Config{})
+
+func New(c Config) *Bus {
+	if c.Workers == 0 {
+		c.Workers = 16
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = 1024
+	}
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	hostname, _ := os.Hostname()
+	return &Bus{
+		config:      c,
+		subscribers: map[reflect.Type][]*subscriber{},
+		types:       map[string]reflect.Type{},
+		queue:       make(chan *delivery, c.QueueSize),
+		origin:      hostname + "." + strconv.Itoa(os.Getpid()) + "." + bson.NewObjectId().Hex(),
+	}
+}
+
+func Subscribe(handler interface{}) {
+	Default.Subscribe(handler)
+}
+
+func Publish(ctx context.Context, event interface{}) error {
+	return Default.Publish(ctx, event)
+}
+
+// Name 事件名
+func Name(event interface{}) string {
+	if named, ok := event.(Named); ok {
+		return named.EventName()
+	}
+	return typeName(reflect.TypeOf(event))
+}
+
+// Subscribe handler 为 func(ctx context.Context, event T) error 或 func(ctx context.Context, event T)
+func (bus *Bus) Subscribe(handler interface{}) {
+	value := reflect.ValueOf(handler)
+	typ := value.Type()
+	if typ.Kind() != reflect.Func || typ.NumIn() != 2 || typ.In(0) != contextType || typ.NumOut() > 1 || (typ.NumOut() == 1 && typ.Out(0) != errorType) {
+		panic("Events: handler must be func(context.Context, T) error")
+	}
+	eventType := typ.In(1)
+
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.subscribers[eventType] = append(bus.subscribers[eventType], &subscriber{
+		name:    utils.NameOfFunction(handler),
+		handler: value,
+	})
+	bus.types[bus.name(eventType)] = eventType
+}
+
+// Publish 异步执行订阅者  队列满时阻塞直到 ctx 结束  有 redis 时同时发送到其他实例
+func (bus *Bus) Publish(ctx context.Context, event interface{}) error {
+	if event == nil {
+		return ErrNil
+	}
+	value := reflect.ValueOf(event)
+	if err := bus.dispatch(ctx, value); err != nil {
+		return err
+	}
+	if bus.redis == nil {
+		return nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(message{
+		Origin: bus.origin,
+		Event:  data,
+	})
+	if err != nil {
+		return err
+	}
+	return bus.redis.Publish(bus.prefix+"."+Name(event), data).Err()
+}
+
+// UseRedis 跨实例投递  只投递本实例已订阅的事件类型
+func (bus *Bus) UseRedis(client *redis.Client, prefix string) {
+	if prefix == "" {
+		prefix = "events"
+	}
+	bus.redis = client
+	bus.prefix = prefix
+	bus.pubsub = client.PSubscribe(prefix + ".*")
+	go func() {
+		for msg := range bus.pubsub.Channel() {
+			bus.receive(strings.TrimPrefix(msg.Channel, prefix+"."), []byte(msg.Payload))
+		}
+	}()
+}
+
+// Close 等待队列中的事件处理完成
+func (bus *Bus) Close(ctx context.Context) error {
+	bus.mutex.Lock()
+	if bus.closed {
+		bus.mutex.Unlock()
+		return nil
+	}
+	bus.closed = true
+	if bus.pubsub != nil {
+		bus.pubsub.Close()
+	}
+	close(bus.queue)
+	bus.mutex.Unlock()
+
+	// 没有启动过 worker
+	bus.once.Do(func() {})
+
+	done := make(chan struct{})
+	go func() {
+		bus.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (bus *Bus) name(typ reflect.Type) string {
+	if typ.Implements(reflect.TypeOf((*Named)(nil)).Elem()) {
+		return Name(reflect.Zero(typ).Interface())
+	}
+	return typeName(typ)
+}
+
+func (bus *Bus) receive(name string, data []byte) {
+	msg := &message{}
+	if err := json.Unmarshal(data, msg); err != nil || msg.Origin == bus.origin {
+		return
+	}
+	bus.mutex.RLock()
+	typ, ok := bus.types[name]
+	bus.mutex.RUnlock()
+	if !ok {
+		return
+	}
+	ptr := reflect.New(typ)
+	if err := json.Unmarshal(msg.Event, ptr.Interface()); err != nil {
+		bus.config.Logger.Errorf("[EVENTS] %s decode %s", name, err)
+		return
+	}
+	bus.dispatch(context.Background(), ptr.Elem())
+}
+
+func (bus *Bus) dispatch(ctx context.Context, value reflect.Value) error {
+	bus.once.Do(bus.start)
+
+	bus.mutex.RLock()
+	defer bus.mutex.RUnlock()
+	if bus.closed {
+		return ErrClosed
+	}
+	for _, sub := range bus.subscribers[value.Type()] {
+		select {
+		case bus.queue <- &delivery{subscriber: sub, event: value}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (bus *Bus) start() {
+	for i := 0; i < bus.config.Workers; i++ {
+		bus.wg.Add(1)
+		go func() {
+			defer bus.wg.Done()
+			for d := range bus.queue {
+				bus.call(d)
+			}
+		}()
+	}
+}
+
+// call 订阅者 panic 不影响其他订阅者
+func (bus *Bus) call(d *delivery) {
+	defer func() {
+		if r := recover(); r != nil {
+			bus.config.Logger.Errorf("[EVENTS] %s panic %v", d.subscriber.name, r)
+		}
+	}()
+	out := d.subscriber.handler.Call([]reflect.Value{reflect.ValueOf(context.Background()), d.event})
+	if len(out) == 1 && !out[0].IsNil() {
+		bus.config.Logger.Errorf("[EVENTS] %s %s %s", d.subscriber.name, typeName(d.event.Type()), out[0].Interface())
+	}
+}
+
+func typeName(typ reflect.Type) string {
+	if typ.Kind() == reflect.Ptr {
+		return typeName(typ.Elem())
+	}
+	if typ.Name() == "" {
+		return fmt.Sprint(typ)
+	}
+	return typ.String()
+}
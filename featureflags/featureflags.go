@@ -0,0 +1,257 @@
+package featureflags
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/apikey"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/jwt"
+)
+
+type (
+	Flag struct {
+		Name    string
+		Default bool
+		// 0-100 按 key 灰度  Default 为 false 时生效
+		Percent int
+	}
+
+	Flags struct {
+		// 灰度使用的 key
+		Rollout func(ctx *gin.Context) string
+
+		mutex     sync.RWMutex
+		defaults  map[string]Flag
+		overrides map[string]Flag
+
+		redis  *redis.Client
+		key    string
+		pubsub *redis.PubSub
+		stop   chan struct{}
+	}
+)
+
+var CONTEXT = "GIN.SERVER.FEATUREFLAGS"
+
+var PREFIX = "featureflags"
+
+func New(flags ...Flag) *Flags {
+	f := &Flags{
+		defaults:  map[string]Flag{},
+		overrides: map[string]Flag{},
+		Rollout:   RolloutKey,
+	}
+	for _, flag := range flags {
+		f.defaults[flag.Name] = flag
+	}
+	return f
+}
+
+func Middleware(f *Flags) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, f)
+		ctx.Next()
+	}
+}
+
+// Enabled 未使用 middleware 时返回 false
+func Enabled(ctx *gin.Context, name string) bool {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		f := val.(*Flags)
+		return f.Enabled(name, f.Rollout(ctx))
+	}
+	return false
+}
+
+// RolloutKey 依次使用 jwt subject  api key  ip
+func RolloutKey(ctx *gin.Context) string {
+	if claims := jwt.Get(ctx); claims != nil {
+		if sub := claims.Subject(); sub != "" {
+			return sub
+		}
+	}
+	if key := apikey.Get(ctx); key != nil {
+		return key.ID.Hex()
+	}
+	return clientip.ClientIP(ctx)
+}
+
+func (f *Flags) Enabled(name string, key string) bool {
+	f.mutex.RLock()
+	flag, ok := f.overrides[name]
+	if !ok {
+		flag, ok = f.defaults[name]
+	}
+	f.mutex.RUnlock()
+	if !ok {
+		return false
+	}
+	if flag.Default {
+		return true
+	}
+	if flag.Percent <= 0 {
+		return false
+	}
+	if flag.Percent >= 100 {
+		return true
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(name + ":" + key))
+	return int(hash.Sum32()%100) < flag.Percent
+}
+
+// UseRedis 使用 redis hash 覆盖  value 为 on off 或 25%
+func (f *Flags) UseRedis(client *redis.Client, key string, refresh time.Duration) (err error) {
+	if key == "" {
+		key = PREFIX
+	}
+	if refresh == 0 {
+		refresh = time.Minute
+	}
+	f.redis = client
+	f.key = key
+	if err = f.Reload(); err != nil {
+		return
+	}
+
+	// 修改后立即通知
+	f.pubsub = client.Subscribe(key + ".changed")
+	f.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+		channel := f.pubsub.Channel()
+		for {
+			select {
+			case <-f.stop:
+				return
+			case _, ok := <-channel:
+				if !ok {
+					return
+				}
+				f.Reload()
+			case <-ticker.C:
+				f.Reload()
+			}
+		}
+	}()
+	return
+}
+
+func (f *Flags) Reload() (err error) {
+	if f.redis == nil {
+		return
+	}
+	var values map[string]string
+	if values, err = f.redis.HGetAll(f.key).Result(); err != nil {
+		return
+	}
+	overrides := map[string]Flag{}
+	for name, value := range values {
+		if flag, ok := parse(name, value); ok {
+			overrides[name] = flag
+		}
+	}
+	f.mutex.Lock()
+	f.overrides = overrides
+	f.mutex.Unlock()
+	return
+}
+
+// Validate value 为空 on off 或 25%
+func Validate(name string, value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, ok := parse(name, value); !ok {
+		return errors.New("featureflags: invalid value " + value)
+	}
+	return nil
+}
+
+// Set 写入 redis 并通知其他实例  value 为空时删除覆盖  没有 UseRedis 时只修改当前实例
+func (f *Flags) Set(name string, value string) (err error) {
+	if err = Validate(name, value); err != nil {
+		return
+	}
+	if f.redis == nil {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		overrides := map[string]Flag{}
+		for key, flag := range f.overrides {
+			overrides[key] = flag
+		}
+		if value == "" {
+			delete(overrides, name)
+		} else {
+			overrides[name], _ = parse(name, value)
+		}
+		f.overrides = overrides
+		return
+	}
+	if value == "" {
+		err = f.redis.HDel(f.key, name).Err()
+	} else {
+		err = f.redis.HSet(f.key, name, value).Err()
+	}
+	if err != nil {
+		return
+	}
+	if err = f.redis.Publish(f.key+".changed", name).Err(); err != nil {
+		return
+	}
+	return f.Reload()
+}
+
+// All 当前生效的 flag
+func (f *Flags) All() map[string]Flag {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	flags := map[string]Flag{}
+	for name, flag := range f.defaults {
+		flags[name] = flag
+	}
+	for name, flag := range f.overrides {
+		flags[name] = flag
+	}
+	return flags
+}
+
+func (f *Flags) Close() error {
+	if f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+	}
+	if f.pubsub != nil {
+		return f.pubsub.Close()
+	}
+	return nil
+}
+
+func parse(name string, value string) (flag Flag, ok bool) {
+	flag.Name = name
+	value = strings.TrimSpace(strings.ToLower(value))
+	switch value {
+	case "on", "true", "1":
+		flag.Default = true
+		return flag, true
+	case "off", "false", "0":
+		return flag, true
+	}
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil || percent < 0 || percent > 100 {
+			return
+		}
+		flag.Percent = percent
+		return flag, true
+	}
+	return
+}
@@ -0,0 +1,40 @@
+package featureflags
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	for value, ok := range map[string]bool{
+		"":      true,
+		"on":    true,
+		"off":   true,
+		"25%":   true,
+		"101%":  false,
+		"maybe": false,
+	} {
+		if err := Validate("beta", value); (err == nil) != ok {
+			t.Errorf("Validate(%q) = %v", value, err)
+		}
+	}
+}
+
+func TestSetWithoutRedis(t *testing.T) {
+	f := New(Flag{Name: "beta"})
+	if f.Enabled("beta", "user") {
+		t.Fatal("beta enabled by default")
+	}
+	if err := f.Set("beta", "on"); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Enabled("beta", "user") {
+		t.Fatal("beta not enabled after Set on")
+	}
+	if err := f.Set("beta", ""); err != nil {
+		t.Fatal(err)
+	}
+	if f.Enabled("beta", "user") {
+		t.Fatal("beta still enabled after removing the override")
+	}
+	if err := f.Set("beta", "maybe"); err == nil {
+		t.Fatal("invalid value accepted")
+	}
+}
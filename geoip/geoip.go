@@ -0,0 +1,198 @@
+package geoip
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/logger"
+)
+
+type (
+	Config struct {
+		// MaxMind GeoIP2 / GeoLite2 City 或 Country mmdb
+		Path string
+		// 检查文件修改间隔
+		Reload time.Duration
+
+		// 国家代码 ISO 3166-1
+		Allow []string
+		Block []string
+	}
+
+	Location struct {
+		Country   string  `json:"country,omitempty"`
+		Region    string  `json:"region,omitempty"`
+		City      string  `json:"city,omitempty"`
+		TimeZone  string  `json:"time_zone,omitempty"`
+		Latitude  float64 `json:"latitude,omitempty"`
+		Longitude float64 `json:"longitude,omitempty"`
+	}
+
+	DB struct {
+		path    string
+		mutex   sync.RWMutex
+		reader  *geoip2.Reader
+		modTime time.Time
+		stop    chan struct{}
+	}
+)
+
+var CONTEXT = "GIN.SERVER.GEOIP"
+
+var ErrBlocked = &errs.Error{
+	Message:    "Access from your region is not allowed",
+	Type:       "geoip",
+	StatusCode: http.StatusForbidden,
+}
+
+func Open(path string, reload time.Duration) (db *DB, err error) {
+	db = &DB{
+		path: path,
+		stop: make(chan struct{}),
+	}
+	if err = db.load(); err != nil {
+		db = nil
+		return
+	}
+	if reload > 0 {
+		go db.watch(reload)
+	}
+	return
+}
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.Reload == 0 {
+		c.Reload = time.Hour
+	}
+	db, err := Open(c.Path, c.Reload)
+	if err != nil {
+		panic(err)
+	}
+	return MiddlewareDB(db, c)
+}
+
+func MiddlewareDB(db *DB, c Config) gin.HandlerFunc {
+	allow := map[string]bool{}
+	for _, val := range c.Allow {
+		allow[strings.ToUpper(val)] = true
+	}
+	block := map[string]bool{}
+	for _, val := range c.Block {
+		block[strings.ToUpper(val)] = true
+	}
+
+	return func(ctx *gin.Context) {
+		location := db.Lookup(net.ParseIP(clientip.ClientIP(ctx)))
+		ctx.Set(CONTEXT, location)
+
+		if val, ok := ctx.Get(logger.CONTEXT); ok && val != nil {
+			if log, ok := val.(*logger.Logger); ok && location.Country != "" {
+				log.Fields["country"] = location.Country
+			}
+		}
+
+		// 未知国家不拦截
+		if location.Country != "" && (block[location.Country] || (len(allow) != 0 && !allow[location.Country])) {
+			ctx.Error(ErrBlocked)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Location {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Location)
+	}
+	return &Location{}
+}
+
+// Lookup 查询失败返回空 Location
+func (db *DB) Lookup(ip net.IP) *Location {
+	location := &Location{}
+	if ip == nil {
+		return location
+	}
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	if db.reader == nil {
+		return location
+	}
+	city, err := db.reader.City(ip)
+	if err != nil {
+		return location
+	}
+	location.Country = city.Country.IsoCode
+	if len(city.Subdivisions) != 0 {
+		location.Region = city.Subdivisions[0].IsoCode
+	}
+	location.City = city.City.Names["en"]
+	location.TimeZone = city.Location.TimeZone
+	location.Latitude = city.Location.Latitude
+	location.Longitude = city.Location.Longitude
+	return location
+}
+
+func (db *DB) Close() error {
+	close(db.stop)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.reader == nil {
+		return nil
+	}
+	err := db.reader.Close()
+	db.reader = nil
+	return err
+}
+
+func (db *DB) load() (err error) {
+	var stat os.FileInfo
+	if stat, err = os.Stat(db.path); err != nil {
+		return
+	}
+	var reader *geoip2.Reader
+	if reader, err = geoip2.Open(db.path); err != nil {
+		return
+	}
+
+	db.mutex.Lock()
+	old := db.reader
+	db.reader = reader
+	db.modTime = stat.ModTime()
+	db.mutex.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return
+}
+
+func (db *DB) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+			stat, err := os.Stat(db.path)
+			if err != nil {
+				continue
+			}
+			db.mutex.RLock()
+			changed := !stat.ModTime().Equal(db.modTime)
+			db.mutex.RUnlock()
+			if changed {
+				db.load()
+			}
+		}
+	}
+}
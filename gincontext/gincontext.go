@@ -0,0 +1,139 @@
+package gincontext
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/jwt"
+	"github.com/otamoe/gin-server/logger"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/otamoe/gin-server/tenant"
+	"github.com/sirupsen/logrus"
+)
+
+// Logger 请求日志  没有 logger 中间件或已删除时为 nil
+func Logger(ctx *gin.Context) *logger.Logger {
+	if val, ok := ctx.Get(logger.CONTEXT); ok && val != nil {
+		if val, ok := val.(*logger.Logger); ok {
+			return val
+		}
+	}
+	return nil
+}
+
+func SetLogger(ctx *gin.Context, value *logger.Logger) {
+	ctx.Set(logger.CONTEXT, value)
+}
+
+// DeleteLogger 不记录这个请求
+func DeleteLogger(ctx *gin.Context) {
+	ctx.Set(logger.CONTEXT, false)
+}
+
+// Entry 请求的 logrus entry
+func Entry(ctx *gin.Context) *logrus.Entry {
+	return logger.FromContext(ctx)
+}
+
+// RequestID tracing 的 trace id  或 X-Request-Id
+func RequestID(ctx *gin.Context) string {
+	return errs.RequestID(ctx)
+}
+
+func SetRequestID(ctx *gin.Context, value string) {
+	ctx.Set(errs.CONTEXT_REQUEST_ID, value)
+}
+
+// Mongo 没有 mongo 中间件时为 nil  session 在请求结束时关闭
+func Mongo(ctx *gin.Context) *mgo.Session {
+	if val, ok := ctx.Get(mongoMiddleware.CONTEXT); ok && val != nil {
+		return val.(*mgo.Session)
+	}
+	return nil
+}
+
+func SetMongo(ctx *gin.Context, value *mgo.Session) {
+	ctx.Set(mongoMiddleware.CONTEXT, value)
+}
+
+// MongoDB 当前请求的数据库  没有 mongo 中间件时为 nil
+func MongoDB(ctx *gin.Context) *mgo.Database {
+	if Mongo(ctx) == nil {
+		return nil
+	}
+	return mongoMiddleware.DB(ctx)
+}
+
+// MongoPrefix 集合前缀  包括 tenant 的
+func MongoPrefix(ctx *gin.Context) string {
+	return mongoMiddleware.Collection(ctx, "")
+}
+
+// Redis 没有 redis 中间件时为 nil
+func Redis(ctx *gin.Context) *redisMiddleware.Client {
+	if val, ok := ctx.Get(redisMiddleware.CONTEXT); ok && val != nil {
+		return redisMiddleware.Get(ctx)
+	}
+	return nil
+}
+
+func SetRedis(ctx *gin.Context, value *redis.Client) {
+	ctx.Set(redisMiddleware.CONTEXT, value)
+}
+
+// Resource 没有 resource 中间件时为 nil  不执行 Pre  需要 Value Owner 时在认证之后调用 resource.Pre()
+func Resource(ctx *gin.Context) *ginResource.Resource {
+	if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+		return val.(*ginResource.Resource)
+	}
+	return nil
+}
+
+// Name 路由名 type.action
+func Name(ctx *gin.Context) string {
+	if resource := Resource(ctx); resource != nil {
+		return resource.Name()
+	}
+	return ""
+}
+
+func Type(ctx *gin.Context) string {
+	if resource := Resource(ctx); resource != nil {
+		return resource.Type
+	}
+	return ""
+}
+
+func Action(ctx *gin.Context) string {
+	if resource := Resource(ctx); resource != nil {
+		return resource.Action
+	}
+	return ""
+}
+
+// Tenant 没有时为空
+func Tenant(ctx *gin.Context) string {
+	return tenant.Get(ctx)
+}
+
+func SetTenant(ctx *gin.Context, value string) {
+	ctx.Set(tenant.CONTEXT, value)
+}
+
+// Claims jwt  没有登录时为 nil
+func Claims(ctx *gin.Context) jwt.Claims {
+	return jwt.Get(ctx)
+}
+
+func SetClaims(ctx *gin.Context, value jwt.Claims) {
+	ctx.Set(jwt.CONTEXT, value)
+}
+
+// ClientIP 信任的代理之后的客户端 IP
+func ClientIP(ctx *gin.Context) string {
+	return clientip.ClientIP(ctx)
+}
@@ -1,16 +1,29 @@
 module github.com/otamoe/gin-server
 
 require (
+	github.com/Shopify/sarama v1.22.1
+	github.com/alicebob/gopher-json v0.0.0-20180125190556-5a6b3ba71ee6 // indirect
+	github.com/alicebob/miniredis v2.5.0+incompatible
 	github.com/gin-gonic/gin v1.4.0
 	github.com/globalsign/mgo v0.0.0-20181015135952-eeefdecb41b8
 	github.com/go-playground/locales v0.12.1 // indirect
 	github.com/go-playground/universal-translator v0.16.0 // indirect
 	github.com/go-redis/redis v6.15.2+incompatible
-	github.com/google/brotli v1.0.7
+	github.com/gomodule/redigo v1.7.0 // indirect
+	github.com/google/brotli v1.1.0
+	github.com/gorilla/websocket v1.4.0
+	github.com/json-iterator/go v1.1.6
 	github.com/leodido/go-urn v1.1.0 // indirect
 	github.com/onsi/ginkgo v1.8.0 // indirect
 	github.com/onsi/gomega v1.5.0 // indirect
+	github.com/oschwald/geoip2-golang v1.2.1
+	github.com/oschwald/maxminddb-golang v1.3.0 // indirect
 	github.com/otamoe/mgo-model v0.1.1
 	github.com/sirupsen/logrus v1.4.1
+	github.com/soheilhy/cmux v0.1.4
+	github.com/yuin/gopher-lua v0.0.0-20180827083657-b942cacc89fe // indirect
+	golang.org/x/crypto v0.0.0-20190605123033-f99c8df09eb5
+	golang.org/x/net v0.0.0-20190503192946-f4e77d36d62c
+	google.golang.org/grpc v1.20.1
 	gopkg.in/go-playground/validator.v9 v9.28.0
 )
@@ -0,0 +1,164 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/logger"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	Config struct {
+		Path string
+
+		// gqlgen handler.New  graphql-go relay.Handler 等 http.Handler
+		Handler http.Handler
+
+		// GET 时返回 playground 等页面
+		Playground http.Handler
+
+		// 允许 GET 查询
+		Get bool
+	}
+
+	contextKey struct{}
+
+	request struct {
+		OperationName string `json:"operationName"`
+	}
+)
+
+var CONTEXT = "GIN.SERVER.GRAPHQL"
+
+// 解析 operationName 最多读取
+const maxPeek = 1024 * 1024
+
+// Register 在 router 上挂载 graphql
+func Register(router gin.IRoutes, c Config) {
+	if c.Handler == nil {
+		panic("graphql: handler is nil")
+	}
+	if c.Path == "" {
+		c.Path = "/graphql"
+	}
+
+	handler := Handler(c)
+	router.POST(c.Path, handler)
+	if c.Get || c.Playground != nil {
+		router.GET(c.Path, handler)
+	}
+}
+
+func Handler(c Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.Method == http.MethodGet && c.Playground != nil && ctx.Query("query") == "" {
+			c.Playground.ServeHTTP(ctx.Writer, ctx.Request)
+			return
+		}
+
+		operation := operationName(ctx)
+		ctx.Set(CONTEXT, operation)
+
+		// resource 名称 graphql.operation  用于 metrics logger
+		if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+			resource := val.(*ginResource.Resource)
+			resource.Type = "graphql"
+			if operation != "" {
+				resource.Action = operation
+			} else if resource.Action == "" {
+				resource.Action = "query"
+			}
+		}
+
+		// resolver 中通过 context 获取 gin context
+		req := ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), contextKey{}, ctx))
+		c.Handler.ServeHTTP(ctx.Writer, req)
+	}
+}
+
+// GinContext resolver 中获取
+func GinContext(ctx context.Context) *gin.Context {
+	if val, ok := ctx.Value(contextKey{}).(*gin.Context); ok {
+		return val
+	}
+	return nil
+}
+
+func Mongo(ctx context.Context) *mgo.Session {
+	if ginCtx := GinContext(ctx); ginCtx != nil {
+		if val, ok := ginCtx.Get(mongoMiddleware.CONTEXT); ok && val != nil {
+			return val.(*mgo.Session)
+		}
+	}
+	return nil
+}
+
+func Redis(ctx context.Context) *redis.Client {
+	if ginCtx := GinContext(ctx); ginCtx != nil {
+		if val, ok := ginCtx.Get(redisMiddleware.CONTEXT); ok && val != nil {
+			return val.(*redis.Client)
+		}
+	}
+	return nil
+}
+
+func Logger(ctx context.Context) *logger.Logger {
+	if ginCtx := GinContext(ctx); ginCtx != nil {
+		if val, ok := ginCtx.Get(logger.CONTEXT); ok && val != nil {
+			if val, ok := val.(*logger.Logger); ok {
+				return val
+			}
+		}
+	}
+	return nil
+}
+
+// Report 记录 resolver 错误到 logger  响应仍由 graphql handler 输出
+func Report(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	log := Logger(ctx)
+	if log == nil {
+		return
+	}
+	var errorsText []string
+	if val, ok := log.Fields["graphql_errors"].([]string); ok {
+		errorsText = val
+	}
+	log.Fields["graphql_errors"] = append(errorsText, err.Error())
+}
+
+func operationName(ctx *gin.Context) string {
+	if ctx.Request.Method == http.MethodGet {
+		return ctx.Query("operationName")
+	}
+	if ctx.Request.Body == nil || ctx.ContentType() != gin.MIMEJSON {
+		return ""
+	}
+	body := ctx.Request.Body
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxPeek))
+	// 还原 body
+	ctx.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), body), body}
+	if err != nil || len(data) == maxPeek {
+		return ""
+	}
+	var req request
+	if json.Unmarshal(data, &req) != nil {
+		return ""
+	}
+	return req.OperationName
+}
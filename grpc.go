@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/soheilhy/cmux"
+)
+
+// serveMux 明文时通过 cmux 区分 grpc(h2c) 和 http
+func (server *Server) serveMux(httpServer *http.Server) (err error) {
+	addr := httpServer.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	var listener net.Listener
+	if listener, err = net.Listen("tcp", addr); err != nil {
+		return
+	}
+
+	// PROXY 头在 cmux 匹配之前去掉
+	if server.ProxyProtocol != nil {
+		listener = server.ProxyProtocol.wrap(listener)
+	}
+	return server.serveCmux(httpServer, listener)
+}
+
+// serveCmux Listeners 的明文地址也使用
+func (server *Server) serveCmux(httpServer *http.Server, listener net.Listener) (err error) {
+	mux := cmux.New(listener)
+	grpcListener := mux.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
+	httpListener := mux.Match(cmux.Any())
+	// grpc 是长连接  只检查 http
+	if slow := server.getServerHandler().slow; slow != nil {
+		httpListener = slow.wrap(httpListener)
+	}
+
+	go server.GRPC.Serve(grpcListener)
+	go httpServer.Serve(httpListener)
+
+	if err = mux.Serve(); err != nil && strings.Contains(err.Error(), "use of closed network connection") {
+		err = http.ErrServerClosed
+	}
+	return
+}
+
+// stopGRPC 等待 grpc 请求结束  超时强制关闭
+func (server *Server) stopGRPC(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		server.GRPC.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		server.GRPC.Stop()
+	}
+}
+
+// isGRPC TLS 时 grpc 通过 http2 进入 ServeHTTP
+func isGRPC(req *http.Request) bool {
+	return req.ProtoMajor == 2 && strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
@@ -1,34 +1,105 @@
 package server
 
 import (
-	"compress/gzip"
-	"fmt"
+	"html/template"
 	"net/http"
-	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/otamoe/gin-server/compress"
+	"github.com/otamoe/gin-server/admission"
+	"github.com/otamoe/gin-server/alerts"
+	"github.com/otamoe/gin-server/assets"
+	"github.com/otamoe/gin-server/cachecontrol"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/cors"
 	"github.com/otamoe/gin-server/errs"
-	"github.com/otamoe/gin-server/logger"
+	"github.com/otamoe/gin-server/headers"
+	"github.com/otamoe/gin-server/jobs"
+	"github.com/otamoe/gin-server/jwt"
+	"github.com/otamoe/gin-server/loadshed"
+	"github.com/otamoe/gin-server/mail"
+	"github.com/otamoe/gin-server/metrics"
 	"github.com/otamoe/gin-server/mongo"
 	"github.com/otamoe/gin-server/notfound"
+	"github.com/otamoe/gin-server/rate"
+	"github.com/otamoe/gin-server/recorder"
 	ginRedis "github.com/otamoe/gin-server/redis"
 	"github.com/otamoe/gin-server/resource"
+	"github.com/otamoe/gin-server/secureheaders"
+	"github.com/otamoe/gin-server/sessions"
 	"github.com/otamoe/gin-server/size"
+	"github.com/otamoe/gin-server/streams"
+	"github.com/otamoe/gin-server/tenant"
+	"github.com/otamoe/gin-server/timeout"
+	"github.com/otamoe/gin-server/timezone"
+	"github.com/otamoe/gin-server/tracing"
+	"github.com/otamoe/gin-server/version"
+	"github.com/otamoe/gin-server/webhooks"
 )
 
 type (
 	Handler struct {
-		Name     string    `json:"name,omitempty"`
-		Hosts    []string  `json:"hosts,omitempty"`
-		Compress *Compress `json:"compress,omitempty"`
-		Logger   *Logger   `json:"logger,omitempty"`
-		Redis    *Redis    `json:"redis,omitempty"`
-		Mongo    *Mongo    `json:"mongo,omitempty"`
-		gin      *gin.Engine
-	}
+		Name        string           `json:"name,omitempty"`
+		Hosts       []string         `json:"hosts,omitempty"`
+		Prefixes    []string         `json:"prefixes,omitempty"`
+		Compress    *Compress        `json:"compress,omitempty"`
+		Logger      *Logger          `json:"logger,omitempty"`
+		Redis       *Redis           `json:"redis,omitempty"`
+		Mongo       *Mongo           `json:"mongo,omitempty"`
+		Size        *Size            `json:"size,omitempty"`
+		Errors      *Errors          `json:"errors,omitempty"`
+		Builtins    *Builtins        `json:"builtins,omitempty"`
+		Static      *Static          `json:"static,omitempty"`
+		Templates   *Templates       `json:"templates,omitempty"`
+		I18n        *I18n            `json:"i18n,omitempty"`
+		TimeZone    *TimeZone        `json:"time_zone,omitempty"`
+		Assets      *Assets          `json:"assets,omitempty"`
+		Cors        *Cors            `json:"cors,omitempty"`
+		Canonical   *Canonical       `json:"canonical,omitempty"`
+		Versioning  *Versioning      `json:"versioning,omitempty"`
+		Deprecation *Deprecation     `json:"deprecation,omitempty"`
+		Sniff       *Sniff           `json:"sniff,omitempty"`
+		Secure      *Secure          `json:"secure,omitempty"`
+		Headers     *RequestHeaders  `json:"headers,omitempty"`
+		Concurrent  *ConcurrentLimit `json:"concurrent,omitempty"`
+		LoadShed    *LoadShed        `json:"load_shed,omitempty"`
+		Admission   *Admission       `json:"admission,omitempty"`
+		Timeout     *Timeout         `json:"timeout,omitempty"`
+		Tenant      *Tenant          `json:"tenant,omitempty"`
+		JWT         *JWT             `json:"jwt,omitempty"`
+		Sessions    *Sessions        `json:"sessions,omitempty"`
+		ACL         *ACL             `json:"acl,omitempty"`
+		TOTP        *TOTP            `json:"totp,omitempty"`
+		SignedURL   *SignedURL       `json:"signed_url,omitempty"`
+		OAuthServer *OAuthServer     `json:"oauth_server,omitempty"`
+
+		// 为空时使用自己的 Redis Mongo 配置  或 server 的 provider
+		RedisProvider RedisProvider `json:"-"`
+		MongoProvider MongoProvider `json:"-"`
+
+		Metrics *Metrics `json:"metrics,omitempty"`
+		Tracing *Tracing `json:"tracing,omitempty"`
+
+		Recorder *Recorder `json:"recorder,omitempty"`
+		Capture  *Capture  `json:"capture,omitempty"`
+
+		CacheControl *CacheControl `json:"cache_control,omitempty"`
+
+		OpenAPI *OpenAPI `json:"openapi,omitempty"`
+
+		// 未匹配的路由转发到 upstream
+		Proxy *Proxy `json:"proxy,omitempty"`
 
-	serverHandler map[string]*gin.Engine
+		// 一部分请求交给另一个 handler 或 upstream
+		Canary *Canary `json:"canary,omitempty"`
+
+		// static templates builtins 的文件  为空时使用 server 的  embed 使用 http.FS(embedFS)
+		FS http.FileSystem `json:"-"`
+
+		// 自动根据路由生成 resource type action
+		AutoResource bool `json:"auto_resource,omitempty"`
+
+		gin *gin.Engine
+	}
 )
 
 func (handler *Handler) Init(server *Server) {
@@ -46,55 +117,560 @@ func (handler *Handler) Init(server *Server) {
 	} else {
 		handler.Logger.init(server, handler)
 	}
+	if handler.RedisProvider == nil && handler.Redis == nil {
+		handler.RedisProvider = server.RedisProvider
+	}
 	if handler.Redis == nil {
 		handler.Redis = server.Redis
 	} else {
 		handler.Redis.init(server, handler)
 	}
+	if handler.RedisProvider == nil && handler.Redis != nil {
+		handler.RedisProvider = handler.Redis
+	}
+	if handler.MongoProvider == nil && handler.Mongo == nil {
+		handler.MongoProvider = server.MongoProvider
+	}
 	if handler.Mongo == nil {
 		handler.Mongo = server.Mongo
 	} else {
 		handler.Mongo.init(server, handler)
 	}
+	if handler.MongoProvider == nil && handler.Mongo != nil {
+		handler.MongoProvider = handler.Mongo
+	}
+	if handler.Size == nil {
+		handler.Size = server.Size
+	} else {
+		handler.Size.init(server, handler)
+	}
+	if handler.Errors == nil {
+		handler.Errors = server.Errors
+	} else {
+		handler.Errors.init(server, handler)
+	}
+	if handler.Builtins == nil && handler.FS == nil {
+		handler.Builtins = server.Builtins
+	} else {
+		if handler.Builtins == nil {
+			handler.Builtins = &Builtins{}
+		}
+		handler.Builtins.init(server, handler)
+	}
+	if handler.FS == nil {
+		handler.FS = server.FS
+	}
+	if handler.Static == nil {
+		handler.Static = server.Static
+	} else {
+		handler.Static.init(server, handler)
+	}
+	if handler.I18n == nil {
+		handler.I18n = server.I18n
+	} else {
+		handler.I18n.init(server, handler)
+	}
+	if handler.TimeZone == nil {
+		handler.TimeZone = server.TimeZone
+	} else {
+		handler.TimeZone.init(server, handler)
+	}
+	if handler.Templates == nil {
+		handler.Templates = server.Templates
+	} else {
+		handler.Templates.init(server, handler)
+	}
+	if handler.Assets == nil {
+		handler.Assets = server.Assets
+	} else {
+		handler.Assets.init(server, handler)
+	}
+	if handler.Cors == nil {
+		handler.Cors = server.Cors
+	} else {
+		handler.Cors.init(server, handler)
+	}
+	if handler.Deprecation == nil {
+		handler.Deprecation = server.Deprecation
+	} else {
+		handler.Deprecation.init(server, handler)
+	}
+	if handler.Sniff == nil {
+		handler.Sniff = server.Sniff
+	} else {
+		handler.Sniff.init(server, handler)
+	}
+	if handler.ACL == nil {
+		handler.ACL = server.ACL
+	} else {
+		handler.ACL.init(server, handler)
+	}
+	if handler.OAuthServer == nil {
+		handler.OAuthServer = server.OAuthServer
+	} else {
+		handler.OAuthServer.init(server, handler)
+	}
+	if handler.TOTP == nil {
+		handler.TOTP = server.TOTP
+	} else {
+		handler.TOTP.init(server, handler)
+	}
+	if handler.SignedURL == nil {
+		handler.SignedURL = server.SignedURL
+	} else {
+		handler.SignedURL.init(server, handler)
+	}
+	if handler.Versioning == nil {
+		handler.Versioning = server.Versioning
+	} else {
+		handler.Versioning.init(server, handler)
+	}
+	if handler.Canonical == nil {
+		handler.Canonical = server.Canonical
+	} else {
+		handler.Canonical.init(server, handler)
+	}
+	if handler.Secure == nil {
+		handler.Secure = server.Secure
+	} else {
+		handler.Secure.init(server, handler)
+	}
+	if handler.Headers == nil {
+		handler.Headers = server.Headers
+	} else {
+		handler.Headers.init(server, handler)
+	}
+	if handler.Concurrent == nil {
+		handler.Concurrent = server.Concurrent
+	} else {
+		handler.Concurrent.init(server, handler)
+	}
+	if handler.LoadShed == nil {
+		handler.LoadShed = server.LoadShed
+	} else {
+		handler.LoadShed.init(server, handler)
+	}
+	if handler.Admission == nil {
+		handler.Admission = server.Admission
+	} else {
+		handler.Admission.init(server, handler)
+	}
+	if handler.Timeout == nil {
+		handler.Timeout = server.Timeout
+	} else {
+		handler.Timeout.init(server, handler)
+	}
+	if handler.Tenant == nil {
+		handler.Tenant = server.Tenant
+	} else {
+		handler.Tenant.init(server, handler)
+	}
+	if handler.JWT == nil {
+		handler.JWT = server.JWT
+	} else {
+		handler.JWT.init(server, handler)
+	}
+	if handler.Sessions == nil {
+		handler.Sessions = server.Sessions
+	} else {
+		handler.Sessions.init(server, handler)
+	}
+
+	if handler.Metrics == nil {
+		handler.Metrics = server.Metrics
+	} else {
+		handler.Metrics.init(server, handler)
+	}
+
+	if handler.Tracing == nil {
+		handler.Tracing = server.Tracing
+	} else {
+		handler.Tracing.init(server, handler)
+	}
+
+	if handler.Recorder == nil {
+		handler.Recorder = server.Recorder
+	} else {
+		handler.Recorder.init(server, handler)
+	}
+
+	if handler.Capture == nil {
+		handler.Capture = server.Capture
+	} else {
+		handler.Capture.init(server, handler)
+	}
+
+	if handler.Proxy != nil {
+		handler.Proxy.init(server, handler)
+	}
+	if handler.Canary != nil {
+		handler.Canary.init(server, handler)
+	}
+	if handler.CacheControl != nil {
+		handler.CacheControl.init(server, handler)
+	}
+	if handler.OpenAPI == nil {
+		handler.OpenAPI = server.OpenAPI
+	} else {
+		handler.OpenAPI.init(server, handler)
+	}
 
 	handler.gin = gin.New()
 
+	// 由 Canonical 统一处理  避免和 gin 的重定向冲突
+	if handler.Canonical != nil {
+		handler.gin.RedirectTrailingSlash = false
+		handler.gin.RedirectFixedPath = false
+	}
+
+	// 客户端 IP  只信任 TrustedProxies 的 RemoteIPHeaders  CDN 的请求头  ctx.ClientIP() 使用相同结果
+	clientip.Engine(handler.gin)
+	handler.gin.Use(clientip.Middleware(clientip.Config{
+		TrustedProxies: server.trustedProxies,
+		Headers:        server.RemoteIPHeaders,
+		Platform:       server.TrustedPlatform,
+		CDNs:           server.cdns(),
+	}))
+
 	// resource
-	handler.gin.Use(resource.Middleware(resource.Config{}))
-
-	// Compress 中间件
-	handler.gin.Use(compress.Middleware(compress.Config{
-		GzipLevel: gzip.DefaultCompression,
-		MinLength: 256,
-		BrLGWin:   19,
-		BrQuality: 6,
-		Types:     handler.Compress.Types,
+	handler.gin.Use(resource.Middleware(resource.Config{
+		Auto:   handler.AutoResource,
+		Routes: handler.gin.Routes,
 	}))
 
+	// metrics 按 resource type action 统计
+	if handler.Metrics != nil {
+		handler.gin.Use(metrics.Middleware(metrics.Config{
+			Buckets: handler.Metrics.Buckets,
+			Skip:    handler.Metrics.skip,
+		}))
+		if handler.Metrics.Path != "" {
+			handler.gin.GET(handler.Metrics.Path, metrics.Handler(nil))
+		}
+	}
+
+	// 5xx 比例 延迟告警
+	if server.Alerts != nil {
+		handler.gin.Use(alerts.Middleware(server.Alerts.Get()))
+	}
+
+	// Compress 中间件  开启 metrics 时统计压缩率
+	if module := server.replaced("compress"); module != nil {
+		handler.useModule(module)
+	} else {
+		var compressRegistry *metrics.Registry
+		if handler.Metrics != nil {
+			compressRegistry = metrics.Default
+		}
+		handler.gin.Use(handler.Compress.middleware(compressRegistry))
+	}
+
 	// logger
-	handler.gin.Use(logger.Middleware(logger.Config{
-		Prefix: "[HTTP] ",
-		Logger: handler.Logger.Get(),
-	}))
+	if module := server.replaced("logger"); module != nil {
+		handler.useModule(module)
+	} else {
+		handler.gin.Use(handler.Logger.middleware())
+	}
+
+	// tracing
+	if handler.Tracing != nil {
+		handler.gin.Use(tracing.Middleware(handler.Tracing.Get()))
+	}
+
+	// X-App-Version
+	handler.gin.Use(version.Middleware())
+
+	// 记录最近的请求  只在 development
+	if handler.Recorder != nil && server.ENV == "development" {
+		handler.gin.Use(recorder.Middleware(recorder.Config{
+			Recorder: handler.Recorder.Get(),
+			Skip:     handler.Recorder.skip,
+		}))
+		handler.gin.GET(handler.Recorder.Path, recorder.Handler(handler.Recorder.Get()))
+	}
+
+	// openapi.json 和 swagger ui
+	if handler.OpenAPI != nil {
+		handler.OpenAPI.register(handler)
+	}
+
+	// 采样保存请求
+	if handler.Capture != nil && handler.Capture.store != nil {
+		handler.gin.Use(handler.Capture.middleware(handler))
+	}
 
 	// errs
-	handler.gin.Use(errs.Middleware())
+	var errorsCallback func(*gin.Context, *errs.Errors)
+	if server.Notify != nil && server.Notify.Errors {
+		errorsCallback = server.Notify.errors
+	}
+	handler.gin.Use(errs.MiddlewareWithConfig(errs.Config{
+		Format:   handler.Errors.Format,
+		Callback: errorsCallback,
+		Debug:    server.ENV == "development",
+	}))
+
+	// Accept-Language  errs 的翻译  每个 host 可以有不同的默认语言
+	if handler.I18n != nil {
+		handler.gin.Use(handler.I18n.middleware(handler.FS))
+	}
+
+	// api 版本  不支持的版本由 errs 输出
+	if handler.Versioning != nil {
+		handler.gin.Use(handler.Versioning.middleware())
+	}
+
+	// 废弃的路由  sunset 之后的 410 由 errs 输出
+	if handler.Deprecation != nil {
+		handler.gin.Use(handler.Deprecation.middleware(handler))
+	}
+
+	// 按 openapi 文档检查请求和响应
+	if handler.OpenAPI != nil {
+		if middleware := handler.OpenAPI.middleware(server); middleware != nil {
+			handler.gin.Use(middleware)
+		}
+	}
+
+	// secure headers
+	if handler.Secure != nil && !handler.Secure.Disabled {
+		handler.gin.Use(secureheaders.Middleware(secureheaders.Config{
+			HSTSMaxAge:            handler.Secure.HSTSMaxAge,
+			HSTSIncludeSubdomains: handler.Secure.HSTSIncludeSubdomains,
+			HSTSPreload:           handler.Secure.HSTSPreload,
+			ContentSecurityPolicy: handler.Secure.ContentSecurityPolicy,
+			FrameOptions:          handler.Secure.FrameOptions,
+			ContentTypeNosniff:    true,
+			ReferrerPolicy:        handler.Secure.ReferrerPolicy,
+			PermissionsPolicy:     handler.Secure.PermissionsPolicy,
+		}))
+	}
+
+	// cors
+	if handler.Cors != nil {
+		handler.gin.Use(cors.Middleware(cors.Config{
+			Origins:       handler.Cors.Origins,
+			Methods:       handler.Cors.Methods,
+			Headers:       handler.Cors.Headers,
+			ExposeHeaders: handler.Cors.ExposeHeaders,
+			Credentials:   handler.Cors.Credentials,
+			MaxAge:        handler.Cors.MaxAge,
+		}))
+	}
+
+	// 请求头数量 长度 必须的请求头
+	if handler.Headers != nil {
+		handler.gin.Use(headers.Middleware(headers.Config{
+			MaxCount: handler.Headers.MaxCount,
+			MaxSize:  handler.Headers.MaxSize,
+			Required: handler.Headers.Required,
+		}))
+	}
+
+	// 每个 IP 同时处理的请求数 连接数
+	if handler.Concurrent != nil {
+		handler.gin.Use(rate.MiddlewareConcurrent(rate.ConcurrentConfig{
+			Requests:       handler.Concurrent.Requests,
+			Connections:    handler.Concurrent.Connections,
+			IPv4Prefix:     handler.Concurrent.IPv4Prefix,
+			IPv6Prefix:     handler.Concurrent.IPv6Prefix,
+			TrustedProxies: server.trustedProxies,
+			ConnClosed:     server.getServerHandler().conns.onClose,
+		}))
+	}
+
+	// 过载时按路由优先级丢弃  在 deadline 之前  丢弃的请求不等待
+	if handler.LoadShed != nil {
+		handler.gin.Use(loadshed.Middleware(handler.LoadShed.Get()))
+	}
+
+	// 按路由名设置 deadline  在 Redis Mongo 之前
+	if handler.Timeout != nil {
+		handler.gin.Use(timeout.Middleware(timeout.Config{
+			Default: handler.Timeout.Default,
+			Routes:  handler.Timeout.routes,
+		}))
+	}
+
+	// 按路由类别排队  在 deadline 之后  排队的时间也计入
+	if handler.Admission != nil {
+		handler.gin.Use(admission.Middleware(handler.Admission.Get()))
+	}
 
 	// Redis 中间件
-	if handler.Redis != nil {
-		handler.gin.Use(ginRedis.Middleware(handler.Redis.Get))
+	if module := server.replaced("redis"); module != nil && handler.Redis == nil {
+		handler.useModule(module)
+	} else if handler.RedisProvider != nil {
+		if val, ok := handler.RedisProvider.(*Redis); ok {
+			handler.gin.Use(val.Middleware())
+		} else {
+			handler.gin.Use(ginRedis.MiddlewareProvider(handler.RedisProvider))
+		}
 	}
 
 	// Mongo 中间件
-	if handler.Mongo != nil {
-		handler.gin.Use(mongo.Middleware(handler.Mongo.Get))
+	if module := server.replaced("mongo"); module != nil && handler.Mongo == nil {
+		handler.useModule(module)
+	} else if handler.MongoProvider != nil {
+		handler.gin.Use(mongo.MiddlewareProvider(handler.MongoProvider))
+	}
+
+	// tenant  在 Redis Mongo 之后修改前缀和数据库
+	if handler.Tenant != nil {
+		handler.gin.Use(tenant.Middleware(tenant.Config{
+			Hosts:       handler.Tenant.Hosts,
+			Domain:      handler.Tenant.Domain,
+			Header:      handler.Tenant.Header,
+			Required:    handler.Tenant.Required,
+			Database:    handler.Tenant.Database,
+			Collections: handler.Tenant.Collections,
+		}))
+	}
+
+	// jobs.Enqueue
+	if server.Jobs != nil {
+		handler.gin.Use(jobs.Middleware(server.Jobs.Get()))
+	}
+
+	// streams.Add
+	if server.Streams != nil {
+		handler.gin.Use(streams.Middleware(server.Streams.Get()))
+	}
+
+	// mail.Send
+	if server.Mail != nil {
+		handler.gin.Use(mail.Middleware(server.Mail.Get()))
+	}
+
+	// webhooks.Publish
+	if server.Webhooks != nil {
+		handler.gin.Use(webhooks.Middleware(server.Webhooks.Get()))
 	}
 
+	// jwt 路由上用 jwt.Required() 要求登录
+	if handler.JWT != nil {
+		handler.gin.Use(jwt.MiddlewareVerifier(handler.JWT.Get()))
+	}
+
+	// 撤销的 access token
+	if handler.OAuthServer != nil {
+		handler.gin.Use(handler.OAuthServer.Get().Middleware())
+	}
+
+	// sessions
+	if handler.Sessions != nil {
+		handler.gin.Use(sessions.Middleware(sessions.Config{
+			Store:      handler.Sessions.Store,
+			CookieName: handler.Sessions.CookieName,
+			Domain:     handler.Sessions.Domain,
+			Secure:     handler.Sessions.Secure,
+			MaxAge:     handler.Sessions.MaxAge,
+			Keys:       handler.Sessions.keys(),
+			Encrypt:    handler.Sessions.Encrypt,
+		}))
+	}
+
+	// 签名的链接  在 acl 之前  路由上用 signedurl.Required() 要求签名
+	if handler.SignedURL != nil {
+		handler.gin.Use(handler.SignedURL.middleware(handler.Sessions))
+	}
+
+	// 路由名的权限  在 jwt sessions 之后
+	if handler.ACL != nil {
+		handler.gin.Use(handler.ACL.middleware())
+	}
+
+	// 第二步验证  在 sessions 之后
+	if handler.TOTP != nil {
+		handler.gin.Use(handler.TOTP.middleware())
+	}
+
+	// 请求的时区  在 sessions jwt 之后  Profile 可以读取用户
+	if handler.TimeZone != nil {
+		handler.gin.Use(handler.TimeZone.middleware())
+	}
+
+	// cache control
+	if handler.CacheControl != nil {
+		handler.gin.Use(cachecontrol.MiddlewareNames(cachecontrol.Config{
+			Policies: handler.CacheControl.Policies,
+			Default:  handler.CacheControl.Default,
+		}))
+	}
+
+	// Register 注册的模块
+	handler.gin.Use(server.moduleMiddlewares()...)
+
 	// body size
-	handler.gin.Use(size.Middleware(1024 * 512))
+	sizeModule := server.replaced("size")
+	if sizeModule != nil {
+		handler.useModule(sizeModule)
+	} else {
+		handler.gin.Use(handler.Size.middleware())
+	}
+
+	// 上传文件的类型按内容检查
+	if handler.Sniff != nil {
+		handler.gin.Use(handler.Sniff.middleware())
+	}
+
+	// response size
+	if sizeModule == nil && (handler.Size.ResponseLimit != 0 || len(handler.Size.ResponseRoutes) != 0) {
+		handler.gin.Use(size.ResponseMiddleware(size.ResponseConfig{
+			Limit:  handler.Size.ResponseLimit,
+			Routes: handler.Size.ResponseRoutes,
+			Logger: handler.Logger.Get(),
+		}))
+	}
+
+	// hash 后的文件名  永久缓存
+	var funcs template.FuncMap
+	if handler.Assets != nil {
+		manifest := handler.Assets.manifest(handler.FS)
+		handler.gin.Use(assets.Middleware(manifest))
+		funcs = manifest.Funcs()
+	}
+
+	// 静态文件  不存在时继续
+	if handler.Static != nil {
+		handler.gin.Use(handler.Static.middleware(handler.FS))
+	}
+
+	// templates.HTML
+	if handler.I18n != nil || handler.TimeZone != nil {
+		if funcs == nil {
+			funcs = template.FuncMap{}
+		}
+	}
+	if handler.I18n != nil {
+		for key, val := range handler.I18n.Get(handler.FS).FuncMap() {
+			funcs[key] = val
+		}
+	}
+	if handler.TimeZone != nil {
+		for key, val := range timezone.FuncMap() {
+			funcs[key] = val
+		}
+	}
+	if handler.Templates != nil {
+		handler.gin.Use(handler.Templates.middleware(handler.FS, handler.gin.Routes, funcs))
+	}
+
+	// token introspect revoke jwks
+	if handler.OAuthServer != nil {
+		handler.OAuthServer.Get().Register(handler.gin.Group(handler.OAuthServer.Path))
+	}
 
 	// 未匹配
-	handler.gin.NoRoute(notfound.Middleware())
+	if handler.Proxy != nil {
+		handler.gin.NoRoute(gin.WrapH(handler.Proxy.Get()))
+	} else if module := server.replaced("notfound"); module != nil {
+		if fn := module.Middleware(); fn != nil {
+			handler.gin.NoRoute(fn)
+		}
+	} else {
+		handler.gin.NoRoute(notfound.Middleware())
+	}
 
 }
 
@@ -102,42 +678,18 @@ func (handler *Handler) Get() *gin.Engine {
 	return handler.gin
 }
 
-func (h serverHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
-	switch req.URL.Path {
-	case "/favicon.ico":
-		writer.Header().Set("Content-Type", "image/x-icon")
-		writer.WriteHeader(http.StatusOK)
-		fmt.Fprintln(writer, "")
-	case "/robots.txt":
-		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		writer.WriteHeader(http.StatusOK)
-		fmt.Fprintln(writer, "Disallow: /")
-	case "/crossdomain.xml":
-		writer.Header().Set("Content-Type", "application/xml; charset=utf-8")
-		writer.WriteHeader(http.StatusOK)
-		fmt.Fprintln(writer, "<?xml version=\"1.0\"?><cross-domain-policy></cross-domain-policy>")
-	default:
-		var host string
-		if host = req.Header.Get("X-Forwarded-Host"); host != "" {
-		} else if host = req.Header.Get("X-Host"); host != "" {
-		} else if host = req.Host; host != "" {
-		} else if host = req.URL.Host; host != "" {
-		} else {
-			host = "localhost"
-		}
-
-		if host != "" {
-			if index := strings.LastIndex(host, ":"); index != -1 {
-				host = host[0:index]
-			}
-		}
-
-		if mux, ok := h[host]; ok {
-			mux.ServeHTTP(writer, req)
-		} else if mux, ok := h["default"]; ok {
-			mux.ServeHTTP(writer, req)
-		} else {
-			http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
-		}
+// http 路由之前规范 URL
+func (handler *Handler) http(server *Server) http.Handler {
+	var next http.Handler = handler.gin
+	if handler.Versioning != nil {
+		next = handler.Versioning.wrap(next)
+	}
+	if handler.Canonical != nil {
+		next = handler.Canonical.wrap(server, next)
+	}
+	// 在规范 URL 之后  canary 也收到规范的 URL
+	if handler.Canary != nil {
+		next = handler.Canary.wrap(server, handler, next)
 	}
+	return next
 }
@@ -0,0 +1,20 @@
+package server
+
+type (
+	RequestHeaders struct {
+		MaxCount int `json:"max_count,omitempty"`
+		MaxSize  int `json:"max_size,omitempty"`
+
+		// 例如 X-API-Version
+		Required []string `json:"required,omitempty"`
+	}
+)
+
+func (config *RequestHeaders) init(server *Server, handler *Handler) {
+	if config.MaxCount == 0 {
+		config.MaxCount = 100
+	}
+	if config.MaxSize == 0 {
+		config.MaxSize = 8192
+	}
+}
@@ -0,0 +1,92 @@
+package headers
+
+import (
+	"net/http"
+	"net/textproto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	Config struct {
+		// 请求头数量  多个值分别计算  0 不限制
+		MaxCount int
+
+		// 单个请求头 name + value 的长度  0 不限制
+		MaxSize int
+
+		// 必须的请求头  例如 X-API-Version
+		Required []string
+	}
+)
+
+var ErrTooMany = &errs.Error{
+	Message:    "Too many request headers",
+	Type:       "headers",
+	StatusCode: http.StatusRequestHeaderFieldsTooLarge,
+}
+
+var ErrTooLarge = &errs.Error{
+	Message:    "Request header is too large",
+	Type:       "headers",
+	StatusCode: http.StatusRequestHeaderFieldsTooLarge,
+}
+
+var ErrRequired = &errs.Error{
+	Message:    "Request header is required",
+	Type:       "headers",
+	StatusCode: http.StatusBadRequest,
+}
+
+func Middleware(c Config) gin.HandlerFunc {
+	required := make([]string, len(c.Required))
+	for i, name := range c.Required {
+		required[i] = textproto.CanonicalMIMEHeaderKey(name)
+	}
+
+	return func(ctx *gin.Context) {
+		if err := check(c, required, ctx.Request.Header); err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func check(c Config, required []string, header http.Header) *errs.Error {
+	var count int
+	for name, values := range header {
+		count += len(values)
+		if c.MaxSize == 0 {
+			continue
+		}
+		for _, value := range values {
+			if len(name)+len(value) > c.MaxSize {
+				err := ErrTooLarge.Clone()
+				err.Path = name
+				err.Params = map[string]interface{}{
+					"max": c.MaxSize,
+				}
+				return err
+			}
+		}
+	}
+	if c.MaxCount != 0 && count > c.MaxCount {
+		err := ErrTooMany.Clone()
+		err.Params = map[string]interface{}{
+			"max": c.MaxCount,
+		}
+		return err
+	}
+
+	for _, name := range required {
+		if header.Get(name) == "" {
+			err := ErrRequired.Clone()
+			err.Path = name
+			return err
+		}
+	}
+	return nil
+}
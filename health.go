@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/otamoe/gin-server/health"
+	"github.com/otamoe/gin-server/version"
+)
+
+type (
+	Health struct {
+		// 所有 host 都响应
+		Disabled  bool   `json:"disabled,omitempty"`
+		LivePath  string `json:"live_path,omitempty"`
+		ReadyPath string `json:"ready_path,omitempty"`
+
+		// 构建版本
+		VersionPath string `json:"version_path,omitempty"`
+
+		live    http.Handler
+		ready   http.Handler
+		version http.Handler
+	}
+)
+
+func (config *Health) init(server *Server, handler *Handler) {
+	if config.LivePath == "" {
+		config.LivePath = "/livez"
+	}
+	if config.ReadyPath == "" {
+		config.ReadyPath = "/readyz"
+	}
+	if config.VersionPath == "" {
+		config.VersionPath = "/version"
+	}
+	config.live = health.Live()
+	config.version = version.Handler()
+	config.ready = health.Default.Ready()
+}
+
+// register 内置 mongo redis 检查 和 ModuleHealth
+func (config *Health) register(server *Server) {
+	if server.MongoProvider == nil && server.Mongo != nil && server.Mongo.Lazy {
+		// 未连接时连接  失败时 readyz 返回 503
+		mongo := server.Mongo
+		health.Register("mongo", func(ctx context.Context) error {
+			session, err := mongo.Session()
+			if err != nil {
+				return err
+			}
+			return health.Mongo(session)(ctx)
+		}, true)
+	} else if provider := server.mongoProvider(); provider != nil {
+		health.Register("mongo", health.Mongo(provider.Get()), true)
+	}
+	if provider := server.redisProvider(); provider != nil {
+		// Degraded 时失败只显示 degraded
+		critical := server.RedisProvider != nil || server.Redis == nil || !server.Redis.Degraded
+		health.Register("redis", health.Redis(provider.Get()), critical)
+	}
+	for _, val := range server.modules {
+		if module, ok := val.(ModuleHealth); ok {
+			health.Register("module."+val.Name(), module.Health, true)
+		}
+	}
+}
+
+func (config *Health) get(urlPath string) http.Handler {
+	if config.Disabled {
+		return nil
+	}
+	switch urlPath {
+	case config.LivePath:
+		return config.live
+	case config.ReadyPath:
+		return config.ready
+	case config.VersionPath:
+		return config.version
+	}
+	return nil
+}
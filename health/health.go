@@ -0,0 +1,293 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/backpressure"
+)
+
+type (
+	Check struct {
+		Name  string
+		Check func(ctx context.Context) error
+
+		// 失败时 readyz 返回 503  否则只显示 degraded
+		Critical bool
+
+		Timeout time.Duration
+
+		// 缓存结果 避免每次探测都请求依赖
+		Cache time.Duration
+
+		mutex     sync.Mutex
+		checkedAt time.Time
+		err       error
+	}
+
+	Checks struct {
+		mutex        sync.RWMutex
+		checks       map[string]*Check
+		draining     int32
+		warming      int32
+		initializing int32
+	}
+
+	Result struct {
+		Status   string    `json:"status"`
+		Critical bool      `json:"critical,omitempty"`
+		Error    string    `json:"error,omitempty"`
+		Latency  string    `json:"latency,omitempty"`
+		Time     time.Time `json:"time"`
+	}
+
+	Report struct {
+		Status string             `json:"status"`
+		Checks map[string]*Result `json:"checks,omitempty"`
+	}
+)
+
+const (
+	StatusOK           = "ok"
+	StatusDegraded     = "degraded"
+	StatusFail         = "fail"
+	StatusDraining     = "draining"
+	StatusWarming      = "warming"
+	StatusInitializing = "initializing"
+)
+
+var Default = New()
+
+var DefaultTimeout = time.Second * 3
+
+func New() *Checks {
+	return &Checks{
+		checks: map[string]*Check{},
+	}
+}
+
+// Register 同名替换
+func Register(name string, check func(ctx context.Context) error, critical bool) {
+	Default.Register(name, check, critical)
+}
+
+func RegisterCheck(check *Check) {
+	Default.RegisterCheck(check)
+}
+
+func Unregister(name string) {
+	Default.Unregister(name)
+}
+
+func (checks *Checks) Register(name string, check func(ctx context.Context) error, critical bool) {
+	checks.RegisterCheck(&Check{
+		Name:     name,
+		Check:    check,
+		Critical: critical,
+	})
+}
+
+func (checks *Checks) RegisterCheck(check *Check) {
+	if check.Name == "" || check.Check == nil {
+		panic("Health: name or check is empty")
+	}
+	if check.Timeout == 0 {
+		check.Timeout = DefaultTimeout
+	}
+	checks.mutex.Lock()
+	checks.checks[check.Name] = check
+	checks.mutex.Unlock()
+}
+
+func (checks *Checks) Unregister(name string) {
+	checks.mutex.Lock()
+	delete(checks.checks, name)
+	checks.mutex.Unlock()
+}
+
+// SetDraining 关闭前标记  readyz 直接返回 503
+func (checks *Checks) SetDraining(draining bool) {
+	var val int32
+	if draining {
+		val = 1
+	}
+	atomic.StoreInt32(&checks.draining, val)
+}
+
+func (checks *Checks) Draining() bool {
+	return atomic.LoadInt32(&checks.draining) == 1
+}
+
+// SetWarming 启动预热中  readyz 直接返回 503
+func (checks *Checks) SetWarming(warming bool) {
+	var val int32
+	if warming {
+		val = 1
+	}
+	atomic.StoreInt32(&checks.warming, val)
+}
+
+func (checks *Checks) Warming() bool {
+	return atomic.LoadInt32(&checks.warming) == 1
+}
+
+// SetInitializing 启动初始化中  readyz 直接返回 503
+func (checks *Checks) SetInitializing(initializing bool) {
+	var val int32
+	if initializing {
+		val = 1
+	}
+	atomic.StoreInt32(&checks.initializing, val)
+}
+
+func (checks *Checks) Initializing() bool {
+	return atomic.LoadInt32(&checks.initializing) == 1
+}
+
+// Run 并发执行所有检查
+func (checks *Checks) Run(ctx context.Context) *Report {
+	checks.mutex.RLock()
+	list := make([]*Check, 0, len(checks.checks))
+	for _, check := range checks.checks {
+		list = append(list, check)
+	}
+	checks.mutex.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	report := &Report{
+		Status: StatusOK,
+		Checks: map[string]*Result{},
+	}
+	results := make([]*Result, len(list))
+	var wg sync.WaitGroup
+	for i, check := range list {
+		wg.Add(1)
+		go func(i int, check *Check) {
+			defer wg.Done()
+			results[i] = check.run(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for i, check := range list {
+		result := results[i]
+		report.Checks[check.Name] = result
+		if result.Status == StatusOK {
+			continue
+		}
+		if check.Critical {
+			report.Status = StatusFail
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func (check *Check) run(ctx context.Context) *Result {
+	check.mutex.Lock()
+	defer check.mutex.Unlock()
+
+	start := time.Now()
+	if check.Cache == 0 || check.checkedAt.IsZero() || start.Sub(check.checkedAt) >= check.Cache {
+		ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+		done := make(chan error, 1)
+		go func() {
+			done <- check.Check(ctx)
+		}()
+		select {
+		case check.err = <-done:
+		case <-ctx.Done():
+			check.err = ctx.Err()
+		}
+		cancel()
+		check.checkedAt = time.Now()
+	}
+
+	result := &Result{
+		Status:   StatusOK,
+		Critical: check.Critical,
+		Latency:  check.checkedAt.Sub(start).String(),
+		Time:     check.checkedAt,
+	}
+	if check.checkedAt.Before(start) {
+		// 缓存
+		result.Latency = ""
+	}
+	if check.err != nil {
+		result.Status = StatusFail
+		result.Error = check.err.Error()
+	}
+	return result
+}
+
+// Live 进程存活即可
+func Live() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		write(writer, req, http.StatusOK, &Report{Status: StatusOK})
+	})
+}
+
+// Ready 关键检查失败返回 503  ?verbose 输出每项结果
+func (checks *Checks) Ready() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if checks.Draining() {
+			backpressure.RetryAfter(writer.Header(), backpressure.DefaultRetry)
+			write(writer, req, http.StatusServiceUnavailable, &Report{Status: StatusDraining})
+			return
+		}
+		if checks.Initializing() {
+			backpressure.RetryAfter(writer.Header(), backpressure.DefaultRetry)
+			write(writer, req, http.StatusServiceUnavailable, &Report{Status: StatusInitializing})
+			return
+		}
+		if checks.Warming() {
+			backpressure.RetryAfter(writer.Header(), backpressure.DefaultRetry)
+			write(writer, req, http.StatusServiceUnavailable, &Report{Status: StatusWarming})
+			return
+		}
+		report := checks.Run(req.Context())
+		code := http.StatusOK
+		if report.Status == StatusFail {
+			code = http.StatusServiceUnavailable
+		}
+		if _, ok := req.URL.Query()["verbose"]; !ok && code == http.StatusOK {
+			report.Checks = nil
+		}
+		write(writer, req, code, report)
+	})
+}
+
+func write(writer http.ResponseWriter, req *http.Request, code int, report *Report) {
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(code)
+	if req.Method != http.MethodHead {
+		json.NewEncoder(writer).Encode(report)
+	}
+}
+
+// Mongo 内置检查
+func Mongo(session *mgo.Session) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		session := session.Copy()
+		defer session.Close()
+		return session.Ping()
+	}
+}
+
+// Redis 内置检查
+func Redis(client *redis.Client) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return client.Ping().Err()
+	}
+}
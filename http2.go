@@ -0,0 +1,46 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+type (
+	// HTTP2 只在 TLS 时生效
+	HTTP2 struct {
+		Disabled bool `json:"disabled,omitempty"`
+
+		MaxConcurrentStreams uint32        `json:"max_concurrent_streams,omitempty"`
+		MaxReadFrameSize     uint32        `json:"max_read_frame_size,omitempty"`
+		IdleTimeout          time.Duration `json:"idle_timeout,omitempty"`
+	}
+)
+
+// nextProtos ALPN  NextProtos 为空时根据 HTTP2
+func (server *Server) nextProtos() []string {
+	if len(server.NextProtos) != 0 {
+		return server.NextProtos
+	}
+	if server.HTTP2 != nil && server.HTTP2.Disabled {
+		return []string{"http/1.1"}
+	}
+	return []string{http2.NextProtoTLS, "http/1.1"}
+}
+
+// configureHTTP2 禁用时 TLSNextProto 设置为空 map
+func (server *Server) configureHTTP2(httpServer *http.Server) error {
+	if server.HTTP2 != nil && server.HTTP2.Disabled {
+		httpServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return nil
+	}
+	config := &http2.Server{}
+	if server.HTTP2 != nil {
+		config.MaxConcurrentStreams = server.HTTP2.MaxConcurrentStreams
+		config.MaxReadFrameSize = server.HTTP2.MaxReadFrameSize
+		config.IdleTimeout = server.HTTP2.IdleTimeout
+	}
+	return http2.ConfigureServer(httpServer, config)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/i18n"
+	"github.com/otamoe/gin-server/static"
+)
+
+type (
+	// I18n 有 FS 时 Dir 为 FS 中的目录  handler 的 Dir 为空时使用 server 的翻译  只修改 Default
+	I18n struct {
+		Dir     string `json:"dir,omitempty"`
+		Default string `json:"default,omitempty"`
+		Query   string `json:"query,omitempty"`
+		Cookie  string `json:"cookie,omitempty"`
+
+		server *Server
+		parent *I18n
+		once   sync.Once
+		bundle *i18n.Bundle
+	}
+)
+
+func (config *I18n) init(server *Server, handler *Handler) {
+	config.server = server
+	if handler != nil && config.Dir == "" && server.I18n != nil && server.I18n != config {
+		config.parent = server.I18n
+	}
+}
+
+// Get 第一次使用时加载
+func (config *I18n) Get(fs http.FileSystem) *i18n.Bundle {
+	config.once.Do(func() {
+		if config.parent != nil {
+			config.bundle = config.parent.Get(config.server.FS)
+			if config.Default != "" {
+				config.bundle = config.bundle.WithDefault(config.Default)
+			}
+			return
+		}
+		c := i18n.Config{
+			Default: config.Default,
+			Query:   config.Query,
+			Cookie:  config.Cookie,
+		}
+		if fs != nil {
+			c.FS = static.Sub(fs, config.Dir)
+		} else {
+			c.Dir = config.Dir
+		}
+		config.bundle = i18n.New(c)
+	})
+	return config.bundle
+}
+
+func (config *I18n) middleware(fs http.FileSystem) gin.HandlerFunc {
+	return i18n.Middleware(config.Get(fs))
+}
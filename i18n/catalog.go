@@ -0,0 +1,156 @@
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func (bundle *Bundle) load() (err error) {
+	var dir http.File
+	if dir, err = bundle.config.FS.Open("/"); err != nil {
+		return
+	}
+	defer dir.Close()
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		return
+	}
+
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		ext := path.Ext(info.Name())
+		if ext != ".json" && ext != ".toml" {
+			continue
+		}
+		locale := strings.TrimSuffix(info.Name(), ext)
+
+		var data []byte
+		if data, err = bundle.read(info.Name()); err != nil {
+			return
+		}
+		messages, ok := bundle.messages[locale]
+		if !ok {
+			messages = map[string]string{}
+			bundle.messages[locale] = messages
+			bundle.locales = append(bundle.locales, locale)
+		}
+		switch ext {
+		case ".json":
+			err = parseJSON(data, messages)
+		case ".toml":
+			err = parseTOML(data, messages)
+		}
+		if err != nil {
+			return fmt.Errorf("i18n: %s %s", info.Name(), err)
+		}
+	}
+	sort.Strings(bundle.locales)
+	return
+}
+
+func (bundle *Bundle) read(name string) (data []byte, err error) {
+	var file http.File
+	if file, err = bundle.config.FS.Open("/" + name); err != nil {
+		return
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// parseJSON 嵌套对象使用 . 连接
+func parseJSON(data []byte, messages map[string]string) (err error) {
+	var value map[string]interface{}
+	if err = json.Unmarshal(data, &value); err != nil {
+		return
+	}
+	flatten("", value, messages)
+	return
+}
+
+func flatten(prefix string, value map[string]interface{}, messages map[string]string) {
+	for key, val := range value {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch val := val.(type) {
+		case map[string]interface{}:
+			flatten(key, val, messages)
+		case string:
+			messages[key] = val
+		default:
+			messages[key] = fmt.Sprint(val)
+		}
+	}
+}
+
+// parseTOML 只支持 [table] 和 key = "string"
+func parseTOML(data []byte, messages map[string]string) error {
+	var prefix string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("line %d: invalid table", i+1)
+			}
+			prefix = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		index := strings.Index(line, "=")
+		if index == -1 {
+			return fmt.Errorf("line %d: missing =", i+1)
+		}
+		key := unquoteKey(strings.TrimSpace(line[:index]))
+		value, err := unquoteValue(strings.TrimSpace(line[index+1:]))
+		if err != nil {
+			return fmt.Errorf("line %d: %s", i+1, err)
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		messages[key] = value
+	}
+	return nil
+}
+
+func unquoteKey(key string) string {
+	if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') && key[len(key)-1] == key[0] {
+		return key[1 : len(key)-1]
+	}
+	return key
+}
+
+func unquoteValue(value string) (string, error) {
+	if value == "" {
+		return "", errors.New("missing value")
+	}
+	switch value[0] {
+	case '"':
+		end := strings.LastIndex(value, "\"")
+		if end <= 0 {
+			return "", errors.New("unterminated string")
+		}
+		return strconv.Unquote(value[:end+1])
+	case '\'':
+		end := strings.LastIndex(value, "'")
+		if end <= 0 {
+			return "", errors.New("unterminated string")
+		}
+		return value[1:end], nil
+	}
+	if index := strings.Index(value, "#"); index != -1 {
+		value = strings.TrimSpace(value[:index])
+	}
+	return value, nil
+}
@@ -0,0 +1,131 @@
+package i18n
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// Format 数字和日期格式  翻译文件中的 @decimal @group @date @time @datetime 覆盖
+	// 日期使用 Go 的 layout  只有数字格式  月份名称不翻译
+	Format struct {
+		Decimal  string
+		Group    string
+		Date     string
+		Time     string
+		DateTime string
+	}
+)
+
+// Formats 按 locale 或基础语言查找  都没有时使用 en
+var Formats = map[string]*Format{
+	"en":    {Decimal: ".", Group: ",", Date: "01/02/2006", Time: "3:04 PM", DateTime: "01/02/2006 3:04 PM"},
+	"en-GB": {Decimal: ".", Group: ",", Date: "02/01/2006", Time: "15:04", DateTime: "02/01/2006 15:04"},
+	"de":    {Decimal: ",", Group: ".", Date: "02.01.2006", Time: "15:04", DateTime: "02.01.2006 15:04"},
+	"fr":    {Decimal: ",", Group: " ", Date: "02/01/2006", Time: "15:04", DateTime: "02/01/2006 15:04"},
+	"es":    {Decimal: ",", Group: ".", Date: "02/01/2006", Time: "15:04", DateTime: "02/01/2006 15:04"},
+	"it":    {Decimal: ",", Group: ".", Date: "02/01/2006", Time: "15:04", DateTime: "02/01/2006 15:04"},
+	"nl":    {Decimal: ",", Group: ".", Date: "02-01-2006", Time: "15:04", DateTime: "02-01-2006 15:04"},
+	"pt":    {Decimal: ",", Group: ".", Date: "02/01/2006", Time: "15:04", DateTime: "02/01/2006 15:04"},
+	"ru":    {Decimal: ",", Group: " ", Date: "02.01.2006", Time: "15:04", DateTime: "02.01.2006 15:04"},
+	"pl":    {Decimal: ",", Group: " ", Date: "02.01.2006", Time: "15:04", DateTime: "02.01.2006 15:04"},
+	"sv":    {Decimal: ",", Group: " ", Date: "2006-01-02", Time: "15:04", DateTime: "2006-01-02 15:04"},
+	"ja":    {Decimal: ".", Group: ",", Date: "2006/01/02", Time: "15:04", DateTime: "2006/01/02 15:04"},
+	"ko":    {Decimal: ".", Group: ",", Date: "2006. 01. 02.", Time: "15:04", DateTime: "2006. 01. 02. 15:04"},
+	"zh":    {Decimal: ".", Group: ",", Date: "2006-01-02", Time: "15:04", DateTime: "2006-01-02 15:04"},
+}
+
+// Format locale 的格式  依次使用 locale 基础语言 默认语言 en
+func (bundle *Bundle) Format(locale string) *Format {
+	format := &Format{}
+	for _, val := range []string{locale, base(locale), bundle.config.Default, base(bundle.config.Default), "en"} {
+		if val, ok := Formats[val]; ok {
+			*format = *val
+			break
+		}
+	}
+	for _, val := range []string{base(locale), locale} {
+		messages, ok := bundle.messages[val]
+		if !ok {
+			continue
+		}
+		for key, field := range map[string]*string{
+			"@decimal":  &format.Decimal,
+			"@group":    &format.Group,
+			"@date":     &format.Date,
+			"@time":     &format.Time,
+			"@datetime": &format.DateTime,
+		} {
+			if message, ok := messages[key]; ok {
+				*field = message
+			}
+		}
+	}
+	return format
+}
+
+// FormatNumber decimals 为小数位数  负数时不限制
+func (bundle *Bundle) FormatNumber(locale string, value float64, decimals int) string {
+	return bundle.Format(locale).Number(value, decimals)
+}
+
+// FormatDate style 为 date time datetime  默认 date
+func (bundle *Bundle) FormatDate(locale string, t time.Time, style string) string {
+	return bundle.Format(locale).FormatTime(t, style)
+}
+
+func (format *Format) Number(value float64, decimals int) string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	text := strconv.FormatFloat(math.Abs(value), 'f', decimals, 64)
+	integer, fraction := text, ""
+	if index := strings.IndexByte(text, '.'); index != -1 {
+		integer, fraction = text[:index], text[index+1:]
+	}
+
+	var builder strings.Builder
+	if value < 0 && strings.Trim(text, "0.") != "" {
+		builder.WriteByte('-')
+	}
+	for i, c := range integer {
+		if i != 0 && (len(integer)-i)%3 == 0 {
+			builder.WriteString(format.Group)
+		}
+		builder.WriteRune(c)
+	}
+	if fraction != "" {
+		builder.WriteString(format.Decimal)
+		builder.WriteString(fraction)
+	}
+	return builder.String()
+}
+
+func (format *Format) FormatTime(t time.Time, style string) string {
+	switch style {
+	case "time":
+		return t.Format(format.Time)
+	case "datetime":
+		return t.Format(format.DateTime)
+	}
+	return t.Format(format.Date)
+}
+
+// Number 当前请求的 locale  没有 middleware 时使用 en
+func Number(ctx *gin.Context, value float64, decimals int) string {
+	if bundle := Get(ctx); bundle != nil {
+		return bundle.FormatNumber(Locale(ctx), value, decimals)
+	}
+	return Formats["en"].Number(value, decimals)
+}
+
+func Date(ctx *gin.Context, t time.Time, style string) string {
+	if bundle := Get(ctx); bundle != nil {
+		return bundle.FormatDate(Locale(ctx), t, style)
+	}
+	return Formats["en"].FormatTime(t, style)
+}
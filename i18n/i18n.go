@@ -0,0 +1,240 @@
+package i18n
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	Config struct {
+		// Dir 和 FS 二选一  文件名为 locale 例如 en.json zh-CN.toml
+		Dir string
+		FS  http.FileSystem
+
+		Default string
+
+		Query  string
+		Cookie string
+	}
+
+	Bundle struct {
+		config   Config
+		locales  []string
+		messages map[string]map[string]string
+	}
+
+	acceptLanguage struct {
+		tag string
+		q   float64
+	}
+)
+
+var CONTEXT = "GIN.SERVER.I18N"
+var CONTEXT_LOCALE = "GIN.SERVER.I18N.LOCALE"
+
+func New(c Config) *Bundle {
+	if c.FS == nil {
+		if c.Dir == "" {
+			panic("i18n: dir is empty")
+		}
+		c.FS = http.Dir(c.Dir)
+	}
+	if c.Default == "" {
+		c.Default = "en"
+	}
+	if c.Query == "" {
+		c.Query = "lang"
+	}
+	if c.Cookie == "" {
+		c.Cookie = "lang"
+	}
+	bundle := &Bundle{
+		config:   c,
+		messages: map[string]map[string]string{},
+	}
+	if err := bundle.load(); err != nil {
+		panic(err)
+	}
+	return bundle
+}
+
+func Middleware(bundle *Bundle) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		locale := bundle.detect(ctx)
+		ctx.Set(CONTEXT, bundle)
+		ctx.Set(CONTEXT_LOCALE, locale)
+		ctx.Header("Content-Language", locale)
+		ctx.Writer.Header().Add("Vary", "Accept-Language")
+
+		// errs 中间件 输出前翻译
+		ctx.Set(errs.CONTEXT_TRANSLATE, func(message string, params map[string]interface{}) string {
+			return bundle.Translate(locale, message, params)
+		})
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Bundle {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Bundle)
+	}
+	return nil
+}
+
+func Locale(ctx *gin.Context) string {
+	return ctx.GetString(CONTEXT_LOCALE)
+}
+
+// T 没有 middleware 或没有翻译时返回 key
+func T(ctx *gin.Context, key string, args ...interface{}) string {
+	bundle := Get(ctx)
+	if bundle == nil {
+		return format(key, args)
+	}
+	return bundle.Translate(Locale(ctx), key, args...)
+}
+
+// FuncMap 模板使用 {{ t .Locale "key" }} {{ number .Locale 1234.5 2 }} {{ date .Locale .Time "datetime" }}
+func (bundle *Bundle) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"t":      bundle.Translate,
+		"number": bundle.FormatNumber,
+		"date":   bundle.FormatDate,
+	}
+}
+
+// WithDefault 共用翻译  默认语言不同  例如每个 host 一个
+func (bundle *Bundle) WithDefault(locale string) *Bundle {
+	val := *bundle
+	val.config.Default = locale
+	return &val
+}
+
+func (bundle *Bundle) Default() string {
+	return bundle.config.Default
+}
+
+func (bundle *Bundle) Locales() []string {
+	return bundle.locales
+}
+
+// Translate locale 不存在时依次使用 基础语言 默认语言
+func (bundle *Bundle) Translate(locale string, key string, args ...interface{}) string {
+	for _, val := range []string{locale, base(locale), bundle.config.Default} {
+		if messages, ok := bundle.messages[val]; ok {
+			if message, ok := messages[key]; ok {
+				return format(message, args)
+			}
+		}
+	}
+	return format(key, args)
+}
+
+func (bundle *Bundle) detect(ctx *gin.Context) string {
+	if val := ctx.Query(bundle.config.Query); val != "" {
+		if locale := bundle.match(val); locale != "" {
+			return locale
+		}
+	}
+	if val, err := ctx.Cookie(bundle.config.Cookie); err == nil && val != "" {
+		if locale := bundle.match(val); locale != "" {
+			return locale
+		}
+	}
+	for _, val := range parseAcceptLanguage(ctx.GetHeader("Accept-Language")) {
+		if locale := bundle.match(val.tag); locale != "" {
+			return locale
+		}
+	}
+	return bundle.config.Default
+}
+
+func (bundle *Bundle) match(tag string) string {
+	tag = strings.Replace(strings.TrimSpace(tag), "_", "-", -1)
+	if tag == "" || tag == "*" {
+		return ""
+	}
+	for _, locale := range bundle.locales {
+		if strings.EqualFold(locale, tag) {
+			return locale
+		}
+	}
+	tagBase := base(tag)
+	for _, locale := range bundle.locales {
+		if strings.EqualFold(locale, tagBase) {
+			return locale
+		}
+	}
+	for _, locale := range bundle.locales {
+		if strings.EqualFold(base(locale), tagBase) {
+			return locale
+		}
+	}
+	return ""
+}
+
+func base(tag string) string {
+	if index := strings.Index(tag, "-"); index != -1 {
+		return tag[:index]
+	}
+	return tag
+}
+
+func parseAcceptLanguage(header string) (languages []acceptLanguage) {
+	for _, val := range strings.Split(header, ",") {
+		val = strings.TrimSpace(val)
+		if val == "" {
+			continue
+		}
+		language := acceptLanguage{tag: val, q: 1}
+		if index := strings.Index(val, ";"); index != -1 {
+			language.tag = strings.TrimSpace(val[:index])
+			param := strings.TrimSpace(val[index+1:])
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					language.q = q
+				}
+			}
+		}
+		if language.q > 0 {
+			languages = append(languages, language)
+		}
+	}
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].q > languages[j].q
+	})
+	return
+}
+
+// format 参数是 map 时替换 {name}  否则使用 fmt
+func format(message string, args []interface{}) string {
+	if len(args) == 0 {
+		return message
+	}
+	if len(args) == 1 {
+		var params map[string]interface{}
+		switch val := args[0].(type) {
+		case map[string]interface{}:
+			params = val
+		case gin.H:
+			params = val
+		}
+		if params != nil {
+			for key, val := range params {
+				message = strings.Replace(message, "{"+key+"}", fmt.Sprint(val), -1)
+			}
+			return message
+		}
+	}
+	if strings.Contains(message, "%") {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
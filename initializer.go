@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/otamoe/gin-server/health"
+)
+
+type (
+	// Initializers AddInitializer 注册的函数和 Redis Mongo 连接同时执行  完成前 readyz 返回 503
+	Initializers struct {
+		// 开始监听前等待完成  否则只影响 readyz
+		Wait bool `json:"wait,omitempty"`
+
+		// 超时后不再等待  readyz 变为就绪  默认 1 分钟
+		Timeout time.Duration `json:"timeout,omitempty"`
+	}
+
+	// Initializer 预热缓存 编译模板等  ctx 在 Timeout 后结束
+	Initializer func(ctx context.Context) error
+
+	initializer struct {
+		name string
+		run  Initializer
+	}
+)
+
+func (config *Initializers) init(server *Server, handler *Handler) {
+	if config.Timeout == 0 {
+		config.Timeout = time.Minute
+	}
+}
+
+// AddInitializer Init 之前注册
+func (server *Server) AddInitializer(name string, fn Initializer) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.initialized != nil {
+		panic("Server: AddInitializer " + name + " after Init")
+	}
+	for _, val := range server.initializers {
+		if val.name == name {
+			panic("Server: initializer " + name + " has exists")
+		}
+	}
+	server.initializers = append(server.initializers, initializer{name: name, run: fn})
+}
+
+// Initialized 全部完成或超时后关闭
+func (server *Server) Initialized() <-chan struct{} {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.initialized
+}
+
+// InitializerErrors 失败和超时的  完成之前为空
+func (server *Server) InitializerErrors() map[string]error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	errs := map[string]error{}
+	for name, err := range server.initErrs {
+		errs[name] = err
+	}
+	return errs
+}
+
+// runInitializers 后台并发执行  失败只记录日志
+func (server *Server) runInitializers() {
+	server.mutex.Lock()
+	initialized := make(chan struct{})
+	server.initialized = initialized
+	initializers := server.initializers
+	server.mutex.Unlock()
+
+	if len(initializers) == 0 {
+		close(initialized)
+		return
+	}
+	if server.Initializers == nil {
+		server.Initializers = &Initializers{}
+		server.Initializers.init(server, nil)
+	}
+
+	health.Default.SetInitializing(true)
+	ctx, cancel := context.WithTimeout(context.Background(), server.Initializers.Timeout)
+	logger := server.Logger.Get()
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	// name => 错误  成功为 nil
+	results := map[string]error{}
+	var mutex sync.Mutex
+	for _, val := range initializers {
+		wg.Add(1)
+		go func(val initializer) {
+			defer wg.Done()
+			start := time.Now()
+			err := val.run(ctx)
+			if err == nil {
+				err = ctx.Err()
+			}
+			mutex.Lock()
+			results[val.name] = err
+			mutex.Unlock()
+			if err != nil {
+				logger.Errorf("[INIT] %s: %s", val.name, err)
+				return
+			}
+			logger.Infof("[INIT] %s %s", val.name, time.Since(start))
+		}(val)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	go func() {
+		defer cancel()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			logger.Warnf("[INIT] timeout after %s", server.Initializers.Timeout)
+		}
+		// 超时未完成的使用 ctx 的错误
+		errs := map[string]error{}
+		mutex.Lock()
+		for _, val := range initializers {
+			if err, ok := results[val.name]; !ok {
+				errs[val.name] = context.DeadlineExceeded
+			} else if err != nil {
+				errs[val.name] = err
+			}
+		}
+		mutex.Unlock()
+		server.mutex.Lock()
+		server.initErrs = errs
+		server.mutex.Unlock()
+		health.Default.SetInitializing(false)
+		logger.Infof("[INIT] done %s, %d failed", time.Since(start), len(errs))
+		close(initialized)
+	}()
+}
+
+// waitInitializers Wait 时开始监听之前执行
+func (server *Server) waitInitializers() {
+	if server.Initializers == nil || !server.Initializers.Wait {
+		return
+	}
+	<-server.Initialized()
+}
@@ -0,0 +1,584 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/clock"
+	"github.com/otamoe/gin-server/jsoncodec"
+	"github.com/otamoe/gin-server/utils"
+)
+
+type (
+	// InitStep Init 的一步  Needs 中的先执行  没有依赖关系时按添加的顺序
+	InitStep struct {
+		Name string
+
+		// 先执行这些  例如 logger redis mongo metrics
+		Needs []string
+
+		// 在这些之前执行  例如 initializers 在 redis mongo 连接之前开始
+		Before []string
+
+		Run func(server *Server) error
+	}
+)
+
+// AddInitStep Init 之前添加  第三方模块声明依赖  不依赖内置的调用顺序
+func (server *Server) AddInitStep(step InitStep) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.initialized != nil {
+		panic("Server: AddInitStep " + step.Name + " after Init")
+	}
+	server.initSteps = append(server.initSteps, step)
+}
+
+// InitOrder Init 执行的顺序
+func (server *Server) InitOrder() (names []string, err error) {
+	steps, err := sortInitSteps(server.steps())
+	if err != nil {
+		return
+	}
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	return
+}
+
+// steps 代替内置的模块使用内置的 InitStep  加上 ModuleNeeds
+func (server *Server) steps() []InitStep {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	steps := builtinInitSteps()
+	for i, step := range steps {
+		for _, module := range server.modules {
+			if val, ok := module.(ModuleNeeds); ok && module.Name() == step.Name {
+				steps[i].Needs = append(step.Needs, val.Needs()...)
+			}
+		}
+	}
+	return append(steps, server.initSteps...)
+}
+
+// sortInitSteps 拓扑排序  每次取添加顺序最靠前的  有环时返回环上的名字
+func sortInitSteps(steps []InitStep) ([]InitStep, error) {
+	index := map[string]int{}
+	for i, step := range steps {
+		if step.Name == "" {
+			return nil, errors.New("Server: init step name is empty")
+		}
+		if _, ok := index[step.Name]; ok {
+			return nil, errors.New("Server: init step " + step.Name + " has exists")
+		}
+		index[step.Name] = i
+	}
+
+	// needs[i] 是 i 之前需要执行的
+	needs := make([][]int, len(steps))
+	for i, step := range steps {
+		for _, name := range step.Needs {
+			j, ok := index[name]
+			if !ok {
+				return nil, errors.New("Server: init step " + step.Name + " needs unknown " + name)
+			}
+			needs[i] = append(needs[i], j)
+		}
+		for _, name := range step.Before {
+			j, ok := index[name]
+			if !ok {
+				return nil, errors.New("Server: init step " + step.Name + " before unknown " + name)
+			}
+			needs[j] = append(needs[j], i)
+		}
+	}
+
+	done := make([]bool, len(steps))
+	sorted := make([]InitStep, 0, len(steps))
+	for len(sorted) < len(steps) {
+		next := -1
+		for i := range steps {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for _, j := range needs[i] {
+				ready = ready && done[j]
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			return nil, errors.New("Server: init cycle " + strings.Join(initCycle(steps, needs, done), " -> "))
+		}
+		done[next] = true
+		sorted = append(sorted, steps[next])
+	}
+	return sorted, nil
+}
+
+// initCycle 从未完成的步骤沿依赖找到重复的
+func initCycle(steps []InitStep, needs [][]int, done []bool) []string {
+	start := 0
+	for done[start] {
+		start++
+	}
+	seen := map[int]int{}
+	var path []int
+	for i := start; ; {
+		if pos, ok := seen[i]; ok {
+			var names []string
+			for _, j := range append(path[pos:], i) {
+				names = append(names, steps[j].Name)
+			}
+			// 依赖方向相反  按执行顺序输出
+			for l, r := 0, len(names)-1; l < r; l, r = l+1, r-1 {
+				names[l], names[r] = names[r], names[l]
+			}
+			return names
+		}
+		seen[i] = len(path)
+		path = append(path, i)
+		for _, j := range needs[i] {
+			if !done[j] {
+				i = j
+				break
+			}
+		}
+	}
+}
+
+// builtinInitSteps 内置配置的顺序  没有配置的跳过
+func builtinInitSteps() []InitStep {
+	return []InitStep{
+		{Name: "server", Run: func(server *Server) error {
+			if server.Addr == "" {
+				if server.Certificates == nil {
+					server.Addr = ":8080"
+				} else {
+					server.Addr = ":8443"
+				}
+			}
+
+			if strings.HasSuffix(server.Addr, ":443") || strings.HasSuffix(server.Addr, ":8443") || (server.Certificates != nil && len(server.Certificates) == 0) {
+				if len(server.Certificates) == 0 {
+					priv, cert, err := NewCertificate("localhost", []string{"localhost"}, "ecdsa", 384)
+					if err != nil {
+						return err
+					}
+
+					certificate, err := EncodeCertificate(priv, cert)
+					if err != nil {
+						return err
+					}
+
+					server.Certificates = append(server.Certificates, certificate)
+				}
+			}
+
+			var err error
+			if server.trustedProxies, err = utils.ParseCIDRs(server.TrustedProxies); err != nil {
+				return err
+			}
+
+			if err = server.initBinding(); err != nil {
+				return err
+			}
+
+			if server.Clock != nil {
+				clock.Set(server.Clock)
+			}
+
+			if server.JSONCodec != nil {
+				jsoncodec.Set(server.JSONCodec)
+			} else if server.JSON != "" {
+				codec, _ := jsoncodec.Named(server.JSON)
+				jsoncodec.Set(codec)
+			}
+
+			if server.ReadTimeout == 0 {
+				server.ReadTimeout = time.Second * 20
+			}
+			if server.ReadHeaderTimeout == 0 {
+				server.ReadHeaderTimeout = time.Second * 10
+			}
+			if server.WriteTimeout == 0 {
+				server.WriteTimeout = time.Second * 30
+			}
+			if server.IdleTimeout == 0 {
+				server.IdleTimeout = time.Second * 300
+			}
+			if server.ShutdownTimeout == 0 {
+				server.ShutdownTimeout = time.Second * 30
+			}
+			if len(server.Signals) == 0 {
+				server.Signals = []string{"SIGINT", "SIGTERM"}
+			}
+			if _, err := parseSignals(server.Signals); err != nil {
+				return err
+			}
+
+			server.rateLimits.Store(server.RateLimits)
+			if server.Flags != nil {
+				for name, value := range server.Features {
+					if err := server.Flags.Set(name, value); err != nil {
+						return err
+					}
+				}
+			}
+
+			// gin
+			switch server.ENV {
+			case "development":
+				gin.SetMode(gin.DebugMode)
+			case "test":
+				gin.SetMode(gin.TestMode)
+			default:
+				gin.SetMode(gin.ReleaseMode)
+			}
+			return nil
+		}},
+		{Name: "compress", Needs: []string{"server"}, Run: func(server *Server) error {
+			return server.configureBuiltin("compress")
+		}},
+		{Name: "logger", Needs: []string{"server"}, Run: func(server *Server) error {
+			return server.configureBuiltin("logger")
+		}},
+		{Name: "size", Needs: []string{"server"}, Run: func(server *Server) error {
+			return server.configureBuiltin("size")
+		}},
+		{Name: "errors", Needs: []string{"logger"}, Run: func(server *Server) error {
+			if server.Errors == nil {
+				server.Errors = &Errors{}
+			}
+			server.Errors.init(server, nil)
+			return nil
+		}},
+		{Name: "notfound", Needs: []string{"errors"}, Run: func(server *Server) error {
+			return server.configureBuiltin("notfound")
+		}},
+		{Name: "builtins", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Builtins == nil {
+				server.Builtins = &Builtins{}
+			}
+			server.Builtins.init(server, nil)
+			return nil
+		}},
+		{Name: "static", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Static != nil {
+				server.Static.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "templates", Needs: []string{"logger"}, Run: func(server *Server) error {
+			if server.Templates != nil {
+				server.Templates.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "i18n", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.I18n != nil {
+				server.I18n.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "timezone", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.TimeZone != nil {
+				server.TimeZone.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "assets", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Assets != nil {
+				server.Assets.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "acme", Needs: []string{"logger"}, Run: func(server *Server) error {
+			if server.ACME != nil {
+				server.ACME.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "cors", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Cors != nil {
+				server.Cors.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "canonical", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Canonical != nil {
+				server.Canonical.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "versioning", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Versioning != nil {
+				server.Versioning.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "deprecation", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Deprecation != nil {
+				server.Deprecation.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "sniff", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Sniff != nil {
+				server.Sniff.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "acl", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.ACL != nil {
+				server.ACL.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "totp", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.TOTP != nil {
+				server.TOTP.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "oauth_server", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.OAuthServer != nil {
+				server.OAuthServer.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "signed_url", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.SignedURL != nil {
+				server.SignedURL.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "secure", Needs: []string{"server"}, Run: func(server *Server) error {
+			// 有证书时 默认开启
+			if server.Secure == nil && len(server.Certificates) != 0 {
+				server.Secure = &Secure{}
+			}
+			if server.Secure != nil {
+				server.Secure.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "jwt", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.JWT != nil {
+				server.JWT.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "sessions", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Sessions != nil {
+				server.Sessions.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "metrics", Needs: []string{"logger"}, Run: func(server *Server) error {
+			if server.Metrics != nil {
+				server.Metrics.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "tracing", Needs: []string{"logger"}, Run: func(server *Server) error {
+			if server.Tracing != nil {
+				server.Tracing.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "recorder", Needs: []string{"logger"}, Run: func(server *Server) error {
+			if server.Recorder != nil {
+				server.Recorder.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "headers", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Headers != nil {
+				server.Headers.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "timeout", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Timeout != nil {
+				server.Timeout.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "tenant", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Tenant != nil {
+				server.Tenant.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "concurrent", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Concurrent != nil {
+				server.Concurrent.init(server, nil)
+			}
+			return nil
+		}},
+		// 输出指标的中间件在 metrics 之后
+		{Name: "load_shed", Needs: []string{"metrics"}, Run: func(server *Server) error {
+			if server.LoadShed != nil {
+				server.LoadShed.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "admission", Needs: []string{"metrics"}, Run: func(server *Server) error {
+			if server.Admission != nil {
+				server.Admission.init(server, nil)
+			}
+			return nil
+		}},
+		// 和 Redis Mongo 连接同时执行
+		{Name: "initializers", Needs: []string{"logger"}, Before: []string{"redis", "mongo"}, Run: func(server *Server) error {
+			if server.Initializers != nil {
+				server.Initializers.init(server, nil)
+			}
+			server.runInitializers()
+			return nil
+		}},
+		{Name: "redis", Needs: []string{"logger"}, Run: func(server *Server) error {
+			return server.configureBuiltin("redis")
+		}},
+		{Name: "mongo", Needs: []string{"logger"}, Run: func(server *Server) error {
+			return server.configureBuiltin("mongo")
+		}},
+		{Name: "jobs", Needs: []string{"redis", "mongo"}, Run: func(server *Server) error {
+			if server.Jobs != nil {
+				server.Jobs.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "webhooks", Needs: []string{"redis", "mongo"}, Run: func(server *Server) error {
+			if server.Webhooks != nil {
+				server.Webhooks.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "mail", Needs: []string{"logger"}, Run: func(server *Server) error {
+			if server.Mail != nil {
+				server.Mail.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "notify", Needs: []string{"mail"}, Run: func(server *Server) error {
+			if server.Notify != nil {
+				server.Notify.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "outbox", Needs: []string{"mongo"}, Run: func(server *Server) error {
+			if server.Outbox != nil {
+				server.Outbox.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "streams", Needs: []string{"redis"}, Run: func(server *Server) error {
+			if server.Streams != nil {
+				server.Streams.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "scheduler", Needs: []string{"redis", "mongo"}, Run: func(server *Server) error {
+			if server.Scheduler != nil {
+				server.Scheduler.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "events", Needs: []string{"redis", "mongo"}, Run: func(server *Server) error {
+			if server.Events != nil {
+				server.Events.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "seed", Needs: []string{"redis", "mongo"}, Run: func(server *Server) error {
+			if server.Seed != nil {
+				server.Seed.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "migrate", Needs: []string{"mongo"}, Run: func(server *Server) error {
+			if server.Migrate != nil {
+				server.Migrate.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "backup", Needs: []string{"mongo"}, Run: func(server *Server) error {
+			if server.Backup != nil {
+				server.Backup.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "warmup", Needs: []string{"redis", "mongo"}, Run: func(server *Server) error {
+			if server.Warmup != nil {
+				server.Warmup.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "openapi", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.OpenAPI != nil {
+				server.OpenAPI.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "capture", Needs: []string{"mongo"}, Run: func(server *Server) error {
+			if server.Capture != nil {
+				server.Capture.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "alerts", Needs: []string{"metrics"}, Run: func(server *Server) error {
+			if server.Alerts != nil {
+				server.Alerts.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "cdn", Needs: []string{"server"}, Run: func(server *Server) error {
+			for _, val := range server.CDN {
+				val.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "slowloris", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Slowloris != nil {
+				server.Slowloris.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "proxy_protocol", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.ProxyProtocol != nil {
+				server.ProxyProtocol.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "normalize", Needs: []string{"server"}, Run: func(server *Server) error {
+			if server.Normalize != nil {
+				server.Normalize.init(server, nil)
+			}
+			return nil
+		}},
+		{Name: "switch", Needs: []string{"logger"}, Run: func(server *Server) error {
+			if server.Switch != nil {
+				server.Switch.init(server, nil)
+			}
+			return nil
+		}},
+		// 检查依赖的连接
+		{Name: "health", Needs: []string{"redis", "mongo", "metrics"}, Run: func(server *Server) error {
+			if server.Health == nil {
+				server.Health = &Health{}
+			}
+			server.Health.init(server, nil)
+			if !server.Health.Disabled {
+				server.Health.register(server)
+			}
+			return nil
+		}},
+	}
+}
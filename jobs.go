@@ -0,0 +1,44 @@
+package server
+
+import (
+	"github.com/otamoe/gin-server/jobs"
+)
+
+type (
+	Jobs struct {
+		Prefix      string   `json:"prefix,omitempty"`
+		Queues      []string `json:"queues,omitempty"`
+		Concurrency int      `json:"concurrency,omitempty"`
+
+		// 只入队 不执行
+		Disabled bool `json:"disabled,omitempty"`
+
+		queue *jobs.Queue
+	}
+)
+
+func (config *Jobs) init(server *Server, handler *Handler) {
+	if config.queue != nil {
+		return
+	}
+	provider := server.redisProvider()
+	if provider == nil {
+		panic("Jobs: redis is empty")
+	}
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = "jobs"
+	}
+	prefix = server.redisPrefix() + prefix
+	config.queue = jobs.New(jobs.Config{
+		Client:      provider.Get(),
+		Prefix:      prefix,
+		Queues:      config.Queues,
+		Concurrency: config.Concurrency,
+		Logger:      server.Logger.Get(),
+	})
+}
+
+func (config *Jobs) Get() *jobs.Queue {
+	return config.queue
+}
@@ -0,0 +1,379 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	Config struct {
+		Client *redis.Client
+		Prefix string
+
+		// 监听的队列 第一个为默认队列
+		Queues      []string
+		Concurrency int
+
+		// 第几次重试的等待时间
+		Backoff func(attempt int) time.Duration
+
+		Logger *logrus.Logger
+	}
+
+	Options struct {
+		Queue      string
+		Delay      time.Duration
+		MaxRetries int
+		Timeout    time.Duration
+	}
+
+	Handler func(ctx context.Context, job *Job) error
+
+	Job struct {
+		ID         string          `json:"id"`
+		Name       string          `json:"name"`
+		Queue      string          `json:"queue"`
+		Payload    json.RawMessage `json:"payload,omitempty"`
+		Attempts   int             `json:"attempts"`
+		MaxRetries int             `json:"max_retries"`
+		Timeout    time.Duration   `json:"timeout,omitempty"`
+		Error      string          `json:"error,omitempty"`
+		CreatedAt  time.Time       `json:"created_at"`
+	}
+
+	Queue struct {
+		config   Config
+		handlers map[string]Handler
+		mutex    sync.RWMutex
+		worker   string
+
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+
+		processed *metrics.Counter
+		duration  *metrics.Histogram
+	}
+)
+
+var CONTEXT = "GIN.SERVER.JOBS"
+
+var ErrNoQueue = errors.New("Jobs: queue middleware is not used")
+
+// ErrHandler 未注册的 job 直接进入 dead
+var ErrHandler = errors.New("Jobs: handler not found")
+
+func New(c Config) *Queue {
+	if c.Client == nil {
+		panic("Jobs: redis client is empty")
+	}
+	if c.Prefix == "" {
+		c.Prefix = "jobs"
+	}
+	if len(c.Queues) == 0 {
+		c.Queues = []string{"default"}
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = 10
+	}
+	if c.Backoff == nil {
+		c.Backoff = Backoff
+	}
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	hostname, _ := os.Hostname()
+	return &Queue{
+		config:    c,
+		handlers:  map[string]Handler{},
+		worker:    hostname + "." + strconv.Itoa(os.Getpid()),
+		processed: metrics.Default.Counter("jobs_processed_total", "Processed jobs.", "name", "status"),
+		duration:  metrics.Default.Histogram("jobs_duration_seconds", "Job latency.", nil, "name"),
+	}
+}
+
+// Backoff 指数退避 最大 1 小时
+func Backoff(attempt int) time.Duration {
+	if attempt > 12 {
+		attempt = 12
+	}
+	return time.Second * time.Duration(1<<uint(attempt))
+}
+
+func Middleware(queue *Queue) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, queue)
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Queue {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Queue)
+	}
+	return nil
+}
+
+// Enqueue 在 handler 中使用
+func Enqueue(ctx *gin.Context, name string, payload interface{}, opts Options) (*Job, error) {
+	queue := Get(ctx)
+	if queue == nil {
+		return nil, ErrNoQueue
+	}
+	return queue.Enqueue(name, payload, opts)
+}
+
+func (queue *Queue) Register(name string, handler Handler) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if _, ok := queue.handlers[name]; ok {
+		panic("Jobs: " + name + " has exists")
+	}
+	queue.handlers[name] = handler
+}
+
+func (queue *Queue) Enqueue(name string, payload interface{}, opts Options) (job *Job, err error) {
+	if opts.Queue == "" {
+		opts.Queue = queue.config.Queues[0]
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 5
+	}
+	job = &Job{
+		ID:         bson.NewObjectId().Hex(),
+		Name:       name,
+		Queue:      opts.Queue,
+		MaxRetries: opts.MaxRetries,
+		Timeout:    opts.Timeout,
+		CreatedAt:  time.Now(),
+	}
+	if payload != nil {
+		if job.Payload, err = json.Marshal(payload); err != nil {
+			return
+		}
+	}
+	err = queue.push(job, opts.Delay)
+	return
+}
+
+// Bind 解析 payload
+func (job *Job) Bind(data interface{}) error {
+	return json.Unmarshal(job.Payload, data)
+}
+
+// Start 启动 worker  重新入队本机上次未完成的 job
+func (queue *Queue) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	queue.cancel = cancel
+
+	for _, name := range queue.config.Queues {
+		for {
+			if err := queue.config.Client.RPopLPush(queue.processingKey(name), queue.key(name)).Err(); err != nil {
+				break
+			}
+		}
+	}
+
+	queue.wg.Add(1)
+	go queue.schedule(ctx)
+
+	for i := 0; i < queue.config.Concurrency; i++ {
+		name := queue.config.Queues[i%len(queue.config.Queues)]
+		queue.wg.Add(1)
+		go queue.work(ctx, name)
+	}
+}
+
+// Stop 等待正在执行的 job
+func (queue *Queue) Stop(ctx context.Context) error {
+	if queue.cancel == nil {
+		return nil
+	}
+	queue.cancel()
+	done := make(chan struct{})
+	go func() {
+		queue.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Dead 死信队列
+func (queue *Queue) Dead(start, stop int64) (jobs []*Job, err error) {
+	values, err := queue.config.Client.LRange(queue.config.Prefix+".dead", start, stop).Result()
+	if err != nil {
+		return
+	}
+	for _, val := range values {
+		job := &Job{}
+		if json.Unmarshal([]byte(val), job) == nil {
+			jobs = append(jobs, job)
+		}
+	}
+	return
+}
+
+// Retry 从死信队列重新入队
+func (queue *Queue) Retry(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	job.Attempts = 0
+	job.Error = ""
+	if err = queue.push(job, 0); err != nil {
+		return err
+	}
+	return queue.config.Client.LRem(queue.config.Prefix+".dead", 1, data).Err()
+}
+
+func (queue *Queue) key(name string) string {
+	return queue.config.Prefix + "." + name
+}
+
+func (queue *Queue) processingKey(name string) string {
+	return queue.config.Prefix + "." + name + ".processing." + queue.worker
+}
+
+func (queue *Queue) push(job *Job, delay time.Duration) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if delay > 0 {
+		return queue.config.Client.ZAdd(queue.config.Prefix+".delayed", redis.Z{
+			Score:  float64(time.Now().Add(delay).UnixNano()),
+			Member: data,
+		}).Err()
+	}
+	return queue.config.Client.LPush(queue.key(job.Queue), data).Err()
+}
+
+// schedule 到期的延迟 job 移入队列  ZRem 成功的实例负责入队
+func (queue *Queue) schedule(ctx context.Context) {
+	defer queue.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	key := queue.config.Prefix + ".delayed"
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		values, err := queue.config.Client.ZRangeByScore(key, redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().UnixNano(), 10),
+			Count: 100,
+		}).Result()
+		if err != nil {
+			continue
+		}
+		for _, val := range values {
+			if n, err := queue.config.Client.ZRem(key, val).Result(); err != nil || n == 0 {
+				continue
+			}
+			job := &Job{}
+			if err := json.Unmarshal([]byte(val), job); err != nil {
+				continue
+			}
+			queue.config.Client.LPush(queue.key(job.Queue), val)
+		}
+	}
+}
+
+func (queue *Queue) work(ctx context.Context, name string) {
+	defer queue.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		val, err := queue.config.Client.BRPopLPush(queue.key(name), queue.processingKey(name), time.Second).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		queue.process(name, val)
+	}
+}
+
+func (queue *Queue) process(name string, val string) {
+	defer queue.config.Client.LRem(queue.processingKey(name), 1, val)
+
+	job := &Job{}
+	if err := json.Unmarshal([]byte(val), job); err != nil {
+		queue.config.Logger.Errorf("[JOBS] %s invalid %s", name, err)
+		return
+	}
+
+	queue.mutex.RLock()
+	handler, ok := queue.handlers[job.Name]
+	queue.mutex.RUnlock()
+
+	start := time.Now()
+	err := ErrHandler
+	if ok {
+		err = queue.run(handler, job)
+	}
+	queue.duration.Observe(time.Since(start).Seconds(), job.Name)
+	job.Attempts++
+
+	if err == nil {
+		queue.processed.Inc(job.Name, "success")
+		return
+	}
+	job.Error = err.Error()
+
+	if ok && job.Attempts <= job.MaxRetries {
+		queue.processed.Inc(job.Name, "retry")
+		queue.config.Logger.Warnf("[JOBS] %s %s attempt %d %s", job.Name, job.ID, job.Attempts, err)
+		queue.push(job, queue.config.Backoff(job.Attempts))
+		return
+	}
+
+	queue.processed.Inc(job.Name, "dead")
+	queue.config.Logger.Errorf("[JOBS] %s %s dead %s", job.Name, job.ID, err)
+	if data, err := json.Marshal(job); err == nil {
+		queue.config.Client.LPush(queue.config.Prefix+".dead", data)
+	}
+}
+
+func (queue *Queue) run(handler Handler, job *Job) (err error) {
+	ctx := context.Background()
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
@@ -0,0 +1,288 @@
+package jsonapi
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/cachecontrol"
+	"github.com/otamoe/gin-server/jsoncodec"
+	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/otamoe/gin-server/respond"
+)
+
+type (
+	// Document https://jsonapi.org/format/#document-structure
+	Document struct {
+		Data     interface{}            `json:"data"`
+		Included []*Resource            `json:"included,omitempty"`
+		Meta     map[string]interface{} `json:"meta,omitempty"`
+		Links    map[string]string      `json:"links,omitempty"`
+	}
+
+	Resource struct {
+		Type          string                   `json:"type"`
+		ID            string                   `json:"id,omitempty"`
+		Attributes    map[string]interface{}   `json:"attributes,omitempty"`
+		Relationships map[string]*Relationship `json:"relationships,omitempty"`
+		Links         map[string]string        `json:"links,omitempty"`
+	}
+
+	// Relationship Data 为 *Identifier  []*Identifier 或 nil
+	Relationship struct {
+		Data  interface{}       `json:"data"`
+		Links map[string]string `json:"links,omitempty"`
+	}
+
+	Identifier struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}
+)
+
+const MediaType = "application/vnd.api+json"
+
+// New 根据 struct 生成  json tag 为属性名
+// `jsonapi:"id"` 或 _id id 为 ID  `jsonapi:"rel,users"` 为关联
+func New(typ string, value interface{}) *Resource {
+	resource := &Resource{
+		Type:          typ,
+		Attributes:    map[string]interface{}{},
+		Relationships: map[string]*Relationship{},
+	}
+	val := reflect.Indirect(reflect.ValueOf(value))
+	if val.Kind() == reflect.Struct {
+		resource.fields(val)
+	}
+	return resource
+}
+
+func (resource *Resource) fields(val reflect.Value) {
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		name, opts := tag, ""
+		if index := strings.Index(tag, ","); index != -1 {
+			name, opts = tag[:index], tag[index:]
+		}
+		fieldValue := val.Field(i)
+		if field.Anonymous && name == "" {
+			if fieldValue = reflect.Indirect(fieldValue); fieldValue.Kind() == reflect.Struct {
+				resource.fields(fieldValue)
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		apiTag := strings.Split(field.Tag.Get("jsonapi"), ",")
+		switch {
+		case apiTag[0] == "id" || (apiTag[0] == "" && (name == "_id" || name == "id")):
+			resource.ID = ID(fieldValue.Interface())
+		case apiTag[0] == "rel" && len(apiTag) > 1:
+			resource.Relate(name, apiTag[1], fieldValue.Interface())
+		case apiTag[0] == "-":
+		default:
+			if strings.Contains(opts, ",omitempty") && isEmptyValue(fieldValue) {
+				continue
+			}
+			resource.Attributes[name] = fieldValue.Interface()
+		}
+	}
+}
+
+// Relate ids 为单个 id 时 to-one  slice 时 to-many  nil 时 data 为 null
+func (resource *Resource) Relate(name string, typ string, ids interface{}) *Relationship {
+	relationship := &Relationship{}
+	val := reflect.ValueOf(ids)
+	switch {
+	case ids == nil, (val.Kind() == reflect.Ptr && val.IsNil()):
+	case val.Kind() == reflect.Slice && val.Type() != reflect.TypeOf([]byte{}):
+		data := make([]*Identifier, 0, val.Len())
+		for i := 0; i < val.Len(); i++ {
+			data = append(data, &Identifier{Type: typ, ID: ID(val.Index(i).Interface())})
+		}
+		relationship.Data = data
+	default:
+		if id := ID(ids); id != "" {
+			relationship.Data = &Identifier{Type: typ, ID: id}
+		}
+	}
+	if resource.Relationships == nil {
+		resource.Relationships = map[string]*Relationship{}
+	}
+	resource.Relationships[name] = relationship
+	return relationship
+}
+
+// ID ObjectId 使用 hex
+func ID(value interface{}) string {
+	val := reflect.Indirect(reflect.ValueOf(value))
+	if !val.IsValid() {
+		return ""
+	}
+	switch id := val.Interface().(type) {
+	case bson.ObjectId:
+		if id == "" {
+			return ""
+		}
+		return id.Hex()
+	case fmt.Stringer:
+		return id.String()
+	default:
+		return fmt.Sprint(id)
+	}
+}
+
+// Fields sparse fieldsets  ?fields[users]=name,email
+func Fields(ctx *gin.Context, resources ...*Resource) {
+	fields := map[string]map[string]bool{}
+	for key, values := range ctx.Request.URL.Query() {
+		if !strings.HasPrefix(key, "fields[") || !strings.HasSuffix(key, "]") || len(values) == 0 {
+			continue
+		}
+		only := map[string]bool{}
+		for _, name := range strings.Split(values[0], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				only[name] = true
+			}
+		}
+		fields[key[len("fields["):len(key)-1]] = only
+	}
+	if len(fields) == 0 {
+		return
+	}
+	for _, resource := range resources {
+		only, ok := fields[resource.Type]
+		if !ok {
+			continue
+		}
+		for name := range resource.Attributes {
+			if !only[name] {
+				delete(resource.Attributes, name)
+			}
+		}
+		for name := range resource.Relationships {
+			if !only[name] {
+				delete(resource.Relationships, name)
+			}
+		}
+	}
+}
+
+// Render data 为 *Resource 或 []*Resource  links 使用 resource 的反向路由  type.show
+func Render(ctx *gin.Context, code int, data interface{}, included ...*Resource) {
+	var resources []*Resource
+	switch val := data.(type) {
+	case *Resource:
+		if val != nil {
+			resources = append(resources, val)
+		} else {
+			data = nil
+		}
+	case []*Resource:
+		if val == nil {
+			data = []*Resource{}
+		}
+		resources = append(resources, val...)
+	}
+	resources = append(resources, included...)
+	Fields(ctx, resources...)
+	for _, resource := range resources {
+		if resource.ID == "" {
+			continue
+		}
+		if _, ok := resource.Links["self"]; ok {
+			continue
+		}
+		if self, err := ginResource.URLFor(ctx, resource.Type+".show", resource.ID); err == nil {
+			if resource.Links == nil {
+				resource.Links = map[string]string{}
+			}
+			resource.Links["self"] = self
+		}
+	}
+
+	doc := &Document{
+		Data:     data,
+		Included: included,
+		Links:    Links(ctx),
+	}
+	if val, ok := ctx.Get(respond.CONTEXT_META); ok && val != nil {
+		doc.Meta = val.(map[string]interface{})
+	}
+	Write(ctx, code, doc)
+}
+
+// Links self  和 pagination 的 next
+func Links(ctx *gin.Context) map[string]string {
+	req := ctx.Request
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	build := func(cursor string) string {
+		query := req.URL.Query()
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		u := url.URL{
+			Scheme:   scheme,
+			Host:     req.Host,
+			Path:     req.URL.Path,
+			RawQuery: query.Encode(),
+		}
+		return u.String()
+	}
+	links := map[string]string{
+		"self": build(""),
+	}
+	if val, ok := ctx.Get(respond.CONTEXT_META); ok && val != nil {
+		if next, ok := val.(map[string]interface{})["next_cursor"].(string); ok && next != "" {
+			links["next"] = build(next)
+		}
+	}
+	return links
+}
+
+func Write(ctx *gin.Context, code int, doc interface{}) {
+	data, err := jsoncodec.Marshal(doc)
+	if err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	cachecontrol.AddVary(ctx, "Accept")
+	ctx.Data(code, MediaType, data)
+}
+
+// Accepted Accept 包括 JSON:API
+func Accepted(ctx *gin.Context) bool {
+	return strings.Contains(ctx.GetHeader("Accept"), MediaType)
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package jsoncodec
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	jsoniter "github.com/json-iterator/go"
+)
+
+type (
+	// Codec encoding/json  jsoniter.ConfigCompatibleWithStandardLibrary  sonic.ConfigStd 都满足
+	Codec interface {
+		Marshal(v interface{}) ([]byte, error)
+		Unmarshal(data []byte, v interface{}) error
+	}
+
+	// Render 使用当前 Codec 的 render.JSON
+	Render struct {
+		Data interface{}
+	}
+
+	std struct{}
+
+	holder struct {
+		codec Codec
+	}
+)
+
+var (
+	Std      Codec = std{}
+	Jsoniter Codec = jsoniter.ConfigCompatibleWithStandardLibrary
+)
+
+var codec atomic.Value
+
+var jsonContentType = []string{"application/json; charset=utf-8"}
+
+func init() {
+	codec.Store(holder{Std})
+}
+
+// Named 配置中的名字  空为 std
+func Named(name string) (Codec, bool) {
+	switch name {
+	case "", "std":
+		return Std, true
+	case "jsoniter":
+		return Jsoniter, true
+	}
+	return nil, false
+}
+
+// Set respond errs jsonapi 使用  gin 的 ctx.JSON 和 binding 需要 go build -tags=jsoniter
+func Set(c Codec) {
+	if c == nil {
+		c = Std
+	}
+	codec.Store(holder{c})
+}
+
+func Get() Codec {
+	return codec.Load().(holder).codec
+}
+
+func Marshal(v interface{}) ([]byte, error) {
+	return Get().Marshal(v)
+}
+
+func Unmarshal(data []byte, v interface{}) error {
+	return Get().Unmarshal(data, v)
+}
+
+// JSON 同 ctx.JSON
+func JSON(ctx *gin.Context, code int, obj interface{}) {
+	ctx.Render(code, Render{Data: obj})
+}
+
+func (r Render) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	data, err := Marshal(r.Data)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (r Render) WriteContentType(w http.ResponseWriter) {
+	header := w.Header()
+	if val := header["Content-Type"]; len(val) == 0 {
+		header["Content-Type"] = jsonContentType
+	}
+}
+
+func (std) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (std) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
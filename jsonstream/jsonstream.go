@@ -0,0 +1,210 @@
+package jsonstream
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/otamoe/gin-server/cachecontrol"
+	"github.com/otamoe/gin-server/jsoncodec"
+)
+
+type (
+	Config struct {
+		// json 数组 或 ndjson  为空时按 Accept
+		Format string
+
+		// 缓冲超过 FlushBytes 或距离上次 Flush 超过 FlushInterval 时写入
+		FlushBytes    int
+		FlushInterval time.Duration
+
+		// 单次写入超过时返回 ErrWriteTimeout  客户端读得慢时停止读取 cursor
+		// 同步写入  阻塞的写入由 server WriteTimeout 的连接 deadline 结束
+		WriteTimeout time.Duration
+	}
+
+	// Writer 第一次写入时才输出响应头  之前出错仍然可以返回错误响应
+	Writer struct {
+		ctx    *gin.Context
+		code   int
+		config Config
+
+		buf     []byte
+		count   int
+		started bool
+		flushed time.Time
+		err     error
+	}
+)
+
+const (
+	JSON   = "json"
+	NDJSON = "ndjson"
+
+	MIMENDJSON = "application/x-ndjson"
+)
+
+var (
+	ErrWriteTimeout = errors.New("jsonstream: write timeout")
+	ErrClosed       = errors.New("jsonstream: writer closed")
+)
+
+func New(ctx *gin.Context, code int, c Config) *Writer {
+	if c.Format == "" {
+		cachecontrol.AddVary(ctx, "Accept")
+		if strings.Contains(ctx.GetHeader("Accept"), MIMENDJSON) {
+			c.Format = NDJSON
+		} else {
+			c.Format = JSON
+		}
+	}
+	if c.FlushBytes == 0 {
+		c.FlushBytes = 32 * 1024
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = time.Second
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = time.Second * 30
+	}
+	return &Writer{
+		ctx:     ctx,
+		code:    code,
+		config:  c,
+		buf:     make([]byte, 0, c.FlushBytes),
+		flushed: time.Now(),
+	}
+}
+
+// Write 一个元素  客户端断开 写入超时后返回错误  应停止读取
+func (writer *Writer) Write(value interface{}) error {
+	if writer.err != nil {
+		return writer.err
+	}
+	if err := writer.ctx.Request.Context().Err(); err != nil {
+		writer.err = err
+		return err
+	}
+	data, err := jsoncodec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if writer.config.Format == NDJSON {
+		writer.buf = append(append(writer.buf, data...), '\n')
+	} else {
+		if writer.count == 0 {
+			writer.buf = append(writer.buf, '[')
+		} else {
+			writer.buf = append(writer.buf, ',')
+		}
+		writer.buf = append(writer.buf, data...)
+	}
+	writer.count++
+	if len(writer.buf) >= writer.config.FlushBytes || time.Since(writer.flushed) >= writer.config.FlushInterval {
+		return writer.Flush()
+	}
+	return nil
+}
+
+// Count 已写入的元素数
+func (writer *Writer) Count() int {
+	return writer.count
+}
+
+// Started 已经输出响应头  之后的错误只能断开
+func (writer *Writer) Started() bool {
+	return writer.started
+}
+
+// Close 结束数组  之后不能再写入
+func (writer *Writer) Close() error {
+	if writer.err != nil {
+		return writer.err
+	}
+	if writer.config.Format == JSON {
+		if writer.count == 0 {
+			writer.buf = append(writer.buf, '[')
+		}
+		writer.buf = append(writer.buf, ']')
+	}
+	err := writer.Flush()
+	if err == nil {
+		writer.err = ErrClosed
+	}
+	return err
+}
+
+// Flush 写入缓冲  写入超过 WriteTimeout 时返回 ErrWriteTimeout
+// 不在其他 goroutine 写入  返回后 gin.Context 会被复用
+func (writer *Writer) Flush() error {
+	if writer.err != nil {
+		return writer.err
+	}
+	if !writer.started {
+		writer.started = true
+		header := writer.ctx.Writer.Header()
+		if writer.config.Format == NDJSON {
+			header.Set("Content-Type", MIMENDJSON)
+		} else {
+			header.Set("Content-Type", "application/json; charset=utf-8")
+		}
+		// nginx 不缓冲
+		header.Set("X-Accel-Buffering", "no")
+		header.Del("Content-Length")
+		writer.ctx.Status(writer.code)
+	}
+
+	start := time.Now()
+	_, err := writer.ctx.Writer.Write(writer.buf)
+	if err == nil {
+		writer.ctx.Writer.Flush()
+		err = writer.ctx.Request.Context().Err()
+	}
+	if err == nil && time.Since(start) > writer.config.WriteTimeout {
+		err = ErrWriteTimeout
+	}
+	writer.buf = writer.buf[:0]
+	writer.flushed = time.Now()
+	writer.err = err
+	return err
+}
+
+// Each next 返回 io.EOF 时结束  没有写入任何内容前出错时可以正常返回错误响应
+func Each(ctx *gin.Context, code int, c Config, next func() (interface{}, error)) error {
+	writer := New(ctx, code, c)
+	for {
+		value, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err = writer.Write(value); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// Iter 输出 mgo cursor  result 每次返回新的元素  结束后关闭 iter
+func Iter(ctx *gin.Context, code int, c Config, iter *mgo.Iter, result func() interface{}) (err error) {
+	defer func() {
+		if closeErr := iter.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return Each(ctx, code, c, func() (interface{}, error) {
+		value := result()
+		if !iter.Next(value) {
+			if err := iter.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		return value, nil
+	})
+}
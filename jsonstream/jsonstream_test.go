@@ -0,0 +1,93 @@
+package jsonstream
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newContext(accept string) (*gin.Context, *httptest.ResponseRecorder) {
+	writer := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(writer)
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if accept != "" {
+		ctx.Request.Header.Set("Accept", accept)
+	}
+	return ctx, writer
+}
+
+func items(values ...interface{}) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		if len(values) == 0 {
+			return nil, io.EOF
+		}
+		value := values[0]
+		values = values[1:]
+		return value, nil
+	}
+}
+
+func TestEachJSON(t *testing.T) {
+	ctx, writer := newContext("")
+	if err := Each(ctx, http.StatusOK, Config{}, items(1, "a", map[string]int{"b": 2})); err != nil {
+		t.Fatal(err)
+	}
+	if body := writer.Body.String(); body != `[1,"a",{"b":2}]` {
+		t.Fatalf("body %s", body)
+	}
+	if val := writer.Header().Get("Content-Type"); val != "application/json; charset=utf-8" {
+		t.Fatalf("content type %s", val)
+	}
+}
+
+func TestEachEmpty(t *testing.T) {
+	ctx, writer := newContext("")
+	if err := Each(ctx, http.StatusOK, Config{}, items()); err != nil {
+		t.Fatal(err)
+	}
+	if body := writer.Body.String(); body != `[]` {
+		t.Fatalf("body %s", body)
+	}
+}
+
+func TestEachNDJSON(t *testing.T) {
+	ctx, writer := newContext(MIMENDJSON)
+	if err := Each(ctx, http.StatusOK, Config{FlushBytes: 1}, items(1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if body := writer.Body.String(); body != "1\n2\n" {
+		t.Fatalf("body %q", body)
+	}
+	if val := writer.Header().Get("Content-Type"); val != MIMENDJSON {
+		t.Fatalf("content type %s", val)
+	}
+}
+
+func TestWriteCanceled(t *testing.T) {
+	ctx, _ := newContext("")
+	cancelCtx, cancel := context.WithCancel(ctx.Request.Context())
+	ctx.Request = ctx.Request.WithContext(cancelCtx)
+	writer := New(ctx, http.StatusOK, Config{})
+	cancel()
+	if err := writer.Write(1); err != context.Canceled {
+		t.Fatalf("Write after cancel = %v", err)
+	}
+	if writer.Started() {
+		t.Fatal("started after cancel")
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	ctx, _ := newContext("")
+	writer := New(ctx, http.StatusOK, Config{})
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write(1); err != ErrClosed {
+		t.Fatalf("Write after Close = %v", err)
+	}
+}
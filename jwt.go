@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/otamoe/gin-server/jwt"
+)
+
+type (
+	JWT struct {
+		Issuer   string        `json:"issuer,omitempty"`
+		Audience string        `json:"audience,omitempty"`
+		Leeway   time.Duration `json:"leeway,omitempty"`
+
+		Secret string `json:"secret,omitempty"`
+
+		// kid => PEM 公钥
+		Keys map[string]string `json:"keys,omitempty"`
+
+		JWKSURL     string        `json:"jwks_url,omitempty"`
+		JWKSRefresh time.Duration `json:"jwks_refresh,omitempty"`
+
+		verifier *jwt.Verifier
+	}
+)
+
+func (config *JWT) init(server *Server, handler *Handler) {
+	if config.verifier != nil {
+		return
+	}
+	if config.Leeway == 0 {
+		config.Leeway = time.Second * 30
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for kid, val := range config.Keys {
+		block, _ := pem.Decode([]byte(val))
+		if block == nil {
+			panic(errors.New("JWT: key " + kid + " is not PEM encoded"))
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			panic(err)
+		}
+		keys[kid] = key
+	}
+
+	config.verifier = jwt.New(jwt.Config{
+		Issuer:      config.Issuer,
+		Audience:    config.Audience,
+		Leeway:      config.Leeway,
+		Secret:      []byte(config.Secret),
+		Keys:        keys,
+		JWKSURL:     config.JWKSURL,
+		JWKSRefresh: config.JWKSRefresh,
+	})
+}
+
+func (config *JWT) Get() *jwt.Verifier {
+	return config.verifier
+}
@@ -0,0 +1,173 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	keySet struct {
+		url     string
+		refresh time.Duration
+		client  *http.Client
+
+		mutex     sync.RWMutex
+		keys      map[string]crypto.PublicKey
+		fetchedAt time.Time
+		triedAt   time.Time
+
+		// 正在刷新时不为 nil  完成时 close
+		fetching chan struct{}
+	}
+
+	jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+)
+
+// 未知 kid 最多每分钟刷新一次
+const jwksMinInterval = time.Minute
+
+var errEmptyKeySet = errors.New("JWT: jwks has no usable keys")
+
+func newKeySet(url string, refresh time.Duration) *keySet {
+	return &keySet{
+		url:     url,
+		refresh: refresh,
+		client: &http.Client{
+			Timeout: time.Second * 10,
+		},
+		keys: map[string]crypto.PublicKey{},
+	}
+}
+
+func (set *keySet) get(kid string) crypto.PublicKey {
+	set.mutex.RLock()
+	key, ok := set.keys[kid]
+	stale := time.Since(set.fetchedAt) > set.refresh
+	set.mutex.RUnlock()
+	if ok && !stale {
+		return key
+	}
+
+	// 过期时后台刷新  继续使用旧的 key  未知的 kid 等待刷新
+	done := set.update()
+	if ok {
+		return key
+	}
+	if done != nil {
+		<-done
+	}
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	return set.keys[kid]
+}
+
+// update 在锁外请求  同时只有一个  返回正在进行的刷新  间隔不到 jwksMinInterval 时为 nil
+func (set *keySet) update() chan struct{} {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	if set.fetching != nil {
+		return set.fetching
+	}
+	if time.Since(set.triedAt) <= jwksMinInterval {
+		return nil
+	}
+	set.triedAt = time.Now()
+	done := make(chan struct{})
+	set.fetching = done
+	go func() {
+		keys, err := set.fetch()
+		set.mutex.Lock()
+		// 失败时保留之前的 key
+		if err == nil {
+			set.keys = keys
+			set.fetchedAt = time.Now()
+		}
+		set.fetching = nil
+		set.mutex.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+func (set *keySet) fetch() (keys map[string]crypto.PublicKey, err error) {
+	var res *http.Response
+	if res, err = set.client.Get(set.url); err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		err = errors.New("JWT: jwks status " + res.Status)
+		return
+	}
+
+	var body struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return
+	}
+
+	keys = map[string]crypto.PublicKey{}
+	for _, val := range body.Keys {
+		if key := val.publicKey(); key != nil {
+			keys[val.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		keys, err = nil, errEmptyKeySet
+	}
+	return
+}
+
+func (key jwk) publicKey() crypto.PublicKey {
+	switch key.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	case "EC":
+		if key.Crv != "P-256" {
+			return nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil {
+			return nil
+		}
+		y, err := base64.RawURLEncoding.DecodeString(key.Y)
+		if err != nil {
+			return nil
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+	}
+	return nil
+}
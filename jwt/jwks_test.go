@@ -0,0 +1,58 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestKeySetKeepsKeys(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := base64.RawURLEncoding.EncodeToString(private.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(private.E)).Bytes())
+	responses := []struct {
+		status int
+		body   string
+	}{
+		{http.StatusOK, `{"keys":[{"kty":"RSA","kid":"a","n":"` + n + `","e":"` + e + `"}]}`},
+		{http.StatusBadGateway, `<html>bad gateway</html>`},
+		{http.StatusOK, `{"keys":[]}`},
+	}
+	index := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := responses[index]
+		w.WriteHeader(response.status)
+		w.Write([]byte(response.body))
+	}))
+	defer server.Close()
+
+	set := newKeySet(server.URL, time.Hour)
+	if set.get("a") == nil {
+		t.Fatal("key a not found")
+	}
+	for index = 1; index < len(responses); index++ {
+		// 过期并允许立即刷新
+		set.mutex.Lock()
+		set.fetchedAt = time.Time{}
+		set.triedAt = time.Time{}
+		set.mutex.Unlock()
+		if set.get("a") == nil {
+			t.Fatalf("response %d: stale key a not returned", index)
+		}
+		// 未知 kid 等待正在进行的刷新
+		if set.get("b") != nil {
+			t.Fatalf("response %d: key b found", index)
+		}
+		if set.get("a") == nil {
+			t.Fatalf("response %d: key a removed", index)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	Config struct {
+		Issuer   string
+		Audience string
+		Leeway   time.Duration
+
+		// HS256
+		Secret []byte
+
+		// RS256 ES256  kid => *rsa.PublicKey *ecdsa.PublicKey
+		Keys map[string]crypto.PublicKey
+
+		JWKSURL     string
+		JWKSRefresh time.Duration
+
+		// 没有 token 时返回错误
+		Required bool
+
+		Header string
+		Query  string
+	}
+
+	Claims map[string]interface{}
+
+	Verifier struct {
+		config Config
+		jwks   *keySet
+	}
+
+	header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		Typ string `json:"typ"`
+	}
+)
+
+var CONTEXT = "GIN.SERVER.JWT"
+
+var (
+	ErrRequired = &errs.Error{
+		Message:    "You are not logged in",
+		Type:       "token",
+		StatusCode: http.StatusUnauthorized,
+	}
+	ErrInvalid = &errs.Error{
+		Message:    "Invalid token",
+		Type:       "token",
+		StatusCode: http.StatusUnauthorized,
+	}
+	ErrExpired = &errs.Error{
+		Message:    "Token has expired",
+		Type:       "token",
+		StatusCode: http.StatusUnauthorized,
+	}
+)
+
+func New(c Config) *Verifier {
+	if c.Header == "" {
+		c.Header = "Authorization"
+	}
+	if c.JWKSRefresh == 0 {
+		c.JWKSRefresh = time.Hour
+	}
+	verifier := &Verifier{
+		config: c,
+	}
+	if c.JWKSURL != "" {
+		verifier.jwks = newKeySet(c.JWKSURL, c.JWKSRefresh)
+	}
+	return verifier
+}
+
+func Middleware(c Config) gin.HandlerFunc {
+	return MiddlewareVerifier(New(c))
+}
+
+func MiddlewareVerifier(verifier *Verifier) gin.HandlerFunc {
+	c := verifier.config
+	return func(ctx *gin.Context) {
+		token := bearer(ctx.GetHeader(c.Header))
+		if token == "" && c.Query != "" {
+			token = ctx.Query(c.Query)
+		}
+		if token == "" {
+			if c.Required {
+				ctx.Error(ErrRequired)
+				ctx.Abort()
+				return
+			}
+			ctx.Next()
+			return
+		}
+
+		claims, err := verifier.Verify(token)
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		ctx.Set(CONTEXT, claims)
+		ctx.Next()
+	}
+}
+
+// Required 路由上使用 必须有 token
+func Required() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if Get(ctx) == nil {
+			ctx.Error(ErrRequired)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) Claims {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(Claims)
+	}
+	return nil
+}
+
+func (verifier *Verifier) Verify(token string) (claims Claims, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		err = ErrInvalid
+		return
+	}
+
+	var h header
+	if err = decodeSegment(parts[0], &h); err != nil {
+		err = ErrInvalid
+		return
+	}
+
+	var signature []byte
+	if signature, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		err = ErrInvalid
+		return
+	}
+
+	if err = verifier.verifySignature(h, parts[0]+"."+parts[1], signature); err != nil {
+		return
+	}
+
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		err = ErrInvalid
+		return
+	}
+
+	if err = verifier.validate(claims); err != nil {
+		claims = nil
+		return
+	}
+	return
+}
+
+func (verifier *Verifier) verifySignature(h header, signed string, signature []byte) (err error) {
+	switch h.Alg {
+	case "HS256":
+		if len(verifier.config.Secret) == 0 {
+			return ErrInvalid
+		}
+		mac := hmac.New(sha256.New, verifier.config.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ErrInvalid
+		}
+		return nil
+	case "RS256", "ES256":
+	default:
+		return ErrInvalid
+	}
+
+	key := verifier.key(h.Kid)
+	if key == nil {
+		return ErrInvalid
+	}
+	hash := sha256.Sum256([]byte(signed))
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if h.Alg != "RS256" {
+			return ErrInvalid
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) != nil {
+			return ErrInvalid
+		}
+	case *ecdsa.PublicKey:
+		if h.Alg != "ES256" || len(signature) != 64 {
+			return ErrInvalid
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, hash[:], r, s) {
+			return ErrInvalid
+		}
+	default:
+		return ErrInvalid
+	}
+	return nil
+}
+
+func (verifier *Verifier) key(kid string) crypto.PublicKey {
+	if key, ok := verifier.config.Keys[kid]; ok {
+		return key
+	}
+	// 只有一个 key 时 不需要 kid
+	if kid == "" && len(verifier.config.Keys) == 1 {
+		for _, key := range verifier.config.Keys {
+			return key
+		}
+	}
+	if verifier.jwks != nil {
+		return verifier.jwks.get(kid)
+	}
+	return nil
+}
+
+func (verifier *Verifier) validate(claims Claims) error {
+	c := verifier.config
+	now := time.Now()
+
+	if exp, ok := claims.time("exp"); ok && now.After(exp.Add(c.Leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(c.Leeway).Before(nbf) {
+		return ErrInvalid
+	}
+	if c.Issuer != "" && claims.String("iss") != c.Issuer {
+		return ErrInvalid
+	}
+	if c.Audience != "" && !claims.hasAudience(c.Audience) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func (claims Claims) String(name string) string {
+	val, _ := claims[name].(string)
+	return val
+}
+
+func (claims Claims) Subject() string {
+	return claims.String("sub")
+}
+
+func (claims Claims) time(name string) (t time.Time, ok bool) {
+	switch val := claims[name].(type) {
+	case json.Number:
+		var f float64
+		var err error
+		if f, err = val.Float64(); err != nil {
+			return
+		}
+		return time.Unix(int64(f), 0), true
+	case float64:
+		return time.Unix(int64(val), 0), true
+	}
+	return
+}
+
+func (claims Claims) hasAudience(audience string) bool {
+	switch val := claims["aud"].(type) {
+	case string:
+		return val == audience
+	case []interface{}:
+		for _, v := range val {
+			if v == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func bearer(value string) string {
+	if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+		return strings.TrimSpace(value[7:])
+	}
+	return ""
+}
+
+func decodeSegment(segment string, v interface{}) (err error) {
+	var data []byte
+	if data, err = base64.RawURLEncoding.DecodeString(segment); err != nil {
+		return
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(v)
+	return
+}
@@ -0,0 +1,121 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+type (
+	// Signer 有 Key 时 RS256 ES256  否则 HS256
+	Signer struct {
+		Secret []byte
+		Key    crypto.Signer
+		Kid    string
+	}
+)
+
+var ErrSigner = errors.New("JWT: signer has no key")
+
+func (signer *Signer) Alg() string {
+	switch signer.Key.(type) {
+	case *rsa.PrivateKey:
+		return "RS256"
+	case *ecdsa.PrivateKey:
+		return "ES256"
+	}
+	return "HS256"
+}
+
+func (signer *Signer) Sign(claims Claims) (token string, err error) {
+	alg := signer.Alg()
+	if alg == "HS256" && len(signer.Secret) == 0 {
+		err = ErrSigner
+		return
+	}
+	var h, payload []byte
+	if h, err = json.Marshal(header{Alg: alg, Kid: signer.Kid, Typ: "JWT"}); err != nil {
+		return
+	}
+	if payload, err = json.Marshal(claims); err != nil {
+		return
+	}
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	hash := sha256.Sum256([]byte(signed))
+	switch key := signer.Key.(type) {
+	case *rsa.PrivateKey:
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:]); err != nil {
+			return
+		}
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		if r, s, err = ecdsa.Sign(rand.Reader, key, hash[:]); err != nil {
+			return
+		}
+		// r s 各 32 字节
+		signature = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+	default:
+		mac := hmac.New(sha256.New, signer.Secret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	token = signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return
+}
+
+// Verifier 验证自己签发的 token
+func (signer *Signer) Verifier(c Config) *Verifier {
+	if signer.Key != nil {
+		c.Keys = map[string]crypto.PublicKey{
+			signer.Kid: signer.Key.Public(),
+		}
+	} else {
+		c.Secret = signer.Secret
+	}
+	return New(c)
+}
+
+// JWKS 公钥  HS256 时为空
+func (signer *Signer) JWKS() map[string]interface{} {
+	keys := []map[string]string{}
+	switch key := signer.Key.(type) {
+	case *rsa.PrivateKey:
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": signer.Kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	case *ecdsa.PrivateKey:
+		x, y := make([]byte, 32), make([]byte, 32)
+		xb, yb := key.X.Bytes(), key.Y.Bytes()
+		copy(x[32-len(xb):], xb)
+		copy(y[32-len(yb):], yb)
+		keys = append(keys, map[string]string{
+			"kty": "EC",
+			"kid": signer.Kid,
+			"use": "sig",
+			"alg": "ES256",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(x),
+			"y":   base64.RawURLEncoding.EncodeToString(y),
+		})
+	}
+	return map[string]interface{}{
+		"keys": keys,
+	}
+}
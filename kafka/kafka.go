@@ -0,0 +1,284 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/otamoe/gin-server/audit"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	Config struct {
+		Brokers []string
+		Topic   string
+
+		ClientID string
+
+		// none gzip snappy lz4 zstd
+		Compression string
+
+		// 批量发送  条数 间隔
+		BatchSize     int
+		FlushInterval time.Duration
+
+		// at-most-once 不等待确认 不重试 缓冲满时丢弃
+		// at-least-once 等待所有副本确认 失败重试 缓冲满时阻塞
+		Delivery string
+
+		// 缓冲条数
+		BufferSize int
+	}
+
+	// Producer 异步发送
+	Producer struct {
+		config   Config
+		producer sarama.AsyncProducer
+		mutex    sync.RWMutex
+		closed   bool
+		closing  chan struct{}
+		once     sync.Once
+		wg       sync.WaitGroup
+		sent     uint64
+		dropped  uint64
+		errors   uint64
+	}
+
+	// Hook logrus hook  json 发送到 Topic
+	Hook struct {
+		producer *Producer
+		topic    string
+		levels   []logrus.Level
+	}
+)
+
+const (
+	AtMostOnce  = "at-most-once"
+	AtLeastOnce = "at-least-once"
+)
+
+var ErrClosed = errors.New("Kafka: producer closed")
+
+var compressions = map[string]sarama.CompressionCodec{
+	"":       sarama.CompressionNone,
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
+func New(c Config) (producer *Producer, err error) {
+	if len(c.Brokers) == 0 {
+		err = errors.New("Kafka: brokers is empty")
+		return
+	}
+	if c.Delivery == "" {
+		c.Delivery = AtMostOnce
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 500
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = time.Millisecond * 500
+	}
+	if c.BufferSize == 0 {
+		c.BufferSize = 8192
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = c.ClientID
+	config.ChannelBufferSize = c.BufferSize
+	config.Producer.Flush.Messages = c.BatchSize
+	config.Producer.Flush.Frequency = c.FlushInterval
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+
+	var ok bool
+	if config.Producer.Compression, ok = compressions[c.Compression]; !ok {
+		err = errors.New("Kafka: unknown compression " + c.Compression)
+		return
+	}
+	if config.Producer.Compression == sarama.CompressionZSTD {
+		config.Version = sarama.V2_1_0_0
+	}
+
+	switch c.Delivery {
+	case AtMostOnce:
+		config.Producer.RequiredAcks = sarama.NoResponse
+		config.Producer.Retry.Max = 0
+	case AtLeastOnce:
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Producer.Retry.Max = 10
+		config.Producer.Retry.Backoff = time.Millisecond * 250
+	default:
+		err = errors.New("Kafka: unknown delivery " + c.Delivery)
+		return
+	}
+
+	producer = &Producer{
+		config:  c,
+		closing: make(chan struct{}),
+	}
+	if producer.producer, err = sarama.NewAsyncProducer(c.Brokers, config); err != nil {
+		producer = nil
+		return
+	}
+
+	producer.wg.Add(2)
+	go func() {
+		defer producer.wg.Done()
+		for range producer.producer.Successes() {
+			atomic.AddUint64(&producer.sent, 1)
+		}
+	}()
+	go func() {
+		defer producer.wg.Done()
+		// 不输出日志 避免 hook 循环
+		for range producer.producer.Errors() {
+			atomic.AddUint64(&producer.errors, 1)
+		}
+	}()
+	return
+}
+
+// Send topic 为空时使用 Config.Topic  at-least-once 缓冲满时阻塞到 Close
+func (producer *Producer) Send(topic string, key []byte, value []byte) error {
+	return producer.send(topic, key, value, producer.config.Delivery == AtLeastOnce)
+}
+
+// send block 为 false 时缓冲满直接丢弃  日志的 hook 不能阻塞请求
+func (producer *Producer) send(topic string, key []byte, value []byte, block bool) error {
+	if topic == "" {
+		topic = producer.config.Topic
+	}
+	message := &sarama.ProducerMessage{
+		Topic:     topic,
+		Value:     sarama.ByteEncoder(value),
+		Timestamp: time.Now(),
+	}
+	if len(key) != 0 {
+		message.Key = sarama.ByteEncoder(key)
+	}
+
+	producer.mutex.RLock()
+	defer producer.mutex.RUnlock()
+	if producer.closed {
+		atomic.AddUint64(&producer.dropped, 1)
+		return ErrClosed
+	}
+	if block {
+		// Close 先关闭 closing  阻塞的 send 返回后才能 AsyncClose
+		select {
+		case producer.producer.Input() <- message:
+			return nil
+		case <-producer.closing:
+			atomic.AddUint64(&producer.dropped, 1)
+			return ErrClosed
+		}
+	}
+	select {
+	case producer.producer.Input() <- message:
+	default:
+		atomic.AddUint64(&producer.dropped, 1)
+	}
+	return nil
+}
+
+// SendJSON json 编码后发送
+func (producer *Producer) SendJSON(topic string, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return producer.Send(topic, []byte(key), data)
+}
+
+// Hook levels 为空时全部级别
+func (producer *Producer) Hook(topic string, levels []logrus.Level) *Hook {
+	if len(levels) == 0 {
+		levels = logrus.AllLevels
+	}
+	return &Hook{
+		producer: producer,
+		topic:    topic,
+		levels:   levels,
+	}
+}
+
+// Audit 用于 audit.Config.Sink  key 为 actor
+func (producer *Producer) Audit(topic string) func(val *audit.Audit) {
+	return func(val *audit.Audit) {
+		producer.SendJSON(topic, val.Actor, val)
+	}
+}
+
+func (producer *Producer) Sent() uint64 {
+	return atomic.LoadUint64(&producer.sent)
+}
+
+// Dropped at-most-once 和 hook 缓冲满 或 关闭后丢弃的条数
+func (producer *Producer) Dropped() uint64 {
+	return atomic.LoadUint64(&producer.dropped)
+}
+
+func (producer *Producer) Errors() uint64 {
+	return atomic.LoadUint64(&producer.errors)
+}
+
+// Close 发送缓冲中的消息后关闭
+func (producer *Producer) Close(ctx context.Context) error {
+	producer.once.Do(func() {
+		close(producer.closing)
+	})
+	producer.mutex.Lock()
+	if producer.closed {
+		producer.mutex.Unlock()
+		return nil
+	}
+	producer.closed = true
+	producer.mutex.Unlock()
+
+	producer.producer.AsyncClose()
+	done := make(chan struct{})
+	go func() {
+		producer.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (hook *Hook) Levels() []logrus.Level {
+	return hook.levels
+}
+
+func (hook *Hook) Fire(entry *logrus.Entry) error {
+	record := make(map[string]interface{}, len(entry.Data)+3)
+	for key, val := range entry.Data {
+		if err, ok := val.(error); ok {
+			record[key] = err.Error()
+		} else {
+			record[key] = val
+		}
+	}
+	record["@timestamp"] = entry.Time.Format(time.RFC3339Nano)
+	record["level"] = entry.Level.String()
+	record["message"] = entry.Message
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	// 缓冲满时丢弃  计入 Dropped
+	hook.producer.send(hook.topic, nil, data, false)
+	return nil
+}
@@ -0,0 +1,119 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// blockingProducer Input 没有读取  缓冲一直是满的
+type blockingProducer struct {
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func (p *blockingProducer) AsyncClose() {
+	close(p.successes)
+	close(p.errors)
+}
+
+func (p *blockingProducer) Close() error {
+	p.AsyncClose()
+	return nil
+}
+
+func (p *blockingProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *blockingProducer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+func (p *blockingProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+func newBlockingProducer(delivery string) *Producer {
+	fake := &blockingProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+	producer := &Producer{
+		config:   Config{Topic: "logs", Delivery: delivery},
+		producer: fake,
+		closing:  make(chan struct{}),
+	}
+	producer.wg.Add(2)
+	go func() {
+		defer producer.wg.Done()
+		for range fake.successes {
+		}
+	}()
+	go func() {
+		defer producer.wg.Done()
+		for range fake.errors {
+		}
+	}()
+	return producer
+}
+
+// hook 不阻塞  缓冲满时丢弃
+func TestHookNonBlocking(t *testing.T) {
+	producer := newBlockingProducer(AtLeastOnce)
+	logger := logrus.New()
+	logger.Out = &nopWriter{}
+	logger.AddHook(producer.Hook("", nil))
+
+	done := make(chan struct{})
+	go func() {
+		logger.Info("hello")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("hook blocked")
+	}
+	if producer.Dropped() != 1 {
+		t.Fatalf("Dropped() = %d", producer.Dropped())
+	}
+}
+
+// at-least-once 阻塞的 Send 不能让 Close 死锁
+func TestCloseWithBlockedSend(t *testing.T) {
+	producer := newBlockingProducer(AtLeastOnce)
+	sent := make(chan error)
+	go func() {
+		sent <- producer.Send("", nil, []byte("x"))
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := producer.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-sent:
+		if err != ErrClosed {
+			t.Fatalf("Send() = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Send still blocked after Close")
+	}
+	if err := producer.Send("", nil, []byte("x")); err != ErrClosed {
+		t.Fatalf("Send after Close = %v", err)
+	}
+}
+
+type nopWriter struct{}
+
+func (nopWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
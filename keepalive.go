@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	KeepAlive struct {
+		Disabled bool `json:"disabled,omitempty"`
+
+		// 每个连接最多请求数  之后返回 Connection: close
+		MaxRequests int64 `json:"max_requests,omitempty"`
+
+		// 连接最长时间  之后返回 Connection: close
+		MaxAge time.Duration `json:"max_age,omitempty"`
+	}
+)
+
+// close 超过 MaxRequests MaxAge 时返回 true  http2 不支持
+func (config *KeepAlive) close(tracker *connTracker, req *http.Request) bool {
+	if req.ProtoMajor != 1 || (config.MaxRequests == 0 && config.MaxAge == 0) {
+		return false
+	}
+	info := tracker.get(req.RemoteAddr)
+	if info == nil {
+		return false
+	}
+	requests := atomic.AddInt64(&info.requests, 1)
+	if config.MaxRequests != 0 && requests >= config.MaxRequests {
+		return true
+	}
+	if config.MaxAge != 0 && time.Since(info.createdAt) >= config.MaxAge {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/mongo"
+	"github.com/otamoe/gin-server/notfound"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// builtinModule server 级别的内置配置作为 Module  Register 同名的模块代替
+	builtinModule struct {
+		name       string
+		server     *Server
+		configure  func(server *Server) error
+		middleware func(server *Server) gin.HandlerFunc
+		stop       func(server *Server, ctx context.Context) error
+		configured bool
+		stopped    bool
+	}
+)
+
+// builtinModules 按 Init 的顺序  关闭时相反
+var builtinModules = []string{"compress", "logger", "size", "notfound", "redis", "mongo"}
+
+func isBuiltinModule(name string) bool {
+	for _, val := range builtinModules {
+		if val == name {
+			return true
+		}
+	}
+	return false
+}
+
+// BuiltinModule 内置的 compress logger size notfound redis mongo  用于包装后 Register 代替  没有时为 nil
+func (server *Server) BuiltinModule(name string) Module {
+	if !isBuiltinModule(name) {
+		return nil
+	}
+	return server.original(name)
+}
+
+func newBuiltinModule(server *Server, name string) *builtinModule {
+	module := &builtinModule{
+		name:   name,
+		server: server,
+	}
+	switch name {
+	case "compress":
+		module.configure = func(server *Server) error {
+			if server.Compress == nil {
+				server.Compress = &Compress{}
+			}
+			server.Compress.init(server, nil)
+			return nil
+		}
+		module.middleware = func(server *Server) gin.HandlerFunc {
+			return server.Compress.middleware(nil)
+		}
+	case "logger":
+		module.configure = func(server *Server) error {
+			if server.Logger == nil {
+				server.Logger = &Logger{}
+			}
+			server.Logger.init(server, nil)
+			return nil
+		}
+		module.middleware = func(server *Server) gin.HandlerFunc {
+			return server.Logger.middleware()
+		}
+		// 发送剩余日志
+		module.stop = func(server *Server, ctx context.Context) error {
+			server.Logger.close(time.Second * 5)
+			return nil
+		}
+	case "size":
+		module.configure = func(server *Server) error {
+			if server.Size == nil {
+				server.Size = &Size{}
+			}
+			server.Size.init(server, nil)
+			return nil
+		}
+		module.middleware = func(server *Server) gin.HandlerFunc {
+			return server.Size.middleware()
+		}
+	case "notfound":
+		module.middleware = func(server *Server) gin.HandlerFunc {
+			return notfound.Middleware()
+		}
+	case "redis":
+		module.configure = func(server *Server) error {
+			if server.Redis != nil {
+				server.Redis.init(server, nil)
+			}
+			return nil
+		}
+		module.middleware = func(server *Server) gin.HandlerFunc {
+			if server.Redis == nil {
+				return nil
+			}
+			return server.Redis.Middleware()
+		}
+		module.stop = func(server *Server, ctx context.Context) error {
+			if server.Redis != nil {
+				server.Redis.Close()
+			}
+			return nil
+		}
+	case "mongo":
+		module.configure = func(server *Server) error {
+			if server.Mongo != nil {
+				server.Mongo.init(server, nil)
+			}
+			return nil
+		}
+		module.middleware = func(server *Server) gin.HandlerFunc {
+			if server.Mongo == nil {
+				return nil
+			}
+			return mongo.MiddlewareProvider(server.Mongo)
+		}
+		module.stop = func(server *Server, ctx context.Context) error {
+			if server.Mongo != nil {
+				server.Mongo.Close()
+			}
+			return nil
+		}
+	default:
+		return nil
+	}
+	return module
+}
+
+func (module *builtinModule) Name() string {
+	return module.name
+}
+
+// Configure 配置在 server 的同名字段  raw 不使用  包装后再次调用时跳过
+func (module *builtinModule) Configure(raw json.RawMessage) error {
+	if module.configure == nil || module.configured {
+		return nil
+	}
+	module.configured = true
+	return module.configure(module.server)
+}
+
+func (module *builtinModule) Middleware() gin.HandlerFunc {
+	if module.middleware == nil {
+		return nil
+	}
+	return module.middleware(module.server)
+}
+
+// Start 连接在 Configure 中完成
+func (module *builtinModule) Start(ctx context.Context) error {
+	return nil
+}
+
+func (module *builtinModule) Stop(ctx context.Context) error {
+	if module.stop == nil || module.stopped {
+		return nil
+	}
+	module.stopped = true
+	return module.stop(module.server, ctx)
+}
+
+// replaced Register 的同名模块  没有时为 nil
+func (server *Server) replaced(name string) Module {
+	if !isBuiltinModule(name) {
+		return nil
+	}
+	return server.Module(name)
+}
+
+// builtin 代替的模块或内置的
+func (server *Server) builtin(name string) Module {
+	if module := server.replaced(name); module != nil {
+		return module
+	}
+	return server.original(name)
+}
+
+// original 内置的  代替时仍然配置 server 的字段  其他地方使用 server.Logger server.Compress 等
+func (server *Server) original(name string) Module {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.builtins == nil {
+		server.builtins = map[string]Module{}
+	}
+	module, ok := server.builtins[name]
+	if !ok {
+		module = newBuiltinModule(server, name)
+		server.builtins[name] = module
+	}
+	return module
+}
+
+// configureBuiltin 先配置内置的  代替的模块只替换中间件
+func (server *Server) configureBuiltin(name string) error {
+	if err := server.original(name).Configure(server.Modules[name]); err != nil {
+		return err
+	}
+	if module := server.replaced(name); module != nil {
+		return module.Configure(server.Modules[name])
+	}
+	return nil
+}
+
+func (handler *Handler) useModule(module Module) {
+	if fn := module.Middleware(); fn != nil {
+		handler.gin.Use(fn)
+	}
+}
+
+// startBuiltins 在其他模块之前
+func (server *Server) startBuiltins(ctx context.Context) error {
+	for _, name := range builtinModules {
+		if err := server.builtin(name).Start(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopBuiltins 在其他模块之后  mongo redis 之后关闭 logger  代替的模块在内置的之前
+func (server *Server) stopBuiltins(ctx context.Context) {
+	for i := len(builtinModules) - 1; i >= 0; i-- {
+		name := builtinModules[i]
+		if module := server.replaced(name); module != nil {
+			if err := module.Stop(ctx); err != nil {
+				logrus.Error("Module "+name+" Stop:", err)
+			}
+		}
+		if err := server.original(name).Stop(ctx); err != nil {
+			logrus.Error("Module "+name+" Stop:", err)
+		}
+	}
+}
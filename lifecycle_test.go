@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type replacementLogger struct {
+	configured bool
+	requests   int
+	stopped    bool
+}
+
+func (module *replacementLogger) Name() string {
+	return "logger"
+}
+
+func (module *replacementLogger) Configure(raw json.RawMessage) error {
+	module.configured = true
+	return nil
+}
+
+func (module *replacementLogger) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		module.requests++
+		ctx.Next()
+	}
+}
+
+func (module *replacementLogger) Start(ctx context.Context) error {
+	return nil
+}
+
+func (module *replacementLogger) Stop(ctx context.Context) error {
+	module.stopped = true
+	return nil
+}
+
+func TestReplaceBuiltinLogger(t *testing.T) {
+	module := &replacementLogger{}
+	srv := &Server{
+		ENV:      "test",
+		Handlers: []*Handler{{Name: "api", Hosts: []string{"example.com"}}},
+	}
+	srv.Register(module)
+	srv.Init()
+	srv.Get("api", false)
+
+	if !module.configured {
+		t.Fatal("replacement logger not configured")
+	}
+	if srv.Logger == nil || srv.Logger.Get() == nil {
+		t.Fatal("server.Logger is nil after replacing the logger module")
+	}
+	if srv.Module("logger") != module {
+		t.Fatal("Module(logger) is not the replacement")
+	}
+
+	// handler 的中间件使用代替的模块
+	writer := httptest.NewRecorder()
+	srv.GetHttpServer().Handler.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if module.requests != 1 {
+		t.Fatalf("replacement middleware ran %d times, want 1", module.requests)
+	}
+
+	srv.stopBuiltins(context.Background())
+	if !module.stopped {
+		t.Fatal("replacement logger not stopped")
+	}
+}
+
+func TestWrapBuiltinModule(t *testing.T) {
+	srv := &Server{ENV: "test"}
+	builtin := srv.BuiltinModule("compress")
+	if builtin == nil {
+		t.Fatal("BuiltinModule(compress) is nil")
+	}
+	if srv.BuiltinModule("unknown") != nil {
+		t.Fatal("BuiltinModule(unknown) is not nil")
+	}
+	srv.Register(builtin)
+	srv.Init()
+	if srv.Compress == nil {
+		t.Fatal("server.Compress is nil")
+	}
+}
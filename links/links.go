@@ -0,0 +1,124 @@
+package links
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	Link struct {
+		Href  string `json:"href"`
+		Rel   string `json:"-"`
+		Type  string `json:"type,omitempty"`
+		Title string `json:"title,omitempty"`
+	}
+
+	// HAL _links  同一个 rel 多个时为数组
+	HAL map[string]interface{}
+)
+
+var CONTEXT = "GIN.SERVER.LINKS"
+
+// Add 添加并重新输出 Link 头
+func Add(ctx *gin.Context, link *Link) {
+	values := Get(ctx)
+	values = append(values, link)
+	ctx.Set(CONTEXT, values)
+	ctx.Header("Link", Header(values))
+}
+
+// Href 添加 rel
+func Href(ctx *gin.Context, rel string, href string) {
+	Add(ctx, &Link{Rel: rel, Href: href})
+}
+
+// Route 反向路由  name 为 type.action  路由不存在时返回错误
+func Route(ctx *gin.Context, rel string, name string, params ...interface{}) error {
+	href, err := ginResource.URLFor(ctx, name, params...)
+	if err != nil {
+		return err
+	}
+	Href(ctx, rel, href)
+	return nil
+}
+
+func Get(ctx *gin.Context) []*Link {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.([]*Link)
+	}
+	return nil
+}
+
+// Header RFC 8288
+func Header(values []*Link) string {
+	var parts []string
+	for _, link := range values {
+		part := "<" + link.Href + ">; rel=\"" + link.Rel + "\""
+		if link.Type != "" {
+			part += "; type=\"" + link.Type + "\""
+		}
+		if link.Title != "" {
+			part += "; title=\"" + strings.Replace(link.Title, "\"", "'", -1) + "\""
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Links 当前请求的 _links  没有 self 时使用请求地址
+func Links(ctx *gin.Context) HAL {
+	hal := HAL{}
+	for _, link := range Get(ctx) {
+		hal.Add(link)
+	}
+	if _, ok := hal["self"]; !ok {
+		hal["self"] = &Link{Rel: "self", Href: Self(ctx)}
+	}
+	return hal
+}
+
+func (hal HAL) Add(link *Link) {
+	switch val := hal[link.Rel].(type) {
+	case nil:
+		hal[link.Rel] = link
+	case *Link:
+		hal[link.Rel] = []*Link{val, link}
+	case []*Link:
+		hal[link.Rel] = append(val, link)
+	}
+}
+
+// Resource 单个资源的 _links  self 为 type.show
+func Resource(ctx *gin.Context, typ string, id interface{}, related map[string]string) HAL {
+	hal := HAL{}
+	if href, err := ginResource.URLFor(ctx, typ+".show", id); err == nil {
+		hal.Add(&Link{Rel: "self", Href: href})
+	}
+	// rel => type.action
+	for rel, name := range related {
+		if href, err := ginResource.URLFor(ctx, name, id); err == nil {
+			hal.Add(&Link{Rel: rel, Href: href})
+		}
+	}
+	return hal
+}
+
+// Self 请求的完整地址
+func Self(ctx *gin.Context) string {
+	req := ctx.Request
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     req.Host,
+		Path:     req.URL.Path,
+		RawPath:  req.URL.RawPath,
+		RawQuery: req.URL.RawQuery,
+	}
+	return u.String()
+}
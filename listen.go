@@ -0,0 +1,153 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+type (
+	// Listener 一个监听地址  json 可以只写地址
+	Listener struct {
+		Addr string `json:"addr"`
+
+		// tcp tcp4 tcp6  为空时按 Addr  IPv4 为 tcp4  IPv6 为 tcp6 只监听 IPv6  主机名为 tcp
+		// [::] 同时接受 IPv4 IPv6 使用 tcp
+		Network string `json:"network,omitempty"`
+
+		// 为空时使用 server 的 Certificates TLSPolicies  server 也没有时为明文
+		Certificates []Certificate         `json:"certificates,omitempty"`
+		TLSPolicies  map[string]*TLSPolicy `json:"tls_policies,omitempty"`
+
+		// server 有证书时这个地址使用明文  例如内网的健康检查
+		Plaintext bool `json:"plaintext,omitempty"`
+
+		tlsConfig atomic.Value
+	}
+)
+
+func (config *Listener) UnmarshalJSON(data []byte) error {
+	var addr string
+	if json.Unmarshal(data, &addr) == nil {
+		config.Addr = addr
+		return nil
+	}
+	type listener Listener
+	return json.Unmarshal(data, (*listener)(config))
+}
+
+// network 地址是 IP 时只监听对应的协议  0.0.0.0 和 [::] 可以同时监听同一个端口
+func (config *Listener) network() string {
+	if config.Network != "" {
+		return config.Network
+	}
+	if host, _, err := net.SplitHostPort(config.Addr); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			if ip.To4() != nil {
+				return "tcp4"
+			}
+			return "tcp6"
+		}
+	}
+	return "tcp"
+}
+
+// loadTLS 读取自己的证书  SIGHUP 时替换  没有证书时使用 server 的
+func (config *Listener) loadTLS(server *Server, httpServer *http.Server) (*tls.Config, error) {
+	if config.Plaintext {
+		return nil, nil
+	}
+	if len(config.Certificates) == 0 {
+		if server.tlsConfig.Load() == nil {
+			return nil, nil
+		}
+		return httpServer.TLSConfig, nil
+	}
+	if err := config.reloadTLS(server); err != nil {
+		return nil, err
+	}
+	tlsConfig := config.tlsConfig.Load().(*tlsConfigs).base.Clone()
+	tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		return config.tlsConfig.Load().(*tlsConfigs).get(hello.ServerName), nil
+	}
+	return tlsConfig, nil
+}
+
+func (config *Listener) reloadTLS(server *Server) error {
+	if config.Plaintext || len(config.Certificates) == 0 {
+		return nil
+	}
+	policies := config.TLSPolicies
+	if policies == nil {
+		policies = server.TLSPolicies
+	}
+	configs, err := server.loadTLSConfigs(config.Certificates, policies)
+	if err != nil {
+		return err
+	}
+	config.tlsConfig.Store(configs)
+	return nil
+}
+
+// listenersTLS 有自己证书的 Listener  需要配置 http2
+func (server *Server) listenersTLS() bool {
+	for _, val := range server.Listeners {
+		if !val.Plaintext && len(val.Certificates) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// serveListeners 先全部监听  一个失败时关闭已经监听的  Shutdown 后全部结束时返回
+func (server *Server) serveListeners(httpServer *http.Server) error {
+	var listeners []net.Listener
+	var configs []*tls.Config
+	for _, val := range server.Listeners {
+		tlsConfig, err := val.loadTLS(server, httpServer)
+		if err == nil {
+			var listener net.Listener
+			if listener, err = net.Listen(val.network(), val.Addr); err == nil {
+				listeners = append(listeners, listener)
+				configs = append(configs, tlsConfig)
+				continue
+			}
+		}
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		return err
+	}
+
+	errc := make(chan error, len(listeners))
+	for i, listener := range listeners {
+		go func(listener net.Listener, tlsConfig *tls.Config) {
+			errc <- server.serveListener(httpServer, listener, tlsConfig)
+		}(listener, configs[i])
+	}
+	for range listeners {
+		if err := <-errc; err != nil && err != http.ErrServerClosed {
+			return err
+		}
+	}
+	return http.ErrServerClosed
+}
+
+// serveListener 和 serve serveMux 相同  tlsConfig 为空时明文
+func (server *Server) serveListener(httpServer *http.Server, listener net.Listener, tlsConfig *tls.Config) error {
+	if server.ProxyProtocol != nil {
+		listener = server.ProxyProtocol.wrap(listener)
+	}
+	if server.GRPC != nil && tlsConfig == nil {
+		return server.serveCmux(httpServer, listener)
+	}
+	if slow := server.getServerHandler().slow; slow != nil {
+		listener = slow.wrap(listener)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	return httpServer.Serve(listener)
+}
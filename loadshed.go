@@ -0,0 +1,71 @@
+package server
+
+import (
+	"time"
+
+	"github.com/otamoe/gin-server/loadshed"
+	"github.com/otamoe/gin-server/metrics"
+)
+
+type (
+	// LoadShed 过载时按路由优先级返回 503  快速拒绝  不等到超时
+	LoadShed struct {
+		// 0 不检查
+		MaxInFlight   int64         `json:"max_in_flight,omitempty"`
+		TargetLatency time.Duration `json:"target_latency,omitempty"`
+		MaxGCPause    time.Duration `json:"max_gc_pause,omitempty"`
+		MaxGCCPU      float64       `json:"max_gc_cpu,omitempty"`
+
+		// 路由名 type.action 或 type => low normal high critical
+		Routes map[string]string `json:"routes,omitempty"`
+
+		// 默认 normal
+		Default string `json:"default,omitempty"`
+
+		Interval   time.Duration `json:"interval,omitempty"`
+		Step       float64       `json:"step,omitempty"`
+		RetryAfter time.Duration `json:"retry_after,omitempty"`
+
+		shedder *loadshed.Shedder
+	}
+)
+
+// init server 的配置所有 handler 共用一个 Shedder
+func (config *LoadShed) init(server *Server, handler *Handler) {
+	if config.shedder != nil {
+		return
+	}
+	c := loadshed.Config{
+		MaxInFlight:   config.MaxInFlight,
+		TargetLatency: config.TargetLatency,
+		MaxGCPause:    config.MaxGCPause,
+		MaxGCCPU:      config.MaxGCCPU,
+		Routes:        map[string]loadshed.Priority{},
+		Interval:      config.Interval,
+		Step:          config.Step,
+		RetryAfter:    config.RetryAfter,
+	}
+	var err error
+	if c.Default, err = loadshed.ParsePriority(config.Default); err != nil {
+		panic(err)
+	}
+	for name, val := range config.Routes {
+		if c.Routes[name], err = loadshed.ParsePriority(val); err != nil {
+			panic(err)
+		}
+	}
+	if handler != nil {
+		c.Name = handler.Name
+	} else if server != nil {
+		c.Name = server.Name
+	}
+	if server != nil && server.Metrics != nil {
+		c.Registry = metrics.Default
+	}
+	config.shedder = loadshed.New(c)
+}
+
+// Get 当前的 in-flight 延迟 丢弃比例
+func (config *LoadShed) Get() *loadshed.Shedder {
+	return config.shedder
+}
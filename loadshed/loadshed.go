@@ -0,0 +1,309 @@
+package loadshed
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/backpressure"
+	"github.com/otamoe/gin-server/metrics"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	Priority int
+
+	Config struct {
+		// metrics 的 name 标签
+		Name string
+
+		// 同时处理的请求数  0 不检查
+		MaxInFlight int64
+
+		// 延迟的 EWMA  0 不检查
+		TargetLatency time.Duration
+
+		// 采样间隔内 GC 暂停的平均值  0 不检查
+		MaxGCPause time.Duration
+
+		// GC 占用的 CPU 比例 0 - 1  0 不检查
+		MaxGCCPU float64
+
+		// 路由名 type.action 或 type => 优先级
+		Routes map[string]Priority
+
+		// 零值为 PriorityNormal
+		Default Priority
+
+		// 采样 GC 和调整丢弃比例的间隔  默认 1 秒
+		Interval time.Duration
+
+		// 每个间隔丢弃比例增加的值  恢复时减半  默认 0.1
+		Step float64
+
+		// 默认 1 秒
+		RetryAfter time.Duration
+
+		// 有值时输出 loadshed_dropped_total
+		Registry *metrics.Registry
+	}
+
+	Shedder struct {
+		config Config
+
+		inFlight int64
+		sampling int32
+
+		mutex    sync.RWMutex
+		latency  time.Duration
+		gcPause  time.Duration
+		gcCPU    float64
+		fraction float64
+		sampleAt time.Time
+		numGC    uint32
+
+		dropped *metrics.Counter
+	}
+
+	Stats struct {
+		InFlight int64         `json:"in_flight"`
+		Latency  time.Duration `json:"latency"`
+		GCPause  time.Duration `json:"gc_pause"`
+		GCCPU    float64       `json:"gc_cpu"`
+		Fraction float64       `json:"fraction"`
+	}
+)
+
+const (
+	PriorityLow Priority = iota - 1
+	// 零值
+	PriorityNormal
+	PriorityHigh
+	// 不丢弃  例如 健康检查 支付回调
+	PriorityCritical
+)
+
+var CONTEXT = "GIN.SERVER.LOADSHED"
+
+var ErrShed = backpressure.Error(http.StatusServiceUnavailable, "loadshed", time.Second)
+
+// ewma 新延迟的权重
+const ewma = 0.1
+
+func ParsePriority(name string) (Priority, error) {
+	switch strings.ToLower(name) {
+	case "low":
+		return PriorityLow, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	case "critical":
+		return PriorityCritical, nil
+	}
+	return PriorityNormal, errors.New("loadshed: unknown priority " + name)
+}
+
+func (priority Priority) String() string {
+	switch priority {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	case PriorityCritical:
+		return "critical"
+	}
+	return "normal"
+}
+
+func New(c Config) *Shedder {
+	if c.Interval <= 0 {
+		c.Interval = time.Second
+	}
+	if c.Step <= 0 {
+		c.Step = 0.1
+	}
+	if c.RetryAfter <= 0 {
+		c.RetryAfter = time.Second
+	}
+	shedder := &Shedder{
+		config:   c,
+		sampleAt: time.Now(),
+	}
+	if c.Registry != nil {
+		shedder.dropped = c.Registry.Counter("loadshed_dropped_total", "Requests rejected by load shedding.", "name", "priority")
+	}
+	return shedder
+}
+
+// Middleware 过载时按优先级丢弃  越低越先丢弃  PriorityCritical 不丢弃
+func Middleware(shedder *Shedder) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		priority := shedder.priority(ctx)
+		ctx.Set(CONTEXT, priority)
+		if !shedder.Allow(priority) {
+			if shedder.dropped != nil {
+				shedder.dropped.Inc(shedder.config.Name, priority.String())
+			}
+			backpressure.Abort(ctx, ErrShed, shedder.config.RetryAfter)
+			return
+		}
+		done := shedder.Begin()
+		defer done()
+		ctx.Next()
+	}
+}
+
+// Get 当前请求的优先级  没有经过中间件时 PriorityNormal
+func Get(ctx *gin.Context) Priority {
+	if val, ok := ctx.Get(CONTEXT); ok {
+		return val.(Priority)
+	}
+	return PriorityNormal
+}
+
+// Allow 是否处理  不经过 gin 时和 Begin 一起使用
+func (shedder *Shedder) Allow(priority Priority) bool {
+	shedder.sample()
+	if priority >= PriorityCritical {
+		return true
+	}
+	// 超过同时处理的请求数  只处理 high
+	if shedder.config.MaxInFlight > 0 && atomic.LoadInt64(&shedder.inFlight) >= shedder.config.MaxInFlight && priority < PriorityHigh {
+		return false
+	}
+
+	shedder.mutex.RLock()
+	fraction := shedder.fraction
+	shedder.mutex.RUnlock()
+	if fraction <= 0 {
+		return true
+	}
+
+	// low 先丢弃  比例超过 1/3 开始丢弃 normal  超过 2/3 开始丢弃 high
+	probability := fraction*3 - float64(priority-PriorityLow)
+	if probability <= 0 {
+		return true
+	}
+	return rand.Float64() >= probability
+}
+
+// Begin 开始处理  返回的函数在完成时调用
+func (shedder *Shedder) Begin() func() {
+	atomic.AddInt64(&shedder.inFlight, 1)
+	start := time.Now()
+	return func() {
+		atomic.AddInt64(&shedder.inFlight, -1)
+		shedder.observe(time.Since(start))
+	}
+}
+
+func (shedder *Shedder) Stats() Stats {
+	shedder.mutex.RLock()
+	defer shedder.mutex.RUnlock()
+	return Stats{
+		InFlight: atomic.LoadInt64(&shedder.inFlight),
+		Latency:  shedder.latency,
+		GCPause:  shedder.gcPause,
+		GCCPU:    shedder.gcCPU,
+		Fraction: shedder.fraction,
+	}
+}
+
+func (shedder *Shedder) priority(ctx *gin.Context) Priority {
+	if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+		resource := val.(*ginResource.Resource)
+		if val, ok := shedder.config.Routes[resource.Name()]; ok {
+			return val
+		} else if val, ok := shedder.config.Routes[resource.Type]; ok {
+			return val
+		}
+	}
+	return shedder.config.Default
+}
+
+func (shedder *Shedder) observe(latency time.Duration) {
+	if shedder.config.TargetLatency <= 0 {
+		return
+	}
+	shedder.mutex.Lock()
+	if shedder.latency == 0 {
+		shedder.latency = latency
+	} else {
+		shedder.latency = time.Duration(float64(shedder.latency)*(1-ewma) + float64(latency)*ewma)
+	}
+	shedder.mutex.Unlock()
+}
+
+// sample 每个 Interval 只有一个请求执行  ReadMemStats 会暂停所有 goroutine
+func (shedder *Shedder) sample() {
+	shedder.mutex.RLock()
+	due := time.Since(shedder.sampleAt) >= shedder.config.Interval
+	shedder.mutex.RUnlock()
+	if !due || !atomic.CompareAndSwapInt32(&shedder.sampling, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&shedder.sampling, 0)
+
+	var gcPause time.Duration
+	var gcCPU float64
+	var numGC uint32
+	if shedder.config.MaxGCPause > 0 || shedder.config.MaxGCCPU > 0 {
+		stats := &runtime.MemStats{}
+		runtime.ReadMemStats(stats)
+		numGC = stats.NumGC
+		gcCPU = stats.GCCPUFraction
+		if n := numGC - shedder.numGC; n > 0 {
+			if n > uint32(len(stats.PauseNs)) {
+				n = uint32(len(stats.PauseNs))
+			}
+			var total uint64
+			for i := uint32(0); i < n; i++ {
+				total += stats.PauseNs[(numGC-i+255)%256]
+			}
+			gcPause = time.Duration(total / uint64(n))
+		}
+	}
+
+	shedder.mutex.Lock()
+	defer shedder.mutex.Unlock()
+	shedder.sampleAt = time.Now()
+	shedder.numGC = numGC
+	shedder.gcPause = gcPause
+	shedder.gcCPU = gcCPU
+
+	if shedder.overloaded() {
+		shedder.fraction += shedder.config.Step
+		if shedder.fraction > 1 {
+			shedder.fraction = 1
+		}
+	} else if shedder.fraction > 0 {
+		shedder.fraction -= shedder.config.Step / 2
+		if shedder.fraction < 0 {
+			shedder.fraction = 0
+		}
+	}
+}
+
+func (shedder *Shedder) overloaded() bool {
+	config := shedder.config
+	if config.MaxInFlight > 0 && atomic.LoadInt64(&shedder.inFlight) >= config.MaxInFlight {
+		return true
+	}
+	if config.TargetLatency > 0 && shedder.latency > config.TargetLatency {
+		return true
+	}
+	if config.MaxGCPause > 0 && shedder.gcPause > config.MaxGCPause {
+		return true
+	}
+	if config.MaxGCCPU > 0 && shedder.gcCPU > config.MaxGCCPU {
+		return true
+	}
+	return false
+}
@@ -1,17 +1,89 @@
 package server
 
 import (
+	"context"
+	"io"
 	"log"
 	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/kafka"
+	"github.com/otamoe/gin-server/logger"
 	"github.com/sirupsen/logrus"
 )
 
 type (
 	Logger struct {
-		File   string `json:"file,omitempty"`
-		logger *logrus.Logger
+		File string `json:"file,omitempty"`
+
+		// 为空时根据 env
+		Level string `json:"level,omitempty"`
+
+		// 访问日志格式 common combined ecs  为空时和其他日志相同
+		Format string `json:"format,omitempty"`
+		// 访问日志文件  为空时写入 File
+		AccessFile string `json:"access_file,omitempty"`
+
+		// 发送到 fluentd logstash
+		Forward *LoggerForward `json:"forward,omitempty"`
+
+		// 发送到 kafka
+		Kafka *LoggerKafka `json:"kafka,omitempty"`
+
+		// 访问日志的 IP query user agent 数据最小化
+		Anonymize *LoggerAnonymize `json:"anonymize,omitempty"`
+
+		logger     *logrus.Logger
+		file       *os.File
+		accessFile *os.File
+		access     atomic.Value
+	}
+
+	LoggerForward struct {
+		// tcp udp
+		Network string `json:"network,omitempty"`
+		Addr    string `json:"addr,omitempty"`
+		// json forward
+		Protocol   string `json:"protocol,omitempty"`
+		Tag        string `json:"tag,omitempty"`
+		BufferSize int    `json:"buffer_size,omitempty"`
+		// 最低级别  为空时全部
+		Level string `json:"level,omitempty"`
+
+		hook *logger.Forward
+	}
+
+	LoggerKafka struct {
+		Brokers     []string `json:"brokers,omitempty"`
+		Topic       string   `json:"topic,omitempty"`
+		Compression string   `json:"compression,omitempty"`
+		BatchSize   int      `json:"batch_size,omitempty"`
+		// at-most-once at-least-once
+		Delivery      string        `json:"delivery,omitempty"`
+		FlushInterval time.Duration `json:"flush_interval,omitempty"`
+		// 最低级别  为空时全部
+		Level string `json:"level,omitempty"`
+
+		producer *kafka.Producer
+	}
+
+	LoggerAnonymize struct {
+		// mask  IPv4 最后一段为 0  IPv6 保留 /64
+		// hash  HMAC-SHA256 的前 16 字节  Key 为空时 sha256
+		IP  string `json:"ip,omitempty"`
+		Key string `json:"key,omitempty"`
+
+		// 删除的 query 参数  例如 token email
+		Query []string `json:"query,omitempty"`
+
+		// user agent 最多的字符数  0 不截断
+		UserAgent int `json:"user_agent,omitempty"`
+	}
+
+	accessWriter struct {
+		config *Logger
 	}
 )
 
@@ -34,6 +106,13 @@ func (config *Logger) init(server *Server, handler *Handler) {
 			config.logger.SetLevel(logrus.InfoLevel)
 		}
 	}
+	if config.Level != "" {
+		level, err := logrus.ParseLevel(config.Level)
+		if err != nil {
+			panic(err)
+		}
+		config.logger.SetLevel(level)
+	}
 
 	config.logger.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp:   true,
@@ -41,15 +120,58 @@ func (config *Logger) init(server *Server, handler *Handler) {
 	})
 
 	config.logger.SetOutput(os.Stdout)
+	if server.testing() && server.logs != nil {
+		config.logger.SetOutput(server.logs)
+	}
 	if config.File != "" {
-		writer, err := os.OpenFile(config.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		config.logger.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339,
+		})
+	}
+	if err := config.reopen(); err != nil {
+		panic(err)
+	}
+
+	// test env 不连接网络
+	if config.Forward != nil && !server.testing() {
+		tag := config.Forward.Tag
+		if tag == "" && server != nil {
+			tag = server.Name
+		}
+		hook, err := logger.NewForward(logger.ForwardConfig{
+			Network:    config.Forward.Network,
+			Addr:       config.Forward.Addr,
+			Protocol:   config.Forward.Protocol,
+			Tag:        tag,
+			BufferSize: config.Forward.BufferSize,
+			Levels:     levels(config.Forward.Level),
+		})
 		if err != nil {
 			panic(err)
 		}
-		config.logger.SetFormatter(&logrus.JSONFormatter{
-			TimestampFormat: time.RFC3339,
+		config.Forward.hook = hook
+		config.logger.AddHook(hook)
+	}
+
+	if config.Kafka != nil && !server.testing() {
+		clientID := "gin-server"
+		if server != nil && server.Name != "" {
+			clientID = server.Name
+		}
+		producer, err := kafka.New(kafka.Config{
+			Brokers:       config.Kafka.Brokers,
+			Topic:         config.Kafka.Topic,
+			ClientID:      clientID,
+			Compression:   config.Kafka.Compression,
+			BatchSize:     config.Kafka.BatchSize,
+			FlushInterval: config.Kafka.FlushInterval,
+			Delivery:      config.Kafka.Delivery,
 		})
-		config.logger.SetOutput(writer)
+		if err != nil {
+			panic(err)
+		}
+		config.Kafka.producer = producer
+		config.logger.AddHook(producer.Hook("", levels(config.Kafka.Level)))
 	}
 
 	if handler == nil {
@@ -57,6 +179,110 @@ func (config *Logger) init(server *Server, handler *Handler) {
 	}
 }
 
+// Hook 返回 Forward 的 hook  用于查看 Sent Dropped Errors
+func (config *LoggerForward) Hook() *logger.Forward {
+	return config.hook
+}
+
+// Producer 可以用于发送 audit 等其他消息
+func (config *LoggerKafka) Producer() *kafka.Producer {
+	return config.producer
+}
+
+// close 发送剩余日志
+func (config *Logger) close(timeout time.Duration) {
+	if config.Forward != nil && config.Forward.hook != nil {
+		config.Forward.hook.Close(timeout)
+	}
+	if config.Kafka != nil && config.Kafka.producer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := config.Kafka.producer.Close(ctx); err != nil {
+			config.logger.Errorf("[KAFKA] close %s", err)
+		}
+	}
+}
+
+// levels level 及以上的级别  为空时全部
+func levels(level string) (levels []logrus.Level) {
+	if level == "" {
+		return
+	}
+	val, err := logrus.ParseLevel(level)
+	if err != nil {
+		panic(err)
+	}
+	for _, l := range logrus.AllLevels {
+		if l <= val {
+			levels = append(levels, l)
+		}
+	}
+	return
+}
+
+// reopen logrotate 之后重新打开文件
+func (config *Logger) reopen() error {
+	if config.File != "" {
+		writer, err := os.OpenFile(config.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		config.logger.SetOutput(writer)
+		if config.file != nil {
+			config.file.Close()
+		}
+		config.file = writer
+	}
+	if config.AccessFile != "" {
+		writer, err := os.OpenFile(config.AccessFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		old := config.accessFile
+		config.access.Store(writer)
+		if old != nil {
+			old.Close()
+		}
+		config.accessFile = writer
+	}
+	return nil
+}
+
+// accessWriter 访问日志  reopen 之后写入新文件
+func (config *Logger) accessWriter() io.Writer {
+	if config.AccessFile == "" {
+		return nil
+	}
+	return accessWriter{config}
+}
+
+func (w accessWriter) Write(data []byte) (int, error) {
+	return w.config.access.Load().(*os.File).Write(data)
+}
+
+// middleware 访问日志
+func (config *Logger) middleware() gin.HandlerFunc {
+	return logger.Middleware(logger.Config{
+		Prefix:    "[HTTP] ",
+		Logger:    config.Get(),
+		Format:    config.Format,
+		Writer:    config.accessWriter(),
+		Anonymize: config.anonymize(),
+	})
+}
+
+func (config *Logger) anonymize() *logger.Anonymize {
+	if config.Anonymize == nil {
+		return nil
+	}
+	return &logger.Anonymize{
+		IP:        config.Anonymize.IP,
+		Key:       []byte(config.Anonymize.Key),
+		Query:     config.Anonymize.Query,
+		UserAgent: config.Anonymize.UserAgent,
+	}
+}
+
 func (config *Logger) Get() *logrus.Logger {
 	return config.logger
 }
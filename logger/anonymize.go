@@ -0,0 +1,199 @@
+package logger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	// Anonymize 访问日志的数据最小化  nil 不处理
+	Anonymize struct {
+		// mask IPv4 最后一段为 0  IPv6 保留 /64
+		// hash HMAC-SHA256(Key) 的前 16 字节  同一个 IP 相同  无法还原
+		IP string
+
+		// hash 的密钥  为空时 sha256  IPv4 可以被穷举
+		Key []byte
+
+		// 删除的 query 参数  例如 token email  包括 referer
+		Query []string
+
+		// user agent 最多的字符数  0 不截断
+		UserAgent int
+	}
+)
+
+const (
+	AnonymizeMask = "mask"
+	AnonymizeHash = "hash"
+)
+
+// 客户端 IP 的请求头  5xx 输出请求头时处理
+var ipHeaders = []string{
+	"X-Forwarded-For",
+	"X-Real-Ip",
+	"Cf-Connecting-Ip",
+	"True-Client-Ip",
+	"X-Appengine-Remote-Addr",
+}
+
+var ipv4Mask = net.CIDRMask(24, 32)
+var ipv6Mask = net.CIDRMask(64, 128)
+
+// AnonymizeIP 按 IP 处理  不是 IP 时原样返回
+func (config *Anonymize) AnonymizeIP(value string) string {
+	if config == nil || config.IP == "" || value == "" {
+		return value
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return value
+	}
+	switch config.IP {
+	case AnonymizeMask:
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(ipv4Mask).String()
+		}
+		return ip.Mask(ipv6Mask).String()
+	case AnonymizeHash:
+		var sum []byte
+		if len(config.Key) != 0 {
+			mac := hmac.New(sha256.New, config.Key)
+			mac.Write([]byte(ip.String()))
+			sum = mac.Sum(nil)
+		} else {
+			val := sha256.Sum256([]byte(ip.String()))
+			sum = val[:]
+		}
+		return hex.EncodeToString(sum[:16])
+	}
+	return value
+}
+
+// AnonymizeQuery 删除 Query 中的参数
+func (config *Anonymize) AnonymizeQuery(query url.Values) url.Values {
+	if config == nil || len(config.Query) == 0 {
+		return query
+	}
+	for _, name := range config.Query {
+		query.Del(name)
+	}
+	return query
+}
+
+// AnonymizeURI path?query 或完整的 url  例如 RequestURI referer
+func (config *Anonymize) AnonymizeURI(value string) string {
+	if config == nil || len(config.Query) == 0 || !strings.Contains(value, "?") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+	query := u.Query()
+	changed := false
+	for _, name := range config.Query {
+		if _, ok := query[name]; ok {
+			query.Del(name)
+			changed = true
+		}
+	}
+	if !changed {
+		return value
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// AnonymizeUserAgent 截断到 UserAgent 个字符
+func (config *Anonymize) AnonymizeUserAgent(value string) string {
+	if config == nil || config.UserAgent <= 0 || utf8.RuneCountInString(value) <= config.UserAgent {
+		return value
+	}
+	return string([]rune(value)[:config.UserAgent])
+}
+
+// request 5xx 输出请求头时使用  不修改原来的请求
+func (config *Anonymize) request(req *http.Request) *http.Request {
+	if config == nil {
+		return req
+	}
+	clone := new(http.Request)
+	*clone = *req
+	u := *req.URL
+	u.RawQuery = config.AnonymizeQuery(req.URL.Query()).Encode()
+	clone.URL = &u
+	clone.RequestURI = config.AnonymizeURI(req.RequestURI)
+	clone.Header = http.Header{}
+	for key, val := range req.Header {
+		clone.Header[key] = val
+	}
+	if val := req.UserAgent(); val != "" {
+		clone.Header.Set("User-Agent", config.AnonymizeUserAgent(val))
+	}
+	if val := req.Referer(); val != "" {
+		clone.Header.Set("Referer", config.AnonymizeURI(val))
+	}
+	if config.IP != "" {
+		for _, name := range ipHeaders {
+			if values, ok := clone.Header[name]; ok {
+				clone.Header[name] = config.anonymizeList(values)
+			}
+		}
+		if values, ok := clone.Header["Forwarded"]; ok {
+			clone.Header["Forwarded"] = config.anonymizeForwarded(values)
+		}
+	}
+	return clone
+}
+
+// anonymizeList X-Forwarded-For  逗号分隔的 IP
+func (config *Anonymize) anonymizeList(values []string) []string {
+	result := make([]string, len(values))
+	for i, value := range values {
+		parts := strings.Split(value, ",")
+		for j, part := range parts {
+			parts[j] = config.AnonymizeIP(strings.TrimSpace(part))
+		}
+		result[i] = strings.Join(parts, ", ")
+	}
+	return result
+}
+
+// anonymizeForwarded RFC 7239  for by 的 IP  端口去掉
+func (config *Anonymize) anonymizeForwarded(values []string) []string {
+	result := make([]string, len(values))
+	for i, value := range values {
+		elements := strings.Split(value, ",")
+		for j, element := range elements {
+			pairs := strings.Split(element, ";")
+			for k, pair := range pairs {
+				index := strings.Index(pair, "=")
+				if index == -1 {
+					continue
+				}
+				key := strings.ToLower(strings.TrimSpace(pair[:index]))
+				if key != "for" && key != "by" {
+					continue
+				}
+				node := strings.Trim(strings.TrimSpace(pair[index+1:]), "\"")
+				if host, _, err := net.SplitHostPort(node); err == nil {
+					node = host
+				}
+				node = strings.Trim(node, "[]")
+				if net.ParseIP(node) != nil {
+					pairs[k] = key + "=\"" + config.AnonymizeIP(node) + "\""
+				}
+			}
+			elements[j] = strings.TrimSpace(strings.Join(pairs, ";"))
+		}
+		result[i] = strings.Join(elements, ", ")
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	hashed := (&Anonymize{IP: AnonymizeHash}).AnonymizeIP("203.0.113.7")
+	tests := []struct {
+		name   string
+		config *Anonymize
+		value  string
+		want   string
+	}{
+		{"nil", nil, "203.0.113.7", "203.0.113.7"},
+		{"disabled", &Anonymize{}, "203.0.113.7", "203.0.113.7"},
+		{"mask ipv4", &Anonymize{IP: AnonymizeMask}, "203.0.113.7", "203.0.113.0"},
+		{"mask ipv6", &Anonymize{IP: AnonymizeMask}, "2001:db8:1:2:3:4:5:6", "2001:db8:1:2::"},
+		{"mask not ip", &Anonymize{IP: AnonymizeMask}, "unknown", "unknown"},
+		{"hash same", &Anonymize{IP: AnonymizeHash}, "203.0.113.7", hashed},
+		{"hash ipv4 in ipv6", &Anonymize{IP: AnonymizeHash}, "::ffff:203.0.113.7", hashed},
+	}
+	for _, test := range tests {
+		if got := test.config.AnonymizeIP(test.value); got != test.want {
+			t.Errorf("%s: AnonymizeIP(%q) = %q, want %q", test.name, test.value, got, test.want)
+		}
+	}
+	if len(hashed) != 32 || hashed == "203.0.113.7" {
+		t.Errorf("hash %q", hashed)
+	}
+	if keyed := (&Anonymize{IP: AnonymizeHash, Key: []byte("key")}).AnonymizeIP("203.0.113.7"); keyed == hashed {
+		t.Error("hash with key equals hash without key")
+	}
+}
+
+func TestAnonymizeQuery(t *testing.T) {
+	config := &Anonymize{Query: []string{"token", "email"}}
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"/a", "/a"},
+		{"/a?page=1", "/a?page=1"},
+		{"/a?token=x&page=1", "/a?page=1"},
+		{"https://example.com/a?email=a%40b.c", "https://example.com/a"},
+	}
+	for _, test := range tests {
+		if got := config.AnonymizeURI(test.value); got != test.want {
+			t.Errorf("AnonymizeURI(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+	query := config.AnonymizeQuery(url.Values{"token": {"x"}, "page": {"1"}})
+	if query.Encode() != "page=1" {
+		t.Errorf("AnonymizeQuery = %q", query.Encode())
+	}
+}
+
+func TestAnonymizeUserAgent(t *testing.T) {
+	tests := []struct {
+		limit int
+		value string
+		want  string
+	}{
+		{0, "Mozilla/5.0", "Mozilla/5.0"},
+		{7, "Mozilla/5.0", "Mozilla"},
+		{20, "Mozilla/5.0", "Mozilla/5.0"},
+		{2, "浏览器", "浏览"},
+	}
+	for _, test := range tests {
+		config := &Anonymize{UserAgent: test.limit}
+		if got := config.AnonymizeUserAgent(test.value); got != test.want {
+			t.Errorf("AnonymizeUserAgent(%d, %q) = %q, want %q", test.limit, test.value, got, test.want)
+		}
+	}
+}
+
+func TestAnonymizeRequest(t *testing.T) {
+	config := &Anonymize{IP: AnonymizeMask, Query: []string{"token"}}
+	req := httptest.NewRequest(http.MethodGet, "/a?token=x&page=1", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.9")
+	req.Header.Set("X-Real-Ip", "203.0.113.7")
+	req.Header.Set("Cf-Connecting-Ip", "203.0.113.7")
+	req.Header.Set("X-Appengine-Remote-Addr", "203.0.113.7")
+	req.Header.Set("Forwarded", `for=203.0.113.7:4711;proto=https, for="[2001:db8:1:2::9]";by=198.51.100.9`)
+
+	clone := config.request(req)
+	for _, name := range []string{"X-Forwarded-For", "X-Real-Ip", "Cf-Connecting-Ip", "X-Appengine-Remote-Addr", "Forwarded"} {
+		if strings.Contains(clone.Header.Get(name), "203.0.113.7") || strings.Contains(clone.Header.Get(name), "198.51.100.9") {
+			t.Errorf("%s not anonymized: %q", name, clone.Header.Get(name))
+		}
+	}
+	if got := clone.Header.Get("X-Forwarded-For"); got != "203.0.113.0, 198.51.100.0" {
+		t.Errorf("X-Forwarded-For %q", got)
+	}
+	if got := clone.Header.Get("Forwarded"); got != `for="203.0.113.0";proto=https, for="2001:db8:1:2::";by="198.51.100.0"` {
+		t.Errorf("Forwarded %q", got)
+	}
+	if clone.URL.RawQuery != "page=1" {
+		t.Errorf("query %q", clone.URL.RawQuery)
+	}
+	// 原来的请求不变
+	if req.Header.Get("X-Real-Ip") != "203.0.113.7" || req.URL.RawQuery != "token=x&page=1" {
+		t.Error("original request modified")
+	}
+}
@@ -0,0 +1,177 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	FormatECS      = "ecs"
+)
+
+// ECSVersion Elastic Common Schema 版本
+var ECSVersion = "1.6.0"
+
+// Common Apache Common Log Format
+// 127.0.0.1 - user [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326
+func (logger *Logger) Common(ctx *gin.Context) string {
+	req := ctx.Request
+
+	user := "-"
+	if logger.UserID != "" {
+		user = logger.UserID.Hex()
+	} else if name, _, ok := req.BasicAuth(); ok && name != "" {
+		user = name
+	}
+
+	size := "-"
+	if val := ctx.Writer.Size(); val > 0 {
+		size = strconv.Itoa(val)
+	}
+
+	uri := req.URL.RequestURI()
+	if req.RequestURI != "" {
+		uri = req.RequestURI
+	}
+	uri = logger.anonymize.AnonymizeURI(uri)
+
+	return strings.Join([]string{
+		clfValue(logger.IP),
+		"-",
+		clfValue(user),
+		"[" + logger.CreatedAt.Format("02/Jan/2006:15:04:05 -0700") + "]",
+		strconv.Quote(req.Method + " " + uri + " " + req.Proto),
+		strconv.Itoa(logger.StatusCode),
+		size,
+	}, " ")
+}
+
+// Combined Common 加上 referer user agent
+func (logger *Logger) Combined(ctx *gin.Context) string {
+	referer := logger.anonymize.AnonymizeURI(ctx.Request.Referer())
+	userAgent := logger.anonymize.AnonymizeUserAgent(ctx.Request.UserAgent())
+	return logger.Common(ctx) + " " + clfQuote(referer) + " " + clfQuote(userAgent)
+}
+
+func clfValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.Replace(value, " ", "_", -1)
+}
+
+func clfQuote(value string) string {
+	if value == "" {
+		return `"-"`
+	}
+	return strconv.Quote(value)
+}
+
+// ECS Elastic Common Schema json  Fields 放在 labels
+func (logger *Logger) ECS(ctx *gin.Context) string {
+	req := ctx.Request
+
+	level := "info"
+	outcome := "success"
+	if logger.StatusCode >= 500 {
+		level = "error"
+		outcome = "failure"
+	} else if logger.ErrorsText != "" {
+		level = "warn"
+	}
+
+	request := map[string]interface{}{
+		"method": req.Method,
+	}
+	if val := logger.anonymize.AnonymizeURI(req.Referer()); val != "" {
+		request["referrer"] = val
+	}
+	if req.ContentLength > 0 {
+		request["body"] = map[string]interface{}{"bytes": req.ContentLength}
+	}
+	response := map[string]interface{}{
+		"status_code": logger.StatusCode,
+	}
+	if val := ctx.Writer.Size(); val > 0 {
+		response["body"] = map[string]interface{}{"bytes": val}
+	}
+
+	uri := logger.anonymize.AnonymizeURI(req.URL.RequestURI())
+	u := map[string]interface{}{
+		"path":     logger.Path,
+		"original": uri,
+	}
+	if i := strings.Index(uri, "?"); i != -1 && i+1 < len(uri) {
+		u["query"] = uri[i+1:]
+	}
+	if logger.Host != "" {
+		u["domain"] = logger.Host
+	}
+	if logger.Scheme != "" {
+		u["scheme"] = logger.Scheme
+	}
+
+	doc := map[string]interface{}{
+		"@timestamp": logger.CreatedAt.UTC().Format(time.RFC3339Nano),
+		"message":    fmt.Sprintf("%s %d %s", logger.Method, logger.StatusCode, uri),
+		"ecs":        map[string]interface{}{"version": ECSVersion},
+		"log":        map[string]interface{}{"level": level, "logger": "access"},
+		"event": map[string]interface{}{
+			"id":       logger.ID.Hex(),
+			"kind":     "event",
+			"category": []string{"web"},
+			"type":     []string{"access"},
+			"outcome":  outcome,
+			"duration": logger.Latency.Nanoseconds(),
+		},
+		"http": map[string]interface{}{
+			"version":  strings.TrimPrefix(req.Proto, "HTTP/"),
+			"request":  request,
+			"response": response,
+		},
+		"url":    u,
+		"client": map[string]interface{}{"ip": logger.IP},
+	}
+	// hash 之后不是 IP
+	if logger.IP != "" && net.ParseIP(logger.IP) == nil {
+		doc["client"] = map[string]interface{}{"address": logger.IP}
+	}
+	if val := logger.anonymize.AnonymizeUserAgent(req.UserAgent()); val != "" {
+		doc["user_agent"] = map[string]interface{}{"original": val}
+	}
+	if logger.UserID != "" {
+		doc["user"] = map[string]interface{}{"id": logger.UserID.Hex()}
+	}
+	if logger.ErrorsText != "" {
+		doc["error"] = map[string]interface{}{"message": logger.ErrorsText}
+	}
+	if val, ok := logger.Fields["trace_id"]; ok {
+		doc["trace"] = map[string]interface{}{"id": val}
+	}
+
+	labels := map[string]string{}
+	for key, val := range logger.Fields {
+		switch key {
+		case "ip", "latency", "errors_text", "trace_id", "user_id":
+			continue
+		}
+		labels[key] = fmt.Sprint(val)
+	}
+	if len(labels) != 0 {
+		doc["labels"] = labels
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
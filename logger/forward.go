@@ -0,0 +1,361 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	ForwardConfig struct {
+		// tcp udp
+		Network string
+		Addr    string
+
+		// json (logstash json_lines) 或 forward (fluentd msgpack)
+		Protocol string
+
+		// fluentd tag
+		Tag string
+
+		// 缓冲条数  满了丢弃
+		BufferSize int
+
+		// 为空时全部级别
+		Levels []logrus.Level
+
+		DialTimeout  time.Duration
+		WriteTimeout time.Duration
+	}
+
+	// Forward logrus hook  异步发送到 fluentd logstash  断开后自动重连
+	Forward struct {
+		config  ForwardConfig
+		queue   chan []byte
+		done    chan struct{}
+		stopped chan struct{}
+		once    sync.Once
+		conn    net.Conn
+		writer  *bufio.Writer
+		sent    uint64
+		dropped uint64
+		errors  uint64
+	}
+)
+
+const (
+	ProtocolJSON    = "json"
+	ProtocolForward = "forward"
+)
+
+func NewForward(c ForwardConfig) (*Forward, error) {
+	if c.Network == "" {
+		c.Network = "tcp"
+	}
+	if c.Protocol == "" {
+		c.Protocol = ProtocolJSON
+	}
+	if c.Tag == "" {
+		c.Tag = "app"
+	}
+	if c.BufferSize == 0 {
+		c.BufferSize = 8192
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = time.Second * 2
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = time.Second * 5
+	}
+	if len(c.Levels) == 0 {
+		c.Levels = logrus.AllLevels
+	}
+	switch c.Protocol {
+	case ProtocolJSON, ProtocolForward:
+	default:
+		return nil, errors.New("Logger: unknown forward protocol " + c.Protocol)
+	}
+	if c.Addr == "" {
+		return nil, errors.New("Logger: forward addr is empty")
+	}
+	forward := &Forward{
+		config:  c,
+		queue:   make(chan []byte, c.BufferSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go forward.run()
+	return forward, nil
+}
+
+func (forward *Forward) Levels() []logrus.Level {
+	return forward.config.Levels
+}
+
+func (forward *Forward) Fire(entry *logrus.Entry) error {
+	record := make(map[string]interface{}, len(entry.Data)+3)
+	for key, val := range entry.Data {
+		switch val := val.(type) {
+		case error:
+			record[key] = val.Error()
+		case time.Duration:
+			record[key] = val.Nanoseconds()
+		default:
+			record[key] = val
+		}
+	}
+	record["level"] = entry.Level.String()
+	record["message"] = entry.Message
+
+	var data []byte
+	var err error
+	if forward.config.Protocol == ProtocolForward {
+		data, err = encodeForward(forward.config.Tag, entry.Time, record)
+	} else {
+		record["@timestamp"] = entry.Time.Format(time.RFC3339Nano)
+		record["tag"] = forward.config.Tag
+		if data, err = json.Marshal(record); err == nil {
+			data = append(data, '\n')
+		}
+	}
+	if err != nil {
+		atomic.AddUint64(&forward.errors, 1)
+		return nil
+	}
+
+	select {
+	case <-forward.done:
+		atomic.AddUint64(&forward.dropped, 1)
+	case forward.queue <- data:
+	default:
+		atomic.AddUint64(&forward.dropped, 1)
+	}
+	return nil
+}
+
+// Sent 已发送条数
+func (forward *Forward) Sent() uint64 {
+	return atomic.LoadUint64(&forward.sent)
+}
+
+// Dropped 缓冲满 或 关闭后丢弃的条数
+func (forward *Forward) Dropped() uint64 {
+	return atomic.LoadUint64(&forward.dropped)
+}
+
+// Errors 编码 连接 写入失败次数
+func (forward *Forward) Errors() uint64 {
+	return atomic.LoadUint64(&forward.errors)
+}
+
+// Close 发送缓冲中的日志后关闭  最多等待 timeout
+func (forward *Forward) Close(timeout time.Duration) {
+	forward.once.Do(func() {
+		close(forward.done)
+	})
+	select {
+	case <-forward.stopped:
+	case <-time.After(timeout):
+	}
+}
+
+func (forward *Forward) run() {
+	defer close(forward.stopped)
+	backoff := time.Millisecond * 100
+	for {
+		var data []byte
+		select {
+		case data = <-forward.queue:
+		case <-forward.done:
+			// 关闭后继续发送剩余的
+			select {
+			case data = <-forward.queue:
+			default:
+				forward.disconnect()
+				return
+			}
+		}
+
+		for {
+			err := forward.write(data)
+			if err == nil {
+				atomic.AddUint64(&forward.sent, 1)
+				backoff = time.Millisecond * 100
+				break
+			}
+			atomic.AddUint64(&forward.errors, 1)
+			forward.disconnect()
+
+			select {
+			case <-forward.done:
+				atomic.AddUint64(&forward.dropped, uint64(len(forward.queue))+1)
+				for len(forward.queue) != 0 {
+					<-forward.queue
+				}
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < time.Second*10 {
+				backoff *= 2
+			}
+		}
+	}
+}
+
+func (forward *Forward) write(data []byte) (err error) {
+	if forward.conn == nil {
+		if forward.conn, err = net.DialTimeout(forward.config.Network, forward.config.Addr, forward.config.DialTimeout); err != nil {
+			forward.conn = nil
+			return
+		}
+		forward.writer = bufio.NewWriter(forward.conn)
+	}
+	forward.conn.SetWriteDeadline(time.Now().Add(forward.config.WriteTimeout))
+	if _, err = forward.writer.Write(data); err != nil {
+		return
+	}
+	// udp 每条一个包  tcp 队列空时 flush
+	if forward.config.Network != "tcp" || len(forward.queue) == 0 {
+		err = forward.writer.Flush()
+	}
+	return
+}
+
+func (forward *Forward) disconnect() {
+	if forward.conn != nil {
+		if forward.writer != nil {
+			forward.writer.Flush()
+		}
+		forward.conn.Close()
+		forward.conn = nil
+		forward.writer = nil
+	}
+}
+
+// encodeForward fluentd forward protocol message mode  [tag, time, record]
+func encodeForward(tag string, t time.Time, record map[string]interface{}) ([]byte, error) {
+	buf := []byte{0x93}
+	buf = msgpackString(buf, tag)
+	buf = msgpackInt(buf, t.Unix())
+	return msgpackValue(buf, record)
+}
+
+func msgpackValue(buf []byte, value interface{}) ([]byte, error) {
+	var err error
+	switch val := value.(type) {
+	case nil:
+		buf = append(buf, 0xc0)
+	case bool:
+		if val {
+			buf = append(buf, 0xc3)
+		} else {
+			buf = append(buf, 0xc2)
+		}
+	case string:
+		buf = msgpackString(buf, val)
+	case []byte:
+		buf = msgpackString(buf, string(val))
+	case int:
+		buf = msgpackInt(buf, int64(val))
+	case int8:
+		buf = msgpackInt(buf, int64(val))
+	case int16:
+		buf = msgpackInt(buf, int64(val))
+	case int32:
+		buf = msgpackInt(buf, int64(val))
+	case int64:
+		buf = msgpackInt(buf, val)
+	case uint:
+		buf = msgpackInt(buf, int64(val))
+	case uint8:
+		buf = msgpackInt(buf, int64(val))
+	case uint16:
+		buf = msgpackInt(buf, int64(val))
+	case uint32:
+		buf = msgpackInt(buf, int64(val))
+	case uint64:
+		buf = msgpackInt(buf, int64(val))
+	case float32:
+		buf = msgpackFloat(buf, float64(val))
+	case float64:
+		buf = msgpackFloat(buf, val)
+	case map[string]interface{}:
+		buf = msgpackLength(buf, len(val), 0x80, 0xdf)
+		for key, item := range val {
+			buf = msgpackString(buf, key)
+			if buf, err = msgpackValue(buf, item); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		buf = msgpackLength(buf, len(val), 0x90, 0xdd)
+		for _, item := range val {
+			if buf, err = msgpackValue(buf, item); err != nil {
+				return nil, err
+			}
+		}
+	case fmt.Stringer:
+		buf = msgpackString(buf, val.String())
+	default:
+		// 其他类型转为 json
+		var data []byte
+		if data, err = json.Marshal(val); err != nil {
+			return nil, err
+		}
+		buf = msgpackString(buf, string(data))
+	}
+	return buf, nil
+}
+
+func msgpackString(buf []byte, s string) []byte {
+	length := len(s)
+	switch {
+	case length < 32:
+		buf = append(buf, 0xa0|byte(length))
+	case length < 1<<8:
+		buf = append(buf, 0xd9, byte(length))
+	case length < 1<<16:
+		buf = append(buf, 0xda, byte(length>>8), byte(length))
+	default:
+		buf = append(buf, 0xdb, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+	}
+	return append(buf, s...)
+}
+
+func msgpackInt(buf []byte, n int64) []byte {
+	if n >= 0 && n < 128 {
+		return append(buf, byte(n))
+	}
+	if n < 0 && n >= -32 {
+		return append(buf, byte(n))
+	}
+	buf = append(buf, 0xd3)
+	for i := 7; i >= 0; i-- {
+		buf = append(buf, byte(n>>(uint(i)*8)))
+	}
+	return buf
+}
+
+func msgpackFloat(buf []byte, f float64) []byte {
+	n := math.Float64bits(f)
+	buf = append(buf, 0xcb)
+	for i := 7; i >= 0; i-- {
+		buf = append(buf, byte(n>>(uint(i)*8)))
+	}
+	return buf
+}
+
+func msgpackLength(buf []byte, length int, fix byte, large byte) []byte {
+	if length < 16 {
+		return append(buf, fix|byte(length))
+	}
+	return append(buf, large, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+}
@@ -2,6 +2,7 @@ package logger
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -12,6 +13,8 @@ import (
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/otamoe/gin-server/bind"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/errs"
 	ginResource "github.com/otamoe/gin-server/resource"
 	mgoModel "github.com/otamoe/mgo-model"
 	"github.com/sirupsen/logrus"
@@ -21,6 +24,14 @@ type (
 	Config struct {
 		Prefix string
 		Logger *logrus.Logger
+
+		// 访问日志格式  为空时使用 Logger  common combined ecs 写入 Writer
+		Format string
+		// 为空时写入 Logger.Out
+		Writer io.Writer
+
+		// IP query user agent 的数据最小化  为空时不处理
+		Anonymize *Anonymize
 	}
 	Logger struct {
 		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
@@ -42,6 +53,8 @@ type (
 		Fields                map[string]interface{} `json:"fields,omitempty" bson:"fields,omitempty"`
 		CreatedAt             *time.Time             `json:"created_at" bson:"created_at"`
 		Logrus                *logrus.Logger         `json:"-" bson:"-" binding:"-"`
+
+		anonymize *Anonymize
 	}
 	BindInterface interface {
 		BindMarshal() map[string]interface{}
@@ -51,6 +64,7 @@ type (
 var (
 	CONTEXT          = "GIN.SERVER.LOGGER"
 	CONTEXT_CALLBACK = "GIN.SERVER.LOGGER.CALBACK"
+	CONTEXT_ENTRY    = "GIN.SERVER.LOGGER.ENTRY"
 	Model            = &mgoModel.Model{
 		Name:     "loggers",
 		Document: &Logger{},
@@ -81,19 +95,34 @@ func Middleware(c Config) gin.HandlerFunc {
 
 		logger := &Logger{
 			ID:        bson.NewObjectId(),
-			IP:        ctx.ClientIP(),
+			IP:        c.Anonymize.AnonymizeIP(clientip.ClientIP(ctx)),
 			Method:    req.Method,
 			Scheme:    url.Scheme,
 			Host:      host,
 			Path:      url.Path,
-			Query:     url.Query(),
+			Query:     c.Anonymize.AnonymizeQuery(url.Query()),
 			Fields:    map[string]interface{}{},
 			CreatedAt: now,
 			Logrus:    c.Logger,
+			anonymize: c.Anonymize,
 		}
 
 		ctx.Set(CONTEXT, logger)
 
+		// 请求的 logrus entry
+		entry := logrus.NewEntry(c.Logger).WithFields(logrus.Fields{
+			"log_id": logger.ID.Hex(),
+			"host":   host,
+			"ip":     logger.IP,
+		})
+		if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+			entry = entry.WithField("route", val.(*ginResource.Resource).Name())
+		}
+		if id := errs.RequestID(ctx); id != "" {
+			entry = entry.WithField("request_id", id)
+		}
+		ctx.Set(CONTEXT_ENTRY, entry)
+
 		defer func() {
 
 			//  被删除
@@ -143,14 +172,29 @@ func Middleware(c Config) gin.HandlerFunc {
 			// 错误消息
 			logger.ErrorsText = strings.TrimSpace(ctx.Errors.ByType(gin.ErrorTypeAny).String())
 
+			// errs.Wrap 的字段  5xx 加上 stack
+			for _, e := range ctx.Errors {
+				for name, val := range errs.Fields(e.Err) {
+					logger.Fields["error_"+name] = val
+				}
+				if ctx.Writer.Status() >= 500 {
+					if stack := errs.Stack(e.Err); stack != "" {
+						logger.Fields["error_stack"] = stack
+					}
+				}
+			}
+
 			// 错误信息加上 请求头
 			if logger.StatusCode >= 500 {
-				httprequest, _ := httputil.DumpRequest(ctx.Request, false)
+				httprequest, _ := httputil.DumpRequest(logger.anonymize.request(ctx.Request), false)
 				logger.ErrorsText += "\n" + strings.TrimSpace(string(httprequest))
 			}
 
 			logger.Fields["ip"] = logger.IP
 			logger.Fields["latency"] = logger.Latency
+			if id := errs.RequestID(ctx); id != "" {
+				logger.Fields["request_id"] = id
+			}
 
 			if logger.TokenID != "" {
 				logger.Fields["token_id"] = logger.TokenID.Hex()
@@ -214,6 +258,21 @@ func Middleware(c Config) gin.HandlerFunc {
 				}
 			}
 
+			// 访问日志  5xx 仍然输出到 Logger
+			if line := format(c.Format, logger, ctx); line != "" {
+				if logger.Logrus.IsLevelEnabled(logrus.InfoLevel) {
+					writer := c.Writer
+					if writer == nil {
+						writer = logger.Logrus.Out
+					}
+					writer.Write([]byte(line + "\n"))
+				}
+				if logger.StatusCode >= 500 {
+					with.Errorf("%s%s %s %d %s", c.Prefix, logger.ID.Hex(), logger.Method, logger.StatusCode, rawPath)
+				}
+				return
+			}
+
 			if logger.StatusCode >= 500 {
 				with.Errorf("%s%s %s %d %s", c.Prefix, logger.ID.Hex(), logger.Method, logger.StatusCode, rawPath)
 			} else if logger.ErrorsText != "" {
@@ -225,3 +284,30 @@ func Middleware(c Config) gin.HandlerFunc {
 		ctx.Next()
 	}
 }
+
+// FromContext 带 request_id host route ip 的 entry  没有 logger 中间件时使用全局 logger
+func FromContext(ctx *gin.Context) *logrus.Entry {
+	val, ok := ctx.Get(CONTEXT_ENTRY)
+	if !ok || val == nil {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+	entry := val.(*logrus.Entry)
+	// tracing 在 logger 之后设置 request id
+	if id := errs.RequestID(ctx); id != "" && entry.Data["request_id"] != id {
+		entry = entry.WithField("request_id", id)
+		ctx.Set(CONTEXT_ENTRY, entry)
+	}
+	return entry
+}
+
+func format(name string, logger *Logger, ctx *gin.Context) string {
+	switch name {
+	case FormatCommon:
+		return logger.Common(ctx)
+	case FormatCombined:
+		return logger.Combined(ctx)
+	case FormatECS:
+		return logger.ECS(ctx)
+	}
+	return ""
+}
@@ -0,0 +1,259 @@
+package loginguard
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/backpressure"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/errs"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+)
+
+type (
+	Config struct {
+		// 窗口内失败的次数  超过后锁定 Lockout
+		AccountAttempts int64
+		IPAttempts      int64
+		Window          time.Duration
+		Lockout         time.Duration
+
+		// 失败 DelayAfter 次后每次延迟翻倍  最多 MaxDelay
+		DelayAfter int64
+		Delay      time.Duration
+		MaxDelay   time.Duration
+
+		// 失败 CaptchaAfter 次后需要验证码  0 不需要
+		CaptchaAfter int64
+
+		// 检查请求中的验证码
+		Captcha func(ctx *gin.Context) bool
+
+		// 锁定时  例如通知账号所有者
+		OnLockout func(ctx *gin.Context, account string, ip string)
+	}
+
+	Guard struct {
+		config Config
+	}
+
+	// Status 账号和 IP 较大的失败次数
+	Status struct {
+		Failures int64
+		Locked   time.Duration
+		Captcha  bool
+	}
+)
+
+var CONTEXT = "GIN.SERVER.LOGINGUARD"
+
+var PREFIX = "loginguard"
+
+var (
+	ErrNoGuard = errors.New("Login guard: middleware is not used")
+
+	ErrCaptcha = &errs.Error{
+		Message:    "Captcha is required",
+		Type:       "loginguard",
+		StatusCode: http.StatusBadRequest,
+		Params: map[string]interface{}{
+			"captcha": true,
+		},
+	}
+)
+
+func New(c Config) *Guard {
+	if c.AccountAttempts == 0 {
+		c.AccountAttempts = 5
+	}
+	if c.IPAttempts == 0 {
+		c.IPAttempts = 20
+	}
+	if c.Window == 0 {
+		c.Window = time.Minute * 15
+	}
+	if c.Lockout == 0 {
+		c.Lockout = time.Minute * 15
+	}
+	if c.DelayAfter == 0 {
+		c.DelayAfter = 2
+	}
+	if c.Delay == 0 {
+		c.Delay = time.Millisecond * 500
+	}
+	if c.MaxDelay == 0 {
+		c.MaxDelay = time.Second * 10
+	}
+	return &Guard{
+		config: c,
+	}
+}
+
+func Middleware(guard *Guard) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, guard)
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Guard {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Guard)
+	}
+	return nil
+}
+
+// Check 验证密码之前使用
+func Check(ctx *gin.Context, account string) error {
+	guard := Get(ctx)
+	if guard == nil {
+		return ErrNoGuard
+	}
+	return guard.Check(ctx, account)
+}
+
+// Fail 验证失败之后使用
+func Fail(ctx *gin.Context, account string) {
+	if guard := Get(ctx); guard != nil {
+		guard.Fail(ctx, account)
+	}
+}
+
+// Success 验证成功之后使用
+func Success(ctx *gin.Context, account string) {
+	if guard := Get(ctx); guard != nil {
+		guard.Success(ctx, account)
+	}
+}
+
+// Check 锁定时 429  需要验证码时 400  否则按失败次数延迟  没有 redis 中间件时不检查
+func (guard *Guard) Check(ctx *gin.Context, account string) error {
+	status, err := guard.Status(ctx, account)
+	if err != nil || status == nil {
+		return err
+	}
+	if status.Locked > 0 {
+		backpressure.RetryAfter(ctx.Writer.Header(), status.Locked)
+		return backpressure.Error(http.StatusTooManyRequests, "loginguard", status.Locked)
+	}
+	if status.Captcha && (guard.config.Captcha == nil || !guard.config.Captcha(ctx)) {
+		return ErrCaptcha
+	}
+	if delay := guard.delay(status.Failures); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Request.Context().Done():
+			return ctx.Request.Context().Err()
+		}
+	}
+	return nil
+}
+
+// Status 当前的失败次数和锁定的剩余时间
+func (guard *Guard) Status(ctx *gin.Context, account string) (*Status, error) {
+	client := redisClient(ctx)
+	if client == nil {
+		return nil, nil
+	}
+	keys := guard.keys(ctx, account)
+	var counts []*redis.StringCmd
+	var locks []*redis.DurationCmd
+	if _, err := client.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			counts = append(counts, pipe.Get(key+".count"))
+			locks = append(locks, pipe.PTTL(key+".lock"))
+		}
+		return nil
+	}); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	status := &Status{}
+	for i := range keys {
+		if count, _ := counts[i].Int64(); count > status.Failures {
+			status.Failures = count
+		}
+		if ttl := locks[i].Val(); ttl > status.Locked {
+			status.Locked = ttl
+		}
+	}
+	status.Captcha = guard.config.CaptchaAfter > 0 && status.Failures >= guard.config.CaptchaAfter
+	return status, nil
+}
+
+// Fail 账号和 IP 的失败次数加 1  超过时锁定
+func (guard *Guard) Fail(ctx *gin.Context, account string) {
+	client := redisClient(ctx)
+	if client == nil {
+		return
+	}
+	keys := guard.keys(ctx, account)
+	var incrs []*redis.IntCmd
+	client.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			incrs = append(incrs, pipe.Incr(key+".count"))
+			pipe.Expire(key+".count", guard.config.Window)
+		}
+		return nil
+	})
+	limits := []int64{guard.config.IPAttempts, guard.config.AccountAttempts}
+	for i, key := range keys {
+		if incrs[i].Val() < limits[i] {
+			continue
+		}
+		if ok, _ := client.SetNX(key+".lock", "1", guard.config.Lockout).Result(); ok && guard.config.OnLockout != nil {
+			guard.config.OnLockout(ctx, account, clientip.ClientIP(ctx))
+		}
+	}
+}
+
+// Success 清除账号的失败次数  IP 的保留
+func (guard *Guard) Success(ctx *gin.Context, account string) {
+	client := redisClient(ctx)
+	if client == nil || account == "" {
+		return
+	}
+	client.Del(redisMiddleware.Key(ctx, PREFIX+".account."+account+".count"))
+}
+
+// Unlock 管理员解除锁定
+func (guard *Guard) Unlock(ctx *gin.Context, account string) {
+	client := redisClient(ctx)
+	if client == nil {
+		return
+	}
+	key := redisMiddleware.Key(ctx, PREFIX+".account."+account)
+	client.Del(key+".count", key+".lock")
+}
+
+// keys 第一个为 IP  有账号时第二个为账号
+func (guard *Guard) keys(ctx *gin.Context, account string) []string {
+	keys := []string{redisMiddleware.Key(ctx, PREFIX+".ip."+clientip.ClientIP(ctx))}
+	if account != "" {
+		keys = append(keys, redisMiddleware.Key(ctx, PREFIX+".account."+account))
+	}
+	return keys
+}
+
+func (guard *Guard) delay(failures int64) time.Duration {
+	if failures < guard.config.DelayAfter {
+		return 0
+	}
+	delay := guard.config.Delay
+	for i := guard.config.DelayAfter; i < failures && delay < guard.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > guard.config.MaxDelay {
+		delay = guard.config.MaxDelay
+	}
+	return delay
+}
+
+func redisClient(ctx *gin.Context) *redis.Client {
+	if val, ok := ctx.Get(redisMiddleware.CONTEXT); ok && val != nil {
+		return val.(*redis.Client)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"time"
+
+	"github.com/otamoe/gin-server/mail"
+	"github.com/otamoe/gin-server/templates"
+)
+
+type (
+	// Mail SMTP  开启 jobs 时异步发送
+	Mail struct {
+		Host     string `json:"host,omitempty"`
+		Port     int    `json:"port,omitempty"`
+		Username string `json:"username,omitempty"`
+		Password string `json:"password,omitempty"`
+		From     string `json:"from,omitempty"`
+
+		// starttls  tls  none
+		TLS                string `json:"tls,omitempty"`
+		InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+
+		PoolSize    int           `json:"pool_size,omitempty"`
+		IdleTimeout time.Duration `json:"idle_timeout,omitempty"`
+		Timeout     time.Duration `json:"timeout,omitempty"`
+
+		// 邮件模板目录
+		Templates string `json:"templates,omitempty"`
+
+		// 有 jobs 时也直接发送
+		Sync bool `json:"sync,omitempty"`
+
+		mailer *mail.Mailer
+	}
+)
+
+func (config *Mail) init(server *Server, handler *Handler) {
+	if config.mailer != nil {
+		return
+	}
+	c := mail.Config{
+		Host:               config.Host,
+		Port:               config.Port,
+		Username:           config.Username,
+		Password:           config.Password,
+		From:               config.From,
+		TLS:                config.TLS,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		PoolSize:           config.PoolSize,
+		IdleTimeout:        config.IdleTimeout,
+		Timeout:            config.Timeout,
+		Logger:             server.Logger.Get(),
+	}
+	if config.Templates != "" {
+		c.Templates = templates.New(templates.Config{
+			Dir: config.Templates,
+		})
+	}
+	if server.Jobs != nil && !config.Sync {
+		c.Queue = server.Jobs.Get()
+	}
+	config.mailer = mail.New(c)
+}
+
+func (config *Mail) Get() *mail.Mailer {
+	return config.mailer
+}
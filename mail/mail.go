@@ -0,0 +1,452 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/jobs"
+	"github.com/otamoe/gin-server/templates"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	Config struct {
+		Host     string
+		Port     int
+		Username string
+		Password string
+
+		// 默认发件人
+		From string
+
+		// starttls  tls  none  默认 starttls
+		TLS                string
+		InsecureSkipVerify bool
+
+		// 连接池  默认 2
+		PoolSize    int
+		IdleTimeout time.Duration
+		Timeout     time.Duration
+
+		// Message.Template 使用
+		Templates *templates.Templates
+
+		// 设置后 Send 通过 jobs 异步发送
+		Queue      *jobs.Queue
+		MaxRetries int
+
+		Logger *logrus.Logger
+	}
+
+	Message struct {
+		From    string            `json:"from,omitempty"`
+		To      []string          `json:"to"`
+		Cc      []string          `json:"cc,omitempty"`
+		Bcc     []string          `json:"bcc,omitempty"`
+		ReplyTo string            `json:"reply_to,omitempty"`
+		Subject string            `json:"subject"`
+		Text    string            `json:"text,omitempty"`
+		HTML    string            `json:"html,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+
+		// 模板名  渲染为 HTML  TextTemplate 渲染为 Text
+		Template     string      `json:"template,omitempty"`
+		TextTemplate string      `json:"text_template,omitempty"`
+		Data         interface{} `json:"data,omitempty"`
+	}
+
+	Mailer struct {
+		config Config
+
+		mutex   sync.Mutex
+		clients []*client
+		closed  bool
+	}
+
+	client struct {
+		*smtp.Client
+		usedAt time.Time
+	}
+)
+
+var CONTEXT = "GIN.SERVER.MAIL"
+
+// JOB jobs 的名称
+const JOB = "mail.send"
+
+var ErrNoMailer = errors.New("Mail: middleware is not used")
+
+var ErrRecipient = errors.New("Mail: recipient is empty")
+
+var ErrClosed = errors.New("Mail: mailer closed")
+
+func New(c Config) *Mailer {
+	if c.Host == "" {
+		panic("Mail: host is empty")
+	}
+	if c.TLS == "" {
+		c.TLS = "starttls"
+	}
+	if c.Port == 0 {
+		switch c.TLS {
+		case "tls":
+			c.Port = 465
+		default:
+			c.Port = 587
+		}
+	}
+	if c.PoolSize == 0 {
+		c.PoolSize = 2
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = time.Second * 30
+	}
+	if c.Timeout == 0 {
+		c.Timeout = time.Second * 30
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 5
+	}
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	mailer := &Mailer{
+		config: c,
+	}
+	if c.Queue != nil {
+		c.Queue.Register(JOB, func(ctx context.Context, job *jobs.Job) error {
+			msg := &Message{}
+			if err := job.Bind(msg); err != nil {
+				return err
+			}
+			return mailer.SendNow(msg)
+		})
+	}
+	return mailer
+}
+
+func Middleware(mailer *Mailer) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, mailer)
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Mailer {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Mailer)
+	}
+	return nil
+}
+
+// Send 在 handler 中使用
+func Send(ctx *gin.Context, msg *Message) error {
+	mailer := Get(ctx)
+	if mailer == nil {
+		return ErrNoMailer
+	}
+	return mailer.Send(msg)
+}
+
+// Send 有 Queue 时入队  否则直接发送
+func (mailer *Mailer) Send(msg *Message) error {
+	if mailer.config.Queue == nil {
+		return mailer.SendNow(msg)
+	}
+	if len(msg.To)+len(msg.Cc)+len(msg.Bcc) == 0 {
+		return ErrRecipient
+	}
+	_, err := mailer.config.Queue.Enqueue(JOB, msg, jobs.Options{
+		MaxRetries: mailer.config.MaxRetries,
+		Timeout:    mailer.config.Timeout * 2,
+	})
+	return err
+}
+
+// SendNow 同步发送  模板在发送时渲染
+func (mailer *Mailer) SendNow(msg *Message) (err error) {
+	from := msg.From
+	if from == "" {
+		from = mailer.config.From
+	}
+	var recipients []string
+	for _, list := range [][]string{msg.To, msg.Cc, msg.Bcc} {
+		for _, val := range list {
+			address, err := mail.ParseAddress(val)
+			if err != nil {
+				return err
+			}
+			recipients = append(recipients, address.Address)
+		}
+	}
+	if len(recipients) == 0 {
+		return ErrRecipient
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return
+	}
+
+	data, err := mailer.Build(msg)
+	if err != nil {
+		return
+	}
+
+	c, err := mailer.get()
+	if err != nil {
+		return
+	}
+	if err = send(c.Client, sender.Address, recipients, data); err != nil {
+		// 连接可能已不可用  不放回
+		c.Close()
+		mailer.config.Logger.Warnf("[MAIL] %s %s", msg.Subject, err)
+		return
+	}
+	mailer.put(c)
+	return
+}
+
+func send(c *smtp.Client, from string, recipients []string, data []byte) (err error) {
+	if err = c.Mail(from); err != nil {
+		return
+	}
+	for _, val := range recipients {
+		if err = c.Rcpt(val); err != nil {
+			return
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return
+	}
+	if _, err = w.Write(data); err != nil {
+		w.Close()
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	// 下一封邮件
+	return c.Reset()
+}
+
+// Build RFC 5322 内容  Text HTML 都有时为 multipart/alternative
+func (mailer *Mailer) Build(msg *Message) (data []byte, err error) {
+	text, content := msg.Text, msg.HTML
+	if msg.Template != "" || msg.TextTemplate != "" {
+		if mailer.config.Templates == nil {
+			return nil, errors.New("Mail: templates is empty")
+		}
+	}
+	if msg.Template != "" {
+		var buf bytes.Buffer
+		if err = mailer.config.Templates.Render(&buf, msg.Template, "", msg.Data); err != nil {
+			return
+		}
+		content = buf.String()
+	}
+	if msg.TextTemplate != "" {
+		var buf bytes.Buffer
+		if err = mailer.config.Templates.Render(&buf, msg.TextTemplate, "", msg.Data); err != nil {
+			return
+		}
+		// html/template 会转义
+		text = html.UnescapeString(buf.String())
+	}
+
+	from := msg.From
+	if from == "" {
+		from = mailer.config.From
+	}
+
+	var buf bytes.Buffer
+	header := textproto.MIMEHeader{}
+	header.Set("From", from)
+	header.Set("To", strings.Join(msg.To, ", "))
+	if len(msg.Cc) != 0 {
+		header.Set("Cc", strings.Join(msg.Cc, ", "))
+	}
+	if msg.ReplyTo != "" {
+		header.Set("Reply-To", msg.ReplyTo)
+	}
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("Message-Id", "<"+messageID()+"@"+mailer.config.Host+">")
+	header.Set("MIME-Version", "1.0")
+	for key, val := range msg.Headers {
+		header.Set(key, val)
+	}
+
+	switch {
+	case text != "" && content != "":
+		writer := multipart.NewWriter(&buf)
+		header.Set("Content-Type", "multipart/alternative; boundary="+writer.Boundary())
+		writeHeader(&buf, header)
+		for _, part := range []struct {
+			typ  string
+			body string
+		}{{"text/plain", text}, {"text/html", content}} {
+			var w io.Writer
+			if w, err = writer.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.typ + "; charset=utf-8"},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			}); err != nil {
+				return
+			}
+			if err = writeQuoted(w, part.body); err != nil {
+				return
+			}
+		}
+		if err = writer.Close(); err != nil {
+			return
+		}
+	default:
+		typ, body := "text/plain", text
+		if content != "" {
+			typ, body = "text/html", content
+		}
+		header.Set("Content-Type", typ+"; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		writeHeader(&buf, header)
+		if err = writeQuoted(&buf, body); err != nil {
+			return
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	var keys []string
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, val := range header[key] {
+			buf.WriteString(key + ": " + val + "\r\n")
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+func writeQuoted(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func messageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// get 复用空闲的连接  超过 IdleTimeout 的关闭
+func (mailer *Mailer) get() (*client, error) {
+	mailer.mutex.Lock()
+	if mailer.closed {
+		mailer.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	for len(mailer.clients) != 0 {
+		c := mailer.clients[len(mailer.clients)-1]
+		mailer.clients = mailer.clients[:len(mailer.clients)-1]
+		if time.Since(c.usedAt) > mailer.config.IdleTimeout {
+			c.Close()
+			continue
+		}
+		mailer.mutex.Unlock()
+		if err := c.Noop(); err != nil {
+			c.Close()
+			mailer.mutex.Lock()
+			continue
+		}
+		return c, nil
+	}
+	mailer.mutex.Unlock()
+	return mailer.dial()
+}
+
+func (mailer *Mailer) put(c *client) {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	if mailer.closed || len(mailer.clients) >= mailer.config.PoolSize {
+		c.Quit()
+		return
+	}
+	c.usedAt = time.Now()
+	mailer.clients = append(mailer.clients, c)
+}
+
+func (mailer *Mailer) dial() (*client, error) {
+	addr := net.JoinHostPort(mailer.config.Host, strconv.Itoa(mailer.config.Port))
+	tlsConfig := &tls.Config{
+		ServerName:         mailer.config.Host,
+		InsecureSkipVerify: mailer.config.InsecureSkipVerify,
+	}
+	dialer := &net.Dialer{Timeout: mailer.config.Timeout}
+
+	var conn net.Conn
+	var err error
+	if mailer.config.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, err := smtp.NewClient(conn, mailer.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if mailer.config.TLS == "starttls" {
+		if err = c.StartTLS(tlsConfig); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if mailer.config.Username != "" {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err = c.Auth(smtp.PlainAuth("", mailer.config.Username, mailer.config.Password, mailer.config.Host)); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+	}
+	return &client{Client: c, usedAt: time.Now()}, nil
+}
+
+// Close 关闭空闲的连接
+func (mailer *Mailer) Close() error {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	mailer.closed = true
+	for _, c := range mailer.clients {
+		c.Quit()
+	}
+	mailer.clients = nil
+	return nil
+}
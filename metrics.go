@@ -0,0 +1,96 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/metrics"
+	"github.com/otamoe/gin-server/useragent"
+)
+
+type (
+	Metrics struct {
+		// 为空 不输出 prometheus
+		Path    string    `json:"path,omitempty"`
+		Buckets []float64 `json:"buckets,omitempty"`
+
+		// 爬虫不计入
+		ExcludeBots bool `json:"exclude_bots,omitempty"`
+
+		// 推送到 dogstatsd  只在 server 上生效
+		StatsD *StatsD `json:"statsd,omitempty"`
+
+		// mongo redis 连接池采样间隔  只在 server 上生效
+		PoolInterval time.Duration `json:"pool_interval,omitempty"`
+
+		pools *metrics.Pools
+	}
+
+	StatsD struct {
+		Addr          string        `json:"addr,omitempty"`
+		Prefix        string        `json:"prefix,omitempty"`
+		Tags          []string      `json:"tags,omitempty"`
+		FlushInterval time.Duration `json:"flush_interval,omitempty"`
+
+		sink *metrics.StatsD
+	}
+)
+
+func (config *Metrics) init(server *Server, handler *Handler) {
+	if handler == nil && config.StatsD != nil && config.StatsD.sink == nil && !server.testing() {
+		tags := append([]string{"service:" + server.Name, "env:" + server.ENV}, config.StatsD.Tags...)
+		sink, err := metrics.NewStatsD(metrics.StatsDConfig{
+			Addr:          config.StatsD.Addr,
+			Prefix:        config.StatsD.Prefix,
+			Tags:          tags,
+			FlushInterval: config.StatsD.FlushInterval,
+		})
+		if err != nil {
+			panic(err)
+		}
+		config.StatsD.sink = sink
+		metrics.Default.AddSink(sink)
+	}
+}
+
+func (config *Metrics) skip(ctx *gin.Context) bool {
+	if config.Path != "" && ctx.Request.URL.Path == config.Path {
+		return true
+	}
+	if config.ExcludeBots {
+		return useragent.Parse(ctx.Request.UserAgent()).Bot
+	}
+	return false
+}
+
+// startPools server 和 handler 自己的 Redis Mongo  按名字区分
+func (config *Metrics) startPools(server *Server) {
+	config.pools = &metrics.Pools{
+		Registry: metrics.Default,
+		Interval: config.PoolInterval,
+	}
+	if server.RedisProvider == nil && server.Redis != nil {
+		config.pools.AddRedis("default", server.Redis.Client())
+	}
+	if server.mongoProvider() != nil {
+		config.pools.AddMongo("default")
+	}
+	for _, handler := range server.Handlers {
+		if handler.Redis != nil && handler.Redis != server.Redis {
+			config.pools.AddRedis(handler.Name, handler.Redis.Client())
+		}
+		if handler.Mongo != nil && handler.Mongo != server.Mongo {
+			config.pools.AddMongo(handler.Name)
+		}
+	}
+	config.pools.Start()
+}
+
+func (config *Metrics) close() {
+	if config.pools != nil {
+		config.pools.Stop()
+	}
+	if config.StatsD != nil && config.StatsD.sink != nil {
+		config.StatsD.sink.Close()
+	}
+}
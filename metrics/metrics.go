@@ -0,0 +1,365 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	Registry struct {
+		mutex      sync.RWMutex
+		collectors map[string]collector
+		names      []string
+		sinks      []Sink
+	}
+
+	// Sink 推送型后端  例如 statsd
+	Sink interface {
+		Count(name string, delta float64, tags []string)
+		Gauge(name string, value float64, tags []string)
+		Observe(name string, value float64, tags []string)
+	}
+
+	collector interface {
+		write(w *bufio.Writer)
+	}
+
+	Counter struct {
+		vec
+	}
+
+	Gauge struct {
+		vec
+		fn func() float64
+	}
+
+	Histogram struct {
+		registry *Registry
+		name     string
+		help     string
+		labels   []string
+		buckets  []float64
+
+		mutex  sync.RWMutex
+		series map[string]*histogramSeries
+	}
+
+	histogramSeries struct {
+		values []string
+		counts []uint64
+		count  uint64
+		sum    float64
+	}
+
+	vec struct {
+		registry *Registry
+		name     string
+		help     string
+		typ      string
+		labels   []string
+
+		mutex  sync.RWMutex
+		series map[string]*series
+	}
+
+	series struct {
+		values []string
+		value  float64
+	}
+)
+
+var Default = NewRegistry()
+
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: map[string]collector{},
+	}
+}
+
+// Counter 同名返回已有的
+func (registry *Registry) Counter(name string, help string, labels ...string) *Counter {
+	return registry.register(name, func() collector {
+		return &Counter{vec: registry.newVec(name, help, "counter", labels)}
+	}).(*Counter)
+}
+
+func (registry *Registry) Gauge(name string, help string, labels ...string) *Gauge {
+	return registry.register(name, func() collector {
+		return &Gauge{vec: registry.newVec(name, help, "gauge", labels)}
+	}).(*Gauge)
+}
+
+// GaugeFunc 输出时调用 fn
+func (registry *Registry) GaugeFunc(name string, help string, fn func() float64) *Gauge {
+	return registry.register(name, func() collector {
+		return &Gauge{vec: registry.newVec(name, help, "gauge", nil), fn: fn}
+	}).(*Gauge)
+}
+
+func (registry *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	return registry.register(name, func() collector {
+		return &Histogram{
+			registry: registry,
+			name:     name,
+			help:     help,
+			labels:   labels,
+			buckets:  buckets,
+			series:   map[string]*histogramSeries{},
+		}
+	}).(*Histogram)
+}
+
+// Write prometheus text 格式
+func (registry *Registry) Write(w io.Writer) error {
+	registry.mutex.RLock()
+	names := append([]string(nil), registry.names...)
+	collectors := make([]collector, 0, len(names))
+	for _, name := range names {
+		collectors = append(collectors, registry.collectors[name])
+	}
+	registry.mutex.RUnlock()
+
+	writer := bufio.NewWriter(w)
+	for _, val := range collectors {
+		val.write(writer)
+	}
+	return writer.Flush()
+}
+
+// AddSink 之后的值同时推送到 sink
+func (registry *Registry) AddSink(sink Sink) {
+	registry.mutex.Lock()
+	registry.sinks = append(registry.sinks, sink)
+	registry.mutex.Unlock()
+}
+
+func (registry *Registry) getSinks() []Sink {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	return registry.sinks
+}
+
+func (registry *Registry) register(name string, create func() collector) collector {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if val, ok := registry.collectors[name]; ok {
+		return val
+	}
+	val := create()
+	registry.collectors[name] = val
+	registry.names = append(registry.names, name)
+	sort.Strings(registry.names)
+	return val
+}
+
+func (counter *Counter) Inc(values ...string) {
+	counter.add(1, values)
+}
+
+func (counter *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	counter.add(delta, values)
+}
+
+func (counter *Counter) add(delta float64, values []string) {
+	counter.vec.add(delta, values)
+	for _, sink := range counter.registry.getSinks() {
+		sink.Count(counter.name, delta, Tags(counter.labels, values))
+	}
+}
+
+func (gauge *Gauge) Set(value float64, values ...string) {
+	gauge.set(value, values)
+	gauge.emit(values)
+}
+
+func (gauge *Gauge) Add(delta float64, values ...string) {
+	gauge.add(delta, values)
+	gauge.emit(values)
+}
+
+func (gauge *Gauge) emit(values []string) {
+	sinks := gauge.registry.getSinks()
+	if len(sinks) == 0 {
+		return
+	}
+	gauge.mutex.Lock()
+	value := gauge.get(values).value
+	gauge.mutex.Unlock()
+	for _, sink := range sinks {
+		sink.Gauge(gauge.name, value, Tags(gauge.labels, values))
+	}
+}
+
+func (gauge *Gauge) write(w *bufio.Writer) {
+	if gauge.fn != nil {
+		gauge.set(gauge.fn(), nil)
+	}
+	gauge.vec.write(w)
+}
+
+func (histogram *Histogram) Observe(value float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	histogram.mutex.Lock()
+	val, ok := histogram.series[key]
+	if !ok {
+		val = &histogramSeries{
+			values: append([]string(nil), values...),
+			counts: make([]uint64, len(histogram.buckets)),
+		}
+		histogram.series[key] = val
+	}
+	for i, bucket := range histogram.buckets {
+		if value <= bucket {
+			val.counts[i]++
+		}
+	}
+	val.count++
+	val.sum += value
+	histogram.mutex.Unlock()
+
+	for _, sink := range histogram.registry.getSinks() {
+		sink.Observe(histogram.name, value, Tags(histogram.labels, values))
+	}
+}
+
+func (histogram *Histogram) write(w *bufio.Writer) {
+	writeHeader(w, histogram.name, histogram.help, "histogram")
+	histogram.mutex.RLock()
+	defer histogram.mutex.RUnlock()
+	for _, key := range sortedKeys(histogram.series) {
+		val := histogram.series[key]
+		for i, bucket := range histogram.buckets {
+			writeSample(w, histogram.name+"_bucket", append(histogram.labels, "le"), append(val.values, formatFloat(bucket)), float64(val.counts[i]))
+		}
+		writeSample(w, histogram.name+"_bucket", append(histogram.labels, "le"), append(val.values, "+Inf"), float64(val.count))
+		writeSample(w, histogram.name+"_sum", histogram.labels, val.values, val.sum)
+		writeSample(w, histogram.name+"_count", histogram.labels, val.values, float64(val.count))
+	}
+}
+
+// Tags label:value 格式
+func Tags(labels []string, values []string) (tags []string) {
+	for i, label := range labels {
+		var val string
+		if i < len(values) {
+			val = values[i]
+		}
+		tags = append(tags, label+":"+val)
+	}
+	return
+}
+
+func (registry *Registry) newVec(name, help, typ string, labels []string) vec {
+	return vec{
+		registry: registry,
+		name:     name,
+		help:     help,
+		typ:      typ,
+		labels:   labels,
+		series:   map[string]*series{},
+	}
+}
+
+func (v *vec) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	val, ok := v.series[key]
+	if !ok {
+		val = &series{values: append([]string(nil), values...)}
+		v.series[key] = val
+	}
+	return val
+}
+
+func (v *vec) add(delta float64, values []string) {
+	v.mutex.Lock()
+	v.get(values).value += delta
+	v.mutex.Unlock()
+}
+
+func (v *vec) set(value float64, values []string) {
+	v.mutex.Lock()
+	v.get(values).value = value
+	v.mutex.Unlock()
+}
+
+// Each 遍历当前的值  用于 statsd 等
+func (v *vec) Each(fn func(values []string, value float64)) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	for _, key := range sortedKeys(v.series) {
+		fn(v.series[key].values, v.series[key].value)
+	}
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	writeHeader(w, v.name, v.help, v.typ)
+	v.Each(func(values []string, value float64) {
+		writeSample(w, v.name, v.labels, values, value)
+	})
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	if help != "" {
+		w.WriteString("# HELP " + name + " " + strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(help) + "\n")
+	}
+	w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+func writeSample(w *bufio.Writer, name string, labels []string, values []string, value float64) {
+	w.WriteString(name)
+	if len(labels) != 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i != 0 {
+				w.WriteByte(',')
+			}
+			var val string
+			if i < len(values) {
+				val = values[i]
+			}
+			w.WriteString(label + "=\"" + strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"").Replace(val) + "\"")
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(m interface{}) (keys []string) {
+	switch m := m.(type) {
+	case map[string]*series:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*histogramSeries:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	Config struct {
+		Registry *Registry
+		Buckets  []float64
+
+		// 不记录 例如爬虫 健康检查
+		Skip func(ctx *gin.Context) bool
+	}
+)
+
+// Middleware RED 指标  按 resource type action 区分
+func Middleware(c Config) gin.HandlerFunc {
+	if c.Registry == nil {
+		c.Registry = Default
+	}
+	requests := c.Registry.Counter("http_requests_total", "Total HTTP requests.", "type", "action", "method", "code")
+	errors := c.Registry.Counter("http_request_errors_total", "Total HTTP requests with 5xx status.", "type", "action")
+	duration := c.Registry.Histogram("http_request_duration_seconds", "HTTP request latency.", c.Buckets, "type", "action")
+	inflight := c.Registry.Gauge("http_requests_in_flight", "HTTP requests being served.")
+
+	return func(ctx *gin.Context) {
+		if c.Skip != nil && c.Skip(ctx) {
+			ctx.Next()
+			return
+		}
+		start := time.Now()
+		inflight.Add(1)
+		defer inflight.Add(-1)
+
+		ctx.Next()
+
+		typ, action := Labels(ctx)
+		status := ctx.Writer.Status()
+		requests.Inc(typ, action, ctx.Request.Method, strconv.Itoa(status))
+		if status >= http.StatusInternalServerError {
+			errors.Inc(typ, action)
+		}
+		duration.Observe(time.Since(start).Seconds(), typ, action)
+	}
+}
+
+// Handler prometheus 格式输出
+func Handler(registry *Registry) gin.HandlerFunc {
+	if registry == nil {
+		registry = Default
+	}
+	return func(ctx *gin.Context) {
+		ctx.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		ctx.Status(http.StatusOK)
+		registry.Write(ctx.Writer)
+	}
+}
+
+// Labels 未匹配路由统一为 notfound  避免 label 过多
+func Labels(ctx *gin.Context) (typ string, action string) {
+	if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+		resource := val.(*ginResource.Resource)
+		resource.Pre()
+		typ, action = resource.Type, resource.Action
+	}
+	if typ == "" {
+		if ctx.Writer.Status() == http.StatusNotFound {
+			return "notfound", ""
+		}
+		typ = "unknown"
+	}
+	return
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/go-redis/redis"
+)
+
+type (
+	// Pools 定时采样 mongo redis 连接池  按名字区分
+	Pools struct {
+		Registry *Registry
+
+		// 默认 10s
+		Interval time.Duration
+
+		mutex  sync.Mutex
+		redis  map[string]*redis.Client
+		mongo  []string
+		once   sync.Once
+		close  sync.Once
+		stop   chan struct{}
+		gauges *poolGauges
+	}
+
+	poolGauges struct {
+		connections *Gauge
+		// go-redis 没有等待次数和时间  只有 timeouts
+		waits       *Gauge
+		waitSeconds *Gauge
+		timeouts    *Gauge
+	}
+)
+
+// AddRedis client 需要是共用的  每个请求新建的 client 没有意义
+func (pools *Pools) AddRedis(name string, client *redis.Client) {
+	pools.mutex.Lock()
+	defer pools.mutex.Unlock()
+	if pools.redis == nil {
+		pools.redis = map[string]*redis.Client{}
+	}
+	pools.redis[name] = client
+}
+
+// AddMongo mgo 只有全局统计  多个连接时数值相同
+func (pools *Pools) AddMongo(name string) {
+	mgo.SetStats(true)
+	pools.mutex.Lock()
+	defer pools.mutex.Unlock()
+	for _, val := range pools.mongo {
+		if val == name {
+			return
+		}
+	}
+	pools.mongo = append(pools.mongo, name)
+}
+
+// Start 多次调用只启动一次
+func (pools *Pools) Start() {
+	pools.once.Do(func() {
+		if pools.Registry == nil {
+			pools.Registry = Default
+		}
+		if pools.Interval == 0 {
+			pools.Interval = time.Second * 10
+		}
+		pools.gauges = &poolGauges{
+			connections: pools.Registry.Gauge("db_pool_connections", "Pool connections by state.", "db", "name", "state"),
+			waits:       pools.Registry.Gauge("db_pool_waits", "Times waited for a pool connection.", "db", "name"),
+			waitSeconds: pools.Registry.Gauge("db_pool_wait_seconds", "Total time waited for a pool connection.", "db", "name"),
+			timeouts:    pools.Registry.Gauge("db_pool_timeouts", "Pool timeouts.", "db", "name"),
+		}
+		pools.stop = make(chan struct{})
+		go pools.run()
+	})
+}
+
+func (pools *Pools) Stop() {
+	pools.close.Do(func() {
+		if pools.stop != nil {
+			close(pools.stop)
+		}
+	})
+}
+
+func (pools *Pools) run() {
+	ticker := time.NewTicker(pools.Interval)
+	defer ticker.Stop()
+	for {
+		pools.Sample()
+		select {
+		case <-pools.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sample 立即采样一次
+func (pools *Pools) Sample() {
+	if pools.gauges == nil {
+		return
+	}
+	gauges := pools.gauges
+	pools.mutex.Lock()
+	defer pools.mutex.Unlock()
+	for name, client := range pools.redis {
+		stats := client.PoolStats()
+		if stats == nil {
+			continue
+		}
+		gauges.connections.Set(float64(stats.TotalConns-stats.IdleConns), "redis", name, "in_use")
+		gauges.connections.Set(float64(stats.IdleConns), "redis", name, "idle")
+		gauges.connections.Set(float64(stats.StaleConns), "redis", name, "stale")
+		gauges.timeouts.Set(float64(stats.Timeouts), "redis", name)
+	}
+	if len(pools.mongo) != 0 {
+		stats := mgo.GetStats()
+		for _, name := range pools.mongo {
+			gauges.connections.Set(float64(stats.SocketsInUse), "mongo", name, "in_use")
+			gauges.connections.Set(float64(stats.SocketsAlive-stats.SocketsInUse), "mongo", name, "idle")
+			gauges.waits.Set(float64(stats.TimesWaitedForPool), "mongo", name)
+			gauges.waitSeconds.Set(stats.TotalPoolWaitTime.Seconds(), "mongo", name)
+			gauges.timeouts.Set(float64(stats.PoolTimeouts), "mongo", name)
+		}
+	}
+}
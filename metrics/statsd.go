@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	StatsDConfig struct {
+		Addr   string
+		Prefix string
+
+		// 所有指标都附带  例如 service:name env:production
+		Tags []string
+
+		FlushInterval time.Duration
+		MaxPacketSize int
+	}
+
+	// StatsD dogstatsd 协议  udp 发送  按包缓冲
+	StatsD struct {
+		config StatsDConfig
+		conn   net.Conn
+
+		mutex  sync.Mutex
+		buffer bytes.Buffer
+
+		closed chan struct{}
+		done   chan struct{}
+	}
+)
+
+func NewStatsD(c StatsDConfig) (*StatsD, error) {
+	if c.Addr == "" {
+		c.Addr = "127.0.0.1:8125"
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = time.Second
+	}
+	if c.MaxPacketSize == 0 {
+		c.MaxPacketSize = 1432
+	}
+	if c.Prefix != "" && !strings.HasSuffix(c.Prefix, ".") {
+		c.Prefix += "."
+	}
+	conn, err := net.Dial("udp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	statsd := &StatsD{
+		config: c,
+		conn:   conn,
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go statsd.run()
+	return statsd, nil
+}
+
+func (statsd *StatsD) Count(name string, delta float64, tags []string) {
+	statsd.write(name, delta, "c", tags)
+}
+
+func (statsd *StatsD) Gauge(name string, value float64, tags []string) {
+	statsd.write(name, value, "g", tags)
+}
+
+func (statsd *StatsD) Observe(name string, value float64, tags []string) {
+	statsd.write(name, value, "h", tags)
+}
+
+// Close 发送剩余数据
+func (statsd *StatsD) Close() error {
+	select {
+	case <-statsd.closed:
+		return nil
+	default:
+	}
+	close(statsd.closed)
+	<-statsd.done
+	statsd.Flush()
+	return statsd.conn.Close()
+}
+
+func (statsd *StatsD) Flush() {
+	statsd.mutex.Lock()
+	defer statsd.mutex.Unlock()
+	statsd.flush()
+}
+
+func (statsd *StatsD) run() {
+	defer close(statsd.done)
+	ticker := time.NewTicker(statsd.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-statsd.closed:
+			return
+		case <-ticker.C:
+			statsd.Flush()
+		}
+	}
+}
+
+func (statsd *StatsD) write(name string, value float64, typ string, tags []string) {
+	line := statsd.config.Prefix + name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + typ
+	if len(tags) != 0 || len(statsd.config.Tags) != 0 {
+		line += "|#" + strings.Join(append(append([]string(nil), statsd.config.Tags...), tags...), ",")
+	}
+
+	statsd.mutex.Lock()
+	defer statsd.mutex.Unlock()
+	if statsd.buffer.Len() != 0 && statsd.buffer.Len()+1+len(line) > statsd.config.MaxPacketSize {
+		statsd.flush()
+	}
+	if statsd.buffer.Len() != 0 {
+		statsd.buffer.WriteByte('\n')
+	}
+	statsd.buffer.WriteString(line)
+}
+
+func (statsd *StatsD) flush() {
+	if statsd.buffer.Len() == 0 {
+		return
+	}
+	// udp 丢弃错误
+	statsd.conn.Write(statsd.buffer.Bytes())
+	statsd.buffer.Reset()
+}
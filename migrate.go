@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/otamoe/gin-server/migrate"
+)
+
+type (
+	// Migrate migrate.Register 注册的数据变更  需要 mongo
+	Migrate struct {
+		// 启动时执行全部
+		Auto bool `json:"auto,omitempty"`
+
+		Collection string        `json:"collection,omitempty"`
+		LockTTL    time.Duration `json:"lock_ttl,omitempty"`
+		Timeout    time.Duration `json:"timeout,omitempty"`
+		DryRun     bool          `json:"dry_run,omitempty"`
+	}
+)
+
+func (config *Migrate) init(server *Server, handler *Handler) {
+	if config.Collection == "" {
+		config.Collection = "migrations"
+	}
+	if config.LockTTL == 0 {
+		config.LockTTL = time.Minute * 10
+	}
+	if config.Timeout == 0 {
+		config.Timeout = time.Minute * 10
+	}
+}
+
+// migrate session client 使用后关闭
+func (server *Server) migrate(ctx context.Context, dryRun bool, run func(ctx context.Context, c migrate.Config) ([]*migrate.Migration, error)) ([]*migrate.Migration, error) {
+	provider := server.mongoProvider()
+	if provider == nil {
+		return nil, errors.New("Migrate: mongo is empty")
+	}
+	config := server.Migrate
+	if config == nil {
+		config = &Migrate{}
+		config.init(server, nil)
+	}
+	c := migrate.Config{
+		Collection: server.mongoPrefix() + config.Collection,
+		LockKey:    server.redisPrefix() + "migrate.lock",
+		LockTTL:    config.LockTTL,
+		DryRun:     config.DryRun || dryRun,
+	}
+	c.Logger = server.Logger.Get()
+	c.CollectionPrefix = server.mongoPrefix()
+	session, err := server.mongoSession()
+	if err != nil {
+		return nil, err
+	}
+	c.Mongo = session
+	defer c.Mongo.Close()
+	if provider := server.redisProvider(); provider != nil {
+		c.Redis = provider.Get()
+		defer c.Redis.Close()
+	}
+	return run(ctx, c)
+}
+
+// MigrateUp target 为 0 时执行全部
+func (server *Server) MigrateUp(ctx context.Context, target int64, dryRun bool) ([]*migrate.Migration, error) {
+	return server.migrate(ctx, dryRun, func(ctx context.Context, c migrate.Config) ([]*migrate.Migration, error) {
+		return migrate.Up(ctx, c, target)
+	})
+}
+
+// MigrateDown 回滚大于 target 的版本
+func (server *Server) MigrateDown(ctx context.Context, target int64, dryRun bool) ([]*migrate.Migration, error) {
+	return server.migrate(ctx, dryRun, func(ctx context.Context, c migrate.Config) ([]*migrate.Migration, error) {
+		return migrate.Down(ctx, c, target)
+	})
+}
+
+// MigrateStatus 已执行的 和未执行的
+func (server *Server) MigrateStatus() (applied []*migrate.Record, pending []*migrate.Migration, err error) {
+	_, err = server.migrate(context.Background(), true, func(ctx context.Context, c migrate.Config) ([]*migrate.Migration, error) {
+		applied, pending, err = migrate.Status(c)
+		return nil, err
+	})
+	return
+}
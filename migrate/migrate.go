@@ -0,0 +1,284 @@
+package migrate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// Migration 和索引无关的数据变更  Version 递增  例如 20190601120000
+	Migration struct {
+		Version int64
+		Name    string
+		Up      func(ctx context.Context, env *Env) error
+		// 为空时不能回滚
+		Down func(ctx context.Context, env *Env) error
+	}
+
+	Env struct {
+		Mongo  *mgo.Session
+		Redis  *redis.Client
+		Logger *logrus.Logger
+
+		// 集合前缀
+		CollectionPrefix string
+	}
+
+	Config struct {
+		Env
+
+		// 已执行的版本  默认 migrations
+		Collection string
+
+		// 有 Redis 时使用 redis 锁  否则使用 mongo 的 <Collection>_lock
+		LockKey string
+		LockTTL time.Duration
+
+		// 只输出将要执行的
+		DryRun bool
+	}
+
+	Record struct {
+		Version   int64     `json:"version" bson:"_id"`
+		Name      string    `json:"name" bson:"name"`
+		AppliedAt time.Time `json:"applied_at" bson:"applied_at"`
+	}
+
+	lock struct {
+		config Config
+		token  string
+	}
+)
+
+var (
+	mutex    sync.Mutex
+	registry = map[int64]*Migration{}
+)
+
+var ErrLocked = errors.New("Migrate: locked by another instance")
+
+func Register(migration *Migration) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if migration.Up == nil {
+		panic("Migrate: " + migration.Name + " up is empty")
+	}
+	if val, ok := registry[migration.Version]; ok {
+		panic(fmt.Sprintf("Migrate: version %d has exists %s", migration.Version, val.Name))
+	}
+	registry[migration.Version] = migration
+}
+
+// Migrations 按版本排序
+func Migrations() (migrations []*Migration) {
+	mutex.Lock()
+	for _, val := range registry {
+		migrations = append(migrations, val)
+	}
+	mutex.Unlock()
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return
+}
+
+func (c *Config) init() {
+	if c.Collection == "" {
+		c.Collection = "migrations"
+	}
+	if c.LockKey == "" {
+		c.LockKey = "migrate.lock"
+	}
+	if c.LockTTL == 0 {
+		c.LockTTL = time.Minute * 10
+	}
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+}
+
+// Status 已执行的 和未执行的
+func Status(c Config) (applied []*Record, pending []*Migration, err error) {
+	c.init()
+	if err = c.Mongo.DB("").C(c.Collection).Find(nil).Sort("_id").All(&applied); err != nil {
+		return
+	}
+	versions := map[int64]bool{}
+	for _, record := range applied {
+		versions[record.Version] = true
+	}
+	for _, migration := range Migrations() {
+		if !versions[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return
+}
+
+// Up 执行到 target  0 为全部  其他实例在执行时等待
+func Up(ctx context.Context, c Config, target int64) (done []*Migration, err error) {
+	c.init()
+	var l *lock
+	if l, err = acquire(ctx, c); err != nil {
+		return
+	}
+	defer l.release()
+
+	var pending []*Migration
+	if _, pending, err = Status(c); err != nil {
+		return
+	}
+	collection := c.Mongo.DB("").C(c.Collection)
+	for _, migration := range pending {
+		if target != 0 && migration.Version > target {
+			break
+		}
+		if c.DryRun {
+			c.Logger.Infof("[MIGRATE] dry-run up %d %s", migration.Version, migration.Name)
+			done = append(done, migration)
+			continue
+		}
+		start := time.Now()
+		if err = migration.Up(ctx, &c.Env); err != nil {
+			err = fmt.Errorf("Migrate: up %d %s: %s", migration.Version, migration.Name, err)
+			return
+		}
+		if err = collection.Insert(&Record{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now(),
+		}); err != nil {
+			return
+		}
+		c.Logger.Infof("[MIGRATE] up %d %s %s", migration.Version, migration.Name, time.Since(start))
+		done = append(done, migration)
+	}
+	return
+}
+
+// Down 回滚大于 target 的版本  从新到旧
+func Down(ctx context.Context, c Config, target int64) (done []*Migration, err error) {
+	c.init()
+	var l *lock
+	if l, err = acquire(ctx, c); err != nil {
+		return
+	}
+	defer l.release()
+
+	var applied []*Record
+	if applied, _, err = Status(c); err != nil {
+		return
+	}
+	collection := c.Mongo.DB("").C(c.Collection)
+	for i := len(applied) - 1; i >= 0; i-- {
+		record := applied[i]
+		if record.Version <= target {
+			break
+		}
+		mutex.Lock()
+		migration, ok := registry[record.Version]
+		mutex.Unlock()
+		if !ok || migration.Down == nil {
+			err = fmt.Errorf("Migrate: %d %s can not be rolled back", record.Version, record.Name)
+			return
+		}
+		if c.DryRun {
+			c.Logger.Infof("[MIGRATE] dry-run down %d %s", migration.Version, migration.Name)
+			done = append(done, migration)
+			continue
+		}
+		start := time.Now()
+		if err = migration.Down(ctx, &c.Env); err != nil {
+			err = fmt.Errorf("Migrate: down %d %s: %s", migration.Version, migration.Name, err)
+			return
+		}
+		if err = collection.RemoveId(record.Version); err != nil {
+			return
+		}
+		c.Logger.Infof("[MIGRATE] down %d %s %s", migration.Version, migration.Name, time.Since(start))
+		done = append(done, migration)
+	}
+	return
+}
+
+// acquire 锁被占用时每秒重试  直到 ctx 结束
+func acquire(ctx context.Context, c Config) (l *lock, err error) {
+	token := make([]byte, 16)
+	if _, err = rand.Read(token); err != nil {
+		return
+	}
+	l = &lock{
+		config: c,
+		token:  hex.EncodeToString(token),
+	}
+	for {
+		var ok bool
+		if ok, err = l.try(); err != nil || ok {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ErrLocked
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (l *lock) try() (bool, error) {
+	c := l.config
+	if c.Redis != nil {
+		return c.Redis.SetNX(c.LockKey, l.token, c.LockTTL).Result()
+	}
+
+	collection := c.Mongo.DB("").C(c.Collection + "_lock")
+	now := time.Now()
+	err := collection.Insert(bson.M{
+		"_id":        c.LockKey,
+		"token":      l.token,
+		"expires_at": now.Add(c.LockTTL),
+	})
+	if err == nil {
+		return true, nil
+	}
+	if !mgo.IsDup(err) {
+		return false, err
+	}
+	// 过期的锁  例如实例崩溃
+	err = collection.Update(bson.M{
+		"_id":        c.LockKey,
+		"expires_at": bson.M{"$lt": now},
+	}, bson.M{
+		"$set": bson.M{
+			"token":      l.token,
+			"expires_at": now.Add(c.LockTTL),
+		},
+	})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// release 只释放自己的锁
+func (l *lock) release() {
+	c := l.config
+	if c.Redis != nil {
+		c.Redis.Eval(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`, []string{c.LockKey}, l.token)
+		return
+	}
+	c.Mongo.DB("").C(c.Collection + "_lock").Remove(bson.M{
+		"_id":   c.LockKey,
+		"token": l.token,
+	})
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// Module 第三方包接入配置 中间件 启动和关闭  不需要修改 server 包
+	Module interface {
+		Name() string
+
+		// raw 为配置中的 modules.<name>  没有时为 nil  Init 时调用
+		Configure(raw json.RawMessage) error
+
+		// 每个 handler 添加  在 jwt sessions acl 之后  nil 不添加
+		Middleware() gin.HandlerFunc
+
+		// Start 开始监听之前调用  Stop 关闭时按注册的相反顺序调用
+		Start(ctx context.Context) error
+		Stop(ctx context.Context) error
+	}
+
+	// ModuleNeeds 可选  Configure 之前执行的 InitStep  例如 redis mongo metrics  默认 logger
+	ModuleNeeds interface {
+		Needs() []string
+	}
+
+	// ModuleHealth 可选  注册为 readyz 的关键检查 module.<name>
+	ModuleHealth interface {
+		Health(ctx context.Context) error
+	}
+)
+
+// Register Init 之前注册  Configure 作为 InitStep module.<name> 执行
+// 和内置的 compress logger size notfound redis mongo 同名时代替中间件  使用内置的 InitStep 和中间件的位置
+// server.Logger server.Compress 等仍然按内置的配置  其他功能使用
+func (server *Server) Register(module Module) {
+	name := module.Name()
+	server.mutex.Lock()
+	if server.initialized != nil {
+		server.mutex.Unlock()
+		panic("Server: Register " + name + " after Init")
+	}
+	for _, val := range server.modules {
+		if val.Name() == name {
+			server.mutex.Unlock()
+			panic("Server: module " + name + " has exists")
+		}
+	}
+	server.modules = append(server.modules, module)
+	server.mutex.Unlock()
+
+	if isBuiltinModule(name) {
+		return
+	}
+	needs := []string{"logger"}
+	if val, ok := module.(ModuleNeeds); ok {
+		needs = val.Needs()
+	}
+	server.AddInitStep(InitStep{
+		Name:  "module." + name,
+		Needs: needs,
+		Run: func(server *Server) error {
+			return module.Configure(server.Modules[name])
+		},
+	})
+}
+
+// Module 注册的模块  不存在时为 nil
+func (server *Server) Module(name string) Module {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, val := range server.modules {
+		if val.Name() == name {
+			return val
+		}
+	}
+	return nil
+}
+
+func (server *Server) moduleMiddlewares() (handlers []gin.HandlerFunc) {
+	for _, val := range server.modules {
+		if isBuiltinModule(val.Name()) {
+			continue
+		}
+		if fn := val.Middleware(); fn != nil {
+			handlers = append(handlers, fn)
+		}
+	}
+	return
+}
+
+// startModules 内置的先启动  其他按注册的顺序  失败时停止已经启动的
+func (server *Server) startModules(ctx context.Context) error {
+	if err := server.startBuiltins(ctx); err != nil {
+		return err
+	}
+	modules := server.external()
+	for i, val := range modules {
+		if err := val.Start(ctx); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				modules[j].Stop(ctx)
+			}
+			return errors.New("Server: module " + val.Name() + " start: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// stopModules 按注册的相反顺序  失败只记录日志  内置的由 stopBuiltins 最后关闭
+func (server *Server) stopModules(ctx context.Context) {
+	modules := server.external()
+	for i := len(modules) - 1; i >= 0; i-- {
+		if err := modules[i].Stop(ctx); err != nil {
+			logrus.Error("Module "+modules[i].Name()+" Stop:", err)
+		}
+	}
+}
+
+// external 不代替内置的模块
+func (server *Server) external() (modules []Module) {
+	for _, val := range server.modules {
+		if !isBuiltinModule(val.Name()) {
+			modules = append(modules, val)
+		}
+	}
+	return
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/globalsign/mgo"
+	"github.com/otamoe/gin-server/audit"
 	"github.com/otamoe/gin-server/mongo"
 	mgoModel "github.com/otamoe/mgo-model"
 )
@@ -21,19 +22,38 @@ type (
 		DialTimeout   time.Duration `json:"dial_timeout,omitempty"`
 		SocketTimeout time.Duration `json:"socket_timeout,omitempty"`
 
+		// 连接失败时重试的总时长  0 不重试
+		DialRetry time.Duration `json:"dial_retry,omitempty"`
+
+		// Init 时不连接  第一次 Get 时连接  失败时下次 Get 再连接
+		Lazy bool `json:"lazy,omitempty"`
+
+		// 前缀  auto 为 name_env_  为空不加
+		Prefix string `json:"prefix,omitempty"`
+
+		// 前缀加在集合上  否则加在数据库上
+		PrefixCollections bool `json:"prefix_collections,omitempty"`
+
 		session *mgo.Session
-		once    sync.Once
+		info    *mgo.DialInfo
+		mutex   sync.Mutex
 	}
 )
 
 func init() {
 	mgoModel.CONTEXT = mongo.CONTEXT
+	// created_by updated_by 和 audit 的 actor 一致
+	mongo.Actor = audit.Actor
 }
 
 func (config *Mongo) init(server *Server, handler *Handler) {
-	if config.session != nil {
+	if config.session != nil || config.info != nil {
 		return
 	}
+	// test env 没有地址时不连接  使用 MongoProvider 或 TEST_MONGO_URL
+	if len(config.URLs) == 0 && server.testing() {
+		config.Lazy = true
+	}
 	if len(config.URLs) == 0 {
 		var db string
 		if handler != nil && handler.Name != "" {
@@ -56,6 +76,10 @@ func (config *Mongo) init(server *Server, handler *Handler) {
 		config.SocketTimeout = time.Minute * 1
 	}
 
+	if config.Prefix == "auto" && server != nil {
+		config.Prefix = server.Name + "_" + server.ENV + "_"
+	}
+
 	if handler == nil && server != nil {
 		if server.ENV == "development" {
 			mgo.SetDebug(true)
@@ -64,15 +88,72 @@ func (config *Mongo) init(server *Server, handler *Handler) {
 		}
 	}
 
-	var err error
-	if config.session, err = mgo.DialWithTimeout(strings.Join(config.URLs, ","), config.DialTimeout); err != nil {
+	info, err := mgo.ParseURL(strings.Join(config.URLs, ","))
+	if err != nil {
+		panic(err)
+	}
+	info.Timeout = config.DialTimeout
+	if config.Prefix != "" && !config.PrefixCollections {
+		// 认证数据库不变
+		if info.Source == "" && info.Username != "" {
+			info.Source = info.Database
+		}
+		info.Database = config.Prefix + info.Database
+	}
+	config.info = info
+	if config.Lazy {
+		return
+	}
+	err = dialRetry(server.dialLogger(), "mongo", config.DialRetry, func() error {
+		_, err := config.Session()
+		return err
+	})
+	if err != nil {
 		panic(err)
 	}
-	config.session.SetPoolLimit(config.PoolLimit)
-	config.session.SetPoolTimeout(config.PoolTimeout)
-	config.session.SetSocketTimeout(config.SocketTimeout)
 }
 
+// Session 未连接时连接  返回共用的 session  不需要 Close
+func (config *Mongo) Session() (*mgo.Session, error) {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	if config.session != nil {
+		return config.session, nil
+	}
+	session, err := mgo.DialWithInfo(config.info)
+	if err != nil {
+		return nil, err
+	}
+	session.SetPoolLimit(config.PoolLimit)
+	session.SetPoolTimeout(config.PoolTimeout)
+	session.SetSocketTimeout(config.SocketTimeout)
+	config.session = session
+	return session, nil
+}
+
+// Get Lazy 时连接失败 panic  由 recovery 返回 500
 func (config *Mongo) Get() *mgo.Session {
-	return config.session.Clone()
+	session, err := config.Session()
+	if err != nil {
+		panic(err)
+	}
+	return session.Clone()
+}
+
+// Close 关闭共用的 session  之后 Session 重新连接
+func (config *Mongo) Close() {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	if config.session != nil {
+		config.session.Close()
+		config.session = nil
+	}
+}
+
+// CollectionPrefix PrefixCollections 时集合的前缀
+func (config *Mongo) CollectionPrefix() string {
+	if config.PrefixCollections {
+		return config.Prefix
+	}
+	return ""
 }
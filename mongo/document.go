@@ -0,0 +1,128 @@
+package mongo
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+type (
+	// Timestamps 嵌入到文档  Insert 时设置 created updated
+	Timestamps struct {
+		CreatedAt *time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+		CreatedBy string     `json:"created_by,omitempty" bson:"created_by,omitempty"`
+		UpdatedAt *time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+		UpdatedBy string     `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+		DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+		DeletedBy string     `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
+	}
+
+	Stamper interface {
+		Stamp(now time.Time, actor string)
+	}
+)
+
+// Actor 默认和 audit 相同  由 server 设置
+var Actor = func(ctx *gin.Context) string {
+	return ""
+}
+
+// Stamp 已有 CreatedAt 时只更新 updated
+func (timestamps *Timestamps) Stamp(now time.Time, actor string) {
+	if timestamps.CreatedAt == nil {
+		timestamps.CreatedAt = &now
+		timestamps.CreatedBy = actor
+	}
+	timestamps.UpdatedAt = &now
+	timestamps.UpdatedBy = actor
+}
+
+// Insert 设置 Timestamps 后写入请求的数据库
+func Insert(ctx *gin.Context, name string, docs ...interface{}) error {
+	now := time.Now()
+	actor := Actor(ctx)
+	for _, doc := range docs {
+		if val, ok := doc.(Stamper); ok {
+			val.Stamp(now, actor)
+		}
+	}
+	return C(ctx, name).Insert(docs...)
+}
+
+// Update 只更新未删除的  update 的 $set 加上 updated
+func Update(ctx *gin.Context, name string, selector bson.M, update bson.M) error {
+	return C(ctx, name).Update(NotDeleted(selector), stamped(ctx, update))
+}
+
+func UpdateAll(ctx *gin.Context, name string, selector bson.M, update bson.M) (*mgo.ChangeInfo, error) {
+	return C(ctx, name).UpdateAll(NotDeleted(selector), stamped(ctx, update))
+}
+
+// Find 默认不包括已删除的  需要时直接使用 C
+func Find(ctx *gin.Context, name string, selector bson.M) *mgo.Query {
+	return C(ctx, name).Find(NotDeleted(selector))
+}
+
+// SoftDelete 设置 deleted_at deleted_by
+func SoftDelete(ctx *gin.Context, name string, selector bson.M) (*mgo.ChangeInfo, error) {
+	return C(ctx, name).UpdateAll(NotDeleted(selector), bson.M{
+		"$set": bson.M{
+			"deleted_at": time.Now(),
+			"deleted_by": Actor(ctx),
+		},
+	})
+}
+
+func Restore(ctx *gin.Context, name string, selector bson.M) (*mgo.ChangeInfo, error) {
+	query := bson.M{}
+	for key, val := range selector {
+		query[key] = val
+	}
+	query["deleted_at"] = bson.M{"$exists": true}
+	return C(ctx, name).UpdateAll(query, bson.M{
+		"$unset": bson.M{
+			"deleted_at": "",
+			"deleted_by": "",
+		},
+		"$set": stampedSet(ctx),
+	})
+}
+
+// NotDeleted 复制 selector 加上 deleted_at 不存在
+func NotDeleted(selector bson.M) bson.M {
+	query := bson.M{}
+	for key, val := range selector {
+		query[key] = val
+	}
+	if _, ok := query["deleted_at"]; !ok {
+		query["deleted_at"] = bson.M{"$exists": false}
+	}
+	return query
+}
+
+func stamped(ctx *gin.Context, update bson.M) bson.M {
+	values := bson.M{}
+	for key, val := range update {
+		values[key] = val
+	}
+	set := bson.M{}
+	if val, ok := values["$set"].(bson.M); ok {
+		for key, val := range val {
+			set[key] = val
+		}
+	}
+	for key, val := range stampedSet(ctx) {
+		set[key] = val
+	}
+	values["$set"] = set
+	return values
+}
+
+func stampedSet(ctx *gin.Context) bson.M {
+	return bson.M{
+		"updated_at": time.Now(),
+		"updated_by": Actor(ctx),
+	}
+}
@@ -3,19 +3,80 @@ package mongo
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/globalsign/mgo"
+	"github.com/otamoe/gin-server/timeout"
 )
 
 type (
-	GetSession func() *mgo.Session
+	// Provider Get 返回的 session 在请求结束时 Close
+	Provider interface {
+		Get() *mgo.Session
+	}
+
+	// Prefixer 多个环境共用一个集群时的集合前缀
+	Prefixer interface {
+		CollectionPrefix() string
+	}
+
+	// ProviderFunc 函数作为 Provider
+	ProviderFunc func() *mgo.Session
+
+	// GetSession 之前的名称
+	GetSession = ProviderFunc
 )
 
 var CONTEXT = "GIN.SERVER.MONGO"
 
+var CONTEXT_PREFIX = "GIN.SERVER.MONGO.PREFIX"
+
+// CONTEXT_DATABASE 为空时使用连接的默认数据库
+var CONTEXT_DATABASE = "GIN.SERVER.MONGO.DATABASE"
+
+func (fn ProviderFunc) Get() *mgo.Session {
+	return fn()
+}
+
+// Middleware 每个请求调用 getSession  Provider 使用 MiddlewareProvider
 func Middleware(getSession GetSession) gin.HandlerFunc {
+	return MiddlewareProvider(getSession)
+}
+
+func MiddlewareProvider(provider Provider) gin.HandlerFunc {
+	var prefix string
+	if val, ok := provider.(Prefixer); ok {
+		prefix = val.CollectionPrefix()
+	}
 	return func(ctx *gin.Context) {
-		session := getSession()
+		session := provider.Get()
 		defer session.Close()
+
+		// 请求有 deadline 时 socket 超时不超过剩余时间
+		if remaining, ok := timeout.Remaining(ctx.Request.Context()); ok {
+			session.SetSocketTimeout(remaining)
+		}
 		ctx.Set(CONTEXT, session)
+		ctx.Set(CONTEXT_PREFIX, prefix)
 		ctx.Next()
 	}
 }
+
+// Collection 加上 Provider 的前缀
+func Collection(ctx *gin.Context, name string) string {
+	if val, ok := ctx.Get(CONTEXT_PREFIX); ok && val != nil {
+		return val.(string) + name
+	}
+	return name
+}
+
+// DB 请求的数据库  例如 tenant 设置的数据库
+func DB(ctx *gin.Context) *mgo.Database {
+	var name string
+	if val, ok := ctx.Get(CONTEXT_DATABASE); ok && val != nil {
+		name = val.(string)
+	}
+	return ctx.MustGet(CONTEXT).(*mgo.Session).DB(name)
+}
+
+// C 请求的数据库的集合
+func C(ctx *gin.Context, name string) *mgo.Collection {
+	return DB(ctx).C(Collection(ctx, name))
+}
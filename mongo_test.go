@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Lazy 连接失败时返回 error  不 panic
+func TestMongoLazyUnreachable(t *testing.T) {
+	srv := &Server{
+		ENV: "test",
+		Mongo: &Mongo{
+			URLs:        []string{"127.0.0.1:1/test"},
+			Lazy:        true,
+			DialTimeout: time.Millisecond * 100,
+		},
+	}
+	srv.Init()
+
+	if _, err := srv.mongoSession(); err == nil {
+		t.Fatal("mongoSession: expected error")
+	}
+	if err := srv.RunSeeds(context.Background(), []string{}); err == nil {
+		t.Fatal("RunSeeds: expected error")
+	}
+	if _, err := srv.MigrateUp(context.Background(), 0, true); err == nil {
+		t.Fatal("MigrateUp: expected error")
+	}
+	srv.RunWarmup(context.Background(), []string{})
+}
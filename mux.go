@@ -0,0 +1,359 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/otamoe/gin-server/utils"
+)
+
+type (
+	serverHandler struct {
+		// 正在处理的请求数  包括长连接
+		active int64
+
+		mutex          sync.RWMutex
+		hosts          map[string]*serverHost
+		redirects      map[string]string
+		builtins       *Builtins
+		acme           *ACME
+		health         *Health
+		switcher       *Switch
+		grpc           http.Handler
+		trustedProxies []*net.IPNet
+		keepAlive      *KeepAlive
+		conns          *connTracker
+		slow           *slowListener
+		normalize      *Normalize
+		tlsPolicies    []map[string]*TLSPolicy
+	}
+
+	serverHost struct {
+		routes   []serverRoute
+		builtins *Builtins
+	}
+
+	serverRoute struct {
+		prefix  string
+		handler http.Handler
+	}
+)
+
+func newServerHandler() *serverHandler {
+	return &serverHandler{
+		hosts:     map[string]*serverHost{},
+		redirects: map[string]string{},
+		conns:     newConnTracker(),
+	}
+}
+
+// add pattern 格式 host 或 host/prefix
+func (h *serverHandler) add(pattern string, handler http.Handler) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	host, prefix := splitPattern(pattern)
+	val, ok := h.hosts[host]
+	if !ok {
+		val = &serverHost{}
+		h.hosts[host] = val
+	}
+	val.add(prefix, handler)
+}
+
+// remove pattern 是 host 时删除整个 host
+func (h *serverHandler) remove(pattern string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	host, prefix := splitPattern(pattern)
+	if !strings.Contains(pattern, "/") {
+		delete(h.hosts, host)
+	} else if val, ok := h.hosts[host]; ok {
+		val.remove(prefix)
+		if len(val.routes) == 0 {
+			delete(h.hosts, host)
+		}
+	}
+}
+
+func (h *serverHandler) redirect(host string, target string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if target == "" {
+		delete(h.redirects, hostName(host))
+	} else {
+		h.redirects[hostName(host)] = target
+	}
+}
+
+// setRedirects 整体替换
+func (h *serverHandler) setRedirects(redirects map[string]string) {
+	values := map[string]string{}
+	for host, target := range redirects {
+		if target != "" {
+			values[hostName(host)] = target
+		}
+	}
+	h.mutex.Lock()
+	h.redirects = values
+	h.mutex.Unlock()
+}
+
+func (h *serverHandler) setBuiltins(host string, builtins *Builtins) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if val, ok := h.hosts[hostName(host)]; ok {
+		val.builtins = builtins
+	}
+}
+
+// match 匹配顺序 完全匹配 => 通配符 (最长优先) => default  同一个 host 路径前缀最长优先
+func (h *serverHandler) match(host string, urlPath string) (handler http.Handler, builtins *Builtins) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	builtins = h.builtins
+	if _, ok := matchHost(host, func(key string) bool {
+		if val, ok := h.hosts[key]; ok {
+			if handler = val.match(urlPath); handler != nil && val.builtins != nil {
+				builtins = val.builtins
+			}
+		}
+		return handler != nil
+	}); ok {
+		return
+	}
+	if val, ok := h.hosts["default"]; ok {
+		if handler = val.match(urlPath); handler != nil && val.builtins != nil {
+			builtins = val.builtins
+		}
+	}
+	return
+}
+
+func (h *serverHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&h.active, 1)
+	defer atomic.AddInt64(&h.active, -1)
+
+	// 连接达到请求数 或 时间上限
+	if h.keepAlive != nil && h.keepAlive.close(h.conns, req) {
+		writer.Header().Set("Connection", "close")
+	}
+
+	// 检查请求体的读取速度
+	if h.slow != nil {
+		h.slow.request(req)
+	}
+
+	// 请求走私  重复的 Header  路径中的 .. //
+	if h.normalize != nil && !h.normalize.check(writer, req) {
+		return
+	}
+
+	// ACME HTTP-01 不匹配 host
+	if h.acme != nil && strings.HasPrefix(req.URL.Path, acmeChallengePrefix) {
+		h.acme.ServeHTTP(writer, req)
+		return
+	}
+
+	if h.grpc != nil && isGRPC(req) {
+		h.grpc.ServeHTTP(writer, req)
+		return
+	}
+
+	// livez readyz 不匹配 host
+	if h.health != nil {
+		if handler := h.health.get(req.URL.Path); handler != nil {
+			handler.ServeHTTP(writer, req)
+			return
+		}
+	}
+
+	// 蓝绿切换管理接口
+	if h.switcher != nil && h.switcher.match(req.URL.Path) {
+		h.switcher.ServeHTTP(writer, req)
+		return
+	}
+
+	host := h.host(req)
+
+	// SNI 和 Host 的 TLSPolicy 不同  不能绕过客户端证书
+	if req.TLS != nil && h.misdirected(req.TLS.ServerName, host) {
+		http.Error(writer, http.StatusText(http.StatusMisdirectedRequest), http.StatusMisdirectedRequest)
+		return
+	}
+
+	// 重定向
+	if target := h.matchRedirect(host); target != "" {
+		h.serveRedirect(writer, req, target)
+		return
+	}
+
+	handler, builtins := h.match(host, req.URL.Path)
+
+	// robots.txt favicon.ico 等
+	if builtins != nil {
+		if builtin := builtins.get(req.URL.Path); builtin != nil {
+			builtin.ServeHTTP(writer, req)
+			return
+		}
+	}
+
+	if handler != nil {
+		handler.ServeHTTP(writer, req)
+	} else {
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	}
+}
+
+func (h *serverHandler) matchRedirect(host string) string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if key, ok := matchHost(host, func(key string) bool {
+		_, ok := h.redirects[key]
+		return ok
+	}); ok {
+		return h.redirects[key]
+	}
+	return ""
+}
+
+// serveRedirect 保留 path 和 query  target 没有 scheme 时使用请求的 scheme
+func (h *serverHandler) serveRedirect(writer http.ResponseWriter, req *http.Request, target string) {
+	if !strings.Contains(target, "://") {
+		target = h.scheme(req) + "://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + req.URL.Path
+	u.RawPath = ""
+	u.RawQuery = req.URL.RawQuery
+
+	code := http.StatusPermanentRedirect
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+	http.Redirect(writer, req, u.String(), code)
+}
+
+// host 非信任的代理 删除 X-Forwarded-Host X-Host
+func (h *serverHandler) host(req *http.Request) (host string) {
+	if utils.ContainsIP(h.trustedProxies, utils.RemoteIP(req)) {
+		if host = req.Header.Get("X-Forwarded-Host"); host != "" {
+			return
+		}
+		if host = req.Header.Get("X-Host"); host != "" {
+			return
+		}
+	} else {
+		req.Header.Del("X-Forwarded-Host")
+		req.Header.Del("X-Host")
+	}
+	if host = req.Host; host != "" {
+	} else if host = req.URL.Host; host != "" {
+	} else {
+		host = "localhost"
+	}
+	return
+}
+
+func (h *serverHandler) scheme(req *http.Request) string {
+	if utils.ContainsIP(h.trustedProxies, utils.RemoteIP(req)) {
+		if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+			return strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+		}
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func (host *serverHost) add(prefix string, handler http.Handler) {
+	host.remove(prefix)
+	host.routes = append(host.routes, serverRoute{
+		prefix:  prefix,
+		handler: handler,
+	})
+	sort.SliceStable(host.routes, func(i, j int) bool {
+		return len(host.routes[i].prefix) > len(host.routes[j].prefix)
+	})
+}
+
+func (host *serverHost) remove(prefix string) {
+	for i, route := range host.routes {
+		if route.prefix == prefix {
+			host.routes = append(host.routes[:i:i], host.routes[i+1:]...)
+			return
+		}
+	}
+}
+
+func (host *serverHost) match(urlPath string) http.Handler {
+	for _, route := range host.routes {
+		if matchPrefix(route.prefix, urlPath) {
+			return route.handler
+		}
+	}
+	return nil
+}
+
+// matchPrefix /api 匹配 /api 和 /api/...  不匹配 /apis
+func matchPrefix(prefix string, urlPath string) bool {
+	if prefix == "/" || prefix == urlPath {
+		return true
+	}
+	if !strings.HasPrefix(urlPath, prefix) {
+		return false
+	}
+	return strings.HasSuffix(prefix, "/") || urlPath[len(prefix)] == '/'
+}
+
+// splitPattern example.com/api => example.com /api
+func splitPattern(pattern string) (host string, prefix string) {
+	prefix = "/"
+	if index := strings.Index(pattern, "/"); index != -1 {
+		pattern, prefix = pattern[:index], pattern[index:]
+		if prefix != "/" {
+			prefix = strings.TrimSuffix(prefix, "/")
+		}
+	}
+	host = hostName(pattern)
+	return
+}
+
+// matchHost 完全匹配 => 通配符 (最长优先) => *
+func matchHost(host string, has func(key string) bool) (string, bool) {
+	host = hostName(host)
+	if has(host) {
+		return host, true
+	}
+	for index := strings.Index(host, "."); index != -1; {
+		host = host[index+1:]
+		if has("*." + host) {
+			return "*." + host, true
+		}
+		index = strings.Index(host, ".")
+	}
+	if has("*") {
+		return "*", true
+	}
+	return "", false
+}
+
+// hostName 去掉端口 小写
+func hostName(host string) string {
+	host = strings.TrimSpace(host)
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/otamoe/gin-server/normalize"
+)
+
+type (
+	// Normalize 路由之前检查请求  拒绝请求走私  规范 host 和路径
+	Normalize struct {
+		// 路径变化时重定向  否则修改后继续
+		Redirect bool `json:"redirect,omitempty"`
+
+		// 允许 %2F
+		AllowEncodedSlash bool `json:"allow_encoded_slash,omitempty"`
+
+		// 只允许一个值的 Header  为空时使用默认
+		SingleHeaders []string `json:"single_headers,omitempty"`
+
+		config normalize.Config
+	}
+)
+
+func (config *Normalize) init(server *Server, handler *Handler) {
+	config.config = normalize.Config{
+		Redirect:          config.Redirect,
+		AllowEncodedSlash: config.AllowEncodedSlash,
+		SingleHeaders:     config.SingleHeaders,
+	}
+}
+
+func (config *Normalize) check(writer http.ResponseWriter, req *http.Request) bool {
+	return normalize.Check(config.config, writer, req)
+}
@@ -0,0 +1,163 @@
+package normalize
+
+import (
+	"net/http"
+	"net/textproto"
+	"path"
+	"strings"
+
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	Config struct {
+		// 路径变化时重定向  否则修改后继续
+		Redirect bool
+
+		// 允许 %2F
+		AllowEncodedSlash bool
+
+		// 只允许一个值  重复相同的值合并  不同的值返回 400
+		SingleHeaders []string
+	}
+)
+
+var DefaultSingleHeaders = []string{
+	"Content-Length",
+	"Content-Type",
+	"Authorization",
+	"Origin",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+	"X-Http-Method-Override",
+}
+
+var ErrSmuggling = &errs.Error{
+	Message:    "Request has both Content-Length and Transfer-Encoding",
+	Type:       "normalize",
+	StatusCode: http.StatusBadRequest,
+}
+
+var ErrHeader = &errs.Error{
+	Message:    "Request header has conflicting values",
+	Type:       "normalize",
+	StatusCode: http.StatusBadRequest,
+}
+
+var ErrPath = &errs.Error{
+	Message:    "Request path is invalid",
+	Type:       "normalize",
+	StatusCode: http.StatusBadRequest,
+}
+
+var ErrHost = &errs.Error{
+	Message:    "Request host is invalid",
+	Type:       "normalize",
+	StatusCode: http.StatusBadRequest,
+}
+
+// Check 在路由之前调用  返回 false 时已经输出响应
+func Check(c Config, writer http.ResponseWriter, req *http.Request) bool {
+	changed, err := Request(c, req)
+	if err != nil {
+		// 请求体的边界不可信  不能继续使用连接
+		writer.Header().Set("Connection", "close")
+		http.Error(writer, err.Message, err.StatusCode)
+		return false
+	}
+	if changed && c.Redirect {
+		u := *req.URL
+		u.Scheme = ""
+		u.Host = ""
+		code := http.StatusPermanentRedirect
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(writer, req, u.String(), code)
+		return false
+	}
+	return true
+}
+
+// Request 检查并修改请求  changed 为路径是否变化
+func Request(c Config, req *http.Request) (changed bool, err *errs.Error) {
+	// Transfer-Encoding 和 Content-Length 同时存在  可能是请求走私
+	if len(req.TransferEncoding) != 0 && len(req.Header["Content-Length"]) != 0 {
+		return false, ErrSmuggling
+	}
+
+	singleHeaders := c.SingleHeaders
+	if singleHeaders == nil {
+		singleHeaders = DefaultSingleHeaders
+	}
+	for _, name := range singleHeaders {
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		values := req.Header[name]
+		if len(values) < 2 {
+			continue
+		}
+		for _, val := range values[1:] {
+			if strings.TrimSpace(val) != strings.TrimSpace(values[0]) {
+				e := ErrHeader.Clone()
+				e.Path = name
+				return false, e
+			}
+		}
+		req.Header[name] = values[:1]
+	}
+
+	// 多个代理时第一个是客户端访问的 host
+	if val := req.Header.Get("X-Forwarded-Host"); strings.Contains(val, ",") {
+		req.Header.Set("X-Forwarded-Host", strings.TrimSpace(strings.Split(val, ",")[0]))
+	}
+	if !validHost(req.Host) || !validHost(req.Header.Get("X-Forwarded-Host")) {
+		return false, ErrHost
+	}
+	req.Host = strings.TrimSuffix(strings.ToLower(req.Host), ".")
+
+	urlPath := req.URL.Path
+	for i := 0; i < len(urlPath); i++ {
+		if urlPath[i] < 0x20 || urlPath[i] == 0x7f || urlPath[i] == '\\' {
+			return false, ErrPath
+		}
+	}
+	if !c.AllowEncodedSlash && strings.Contains(strings.ToLower(req.URL.RawPath), "%2f") {
+		return false, ErrPath
+	}
+
+	cleaned := Clean(urlPath)
+	if cleaned != urlPath {
+		req.URL.Path = cleaned
+		req.URL.RawPath = ""
+		changed = true
+	}
+	return
+}
+
+// Clean 去掉 . .. //  保留结尾的 /
+func Clean(urlPath string) string {
+	if urlPath == "" {
+		return "/"
+	}
+	if urlPath[0] != '/' {
+		urlPath = "/" + urlPath
+	}
+	cleaned := path.Clean(urlPath)
+	if urlPath[len(urlPath)-1] == '/' && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func validHost(host string) bool {
+	for i := 0; i < len(host); i++ {
+		switch b := host[i]; {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		case b == '.' || b == '-' || b == '_' || b == ':' || b == '[' || b == ']':
+		default:
+			return false
+		}
+	}
+	return true
+}
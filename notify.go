@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/alerts"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/notify"
+	"github.com/otamoe/gin-server/resource"
+)
+
+type (
+	// Notify 配置 notify.Default  代码中也可以 notify.Register
+	Notify struct {
+		Rules     []notify.Rule              `json:"rules,omitempty"`
+		Providers map[string]*NotifyProvider `json:"providers,omitempty"`
+
+		// 5xx 错误发送 errors 事件
+		Errors bool `json:"errors,omitempty"`
+
+		// 告警发送 alerts.error_rate alerts.latency 事件
+		Alerts bool `json:"alerts,omitempty"`
+
+		started bool
+	}
+
+	NotifyProvider struct {
+		// webhook  slack  email
+		Type     string            `json:"type"`
+		URL      string            `json:"url,omitempty"`
+		Headers  map[string]string `json:"headers,omitempty"`
+		Channel  string            `json:"channel,omitempty"`
+		Username string            `json:"username,omitempty"`
+		To       []string          `json:"to,omitempty"`
+		Prefix   string            `json:"prefix,omitempty"`
+	}
+)
+
+func (config *Notify) init(server *Server, handler *Handler) {
+	if config.started {
+		return
+	}
+	config.started = true
+	for name, val := range config.Providers {
+		var provider notify.Provider
+		switch val.Type {
+		case "webhook":
+			provider = &notify.Webhook{URL: val.URL, Headers: val.Headers}
+		case "slack":
+			provider = &notify.Slack{URL: val.URL, Channel: val.Channel, Username: val.Username}
+		case "email":
+			if server.Mail == nil {
+				panic("Notify: mail is empty")
+			}
+			provider = &notify.Email{Mailer: server.Mail.Get(), To: val.To, Prefix: val.Prefix}
+		default:
+			panic("Notify: unknown provider type " + val.Type)
+		}
+		notify.Register(name, provider)
+	}
+	if len(config.Rules) != 0 {
+		notify.Default.SetRules(config.Rules)
+	}
+}
+
+// alert 用于 Alerts.Callbacks
+func (config *Notify) alert(alert alerts.Alert) {
+	level := notify.LevelWarning
+	title := "Alert firing " + alert.Kind + " " + alert.Route
+	if alert.Resolved {
+		level = notify.LevelInfo
+		title = "Alert resolved " + alert.Kind + " " + alert.Route
+	}
+	notify.Notify(&notify.Notification{
+		Event: "alerts." + alert.Kind,
+		Level: level,
+		Title: title,
+		Fields: map[string]interface{}{
+			"host":      alert.Host,
+			"route":     alert.Route,
+			"value":     alert.Value,
+			"threshold": alert.Threshold,
+			"requests":  alert.Requests,
+		},
+	})
+}
+
+// errors 用于 errs.Config.Callback  只通知 5xx
+func (config *Notify) errors(ctx *gin.Context, e *errs.Errors) {
+	if e.StatusCode < http.StatusInternalServerError {
+		return
+	}
+	notify.Get(ctx).Notify(&notify.Notification{
+		Event:   "errors",
+		Level:   notify.LevelError,
+		Title:   strconv.Itoa(e.StatusCode) + " " + ctx.Request.Method + " " + resource.RoutePath(ctx),
+		Message: e.Error(),
+		Fields: map[string]interface{}{
+			"host":       ctx.Request.Host,
+			"path":       ctx.Request.URL.Path,
+			"request_id": e.RequestID,
+		},
+	})
+}
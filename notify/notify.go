@@ -0,0 +1,269 @@
+package notify
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	Config struct {
+		// 没有规则时发送到全部 provider
+		Rules []Rule
+
+		// 每个 provider 的超时  默认 10s
+		Timeout time.Duration
+
+		Logger *logrus.Logger
+	}
+
+	// Rule Events 支持 path.Match  例如 alerts.*  为空时匹配全部
+	Rule struct {
+		Events    []string      `json:"events,omitempty"`
+		Levels    []string      `json:"levels,omitempty"`
+		Providers []string      `json:"providers"`
+		Throttle  time.Duration `json:"throttle,omitempty"`
+	}
+
+	Notification struct {
+		Event     string                 `json:"event"`
+		Level     string                 `json:"level"`
+		Title     string                 `json:"title"`
+		Message   string                 `json:"message,omitempty"`
+		Fields    map[string]interface{} `json:"fields,omitempty"`
+		CreatedAt time.Time              `json:"created_at"`
+	}
+
+	Provider interface {
+		Notify(ctx context.Context, notification *Notification) error
+	}
+
+	ProviderFunc func(ctx context.Context, notification *Notification) error
+
+	Notifier struct {
+		config Config
+
+		mutex     sync.RWMutex
+		providers map[string]Provider
+
+		throttleMutex sync.Mutex
+		throttled     map[string]time.Time
+	}
+)
+
+const (
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+var CONTEXT = "GIN.SERVER.NOTIFY"
+
+var Default = New(Config{})
+
+func (fn ProviderFunc) Notify(ctx context.Context, notification *Notification) error {
+	return fn(ctx, notification)
+}
+
+func New(c Config) *Notifier {
+	if c.Timeout == 0 {
+		c.Timeout = time.Second * 10
+	}
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	return &Notifier{
+		config:    c,
+		providers: map[string]Provider{},
+		throttled: map[string]time.Time{},
+	}
+}
+
+// Register 注册到 Default
+func Register(name string, provider Provider) {
+	Default.Register(name, provider)
+}
+
+// Notify 使用 Default
+func Notify(notification *Notification) {
+	Default.Notify(notification)
+}
+
+func Middleware(notifier *Notifier) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, notifier)
+		ctx.Next()
+	}
+}
+
+// Get 没有中间件时为 Default
+func Get(ctx *gin.Context) *Notifier {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Notifier)
+	}
+	return Default
+}
+
+func (notifier *Notifier) Register(name string, provider Provider) {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	if _, ok := notifier.providers[name]; ok {
+		panic("Notify: " + name + " has exists")
+	}
+	notifier.providers[name] = provider
+}
+
+// SetRules 替换规则
+func (notifier *Notifier) SetRules(rules []Rule) {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	notifier.config.Rules = rules
+}
+
+func (notifier *Notifier) Providers() (names []string) {
+	notifier.mutex.RLock()
+	defer notifier.mutex.RUnlock()
+	for name := range notifier.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Notify 按规则异步发送到匹配的 provider  同一个 provider 只发送一次
+func (notifier *Notifier) Notify(notification *Notification) {
+	if notification.Level == "" {
+		notification.Level = LevelInfo
+	}
+	if notification.CreatedAt.IsZero() {
+		notification.CreatedAt = time.Now()
+	}
+	for name, provider := range notifier.match(notification) {
+		go notifier.send(name, provider, notification)
+	}
+}
+
+// NotifySync 等待全部 provider  返回第一个错误
+func (notifier *Notifier) NotifySync(ctx context.Context, notification *Notification) (err error) {
+	if notification.Level == "" {
+		notification.Level = LevelInfo
+	}
+	if notification.CreatedAt.IsZero() {
+		notification.CreatedAt = time.Now()
+	}
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for name, provider := range notifier.match(notification) {
+		wg.Add(1)
+		go func(name string, provider Provider) {
+			defer wg.Done()
+			if e := notifier.call(ctx, name, provider, notification); e != nil {
+				mutex.Lock()
+				if err == nil {
+					err = e
+				}
+				mutex.Unlock()
+			}
+		}(name, provider)
+	}
+	wg.Wait()
+	return
+}
+
+func (notifier *Notifier) match(notification *Notification) map[string]Provider {
+	notifier.mutex.RLock()
+	defer notifier.mutex.RUnlock()
+	providers := map[string]Provider{}
+	if len(notifier.config.Rules) == 0 {
+		for name, provider := range notifier.providers {
+			providers[name] = provider
+		}
+		return providers
+	}
+	for i, rule := range notifier.config.Rules {
+		if !rule.match(notification) {
+			continue
+		}
+		if rule.Throttle > 0 && notifier.throttle(i, rule.Throttle, notification) {
+			continue
+		}
+		for _, name := range rule.Providers {
+			provider, ok := notifier.providers[name]
+			if !ok {
+				notifier.config.Logger.Warnf("[NOTIFY] provider %s not found", name)
+				continue
+			}
+			providers[name] = provider
+		}
+	}
+	return providers
+}
+
+// throttle 同一个规则  event title 在 d 内只发送一次
+func (notifier *Notifier) throttle(index int, d time.Duration, notification *Notification) bool {
+	key := strconv.Itoa(index) + "|" + notification.Event + "|" + notification.Title
+	now := time.Now()
+	notifier.throttleMutex.Lock()
+	defer notifier.throttleMutex.Unlock()
+	if at, ok := notifier.throttled[key]; ok && now.Sub(at) < d {
+		return true
+	}
+	notifier.throttled[key] = now
+	// 清理过期
+	if len(notifier.throttled) > 1024 {
+		for key, at := range notifier.throttled {
+			if now.Sub(at) >= d {
+				delete(notifier.throttled, key)
+			}
+		}
+	}
+	return false
+}
+
+func (notifier *Notifier) send(name string, provider Provider, notification *Notification) {
+	notifier.call(context.Background(), name, provider, notification)
+}
+
+func (notifier *Notifier) call(ctx context.Context, name string, provider Provider, notification *Notification) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, notifier.config.Timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			notifier.config.Logger.Errorf("[NOTIFY] %s panic %v", name, r)
+		}
+	}()
+	if err = provider.Notify(ctx, notification); err != nil {
+		notifier.config.Logger.Errorf("[NOTIFY] %s %s %s", name, notification.Event, err)
+	}
+	return
+}
+
+func (rule Rule) match(notification *Notification) bool {
+	if len(rule.Levels) != 0 {
+		var ok bool
+		for _, level := range rule.Levels {
+			if level == notification.Level {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(rule.Events) == 0 {
+		return true
+	}
+	for _, pattern := range rule.Events {
+		if ok, _ := path.Match(pattern, notification.Event); ok {
+			return true
+		}
+	}
+	return false
+}
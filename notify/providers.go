@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/otamoe/gin-server/mail"
+)
+
+type (
+	// Webhook POST json
+	Webhook struct {
+		URL     string
+		Headers map[string]string
+		Client  *http.Client
+	}
+
+	// Slack incoming webhook  兼容 mattermost 等
+	Slack struct {
+		URL      string
+		Channel  string
+		Username string
+		Client   *http.Client
+	}
+
+	Email struct {
+		Mailer *mail.Mailer
+		To     []string
+
+		// 标题前缀  例如 [prod]
+		Prefix string
+	}
+)
+
+func (provider *Webhook) Notify(ctx context.Context, notification *Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	return post(ctx, provider.Client, provider.URL, provider.Headers, data)
+}
+
+func (provider *Slack) Notify(ctx context.Context, notification *Notification) error {
+	icon := ":information_source:"
+	switch notification.Level {
+	case LevelWarning:
+		icon = ":warning:"
+	case LevelError:
+		icon = ":rotating_light:"
+	}
+	text := icon + " *" + notification.Title + "*"
+	if notification.Message != "" {
+		text += "\n" + notification.Message
+	}
+	if fields := formatFields(notification.Fields); fields != "" {
+		text += "\n```" + fields + "```"
+	}
+	body := map[string]interface{}{
+		"text": text,
+	}
+	if provider.Channel != "" {
+		body["channel"] = provider.Channel
+	}
+	if provider.Username != "" {
+		body["username"] = provider.Username
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return post(ctx, provider.Client, provider.URL, nil, data)
+}
+
+func (provider *Email) Notify(ctx context.Context, notification *Notification) error {
+	if provider.Mailer == nil {
+		return errors.New("Notify: mailer is empty")
+	}
+	text := notification.Message
+	if fields := formatFields(notification.Fields); fields != "" {
+		text += "\n\n" + fields
+	}
+	return provider.Mailer.Send(&mail.Message{
+		To:      provider.To,
+		Subject: provider.Prefix + "[" + notification.Level + "] " + notification.Title,
+		Text:    text,
+	})
+}
+
+func post(ctx context.Context, client *http.Client, url string, headers map[string]string, data []byte) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return errors.New("Notify: " + url + " " + res.Status)
+	}
+	return nil
+}
+
+func formatFields(fields map[string]interface{}) string {
+	var keys []string
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var lines []string
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%s: %v", key, fields[key]))
+	}
+	return strings.Join(lines, "\n")
+}
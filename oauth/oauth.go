@@ -0,0 +1,250 @@
+package oauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/utils"
+)
+
+type (
+	Config struct {
+		Providers map[string]*Provider
+
+		// state cookie 签名
+		Secret []byte
+
+		CookieName string
+		Path       string
+		Domain     string
+		Secure     bool
+		MaxAge     int
+
+		// 登录成功后的默认跳转
+		Redirect string
+
+		// Provider 没有 RedirectURL 时的回调地址前缀  例如 https://example.com  为空时使用请求的 host
+		BaseURL string
+
+		// 只有来自这些地址时使用 X-Forwarded-Proto X-Forwarded-Host
+		TrustedProxies []*net.IPNet
+
+		// 登录成功 在这里创建 session
+		Login func(ctx *gin.Context, user *User) error
+	}
+)
+
+var CONTEXT = "GIN.SERVER.OAUTH"
+
+var ErrProvider = &errs.Error{
+	Message:    "Unknown OAuth provider",
+	Type:       "oauth",
+	StatusCode: http.StatusNotFound,
+}
+var ErrState = &errs.Error{
+	Message:    "Invalid OAuth state",
+	Type:       "oauth",
+	StatusCode: http.StatusBadRequest,
+}
+var ErrExchange = &errs.Error{
+	Message:    "OAuth login failed",
+	Type:       "oauth",
+	StatusCode: http.StatusBadGateway,
+}
+
+// Register 注册 GET /:provider 和 GET /:provider/callback
+func Register(router gin.IRoutes, c Config) {
+	if len(c.Secret) == 0 {
+		panic("oauth: secret is empty")
+	}
+	if c.Login == nil {
+		panic("oauth: login is nil")
+	}
+	if c.CookieName == "" {
+		c.CookieName = "oauth_state"
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = 600
+	}
+	if c.Redirect == "" {
+		c.Redirect = "/"
+	}
+
+	router.GET("/:provider", c.authorize)
+	router.GET("/:provider/callback", c.callback)
+}
+
+func Get(ctx *gin.Context) *User {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*User)
+	}
+	return nil
+}
+
+func (c Config) authorize(ctx *gin.Context) {
+	provider, ok := c.Providers[ctx.Param("provider")]
+	if !ok {
+		ctx.Error(ErrProvider)
+		ctx.Abort()
+		return
+	}
+
+	state := random()
+	var verifier, challenge string
+	if provider.PKCE {
+		verifier = random()
+		sum := sha256.Sum256([]byte(verifier))
+		challenge = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	// 只允许站内跳转
+	redirect := ctx.Query("redirect")
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		redirect = ""
+	}
+
+	value := strings.Join([]string{
+		state,
+		verifier,
+		base64.RawURLEncoding.EncodeToString([]byte(redirect)),
+	}, ".")
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     c.CookieName,
+		Value:    value + "." + c.sign(value),
+		Path:     c.Path,
+		Domain:   c.Domain,
+		MaxAge:   c.MaxAge,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	ctx.Redirect(http.StatusFound, provider.authCodeURL(c.redirectURL(ctx, provider), state, challenge))
+	ctx.Abort()
+}
+
+func (c Config) callback(ctx *gin.Context) {
+	provider, ok := c.Providers[ctx.Param("provider")]
+	if !ok {
+		ctx.Error(ErrProvider)
+		ctx.Abort()
+		return
+	}
+
+	cookie, _ := ctx.Cookie(c.CookieName)
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     c.CookieName,
+		Value:    "",
+		Path:     c.Path,
+		Domain:   c.Domain,
+		MaxAge:   -1,
+		Secure:   c.Secure,
+		HttpOnly: true,
+	})
+
+	parts := strings.Split(cookie, ".")
+	if len(parts) != 4 || !hmac.Equal([]byte(parts[3]), []byte(c.sign(strings.Join(parts[:3], ".")))) {
+		ctx.Error(ErrState)
+		ctx.Abort()
+		return
+	}
+	state := ctx.Query("state")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(parts[0])) != 1 {
+		ctx.Error(ErrState)
+		ctx.Abort()
+		return
+	}
+	if ctx.Query("error") != "" || ctx.Query("code") == "" {
+		ctx.Error(ErrExchange)
+		ctx.Abort()
+		return
+	}
+
+	redirectURL := c.redirectURL(ctx, provider)
+	token, err := provider.exchange(ctx.Request.Context(), redirectURL, ctx.Query("code"), parts[1])
+	if err != nil {
+		ctx.Error(ErrExchange)
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	user, err := provider.userInfo(ctx.Request.Context(), token)
+	if err != nil {
+		ctx.Error(ErrExchange)
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	ctx.Set(CONTEXT, user)
+
+	if err = c.Login(ctx, user); err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	if ctx.Writer.Written() || ctx.IsAborted() {
+		return
+	}
+
+	redirect := c.Redirect
+	if b, e := base64.RawURLEncoding.DecodeString(parts[2]); e == nil && len(b) != 0 {
+		redirect = string(b)
+	}
+	ctx.Redirect(http.StatusFound, redirect)
+	ctx.Abort()
+}
+
+func (c Config) redirectURL(ctx *gin.Context, provider *Provider) string {
+	if provider.RedirectURL != "" {
+		return provider.RedirectURL
+	}
+	path := strings.TrimSuffix(ctx.Request.URL.Path, "/")
+	if !strings.HasSuffix(path, "/callback") {
+		path += "/callback"
+	}
+	if c.BaseURL != "" {
+		return strings.TrimSuffix(c.BaseURL, "/") + path
+	}
+
+	req := ctx.Request
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	host := req.Host
+	// 代理的 header 可以伪造  授权码会发送到伪造的 host
+	if utils.ContainsIP(c.TrustedProxies, utils.RemoteIP(req)) {
+		if proto := strings.ToLower(strings.TrimSpace(strings.Split(req.Header.Get("X-Forwarded-Proto"), ",")[0])); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if val := strings.TrimSpace(strings.Split(req.Header.Get("X-Forwarded-Host"), ",")[0]); val != "" {
+			host = val
+		}
+	}
+	return scheme + "://" + host + path
+}
+
+func (c Config) sign(value string) string {
+	mac := hmac.New(sha256.New, c.Secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func random() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,247 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	Provider struct {
+		Name         string   `json:"name,omitempty"`
+		ClientID     string   `json:"client_id,omitempty"`
+		ClientSecret string   `json:"client_secret,omitempty"`
+		AuthURL      string   `json:"auth_url,omitempty"`
+		TokenURL     string   `json:"token_url,omitempty"`
+		UserInfoURL  string   `json:"userinfo_url,omitempty"`
+		Scopes       []string `json:"scopes,omitempty"`
+
+		// 为空时使用当前 host + callback 路由
+		RedirectURL string `json:"redirect_url,omitempty"`
+
+		// 是否发送 PKCE code_challenge
+		PKCE bool `json:"pkce,omitempty"`
+
+		// 额外的授权参数 例如 prompt hd
+		Params map[string]string `json:"params,omitempty"`
+	}
+
+	Token struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type,omitempty"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		ExpiresIn    int64  `json:"expires_in,omitempty"`
+		IDToken      string `json:"id_token,omitempty"`
+		Scope        string `json:"scope,omitempty"`
+		Error        string `json:"error,omitempty"`
+	}
+
+	User struct {
+		Provider string                 `json:"provider"`
+		ID       string                 `json:"id"`
+		Email    string                 `json:"email,omitempty"`
+		Verified bool                   `json:"verified,omitempty"`
+		Name     string                 `json:"name,omitempty"`
+		Picture  string                 `json:"picture,omitempty"`
+		Raw      map[string]interface{} `json:"raw,omitempty"`
+		Token    *Token                 `json:"-"`
+	}
+)
+
+var Client = &http.Client{
+	Timeout: time.Second * 15,
+}
+
+func Google(clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         "google",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:     "https://oauth2.googleapis.com/token",
+		UserInfoURL:  "https://openidconnect.googleapis.com/v1/userinfo",
+		Scopes:       []string{"openid", "email", "profile"},
+		PKCE:         true,
+	}
+}
+
+func GitHub(clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         "github",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		UserInfoURL:  "https://api.github.com/user",
+		Scopes:       []string{"read:user", "user:email"},
+		PKCE:         true,
+	}
+}
+
+// Discover 通过 issuer 的 openid-configuration 生成 provider
+func Discover(ctx context.Context, name, issuer, clientID, clientSecret string) (provider *Provider, err error) {
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodGet, strings.TrimRight(issuer, "/")+"/.well-known/openid-configuration", nil); err != nil {
+		return
+	}
+	var res *http.Response
+	if res, err = Client.Do(req.WithContext(ctx)); err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("oauth: discovery %s status %d", issuer, res.StatusCode)
+		return
+	}
+
+	var body struct {
+		AuthorizationEndpoint string   `json:"authorization_endpoint"`
+		TokenEndpoint         string   `json:"token_endpoint"`
+		UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+		CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return
+	}
+
+	provider = &Provider{
+		Name:         name,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      body.AuthorizationEndpoint,
+		TokenURL:     body.TokenEndpoint,
+		UserInfoURL:  body.UserinfoEndpoint,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+	for _, val := range body.CodeChallengeMethods {
+		if val == "S256" {
+			provider.PKCE = true
+		}
+	}
+	return
+}
+
+func (provider *Provider) authCodeURL(redirectURL, state, challenge string) string {
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", provider.ClientID)
+	values.Set("redirect_uri", redirectURL)
+	values.Set("state", state)
+	if len(provider.Scopes) != 0 {
+		values.Set("scope", strings.Join(provider.Scopes, " "))
+	}
+	if challenge != "" {
+		values.Set("code_challenge", challenge)
+		values.Set("code_challenge_method", "S256")
+	}
+	for key, val := range provider.Params {
+		values.Set(key, val)
+	}
+
+	if strings.Contains(provider.AuthURL, "?") {
+		return provider.AuthURL + "&" + values.Encode()
+	}
+	return provider.AuthURL + "?" + values.Encode()
+}
+
+func (provider *Provider) exchange(ctx context.Context, redirectURL, code, verifier string) (token *Token, err error) {
+	values := url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", code)
+	values.Set("redirect_uri", redirectURL)
+	values.Set("client_id", provider.ClientID)
+	values.Set("client_secret", provider.ClientSecret)
+	if verifier != "" {
+		values.Set("code_verifier", verifier)
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodPost, provider.TokenURL, strings.NewReader(values.Encode())); err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var res *http.Response
+	if res, err = Client.Do(req.WithContext(ctx)); err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	token = &Token{}
+	if err = json.NewDecoder(res.Body).Decode(token); err != nil {
+		token = nil
+		return
+	}
+	if token.Error != "" || token.AccessToken == "" || res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("oauth: token exchange %s status %d %s", provider.Name, res.StatusCode, token.Error)
+		token = nil
+	}
+	return
+}
+
+func (provider *Provider) userInfo(ctx context.Context, token *Token) (user *User, err error) {
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodGet, provider.UserInfoURL, nil); err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+
+	var res *http.Response
+	if res, err = Client.Do(req.WithContext(ctx)); err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("oauth: userinfo %s status %d", provider.Name, res.StatusCode)
+		return
+	}
+
+	raw := map[string]interface{}{}
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	if err = decoder.Decode(&raw); err != nil {
+		return
+	}
+
+	// oidc 使用 sub  github 使用 id login avatar_url
+	user = &User{
+		Provider: provider.Name,
+		ID:       stringValue(raw, "sub", "id"),
+		Email:    stringValue(raw, "email"),
+		Name:     stringValue(raw, "name", "login"),
+		Picture:  stringValue(raw, "picture", "avatar_url"),
+		Raw:      raw,
+		Token:    token,
+	}
+	if val, ok := raw["email_verified"].(bool); ok {
+		user.Verified = val
+	}
+	if user.ID == "" {
+		err = fmt.Errorf("oauth: userinfo %s missing id", provider.Name)
+		user = nil
+	}
+	return
+}
+
+func stringValue(raw map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		switch val := raw[key].(type) {
+		case string:
+			if val != "" {
+				return val
+			}
+		case json.Number:
+			return val.String()
+		case float64:
+			return strconv.FormatFloat(val, 'f', -1, 64)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/jwt"
+	"github.com/otamoe/gin-server/oauthserver"
+	"github.com/otamoe/gin-server/refreshtoken"
+)
+
+type (
+	// OAuthServer 签发 token  client_credentials password refresh_token
+	OAuthServer struct {
+		Path string `json:"path,omitempty"`
+
+		Issuer   string `json:"issuer,omitempty"`
+		Audience string `json:"audience,omitempty"`
+
+		// HS256
+		Secret string `json:"secret,omitempty"`
+
+		// RS256 ES256  PEM 私钥  PKCS1 PKCS8 或 EC
+		PrivateKey string `json:"private_key,omitempty"`
+		Kid        string `json:"kid,omitempty"`
+
+		AccessTTL  time.Duration `json:"access_ttl,omitempty"`
+		RefreshTTL time.Duration `json:"refresh_ttl,omitempty"`
+
+		// refresh token 轮换之后最长的有效期  0 不限制
+		RefreshMaxLifetime time.Duration `json:"refresh_max_lifetime,omitempty"`
+
+		// redis 或 mongo  默认 redis
+		RefreshStore string `json:"refresh_store,omitempty"`
+
+		Clients map[string]*OAuthClient `json:"clients,omitempty"`
+
+		// password grant
+		Authenticate func(ctx *gin.Context, username string, password string) (subject string, scopes []string, err error) `json:"-"`
+
+		server *oauthserver.Server
+	}
+
+	OAuthClient struct {
+		// 明文  或 auth/password 的 hash
+		Secret   string   `json:"secret"`
+		Scopes   []string `json:"scopes,omitempty"`
+		Grants   []string `json:"grants,omitempty"`
+		Audience string   `json:"audience,omitempty"`
+	}
+)
+
+func (config *OAuthServer) init(server *Server, handler *Handler) {
+	if config.server != nil {
+		return
+	}
+	if config.Path == "" {
+		config.Path = "/oauth"
+	}
+
+	signer := &jwt.Signer{
+		Secret: []byte(config.Secret),
+		Kid:    config.Kid,
+	}
+	if config.PrivateKey != "" {
+		block, _ := pem.Decode([]byte(config.PrivateKey))
+		if block == nil {
+			panic(errors.New("OAuth server: private key is not PEM encoded"))
+		}
+		signer.Key = parsePrivateKey(block.Bytes)
+	}
+
+	clients := map[string]*oauthserver.Client{}
+	for id, val := range config.Clients {
+		clients[id] = &oauthserver.Client{
+			Secret:   val.Secret,
+			Scopes:   val.Scopes,
+			Grants:   val.Grants,
+			Audience: val.Audience,
+		}
+	}
+	var store refreshtoken.Store = &refreshtoken.RedisStore{
+		Prefix: "oauthserver.refresh",
+	}
+	if config.RefreshStore == "mongo" {
+		store = &refreshtoken.MongoStore{}
+	}
+	config.server = oauthserver.New(oauthserver.Config{
+		Signer:       signer,
+		Issuer:       config.Issuer,
+		Audience:     config.Audience,
+		AccessTTL:    config.AccessTTL,
+		RefreshTTL:   config.RefreshTTL,
+		Clients:      clients,
+		Authenticate: config.Authenticate,
+		Refresh: refreshtoken.New(refreshtoken.Config{
+			Store:       store,
+			TTL:         config.RefreshTTL,
+			MaxLifetime: config.RefreshMaxLifetime,
+		}),
+	})
+}
+
+func (config *OAuthServer) Get() *oauthserver.Server {
+	return config.server
+}
+
+func parsePrivateKey(data []byte) crypto.Signer {
+	if key, err := x509.ParsePKCS1PrivateKey(data); err == nil {
+		return key
+	}
+	if key, err := x509.ParseECPrivateKey(data); err == nil {
+		return key
+	}
+	key, err := x509.ParsePKCS8PrivateKey(data)
+	if err != nil {
+		panic(err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		panic(errors.New("OAuth server: unsupported private key"))
+	}
+	return signer
+}
@@ -0,0 +1,597 @@
+package oauthserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/auth/password"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/jwt"
+	"github.com/otamoe/gin-server/loginguard"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+	"github.com/otamoe/gin-server/refreshtoken"
+)
+
+type (
+	Config struct {
+		Signer *jwt.Signer
+
+		Issuer string
+
+		// 默认 Issuer
+		Audience string
+
+		AccessTTL  time.Duration
+		RefreshTTL time.Duration
+
+		// 一次性的 refresh token  默认保存在 redis
+		Refresh *refreshtoken.Manager
+
+		// client id => client
+		Clients map[string]*Client
+
+		// password grant  返回 subject 和允许的 scope  为 nil 时不支持
+		Authenticate func(ctx *gin.Context, username string, password string) (subject string, scopes []string, err error)
+
+		// redis key 前缀
+		Prefix string
+	}
+
+	Client struct {
+		// 明文  或 auth/password 的 hash
+		Secret string
+
+		// 允许的 scope  为空时不限制
+		Scopes []string
+
+		// client_credentials password refresh_token  为空时只有 client_credentials
+		Grants []string
+
+		// 默认 Config.Audience
+		Audience string
+	}
+
+	// Token token 端点的响应  RFC 6749 5.1
+	Token struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		Scope        string `json:"scope,omitempty"`
+	}
+
+	Server struct {
+		config   Config
+		verifier *jwt.Verifier
+	}
+
+	// Error RFC 6749 5.2
+	Error struct {
+		StatusCode  int    `json:"-"`
+		Code        string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}
+)
+
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantPassword          = "password"
+	GrantRefreshToken      = "refresh_token"
+)
+
+var CONTEXT = "GIN.SERVER.OAUTHSERVER"
+
+var (
+	ErrInvalidRequest       = &Error{http.StatusBadRequest, "invalid_request", ""}
+	ErrInvalidClient        = &Error{http.StatusUnauthorized, "invalid_client", ""}
+	ErrInvalidGrant         = &Error{http.StatusBadRequest, "invalid_grant", ""}
+	ErrUnauthorizedClient   = &Error{http.StatusBadRequest, "unauthorized_client", ""}
+	ErrUnsupportedGrantType = &Error{http.StatusBadRequest, "unsupported_grant_type", ""}
+	ErrInvalidScope         = &Error{http.StatusBadRequest, "invalid_scope", ""}
+
+	// 资源服务器上 token 已撤销
+	ErrRevoked = &errs.Error{
+		Message:    "Token has been revoked",
+		Type:       "token",
+		StatusCode: http.StatusUnauthorized,
+	}
+
+	// 没有 redis 中间件或者 redis 错误时拒绝  不能确认 token 没有撤销
+	ErrRevocationUnavailable = &errs.Error{
+		Message:    "Token revocation cannot be checked",
+		Type:       "token",
+		StatusCode: http.StatusServiceUnavailable,
+	}
+)
+
+func New(c Config) *Server {
+	if c.Signer == nil {
+		panic("OAuth server: signer is empty")
+	}
+	if c.Issuer == "" {
+		panic("OAuth server: issuer is empty")
+	}
+	if c.Audience == "" {
+		c.Audience = c.Issuer
+	}
+	if c.AccessTTL == 0 {
+		c.AccessTTL = time.Hour
+	}
+	if c.RefreshTTL == 0 {
+		c.RefreshTTL = time.Hour * 24 * 30
+	}
+	if c.Prefix == "" {
+		c.Prefix = "oauthserver"
+	}
+	if c.Refresh == nil {
+		c.Refresh = refreshtoken.New(refreshtoken.Config{
+			Store: &refreshtoken.RedisStore{
+				Prefix: c.Prefix + ".refresh",
+			},
+			TTL: c.RefreshTTL,
+		})
+	}
+	return &Server{
+		config: c,
+		verifier: c.Signer.Verifier(jwt.Config{
+			Issuer: c.Issuer,
+		}),
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+func (e *Error) describe(description string) *Error {
+	return &Error{e.StatusCode, e.Code, description}
+}
+
+// Register POST /token  /introspect  /revoke  GET /jwks.json  需要 redis 中间件
+func (server *Server) Register(router gin.IRoutes) {
+	router.POST("/token", server.token)
+	router.POST("/introspect", server.introspect)
+	router.POST("/revoke", server.revoke)
+	router.GET("/jwks.json", server.jwks)
+}
+
+// Middleware 资源服务器上使用  在 jwt 中间件之后  拒绝已撤销的 access token  无法检查撤销时也拒绝
+func (server *Server) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, server)
+		claims := jwt.Get(ctx)
+		if claims == nil || claims.String("iss") != server.config.Issuer {
+			ctx.Next()
+			return
+		}
+		revoked, err := server.revoked(ctx, redisClient(ctx), claims.String("jti"))
+		// refresh token 撤销后  同一次登录的 access token 也失效
+		if family := claims.String("sid"); err == nil && !revoked && family != "" {
+			if revoked, err = server.config.Refresh.FamilyRevoked(ctx, family); err != nil {
+				err = ErrRevocationUnavailable
+			}
+		}
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		if revoked {
+			ctx.Error(ErrRevoked)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// Issue 签发 access token  有 refresh 时同时签发 refresh token  access token 的 sid 为 refresh token 的 family
+func (server *Server) Issue(ctx *gin.Context, clientID string, subject string, scope string, refresh bool) (token *Token, err error) {
+	var value string
+	var family string
+	if refresh {
+		var val *refreshtoken.Token
+		if value, val, err = server.config.Refresh.Issue(ctx, subject, clientID, scope, ""); err != nil {
+			return
+		}
+		family = val.Family
+	}
+	if token, err = server.issue(clientID, subject, scope, family); err != nil {
+		return
+	}
+	token.RefreshToken = value
+	return
+}
+
+func (server *Server) issue(clientID string, subject string, scope string, family string) (token *Token, err error) {
+	client := server.config.Clients[clientID]
+	audience := server.config.Audience
+	if client != nil && client.Audience != "" {
+		audience = client.Audience
+	}
+	now := time.Now()
+	claims := jwt.Claims{
+		"iss":       server.config.Issuer,
+		"sub":       subject,
+		"aud":       audience,
+		"iat":       now.Unix(),
+		"nbf":       now.Unix(),
+		"exp":       now.Add(server.config.AccessTTL).Unix(),
+		"jti":       random(16),
+		"client_id": clientID,
+	}
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	if family != "" {
+		claims["sid"] = family
+	}
+	token = &Token{
+		TokenType: "Bearer",
+		ExpiresIn: int64(server.config.AccessTTL / time.Second),
+		Scope:     scope,
+	}
+	token.AccessToken, err = server.config.Signer.Sign(claims)
+	return
+}
+
+func (server *Server) token(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+
+	clientID, client, err := server.authenticateClient(ctx)
+	if err != nil {
+		server.fail(ctx, err)
+		return
+	}
+	grant := ctx.PostForm("grant_type")
+	if grant == "" {
+		server.fail(ctx, ErrInvalidRequest.describe("grant_type is required"))
+		return
+	}
+	if !client.allowGrant(grant) {
+		server.fail(ctx, ErrUnauthorizedClient)
+		return
+	}
+
+	var token *Token
+	switch grant {
+	case GrantClientCredentials:
+		var scope string
+		if scope, err = scopes(ctx.PostForm("scope"), client.Scopes, len(client.Scopes) != 0); err == nil {
+			token, err = server.Issue(ctx, clientID, clientID, scope, false)
+		}
+	case GrantPassword:
+		token, err = server.password(ctx, clientID, client)
+	case GrantRefreshToken:
+		token, err = server.refresh(ctx, clientID)
+	default:
+		err = ErrUnsupportedGrantType
+	}
+	if err != nil {
+		server.fail(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, token)
+}
+
+func (server *Server) password(ctx *gin.Context, clientID string, client *Client) (token *Token, err error) {
+	if server.config.Authenticate == nil {
+		return nil, ErrUnsupportedGrantType
+	}
+	username := ctx.PostForm("username")
+	if username == "" || ctx.PostForm("password") == "" {
+		return nil, ErrInvalidRequest.describe("username and password are required")
+	}
+	guard := loginguard.Get(ctx)
+	if guard != nil {
+		if err = guard.Check(ctx, username); err != nil {
+			return
+		}
+	}
+	subject, allowed, err := server.config.Authenticate(ctx, username, ctx.PostForm("password"))
+	if err != nil || subject == "" {
+		if guard != nil {
+			guard.Fail(ctx, username)
+		}
+		if err == nil {
+			err = ErrInvalidGrant.describe("invalid username or password")
+		}
+		return
+	}
+	if guard != nil {
+		guard.Success(ctx, username)
+	}
+	restricted := allowed != nil || len(client.Scopes) != 0
+	switch {
+	case allowed == nil:
+		allowed = client.Scopes
+	case len(client.Scopes) != 0:
+		allowed = intersect(allowed, client.Scopes)
+	}
+	scope, err := scopes(ctx.PostForm("scope"), allowed, restricted)
+	if err != nil {
+		return
+	}
+	return server.Issue(ctx, clientID, subject, scope, client.allowGrant(GrantRefreshToken))
+}
+
+// refresh 一次性使用  重复使用时整个 family 撤销
+func (server *Server) refresh(ctx *gin.Context, clientID string) (token *Token, err error) {
+	value := ctx.PostForm("refresh_token")
+	if value == "" {
+		return nil, ErrInvalidRequest.describe("refresh_token is required")
+	}
+	current, err := server.config.Refresh.Find(ctx, value)
+	if err != nil {
+		return
+	}
+	if current != nil && current.ClientID != clientID {
+		return nil, ErrInvalidGrant
+	}
+	// 只能缩小 scope
+	var scope string
+	if current != nil {
+		scope = current.Scope
+		if val := ctx.PostForm("scope"); val != "" {
+			if scope, err = scopes(val, strings.Fields(current.Scope), true); err != nil {
+				return
+			}
+		}
+	}
+	// 已使用的也需要 Rotate  用于检测重复使用
+	value, rotated, err := server.config.Refresh.Rotate(ctx, value)
+	if err != nil {
+		if err == refreshtoken.ErrInvalid || err == refreshtoken.ErrReused {
+			err = ErrInvalidGrant
+		}
+		return
+	}
+	if rotated.ClientID != clientID {
+		server.config.Refresh.RevokeFamily(ctx, rotated.Family)
+		return nil, ErrInvalidGrant
+	}
+	if current == nil {
+		scope = rotated.Scope
+	}
+	if token, err = server.issue(clientID, rotated.Subject, scope, rotated.Family); err != nil {
+		return
+	}
+	token.RefreshToken = value
+	return
+}
+
+// introspect RFC 7662  需要 client 认证
+func (server *Server) introspect(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	if _, _, err := server.authenticateClient(ctx); err != nil {
+		server.fail(ctx, err)
+		return
+	}
+	value := ctx.PostForm("token")
+	if value == "" {
+		server.fail(ctx, ErrInvalidRequest.describe("token is required"))
+		return
+	}
+	inactive := gin.H{"active": false}
+
+	if ctx.PostForm("token_type_hint") != GrantRefreshToken {
+		if claims, err := server.verifier.Verify(value); err == nil {
+			revoked, err := server.revoked(ctx, redisClient(ctx), claims.String("jti"))
+			if err != nil {
+				server.fail(ctx, err)
+				return
+			}
+			if revoked {
+				ctx.JSON(http.StatusOK, inactive)
+				return
+			}
+			result := gin.H{"active": true, "token_type": "Bearer"}
+			for _, name := range []string{"iss", "sub", "aud", "exp", "iat", "nbf", "jti", "client_id", "scope"} {
+				if val, ok := claims[name]; ok {
+					result[name] = val
+				}
+			}
+			ctx.JSON(http.StatusOK, result)
+			return
+		}
+	}
+
+	refresh, err := server.config.Refresh.Find(ctx, value)
+	if err != nil {
+		server.fail(ctx, err)
+		return
+	}
+	if refresh == nil {
+		ctx.JSON(http.StatusOK, inactive)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"active":     true,
+		"token_type": GrantRefreshToken,
+		"client_id":  refresh.ClientID,
+		"sub":        refresh.Subject,
+		"scope":      refresh.Scope,
+		"exp":        refresh.ExpiresAt.Unix(),
+	})
+}
+
+// revoke RFC 7009  未知的 token 也返回 200
+func (server *Server) revoke(ctx *gin.Context) {
+	clientID, _, err := server.authenticateClient(ctx)
+	if err != nil {
+		server.fail(ctx, err)
+		return
+	}
+	value := ctx.PostForm("token")
+	if value == "" {
+		server.fail(ctx, ErrInvalidRequest.describe("token is required"))
+		return
+	}
+	client := redisClient(ctx)
+	if client == nil {
+		server.fail(ctx, &Error{http.StatusServiceUnavailable, "temporarily_unavailable", ""})
+		return
+	}
+
+	if claims, err := server.verifier.Verify(value); err == nil {
+		if claims.String("client_id") == clientID {
+			if exp, ok := claims["exp"].(json.Number); ok {
+				if val, e := exp.Int64(); e == nil {
+					if ttl := time.Until(time.Unix(val, 0)); ttl > 0 {
+						client.Set(server.key(ctx, "revoked."+claims.String("jti")), "1", ttl)
+					}
+				}
+			}
+		}
+		ctx.Status(http.StatusOK)
+		return
+	}
+
+	refresh, err := server.config.Refresh.Find(ctx, value)
+	if err != nil {
+		server.fail(ctx, err)
+		return
+	}
+	if refresh != nil && refresh.ClientID == clientID {
+		if err = server.config.Refresh.RevokeFamily(ctx, refresh.Family); err != nil {
+			server.fail(ctx, err)
+			return
+		}
+	}
+	ctx.Status(http.StatusOK)
+}
+
+func (server *Server) jwks(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=3600")
+	ctx.JSON(http.StatusOK, server.config.Signer.JWKS())
+}
+
+// authenticateClient Basic 认证  或 client_id client_secret 表单
+func (server *Server) authenticateClient(ctx *gin.Context) (clientID string, client *Client, err error) {
+	clientID, secret, ok := ctx.Request.BasicAuth()
+	if !ok {
+		clientID = ctx.PostForm("client_id")
+		secret = ctx.PostForm("client_secret")
+	}
+	if clientID == "" {
+		ctx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		err = ErrInvalidClient
+		return
+	}
+	client = server.config.Clients[clientID]
+	if client == nil || !client.verify(secret) {
+		ctx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		err = ErrInvalidClient
+		return
+	}
+	return
+}
+
+func (server *Server) fail(ctx *gin.Context, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	ctx.AbortWithStatusJSON(e.StatusCode, e)
+}
+
+// revoked 没有 redis 或者 redis 错误时返回 ErrRevocationUnavailable
+func (server *Server) revoked(ctx *gin.Context, client *redis.Client, jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+	if client == nil {
+		return false, ErrRevocationUnavailable
+	}
+	n, err := client.Exists(server.key(ctx, "revoked."+jti)).Result()
+	if err != nil {
+		return false, ErrRevocationUnavailable
+	}
+	return n != 0, nil
+}
+
+func (server *Server) key(ctx *gin.Context, key string) string {
+	return redisMiddleware.Key(ctx, server.config.Prefix+"."+key)
+}
+
+func (client *Client) allowGrant(grant string) bool {
+	if len(client.Grants) == 0 {
+		return grant == GrantClientCredentials
+	}
+	for _, val := range client.Grants {
+		if val == grant {
+			return true
+		}
+	}
+	return false
+}
+
+func (client *Client) verify(secret string) bool {
+	if strings.HasPrefix(client.Secret, "$") {
+		ok, _, _ := password.Verify(secret, client.Secret)
+		return ok
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) == 1
+}
+
+// scopes restricted 时请求的 scope 需要都在 allowed 中  请求为空时为 allowed
+func scopes(requested string, allowed []string, restricted bool) (string, error) {
+	values := strings.Fields(requested)
+	if len(values) == 0 {
+		return strings.Join(allowed, " "), nil
+	}
+	if !restricted {
+		return strings.Join(values, " "), nil
+	}
+	for _, val := range values {
+		var ok bool
+		for _, a := range allowed {
+			if a == val {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return "", ErrInvalidScope.describe(val)
+		}
+	}
+	return strings.Join(values, " "), nil
+}
+
+func intersect(a []string, b []string) (values []string) {
+	for _, val := range a {
+		for _, v := range b {
+			if val == v {
+				values = append(values, val)
+				break
+			}
+		}
+	}
+	return
+}
+
+func redisClient(ctx *gin.Context) *redis.Client {
+	if val, ok := ctx.Get(redisMiddleware.CONTEXT); ok && val != nil {
+		return val.(*redis.Client)
+	}
+	return nil
+}
+
+func random(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
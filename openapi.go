@@ -0,0 +1,80 @@
+package server
+
+import (
+	"io/ioutil"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/openapi"
+	"github.com/otamoe/gin-server/version"
+)
+
+type (
+	// OpenAPI 每个 handler 输出自己的路由
+	OpenAPI struct {
+		Path string `json:"path,omitempty"`
+
+		// 为空不输出 swagger ui
+		UIPath string `json:"ui_path,omitempty"`
+
+		Title       string   `json:"title,omitempty"`
+		Version     string   `json:"version,omitempty"`
+		Description string   `json:"description,omitempty"`
+		Servers     []string `json:"servers,omitempty"`
+
+		// 文件  设置后检查请求  development test 时检查响应
+		Spec string `json:"spec,omitempty"`
+
+		spec []byte
+	}
+)
+
+func (config *OpenAPI) init(server *Server, handler *Handler) {
+	if config.Path == "" {
+		config.Path = "/openapi.json"
+	}
+	if config.Title == "" {
+		if handler != nil && handler.Name != "" {
+			config.Title = handler.Name
+		} else {
+			config.Title = server.Name
+		}
+	}
+	if config.Version == "" {
+		config.Version = version.Get().Version
+	}
+	if config.Version == "" {
+		config.Version = "0.0.0"
+	}
+	if config.Spec != "" && config.spec == nil {
+		data, err := ioutil.ReadFile(config.Spec)
+		if err != nil {
+			panic(err)
+		}
+		config.spec = data
+	}
+}
+
+// middleware 没有 Spec 时为 nil
+func (config *OpenAPI) middleware(server *Server) gin.HandlerFunc {
+	if config.spec == nil {
+		return nil
+	}
+	return openapi.ValidateMiddleware(openapi.ValidateConfig{
+		Document:  config.spec,
+		Responses: server.ENV == "development" || server.ENV == "test",
+	})
+}
+
+func (config *OpenAPI) register(handler *Handler) {
+	c := openapi.Config{
+		Title:       config.Title,
+		Version:     config.Version,
+		Description: config.Description,
+		Servers:     config.Servers,
+		Skip:        []string{config.Path, config.UIPath},
+	}
+	handler.gin.GET(config.Path, openapi.Serve(c, handler.gin.Routes))
+	if config.UIPath != "" {
+		handler.gin.GET(config.UIPath, openapi.UI(config.Path))
+	}
+}
@@ -0,0 +1,254 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/otamoe/gin-server/utils"
+)
+
+type (
+	Config struct {
+		Title       string
+		Version     string
+		Description string
+		Servers     []string
+
+		// 不输出的路径  例如 spec 和 ui 自己
+		Skip []string
+	}
+
+	// Operation Request Query Response 为 binding struct  通过反射生成 schema
+	Operation struct {
+		Summary     string
+		Description string
+		Tags        []string
+		Deprecated  bool
+
+		// json body
+		Request interface{}
+		// form tag 的 query 参数
+		Query interface{}
+		// 200 201 的响应
+		Response interface{}
+		// 默认 GET 200  POST 201  DELETE 204
+		StatusCode int
+	}
+
+	Document struct {
+		OpenAPI    string                            `json:"openapi"`
+		Info       map[string]string                 `json:"info"`
+		Servers    []map[string]string               `json:"servers,omitempty"`
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components map[string]map[string]Schema      `json:"components,omitempty"`
+	}
+
+	Schema map[string]interface{}
+)
+
+var operations = sync.Map{}
+
+// Handler 注册 handler 的 operation  同 resource.Handler
+func Handler(handler gin.HandlerFunc, operation Operation) {
+	key := reflect.ValueOf(handler)
+	if _, ok := operations.Load(key); ok {
+		panic("OpenAPI: " + utils.NameOfFunction(handler) + " has exists")
+	}
+	operations.Store(key, operation)
+}
+
+// Build 根据路由生成  operationId 为 resource 的 type.action
+func Build(c Config, routes gin.RoutesInfo) *Document {
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info: map[string]string{
+			"title":   c.Title,
+			"version": c.Version,
+		},
+		Components: map[string]map[string]Schema{
+			"schemas": map[string]Schema{},
+		},
+		Paths: map[string]map[string]interface{}{},
+	}
+	if c.Description != "" {
+		doc.Info["description"] = c.Description
+	}
+	for _, val := range c.Servers {
+		doc.Servers = append(doc.Servers, map[string]string{"url": val})
+	}
+	skip := map[string]bool{}
+	for _, val := range c.Skip {
+		skip[val] = true
+	}
+
+	schemas := doc.Components["schemas"]
+	for _, route := range routes {
+		if skip[route.Path] || route.Method == http.MethodHead {
+			continue
+		}
+		var operation Operation
+		if route.HandlerFunc != nil {
+			if val, ok := operations.Load(reflect.ValueOf(route.HandlerFunc)); ok && val != nil {
+				operation = val.(Operation)
+			}
+		}
+		name := ginResource.RouteName(route)
+		item := map[string]interface{}{
+			"operationId": name,
+		}
+		tags := operation.Tags
+		if len(tags) == 0 {
+			tags = []string{strings.Split(name, ".")[0]}
+		}
+		item["tags"] = tags
+		if operation.Summary != "" {
+			item["summary"] = operation.Summary
+		}
+		if operation.Description != "" {
+			item["description"] = operation.Description
+		}
+		if operation.Deprecated {
+			item["deprecated"] = true
+		}
+
+		var parameters []Schema
+		routePath, params := Path(route.Path)
+		for _, param := range params {
+			parameters = append(parameters, Schema{
+				"name":     param,
+				"in":       "path",
+				"required": true,
+				"schema":   Schema{"type": "string"},
+			})
+		}
+		if operation.Query != nil {
+			parameters = append(parameters, queryParameters(reflect.TypeOf(operation.Query), schemas)...)
+		}
+		if len(parameters) != 0 {
+			item["parameters"] = parameters
+		}
+		if operation.Request != nil {
+			item["requestBody"] = Schema{
+				"required": true,
+				"content": Schema{
+					"application/json": Schema{"schema": TypeSchema(reflect.TypeOf(operation.Request), schemas)},
+				},
+			}
+		}
+
+		statusCode := operation.StatusCode
+		if statusCode == 0 {
+			switch route.Method {
+			case http.MethodPost:
+				statusCode = http.StatusCreated
+			case http.MethodDelete:
+				statusCode = http.StatusNoContent
+			default:
+				statusCode = http.StatusOK
+			}
+		}
+		response := Schema{"description": http.StatusText(statusCode)}
+		if operation.Response != nil && statusCode != http.StatusNoContent {
+			response["content"] = Schema{
+				"application/json": Schema{"schema": TypeSchema(reflect.TypeOf(operation.Response), schemas)},
+			}
+		}
+		item["responses"] = Schema{
+			strconv.Itoa(statusCode): response,
+			"default":                Schema{"$ref": "#/components/responses/Error"},
+		}
+
+		if doc.Paths[routePath] == nil {
+			doc.Paths[routePath] = map[string]interface{}{}
+		}
+		doc.Paths[routePath][strings.ToLower(route.Method)] = item
+	}
+
+	// errs.Errors
+	schemas["Error"] = Schema{
+		"type": "object",
+		"properties": Schema{
+			"message":     Schema{"type": "string"},
+			"type":        Schema{"type": "string"},
+			"path":        Schema{"type": "string"},
+			"status_code": Schema{"type": "integer"},
+		},
+	}
+	schemas["Errors"] = Schema{
+		"type": "object",
+		"properties": Schema{
+			"errors":      Schema{"type": "array", "items": Schema{"$ref": "#/components/schemas/Error"}},
+			"errors_text": Schema{"type": "string"},
+			"status_code": Schema{"type": "integer"},
+			"request_id":  Schema{"type": "string"},
+		},
+	}
+	doc.Components["responses"] = map[string]Schema{
+		"Error": Schema{
+			"description": "Error",
+			"content": Schema{
+				"application/json": Schema{"schema": Schema{"$ref": "#/components/schemas/Errors"}},
+			},
+		},
+	}
+	return doc
+}
+
+// Path gin 的 :id *path 转为 {id} {path}
+func Path(routePath string) (string, []string) {
+	var params []string
+	segments := strings.Split(routePath, "/")
+	for i, segment := range segments {
+		if segment == "" || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+		params = append(params, segment[1:])
+		segments[i] = "{" + segment[1:] + "}"
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// Serve 第一次请求时生成  之后不再变化
+func Serve(c Config, routes func() gin.RoutesInfo) gin.HandlerFunc {
+	var once sync.Once
+	var data []byte
+	var err error
+	return func(ctx *gin.Context) {
+		once.Do(func() {
+			data, err = json.Marshal(Build(c, routes()))
+		})
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		ctx.Data(http.StatusOK, "application/json; charset=utf-8", data)
+	}
+}
+
+// UI swagger ui  静态文件来自 unpkg
+func UI(specURL string) gin.HandlerFunc {
+	page := []byte(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@3/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@3/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: ` + strconv.Quote(specURL) + `, dom_id: "#swagger-ui"})</script>
+</body>
+</html>
+`)
+	return func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", page)
+	}
+}
@@ -0,0 +1,196 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(bson.ObjectId(""))
+)
+
+// TypeSchema 有名字的 struct 放到 components  json tag 为属性名  binding tag 生成 required 和限制
+func TypeSchema(typ reflect.Type, schemas map[string]Schema) Schema {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ {
+	case timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	case objectIDType:
+		return Schema{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return Schema{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return Schema{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return Schema{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return Schema{"type": "number", "format": "double"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "format": "byte"}
+		}
+		return Schema{"type": "array", "items": TypeSchema(typ.Elem(), schemas)}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": TypeSchema(typ.Elem(), schemas)}
+	case reflect.Struct:
+		if typ.Name() == "" {
+			return structSchema(typ, schemas)
+		}
+		name := typ.Name()
+		if _, ok := schemas[name]; !ok {
+			// 先占位  避免递归
+			schemas[name] = Schema{}
+			schemas[name] = structSchema(typ, schemas)
+		}
+		return Schema{"$ref": "#/components/schemas/" + name}
+	}
+	return Schema{}
+}
+
+func structSchema(typ reflect.Type, schemas map[string]Schema) Schema {
+	properties := Schema{}
+	var required []string
+	fields(typ, func(field reflect.StructField, name string) {
+		schema := TypeSchema(field.Type, schemas)
+		if rules(schema, field.Tag.Get("binding")) {
+			required = append(required, name)
+		}
+		properties[name] = schema
+	}, "json")
+	schema := Schema{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) != 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func queryParameters(typ reflect.Type, schemas map[string]Schema) (parameters []Schema) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return
+	}
+	fields(typ, func(field reflect.StructField, name string) {
+		schema := TypeSchema(field.Type, schemas)
+		parameters = append(parameters, Schema{
+			"name":     name,
+			"in":       "query",
+			"required": rules(schema, field.Tag.Get("binding")),
+			"schema":   schema,
+		})
+	}, "form")
+	return
+}
+
+// fields 展开匿名字段  跳过 - 和未导出的
+func fields(typ reflect.Type, fn func(field reflect.StructField, name string), tagName string) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get(tagName)
+		if tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				fields(embedded, fn, tagName)
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fn(field, name)
+	}
+}
+
+// rules validator 的常用规则  返回是否 required
+func rules(schema Schema, binding string) (required bool) {
+	if binding == "" || binding == "-" {
+		return
+	}
+	_, isRef := schema["$ref"]
+	for _, rule := range strings.Split(binding, ",") {
+		key, value := rule, ""
+		if index := strings.Index(rule, "="); index != -1 {
+			key, value = rule[:index], rule[index+1:]
+		}
+		if isRef {
+			if key == "required" {
+				required = true
+			}
+			continue
+		}
+		switch key {
+		case "required":
+			required = true
+		case "email":
+			schema["format"] = "email"
+		case "url", "uri":
+			schema["format"] = "uri"
+		case "uuid", "uuid4":
+			schema["format"] = "uuid"
+		case "oneof":
+			var enum []string
+			for _, val := range strings.Fields(value) {
+				enum = append(enum, val)
+			}
+			schema["enum"] = enum
+		case "min", "max", "gte", "lte", "len":
+			limit(schema, key, value)
+		}
+	}
+	return
+}
+
+func limit(schema Schema, key string, value string) {
+	n, err := parseNumber(value)
+	if err != nil {
+		return
+	}
+	var names []string
+	switch schema["type"] {
+	case "string":
+		names = []string{"minLength", "maxLength"}
+	case "array":
+		names = []string{"minItems", "maxItems"}
+	case "integer", "number":
+		names = []string{"minimum", "maximum"}
+	default:
+		return
+	}
+	switch key {
+	case "min", "gte":
+		schema[names[0]] = n
+	case "max", "lte":
+		schema[names[1]] = n
+	case "len":
+		schema[names[0]] = n
+		schema[names[1]] = n
+	}
+}
+
+func parseNumber(value string) (float64, error) {
+	return strconv.ParseFloat(value, 64)
+}
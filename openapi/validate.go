@@ -0,0 +1,579 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	ValidateConfig struct {
+		// []byte  *Document 或解析后的 map
+		Document interface{}
+
+		// 检查响应  只应在 development test 使用  需要缓冲响应
+		Responses bool
+
+		// 超过后不检查 body  默认 1M
+		MaxBody int
+	}
+
+	// Validator 只支持 json schema 常用的部分
+	Validator struct {
+		doc    map[string]interface{}
+		routes []*route
+	}
+
+	route struct {
+		method    string
+		pattern   *regexp.Regexp
+		names     []string
+		operation map[string]interface{}
+		params    []interface{}
+	}
+
+	readCloser struct {
+		io.Reader
+		io.Closer
+	}
+
+	validateWriter struct {
+		gin.ResponseWriter
+		buf    bytes.Buffer
+		status int
+	}
+)
+
+var ErrRequest = &errs.Error{
+	Message:    "Request does not match the API specification",
+	Type:       "openapi",
+	StatusCode: http.StatusBadRequest,
+}
+
+var ErrResponse = &errs.Error{
+	Message:    "Response does not match the API specification",
+	Type:       "openapi",
+	StatusCode: http.StatusInternalServerError,
+}
+
+var (
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	paramPattern = regexp.MustCompile(`\{([^/}]+)\}`)
+)
+
+// ValidateMiddleware 在 errs 之后  不在文档中的路由不检查
+func ValidateMiddleware(c ValidateConfig) gin.HandlerFunc {
+	if c.MaxBody == 0 {
+		c.MaxBody = 1024 * 1024
+	}
+	validator, err := NewValidator(c.Document)
+	if err != nil {
+		panic(err)
+	}
+	return func(ctx *gin.Context) {
+		route, pathParams := validator.find(ctx.Request.Method, ctx.Request.URL.Path)
+		if route == nil {
+			ctx.Next()
+			return
+		}
+		if violations := validator.request(ctx.Request, route, pathParams, c.MaxBody); len(violations) != 0 {
+			for _, reason := range violations {
+				ctx.Error(violation(ErrRequest, reason))
+			}
+			ctx.Abort()
+			return
+		}
+		if !c.Responses {
+			ctx.Next()
+			return
+		}
+
+		writer := &validateWriter{
+			ResponseWriter: ctx.Writer,
+			status:         http.StatusOK,
+		}
+		ctx.Writer = writer
+		ctx.Next()
+		ctx.Writer = writer.ResponseWriter
+
+		// handler 已经返回错误时由 errs 输出
+		if len(ctx.Errors) == 0 && writer.buf.Len() <= c.MaxBody {
+			if violations := validator.response(route, writer.status, writer.Header().Get("Content-Type"), writer.buf.Bytes()); len(violations) != 0 {
+				for _, reason := range violations {
+					ctx.Error(violation(ErrResponse, reason))
+				}
+				ctx.Abort()
+				return
+			}
+		}
+		ctx.Writer.WriteHeader(writer.status)
+		if writer.buf.Len() != 0 {
+			ctx.Writer.Write(writer.buf.Bytes())
+		} else {
+			ctx.Writer.WriteHeaderNow()
+		}
+	}
+}
+
+func NewValidator(document interface{}) (*Validator, error) {
+	var data []byte
+	switch val := document.(type) {
+	case []byte:
+		data = val
+	case map[string]interface{}:
+		data, _ = json.Marshal(val)
+	case nil:
+		return nil, errors.New("OpenAPI: document is empty")
+	default:
+		var err error
+		if data, err = json.Marshal(val); err != nil {
+			return nil, err
+		}
+	}
+	validator := &Validator{}
+	if err := json.Unmarshal(data, &validator.doc); err != nil {
+		return nil, err
+	}
+	paths, _ := validator.doc["paths"].(map[string]interface{})
+	for routePath, item := range paths {
+		item, _ := item.(map[string]interface{})
+		var names []string
+		expr := "^" + paramPattern.ReplaceAllStringFunc(regexp.QuoteMeta(routePath), func(match string) string {
+			names = append(names, strings.Trim(match, `\{}`))
+			return "([^/]+)"
+		}) + "$"
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		params, _ := item["parameters"].([]interface{})
+		for method, operation := range item {
+			operation, ok := operation.(map[string]interface{})
+			if !ok || method == "parameters" {
+				continue
+			}
+			validator.routes = append(validator.routes, &route{
+				method:    strings.ToUpper(method),
+				pattern:   pattern,
+				names:     names,
+				operation: operation,
+				params:    params,
+			})
+		}
+	}
+	// 固定路径优先于参数
+	sort.SliceStable(validator.routes, func(i, j int) bool {
+		return len(validator.routes[i].names) < len(validator.routes[j].names)
+	})
+	return validator, nil
+}
+
+func (validator *Validator) find(method string, urlPath string) (*route, map[string]string) {
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	for _, route := range validator.routes {
+		if route.method != method {
+			continue
+		}
+		matches := route.pattern.FindStringSubmatch(urlPath)
+		if matches == nil {
+			continue
+		}
+		params := map[string]string{}
+		for i, name := range route.names {
+			params[name] = matches[i+1]
+		}
+		return route, params
+	}
+	return nil, nil
+}
+
+func (validator *Validator) request(req *http.Request, route *route, pathParams map[string]string, maxBody int) (violations []string) {
+	params, _ := route.operation["parameters"].([]interface{})
+	for _, param := range append(route.params, params...) {
+		param, _ := validator.resolve(param).(map[string]interface{})
+		name, _ := param["name"].(string)
+		required, _ := param["required"].(bool)
+		schema, _ := param["schema"].(map[string]interface{})
+		var values []string
+		switch param["in"] {
+		case "path":
+			if val, ok := pathParams[name]; ok {
+				values = []string{val}
+			}
+		case "query":
+			values = req.URL.Query()[name]
+		case "header":
+			values = req.Header[http.CanonicalHeaderKey(name)]
+		default:
+			continue
+		}
+		if len(values) == 0 {
+			if required {
+				violations = append(violations, name+": is required")
+			}
+			continue
+		}
+		violations = append(violations, validator.validate(schema, parseParam(validator.resolveSchema(schema), values), name)...)
+	}
+
+	body, _ := validator.resolve(route.operation["requestBody"]).(map[string]interface{})
+	if body == nil {
+		return
+	}
+	schema := validator.contentSchema(body, req.Header.Get("Content-Type"))
+	if schema == nil || req.Body == nil || (req.ContentLength > int64(maxBody)) {
+		return
+	}
+	// 没有 Content-Length 时最多读取 maxBody+1  超过时放回不检查  路由的 size 限制由 size 中间件的 Reader 检查
+	data, err := ioutil.ReadAll(io.LimitReader(req.Body, int64(maxBody)+1))
+	if len(data) > maxBody {
+		req.Body = readCloser{io.MultiReader(bytes.NewReader(data), req.Body), req.Body}
+		return
+	}
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return append(violations, "body: "+err.Error())
+	}
+	if len(data) == 0 {
+		if required, _ := body["required"].(bool); required {
+			violations = append(violations, "body: is required")
+		}
+		return
+	}
+	value, err := decode(data)
+	if err != nil {
+		return append(violations, "body: invalid json")
+	}
+	return append(violations, validator.validate(schema, value, "body")...)
+}
+
+func (validator *Validator) response(route *route, status int, contentType string, data []byte) (violations []string) {
+	responses, _ := route.operation["responses"].(map[string]interface{})
+	if len(responses) == 0 {
+		return
+	}
+	response, ok := responses[strconv.Itoa(status)]
+	if !ok {
+		response, ok = responses[strconv.Itoa(status/100)+"XX"]
+	}
+	if !ok {
+		if response, ok = responses["default"]; !ok {
+			return []string{"status: " + strconv.Itoa(status) + " is not documented"}
+		}
+	}
+	value, _ := validator.resolve(response).(map[string]interface{})
+	schema := validator.contentSchema(value, contentType)
+	if schema == nil || len(data) == 0 {
+		return
+	}
+	body, err := decode(data)
+	if err != nil {
+		return []string{"body: invalid json"}
+	}
+	return validator.validate(schema, body, "body")
+}
+
+// contentSchema 只检查 json
+func (validator *Validator) contentSchema(value map[string]interface{}, contentType string) map[string]interface{} {
+	content, _ := value["content"].(map[string]interface{})
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+	item, ok := content[mediaType].(map[string]interface{})
+	if !ok {
+		item, _ = content["application/json"].(map[string]interface{})
+	}
+	schema, _ := item["schema"].(map[string]interface{})
+	return schema
+}
+
+func (validator *Validator) resolveSchema(schema map[string]interface{}) map[string]interface{} {
+	val, _ := validator.resolve(schema).(map[string]interface{})
+	return val
+}
+
+// resolve 只支持本文档的 $ref
+func (validator *Validator) resolve(value interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		val, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		ref, ok := val["$ref"].(string)
+		if !ok || !strings.HasPrefix(ref, "#/") {
+			return value
+		}
+		var current interface{} = validator.doc
+		for _, key := range strings.Split(ref[2:], "/") {
+			key = strings.Replace(strings.Replace(key, "~1", "/", -1), "~0", "~", -1)
+			m, _ := current.(map[string]interface{})
+			current = m[key]
+		}
+		value = current
+	}
+	return value
+}
+
+func (validator *Validator) validate(schema map[string]interface{}, value interface{}, path string) (violations []string) {
+	schema = validator.resolveSchema(schema)
+	if schema == nil {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		violations = append(violations, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); !nullable && schema["type"] != nil {
+			fail("must not be null")
+		}
+		return
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, val := range all {
+			val, _ := val.(map[string]interface{})
+			violations = append(violations, validator.validate(val, value, path)...)
+		}
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		if any, ok := schema[key].([]interface{}); ok {
+			matched := 0
+			for _, val := range any {
+				val, _ := val.(map[string]interface{})
+				if len(validator.validate(val, value, path)) == 0 {
+					matched++
+				}
+			}
+			if matched == 0 || (key == "oneOf" && matched > 1) {
+				fail("must match %s", key)
+			}
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, val := range enum {
+			if fmt.Sprint(val) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %v", enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fail("must be object")
+			return
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := object[fmt.Sprint(name)]; !ok {
+					violations = append(violations, path+"."+fmt.Sprint(name)+": is required")
+				}
+			}
+		}
+		for name, val := range object {
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				violations = append(violations, validator.validate(property, val, path+"."+name)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					violations = append(violations, path+"."+name+": is not allowed")
+				}
+			case map[string]interface{}:
+				violations = append(violations, validator.validate(additional, val, path+"."+name)...)
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			fail("must be array")
+			return
+		}
+		if n, ok := number(schema["minItems"]); ok && float64(len(array)) < n {
+			fail("must have at least %v items", n)
+		}
+		if n, ok := number(schema["maxItems"]); ok && float64(len(array)) > n {
+			fail("must have at most %v items", n)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, val := range array {
+				violations = append(violations, validator.validate(items, val, path+"."+strconv.Itoa(i))...)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			fail("must be string")
+			return
+		}
+		length := float64(len([]rune(str)))
+		if n, ok := number(schema["minLength"]); ok && length < n {
+			fail("must be at least %v characters", n)
+		}
+		if n, ok := number(schema["maxLength"]); ok && length > n {
+			fail("must be at most %v characters", n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(str) {
+				fail("must match %s", pattern)
+			}
+		}
+		switch schema["format"] {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				fail("must be date-time")
+			}
+		case "date":
+			if _, err := time.Parse("2006-01-02", str); err != nil {
+				fail("must be date")
+			}
+		case "email":
+			if !strings.Contains(str, "@") {
+				fail("must be email")
+			}
+		case "uuid":
+			if !uuidPattern.MatchString(str) {
+				fail("must be uuid")
+			}
+		}
+	case "integer", "number":
+		n, ok := number(value)
+		if !ok {
+			fail("must be %s", schema["type"])
+			return
+		}
+		if schema["type"] == "integer" && n != float64(int64(n)) {
+			fail("must be integer")
+		}
+		if min, ok := number(schema["minimum"]); ok && n < min {
+			fail("must be at least %v", min)
+		}
+		if max, ok := number(schema["maximum"]); ok && n > max {
+			fail("must be at most %v", max)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be boolean")
+		}
+	}
+	return
+}
+
+func violation(base *errs.Error, reason string) *errs.Error {
+	e := base.Clone()
+	if index := strings.Index(reason, ": "); index != -1 {
+		e.Path = reason[:index]
+		reason = reason[index+2:]
+	}
+	e.Params = map[string]interface{}{"reason": reason}
+	return e
+}
+
+// parseParam 按 schema 的类型转换  失败时保留字符串由 validate 报错
+func parseParam(schema map[string]interface{}, values []string) interface{} {
+	if schema != nil && schema["type"] == "array" {
+		if len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		array := make([]interface{}, 0, len(values))
+		for _, val := range values {
+			array = append(array, parseParam(items, []string{val}))
+		}
+		return array
+	}
+	value := values[0]
+	if schema == nil {
+		return value
+	}
+	switch schema["type"] {
+	case "integer", "number":
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+func decode(data []byte) (value interface{}, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&value)
+	return
+}
+
+func number(value interface{}) (float64, bool) {
+	switch val := value.(type) {
+	case float64:
+		return val, true
+	case json.Number:
+		n, err := val.Float64()
+		return n, err == nil
+	case int:
+		return float64(val), true
+	}
+	return 0, false
+}
+
+func (w *validateWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *validateWriter) WriteHeaderNow() {
+}
+
+func (w *validateWriter) Write(data []byte) (int, error) {
+	return w.buf.Write(data)
+}
+
+func (w *validateWriter) WriteString(s string) (int, error) {
+	return w.buf.WriteString(s)
+}
+
+func (w *validateWriter) Status() int {
+	return w.status
+}
+
+func (w *validateWriter) Size() int {
+	return w.buf.Len()
+}
+
+func (w *validateWriter) Written() bool {
+	return w.buf.Len() != 0
+}
+
+func (w *validateWriter) Flush() {
+}
@@ -0,0 +1,77 @@
+package openapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testDocument = `{
+	"openapi": "3.0.0",
+	"paths": {
+		"/items": {
+			"post": {
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {
+							"schema": {
+								"type": "object",
+								"required": ["name"],
+								"properties": {"name": {"type": "string"}}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}`
+
+func TestValidateRequestBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	large := `{"name":"` + strings.Repeat("a", 64) + `"}`
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"valid", `{"name":"a"}`, http.StatusOK},
+		{"invalid", `{"name":1}`, http.StatusBadRequest},
+		// 超过 MaxBody 不检查  handler 读取完整的请求体
+		{"large", large, http.StatusOK},
+	}
+	for _, test := range tests {
+		var received string
+		engine := gin.New()
+		engine.Use(func(ctx *gin.Context) {
+			ctx.Next()
+			if len(ctx.Errors) != 0 {
+				ctx.Status(http.StatusBadRequest)
+			}
+		})
+		engine.Use(ValidateMiddleware(ValidateConfig{Document: []byte(testDocument), MaxBody: 32}))
+		engine.POST("/items", func(ctx *gin.Context) {
+			data, _ := ioutil.ReadAll(ctx.Request.Body)
+			received = string(data)
+			ctx.Status(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		// chunked  没有 Content-Length
+		req.ContentLength = -1
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, recorder.Code, test.status)
+			continue
+		}
+		if test.status == http.StatusOK && received != test.body {
+			t.Errorf("%s: handler received %d bytes, want %d", test.name, len(received), len(test.body))
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"time"
+
+	"github.com/otamoe/gin-server/outbox"
+)
+
+type (
+	// Outbox handler 中 outbox.Write  后台发布到 redis stream 或 pubsub
+	Outbox struct {
+		// redis key 前缀
+		Prefix string `json:"prefix,omitempty"`
+
+		// stream 或 pubsub
+		Mode      string        `json:"mode,omitempty"`
+		MaxLen    int64         `json:"max_len,omitempty"`
+		Interval  time.Duration `json:"interval,omitempty"`
+		BatchSize int           `json:"batch_size,omitempty"`
+
+		// 发布失败多少次后不再重试  默认 10  -1 一直重试
+		MaxAttempts int `json:"max_attempts,omitempty"`
+
+		// 已发布的保留时间  0 一直保留
+		Retention time.Duration `json:"retention,omitempty"`
+
+		// 只写入 不发布
+		Disabled bool `json:"disabled,omitempty"`
+
+		relay *outbox.Relay
+	}
+)
+
+func (config *Outbox) init(server *Server, handler *Handler) {
+	if config.relay != nil {
+		return
+	}
+	redisProvider := server.redisProvider()
+	if redisProvider == nil {
+		panic("Outbox: redis is empty")
+	}
+	if server.mongoProvider() == nil {
+		panic("Outbox: mongo is empty")
+	}
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = "outbox"
+	}
+	config.relay = outbox.New(outbox.Config{
+		Client:           redisProvider.Get(),
+		Session:          server.mongoSession,
+		CollectionPrefix: server.mongoPrefix(),
+		Prefix:           server.redisPrefix() + prefix,
+		Mode:             config.Mode,
+		MaxLen:           config.MaxLen,
+		Interval:         config.Interval,
+		BatchSize:        config.BatchSize,
+		MaxAttempts:      config.MaxAttempts,
+		Logger:           server.Logger.Get(),
+	})
+}
+
+func (config *Outbox) start(server *Server) {
+	if config.Retention > 0 {
+		// Lazy 连接失败时只记录  Flush 时再连接
+		session, err := server.mongoSession()
+		if err == nil {
+			err = outbox.Setup(session, server.mongoPrefix()+outbox.Model.Name, config.Retention)
+			session.Close()
+		}
+		if err != nil {
+			server.Logger.Get().Errorf("[OUTBOX] setup %s", err)
+		}
+	}
+	if !config.Disabled {
+		config.relay.Start()
+	}
+}
+
+func (config *Outbox) Get() *outbox.Relay {
+	return config.relay
+}
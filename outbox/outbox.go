@@ -0,0 +1,371 @@
+package outbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/globalsign/mgo/txn"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/metrics"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	mgoModel "github.com/otamoe/mgo-model"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	Config struct {
+		Client *redis.Client
+
+		// 每次查询新建 session  连接失败时这次不发布
+		Session func() (*mgo.Session, error)
+
+		// mongo 集合前缀
+		CollectionPrefix string
+
+		// redis key 前缀  stream 为 Prefix.topic  默认 outbox
+		Prefix string
+
+		// stream 或 pubsub  默认 stream
+		Mode string
+
+		// stream 的最大长度  近似  0 不限制
+		MaxLen int64
+
+		Interval  time.Duration
+		BatchSize int
+
+		// 同时只有一个实例发布  每批之前续期
+		LockTTL time.Duration
+
+		// 发布失败多少次后标记 dead_at 不再重试  默认 10  -1 一直重试
+		MaxAttempts int
+
+		Logger *logrus.Logger
+	}
+
+	Message struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    bson.ObjectId `json:"_id" bson:"_id"`
+		Topic                 string        `json:"topic" bson:"topic"`
+		Key                   string        `json:"key,omitempty" bson:"key,omitempty"`
+		Payload               string        `json:"payload" bson:"payload"`
+		Attempts              int           `json:"attempts,omitempty" bson:"attempts,omitempty"`
+		Error                 string        `json:"error,omitempty" bson:"error,omitempty"`
+		CreatedAt             time.Time     `json:"created_at" bson:"created_at"`
+		PublishedAt           *time.Time    `json:"published_at,omitempty" bson:"published_at,omitempty"`
+		DeadAt                *time.Time    `json:"dead_at,omitempty" bson:"dead_at,omitempty"`
+	}
+
+	Relay struct {
+		config Config
+		token  string
+		leader bool
+
+		published *metrics.Counter
+		failed    *metrics.Counter
+		dead      *metrics.Counter
+
+		once  sync.Once
+		close sync.Once
+		stop  chan struct{}
+		done  chan struct{}
+	}
+)
+
+var Model = &mgoModel.Model{
+	Name:     "outbox",
+	Document: &Message{},
+	Indexs: []mgo.Index{
+		mgo.Index{
+			Key:        []string{"published_at", "_id"},
+			Background: true,
+		},
+	},
+}
+
+var ErrTopic = errors.New("Outbox: topic is empty")
+
+// 续期和释放只处理自己的锁
+var (
+	renewScript   = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+	releaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+)
+
+// NewMessage payload 保存为 json
+func NewMessage(topic string, key string, payload interface{}) (*Message, error) {
+	if topic == "" {
+		return nil, ErrTopic
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		ID:        bson.NewObjectId(),
+		Topic:     topic,
+		Key:       key,
+		Payload:   string(data),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// Insert 业务数据写入之后  collection 使用业务数据的 session  需要同时成功时使用 Op
+func Insert(collection *mgo.Collection, topic string, key string, payload interface{}) (*Message, error) {
+	message, err := NewMessage(topic, key, payload)
+	if err != nil {
+		return nil, err
+	}
+	if err = collection.Insert(message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// Op mgo/txn 的插入  和业务数据的 Op 一起 runner.Run  同时成功或者都不写入
+func Op(collection string, topic string, key string, payload interface{}) (txn.Op, *Message, error) {
+	message, err := NewMessage(topic, key, payload)
+	if err != nil {
+		return txn.Op{}, nil, err
+	}
+	return txn.Op{
+		C:      collection,
+		Id:     message.ID,
+		Assert: txn.DocMissing,
+		Insert: message,
+	}, message, nil
+}
+
+// Write 在 handler 中使用  和 mongo 中间件的 session 相同  集合前缀由 mongo 中间件添加
+func Write(ctx *gin.Context, topic string, key string, payload interface{}) (*Message, error) {
+	return Insert(mongoMiddleware.C(ctx, Model.Name), topic, key, payload)
+}
+
+// WriteOp 在 handler 中使用的 Op  runner 使用 mongoMiddleware.DB(ctx)
+func WriteOp(ctx *gin.Context, topic string, key string, payload interface{}) (txn.Op, *Message, error) {
+	return Op(mongoMiddleware.Collection(ctx, Model.Name), topic, key, payload)
+}
+
+// Requeue dead_at 的消息重新发布  attempts 重新计数
+func Requeue(collection *mgo.Collection, id bson.ObjectId) error {
+	return collection.Update(bson.M{
+		"_id":     id,
+		"dead_at": bson.M{"$ne": nil},
+	}, bson.M{
+		"$set":   bson.M{"attempts": 0},
+		"$unset": bson.M{"dead_at": "", "error": ""},
+	})
+}
+
+// Setup 已发布的消息保留 retention  0 时不创建 ttl 索引
+func Setup(session *mgo.Session, collection string, retention time.Duration) error {
+	if retention <= 0 {
+		return nil
+	}
+	return session.DB("").C(collection).EnsureIndex(mgo.Index{
+		Key:         []string{"published_at"},
+		ExpireAfter: retention,
+		Background:  true,
+	})
+}
+
+func New(c Config) *Relay {
+	if c.Client == nil {
+		panic("Outbox: redis client is empty")
+	}
+	if c.Session == nil {
+		panic("Outbox: mongo session is empty")
+	}
+	if c.Prefix == "" {
+		c.Prefix = "outbox"
+	}
+	if c.Mode == "" {
+		c.Mode = "stream"
+	}
+	if c.Interval == 0 {
+		c.Interval = time.Second
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 100
+	}
+	if c.LockTTL == 0 {
+		c.LockTTL = time.Second * 30
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = 10
+	}
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return &Relay{
+		config:    c,
+		token:     hex.EncodeToString(b),
+		published: metrics.Default.Counter("outbox_published_total", "Published outbox messages.", "topic"),
+		failed:    metrics.Default.Counter("outbox_failed_total", "Failed outbox publishes.", "topic"),
+		dead:      metrics.Default.Counter("outbox_dead_total", "Outbox messages given up after MaxAttempts.", "topic"),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Stream topic 的 stream 或 channel
+func (relay *Relay) Stream(topic string) string {
+	return relay.config.Prefix + "." + topic
+}
+
+func (relay *Relay) Start() {
+	relay.once.Do(func() {
+		go relay.run()
+	})
+}
+
+// Stop 等待当前批次  释放锁
+func (relay *Relay) Stop() {
+	relay.close.Do(func() {
+		close(relay.stop)
+	})
+	relay.once.Do(func() {
+		close(relay.done)
+	})
+	<-relay.done
+}
+
+func (relay *Relay) run() {
+	defer close(relay.done)
+	ticker := time.NewTicker(relay.config.Interval)
+	defer ticker.Stop()
+	defer relay.release()
+	for {
+		if relay.elect() {
+			// 一批满时立即继续  每批之前续期  失去锁时停止
+			for relay.Flush() >= relay.config.BatchSize && relay.elect() {
+				select {
+				case <-relay.stop:
+					return
+				default:
+				}
+			}
+		}
+		select {
+		case <-relay.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// elect 获取或续期 redis 锁
+func (relay *Relay) elect() bool {
+	key := relay.config.Prefix + ".leader"
+	client := relay.config.Client
+	if relay.leader {
+		n, err := client.Eval(renewScript, []string{key}, relay.token, int64(relay.config.LockTTL/time.Millisecond)).Int64()
+		if err == nil && n == 1 {
+			return true
+		}
+		relay.leader = false
+		relay.config.Logger.Warnf("[OUTBOX] leader lost %v", err)
+	}
+	ok, err := client.SetNX(key, relay.token, relay.config.LockTTL).Result()
+	if err != nil {
+		relay.config.Logger.Errorf("[OUTBOX] lock %s", err)
+		return false
+	}
+	if ok {
+		relay.leader = true
+		relay.config.Logger.Infof("[OUTBOX] leader %s", relay.token)
+	}
+	return ok
+}
+
+func (relay *Relay) release() {
+	if !relay.leader {
+		return
+	}
+	relay.leader = false
+	relay.config.Client.Eval(releaseScript, []string{relay.config.Prefix + ".leader"}, relay.token)
+}
+
+// Flush 按顺序发布未发布的消息  返回处理的数量  发布成功后才标记  至少一次
+func (relay *Relay) Flush() int {
+	session, err := relay.config.Session()
+	if err != nil {
+		relay.config.Logger.Errorf("[OUTBOX] mongo %s", err)
+		return 0
+	}
+	defer session.Close()
+	collection := session.DB("").C(relay.config.CollectionPrefix + Model.Name)
+
+	var messages []*Message
+	if err := collection.Find(bson.M{"published_at": nil, "dead_at": nil}).Sort("_id").Limit(relay.config.BatchSize).All(&messages); err != nil {
+		relay.config.Logger.Errorf("[OUTBOX] find %s", err)
+		return 0
+	}
+	for i, message := range messages {
+		if err := relay.publish(message); err != nil {
+			relay.failed.Inc(message.Topic)
+			relay.config.Logger.Errorf("[OUTBOX] %s %s publish %s", message.Topic, message.ID.Hex(), err)
+			update := bson.M{"error": err.Error()}
+			dead := relay.exhausted(message)
+			if dead {
+				update["dead_at"] = time.Now()
+			}
+			if err := collection.UpdateId(message.ID, bson.M{
+				"$inc": bson.M{"attempts": 1},
+				"$set": update,
+			}); err != nil || !dead {
+				// 保持顺序  下次重试
+				return i
+			}
+			// 超过 MaxAttempts 不再阻塞后面的消息  Requeue 重新发布
+			relay.dead.Inc(message.Topic)
+			relay.config.Logger.Errorf("[OUTBOX] %s %s dead after %d attempts", message.Topic, message.ID.Hex(), message.Attempts+1)
+			continue
+		}
+		now := time.Now()
+		if err := collection.UpdateId(message.ID, bson.M{
+			"$set":   bson.M{"published_at": now},
+			"$unset": bson.M{"error": ""},
+		}); err != nil {
+			// 会重复发布
+			relay.config.Logger.Errorf("[OUTBOX] %s %s mark %s", message.Topic, message.ID.Hex(), err)
+			return i
+		}
+		relay.published.Inc(message.Topic)
+	}
+	return len(messages)
+}
+
+// exhausted 这次失败之后达到 MaxAttempts
+func (relay *Relay) exhausted(message *Message) bool {
+	return relay.config.MaxAttempts > 0 && message.Attempts+1 >= relay.config.MaxAttempts
+}
+
+func (relay *Relay) publish(message *Message) error {
+	stream := relay.Stream(message.Topic)
+	if relay.config.Mode == "pubsub" {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		return relay.config.Client.Publish(stream, data).Err()
+	}
+	return relay.config.Client.XAdd(&redis.XAddArgs{
+		Stream:       stream,
+		MaxLenApprox: relay.config.MaxLen,
+		Values: map[string]interface{}{
+			"id":      message.ID.Hex(),
+			"topic":   message.Topic,
+			"key":     message.Key,
+			"payload": message.Payload,
+		},
+	}).Err()
+}
@@ -0,0 +1,126 @@
+package outbox
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/globalsign/mgo/txn"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+)
+
+func TestFlushSessionError(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	relay := New(Config{
+		Client: redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}),
+		Session: func() (*mgo.Session, error) {
+			return nil, errors.New("no reachable servers")
+		},
+		Logger: logger,
+	})
+	if n := relay.Flush(); n != 0 {
+		t.Fatalf("Flush() = %d", n)
+	}
+}
+
+func TestExhausted(t *testing.T) {
+	tests := []struct {
+		maxAttempts int
+		attempts    int
+		want        bool
+	}{
+		{0, 9, true},
+		{0, 8, false},
+		{3, 2, true},
+		{3, 1, false},
+		{-1, 100, false},
+	}
+	for _, test := range tests {
+		relay := New(Config{
+			Client: redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}),
+			Session: func() (*mgo.Session, error) {
+				return nil, errors.New("unused")
+			},
+			MaxAttempts: test.maxAttempts,
+		})
+		if got := relay.exhausted(&Message{Attempts: test.attempts}); got != test.want {
+			t.Errorf("MaxAttempts %d attempts %d: exhausted = %v, want %v", test.maxAttempts, test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestOp(t *testing.T) {
+	op, message, err := Op("outbox", "user.created", "1", map[string]string{"id": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.C != "outbox" || op.Id != message.ID || op.Assert != txn.DocMissing || op.Insert != message {
+		t.Fatalf("op %+v", op)
+	}
+	if message.Payload != `{"id":"1"}` {
+		t.Fatalf("payload %s", message.Payload)
+	}
+	if _, _, err = Op("outbox", "", "", nil); err != ErrTopic {
+		t.Fatalf("empty topic: %v", err)
+	}
+}
+
+// 需要 TEST_MONGO_URL  redis 不可用时发布失败
+func TestFlushDeadLetter(t *testing.T) {
+	url := os.Getenv("TEST_MONGO_URL")
+	if url == "" {
+		t.Skip("TEST_MONGO_URL is empty")
+	}
+	session, err := mgo.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	collection := session.DB("").C("outbox_test_" + bson.NewObjectId().Hex())
+	defer collection.DropCollection()
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	relay := New(Config{
+		Client: redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}),
+		Session: func() (*mgo.Session, error) {
+			return session.Clone(), nil
+		},
+		CollectionPrefix: collection.Name[:len(collection.Name)-len(Model.Name)],
+		MaxAttempts:      2,
+		Logger:           logger,
+	})
+	collection = session.DB("").C(relay.config.CollectionPrefix + Model.Name)
+	first, _ := Insert(collection, "a", "", 1)
+	second, _ := Insert(collection, "a", "", 2)
+
+	// 第一次失败  保持顺序
+	if n := relay.Flush(); n != 0 {
+		t.Fatalf("first Flush() = %d", n)
+	}
+	// 第二次失败后 dead  继续后面的消息  也失败
+	relay.Flush()
+	message := &Message{}
+	collection.FindId(first.ID).One(message)
+	if message.DeadAt == nil || message.Attempts != 2 {
+		t.Fatalf("first message %+v", message)
+	}
+	message = &Message{}
+	collection.FindId(second.ID).One(message)
+	if message.DeadAt != nil || message.Attempts != 1 {
+		t.Fatalf("second message %+v", message)
+	}
+	if err = Requeue(collection, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	message = &Message{}
+	collection.FindId(first.ID).One(message)
+	if message.DeadAt != nil || message.Attempts != 0 {
+		t.Fatalf("requeued message %+v", message)
+	}
+}
@@ -0,0 +1,286 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/links"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	"github.com/otamoe/gin-server/respond"
+	mgoModel "github.com/otamoe/mgo-model"
+)
+
+type (
+	Config struct {
+		DefaultLimit int
+		MaxLimit     int
+
+		// 允许排序的字段  - 开头为倒序
+		Sorts       []string
+		DefaultSort string
+
+		// 输出 X-Total-Count  需要额外的 count 查询
+		Total bool
+	}
+
+	Pagination struct {
+		Limit  int
+		Sort   string
+		Cursor *Cursor
+
+		config Config
+		ctx    *gin.Context
+	}
+
+	// Cursor 上一页最后一条的 排序值 和 _id
+	Cursor struct {
+		Value interface{}   `bson:"v,omitempty"`
+		ID    bson.ObjectId `bson:"i"`
+	}
+)
+
+var CONTEXT = "GIN.SERVER.PAGINATION"
+
+var ErrCursor = &errs.Error{
+	Message:    "Invalid cursor",
+	Type:       "pagination",
+	Path:       "cursor",
+	StatusCode: http.StatusBadRequest,
+}
+var ErrSort = &errs.Error{
+	Message:    "Invalid sort",
+	Type:       "pagination",
+	Path:       "sort",
+	StatusCode: http.StatusBadRequest,
+}
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.DefaultLimit == 0 {
+		c.DefaultLimit = 20
+	}
+	if c.MaxLimit == 0 {
+		c.MaxLimit = 100
+	}
+	if c.DefaultSort == "" {
+		c.DefaultSort = "-_id"
+	}
+
+	return func(ctx *gin.Context) {
+		pagination := &Pagination{
+			Limit:  c.DefaultLimit,
+			Sort:   c.DefaultSort,
+			config: c,
+			ctx:    ctx,
+		}
+
+		if val := ctx.Query("limit"); val != "" {
+			if limit, err := strconv.Atoi(val); err == nil && limit > 0 {
+				pagination.Limit = limit
+			}
+		}
+		if pagination.Limit > c.MaxLimit {
+			pagination.Limit = c.MaxLimit
+		}
+
+		if val := ctx.Query("sort"); val != "" {
+			if !c.allowSort(val) {
+				ctx.Error(ErrSort)
+				ctx.Abort()
+				return
+			}
+			pagination.Sort = val
+		}
+
+		if val := ctx.Query("cursor"); val != "" {
+			cursor, err := decodeCursor(val)
+			if err != nil {
+				ctx.Error(ErrCursor)
+				ctx.Abort()
+				return
+			}
+			pagination.Cursor = cursor
+		}
+
+		ctx.Set(CONTEXT, pagination)
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Pagination {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Pagination)
+	}
+	return nil
+}
+
+// Find 使用 request 的 mongo session 查询  result 为 slice 指针  不包括软删除的
+func (pagination *Pagination) Find(model *mgoModel.Model, selector bson.M, result interface{}) (err error) {
+	collection := mongoMiddleware.C(pagination.ctx, model.Name)
+	selector = mongoMiddleware.NotDeleted(selector)
+
+	if pagination.config.Total {
+		var total int
+		if total, err = collection.Find(selector).Count(); err != nil {
+			return
+		}
+		pagination.ctx.Header("X-Total-Count", strconv.Itoa(total))
+	}
+
+	// 多查一条 判断是否有下一页
+	if err = pagination.Apply(collection, selector).All(result); err != nil {
+		return
+	}
+
+	value := reflect.ValueOf(result).Elem()
+	var next *Cursor
+	if value.Len() > pagination.Limit {
+		value.Set(value.Slice(0, pagination.Limit))
+		if next, err = pagination.cursor(value.Index(pagination.Limit - 1).Interface()); err != nil {
+			return
+		}
+	}
+	pagination.link(next)
+	return
+}
+
+// Apply 添加 cursor 条件 排序 和 limit+1
+func (pagination *Pagination) Apply(collection *mgo.Collection, selector bson.M) *mgo.Query {
+	field, desc := pagination.field()
+	query := bson.M{}
+	for key, val := range selector {
+		query[key] = val
+	}
+
+	if pagination.Cursor != nil {
+		op := "$gt"
+		if desc {
+			op = "$lt"
+		}
+		var condition bson.M
+		if field == "_id" {
+			condition = bson.M{"_id": bson.M{op: pagination.Cursor.ID}}
+		} else {
+			condition = bson.M{"$or": []bson.M{
+				{field: bson.M{op: pagination.Cursor.Value}},
+				{field: pagination.Cursor.Value, "_id": bson.M{op: pagination.Cursor.ID}},
+			}}
+		}
+		if len(query) == 0 {
+			query = condition
+		} else {
+			query = bson.M{"$and": []bson.M{query, condition}}
+		}
+	}
+
+	sort := []string{pagination.Sort}
+	if field != "_id" {
+		if desc {
+			sort = append(sort, "-_id")
+		} else {
+			sort = append(sort, "_id")
+		}
+	}
+	return collection.Find(query).Sort(sort...).Limit(pagination.Limit + 1)
+}
+
+func (pagination *Pagination) field() (field string, desc bool) {
+	field = pagination.Sort
+	if strings.HasPrefix(field, "-") {
+		return field[1:], true
+	}
+	return strings.TrimPrefix(field, "+"), false
+}
+
+func (pagination *Pagination) cursor(document interface{}) (cursor *Cursor, err error) {
+	var data []byte
+	if data, err = bson.Marshal(document); err != nil {
+		return
+	}
+	raw := bson.M{}
+	if err = bson.Unmarshal(data, raw); err != nil {
+		return
+	}
+	cursor = &Cursor{}
+	cursor.ID, _ = raw["_id"].(bson.ObjectId)
+	if field, _ := pagination.field(); field != "_id" {
+		cursor.Value = raw[field]
+	}
+	return
+}
+
+// link 输出 Link 头  和 links 的 _links 共用
+func (pagination *Pagination) link(next *Cursor) {
+	req := pagination.ctx.Request
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	build := func(cursor string) string {
+		query := req.URL.Query()
+		query.Del("cursor")
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		u := url.URL{
+			Scheme:   scheme,
+			Host:     req.Host,
+			Path:     req.URL.Path,
+			RawQuery: query.Encode(),
+		}
+		return u.String()
+	}
+
+	links.Href(pagination.ctx, "first", build(""))
+	if next != nil {
+		if value, err := next.Encode(); err == nil {
+			links.Href(pagination.ctx, "next", build(value))
+			respond.SetMeta(pagination.ctx, "next_cursor", value)
+		}
+	}
+}
+
+func (c Config) allowSort(sort string) bool {
+	field := strings.TrimLeft(sort, "+-")
+	if field == "_id" {
+		return true
+	}
+	for _, val := range c.Sorts {
+		if strings.TrimLeft(val, "+-") == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (cursor *Cursor) Encode() (string, error) {
+	data, err := bson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(value string) (cursor *Cursor, err error) {
+	var data []byte
+	if data, err = base64.RawURLEncoding.DecodeString(value); err != nil {
+		return
+	}
+	cursor = &Cursor{}
+	if err = bson.Unmarshal(data, cursor); err != nil {
+		cursor = nil
+		return
+	}
+	if !cursor.ID.Valid() {
+		cursor = nil
+		err = ErrCursor
+	}
+	return
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// profiles 配置文件中按环境分开的部分  default 先合并  然后是 ENV 对应的
+var profiles = []string{"default", "development", "test", "production"}
+
+// ResolveProfile 合并 default 和 env 的部分  对象递归合并  数组和其他值替换  null 删除
+// 顶层的其他字段在 default 之前  没有这些部分时原样返回
+func ResolveProfile(data []byte, env string) ([]byte, error) {
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	layered := false
+	for _, name := range profiles {
+		if _, ok := doc[name]; ok {
+			layered = true
+		}
+	}
+	if !layered {
+		return data, nil
+	}
+
+	base := map[string]interface{}{}
+	for key, val := range doc {
+		if isProfile(key) {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(val, &value); err != nil {
+			return nil, err
+		}
+		base[key] = value
+	}
+
+	if env == "" {
+		env = profileEnv(doc["default"])
+	}
+	for _, name := range []string{"default", normalizeENV(env)} {
+		raw, ok := doc[name]
+		if !ok {
+			continue
+		}
+		var layer interface{}
+		if err := json.Unmarshal(raw, &layer); err != nil {
+			return nil, err
+		}
+		if layer == nil {
+			continue
+		}
+		value, ok := layer.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("Server: profile " + name + " is not an object")
+		}
+		mergeProfile(base, value)
+	}
+	base["env"] = normalizeENV(env)
+	return json.Marshal(base)
+}
+
+// LoadConfig 解析配置文件到 server  ENV 为空时使用 GIN_ENV 环境变量 或 default 中的 env  然后替换 secrets
+func (server *Server) LoadConfig(data []byte) error {
+	env := server.ENV
+	if env == "" {
+		env = os.Getenv("GIN_ENV")
+	}
+	resolved, err := server.resolveConfig(data, env)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(resolved, server); err != nil {
+		return err
+	}
+	next := map[string]json.RawMessage{}
+	if err := json.Unmarshal(resolved, &next); err != nil {
+		return err
+	}
+	server.mutex.Lock()
+	server.configData = next
+	server.mutex.Unlock()
+	return nil
+}
+
+// ResolvedConfig LoadConfig ReloadConfig 合并后的配置  没有加载时为空
+func (server *Server) ResolvedConfig() []byte {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.configData == nil {
+		return nil
+	}
+	data, _ := json.Marshal(server.configData)
+	return data
+}
+
+func isProfile(key string) bool {
+	for _, name := range profiles {
+		if name == key {
+			return true
+		}
+	}
+	return false
+}
+
+// profileEnv default 中的 env
+func profileEnv(raw json.RawMessage) string {
+	var value struct {
+		ENV string `json:"env"`
+	}
+	if raw != nil {
+		json.Unmarshal(raw, &value)
+	}
+	return value.ENV
+}
+
+// normalizeENV 和 Init 相同
+func normalizeENV(env string) string {
+	switch env {
+	case "dev", "development":
+		return "development"
+	case "test":
+		return "test"
+	}
+	return "production"
+}
+
+func mergeProfile(dst map[string]interface{}, src map[string]interface{}) {
+	for key, val := range src {
+		if val == nil {
+			delete(dst, key)
+			continue
+		}
+		if next, ok := val.(map[string]interface{}); ok {
+			if current, ok := dst[key].(map[string]interface{}); ok {
+				mergeProfile(current, next)
+				continue
+			}
+		}
+		dst[key] = val
+	}
+}
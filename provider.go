@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/globalsign/mgo"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/mongo"
+	ginRedis "github.com/otamoe/gin-server/redis"
+)
+
+type (
+	// RedisProvider 替换 redis 配置  例如 mock 或包装
+	RedisProvider interface {
+		Get() *redis.Client
+	}
+
+	// MongoProvider Get 返回的 session 需要 Close
+	MongoProvider interface {
+		Get() *mgo.Session
+	}
+)
+
+// redisProvider 优先使用 RedisProvider
+func (server *Server) redisProvider() RedisProvider {
+	if server.RedisProvider != nil {
+		return server.RedisProvider
+	}
+	if server.Redis != nil {
+		return server.Redis
+	}
+	return nil
+}
+
+// redisPrefix provider 没有前缀时为空
+func (server *Server) redisPrefix() string {
+	if val, ok := server.redisProvider().(ginRedis.Prefixer); ok {
+		return val.KeyPrefix()
+	}
+	return ""
+}
+
+// mongoPrefix 集合的前缀
+func (server *Server) mongoPrefix() string {
+	if val, ok := server.mongoProvider().(mongo.Prefixer); ok {
+		return val.CollectionPrefix()
+	}
+	return ""
+}
+
+// mongoSession 需要 Close  Lazy 连接失败时返回 error 不 panic
+func (server *Server) mongoSession() (*mgo.Session, error) {
+	provider := server.mongoProvider()
+	if provider == nil {
+		return nil, errors.New("Server: mongo is empty")
+	}
+	if config, ok := provider.(*Mongo); ok {
+		session, err := config.Session()
+		if err != nil {
+			return nil, err
+		}
+		return session.Clone(), nil
+	}
+	return provider.Get(), nil
+}
+
+func (server *Server) mongoProvider() MongoProvider {
+	if server.MongoProvider != nil {
+		return server.MongoProvider
+	}
+	if server.Mongo != nil {
+		return server.Mongo
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/otamoe/gin-server/health"
+	"github.com/otamoe/gin-server/metrics"
+	"github.com/otamoe/gin-server/proxy"
+	ginRedis "github.com/otamoe/gin-server/redis"
+)
+
+type (
+	Proxy struct {
+		Upstream string `json:"upstream,omitempty"`
+
+		// 多个 upstream 时代替 Upstream  round_robin 或 least_conn
+		Upstreams   []string          `json:"upstreams,omitempty"`
+		Balance     string            `json:"balance,omitempty"`
+		HealthCheck *ProxyHealthCheck `json:"health_check,omitempty"`
+
+		PreserveHost    bool              `json:"preserve_host,omitempty"`
+		StripPrefix     string            `json:"strip_prefix,omitempty"`
+		Headers         map[string]string `json:"headers,omitempty"`
+		ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+
+		InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+		ServerName         string `json:"server_name,omitempty"`
+		CAFile             string `json:"ca_file,omitempty"`
+		CertFile           string `json:"cert_file,omitempty"`
+		KeyFile            string `json:"key_file,omitempty"`
+
+		DialTimeout           time.Duration `json:"dial_timeout,omitempty"`
+		ResponseHeaderTimeout time.Duration `json:"response_header_timeout,omitempty"`
+		Retries               int           `json:"retries,omitempty"`
+
+		// 缓存 upstream 的响应
+		Cache *ProxyCache `json:"cache,omitempty"`
+
+		handler  http.Handler
+		balancer *proxy.Balancer
+	}
+
+	// ProxyHealthCheck 连续失败 Fails 次剔除  连续成功 Passes 次恢复
+	ProxyHealthCheck struct {
+		Path     string        `json:"path,omitempty"`
+		Interval time.Duration `json:"interval,omitempty"`
+		Timeout  time.Duration `json:"timeout,omitempty"`
+		Fails    int           `json:"fails,omitempty"`
+		Passes   int           `json:"passes,omitempty"`
+	}
+
+	// ProxyCache 遵守 upstream 的 Cache-Control  PURGE 请求清除
+	ProxyCache struct {
+		// redis 或 disk
+		Store  string `json:"store,omitempty"`
+		Dir    string `json:"dir,omitempty"`
+		Prefix string `json:"prefix,omitempty"`
+
+		// 过期后保留用于重新验证的时间
+		Stale   time.Duration `json:"stale,omitempty"`
+		MaxSize int64         `json:"max_size,omitempty"`
+
+		// PURGE 请求头 X-Purge-Key  为空时不允许 PURGE
+		PurgeKeys []string `json:"purge_keys,omitempty"`
+	}
+)
+
+func (config *Proxy) init(server *Server, handler *Handler) {
+	if config.handler != nil {
+		return
+	}
+	if config.Retries == 0 {
+		config.Retries = 2
+	}
+	if len(config.Upstreams) != 0 {
+		c := proxy.BalancerConfig{
+			Policy: config.Balance,
+		}
+		if config.HealthCheck != nil {
+			c.HealthPath = config.HealthCheck.Path
+			c.Interval = config.HealthCheck.Interval
+			c.Timeout = config.HealthCheck.Timeout
+			c.Fails = config.HealthCheck.Fails
+			c.Passes = config.HealthCheck.Passes
+		}
+		name := "default"
+		if handler != nil && handler.Name != "" {
+			name = handler.Name
+		}
+		if server.Metrics != nil || (handler != nil && handler.Metrics != nil) {
+			c.Registry = metrics.Default
+			c.Name = name
+		}
+		logger := server.Logger.Get()
+		if handler != nil && handler.Logger != nil {
+			logger = handler.Logger.Get()
+		}
+		c.OnChange = func(upstream *proxy.Upstream, healthy bool) {
+			if healthy {
+				logger.Infof("[PROXY] %s upstream %s is healthy", name, upstream.URL)
+			} else {
+				logger.Warnf("[PROXY] %s upstream %s is unhealthy", name, upstream.URL)
+			}
+		}
+		config.balancer = proxy.NewBalancer(config.Upstreams, c)
+		// 全部不健康时只显示 degraded
+		health.Register("proxy."+name, config.balancer.HealthCheck, false)
+	}
+	config.handler = proxy.New(proxy.Config{
+		Upstream:              config.Upstream,
+		Balancer:              config.balancer,
+		PreserveHost:          config.PreserveHost,
+		TrustedProxies:        server.trustedProxies,
+		StripPrefix:           config.StripPrefix,
+		Headers:               config.Headers,
+		ResponseHeaders:       config.ResponseHeaders,
+		InsecureSkipVerify:    config.InsecureSkipVerify,
+		ServerName:            config.ServerName,
+		CAFile:                config.CAFile,
+		CertFile:              config.CertFile,
+		KeyFile:               config.KeyFile,
+		DialTimeout:           config.DialTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		Retries:               config.Retries,
+	})
+	if config.balancer != nil {
+		config.balancer.Start()
+	}
+	if config.Cache != nil {
+		config.handler = proxy.NewCache(config.handler, proxy.CacheConfig{
+			Store:     config.Cache.store(server, handler),
+			Stale:     config.Cache.Stale,
+			MaxSize:   config.Cache.MaxSize,
+			PurgeKeys: config.Cache.PurgeKeys,
+		})
+	}
+}
+
+func (config *ProxyCache) store(server *Server, handler *Handler) proxy.CacheStore {
+	if config.Store == "disk" {
+		return &proxy.DiskCache{Dir: config.Dir}
+	}
+	var provider RedisProvider
+	if handler != nil {
+		provider = handler.RedisProvider
+	}
+	if provider == nil {
+		provider = server.redisProvider()
+	}
+	if provider == nil {
+		panic("Proxy: cache redis is empty")
+	}
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = "proxycache."
+	}
+	if val, ok := provider.(ginRedis.Prefixer); ok {
+		prefix = val.KeyPrefix() + prefix
+	}
+	return &proxy.RedisCache{
+		Client: provider.Get(),
+		Prefix: prefix,
+	}
+}
+
+func (config *Proxy) Get() http.Handler {
+	return config.handler
+}
+
+// State 每个 upstream 的状态  只有一个 Upstream 时为空
+func (config *Proxy) State() []proxy.UpstreamState {
+	if config.balancer == nil {
+		return nil
+	}
+	return config.balancer.State()
+}
+
+func (config *Proxy) close() {
+	if config.balancer != nil {
+		config.balancer.Stop()
+	}
+}
@@ -0,0 +1,432 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/otamoe/gin-server/metrics"
+)
+
+type (
+	BalancerConfig struct {
+		// round_robin 或 least_conn
+		Policy string
+
+		// 主动检查的路径  为空时只在连接失败时剔除
+		HealthPath string
+		Interval   time.Duration
+		Timeout    time.Duration
+
+		// 连续失败多少次剔除  默认 3
+		Fails int
+		// 连续成功多少次恢复  默认 2
+		Passes int
+
+		// 没有 HealthPath 时剔除多久后再尝试  默认 30s
+		EjectFor time.Duration
+
+		// 有值时输出 proxy_upstream_* 指标  Name 为 handler 标签
+		Registry *metrics.Registry
+		Name     string
+
+		OnChange func(upstream *Upstream, healthy bool)
+	}
+
+	Upstream struct {
+		URL *url.URL
+
+		active    int64
+		healthy   int32
+		fails     int32
+		passes    int32
+		ejectedAt int64
+		requests  uint64
+		failures  uint64
+	}
+
+	UpstreamState struct {
+		URL      string `json:"url"`
+		Healthy  bool   `json:"healthy"`
+		Active   int64  `json:"active"`
+		Requests uint64 `json:"requests"`
+		Failures uint64 `json:"failures"`
+	}
+
+	Balancer struct {
+		config    BalancerConfig
+		upstreams []*Upstream
+		hosts     map[string]*Upstream
+		next      uint64
+
+		// 健康检查使用  New 时设置为 proxy 的 transport
+		transport http.RoundTripper
+
+		gauges *balancerGauges
+
+		once  sync.Once
+		close sync.Once
+		stop  chan struct{}
+	}
+
+	balancerGauges struct {
+		healthy  *metrics.Gauge
+		active   *metrics.Gauge
+		requests *metrics.Counter
+		failures *metrics.Counter
+	}
+
+	balancerTransport struct {
+		next     http.RoundTripper
+		balancer *Balancer
+		retries  int
+		delay    time.Duration
+	}
+
+	upstreamBody struct {
+		io.ReadCloser
+		upstream *Upstream
+		balancer *Balancer
+		once     sync.Once
+	}
+)
+
+var ErrNoUpstream = errors.New("proxy: no upstream")
+
+func NewBalancer(upstreams []string, c BalancerConfig) *Balancer {
+	if len(upstreams) == 0 {
+		panic(ErrNoUpstream)
+	}
+	if c.Policy == "" {
+		c.Policy = "round_robin"
+	}
+	if c.Interval == 0 {
+		c.Interval = time.Second * 10
+	}
+	if c.Timeout == 0 {
+		c.Timeout = time.Second * 2
+	}
+	if c.Fails == 0 {
+		c.Fails = 3
+	}
+	if c.Passes == 0 {
+		c.Passes = 2
+	}
+	if c.EjectFor == 0 {
+		c.EjectFor = time.Second * 30
+	}
+	balancer := &Balancer{
+		config: c,
+		hosts:  map[string]*Upstream{},
+		stop:   make(chan struct{}),
+	}
+	for _, val := range upstreams {
+		target, err := url.Parse(val)
+		if err != nil {
+			panic(err)
+		}
+		if target.Scheme == "" || target.Host == "" {
+			panic(errors.New("proxy: upstream " + val + " is invalid"))
+		}
+		upstream := &Upstream{URL: target, healthy: 1}
+		balancer.upstreams = append(balancer.upstreams, upstream)
+		balancer.hosts[target.Scheme+"://"+target.Host] = upstream
+	}
+	if c.Registry != nil {
+		balancer.gauges = &balancerGauges{
+			healthy:  c.Registry.Gauge("proxy_upstream_healthy", "Upstream is healthy.", "handler", "upstream"),
+			active:   c.Registry.Gauge("proxy_upstream_active", "Active requests to upstream.", "handler", "upstream"),
+			requests: c.Registry.Counter("proxy_upstream_requests_total", "Requests sent to upstream.", "handler", "upstream"),
+			failures: c.Registry.Counter("proxy_upstream_failures_total", "Failed requests to upstream.", "handler", "upstream"),
+		}
+		for _, upstream := range balancer.upstreams {
+			balancer.gauges.healthy.Set(1, c.Name, upstream.URL.String())
+			balancer.gauges.active.Set(0, c.Name, upstream.URL.String())
+		}
+	}
+	return balancer
+}
+
+// Start 有 HealthPath 时定时检查  多次调用只启动一次
+func (balancer *Balancer) Start() {
+	if balancer.config.HealthPath == "" {
+		return
+	}
+	balancer.once.Do(func() {
+		go balancer.run()
+	})
+}
+
+func (balancer *Balancer) Stop() {
+	balancer.close.Do(func() {
+		close(balancer.stop)
+	})
+}
+
+func (balancer *Balancer) run() {
+	ticker := time.NewTicker(balancer.config.Interval)
+	defer ticker.Stop()
+	for {
+		balancer.Check()
+		select {
+		case <-balancer.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check 立即检查一次全部 upstream
+func (balancer *Balancer) Check() {
+	var wg sync.WaitGroup
+	for _, upstream := range balancer.upstreams {
+		wg.Add(1)
+		go func(upstream *Upstream) {
+			defer wg.Done()
+			if balancer.check(upstream) {
+				balancer.pass(upstream)
+			} else {
+				balancer.fail(upstream)
+			}
+		}(upstream)
+	}
+	wg.Wait()
+}
+
+// Pick 没有健康的 upstream 时在全部中选择
+func (balancer *Balancer) Pick(exclude *Upstream) *Upstream {
+	var candidates []*Upstream
+	for _, upstream := range balancer.upstreams {
+		if upstream != exclude && balancer.available(upstream) {
+			candidates = append(candidates, upstream)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, upstream := range balancer.upstreams {
+			if upstream != exclude {
+				candidates = append(candidates, upstream)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return exclude
+	}
+	start := int(atomic.AddUint64(&balancer.next, 1) % uint64(len(candidates)))
+	if balancer.config.Policy != "least_conn" {
+		return candidates[start]
+	}
+	// 相同时轮流
+	var picked *Upstream
+	for i := range candidates {
+		upstream := candidates[(start+i)%len(candidates)]
+		if picked == nil || atomic.LoadInt64(&upstream.active) < atomic.LoadInt64(&picked.active) {
+			picked = upstream
+		}
+	}
+	return picked
+}
+
+// Healthy 健康的数量
+func (balancer *Balancer) Healthy() (n int) {
+	for _, upstream := range balancer.upstreams {
+		if atomic.LoadInt32(&upstream.healthy) == 1 {
+			n++
+		}
+	}
+	return
+}
+
+func (balancer *Balancer) State() (states []UpstreamState) {
+	for _, upstream := range balancer.upstreams {
+		states = append(states, UpstreamState{
+			URL:      upstream.URL.String(),
+			Healthy:  atomic.LoadInt32(&upstream.healthy) == 1,
+			Active:   atomic.LoadInt64(&upstream.active),
+			Requests: atomic.LoadUint64(&upstream.requests),
+			Failures: atomic.LoadUint64(&upstream.failures),
+		})
+	}
+	return
+}
+
+// HealthCheck 用于 health.Register  没有健康的 upstream 时失败
+func (balancer *Balancer) HealthCheck(ctx context.Context) error {
+	if balancer.Healthy() == 0 {
+		return ErrNoUpstream
+	}
+	return nil
+}
+
+// available 被动剔除的  EjectFor 之后允许一个请求尝试
+func (balancer *Balancer) available(upstream *Upstream) bool {
+	if atomic.LoadInt32(&upstream.healthy) == 1 {
+		return true
+	}
+	if balancer.config.HealthPath != "" {
+		return false
+	}
+	ejectedAt := atomic.LoadInt64(&upstream.ejectedAt)
+	if time.Now().UnixNano()-ejectedAt < int64(balancer.config.EjectFor) {
+		return false
+	}
+	return atomic.CompareAndSwapInt64(&upstream.ejectedAt, ejectedAt, time.Now().UnixNano())
+}
+
+func (balancer *Balancer) check(upstream *Upstream) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), balancer.config.Timeout)
+	defer cancel()
+	target := *upstream.URL
+	target.Path = singleJoiningSlash(target.Path, balancer.config.HealthPath)
+	target.RawPath = ""
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return false
+	}
+	transport := balancer.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	res, err := transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode >= 200 && res.StatusCode < 400
+}
+
+func (balancer *Balancer) pass(upstream *Upstream) {
+	atomic.StoreInt32(&upstream.fails, 0)
+	if atomic.LoadInt32(&upstream.healthy) == 1 {
+		return
+	}
+	// 被动剔除的第一次成功就恢复
+	if balancer.config.HealthPath != "" && atomic.AddInt32(&upstream.passes, 1) < int32(balancer.config.Passes) {
+		return
+	}
+	balancer.setHealthy(upstream, true)
+}
+
+func (balancer *Balancer) fail(upstream *Upstream) {
+	atomic.StoreInt32(&upstream.passes, 0)
+	if atomic.AddInt32(&upstream.fails, 1) < int32(balancer.config.Fails) {
+		return
+	}
+	atomic.StoreInt64(&upstream.ejectedAt, time.Now().UnixNano())
+	if atomic.LoadInt32(&upstream.healthy) == 1 {
+		balancer.setHealthy(upstream, false)
+	}
+}
+
+func (balancer *Balancer) setHealthy(upstream *Upstream, healthy bool) {
+	var val int32
+	if healthy {
+		val = 1
+	}
+	if atomic.SwapInt32(&upstream.healthy, val) == val {
+		return
+	}
+	atomic.StoreInt32(&upstream.passes, 0)
+	if balancer.gauges != nil {
+		balancer.gauges.healthy.Set(float64(val), balancer.config.Name, upstream.URL.String())
+	}
+	if balancer.config.OnChange != nil {
+		balancer.config.OnChange(upstream, healthy)
+	}
+}
+
+func (balancer *Balancer) direct(req *http.Request, upstream *Upstream) {
+	target := upstream.URL
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path = singleJoiningSlash(target.Path, req.URL.Path)
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = singleJoiningSlash(target.EscapedPath(), req.URL.RawPath)
+	}
+	if target.RawQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = target.RawQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+	}
+}
+
+func (balancer *Balancer) acquire(upstream *Upstream) {
+	atomic.AddUint64(&upstream.requests, 1)
+	active := atomic.AddInt64(&upstream.active, 1)
+	if balancer.gauges != nil {
+		balancer.gauges.requests.Inc(balancer.config.Name, upstream.URL.String())
+		balancer.gauges.active.Set(float64(active), balancer.config.Name, upstream.URL.String())
+	}
+}
+
+func (balancer *Balancer) release(upstream *Upstream) {
+	active := atomic.AddInt64(&upstream.active, -1)
+	if balancer.gauges != nil {
+		balancer.gauges.active.Set(float64(active), balancer.config.Name, upstream.URL.String())
+	}
+}
+
+// RoundTrip 连接失败时换一个 upstream 重试  只重试没有 body 的请求
+func (t *balancerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	upstream := t.balancer.hosts[req.URL.Scheme+"://"+req.URL.Host]
+	for i := 0; ; i++ {
+		if upstream == nil {
+			return t.next.RoundTrip(req)
+		}
+		t.balancer.acquire(upstream)
+		res, err := t.next.RoundTrip(req)
+		if err == nil {
+			t.balancer.pass(upstream)
+			res.Body = &upstreamBody{ReadCloser: res.Body, upstream: upstream, balancer: t.balancer}
+			return res, nil
+		}
+		t.balancer.release(upstream)
+		atomic.AddUint64(&upstream.failures, 1)
+		if t.balancer.gauges != nil {
+			t.balancer.gauges.failures.Inc(t.balancer.config.Name, upstream.URL.String())
+		}
+		if !isDialError(err) {
+			return nil, err
+		}
+		t.balancer.fail(upstream)
+		if i >= t.retries || (req.Body != nil && req.Body != http.NoBody) {
+			return nil, err
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, err
+		case <-time.After(t.delay):
+		}
+		next := t.balancer.Pick(upstream)
+		if next != upstream {
+			// path 已经拼接过  只替换 host  upstream 的路径需要相同
+			req.URL.Scheme = next.URL.Scheme
+			req.URL.Host = next.URL.Host
+			upstream = next
+		}
+	}
+}
+
+func (body *upstreamBody) Close() error {
+	body.once.Do(func() {
+		body.balancer.release(body.upstream)
+	})
+	return body.ReadCloser.Close()
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,477 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/otamoe/gin-server/clock"
+)
+
+type (
+	CacheConfig struct {
+		// RedisCache 或 DiskCache
+		Store CacheStore
+
+		// 过期后保留用于 If-None-Match If-Modified-Since 重新验证的时间  默认 1 小时
+		Stale time.Duration
+
+		// 超过的响应不缓存  默认 10MB
+		MaxSize int64
+
+		// PURGE 请求需要的 X-Purge-Key  为空时不允许 PURGE
+		PurgeKeys []string
+	}
+
+	// CacheStore host 和 uri 分开  用于清除整个 host
+	CacheStore interface {
+		// 不存在时 nil nil
+		Get(host string, uri string) ([]byte, error)
+		Set(host string, uri string, value []byte, ttl time.Duration) error
+		Delete(host string, uri string) error
+		Clear(host string) error
+	}
+
+	Cache struct {
+		config CacheConfig
+		next   http.Handler
+	}
+
+	cacheEntry struct {
+		StatusCode int               `json:"status_code"`
+		Header     http.Header       `json:"header"`
+		Body       []byte            `json:"body"`
+		Vary       map[string]string `json:"vary,omitempty"`
+		StoredAt   time.Time         `json:"stored_at"`
+		ExpiresAt  time.Time         `json:"expires_at"`
+
+		// 有 Vary 时 uri 只保存请求头名  每个组合按 variantKey 单独保存  StoredAt 区分 Purge 之前的
+		Variants []string `json:"variants,omitempty"`
+	}
+
+	cacheWriter struct {
+		header     http.Header
+		writer     http.ResponseWriter
+		statusCode int
+		buffer     bytes.Buffer
+		limit      int64
+		overflow   bool
+
+		// 重新验证时不是 304 才转发
+		revalidate http.ResponseWriter
+	}
+)
+
+// 可以缓存的状态码  RFC 7231 6.1
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusPermanentRedirect:    true,
+}
+
+// 不保存的逐跳响应头
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// NewCache 包装 New 返回的 handler  遵守 upstream 的 Cache-Control Expires ETag Last-Modified Vary
+func NewCache(next http.Handler, c CacheConfig) *Cache {
+	if c.Store == nil {
+		panic("proxy: cache store is empty")
+	}
+	if c.Stale == 0 {
+		c.Stale = time.Hour
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = 10 << 20
+	}
+	return &Cache{
+		config: c,
+		next:   next,
+	}
+}
+
+func (cache *Cache) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "PURGE" {
+		cache.purge(w, req)
+		return
+	}
+	// 带 Cookie 的响应可能是个人的
+	if (req.Method != http.MethodGet && req.Method != http.MethodHead) || req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "" {
+		w.Header().Set("X-Cache", "BYPASS")
+		cache.next.ServeHTTP(w, req)
+		return
+	}
+	requestControl := parseCacheControl(req.Header.Get("Cache-Control"))
+	if _, ok := requestControl["no-store"]; ok {
+		w.Header().Set("X-Cache", "BYPASS")
+		cache.next.ServeHTTP(w, req)
+		return
+	}
+
+	now := clock.Now()
+	entry := cache.get(req)
+	_, noCache := requestControl["no-cache"]
+	if entry != nil && !noCache && now.Before(entry.ExpiresAt) {
+		cache.serve(w, req, entry, "HIT", now)
+		return
+	}
+
+	// 过期的用 upstream 重新验证
+	if entry != nil && (entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != "") {
+		upstream := req.WithContext(req.Context())
+		upstream.Header = cloneHeader(req.Header)
+		upstream.Header.Del("If-Modified-Since")
+		upstream.Header.Del("If-None-Match")
+		if val := entry.Header.Get("ETag"); val != "" {
+			upstream.Header.Set("If-None-Match", val)
+		}
+		if val := entry.Header.Get("Last-Modified"); val != "" {
+			upstream.Header.Set("If-Modified-Since", val)
+		}
+		writer := &cacheWriter{header: http.Header{}, limit: cache.config.MaxSize, revalidate: w}
+		cache.next.ServeHTTP(writer, upstream)
+		if writer.statusCode == 0 {
+			writer.WriteHeader(http.StatusOK)
+		}
+		if writer.statusCode == http.StatusNotModified {
+			for key, values := range writer.header {
+				switch key {
+				case "Cache-Control", "Expires", "Date", "ETag", "Last-Modified":
+					entry.Header[key] = values
+				}
+			}
+			if ttl, ok := freshness(writer.header, now); ok {
+				entry.StoredAt, entry.ExpiresAt = now, now.Add(ttl)
+				cache.set(req, entry)
+			}
+			cache.serve(w, req, entry, "REVALIDATED", now)
+			return
+		}
+		cache.store(req, writer, now)
+		return
+	}
+
+	w.Header().Set("X-Cache", "MISS")
+	writer := &cacheWriter{header: w.Header(), writer: w, limit: cache.config.MaxSize}
+	cache.next.ServeHTTP(writer, req)
+	cache.store(req, writer, now)
+}
+
+// Purge 清除一个 uri  uri 包括查询参数
+func (cache *Cache) Purge(host string, uri string) error {
+	return cache.config.Store.Delete(host, uri)
+}
+
+// Clear 清除 host 的全部缓存
+func (cache *Cache) Clear(host string) error {
+	return cache.config.Store.Clear(host)
+}
+
+// purge  PURGE /path 清除一个  带 X-Purge-All 清除整个 host
+func (cache *Cache) purge(w http.ResponseWriter, req *http.Request) {
+	key := req.Header.Get("X-Purge-Key")
+	valid := false
+	for _, val := range cache.config.PurgeKeys {
+		if key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(val)) == 1 {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	var err error
+	if req.Header.Get("X-Purge-All") != "" {
+		err = cache.Clear(req.Host)
+	} else {
+		err = cache.Purge(req.Host, req.URL.RequestURI())
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cache *Cache) get(req *http.Request) *cacheEntry {
+	uri := req.URL.RequestURI()
+	entry := cache.load(req.Host, uri)
+	if entry != nil && len(entry.Variants) != 0 {
+		entry = cache.load(req.Host, variantKey(uri, entry, req.Header))
+	}
+	if entry == nil {
+		return nil
+	}
+	for key, val := range entry.Vary {
+		if req.Header.Get(key) != val {
+			return nil
+		}
+	}
+	return entry
+}
+
+func (cache *Cache) load(host string, uri string) *cacheEntry {
+	data, err := cache.config.Store.Get(host, uri)
+	if err != nil || data == nil {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err = json.Unmarshal(data, entry); err != nil {
+		return nil
+	}
+	return entry
+}
+
+func (cache *Cache) set(req *http.Request, entry *cacheEntry) {
+	uri := req.URL.RequestURI()
+	if len(entry.Vary) == 0 {
+		cache.save(req.Host, uri, entry)
+		return
+	}
+	names := make([]string, 0, len(entry.Vary))
+	for name := range entry.Vary {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	index := cache.load(req.Host, uri)
+	if index == nil || strings.Join(index.Variants, ",") != strings.Join(names, ",") {
+		index = &cacheEntry{Variants: names, StoredAt: clock.Now()}
+	}
+	if entry.ExpiresAt.After(index.ExpiresAt) {
+		index.ExpiresAt = entry.ExpiresAt
+	}
+	cache.save(req.Host, uri, index)
+	cache.save(req.Host, variantKey(uri, index, req.Header), entry)
+}
+
+func (cache *Cache) save(host string, uri string, entry *cacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	cache.config.Store.Set(host, uri, data, clock.Until(entry.ExpiresAt)+cache.config.Stale)
+}
+
+// variantKey uri#index 的时间?Vary 请求头的值
+func variantKey(uri string, index *cacheEntry, header http.Header) string {
+	values := url.Values{}
+	for _, name := range index.Variants {
+		values.Set(name, header.Get(name))
+	}
+	return uri + "#" + strconv.FormatInt(index.StoredAt.UnixNano(), 36) + "?" + values.Encode()
+}
+
+func (cache *Cache) store(req *http.Request, writer *cacheWriter, now time.Time) {
+	// HEAD 没有 body
+	if req.Method != http.MethodGet || writer.overflow || !cacheableStatus[writer.status()] {
+		return
+	}
+	header := writer.header
+	if header.Get("Set-Cookie") != "" {
+		return
+	}
+	control := parseCacheControl(header.Get("Cache-Control"))
+	for _, name := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := control[name]; ok {
+			return
+		}
+	}
+	ttl, ok := freshness(header, now)
+	if !ok || ttl <= 0 {
+		return
+	}
+	entry := &cacheEntry{
+		StatusCode: writer.status(),
+		Header:     cloneHeader(header),
+		Body:       writer.buffer.Bytes(),
+		StoredAt:   now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	for _, name := range hopHeaders {
+		entry.Header.Del(name)
+	}
+	entry.Header.Del("X-Cache")
+	for _, val := range header["Vary"] {
+		for _, name := range strings.Split(val, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			// 每个请求都不同
+			if name == "*" {
+				return
+			}
+			if entry.Vary == nil {
+				entry.Vary = map[string]string{}
+			}
+			entry.Vary[name] = req.Header.Get(name)
+		}
+	}
+	cache.set(req, entry)
+}
+
+func (cache *Cache) serve(w http.ResponseWriter, req *http.Request, entry *cacheEntry, status string, now time.Time) {
+	header := w.Header()
+	copyHeader(header, entry.Header)
+	header.Set("Age", strconv.FormatInt(int64(now.Sub(entry.StoredAt)/time.Second), 10))
+	header.Set("X-Cache", status)
+	if entry.StatusCode == http.StatusOK && notModified(req, entry.Header) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	w.WriteHeader(entry.StatusCode)
+	if req.Method != http.MethodHead {
+		w.Write(entry.Body)
+	}
+}
+
+func (writer *cacheWriter) Header() http.Header {
+	return writer.header
+}
+
+func (writer *cacheWriter) WriteHeader(statusCode int) {
+	if writer.statusCode != 0 {
+		return
+	}
+	writer.statusCode = statusCode
+	if writer.revalidate != nil && statusCode != http.StatusNotModified {
+		copyHeader(writer.revalidate.Header(), writer.header)
+		writer.revalidate.Header().Set("X-Cache", "MISS")
+		writer.writer = writer.revalidate
+	}
+	if writer.writer != nil {
+		writer.writer.WriteHeader(statusCode)
+	}
+}
+
+func (writer *cacheWriter) Write(data []byte) (int, error) {
+	if writer.statusCode == 0 {
+		writer.WriteHeader(http.StatusOK)
+	}
+	// 超过 limit 后只转发  不再保存
+	if !writer.overflow {
+		if int64(writer.buffer.Len()+len(data)) > writer.limit {
+			writer.overflow = true
+			writer.buffer = bytes.Buffer{}
+		} else {
+			writer.buffer.Write(data)
+		}
+	}
+	if writer.writer != nil {
+		return writer.writer.Write(data)
+	}
+	return len(data), nil
+}
+
+func (writer *cacheWriter) Flush() {
+	if flusher, ok := writer.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (writer *cacheWriter) status() int {
+	if writer.statusCode == 0 {
+		return http.StatusOK
+	}
+	return writer.statusCode
+}
+
+// freshness s-maxage > max-age > Expires
+func freshness(header http.Header, now time.Time) (time.Duration, bool) {
+	control := parseCacheControl(header.Get("Cache-Control"))
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if val, ok := control[name]; ok {
+			seconds, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			age, _ := strconv.ParseInt(header.Get("Age"), 10, 64)
+			return time.Duration(seconds-age) * time.Second, true
+		}
+	}
+	if val := header.Get("Expires"); val != "" {
+		expires, err := http.ParseTime(val)
+		if err != nil {
+			return 0, false
+		}
+		date := now
+		if val, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = val
+		}
+		return expires.Sub(date), true
+	}
+	return 0, false
+}
+
+func notModified(req *http.Request, header http.Header) bool {
+	if val := req.Header.Get("If-None-Match"); val != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(val, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if val := req.Header.Get("If-Modified-Since"); val != "" {
+		since, err := http.ParseTime(val)
+		if err != nil {
+			return false
+		}
+		modified, err := http.ParseTime(header.Get("Last-Modified"))
+		return err == nil && !modified.After(since)
+	}
+	return false
+}
+
+func parseCacheControl(value string) map[string]string {
+	control := map[string]string{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if index := strings.Index(part, "="); index != -1 {
+			control[strings.ToLower(strings.TrimSpace(part[:index]))] = strings.Trim(strings.TrimSpace(part[index+1:]), "\"")
+		} else {
+			control[strings.ToLower(part)] = ""
+		}
+	}
+	return control
+}
+
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header, len(header))
+	copyHeader(clone, header)
+	return clone
+}
+
+func copyHeader(dst http.Header, src http.Header) {
+	for key, values := range src {
+		dst[key] = append([]string(nil), values...)
+	}
+}
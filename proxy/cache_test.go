@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryCacheStore struct {
+	mutex  sync.Mutex
+	values map[string][]byte
+}
+
+func (store *memoryCacheStore) Get(host string, uri string) ([]byte, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.values[host+uri], nil
+}
+
+func (store *memoryCacheStore) Set(host string, uri string, value []byte, ttl time.Duration) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.values[host+uri] = value
+	return nil
+}
+
+func (store *memoryCacheStore) Delete(host string, uri string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.values, host+uri)
+	return nil
+}
+
+func (store *memoryCacheStore) Clear(host string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.values = map[string][]byte{}
+	return nil
+}
+
+func newTestCache(maxSize int64, handler http.HandlerFunc) *Cache {
+	return NewCache(handler, CacheConfig{
+		Store:   &memoryCacheStore{values: map[string][]byte{}},
+		MaxSize: maxSize,
+	})
+}
+
+func cacheGet(cache *Cache, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/a", nil)
+	for key, val := range header {
+		req.Header.Set(key, val)
+	}
+	recorder := httptest.NewRecorder()
+	cache.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestCacheVary(t *testing.T) {
+	cache := newTestCache(0, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte("lang=" + req.Header.Get("Accept-Language")))
+	})
+	for _, lang := range []string{"en", "fr"} {
+		if res := cacheGet(cache, map[string]string{"Accept-Language": lang}); res.Header().Get("X-Cache") != "MISS" {
+			t.Fatalf("%s: first X-Cache %s", lang, res.Header().Get("X-Cache"))
+		}
+	}
+	// 两个组合都保存  不互相覆盖
+	for _, lang := range []string{"en", "fr"} {
+		res := cacheGet(cache, map[string]string{"Accept-Language": lang})
+		if res.Header().Get("X-Cache") != "HIT" {
+			t.Fatalf("%s: X-Cache %s", lang, res.Header().Get("X-Cache"))
+		}
+		if res.Body.String() != "lang="+lang {
+			t.Fatalf("%s: body %q", lang, res.Body.String())
+		}
+	}
+
+	// Purge 之后旧的组合不能再使用
+	cache.Purge("example.com", "/a")
+	cacheGet(cache, map[string]string{"Accept-Language": "en"})
+	if res := cacheGet(cache, map[string]string{"Accept-Language": "fr"}); res.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("after purge X-Cache %s", res.Header().Get("X-Cache"))
+	}
+}
+
+func TestCacheCookieBypass(t *testing.T) {
+	cache := newTestCache(0, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("user"))
+	})
+	if res := cacheGet(cache, map[string]string{"Cookie": "session=1"}); res.Header().Get("X-Cache") != "BYPASS" {
+		t.Fatalf("X-Cache %s", res.Header().Get("X-Cache"))
+	}
+	if res := cacheGet(cache, nil); res.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("response with cookie was cached: X-Cache %s", res.Header().Get("X-Cache"))
+	}
+}
+
+func TestCacheWriterLimit(t *testing.T) {
+	for _, forward := range []bool{true, false} {
+		recorder := httptest.NewRecorder()
+		writer := &cacheWriter{header: http.Header{}, limit: 8}
+		if forward {
+			writer.writer = recorder
+		}
+		for i := 0; i < 4; i++ {
+			writer.Write([]byte("0123456789"))
+		}
+		if !writer.overflow || writer.buffer.Len() != 0 {
+			t.Fatalf("forward %v: overflow %v buffered %d", forward, writer.overflow, writer.buffer.Len())
+		}
+		if forward && recorder.Body.Len() != 40 {
+			t.Fatalf("forwarded %d", recorder.Body.Len())
+		}
+	}
+}
+
+func TestCacheRevalidateLarge(t *testing.T) {
+	body := strings.Repeat("a", 64)
+	calls := 0
+	cache := newTestCache(32, func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set("ETag", `"1"`)
+		w.Header().Set("Cache-Control", "max-age=0")
+		if calls == 1 {
+			w.Write([]byte("small"))
+			return
+		}
+		w.Header().Set("ETag", `"2"`)
+		w.Write([]byte(body))
+	})
+	entry := &cacheEntry{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Etag": {`"1"`}},
+		Body:       []byte("small"),
+		StoredAt:   time.Now().Add(-time.Hour),
+		ExpiresAt:  time.Now().Add(-time.Minute),
+	}
+	cache.set(httptest.NewRequest(http.MethodGet, "http://example.com/a", nil), entry)
+	calls = 1
+	res := cacheGet(cache, nil)
+	if res.Header().Get("X-Cache") != "MISS" || res.Body.String() != body {
+		t.Fatalf("X-Cache %s body %d", res.Header().Get("X-Cache"), res.Body.Len())
+	}
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/clock"
+)
+
+type (
+	RedisCache struct {
+		Client *redis.Client
+
+		// 默认 proxycache.
+		Prefix string
+	}
+
+	// DiskCache 每个 host 一个目录  过期的在读取时删除
+	DiskCache struct {
+		Dir string
+	}
+)
+
+var globEscaper = strings.NewReplacer("\\", "\\\\", "*", "\\*", "?", "\\?", "[", "\\[", "]", "\\]")
+
+func (store *RedisCache) key(host string, uri string) string {
+	prefix := store.Prefix
+	if prefix == "" {
+		prefix = "proxycache."
+	}
+	return prefix + strings.ToLower(host) + "|" + uri
+}
+
+func (store *RedisCache) Get(host string, uri string) ([]byte, error) {
+	data, err := store.Client.Get(store.key(host, uri)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (store *RedisCache) Set(host string, uri string, value []byte, ttl time.Duration) error {
+	return store.Client.Set(store.key(host, uri), value, ttl).Err()
+}
+
+func (store *RedisCache) Delete(host string, uri string) error {
+	return store.Client.Del(store.key(host, uri)).Err()
+}
+
+func (store *RedisCache) Clear(host string) error {
+	match := globEscaper.Replace(store.key(host, "")) + "*"
+	var cursor uint64
+	for {
+		keys, next, err := store.Client.Scan(cursor, match, 500).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) != 0 {
+			if err = store.Client.Del(keys...).Err(); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (store *DiskCache) dir(host string) string {
+	return filepath.Join(store.Dir, hash(strings.ToLower(host)))
+}
+
+func (store *DiskCache) file(host string, uri string) string {
+	return filepath.Join(store.dir(host), hash(uri))
+}
+
+// Get 前 8 字节是过期时间
+func (store *DiskCache) Get(host string, uri string) ([]byte, error) {
+	name := store.file(host, uri)
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 || clock.Now().UnixNano() > int64(binary.BigEndian.Uint64(data[:8])) {
+		os.Remove(name)
+		return nil, nil
+	}
+	return data[8:], nil
+}
+
+// Set 先写临时文件再改名  读取时不会读到一半
+func (store *DiskCache) Set(host string, uri string, value []byte, ttl time.Duration) error {
+	dir := store.dir(host)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	expires := make([]byte, 8)
+	binary.BigEndian.PutUint64(expires, uint64(clock.Now().Add(ttl).UnixNano()))
+	if _, err = file.Write(append(expires, value...)); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), store.file(host, uri))
+}
+
+func (store *DiskCache) Delete(host string, uri string) error {
+	if err := os.Remove(store.file(host, uri)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store *DiskCache) Clear(host string) error {
+	return os.RemoveAll(store.dir(host))
+}
+
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/otamoe/gin-server/utils"
+)
+
+type (
+	Config struct {
+		Upstream string
+
+		// 多个 upstream  设置后忽略 Upstream
+		Balancer *Balancer
+
+		// 默认使用 upstream 的 host
+		PreserveHost bool
+
+		// 来自这些地址时保留请求中的 X-Forwarded-Host X-Forwarded-Proto  否则覆盖
+		TrustedProxies []*net.IPNet
+
+		// 转发前删除的路径前缀
+		StripPrefix string
+
+		// 请求头 值为空时删除
+		Headers map[string]string
+		// 响应头 值为空时删除
+		ResponseHeaders map[string]string
+
+		// upstream tls
+		InsecureSkipVerify bool
+		ServerName         string
+		CAFile             string
+		CertFile           string
+		KeyFile            string
+
+		DialTimeout           time.Duration
+		ResponseHeaderTimeout time.Duration
+		IdleConnTimeout       time.Duration
+		MaxIdleConns          int
+
+		// 连接失败重试次数 只重试没有 body 的请求
+		Retries    int
+		RetryDelay time.Duration
+
+		FlushInterval time.Duration
+
+		ErrorHandler func(w http.ResponseWriter, req *http.Request, err error)
+	}
+
+	retryTransport struct {
+		next    http.RoundTripper
+		retries int
+		delay   time.Duration
+	}
+)
+
+func New(c Config) http.Handler {
+	if c.Balancer != nil && c.Upstream == "" {
+		c.Upstream = c.Balancer.upstreams[0].URL.String()
+	}
+	target, err := url.Parse(c.Upstream)
+	if err != nil {
+		panic(err)
+	}
+	if target.Scheme == "" || target.Host == "" {
+		panic(errors.New("proxy: upstream " + c.Upstream + " is invalid"))
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = time.Second * 5
+	}
+	if c.ResponseHeaderTimeout == 0 {
+		c.ResponseHeaderTimeout = time.Second * 60
+	}
+	if c.IdleConnTimeout == 0 {
+		c.IdleConnTimeout = time.Second * 90
+	}
+	if c.MaxIdleConns == 0 {
+		c.MaxIdleConns = 256
+	}
+	if c.RetryDelay == 0 {
+		c.RetryDelay = time.Millisecond * 100
+	}
+	if c.ErrorHandler == nil {
+		c.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+			if err == context.Canceled {
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   c.DialTimeout,
+			KeepAlive: time.Second * 30,
+		}).DialContext,
+		TLSClientConfig:       c.tlsConfig(),
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConns,
+		TLSHandshakeTimeout:   c.DialTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	reverseProxy := httputil.NewSingleHostReverseProxy(target)
+	director := reverseProxy.Director
+	reverseProxy.Director = func(req *http.Request) {
+		host := req.Host
+		if c.StripPrefix != "" {
+			req.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, c.StripPrefix), "/")
+			if req.URL.RawPath != "" {
+				req.URL.RawPath = "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.RawPath, c.StripPrefix), "/")
+			}
+		}
+		if c.Balancer != nil {
+			c.Balancer.direct(req, c.Balancer.Pick(nil))
+		} else {
+			director(req)
+		}
+		if c.PreserveHost {
+			req.Host = host
+		} else {
+			req.Host = req.URL.Host
+		}
+		// 客户端可以伪造  只保留信任的代理设置的
+		trusted := utils.ContainsIP(c.TrustedProxies, utils.RemoteIP(req))
+		if !trusted || req.Header.Get("X-Forwarded-Host") == "" {
+			req.Header.Set("X-Forwarded-Host", host)
+		}
+		if !trusted || req.Header.Get("X-Forwarded-Proto") == "" {
+			if req.TLS != nil {
+				req.Header.Set("X-Forwarded-Proto", "https")
+			} else {
+				req.Header.Set("X-Forwarded-Proto", "http")
+			}
+		}
+		setHeaders(req.Header, c.Headers)
+	}
+	reverseProxy.ModifyResponse = func(res *http.Response) error {
+		setHeaders(res.Header, c.ResponseHeaders)
+		return nil
+	}
+	if c.Balancer != nil {
+		if c.Balancer.transport == nil {
+			c.Balancer.transport = transport
+		}
+		reverseProxy.Transport = &balancerTransport{
+			next:     transport,
+			balancer: c.Balancer,
+			retries:  c.Retries,
+			delay:    c.RetryDelay,
+		}
+	} else {
+		reverseProxy.Transport = &retryTransport{
+			next:    transport,
+			retries: c.Retries,
+			delay:   c.RetryDelay,
+		}
+	}
+	reverseProxy.FlushInterval = c.FlushInterval
+	reverseProxy.ErrorHandler = c.ErrorHandler
+	return reverseProxy
+}
+
+func (c Config) tlsConfig() *tls.Config {
+	config := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		ServerName:         c.ServerName,
+	}
+	if c.CAFile != "" {
+		data, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			panic(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			panic(errors.New("proxy: ca file " + c.CAFile + " is invalid"))
+		}
+		config.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			panic(err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	for i := 0; ; i++ {
+		if res, err = t.next.RoundTrip(req); err == nil {
+			return
+		}
+		if i >= t.retries || !isDialError(err) || (req.Body != nil && req.Body != http.NoBody) {
+			return
+		}
+		select {
+		case <-req.Context().Done():
+			return
+		case <-time.After(t.delay):
+		}
+	}
+}
+
+func isDialError(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		return opErr.Op == "dial"
+	}
+	return false
+}
+
+func setHeaders(header http.Header, values map[string]string) {
+	for key, val := range values {
+		if val == "" {
+			header.Del(key)
+		} else {
+			header.Set(key, val)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedProto(t *testing.T) {
+	var proto string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proto = req.Header.Get("X-Forwarded-Proto")
+	}))
+	defer upstream.Close()
+
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	handler := New(Config{
+		Upstream:       upstream.URL,
+		TrustedProxies: []*net.IPNet{trusted},
+	})
+	tests := []struct {
+		remote string
+		header string
+		want   string
+	}{
+		{"1.1.1.1:1234", "https", "http"},
+		{"1.1.1.1:1234", "", "http"},
+		{"10.0.0.1:1234", "https", "https"},
+		{"10.0.0.1:1234", "", "http"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remote
+		if test.header != "" {
+			req.Header.Set("X-Forwarded-Proto", test.header)
+		}
+		proto = ""
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if proto != test.want {
+			t.Errorf("%s %q: X-Forwarded-Proto %q, want %q", test.remote, test.header, proto, test.want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/otamoe/gin-server/utils"
+)
+
+type (
+	Config struct {
+		// 只接受来自这些地址的 PROXY 头  为空时都不接受  全部接受需要明确写 0.0.0.0/0 ::/0
+		Trusted []*net.IPNet
+
+		// 来自 Trusted 的连接必须有 PROXY 头
+		Required bool
+
+		// 读取 PROXY 头的超时
+		Timeout time.Duration
+	}
+
+	// Listener 在后台读取 PROXY 头  不阻塞 Accept
+	Listener struct {
+		net.Listener
+		config Config
+		conns  chan net.Conn
+		errs   chan error
+		done   chan struct{}
+		once   sync.Once
+		close  sync.Once
+	}
+
+	Conn struct {
+		net.Conn
+		reader *bufio.Reader
+		remote net.Addr
+		local  net.Addr
+	}
+)
+
+var signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+var ErrInvalid = errors.New("ProxyProto: invalid header")
+
+var ErrRequired = errors.New("ProxyProto: header is required")
+
+func Listen(listener net.Listener, c Config) *Listener {
+	if c.Timeout == 0 {
+		c.Timeout = time.Second * 5
+	}
+	return &Listener{
+		Listener: listener,
+		config:   c,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+}
+
+func (listener *Listener) Accept() (net.Conn, error) {
+	listener.once.Do(func() {
+		go listener.run()
+	})
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case err := <-listener.errs:
+		return nil, err
+	case <-listener.done:
+		return nil, errors.New("ProxyProto: use of closed network connection")
+	}
+}
+
+func (listener *Listener) Close() error {
+	listener.close.Do(func() {
+		close(listener.done)
+	})
+	return listener.Listener.Close()
+}
+
+func (listener *Listener) run() {
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			select {
+			case listener.errs <- err:
+			case <-listener.done:
+				return
+			}
+			if err, ok := err.(net.Error); ok && err.Temporary() {
+				continue
+			}
+			return
+		}
+		go listener.handshake(conn)
+	}
+}
+
+func (listener *Listener) handshake(conn net.Conn) {
+	var ip net.IP
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+	if !utils.ContainsIP(listener.config.Trusted, ip) {
+		listener.deliver(conn)
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(listener.config.Timeout))
+	c, err := Read(conn)
+	if err == nil && c.remote == nil && listener.config.Required {
+		err = ErrRequired
+	}
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	listener.deliver(c)
+}
+
+func (listener *Listener) deliver(conn net.Conn) {
+	select {
+	case listener.conns <- conn:
+	case <-listener.done:
+		conn.Close()
+	}
+}
+
+// Read 读取 v1 v2 PROXY 头  没有时 RemoteAddr 不变
+func Read(conn net.Conn) (c *Conn, err error) {
+	c = &Conn{
+		Conn:   conn,
+		reader: bufio.NewReaderSize(conn, 256),
+	}
+	var head []byte
+	if head, err = c.reader.Peek(1); err != nil {
+		return
+	}
+	switch head[0] {
+	case 'P':
+		if head, err = c.reader.Peek(6); err != nil {
+			return
+		}
+		if string(head) == "PROXY " {
+			err = c.readV1()
+		}
+	case signature[0]:
+		if head, err = c.reader.Peek(len(signature)); err != nil {
+			return
+		}
+		if bytes.Equal(head, signature) {
+			err = c.readV2()
+		}
+	}
+	return
+}
+
+// readV1 PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func (c *Conn) readV1() error {
+	var line []byte
+	for len(line) < 107 {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return ErrInvalid
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return ErrInvalid
+	}
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || err1 != nil || err2 != nil {
+		return ErrInvalid
+	}
+	c.remote = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	c.local = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return nil
+}
+
+// readV2 signature + ver_cmd + fam + len + addresses + tlv
+func (c *Conn) readV2() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return err
+	}
+	if header[12]>>4 != 2 {
+		return ErrInvalid
+	}
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		return err
+	}
+	// LOCAL 健康检查等  使用真实地址
+	if header[12]&0x0F == 0 {
+		return nil
+	}
+	switch header[13] >> 4 {
+	case 1:
+		if length < 12 {
+			return ErrInvalid
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(data[0:4]), Port: int(binary.BigEndian.Uint16(data[8:10]))}
+		c.local = &net.TCPAddr{IP: net.IP(data[4:8]), Port: int(binary.BigEndian.Uint16(data[10:12]))}
+	case 2:
+		if length < 36 {
+			return ErrInvalid
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(data[0:16]), Port: int(binary.BigEndian.Uint16(data[32:34]))}
+		c.local = &net.TCPAddr{IP: net.IP(data[16:32]), Port: int(binary.BigEndian.Uint16(data[34:36]))}
+	}
+	return nil
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if c.reader.Buffered() == 0 {
+		return c.Conn.Read(p)
+	}
+	return c.reader.Read(p)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
@@ -0,0 +1,45 @@
+package proxyproto
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenTrusted(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	tests := []struct {
+		name    string
+		trusted []*net.IPNet
+		want    string
+	}{
+		// 为空时不接受 PROXY 头
+		{"empty", nil, "127.0.0.1"},
+		{"trusted", []*net.IPNet{loopback}, "203.0.113.7"},
+	}
+	for _, test := range tests {
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener := Listen(ln, Config{Trusted: test.trusted, Timeout: time.Second})
+
+		client, err := net.Dial("tcp4", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Write([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 4711 80\r\n"))
+
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		if host != test.want {
+			t.Errorf("%s: RemoteAddr %s, want %s", test.name, host, test.want)
+		}
+		conn.Close()
+		client.Close()
+		listener.Close()
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/otamoe/gin-server/proxyproto"
+	"github.com/otamoe/gin-server/utils"
+)
+
+type (
+	// ProxyProtocol 四层负载均衡的 PROXY 头 v1 v2  RemoteAddr 替换为客户端地址
+	ProxyProtocol struct {
+		// 负载均衡的地址  必须配置  全部接受需要明确写 0.0.0.0/0 ::/0
+		Trusted []string `json:"trusted,omitempty"`
+
+		// 来自 Trusted 的连接必须有 PROXY 头
+		Required bool `json:"required,omitempty"`
+
+		Timeout time.Duration `json:"timeout,omitempty"`
+
+		trusted []*net.IPNet
+	}
+)
+
+func (config *ProxyProtocol) init(server *Server, handler *Handler) {
+	if config.Timeout == 0 {
+		config.Timeout = time.Second * 5
+	}
+	var err error
+	if config.trusted, err = utils.ParseCIDRs(config.Trusted); err != nil {
+		panic(err)
+	}
+}
+
+func (config *ProxyProtocol) wrap(listener net.Listener) net.Listener {
+	return proxyproto.Listen(listener, proxyproto.Config{
+		Trusted:  config.trusted,
+		Required: config.Required,
+		Timeout:  config.Timeout,
+	})
+}
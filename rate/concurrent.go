@@ -0,0 +1,155 @@
+package rate
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/backpressure"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/utils"
+)
+
+type (
+	// 单实例使用  按客户端 IP 限制同时处理的请求数 和 连接数
+	ConcurrentConfig struct {
+		// 0 不限制
+		Requests int64
+
+		// 来自信任的代理时不检查  0 不限制
+		Connections int64
+
+		// 按网段合并  默认 ipv4 /32  ipv6 /64
+		IPv4Prefix int
+		IPv6Prefix int
+
+		// 来自信任的代理时不检查连接数
+		TrustedProxies []*net.IPNet
+
+		// 注册连接关闭的回调  用于释放连接数
+		ConnClosed func(callback func(remoteAddr string))
+	}
+
+	concurrentLimiter struct {
+		config   ConcurrentConfig
+		mutex    sync.Mutex
+		requests map[string]int64
+		conns    map[string]map[string]struct{}
+		keys     map[string]string
+	}
+)
+
+func MiddlewareConcurrent(c ConcurrentConfig) gin.HandlerFunc {
+	if c.IPv4Prefix == 0 {
+		c.IPv4Prefix = 32
+	}
+	if c.IPv6Prefix == 0 {
+		c.IPv6Prefix = 64
+	}
+	limiter := &concurrentLimiter{
+		config:   c,
+		requests: map[string]int64{},
+		conns:    map[string]map[string]struct{}{},
+		keys:     map[string]string{},
+	}
+	if c.Connections > 0 && c.ConnClosed != nil {
+		c.ConnClosed(limiter.closed)
+	} else {
+		limiter.config.Connections = 0
+	}
+
+	return func(ctx *gin.Context) {
+		remoteIP := utils.RemoteIP(ctx.Request)
+
+		if limiter.config.Connections > 0 && !utils.ContainsIP(c.TrustedProxies, remoteIP) {
+			if !limiter.connect(limiter.key(remoteIP), ctx.Request.RemoteAddr) {
+				ctx.Header("Connection", "close")
+				limiter.abort(ctx, "connections", limiter.config.Connections)
+				return
+			}
+		}
+
+		if c.Requests > 0 {
+			key := limiter.key(net.ParseIP(clientip.ClientIP(ctx)))
+			if !limiter.acquire(key) {
+				limiter.abort(ctx, "requests", c.Requests)
+				return
+			}
+			defer limiter.release(key)
+		}
+		ctx.Next()
+	}
+}
+
+// key 按网段合并
+func (limiter *concurrentLimiter) key(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(limiter.config.IPv4Prefix, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(limiter.config.IPv6Prefix, 128)).String()
+}
+
+func (limiter *concurrentLimiter) acquire(key string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.requests[key] >= limiter.config.Requests {
+		return false
+	}
+	limiter.requests[key]++
+	return true
+}
+
+func (limiter *concurrentLimiter) release(key string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.requests[key]--; limiter.requests[key] <= 0 {
+		delete(limiter.requests, key)
+	}
+}
+
+// connect 连接第一个请求时计数  连接关闭时释放
+func (limiter *concurrentLimiter) connect(key string, remoteAddr string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	conns := limiter.conns[key]
+	if _, ok := conns[remoteAddr]; ok {
+		return true
+	}
+	if int64(len(conns)) >= limiter.config.Connections {
+		return false
+	}
+	if conns == nil {
+		conns = map[string]struct{}{}
+		limiter.conns[key] = conns
+	}
+	conns[remoteAddr] = struct{}{}
+	limiter.keys[remoteAddr] = key
+	return true
+}
+
+func (limiter *concurrentLimiter) closed(remoteAddr string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	key, ok := limiter.keys[remoteAddr]
+	if !ok {
+		return
+	}
+	delete(limiter.keys, remoteAddr)
+	conns := limiter.conns[key]
+	delete(conns, remoteAddr)
+	if len(conns) == 0 {
+		delete(limiter.conns, key)
+	}
+}
+
+func (limiter *concurrentLimiter) abort(ctx *gin.Context, name string, limit int64) {
+	// 不知道什么时候释放  使用默认值
+	err := backpressure.Error(http.StatusTooManyRequests, "rate", backpressure.DefaultRetry)
+	err.Path = name
+	err.Params["limit"] = limit
+	backpressure.Abort(ctx, err, backpressure.DefaultRetry)
+}
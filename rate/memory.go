@@ -0,0 +1,146 @@
+package rate
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/backpressure"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/clock"
+)
+
+type (
+	// 单实例使用 不依赖 redis
+	MemoryConfig struct {
+		Global Bucket
+		IP     Bucket
+
+		// ip bucket 空闲多久后清理
+		IdleTimeout time.Duration
+	}
+
+	Bucket struct {
+		// 每秒补充
+		Rate  float64
+		Burst int64
+	}
+
+	// GCRA  tat 保存理论到达时间  CAS 更新 无锁
+	bucket struct {
+		tat      int64
+		interval int64
+		burst    int64
+	}
+
+	memoryLimiter struct {
+		config MemoryConfig
+		global *bucket
+		ips    sync.Map
+	}
+)
+
+func MiddlewareMemory(c MemoryConfig) gin.HandlerFunc {
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = time.Minute * 10
+	}
+	limiter := &memoryLimiter{
+		config: c,
+		global: newBucket(c.Global),
+	}
+	if limiter.config.IP.Rate > 0 {
+		go limiter.cleanup()
+	}
+
+	return func(ctx *gin.Context) {
+		now := clock.Now().UnixNano()
+
+		if limiter.global != nil {
+			if ok, _, retry := limiter.global.take(now); !ok {
+				limiter.abort(ctx, limiter.global, retry)
+				return
+			}
+		}
+
+		if limiter.config.IP.Rate > 0 {
+			ip := clientip.ClientIP(ctx)
+			val, ok := limiter.ips.Load(ip)
+			if !ok {
+				val, _ = limiter.ips.LoadOrStore(ip, newBucket(limiter.config.IP))
+			}
+			b := val.(*bucket)
+			ok, remaining, retry := b.take(now)
+			ctx.Header("X-RateLimit-Limit", strconv.FormatInt(b.burst, 10))
+			ctx.Header("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+			if !ok {
+				limiter.abort(ctx, b, retry)
+				return
+			}
+		}
+
+		ctx.Next()
+	}
+}
+
+func (limiter *memoryLimiter) abort(ctx *gin.Context, b *bucket, retry time.Duration) {
+	reset := clock.Now().Add(retry)
+	ctx.Header("X-RateLimit-Limit", strconv.FormatInt(b.burst, 10))
+	ctx.Header("X-RateLimit-Remaining", "0")
+	ctx.Header("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	err := backpressure.Error(http.StatusTooManyRequests, "rate", retry)
+	err.Params["limit"] = b.burst
+	err.Params["reset"] = reset
+	backpressure.Abort(ctx, err, retry)
+}
+
+func (limiter *memoryLimiter) cleanup() {
+	ticker := clock.NewTicker(limiter.config.IdleTimeout)
+	defer ticker.Stop()
+	for range ticker.C() {
+		now := clock.Now().UnixNano()
+		limiter.ips.Range(func(key, val interface{}) bool {
+			// 已经补满并且空闲
+			if atomic.LoadInt64(&val.(*bucket).tat)+int64(limiter.config.IdleTimeout) < now {
+				limiter.ips.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+func newBucket(c Bucket) *bucket {
+	if c.Rate <= 0 {
+		return nil
+	}
+	if c.Burst <= 0 {
+		c.Burst = 1
+	}
+	return &bucket{
+		interval: int64(float64(time.Second) / c.Rate),
+		burst:    c.Burst,
+	}
+}
+
+func (b *bucket) take(now int64) (ok bool, remaining int64, retry time.Duration) {
+	limit := b.interval * b.burst
+	for {
+		tat := atomic.LoadInt64(&b.tat)
+		next := tat
+		if next < now {
+			next = now
+		}
+		next += b.interval
+		if next-now > limit {
+			retry = time.Duration(next - now - limit)
+			return
+		}
+		if atomic.CompareAndSwapInt64(&b.tat, tat, next) {
+			ok = true
+			remaining = (limit - (next - now)) / b.interval
+			return
+		}
+	}
+}
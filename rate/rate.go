@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -11,7 +12,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
-	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/backpressure"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/clock"
 	redisMiddleware "github.com/otamoe/gin-server/redis"
 	"github.com/otamoe/gin-server/utils"
 )
@@ -31,6 +34,8 @@ var CONTEXT = "GIN.SERVER.RATE"
 
 var PREFIX = "rate"
 
+var ErrRedis = errors.New("rate: redis middleware is required")
+
 func Middleware(rates ...Config) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var err error
@@ -45,6 +50,15 @@ func Middleware(rates ...Config) gin.HandlerFunc {
 			}
 			ctx.Next()
 		}()
+		// redis 不可用时不限制
+		if redisMiddleware.Degraded(ctx) {
+			return
+		}
+		// 没有 redis 中间件是配置错误  不能不限制
+		if !redisMiddleware.Available(ctx) {
+			err = ErrRedis
+			return
+		}
 		redisClient := ctx.MustGet(redisMiddleware.CONTEXT).(*redis.Client)
 
 		for i, rate := range rates {
@@ -64,7 +78,7 @@ func Middleware(rates ...Config) gin.HandlerFunc {
 
 				// ip
 				if rate.IP {
-					keys = append(keys, base64.StdEncoding.EncodeToString([]byte(ctx.ClientIP())))
+					keys = append(keys, base64.StdEncoding.EncodeToString([]byte(clientip.ClientIP(ctx))))
 				}
 
 				// keys
@@ -72,7 +86,7 @@ func Middleware(rates ...Config) gin.HandlerFunc {
 					keys = append(keys, getValue(ctx, val))
 				}
 
-				key = strings.Join(keys, ".")
+				key = redisMiddleware.Key(ctx, strings.Join(keys, "."))
 			}
 
 			// 剩余
@@ -102,9 +116,9 @@ func Middleware(rates ...Config) gin.HandlerFunc {
 			}
 
 			if ttl > 0 {
-				rateReset = rateReset.Add(ttl)
+				rateReset = clock.Now().Add(ttl)
 			} else {
-				rateReset = rateReset.Add(rate.Reset)
+				rateReset = clock.Now().Add(rate.Reset)
 			}
 
 			if limit == 0 || remaining > rateRemaining {
@@ -143,15 +157,12 @@ func Middleware(rates ...Config) gin.HandlerFunc {
 			ctx.Header("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
 			ctx.Header("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
 			if remaining == 0 && gin.Mode() != gin.DebugMode {
-				err = &errs.Error{
-					Message:    http.StatusText(http.StatusTooManyRequests),
-					Type:       "rate",
-					StatusCode: http.StatusTooManyRequests,
-					Params: map[string]interface{}{
-						"limit": limit,
-						"reset": reset,
-					},
-				}
+				retry := time.Until(reset)
+				backpressure.RetryAfter(ctx.Writer.Header(), retry)
+				e := backpressure.Error(http.StatusTooManyRequests, "rate", retry)
+				e.Params["limit"] = limit
+				e.Params["reset"] = reset
+				err = e
 				return
 			}
 		}
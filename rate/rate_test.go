@@ -0,0 +1,43 @@
+package rate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+)
+
+func TestMiddlewareRedis(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name     string
+		degraded bool
+		status   int
+	}{
+		// 没有 redis 中间件时不能不限制
+		{"missing", false, http.StatusInternalServerError},
+		{"degraded", true, http.StatusOK},
+	}
+	for _, test := range tests {
+		engine := gin.New()
+		engine.Use(func(ctx *gin.Context) {
+			if test.degraded {
+				ctx.Set(redisMiddleware.CONTEXT_DEGRADED, true)
+			}
+			ctx.Next()
+			if len(ctx.Errors) != 0 {
+				ctx.Status(http.StatusInternalServerError)
+			}
+		})
+		engine.GET("/", Middleware(Config{Name: "test", IP: true}), func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, recorder.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package rbac
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/audit"
+	"github.com/otamoe/gin-server/errs"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+	mgoModel "github.com/otamoe/mgo-model"
+)
+
+type (
+	Config struct {
+		// 默认 audit.Actor  jwt sub  apikey owner  basicauth 用户
+		Subject func(ctx *gin.Context) string
+
+		// redis 缓存时间
+		CacheTTL time.Duration
+	}
+
+	// Role 权限支持 orders:*  和 *
+	Role struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    string     `json:"_id" bson:"_id"`
+		Description           string     `json:"description,omitempty" bson:"description,omitempty"`
+		Permissions           []string   `json:"permissions" bson:"permissions"`
+		UpdatedAt             *time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	}
+
+	Assignment struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    string     `json:"_id" bson:"_id"`
+		Roles                 []string   `json:"roles" bson:"roles"`
+		UpdatedAt             *time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	}
+)
+
+var (
+	CONTEXT        = "GIN.SERVER.RBAC"
+	CONTEXT_CONFIG = "GIN.SERVER.RBAC.CONFIG"
+	PREFIX         = "rbac"
+
+	RoleModel = &mgoModel.Model{
+		Name:     "rbac_roles",
+		Document: &Role{},
+	}
+	AssignmentModel = &mgoModel.Model{
+		Name:     "rbac_assignments",
+		Document: &Assignment{},
+		Indexs: []mgo.Index{
+			mgo.Index{
+				Key:        []string{"roles"},
+				Background: true,
+			},
+		},
+	}
+
+	ErrRequired = &errs.Error{
+		Message:    "Authentication is required",
+		Type:       "rbac",
+		StatusCode: http.StatusUnauthorized,
+	}
+	ErrForbidden = &errs.Error{
+		Message:    "Permission denied",
+		Type:       "rbac",
+		StatusCode: http.StatusForbidden,
+	}
+	ErrRole = &errs.Error{
+		Message:    "Role not found",
+		Type:       "rbac",
+		StatusCode: http.StatusNotFound,
+	}
+)
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.Subject == nil {
+		c.Subject = audit.Actor
+	}
+	if c.CacheTTL == 0 {
+		c.CacheTTL = time.Minute * 5
+	}
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT_CONFIG, c)
+		ctx.Next()
+	}
+}
+
+func config(ctx *gin.Context) Config {
+	if val, ok := ctx.Get(CONTEXT_CONFIG); ok && val != nil {
+		return val.(Config)
+	}
+	return Config{
+		Subject:  audit.Actor,
+		CacheTTL: time.Minute * 5,
+	}
+}
+
+// Require 需要全部权限  没有 subject 时 401
+func Require(permissions ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		subject := Subject(ctx)
+		if subject == "" {
+			ctx.Error(ErrRequired)
+			ctx.Abort()
+			return
+		}
+		granted, err := Permissions(ctx)
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		for _, permission := range permissions {
+			if !Match(granted, permission) {
+				err := ErrForbidden.Clone()
+				err.Params = map[string]interface{}{
+					"permission": permission,
+				}
+				ctx.Error(err)
+				ctx.Abort()
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
+
+// RequireAny 需要其中一个权限
+func RequireAny(permissions ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if Subject(ctx) == "" {
+			ctx.Error(ErrRequired)
+			ctx.Abort()
+			return
+		}
+		granted, err := Permissions(ctx)
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		for _, permission := range permissions {
+			if Match(granted, permission) {
+				ctx.Next()
+				return
+			}
+		}
+		ctx.Error(ErrForbidden)
+		ctx.Abort()
+	}
+}
+
+func Subject(ctx *gin.Context) string {
+	return config(ctx).Subject(ctx)
+}
+
+// Can 当前 subject 是否有权限
+func Can(ctx *gin.Context, permission string) bool {
+	if Subject(ctx) == "" {
+		return false
+	}
+	granted, err := Permissions(ctx)
+	if err != nil {
+		return false
+	}
+	return Match(granted, permission)
+}
+
+// Permissions 当前 subject 的全部权限  同一个请求只查询一次
+func Permissions(ctx *gin.Context) ([]string, error) {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.([]string), nil
+	}
+	subject := Subject(ctx)
+	if subject == "" {
+		return nil, nil
+	}
+	permissions, err := PermissionsOf(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Set(CONTEXT, permissions)
+	return permissions, nil
+}
+
+// PermissionsOf subject 的角色和角色的权限分别缓存
+func PermissionsOf(ctx *gin.Context, subject string) (permissions []string, err error) {
+	roles, err := RolesOf(ctx, subject)
+	if err != nil {
+		return
+	}
+	ttl := config(ctx).CacheTTL
+	exists := map[string]bool{}
+	for _, name := range roles {
+		var values []string
+		if err = cached(ctx, "role."+name, ttl, &values, func() (interface{}, error) {
+			role := &Role{}
+			if e := mongoMiddleware.C(ctx, RoleModel.Name).FindId(name).One(role); e != nil {
+				if e == mgo.ErrNotFound {
+					return []string{}, nil
+				}
+				return nil, e
+			}
+			return role.Permissions, nil
+		}); err != nil {
+			return
+		}
+		for _, value := range values {
+			if !exists[value] {
+				exists[value] = true
+				permissions = append(permissions, value)
+			}
+		}
+	}
+	return
+}
+
+func RolesOf(ctx *gin.Context, subject string) (roles []string, err error) {
+	err = cached(ctx, "subject."+subject, config(ctx).CacheTTL, &roles, func() (interface{}, error) {
+		assignment := &Assignment{}
+		if e := mongoMiddleware.C(ctx, AssignmentModel.Name).FindId(subject).One(assignment); e != nil {
+			if e == mgo.ErrNotFound {
+				return []string{}, nil
+			}
+			return nil, e
+		}
+		return assignment.Roles, nil
+	})
+	return
+}
+
+// SaveRole 创建或替换角色
+func SaveRole(ctx *gin.Context, role *Role) error {
+	now := time.Now()
+	role.UpdatedAt = &now
+	if _, err := mongoMiddleware.C(ctx, RoleModel.Name).UpsertId(role.ID, role); err != nil {
+		return err
+	}
+	invalidate(ctx, "role."+role.ID)
+	return nil
+}
+
+// DeleteRole 同时从全部 subject 中移除
+func DeleteRole(ctx *gin.Context, name string) error {
+	if err := mongoMiddleware.C(ctx, RoleModel.Name).RemoveId(name); err != nil {
+		if err == mgo.ErrNotFound {
+			return ErrRole
+		}
+		return err
+	}
+	var assignments []*Assignment
+	if err := mongoMiddleware.C(ctx, AssignmentModel.Name).Find(bson.M{"roles": name}).Select(bson.M{"_id": 1}).All(&assignments); err != nil {
+		return err
+	}
+	if _, err := mongoMiddleware.C(ctx, AssignmentModel.Name).UpdateAll(bson.M{"roles": name}, bson.M{"$pull": bson.M{"roles": name}}); err != nil {
+		return err
+	}
+	keys := []string{"role." + name}
+	for _, assignment := range assignments {
+		keys = append(keys, "subject."+assignment.ID)
+	}
+	invalidate(ctx, keys...)
+	return nil
+}
+
+func GetRole(ctx *gin.Context, name string) (*Role, error) {
+	role := &Role{}
+	if err := mongoMiddleware.C(ctx, RoleModel.Name).FindId(name).One(role); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrRole
+		}
+		return nil, err
+	}
+	return role, nil
+}
+
+func Roles(ctx *gin.Context) (roles []*Role, err error) {
+	err = mongoMiddleware.C(ctx, RoleModel.Name).Find(nil).Sort("_id").All(&roles)
+	return
+}
+
+// Assign 角色需要存在
+func Assign(ctx *gin.Context, subject string, roles ...string) error {
+	for _, name := range roles {
+		n, err := mongoMiddleware.C(ctx, RoleModel.Name).FindId(name).Count()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			err := ErrRole.Clone()
+			err.Params = map[string]interface{}{
+				"role": name,
+			}
+			return err
+		}
+	}
+	if _, err := mongoMiddleware.C(ctx, AssignmentModel.Name).UpsertId(subject, bson.M{
+		"$addToSet": bson.M{"roles": bson.M{"$each": roles}},
+		"$set":      bson.M{"updated_at": time.Now()},
+	}); err != nil {
+		return err
+	}
+	invalidate(ctx, "subject."+subject)
+	return nil
+}
+
+func Unassign(ctx *gin.Context, subject string, roles ...string) error {
+	if err := mongoMiddleware.C(ctx, AssignmentModel.Name).UpdateId(subject, bson.M{
+		"$pullAll": bson.M{"roles": roles},
+		"$set":     bson.M{"updated_at": time.Now()},
+	}); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	invalidate(ctx, "subject."+subject)
+	return nil
+}
+
+// Match 支持 orders:*  和 *
+func Match(granted []string, permission string) bool {
+	for _, value := range granted {
+		if value == "*" || value == permission {
+			return true
+		}
+		if strings.HasSuffix(value, ":*") && strings.HasPrefix(permission, strings.TrimSuffix(value, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func client(ctx *gin.Context) *redis.Client {
+	if val, ok := ctx.Get(redisMiddleware.CONTEXT); ok && val != nil {
+		return val.(*redis.Client)
+	}
+	return nil
+}
+
+// cached 没有 redis 中间件时直接查询
+func cached(ctx *gin.Context, key string, ttl time.Duration, value interface{}, load func() (interface{}, error)) error {
+	redisClient := client(ctx)
+	cacheKey := redisMiddleware.Key(ctx, PREFIX+"."+key)
+	if redisClient != nil {
+		if data, e := redisClient.Get(cacheKey).Bytes(); e == nil {
+			if e = json.Unmarshal(data, value); e == nil {
+				return nil
+			}
+		}
+	}
+	val, err := load()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	if redisClient != nil {
+		redisClient.Set(cacheKey, data, ttl)
+	}
+	return json.Unmarshal(data, value)
+}
+
+func invalidate(ctx *gin.Context, keys ...string) {
+	ctx.Set(CONTEXT, nil)
+	redisClient := client(ctx)
+	if redisClient == nil {
+		return
+	}
+	for i, key := range keys {
+		keys[i] = redisMiddleware.Key(ctx, PREFIX+"."+key)
+	}
+	redisClient.Del(keys...)
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/recorder"
+)
+
+type (
+	// Recorder 只在 development env 生效
+	Recorder struct {
+		Path    string `json:"path,omitempty"`
+		Size    int    `json:"size,omitempty"`
+		MaxBody int    `json:"max_body,omitempty"`
+
+		recorder *recorder.Recorder
+	}
+)
+
+func (config *Recorder) init(server *Server, handler *Handler) {
+	if config.Path == "" {
+		config.Path = "/debug/requests"
+	}
+	if config.Size == 0 {
+		config.Size = 100
+	}
+	if config.MaxBody == 0 {
+		config.MaxBody = 1024 * 64
+	}
+	if config.recorder == nil {
+		config.recorder = recorder.New(config.Size, config.MaxBody)
+	}
+}
+
+func (config *Recorder) Get() *recorder.Recorder {
+	return config.recorder
+}
+
+func (config *Recorder) skip(ctx *gin.Context) bool {
+	return ctx.Request.URL.Path == config.Path
+}
@@ -0,0 +1,303 @@
+package recorder
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/clientip"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	Config struct {
+		Recorder *Recorder
+
+		// 不记录的请求  例如 /debug/requests 自身
+		Skip func(ctx *gin.Context) bool
+	}
+
+	Entry struct {
+		ID                string        `json:"id" bson:"_id"`
+		Method            string        `json:"method" bson:"method"`
+		Host              string        `json:"host,omitempty" bson:"host,omitempty"`
+		Path              string        `json:"path" bson:"path"`
+		Query             string        `json:"query,omitempty" bson:"query,omitempty"`
+		Route             string        `json:"route,omitempty" bson:"route,omitempty"`
+		Name              string        `json:"name,omitempty" bson:"name,omitempty"`
+		IP                string        `json:"ip,omitempty" bson:"ip,omitempty"`
+		RequestHeader     http.Header   `json:"request_header,omitempty" bson:"request_header,omitempty"`
+		RequestBody       string        `json:"request_body,omitempty" bson:"request_body,omitempty"`
+		StatusCode        int           `json:"status_code" bson:"status_code"`
+		ResponseHeader    http.Header   `json:"response_header,omitempty" bson:"response_header,omitempty"`
+		ResponseBody      string        `json:"response_body,omitempty" bson:"response_body,omitempty"`
+		ErrorsText        string        `json:"errors_text,omitempty" bson:"errors_text,omitempty"`
+		Latency           time.Duration `json:"latency" bson:"latency"`
+		CreatedAt         time.Time     `json:"created_at" bson:"created_at"`
+		RequestTruncated  bool          `json:"request_truncated,omitempty" bson:"request_truncated,omitempty"`
+		ResponseTruncated bool          `json:"response_truncated,omitempty" bson:"response_truncated,omitempty"`
+	}
+
+	// Recorder 环形缓冲  保存最近 size 个请求
+	Recorder struct {
+		size    int
+		maxBody int
+		mutex   sync.RWMutex
+		entries []*Entry
+		next    int
+	}
+
+	Filter struct {
+		Route  string
+		Name   string
+		Method string
+		// 200 或 5xx
+		Status string
+		// 路径前缀
+		Path  string
+		Limit int
+	}
+
+	recorderWriter struct {
+		gin.ResponseWriter
+		body      bytes.Buffer
+		limit     int
+		truncated bool
+	}
+)
+
+var CONTEXT = "GIN.SERVER.RECORDER"
+
+// 隐藏值的请求头
+var SensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization", "X-Api-Key"}
+
+func New(size int, maxBody int) *Recorder {
+	if size <= 0 {
+		size = 100
+	}
+	if maxBody <= 0 {
+		maxBody = 1024 * 64
+	}
+	return &Recorder{
+		size:    size,
+		maxBody: maxBody,
+		entries: make([]*Entry, 0, size),
+	}
+}
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.Recorder == nil {
+		c.Recorder = New(0, 0)
+	}
+	recorder := c.Recorder
+	return func(ctx *gin.Context) {
+		if c.Skip != nil && c.Skip(ctx) {
+			ctx.Next()
+			return
+		}
+		recorder.Add(Record(ctx, recorder.maxBody))
+	}
+}
+
+// Record 执行 ctx.Next 并返回记录  敏感请求头已隐藏  body 超过 maxBody 截断
+func Record(ctx *gin.Context, maxBody int) *Entry {
+	req := ctx.Request
+	entry := &Entry{
+		ID:            bson.NewObjectId().Hex(),
+		Method:        req.Method,
+		Host:          req.Host,
+		Path:          req.URL.Path,
+		Query:         req.URL.RawQuery,
+		IP:            clientip.ClientIP(ctx),
+		RequestHeader: sanitize(req.Header),
+		CreatedAt:     time.Now(),
+	}
+
+	// 请求 body 截断 原 body 不变
+	if req.Body != nil {
+		body := req.Body
+		data, _ := ioutil.ReadAll(io.LimitReader(body, int64(maxBody+1)))
+		if len(data) > maxBody {
+			entry.RequestBody = string(data[:maxBody])
+			entry.RequestTruncated = true
+		} else {
+			entry.RequestBody = string(data)
+		}
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), body), body}
+	}
+
+	writer := &recorderWriter{
+		ResponseWriter: ctx.Writer,
+		limit:          maxBody,
+	}
+	ctx.Writer = writer
+	ctx.Set(CONTEXT, entry)
+
+	ctx.Next()
+
+	entry.Latency = time.Since(entry.CreatedAt)
+	entry.StatusCode = writer.Status()
+	entry.ResponseHeader = sanitize(writer.Header())
+	entry.ResponseBody = writer.body.String()
+	entry.ResponseTruncated = writer.truncated
+	entry.ErrorsText = strings.TrimSpace(ctx.Errors.ByType(gin.ErrorTypeAny).String())
+	if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+		resource := val.(*ginResource.Resource)
+		resource.Pre()
+		entry.Name = resource.Name()
+	}
+	entry.Route = ginResource.RoutePath(ctx)
+	return entry
+}
+
+// Handler 输出 json  参数 route name method status (200 或 5xx) path 前缀 id limit
+func Handler(recorder *Recorder) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if id := ctx.Query("id"); id != "" {
+			entry := recorder.Find(id)
+			if entry == nil {
+				ctx.AbortWithStatus(http.StatusNotFound)
+				return
+			}
+			ctx.JSON(http.StatusOK, entry)
+			return
+		}
+
+		limit, _ := strconv.Atoi(ctx.Query("limit"))
+		filter := Filter{
+			Route:  ctx.Query("route"),
+			Name:   ctx.Query("name"),
+			Method: strings.ToUpper(ctx.Query("method")),
+			Status: ctx.Query("status"),
+			Path:   ctx.Query("path"),
+			Limit:  limit,
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"requests": recorder.List(filter),
+		})
+	}
+}
+
+func Get(ctx *gin.Context) *Entry {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Entry)
+	}
+	return nil
+}
+
+func (recorder *Recorder) Add(entry *Entry) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if len(recorder.entries) < recorder.size {
+		recorder.entries = append(recorder.entries, entry)
+	} else {
+		recorder.entries[recorder.next] = entry
+	}
+	recorder.next = (recorder.next + 1) % recorder.size
+}
+
+// List 新的在前
+func (recorder *Recorder) List(filter Filter) (entries []*Entry) {
+	recorder.mutex.RLock()
+	defer recorder.mutex.RUnlock()
+	entries = []*Entry{}
+	length := len(recorder.entries)
+	for i := 1; i <= length; i++ {
+		entry := recorder.entries[(recorder.next-i+length)%length]
+		if !filter.match(entry) {
+			continue
+		}
+		entries = append(entries, entry)
+		if filter.Limit > 0 && len(entries) >= filter.Limit {
+			break
+		}
+	}
+	return
+}
+
+func (recorder *Recorder) Find(id string) *Entry {
+	recorder.mutex.RLock()
+	defer recorder.mutex.RUnlock()
+	for _, entry := range recorder.entries {
+		if entry.ID == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (recorder *Recorder) Reset() {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.entries = recorder.entries[:0]
+	recorder.next = 0
+}
+
+func (filter Filter) match(entry *Entry) bool {
+	if filter.Route != "" && filter.Route != entry.Route {
+		return false
+	}
+	if filter.Name != "" && filter.Name != entry.Name && !strings.HasPrefix(entry.Name, filter.Name+".") {
+		return false
+	}
+	if filter.Method != "" && filter.Method != entry.Method {
+		return false
+	}
+	if filter.Path != "" && !strings.HasPrefix(entry.Path, filter.Path) {
+		return false
+	}
+	if filter.Status != "" {
+		status := strconv.Itoa(entry.StatusCode)
+		if len(filter.Status) != len(status) {
+			return false
+		}
+		for i := 0; i < len(status); i++ {
+			if filter.Status[i] != 'x' && filter.Status[i] != 'X' && filter.Status[i] != status[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sanitize(header http.Header) http.Header {
+	values := http.Header{}
+	for key, val := range header {
+		values[key] = append([]string(nil), val...)
+	}
+	for _, key := range SensitiveHeaders {
+		if _, ok := values[key]; ok {
+			values[key] = []string{"******"}
+		}
+	}
+	return values
+}
+
+func (w *recorderWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recorderWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *recorderWriter) capture(data []byte) {
+	remaining := w.limit - w.body.Len()
+	if len(data) > remaining {
+		data = data[:remaining]
+		w.truncated = true
+	}
+	w.body.Write(data)
+}
@@ -3,9 +3,12 @@ package server
 import (
 	"log"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
+	ginRedis "github.com/otamoe/gin-server/redis"
 )
 
 type (
@@ -17,10 +20,34 @@ type (
 
 		DialTimeout   time.Duration `json:"dial_timeout,omitempty"`
 		SocketTimeout time.Duration `json:"socket_timeout,omitempty"`
+
+		// 大于 0 时启动时 ping  失败时重试的总时长
+		DialRetry time.Duration `json:"dial_retry,omitempty"`
+
+		// 不可用时继续处理请求  session 使用内存  rate 不限制
+		Degraded      bool          `json:"degraded,omitempty"`
+		CheckInterval time.Duration `json:"check_interval,omitempty"`
+
+		// key 前缀  auto 为 name:env:  为空不加
+		Prefix string `json:"prefix,omitempty"`
+
+		// test env 未设置 URLs 时 EmbeddedRedis 返回的关闭函数
+		embedded func()
+		status   *ginRedis.Status
+		client   *redis.Client
+		once     sync.Once
 	}
 )
 
 func (config *Redis) init(server *Server, handler *Handler) {
+	if len(config.URLs) == 0 && server != nil && server.ENV == "test" && EmbeddedRedis != nil {
+		addr, close, err := EmbeddedRedis()
+		if err != nil {
+			panic(err)
+		}
+		config.embedded = close
+		config.URLs = append(config.URLs, addr)
+	}
 	if len(config.URLs) == 0 {
 		config.URLs = append(config.URLs, "localhost:6379")
 	}
@@ -36,10 +63,30 @@ func (config *Redis) init(server *Server, handler *Handler) {
 	if config.SocketTimeout == 0 {
 		config.SocketTimeout = time.Second * 2
 	}
+	if config.Prefix == "auto" && server != nil {
+		config.Prefix = server.Name + ":" + server.ENV + ":"
+	}
 	if handler == nil && server != nil {
 		logWriter := server.Logger.Get().Writer()
 		redis.SetLogger(log.New(logWriter, "", 0))
 	}
+	if config.Degraded && config.status == nil {
+		config.status = &ginRedis.Status{
+			Interval: config.CheckInterval,
+			Logger:   server.dialLogger(),
+		}
+	}
+	if config.DialRetry > 0 && config.embedded == nil {
+		err := dialRetry(server.dialLogger(), "redis", config.DialRetry, func() error {
+			client := config.Get()
+			defer client.Close()
+			return client.Ping().Err()
+		})
+		// Degraded 时不可用也继续启动
+		if err != nil && !config.Degraded {
+			panic(err)
+		}
+	}
 }
 
 func (config *Redis) Get() (client *redis.Client) {
@@ -53,3 +100,38 @@ func (config *Redis) Get() (client *redis.Client) {
 	})
 	return
 }
+
+// Client 中间件共用的连接池  连接池统计使用
+func (config *Redis) Client() *redis.Client {
+	config.once.Do(func() {
+		config.client = config.Get()
+	})
+	return config.client
+}
+
+// KeyPrefix sessions rate jobs events 的 key 前缀
+func (config *Redis) KeyPrefix() string {
+	return config.Prefix
+}
+
+// Middleware Degraded 时 redis 不可用不返回错误
+func (config *Redis) Middleware() gin.HandlerFunc {
+	if config.status != nil {
+		return ginRedis.MiddlewareDegraded(config, config.status)
+	}
+	return ginRedis.MiddlewareProvider(config)
+}
+
+// Close 关闭内嵌的 redis
+func (config *Redis) Close() {
+	if config.status != nil {
+		config.status.Stop()
+	}
+	if config.client != nil {
+		config.client.Close()
+	}
+	if config.embedded != nil {
+		config.embedded()
+		config.embedded = nil
+	}
+}
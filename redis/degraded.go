@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// Status 后台 ping  不可用时 MiddlewareDegraded 不设置 client
+	Status struct {
+		// 默认 1s
+		Interval time.Duration
+		Logger   *logrus.Logger
+
+		down  int32
+		once  sync.Once
+		close sync.Once
+		stop  chan struct{}
+	}
+)
+
+var CONTEXT_DEGRADED = "GIN.SERVER.REDIS.DEGRADED"
+
+// MiddlewareDegraded redis 不可用时继续处理请求  使用方通过 Degraded 判断后降级
+func MiddlewareDegraded(provider Provider, status *Status) gin.HandlerFunc {
+	status.Start(provider)
+	next := MiddlewareProvider(provider)
+	return func(ctx *gin.Context) {
+		if status.Down() {
+			ctx.Set(CONTEXT_DEGRADED, true)
+			ctx.Next()
+			return
+		}
+		next(ctx)
+	}
+}
+
+// Degraded MiddlewareDegraded 中 redis 不可用  没有 redis 中间件时为 false  使用 Available 判断
+func Degraded(ctx *gin.Context) bool {
+	return ctx.GetBool(CONTEXT_DEGRADED)
+}
+
+// Available context 中有 redis client  Degraded 时为 false
+func Available(ctx *gin.Context) bool {
+	val, ok := ctx.Get(CONTEXT)
+	return ok && val != nil
+}
+
+func (status *Status) Down() bool {
+	return atomic.LoadInt32(&status.down) == 1
+}
+
+// Start 多次调用只启动一次
+func (status *Status) Start(provider Provider) {
+	status.once.Do(func() {
+		if status.Interval == 0 {
+			status.Interval = time.Second
+		}
+		if status.Logger == nil {
+			status.Logger = logrus.StandardLogger()
+		}
+		status.stop = make(chan struct{})
+		go status.run(provider.Get())
+	})
+}
+
+func (status *Status) Stop() {
+	status.close.Do(func() {
+		if status.stop != nil {
+			close(status.stop)
+		}
+	})
+}
+
+func (status *Status) run(client *redis.Client) {
+	defer client.Close()
+	ticker := time.NewTicker(status.Interval)
+	defer ticker.Stop()
+	for {
+		status.check(client)
+		select {
+		case <-status.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (status *Status) check(client *redis.Client) {
+	if err := client.Ping().Err(); err != nil {
+		if atomic.SwapInt32(&status.down, 1) == 0 {
+			status.Logger.Error("[REDIS] unavailable, degraded: ", err)
+		}
+		return
+	}
+	if atomic.SwapInt32(&status.down, 0) == 1 {
+		status.Logger.Info("[REDIS] available")
+	}
+}
@@ -0,0 +1,30 @@
+package redis
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+func TestDegraded(t *testing.T) {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if Degraded(ctx) {
+		t.Error("Degraded without redis middleware")
+	}
+	if Available(ctx) {
+		t.Error("Available without redis middleware")
+	}
+
+	ctx.Set(CONTEXT_DEGRADED, true)
+	if !Degraded(ctx) || Available(ctx) {
+		t.Error("degraded context")
+	}
+
+	ctx, _ = gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Set(CONTEXT, redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}))
+	if Degraded(ctx) || !Available(ctx) {
+		t.Error("context with client")
+	}
+}
@@ -1,22 +1,147 @@
 package redis
 
 import (
+	"sync"
+	"time"
+
 	"github.com/go-redis/redis"
 
 	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/timeout"
 )
 
 type (
-	GetSession func() *redis.Client
+	// Provider Get 返回的 client 在请求结束时 Close
+	Provider interface {
+		Get() *redis.Client
+	}
+
+	// Pool 共用一个连接池  Client 返回的不需要 Close
+	Pool interface {
+		Client() *redis.Client
+	}
+
+	// Prefixer 多个服务共用一个 redis 时的 key 前缀  例如 myapp:production:
+	Prefixer interface {
+		KeyPrefix() string
+	}
+
+	// ProviderFunc 函数作为 Provider
+	ProviderFunc func() *redis.Client
+
+	// GetSession 之前的名称
+	GetSession = ProviderFunc
+
+	// Client key 通过 Key 加前缀  需要原始 key 时直接使用 Client
+	Client struct {
+		*redis.Client
+		prefix string
+	}
 )
 
 var CONTEXT = "GIN.SERVER.REDIS"
 
+var CONTEXT_PREFIX = "GIN.SERVER.REDIS.PREFIX"
+
+func (fn ProviderFunc) Get() *redis.Client {
+	return fn()
+}
+
+// Middleware 每个请求调用 getSession  Provider 使用 MiddlewareProvider
 func Middleware(getSession GetSession) gin.HandlerFunc {
+	return MiddlewareProvider(getSession)
+}
+
+func MiddlewareProvider(provider Provider) gin.HandlerFunc {
+	var prefix string
+	if val, ok := provider.(Prefixer); ok {
+		prefix = val.KeyPrefix()
+	}
+	pool, _ := provider.(Pool)
+	// 共用连接池时  每个路由超时一个 client
+	limited := &sync.Map{}
 	return func(ctx *gin.Context) {
-		session := getSession()
-		defer session.Close()
-		ctx.Set(CONTEXT, session)
+		var session *redis.Client
+		d := routeTimeout(ctx)
+		if pool != nil {
+			session = pool.Client()
+			if d > 0 && exceeds(session.Options(), d) {
+				key := limitedKey{session, d}
+				val, ok := limited.Load(key)
+				if !ok {
+					val, _ = limited.LoadOrStore(key, redis.NewClient(withTimeout(session.Options(), d)))
+				}
+				session = val.(*redis.Client)
+			}
+		} else {
+			session = provider.Get()
+			defer session.Close()
+			if d > 0 && exceeds(session.Options(), d) {
+				session = redis.NewClient(withTimeout(session.Options(), d))
+				defer session.Close()
+			}
+		}
+
+		// go-redis v6 不使用 ctx 的 deadline  每个命令的读写超时不超过路由的超时
+		client := session.WithContext(ctx.Request.Context())
+		ctx.Set(CONTEXT, client)
+		ctx.Set(CONTEXT_PREFIX, prefix)
 		ctx.Next()
 	}
 }
+
+type limitedKey struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+// routeTimeout timeout 中间件设置的路由超时  没有时为 0
+func routeTimeout(ctx *gin.Context) time.Duration {
+	if val, ok := ctx.Get(timeout.CONTEXT); ok && val != nil {
+		return val.(time.Duration)
+	}
+	return 0
+}
+
+// exceeds 读写或者连接超时大于 d  -1 为不超时
+func exceeds(opt *redis.Options, d time.Duration) bool {
+	return opt.ReadTimeout <= 0 || opt.ReadTimeout > d || opt.WriteTimeout <= 0 || opt.WriteTimeout > d || opt.DialTimeout > d
+}
+
+func withTimeout(opt *redis.Options, d time.Duration) *redis.Options {
+	limited := *opt
+	if limited.ReadTimeout <= 0 || limited.ReadTimeout > d {
+		limited.ReadTimeout = d
+	}
+	if limited.WriteTimeout <= 0 || limited.WriteTimeout > d {
+		limited.WriteTimeout = d
+	}
+	if limited.DialTimeout > d {
+		limited.DialTimeout = d
+	}
+	return &limited
+}
+
+// Get 带前缀的 client
+func Get(ctx *gin.Context) *Client {
+	return &Client{
+		Client: ctx.MustGet(CONTEXT).(*redis.Client),
+		prefix: Prefix(ctx),
+	}
+}
+
+func Prefix(ctx *gin.Context) string {
+	if val, ok := ctx.Get(CONTEXT_PREFIX); ok && val != nil {
+		return val.(string)
+	}
+	return ""
+}
+
+// Key 加上 Provider 的前缀
+func Key(ctx *gin.Context, key string) string {
+	return Prefix(ctx) + key
+}
+
+func (client *Client) Key(key string) string {
+	return client.prefix + key
+}
@@ -0,0 +1,309 @@
+package refreshtoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/jwt"
+	"github.com/otamoe/gin-server/sessions"
+)
+
+type (
+	Config struct {
+		// 默认 RedisStore
+		Store Store
+
+		// 每次轮换后的有效期
+		TTL time.Duration
+
+		// 一个 family 最长的有效期  超过后需要重新登录  0 不限制
+		MaxLifetime time.Duration
+
+		// 检测到重复使用  整个 family 已撤销
+		OnReuse func(ctx *gin.Context, token *Token)
+	}
+
+	// Token 保存的是 hash  明文只在签发时返回
+	Token struct {
+		ID        string     `json:"_id" bson:"_id"`
+		Family    string     `json:"family" bson:"family"`
+		Parent    string     `json:"parent,omitempty" bson:"parent,omitempty"`
+		Subject   string     `json:"sub" bson:"sub"`
+		ClientID  string     `json:"client_id,omitempty" bson:"client_id,omitempty"`
+		Scope     string     `json:"scope,omitempty" bson:"scope,omitempty"`
+		Session   string     `json:"session,omitempty" bson:"session,omitempty"`
+		Device    Device     `json:"device" bson:"device"`
+		CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+		StartedAt time.Time  `json:"started_at" bson:"started_at"`
+		ExpiresAt time.Time  `json:"expires_at" bson:"expires_at"`
+		UsedAt    *time.Time `json:"used_at,omitempty" bson:"used_at,omitempty"`
+		RevokedAt *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	}
+
+	Device struct {
+		Name      string `json:"name,omitempty" bson:"name,omitempty"`
+		UserAgent string `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+		IP        string `json:"ip,omitempty" bson:"ip,omitempty"`
+	}
+
+	Store interface {
+		Save(ctx *gin.Context, token *Token) error
+
+		// 不存在时 nil nil
+		Find(ctx *gin.Context, id string) (*Token, error)
+
+		// 原子的标记为已使用  已经使用过时返回 false
+		Use(ctx *gin.Context, id string, at time.Time) (bool, error)
+
+		RevokeFamily(ctx *gin.Context, family string, at time.Time) error
+		FamilyRevoked(ctx *gin.Context, family string) (bool, error)
+
+		// 每个有效 family 最新的 token
+		Families(ctx *gin.Context, subject string) ([]*Token, error)
+	}
+
+	Manager struct {
+		config Config
+	}
+)
+
+var CONTEXT = "GIN.SERVER.REFRESHTOKEN"
+
+var (
+	ErrInvalid = &errs.Error{
+		Message:    "Invalid refresh token",
+		Type:       "refresh_token",
+		StatusCode: http.StatusUnauthorized,
+	}
+	ErrReused = &errs.Error{
+		Message:    "Refresh token has already been used",
+		Type:       "refresh_token",
+		StatusCode: http.StatusUnauthorized,
+	}
+	ErrRevoked = &errs.Error{
+		Message:    "Session has been revoked",
+		Type:       "token",
+		StatusCode: http.StatusUnauthorized,
+	}
+)
+
+func New(c Config) *Manager {
+	if c.Store == nil {
+		c.Store = &RedisStore{}
+	}
+	if c.TTL == 0 {
+		c.TTL = time.Hour * 24 * 30
+	}
+	return &Manager{
+		config: c,
+	}
+}
+
+// Middleware 在 jwt 中间件之后  access token 的 sid 为 family  family 撤销后拒绝
+func Middleware(manager *Manager) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, manager)
+		if claims := jwt.Get(ctx); claims != nil {
+			if family := claims.String("sid"); family != "" {
+				revoked, err := manager.config.Store.FamilyRevoked(ctx, family)
+				if err != nil {
+					ctx.Error(err)
+					ctx.Abort()
+					return
+				}
+				if revoked {
+					ctx.Error(ErrRevoked)
+					ctx.Abort()
+					return
+				}
+			}
+		}
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Manager {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Manager)
+	}
+	return nil
+}
+
+// Issue 登录时签发  新的 family  有 session 时记录 session id
+func (manager *Manager) Issue(ctx *gin.Context, subject string, clientID string, scope string, device string) (value string, token *Token, err error) {
+	now := time.Now()
+	token = &Token{
+		Family:    random(16),
+		Subject:   subject,
+		ClientID:  clientID,
+		Scope:     scope,
+		StartedAt: now,
+		Device: Device{
+			Name:      device,
+			UserAgent: ctx.Request.UserAgent(),
+			IP:        clientip.ClientIP(ctx),
+		},
+	}
+	if session := sessions.Get(ctx); session != nil {
+		token.Session = session.ID
+	}
+	value, err = manager.save(ctx, token, now)
+	return
+}
+
+// Rotate 一次性使用  返回新的 token  重复使用时撤销整个 family
+func (manager *Manager) Rotate(ctx *gin.Context, value string) (string, *Token, error) {
+	store := manager.config.Store
+	old, err := store.Find(ctx, Hash(value))
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	if old == nil || old.RevokedAt != nil || now.After(old.ExpiresAt) {
+		return "", nil, ErrInvalid
+	}
+	if revoked, err := store.FamilyRevoked(ctx, old.Family); err != nil {
+		return "", nil, err
+	} else if revoked {
+		return "", nil, ErrInvalid
+	}
+
+	ok, err := store.Use(ctx, old.ID, now)
+	if err != nil {
+		return "", nil, err
+	}
+	if !ok {
+		// 被盗用的 token 和合法的都失效
+		if err = store.RevokeFamily(ctx, old.Family, now); err != nil {
+			return "", nil, err
+		}
+		if manager.config.OnReuse != nil {
+			manager.config.OnReuse(ctx, old)
+		}
+		return "", nil, ErrReused
+	}
+
+	token := &Token{
+		Family:    old.Family,
+		Parent:    old.ID,
+		Subject:   old.Subject,
+		ClientID:  old.ClientID,
+		Scope:     old.Scope,
+		Session:   old.Session,
+		StartedAt: old.StartedAt,
+		Device:    old.Device,
+	}
+	token.Device.UserAgent = ctx.Request.UserAgent()
+	token.Device.IP = clientip.ClientIP(ctx)
+	next, err := manager.save(ctx, token, now)
+	if err != nil {
+		return "", nil, err
+	}
+	return next, token, nil
+}
+
+// Find 不存在 已使用 已撤销 过期时为 nil
+func (manager *Manager) Find(ctx *gin.Context, value string) (*Token, error) {
+	token, err := manager.config.Store.Find(ctx, Hash(value))
+	if err != nil || token == nil {
+		return nil, err
+	}
+	if token.UsedAt != nil || token.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, nil
+	}
+	if revoked, err := manager.config.Store.FamilyRevoked(ctx, token.Family); err != nil || revoked {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Revoke 撤销 token 所在的 family  例如退出登录
+func (manager *Manager) Revoke(ctx *gin.Context, value string) error {
+	token, err := manager.config.Store.Find(ctx, Hash(value))
+	if err != nil || token == nil {
+		return err
+	}
+	return manager.config.Store.RevokeFamily(ctx, token.Family, time.Now())
+}
+
+func (manager *Manager) RevokeFamily(ctx *gin.Context, family string) error {
+	return manager.config.Store.RevokeFamily(ctx, family, time.Now())
+}
+
+func (manager *Manager) FamilyRevoked(ctx *gin.Context, family string) (bool, error) {
+	return manager.config.Store.FamilyRevoked(ctx, family)
+}
+
+// RevokeSubject 撤销全部设备  例如修改密码
+func (manager *Manager) RevokeSubject(ctx *gin.Context, subject string) error {
+	tokens, err := manager.config.Store.Families(ctx, subject)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, token := range tokens {
+		if err = manager.config.Store.RevokeFamily(ctx, token.Family, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeSession 撤销 session 签发的
+func (manager *Manager) RevokeSession(ctx *gin.Context, subject string, session string) error {
+	tokens, err := manager.config.Store.Families(ctx, subject)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, token := range tokens {
+		if token.Session != session {
+			continue
+		}
+		if err = manager.config.Store.RevokeFamily(ctx, token.Family, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Devices 每个登录的设备一个
+func (manager *Manager) Devices(ctx *gin.Context, subject string) ([]*Token, error) {
+	return manager.config.Store.Families(ctx, subject)
+}
+
+func (manager *Manager) save(ctx *gin.Context, token *Token, now time.Time) (string, error) {
+	value := random(32)
+	token.ID = Hash(value)
+	token.CreatedAt = now
+	token.ExpiresAt = now.Add(manager.config.TTL)
+	if manager.config.MaxLifetime > 0 {
+		if max := token.StartedAt.Add(manager.config.MaxLifetime); max.Before(token.ExpiresAt) {
+			token.ExpiresAt = max
+		}
+		if !now.Before(token.ExpiresAt) {
+			return "", ErrInvalid
+		}
+	}
+	if err := manager.config.Store.Save(ctx, token); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func random(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,250 @@
+package refreshtoken
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+	mgoModel "github.com/otamoe/mgo-model"
+)
+
+type (
+	// RedisStore 需要 redis 中间件
+	RedisStore struct {
+		// 默认 refreshtoken
+		Prefix string
+	}
+
+	// MongoStore 需要 mongo 中间件  过期的由 ttl 索引删除
+	MongoStore struct {
+	}
+
+	document struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		Token                 `bson:",inline"`
+	}
+)
+
+var Model = &mgoModel.Model{
+	Name:     "refresh_tokens",
+	Document: &document{},
+	Indexs: []mgo.Index{
+		mgo.Index{
+			Key:        []string{"family"},
+			Background: true,
+		},
+		mgo.Index{
+			Key:        []string{"sub", "-created_at"},
+			Background: true,
+		},
+		mgo.Index{
+			Key:         []string{"expires_at"},
+			ExpireAfter: time.Second,
+			Background:  true,
+		},
+	},
+}
+
+var ErrNoRedis = errors.New("Refresh token: redis middleware is not used")
+
+// family 已撤销的标记  最长保留
+var revokedTTL = time.Hour * 24 * 90
+
+func (store *RedisStore) key(ctx *gin.Context, key string) string {
+	prefix := store.Prefix
+	if prefix == "" {
+		prefix = "refreshtoken"
+	}
+	return redisMiddleware.Key(ctx, prefix+"."+key)
+}
+
+func (store *RedisStore) client(ctx *gin.Context) (*redis.Client, error) {
+	if val, ok := ctx.Get(redisMiddleware.CONTEXT); ok && val != nil {
+		return val.(*redis.Client), nil
+	}
+	return nil, ErrNoRedis
+}
+
+func (store *RedisStore) Save(ctx *gin.Context, token *Token) error {
+	client, err := store.client(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(token.ExpiresAt)
+	// 已使用的保留到过期  用于检测重复使用
+	_, err = client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(store.key(ctx, "token."+token.ID), data, ttl)
+		pipe.Set(store.key(ctx, "family."+token.Family), token.ID, ttl)
+		pipe.SAdd(store.key(ctx, "subject."+token.Subject), token.Family)
+		pipe.Expire(store.key(ctx, "subject."+token.Subject), ttl)
+		return nil
+	})
+	return err
+}
+
+func (store *RedisStore) Find(ctx *gin.Context, id string) (*Token, error) {
+	client, err := store.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := client.Get(store.key(ctx, "token."+id)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	token := &Token{}
+	if err = json.Unmarshal(data, token); err != nil {
+		return nil, nil
+	}
+	if used, _ := client.Get(store.key(ctx, "used."+id)).Int64(); used != 0 {
+		at := time.Unix(used, 0)
+		token.UsedAt = &at
+	}
+	return token, nil
+}
+
+func (store *RedisStore) Use(ctx *gin.Context, id string, at time.Time) (bool, error) {
+	client, err := store.client(ctx)
+	if err != nil {
+		return false, err
+	}
+	ttl, err := client.PTTL(store.key(ctx, "token."+id)).Result()
+	if err != nil {
+		return false, err
+	}
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	return client.SetNX(store.key(ctx, "used."+id), at.Unix(), ttl).Result()
+}
+
+func (store *RedisStore) RevokeFamily(ctx *gin.Context, family string, at time.Time) error {
+	client, err := store.client(ctx)
+	if err != nil {
+		return err
+	}
+	return client.Set(store.key(ctx, "revoked."+family), at.Unix(), revokedTTL).Err()
+}
+
+func (store *RedisStore) FamilyRevoked(ctx *gin.Context, family string) (bool, error) {
+	client, err := store.client(ctx)
+	if err != nil {
+		return false, err
+	}
+	n, err := client.Exists(store.key(ctx, "revoked."+family)).Result()
+	return n != 0, err
+}
+
+// Families 清理过期和撤销的 family
+func (store *RedisStore) Families(ctx *gin.Context, subject string) (tokens []*Token, err error) {
+	client, err := store.client(ctx)
+	if err != nil {
+		return
+	}
+	subjectKey := store.key(ctx, "subject."+subject)
+	families, err := client.SMembers(subjectKey).Result()
+	if err != nil {
+		return
+	}
+	for _, family := range families {
+		var id string
+		if id, err = client.Get(store.key(ctx, "family."+family)).Result(); err == redis.Nil {
+			err = nil
+			client.SRem(subjectKey, family)
+			continue
+		} else if err != nil {
+			return
+		}
+		var revoked bool
+		if revoked, err = store.FamilyRevoked(ctx, family); err != nil {
+			return
+		}
+		if revoked {
+			client.SRem(subjectKey, family)
+			continue
+		}
+		var token *Token
+		if token, err = store.Find(ctx, id); err != nil {
+			return
+		}
+		if token != nil {
+			tokens = append(tokens, token)
+		}
+	}
+	return
+}
+
+func (store *MongoStore) Save(ctx *gin.Context, token *Token) error {
+	return mongoMiddleware.C(ctx, Model.Name).Insert(&document{Token: *token})
+}
+
+func (store *MongoStore) Find(ctx *gin.Context, id string) (*Token, error) {
+	doc := &document{}
+	if err := mongoMiddleware.C(ctx, Model.Name).FindId(id).One(doc); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &doc.Token, nil
+}
+
+func (store *MongoStore) Use(ctx *gin.Context, id string, at time.Time) (bool, error) {
+	err := mongoMiddleware.C(ctx, Model.Name).Update(bson.M{
+		"_id":     id,
+		"used_at": nil,
+	}, bson.M{
+		"$set": bson.M{"used_at": at},
+	})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (store *MongoStore) RevokeFamily(ctx *gin.Context, family string, at time.Time) error {
+	_, err := mongoMiddleware.C(ctx, Model.Name).UpdateAll(bson.M{
+		"family":     family,
+		"revoked_at": nil,
+	}, bson.M{
+		"$set": bson.M{"revoked_at": at},
+	})
+	return err
+}
+
+func (store *MongoStore) FamilyRevoked(ctx *gin.Context, family string) (bool, error) {
+	n, err := mongoMiddleware.C(ctx, Model.Name).Find(bson.M{
+		"family":     family,
+		"revoked_at": bson.M{"$ne": nil},
+	}).Limit(1).Count()
+	return n != 0, err
+}
+
+func (store *MongoStore) Families(ctx *gin.Context, subject string) (tokens []*Token, err error) {
+	var docs []*document
+	if err = mongoMiddleware.C(ctx, Model.Name).Find(bson.M{
+		"sub":        subject,
+		"used_at":    nil,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Sort("-created_at").All(&docs); err != nil {
+		return
+	}
+	for _, doc := range docs {
+		token := doc.Token
+		tokens = append(tokens, &token)
+	}
+	return
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/featureflags"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// reloadable 可以热更新的部分  其他字段变化时拒绝
+	reloadable struct {
+		Logger     *Logger           `json:"logger,omitempty"`
+		Compress   *Compress         `json:"compress,omitempty"`
+		Redirects  map[string]string `json:"redirects,omitempty"`
+		RateLimits map[string]int64  `json:"rate_limits,omitempty"`
+		Features   map[string]string `json:"features,omitempty"`
+	}
+)
+
+// ReloadConfig 校验后替换 日志级别 压缩类型 重定向 限速 feature flags
+// 和上一次的配置比较  其他配置变化时拒绝  第一次调用没有可比较的配置  按 ENV 合并 profile 替换 secrets
+func (server *Server) ReloadConfig(data []byte) (err error) {
+	if data, err = server.resolveConfig(data, server.ENV); err != nil {
+		return err
+	}
+	next := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &next); err != nil {
+		return err
+	}
+	server.mutex.Lock()
+	current := server.configData
+	server.mutex.Unlock()
+
+	// 不能热更新的字段
+	for _, name := range []string{"env", "name", "addr", "listeners", "trusted_proxies", "read_timeout", "read_header_timeout", "write_timeout", "idle_timeout", "signals", "redis", "mongo", "modules", "handlers"} {
+		if current == nil {
+			break
+		}
+		var a, b interface{}
+		json.Unmarshal(next[name], &a)
+		json.Unmarshal(current[name], &b)
+		if !reflect.DeepEqual(a, b) {
+			return errors.New("Server: " + name + " can not be reloaded, restart required")
+		}
+	}
+
+	config := &reloadable{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return err
+	}
+
+	// 校验
+	var level logrus.Level
+	if config.Logger != nil && config.Logger.Level != "" {
+		var err error
+		if level, err = logrus.ParseLevel(config.Logger.Level); err != nil {
+			return err
+		}
+	}
+	if len(config.Features) != 0 {
+		if server.Flags == nil {
+			return errors.New("Server: feature flags is empty")
+		}
+		for name, value := range config.Features {
+			if err := featureflags.Validate(name, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 替换
+	if config.Logger != nil && config.Logger.Level != "" {
+		server.Logger.Level = config.Logger.Level
+		server.Logger.Get().SetLevel(level)
+		for _, val := range server.Handlers {
+			if val.Logger != nil && val.Logger != server.Logger && val.Logger.Level == "" {
+				val.Logger.Get().SetLevel(level)
+			}
+		}
+	}
+	if config.Compress != nil && config.Compress.Types != nil {
+		server.Compress.setTypes(config.Compress.Types)
+	}
+	if _, ok := next["redirects"]; ok {
+		server.getServerHandler().setRedirects(config.Redirects)
+	}
+	if _, ok := next["rate_limits"]; ok {
+		server.rateLimits.Store(config.RateLimits)
+	}
+	for name, value := range config.Features {
+		server.Flags.Set(name, value)
+	}
+
+	server.mutex.Lock()
+	server.configData = next
+	server.mutex.Unlock()
+	return nil
+}
+
+// WatchConfig 文件修改 或 SIGHUP 时 ReloadConfig  interval 为 0 只响应 SIGHUP
+func (server *Server) WatchConfig(file string, interval time.Duration) {
+	var modTime time.Time
+	if info, err := os.Stat(file); err == nil {
+		modTime = info.ModTime()
+	}
+	if data, err := ioutil.ReadFile(file); err == nil {
+		next := map[string]json.RawMessage{}
+		if data, err = server.resolveConfig(data, server.ENV); err == nil && json.Unmarshal(data, &next) == nil {
+			server.mutex.Lock()
+			server.configData = next
+			server.mutex.Unlock()
+		}
+	}
+	reload := func() error {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		return server.ReloadConfig(data)
+	}
+	server.OnReload(reload)
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			info, err := os.Stat(file)
+			if err != nil || !info.ModTime().After(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			if err := reload(); err != nil {
+				logrus.Error("Config reload:", err)
+			} else {
+				logrus.Println("Config reloaded ", file)
+			}
+		}
+	}()
+}
+
+// RateLimit 用于 rate.Config.Limit  值来自 rate_limits[name]  可热更新
+func (server *Server) RateLimit(name string, value int64) func(ctx *gin.Context) int64 {
+	return func(ctx *gin.Context) int64 {
+		if limits, ok := server.rateLimits.Load().(map[string]int64); ok {
+			if limit, ok := limits[name]; ok {
+				return limit
+			}
+		}
+		return value
+	}
+}
@@ -1,8 +1,12 @@
 package resource
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"reflect"
+	"strings"
 	"sync"
 
 	"github.com/gin-gonic/gin"
@@ -15,6 +19,12 @@ type (
 		ValueKeys []string
 		OwnerKeys []string
 
+		// 未设置 Type Action 时 从路由自动生成
+		Auto bool
+
+		// 用于 URLFor  一般是 engine.Routes
+		Routes func() gin.RoutesInfo
+
 		Parent      *Config
 		Application bson.ObjectId
 		Type        string
@@ -40,6 +50,12 @@ type (
 
 var CONTEXT = "GIN.SERVER.RESOURCE"
 
+var CONTEXT_ROUTES = "GIN.SERVER.RESOURCE.ROUTES"
+
+var CONTEXT_ROUTE_PATH = "GIN.SERVER.RESOURCE.ROUTE_PATH"
+
+var contextRoutePaths = "GIN.SERVER.RESOURCE.ROUTE_PATHS"
+
 var handlersMap = sync.Map{}
 
 func Handler(handler gin.HandlerFunc, config Config) {
@@ -56,6 +72,8 @@ func Reflect(handler gin.HandlerFunc) reflect.Value {
 }
 
 func Middleware(config Config) gin.HandlerFunc {
+	// method + handler => 注册的路径  每个 engine 一个
+	routePaths := &sync.Map{}
 	return func(ctx *gin.Context) {
 		var resource *Resource
 		if val, ok := ctx.Get(CONTEXT); ok {
@@ -69,11 +87,242 @@ func Middleware(config Config) gin.HandlerFunc {
 		if val, ok := handlersMap.Load(reflect.ValueOf(ctx.Handler())); ok && val != nil {
 			val.(Config).setResource(ctx, resource)
 		}
+		if config.Routes != nil {
+			ctx.Set(CONTEXT_ROUTES, config.Routes)
+			ctx.Set(contextRoutePaths, routePaths)
+		}
 		config.setResource(ctx, resource)
+		if config.Auto && (resource.Type == "" || resource.Action == "") {
+			// 在 Pre 之外立即生成  不触发 ValueKeys OwnerKeys
+			typ, action := Route(ctx.Request.Method, RoutePath(ctx))
+			if resource.Type == "" {
+				resource.Type = typ
+			}
+			if resource.Action == "" {
+				resource.Action = action
+			}
+		}
 		ctx.Next()
 	}
 }
 
+// RoutePath 匹配的路由 例如 /users/123 => /users/:id  使用 handler 注册的路径  没有 Routes 时从参数还原
+func RoutePath(ctx *gin.Context) string {
+	if val, ok := ctx.Get(CONTEXT_ROUTE_PATH); ok {
+		return val.(string)
+	}
+	routePath, ok := registeredPath(ctx)
+	if !ok {
+		routePath = paramsPath(ctx.Request.URL.Path, ctx.Params)
+	}
+	ctx.Set(CONTEXT_ROUTE_PATH, routePath)
+	return routePath
+}
+
+type routeKey struct {
+	method  string
+	handler uintptr
+}
+
+func registeredPath(ctx *gin.Context) (string, bool) {
+	val, ok := ctx.Get(CONTEXT_ROUTES)
+	if !ok || val == nil || ctx.Handler() == nil {
+		return "", false
+	}
+	routePaths := &sync.Map{}
+	if val, ok := ctx.Get(contextRoutePaths); ok && val != nil {
+		routePaths = val.(*sync.Map)
+	}
+	key := routeKey{ctx.Request.Method, reflect.ValueOf(ctx.Handler()).Pointer()}
+	paths, ok := routePaths.Load(key)
+	if !ok {
+		var list []string
+		for _, route := range val.(func() gin.RoutesInfo)() {
+			if route.Method == key.method && route.HandlerFunc != nil && reflect.ValueOf(route.HandlerFunc).Pointer() == key.handler {
+				list = append(list, route.Path)
+			}
+		}
+		paths, _ = routePaths.LoadOrStore(key, list)
+	}
+	list := paths.([]string)
+	if len(list) == 0 {
+		return "", false
+	}
+	if len(list) == 1 {
+		return list[0], true
+	}
+	// 同一个 handler 注册了多个路径  参数填充之后和请求路径比较
+	for _, routePath := range list {
+		if expandPath(routePath, ctx.Params) == ctx.Request.URL.Path {
+			return routePath, true
+		}
+	}
+	return "", false
+}
+
+func expandPath(routePath string, params gin.Params) string {
+	segments := strings.Split(routePath, "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		switch segment[0] {
+		case ':':
+			segments[i] = params.ByName(segment[1:])
+		case '*':
+			segments[i] = strings.TrimPrefix(params.ByName(segment[1:]), "/")
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// paramsPath 根据参数值还原路由  参数值和固定的路径片段相同时 例如 /users/users 可能还原错误
+func paramsPath(urlPath string, params gin.Params) string {
+	if len(params) == 0 {
+		return urlPath
+	}
+	segments := strings.Split(urlPath, "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		for j, param := range params {
+			if strings.HasPrefix(param.Value, "/") {
+				// *catchAll
+				if "/"+strings.Join(segments[i:], "/") == param.Value {
+					segments = append(segments[:i], "*"+param.Key)
+					return strings.Join(segments, "/")
+				}
+				continue
+			}
+			if param.Value == segment {
+				segments[i] = ":" + param.Key
+				params = append(params[:j:j], params[j+1:]...)
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Route 根据路由生成 type action 例如 GET /users/:id => users show
+func Route(method string, routePath string) (typ string, action string) {
+	var param bool
+	for _, segment := range strings.Split(routePath, "/") {
+		if segment == "" {
+			continue
+		}
+		if segment[0] == ':' || segment[0] == '*' {
+			param = true
+			continue
+		}
+		typ = segment
+		param = false
+	}
+	typ = strings.Replace(strings.ToLower(typ), "-", "_", -1)
+	if typ == "" {
+		typ = "index"
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		if param {
+			action = "show"
+		} else {
+			action = "list"
+		}
+	case http.MethodPost:
+		action = "create"
+	case http.MethodPut, http.MethodPatch:
+		action = "update"
+	case http.MethodDelete:
+		action = "delete"
+	default:
+		action = strings.ToLower(method)
+	}
+	return
+}
+
+// URL 反向路由 根据 type.action 生成路径  params 按顺序填充 :param *param
+func URL(routes gin.RoutesInfo, name string, params ...interface{}) (string, error) {
+	var routePath string
+	for _, route := range routes {
+		if RouteName(route) != name {
+			continue
+		}
+		if routePath == "" || route.Method == http.MethodGet {
+			routePath = route.Path
+		}
+		if route.Method == http.MethodGet {
+			break
+		}
+	}
+	if routePath == "" {
+		return "", errors.New("Resource: route " + name + " not found")
+	}
+
+	segments := strings.Split(routePath, "/")
+	index := 0
+	for i, segment := range segments {
+		if segment == "" || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+		if index >= len(params) {
+			return "", errors.New("Resource: route " + name + " missing param " + segment[1:])
+		}
+		value := fmt.Sprint(params[index])
+		if val, ok := params[index].(bson.ObjectId); ok {
+			value = val.Hex()
+		}
+		index++
+		if segment[0] == '*' {
+			segments[i] = strings.TrimPrefix(value, "/")
+		} else {
+			segments[i] = url.PathEscape(value)
+		}
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// URLFor 当前 handler 的反向路由  包括 scheme host
+func URLFor(ctx *gin.Context, name string, params ...interface{}) (string, error) {
+	routes, ok := ctx.Get(CONTEXT_ROUTES)
+	if !ok || routes == nil {
+		return "", errors.New("Resource: routes is nil")
+	}
+	routePath, err := URL(routes.(func() gin.RoutesInfo)(), name, params...)
+	if err != nil {
+		return "", err
+	}
+	scheme := "http"
+	if ctx.Request.TLS != nil {
+		scheme = "https"
+	}
+	// routePath 已经 escape
+	return scheme + "://" + ctx.Request.Host + routePath, nil
+}
+
+// RouteName 路由的 type.action  Handler 注册的优先
+func RouteName(route gin.RouteInfo) string {
+	resource := &Resource{}
+	if route.HandlerFunc != nil {
+		if val, ok := handlersMap.Load(reflect.ValueOf(route.HandlerFunc)); ok && val != nil {
+			resource.Type = val.(Config).Type
+			resource.Action = val.(Config).Action
+		}
+	}
+	if resource.Type == "" || resource.Action == "" {
+		typ, action := Route(route.Method, route.Path)
+		if resource.Type == "" {
+			resource.Type = typ
+		}
+		if resource.Action == "" {
+			resource.Action = action
+		}
+	}
+	return resource.Name()
+}
+
 func (config Config) setResource(ctx *gin.Context, resource *Resource) {
 	if config.Parent != nil {
 		parent := &Resource{}
@@ -147,6 +396,14 @@ func (config Config) setResource(ctx *gin.Context, resource *Resource) {
 
 }
 
+// Name type.action
+func (resource *Resource) Name() string {
+	if resource.Action == "" {
+		return resource.Type
+	}
+	return resource.Type + "." + resource.Action
+}
+
 func (resource *Resource) AppendPre(pre ResourcePre) {
 	resource.pres = append(resource.pres, pre)
 	return
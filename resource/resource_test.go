@@ -0,0 +1,81 @@
+package resource
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRoutePath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		path      string
+		routePath string
+		name      string
+	}{
+		{"/users", "/users", "users.list"},
+		{"/users/123", "/users/:id", "users.show"},
+		{"/users/users", "/users/:id", "users.show"},
+		{"/users/1/posts/posts", "/users/:id/posts/:post", "posts.show"},
+		{"/files/a/b", "/files/*path", "files.show"},
+	}
+	engine := gin.New()
+	engine.Use(Middleware(Config{
+		Auto:   true,
+		Routes: engine.Routes,
+	}))
+	var routePath, name string
+	handler := func(ctx *gin.Context) {
+		routePath = RoutePath(ctx)
+		val, _ := ctx.Get(CONTEXT)
+		name = val.(*Resource).Name()
+	}
+	engine.GET("/users", handler)
+	engine.GET("/users/:id", handler)
+	engine.GET("/users/:id/posts/:post", handler)
+	engine.GET("/files/*path", handler)
+	for _, test := range tests {
+		routePath, name = "", ""
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, test.path, nil))
+		if routePath != test.routePath || name != test.name {
+			t.Errorf("%s: route %q name %q, want %q %q", test.path, routePath, name, test.routePath, test.name)
+		}
+	}
+}
+
+// 没有 Routes 时从参数还原  参数值和固定片段相同时还原错误
+func TestParamsPath(t *testing.T) {
+	params := gin.Params{{Key: "id", Value: "123"}}
+	if got := paramsPath("/users/123", params); got != "/users/:id" {
+		t.Errorf("paramsPath = %q", got)
+	}
+	params = gin.Params{{Key: "id", Value: "users"}}
+	if got := paramsPath("/users/users", params); got != "/:id/users" {
+		t.Errorf("paramsPath = %q", got)
+	}
+}
+
+func TestAutoWithoutPre(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Middleware(Config{
+		Auto:      true,
+		Routes:    engine.Routes,
+		OwnerKeys: []string{"owner"},
+	}))
+	var resource *Resource
+	engine.GET("/users/:id", func(ctx *gin.Context) {
+		val, _ := ctx.Get(CONTEXT)
+		resource = val.(*Resource)
+	})
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if resource.Name() != "users.show" {
+		t.Fatalf("name %q", resource.Name())
+	}
+	// OwnerKeys 仍然等待 Pre
+	if len(resource.pres) != 1 {
+		t.Fatalf("pres %d", len(resource.pres))
+	}
+}
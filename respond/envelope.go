@@ -0,0 +1,70 @@
+package respond
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/i18n"
+)
+
+type (
+	// Envelope 和 errs.Errors 对应  成功时 data  失败时 errors
+	Envelope struct {
+		Data       interface{}            `json:"data"`
+		Meta       map[string]interface{} `json:"meta,omitempty"`
+		StatusCode int                    `json:"status_code"`
+		RequestID  string                 `json:"request_id,omitempty"`
+		Locale     string                 `json:"locale,omitempty"`
+	}
+)
+
+var CONTEXT_META = "GIN.SERVER.RESPOND.META"
+
+// SetMeta 添加到响应的 meta  例如 pagination 的 next
+func SetMeta(ctx *gin.Context, key string, value interface{}) {
+	var meta map[string]interface{}
+	if val, ok := ctx.Get(CONTEXT_META); ok && val != nil {
+		meta = val.(map[string]interface{})
+	} else {
+		meta = map[string]interface{}{}
+		ctx.Set(CONTEXT_META, meta)
+	}
+	meta[key] = value
+}
+
+func OK(ctx *gin.Context, data interface{}) {
+	Respond(ctx, http.StatusOK, data)
+}
+
+// Created location 为空时不输出 Location
+func Created(ctx *gin.Context, data interface{}, location string) {
+	if location != "" {
+		ctx.Header("Location", location)
+	}
+	Respond(ctx, http.StatusCreated, data)
+}
+
+// Message data 为 {"message": 翻译后的 key}
+func Message(ctx *gin.Context, code int, key string, args ...interface{}) {
+	Respond(ctx, code, gin.H{"message": i18n.T(ctx, key, args...)})
+}
+
+func NoContent(ctx *gin.Context) {
+	ctx.Status(http.StatusNoContent)
+	ctx.Writer.WriteHeaderNow()
+}
+
+// Respond 输出 Envelope  按 Accept 协商格式
+func Respond(ctx *gin.Context, code int, data interface{}) {
+	envelope := &Envelope{
+		Data:       data,
+		StatusCode: code,
+		RequestID:  errs.RequestID(ctx),
+		Locale:     i18n.Locale(ctx),
+	}
+	if val, ok := ctx.Get(CONTEXT_META); ok && val != nil {
+		envelope.Meta = val.(map[string]interface{})
+	}
+	Negotiate(ctx, code, envelope)
+}
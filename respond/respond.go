@@ -0,0 +1,68 @@
+package respond
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+	"github.com/otamoe/gin-server/cachecontrol"
+	"github.com/otamoe/gin-server/jsoncodec"
+)
+
+type (
+	// ProtoMessage 同 proto.Message
+	ProtoMessage interface {
+		Reset()
+		String() string
+		ProtoMessage()
+	}
+)
+
+// Offered 顺序即优先级  第一个为默认
+var Offered = []string{
+	binding.MIMEJSON,
+	binding.MIMEXML,
+	binding.MIMEXML2,
+	binding.MIMEMSGPACK,
+	binding.MIMEMSGPACK2,
+	binding.MIMEPROTOBUF,
+}
+
+// CompressTypes 需要加入 compress 白名单的类型
+var CompressTypes = []string{
+	binding.MIMEXML,
+	binding.MIMEXML2,
+	binding.MIMEMSGPACK,
+	binding.MIMEMSGPACK2,
+	binding.MIMEPROTOBUF,
+}
+
+// Negotiate 根据 Accept 选择 json xml msgpack protobuf  obj 不是 proto.Message 时不使用 protobuf
+func Negotiate(ctx *gin.Context, code int, obj interface{}) {
+	cachecontrol.AddVary(ctx, "Accept")
+	format := Format(ctx)
+	if _, ok := obj.(ProtoMessage); !ok && format == binding.MIMEPROTOBUF {
+		format = binding.MIMEJSON
+	}
+	switch format {
+	case binding.MIMEXML, binding.MIMEXML2:
+		ctx.XML(code, obj)
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		ctx.Render(code, render.MsgPack{Data: obj})
+	case binding.MIMEPROTOBUF:
+		ctx.Render(code, render.ProtoBuf{Data: obj})
+	default:
+		jsoncodec.JSON(ctx, code, obj)
+	}
+}
+
+// Format 协商结果  不匹配时为 json  Accept 为空或 */* 时跟随请求的 protobuf Content-Type
+func Format(ctx *gin.Context) string {
+	if accept := ctx.GetHeader("Accept"); (accept == "" || accept == "*/*") && ctx.ContentType() == binding.MIMEPROTOBUF {
+		return binding.MIMEPROTOBUF
+	}
+	format := ctx.NegotiateFormat(Offered...)
+	if format == "" {
+		format = binding.MIMEJSON
+	}
+	return format
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"time"
+
+	"github.com/otamoe/gin-server/scheduler"
+)
+
+type (
+	Scheduler struct {
+		Prefix   string `json:"prefix,omitempty"`
+		Location string `json:"location,omitempty"`
+
+		// 只注册 不执行
+		Disabled bool `json:"disabled,omitempty"`
+
+		scheduler *scheduler.Scheduler
+	}
+)
+
+func (config *Scheduler) init(server *Server, handler *Handler) {
+	if config.scheduler != nil {
+		return
+	}
+	c := scheduler.Config{
+		Prefix: config.Prefix,
+		Logger: server.Logger.Get(),
+	}
+	// 有 redis 时 多实例只执行一次
+	if provider := server.redisProvider(); provider != nil {
+		c.Client = provider.Get()
+	}
+	if config.Location != "" {
+		location, err := time.LoadLocation(config.Location)
+		if err != nil {
+			panic(err)
+		}
+		c.Location = location
+	}
+	config.scheduler = scheduler.New(c)
+}
+
+func (config *Scheduler) Get() *scheduler.Scheduler {
+	return config.scheduler
+}
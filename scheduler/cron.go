@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	Schedule interface {
+		Next(t time.Time) time.Time
+	}
+
+	// cron 分 时 日 月 周
+	cronSchedule struct {
+		minute, hour, dom, month, dow uint64
+		domStar, dowStar              bool
+	}
+
+	everySchedule struct {
+		every time.Duration
+	}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse 支持 5 段 cron  @daily 等  @every 10m
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(spec[7:]))
+		if err != nil {
+			return nil, err
+		}
+		if every < time.Second {
+			return nil, errors.New("Scheduler: @every must be at least 1s")
+		}
+		return &everySchedule{every: every}, nil
+	}
+	if val, ok := macros[spec]; ok {
+		spec = val
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New("Scheduler: expected 5 fields in " + spec)
+	}
+	schedule := &cronSchedule{}
+	var err error
+	if schedule.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if schedule.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if schedule.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if schedule.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if schedule.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// 7 也是周日
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	schedule.domStar = fields[2] == "*" || fields[2] == "?"
+	schedule.dowStar = fields[4] == "*" || fields[4] == "?"
+	return schedule, nil
+}
+
+func parseField(field string, min, max int) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if index := strings.Index(part, "/"); index != -1 {
+			if step, err = strconv.Atoi(part[index+1:]); err != nil || step <= 0 {
+				return 0, errors.New("Scheduler: invalid step " + part)
+			}
+			part = part[:index]
+		}
+		start, end := min, max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			values := strings.SplitN(part, "-", 2)
+			if start, err = strconv.Atoi(values[0]); err != nil {
+				return 0, errors.New("Scheduler: invalid range " + part)
+			}
+			if end, err = strconv.Atoi(values[1]); err != nil {
+				return 0, errors.New("Scheduler: invalid range " + part)
+			}
+		default:
+			if start, err = strconv.Atoi(part); err != nil {
+				return 0, errors.New("Scheduler: invalid value " + part)
+			}
+			if step == 1 {
+				end = start
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, errors.New("Scheduler: value out of range " + field)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return
+}
+
+func (schedule *everySchedule) Next(t time.Time) time.Time {
+	return t.Truncate(schedule.every).Add(schedule.every)
+}
+
+// Next 下一次触发时间  最多查找 5 年
+func (schedule *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		if schedule.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !schedule.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if schedule.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if schedule.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchDay 日 周 都有限制时满足任意一个即可
+func (schedule *cronSchedule) matchDay(t time.Time) bool {
+	dom := schedule.dom&(1<<uint(t.Day())) != 0
+	dow := schedule.dow&(1<<uint(t.Weekday())) != 0
+	if schedule.domStar || schedule.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,178 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/clock"
+	"github.com/otamoe/gin-server/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	Config struct {
+		// 为空时不加锁  每个实例都会执行
+		Client *redis.Client
+		Prefix string
+
+		Location *time.Location
+		Logger   *logrus.Logger
+	}
+
+	Task func(ctx context.Context) error
+
+	Scheduler struct {
+		config Config
+		mutex  sync.Mutex
+		tasks  map[string]*task
+
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+
+		runs *metrics.Counter
+	}
+
+	task struct {
+		name     string
+		spec     string
+		schedule Schedule
+		run      Task
+	}
+)
+
+func New(c Config) *Scheduler {
+	if c.Prefix == "" {
+		c.Prefix = "scheduler"
+	}
+	if c.Location == nil {
+		c.Location = time.Local
+	}
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	return &Scheduler{
+		config: c,
+		tasks:  map[string]*task{},
+		runs:   metrics.Default.Counter("scheduler_runs_total", "Scheduled task runs.", "name", "status"),
+	}
+}
+
+// Register spec 错误 panic
+func (scheduler *Scheduler) Register(name string, spec string, run Task) {
+	schedule, err := Parse(spec)
+	if err != nil {
+		panic(err)
+	}
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if _, ok := scheduler.tasks[name]; ok {
+		panic("Scheduler: " + name + " has exists")
+	}
+	t := &task{
+		name:     name,
+		spec:     spec,
+		schedule: schedule,
+		run:      run,
+	}
+	scheduler.tasks[name] = t
+	if scheduler.ctx != nil {
+		scheduler.start(t)
+	}
+}
+
+func (scheduler *Scheduler) Start() {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if scheduler.ctx != nil {
+		return
+	}
+	scheduler.ctx, scheduler.cancel = context.WithCancel(context.Background())
+	for _, t := range scheduler.tasks {
+		scheduler.start(t)
+	}
+}
+
+// Stop 不再触发  等待正在执行的任务
+func (scheduler *Scheduler) Stop(ctx context.Context) error {
+	scheduler.mutex.Lock()
+	cancel := scheduler.cancel
+	scheduler.mutex.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		scheduler.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (scheduler *Scheduler) start(t *task) {
+	scheduler.wg.Add(1)
+	go func() {
+		defer scheduler.wg.Done()
+		ctx := scheduler.ctx
+		for {
+			next := t.schedule.Next(clock.Now().In(scheduler.config.Location))
+			if next.IsZero() {
+				return
+			}
+			timer := clock.NewTimer(clock.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C():
+			}
+			if !scheduler.lock(t, next) {
+				continue
+			}
+			scheduler.execute(ctx, t)
+		}
+	}()
+}
+
+// lock 同一个 tick 只有一个实例执行
+func (scheduler *Scheduler) lock(t *task, tick time.Time) bool {
+	if scheduler.config.Client == nil {
+		return true
+	}
+	key := scheduler.config.Prefix + "." + t.name + "." + strconv.FormatInt(tick.Unix(), 10)
+	ok, err := scheduler.config.Client.SetNX(key, 1, time.Hour).Result()
+	if err != nil {
+		scheduler.config.Logger.Errorf("[SCHEDULER] %s lock %s", t.name, err)
+		return false
+	}
+	return ok
+}
+
+func (scheduler *Scheduler) execute(ctx context.Context, t *task) {
+	start := clock.Now()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return t.run(ctx)
+	}()
+	if err != nil {
+		scheduler.runs.Inc(t.name, "error")
+		scheduler.config.Logger.Errorf("[SCHEDULER] %s %s %s", t.name, clock.Since(start), err)
+		return
+	}
+	scheduler.runs.Inc(t.name, "success")
+	scheduler.config.Logger.Infof("[SCHEDULER] %s %s", t.name, clock.Since(start))
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/otamoe/gin-server/secrets"
+)
+
+type (
+	// Secrets 配置中的 ${env:NAME} ${file:/path} ${vault:path#key} 在加载时替换
+	// 本身只能使用 env file
+	Secrets struct {
+		// .enc 文件的 AES 密钥  base64  例如 ${env:SECRETS_KEY}
+		Key string `json:"key,omitempty"`
+
+		Vault *SecretsVault `json:"vault,omitempty"`
+
+		// 全部读取的超时  默认 10 秒
+		Timeout time.Duration `json:"timeout,omitempty"`
+	}
+
+	// SecretsVault 为空时使用 VAULT_ADDR VAULT_TOKEN
+	SecretsVault struct {
+		Addr      string `json:"addr,omitempty"`
+		Token     string `json:"token,omitempty"`
+		Namespace string `json:"namespace,omitempty"`
+	}
+)
+
+// AddSecretProvider LoadConfig 之前注册  ${name:ref}  同名的代替 env file vault
+func (server *Server) AddSecretProvider(name string, provider secrets.Provider) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.secrets == nil {
+		server.secrets = map[string]secrets.Provider{}
+	}
+	server.secrets[name] = provider
+}
+
+// resolveConfig 合并 profile 后替换 secrets
+func (server *Server) resolveConfig(data []byte, env string) ([]byte, error) {
+	data, err := ResolveProfile(data, env)
+	if err != nil {
+		return nil, err
+	}
+	return server.resolveSecrets(data)
+}
+
+func (server *Server) resolveSecrets(data []byte) ([]byte, error) {
+	doc := struct {
+		Secrets json.RawMessage `json:"secrets"`
+	}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	resolver := secrets.New()
+	config := &Secrets{}
+	if doc.Secrets != nil {
+		raw, err := resolver.Resolve(context.Background(), doc.Secrets)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(raw, config); err != nil {
+			return nil, err
+		}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = time.Second * 10
+	}
+	if config.Key != "" {
+		key, err := base64.StdEncoding.DecodeString(config.Key)
+		if err != nil {
+			return nil, err
+		}
+		resolver.Register("file", &secrets.File{Key: key})
+	}
+	vault := &secrets.Vault{}
+	if config.Vault != nil {
+		vault.Addr = config.Vault.Addr
+		vault.Token = config.Vault.Token
+		vault.Namespace = config.Vault.Namespace
+	}
+	resolver.Register("vault", vault)
+
+	server.mutex.Lock()
+	for name, provider := range server.secrets {
+		resolver.Register(name, provider)
+	}
+	server.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	return resolver.Resolve(ctx, data)
+}
@@ -0,0 +1,278 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type (
+	// Provider ref 为 ${name:ref} 中 : 之后的部分
+	Provider interface {
+		Get(ctx context.Context, ref string) (string, error)
+	}
+
+	ProviderFunc func(ctx context.Context, ref string) (string, error)
+
+	// Resolver 按名字选择 Provider  同一个 ref 只读取一次
+	Resolver struct {
+		providers map[string]Provider
+		cache     map[string]string
+		mutex     sync.Mutex
+	}
+
+	// Env ${env:REDIS_PASSWORD}
+	Env struct{}
+
+	// File ${file:/run/secrets/mongo}  去掉结尾的换行
+	// .enc 结尾的文件使用 Key 解密  AES-GCM  nonce 在前  Encrypt 生成
+	File struct {
+		Key []byte
+	}
+
+	// Vault ${vault:secret/app#mongo_password}  KV v1 v2  Addr Token 为空时使用 VAULT_ADDR VAULT_TOKEN
+	Vault struct {
+		Addr      string
+		Token     string
+		Namespace string
+		Client    *http.Client
+	}
+)
+
+var pattern = regexp.MustCompile(`\$\{([a-z][a-z0-9_]*):([^}]*)\}`)
+
+var ErrKey = errors.New("Secrets: key is empty for encrypted file")
+
+func (fn ProviderFunc) Get(ctx context.Context, ref string) (string, error) {
+	return fn(ctx, ref)
+}
+
+// New 带 env file  file 不解密
+func New() *Resolver {
+	resolver := &Resolver{
+		providers: map[string]Provider{},
+		cache:     map[string]string{},
+	}
+	resolver.Register("env", Env{})
+	resolver.Register("file", &File{})
+	return resolver
+}
+
+// Register 同名的代替
+func (resolver *Resolver) Register(name string, provider Provider) {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	resolver.providers[name] = provider
+}
+
+// String 替换 s 中所有的 ${name:ref}
+func (resolver *Resolver) String(ctx context.Context, s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var err error
+	value := pattern.ReplaceAllStringFunc(s, func(match string) string {
+		if err != nil {
+			return match
+		}
+		sub := pattern.FindStringSubmatch(match)
+		var val string
+		val, err = resolver.get(ctx, sub[1], sub[2])
+		return val
+	})
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// Resolve 替换 json 中所有字符串的引用  key 不替换
+func (resolver *Resolver) Resolve(ctx context.Context, data []byte) ([]byte, error) {
+	if !strings.Contains(string(data), "${") {
+		return data, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	value, err := resolver.value(ctx, value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+func (resolver *Resolver) value(ctx context.Context, value interface{}) (interface{}, error) {
+	var err error
+	switch val := value.(type) {
+	case map[string]interface{}:
+		for key, item := range val {
+			if val[key], err = resolver.value(ctx, item); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			if val[i], err = resolver.value(ctx, item); err != nil {
+				return nil, err
+			}
+		}
+	case string:
+		return resolver.String(ctx, val)
+	}
+	return value, nil
+}
+
+func (resolver *Resolver) get(ctx context.Context, name, ref string) (string, error) {
+	key := name + ":" + ref
+	resolver.mutex.Lock()
+	provider, ok := resolver.providers[name]
+	val, cached := resolver.cache[key]
+	resolver.mutex.Unlock()
+	if !ok {
+		return "", errors.New("Secrets: unknown provider " + name)
+	}
+	if cached {
+		return val, nil
+	}
+	val, err := provider.Get(ctx, ref)
+	if err != nil {
+		return "", errors.New("Secrets: " + key + ": " + err.Error())
+	}
+	resolver.mutex.Lock()
+	resolver.cache[key] = val
+	resolver.mutex.Unlock()
+	return val, nil
+}
+
+func (Env) Get(ctx context.Context, ref string) (string, error) {
+	val, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", errors.New("not set")
+	}
+	return val, nil
+}
+
+func (file *File) Get(ctx context.Context, ref string) (string, error) {
+	data, err := ioutil.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(ref, ".enc") {
+		if len(file.Key) == 0 {
+			return "", ErrKey
+		}
+		if data, err = Decrypt(file.Key, data); err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Encrypt 生成 File 读取的 .enc 文件  key 16 24 32 字节
+func Encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func Decrypt(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("Secrets: encrypted data is too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Get ref 为 path#key  v2 的 path 包含 data  例如 secret/data/app#password
+func (vault *Vault) Get(ctx context.Context, ref string) (string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i == -1 {
+		return "", errors.New("key is empty")
+	}
+	path, key := strings.Trim(ref[:i], "/"), ref[i+1:]
+
+	addr := vault.Addr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return "", errors.New("vault addr is empty")
+	}
+	token := vault.Token
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	client := vault.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", token)
+	if vault.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", vault.Namespace)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", errors.New("vault " + res.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	data := body.Data
+	// KV v2  data.data data.metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	val, ok := data[key]
+	if !ok {
+		return "", errors.New("key " + key + " not found")
+	}
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(val)
+	return string(b), err
+}
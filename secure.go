@@ -0,0 +1,34 @@
+package server
+
+type (
+	Secure struct {
+		Disabled bool `json:"disabled,omitempty"`
+
+		HSTSMaxAge            int  `json:"hsts_max_age,omitempty"`
+		HSTSIncludeSubdomains bool `json:"hsts_include_subdomains,omitempty"`
+		HSTSPreload           bool `json:"hsts_preload,omitempty"`
+
+		ContentSecurityPolicy string `json:"content_security_policy,omitempty"`
+		FrameOptions          string `json:"frame_options,omitempty"`
+		ReferrerPolicy        string `json:"referrer_policy,omitempty"`
+		PermissionsPolicy     string `json:"permissions_policy,omitempty"`
+	}
+)
+
+func (config *Secure) init(server *Server, handler *Handler) {
+	if config.HSTSMaxAge == 0 {
+		config.HSTSMaxAge = 86400 * 180
+	}
+	if config.ContentSecurityPolicy == "" {
+		config.ContentSecurityPolicy = "default-src 'self'; frame-ancestors 'self'; object-src 'none'"
+	}
+	if config.FrameOptions == "" {
+		config.FrameOptions = "SAMEORIGIN"
+	}
+	if config.ReferrerPolicy == "" {
+		config.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+	if config.PermissionsPolicy == "" {
+		config.PermissionsPolicy = "camera=(), microphone=(), geolocation=()"
+	}
+}
@@ -0,0 +1,58 @@
+package secureheaders
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	Config struct {
+		HSTSMaxAge            int
+		HSTSIncludeSubdomains bool
+		HSTSPreload           bool
+
+		ContentSecurityPolicy string
+		FrameOptions          string
+		ContentTypeNosniff    bool
+		ReferrerPolicy        string
+		PermissionsPolicy     string
+	}
+)
+
+func Middleware(c Config) gin.HandlerFunc {
+	var hsts string
+	if c.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(c.HSTSMaxAge)
+		if c.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if c.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
+	return func(ctx *gin.Context) {
+		header := ctx.Writer.Header()
+		// 浏览器会忽略 http 下的 HSTS
+		if hsts != "" {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		if c.ContentSecurityPolicy != "" {
+			header.Set("Content-Security-Policy", c.ContentSecurityPolicy)
+		}
+		if c.FrameOptions != "" {
+			header.Set("X-Frame-Options", c.FrameOptions)
+		}
+		if c.ContentTypeNosniff {
+			header.Set("X-Content-Type-Options", "nosniff")
+		}
+		if c.ReferrerPolicy != "" {
+			header.Set("Referrer-Policy", c.ReferrerPolicy)
+		}
+		if c.PermissionsPolicy != "" {
+			header.Set("Permissions-Policy", c.PermissionsPolicy)
+		}
+		ctx.Next()
+	}
+}
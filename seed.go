@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/otamoe/gin-server/seed"
+)
+
+type (
+	// Seed 启动时执行 seed.Register 注册的 seeder
+	Seed struct {
+		// 自动执行的环境  默认 development test
+		ENVs []string `json:"envs,omitempty"`
+
+		// 只执行这些  为空执行全部
+		Names []string `json:"names,omitempty"`
+
+		Timeout time.Duration `json:"timeout,omitempty"`
+
+		// 启动时不执行  只通过 cli seed
+		Disabled bool `json:"disabled,omitempty"`
+	}
+)
+
+func (config *Seed) init(server *Server, handler *Handler) {
+	if len(config.ENVs) == 0 {
+		config.ENVs = []string{"development", "test"}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = time.Minute
+	}
+}
+
+func (config *Seed) enabled(env string) bool {
+	if config.Disabled {
+		return false
+	}
+	for _, val := range config.ENVs {
+		if val == env {
+			return true
+		}
+	}
+	return false
+}
+
+// RunSeeds names 为空时使用配置的 Names
+func (server *Server) RunSeeds(ctx context.Context, names []string) error {
+	c := seed.Config{
+		Names: names,
+	}
+	if server.Seed != nil && len(names) == 0 {
+		c.Names = server.Seed.Names
+	}
+	c.ENV = server.ENV
+	c.Logger = server.Logger.Get()
+	if server.mongoProvider() != nil {
+		session, err := server.mongoSession()
+		if err != nil {
+			return err
+		}
+		c.Mongo = session
+		defer c.Mongo.Close()
+	}
+	if provider := server.redisProvider(); provider != nil {
+		c.Redis = provider.Get()
+		defer c.Redis.Close()
+	}
+	return seed.Run(ctx, c)
+}
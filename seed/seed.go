@@ -0,0 +1,104 @@
+package seed
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// Seeder 需要幂等  每次启动都会执行  例如 Upsert
+	Seeder func(ctx context.Context, env *Env) error
+
+	Env struct {
+		Mongo  *mgo.Session
+		Redis  *redis.Client
+		Logger *logrus.Logger
+
+		// development test
+		ENV string
+	}
+
+	Config struct {
+		Env
+
+		// 只执行这些  为空执行全部
+		Names []string
+	}
+
+	seeder struct {
+		name  string
+		order int
+		run   Seeder
+	}
+)
+
+var (
+	mutex    sync.Mutex
+	registry = map[string]*seeder{}
+)
+
+// Register order 小的先执行  相同时按名字
+func Register(name string, order int, run Seeder) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("Seed: " + name + " has exists")
+	}
+	registry[name] = &seeder{
+		name:  name,
+		order: order,
+		run:   run,
+	}
+}
+
+// Names 按执行顺序
+func Names() (names []string) {
+	for _, val := range sorted() {
+		names = append(names, val.name)
+	}
+	return
+}
+
+// Run 按顺序执行  第一个错误时停止
+func Run(ctx context.Context, c Config) error {
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	only := map[string]bool{}
+	for _, name := range c.Names {
+		only[name] = true
+	}
+	for _, val := range sorted() {
+		if len(only) != 0 && !only[val.name] {
+			continue
+		}
+		start := time.Now()
+		if err := val.run(ctx, &c.Env); err != nil {
+			c.Logger.Errorf("[SEED] %s: %s", val.name, err)
+			return err
+		}
+		c.Logger.Infof("[SEED] %s %s", val.name, time.Since(start))
+	}
+	return nil
+}
+
+func sorted() (values []*seeder) {
+	mutex.Lock()
+	for _, val := range registry {
+		values = append(values, val)
+	}
+	mutex.Unlock()
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].order != values[j].order {
+			return values[i].order < values[j].order
+		}
+		return values[i].name < values[j].name
+	})
+	return
+}
@@ -3,18 +3,34 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/otamoe/gin-server/clock"
+	"github.com/otamoe/gin-server/events"
+	"github.com/otamoe/gin-server/featureflags"
+	"github.com/otamoe/gin-server/health"
+	"github.com/otamoe/gin-server/jsoncodec"
+	"github.com/otamoe/gin-server/metrics"
+	"github.com/otamoe/gin-server/secrets"
+	"github.com/otamoe/gin-server/sse"
 	_ "github.com/otamoe/gin-server/validator"
+	"github.com/otamoe/gin-server/websocket"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	validator9 "gopkg.in/go-playground/validator.v9"
 )
 
 type (
@@ -30,26 +46,166 @@ type (
 		IdleTimeout       time.Duration `json:"idle_timeout,omitempty"`
 		ShutdownTimeout   time.Duration `json:"shutdown_timeout,omitempty"`
 
-		Compress *Compress  `json:"compress,omitempty"`
-		Logger   *Logger    `json:"logger,omitempty"`
-		Redis    *Redis     `json:"redis,omitempty"`
-		Mongo    *Mongo     `json:"mongo,omitempty"`
-		Handlers []*Handler `json:"handlers,omitempty"`
+		// 多个监听地址  设置后不使用 Addr  共用 handler  一起关闭
+		// 例如 ["0.0.0.0:8080", "[::]:8080", {"addr": "10.0.0.5:9090", "certificates": [...]}]
+		Listeners []*Listener `json:"listeners,omitempty"`
 
-		httpServer *http.Server
+		// SNI host => tls 参数  例如 partner.example.com 要求客户端证书
+		TLSPolicies map[string]*TLSPolicy `json:"tls_policies,omitempty"`
+
+		KeepAlive   *KeepAlive   `json:"keep_alive,omitempty"`
+		Connections *Connections `json:"connections,omitempty"`
+		Slowloris   *Slowloris   `json:"slowloris,omitempty"`
+
+		// 负载均衡的 PROXY protocol
+		ProxyProtocol *ProxyProtocol `json:"proxy_protocol,omitempty"`
+
+		// 路由之前规范请求
+		Normalize *Normalize `json:"normalize,omitempty"`
+
+		// ALPN  为空时 h2 http/1.1
+		NextProtos []string `json:"next_protos,omitempty"`
+		HTTP2      *HTTP2   `json:"http2,omitempty"`
+
+		// 关闭前 readyz 先返回 503  等待负载均衡摘除
+		DrainDelay time.Duration `json:"drain_delay,omitempty"`
+
+		// 限速 name => limit  见 RateLimit
+		RateLimits map[string]int64 `json:"rate_limits,omitempty"`
+
+		// feature flag name => on off N%  应用到 Flags
+		Features map[string]string   `json:"features,omitempty"`
+		Flags    *featureflags.Flags `json:"-"`
+
+		// 关闭的信号  默认 SIGINT SIGTERM  SIGHUP 重新加载  SIGQUIT 输出 goroutine  test env 不处理  使用 Stop
+		Signals []string `json:"signals,omitempty"`
+
+		// 只有来自这些地址的请求才使用 X-Forwarded-For X-Forwarded-Host X-Host  见 clientip
+		TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+		// 来自 TrustedProxies 时读取客户端 IP 的 header  默认 X-Forwarded-For X-Real-Ip
+		RemoteIPHeaders []string `json:"remote_ip_headers,omitempty"`
+
+		// 平台提供的客户端 IP header  例如 CF-Connecting-IP X-Appengine-Remote-Addr  无条件信任
+		TrustedPlatform string `json:"trusted_platform,omitempty"`
+
+		// CF-Connecting-IP True-Client-IP 等
+		CDN []*CDN `json:"cdn,omitempty"`
+
+		// host => target 例如 www.example.com => example.com
+		Redirects map[string]string `json:"redirects,omitempty"`
+
+		Compress    *Compress        `json:"compress,omitempty"`
+		Logger      *Logger          `json:"logger,omitempty"`
+		Redis       *Redis           `json:"redis,omitempty"`
+		Mongo       *Mongo           `json:"mongo,omitempty"`
+		Size        *Size            `json:"size,omitempty"`
+		Errors      *Errors          `json:"errors,omitempty"`
+		Builtins    *Builtins        `json:"builtins,omitempty"`
+		Static      *Static          `json:"static,omitempty"`
+		Templates   *Templates       `json:"templates,omitempty"`
+		I18n        *I18n            `json:"i18n,omitempty"`
+		TimeZone    *TimeZone        `json:"time_zone,omitempty"`
+		Assets      *Assets          `json:"assets,omitempty"`
+		ACME        *ACME            `json:"acme,omitempty"`
+		Cors        *Cors            `json:"cors,omitempty"`
+		Canonical   *Canonical       `json:"canonical,omitempty"`
+		Versioning  *Versioning      `json:"versioning,omitempty"`
+		Deprecation *Deprecation     `json:"deprecation,omitempty"`
+		Sniff       *Sniff           `json:"sniff,omitempty"`
+		Secure      *Secure          `json:"secure,omitempty"`
+		Headers     *RequestHeaders  `json:"headers,omitempty"`
+		Concurrent  *ConcurrentLimit `json:"concurrent,omitempty"`
+		LoadShed    *LoadShed        `json:"load_shed,omitempty"`
+		Admission   *Admission       `json:"admission,omitempty"`
+		Timeout     *Timeout         `json:"timeout,omitempty"`
+		Tenant      *Tenant          `json:"tenant,omitempty"`
+		JWT         *JWT             `json:"jwt,omitempty"`
+		Sessions    *Sessions        `json:"sessions,omitempty"`
+		ACL         *ACL             `json:"acl,omitempty"`
+		TOTP        *TOTP            `json:"totp,omitempty"`
+		SignedURL   *SignedURL       `json:"signed_url,omitempty"`
+		OAuthServer *OAuthServer     `json:"oauth_server,omitempty"`
+		Metrics     *Metrics         `json:"metrics,omitempty"`
+		Tracing     *Tracing         `json:"tracing,omitempty"`
+		Recorder    *Recorder        `json:"recorder,omitempty"`
+		Capture     *Capture         `json:"capture,omitempty"`
+		Alerts      *Alerts          `json:"alerts,omitempty"`
+		Health      *Health          `json:"health,omitempty"`
+		Switch      *Switch          `json:"switch,omitempty"`
+		Jobs        *Jobs            `json:"jobs,omitempty"`
+		Webhooks    *Webhooks        `json:"webhooks,omitempty"`
+		Mail        *Mail            `json:"mail,omitempty"`
+		Notify      *Notify          `json:"notify,omitempty"`
+		Outbox      *Outbox          `json:"outbox,omitempty"`
+		Streams     *Streams         `json:"streams,omitempty"`
+		Scheduler   *Scheduler       `json:"scheduler,omitempty"`
+		Events      *Events          `json:"events,omitempty"`
+		Seed        *Seed            `json:"seed,omitempty"`
+		Warmup      *Warmup          `json:"warmup,omitempty"`
+		OpenAPI     *OpenAPI         `json:"openapi,omitempty"`
+		Migrate     *Migrate         `json:"migrate,omitempty"`
+		Backup      *Backup          `json:"backup,omitempty"`
+		Handlers    []*Handler       `json:"handlers,omitempty"`
+
+		// AddInitializer 注册的函数  完成前 readyz 返回 503
+		Initializers *Initializers `json:"initializers,omitempty"`
+
+		// Register 注册的模块的配置  name => 任意 json
+		Modules map[string]json.RawMessage `json:"modules,omitempty"`
+
+		// LoadConfig ReloadConfig 替换 ${env:...} ${file:...} ${vault:...}
+		Secrets *Secrets `json:"secrets,omitempty"`
+
+		// static templates builtins 的文件  embed 使用 http.FS(embedFS)  单个二进制部署
+		FS http.FileSystem `json:"-"`
+
+		// respond errs jsonapi 的 JSON  std jsoniter  JSONCodec 优先  例如 sonic.ConfigStd
+		JSON      string          `json:"json,omitempty"`
+		JSONCodec jsoncodec.Codec `json:"-"`
+
+		// 代替 validator 包设置的 binding.Validator  全局的  所有 handler 使用
+		Validator binding.StructValidator `json:"-"`
+
+		// certificate sessions rate proxy 缓存 scheduler 的时间  测试使用 clock.NewMock 冻结和前进
+		Clock clock.Clock `json:"-"`
+
+		// 设置后代替 Redis Mongo 配置
+		RedisProvider RedisProvider `json:"-"`
+		MongoProvider MongoProvider `json:"-"`
+
+		// 和 http 共用端口  明文使用 cmux  TLS 使用 http2
+		GRPC *grpc.Server `json:"-"`
+
+		httpServer     *http.Server
+		serverHandler  *serverHandler
+		trustedProxies []*net.IPNet
+		tlsConfig      atomic.Value
+		reloads        []func() error
+		rateLimits     atomic.Value
+		configData     map[string]json.RawMessage
+		validations    map[string]validator9.Func
+		logs           *LogBuffer
+		initializers   []initializer
+		initSteps      []InitStep
+		modules        []Module
+		builtins       map[string]Module
+		secrets        map[string]secrets.Provider
+		initialized    chan struct{}
+		initErrs       map[string]error
+		quit           chan os.Signal
+		mutex          sync.Mutex
 	}
 )
 
 func (server *Server) Init() *Server {
-	switch server.ENV {
-	case "dev", "development":
-		server.ENV = "development"
-	case "test":
-		server.ENV = "test"
-	default:
-		server.ENV = "production"
+	if err := server.Validate(); err != nil {
+		panic(err)
 	}
 
+	server.ENV = normalizeENV(server.ENV)
+	server.initTesting()
+
 	if server.Name == "" {
 		dir, err := os.Getwd()
 		if err != nil {
@@ -62,73 +218,16 @@ func (server *Server) Init() *Server {
 		server.Name = strings.ToLower(server.Name)
 	}
 
-	if server.Addr == "" {
-		if server.Certificates == nil {
-			server.Addr = ":8080"
-		} else {
-			server.Addr = ":8443"
-		}
+	steps, err := sortInitSteps(server.steps())
+	if err != nil {
+		panic(err)
 	}
-
-	if strings.HasSuffix(server.Addr, ":443") || strings.HasSuffix(server.Addr, ":8443") || (server.Certificates != nil && len(server.Certificates) == 0) {
-		if len(server.Certificates) == 0 {
-			priv, cert, err := NewCertificate("localhost", []string{"localhost"}, "ecdsa", 384)
-			if err != nil {
-				panic(err)
-			}
-
-			certificate, err2 := EncodeCertificate(priv, cert)
-			if err2 != nil {
-				panic(err)
-			}
-
-			server.Certificates = append(server.Certificates, certificate)
+	for _, step := range steps {
+		if err := step.Run(server); err != nil {
+			panic(errors.New("Server: init " + step.Name + ": " + err.Error()))
 		}
 	}
 
-	if server.ReadTimeout == 0 {
-		server.ReadTimeout = time.Second * 20
-	}
-	if server.ReadHeaderTimeout == 0 {
-		server.ReadHeaderTimeout = time.Second * 10
-	}
-	if server.WriteTimeout == 0 {
-		server.WriteTimeout = time.Second * 30
-	}
-	if server.IdleTimeout == 0 {
-		server.IdleTimeout = time.Second * 300
-	}
-	if server.ShutdownTimeout == 0 {
-		server.ShutdownTimeout = server.WriteTimeout + server.ReadTimeout + server.ReadHeaderTimeout
-	}
-
-	// gin
-	switch server.ENV {
-	case "development":
-		gin.SetMode(gin.DebugMode)
-	case "test":
-		gin.SetMode(gin.TestMode)
-	default:
-		gin.SetMode(gin.ReleaseMode)
-	}
-
-	if server.Compress == nil {
-		server.Compress = &Compress{}
-	}
-	server.Compress.init(server, nil)
-
-	if server.Logger == nil {
-		server.Logger = &Logger{}
-	}
-	server.Logger.init(server, nil)
-
-	if server.Redis != nil {
-		server.Redis.init(server, nil)
-	}
-	if server.Mongo != nil {
-		server.Mongo.init(server, nil)
-	}
-
 	return server
 }
 
@@ -157,45 +256,25 @@ func (server *Server) GetHttpServer() *http.Server {
 	}
 	var tlsConfig *tls.Config
 	if len(server.Certificates) != 0 {
-		var certificates []tls.Certificate
-		for _, val := range server.Certificates {
-			certificate, err := tls.X509KeyPair([]byte(val.Certificate), []byte(val.PrivateKey))
-			if err != nil {
-				panic(err)
-			}
-			certificates = append(certificates, certificate)
+		configs, err := server.loadTLSConfigs(server.Certificates, server.TLSPolicies)
+		if err != nil {
+			panic(err)
 		}
-		tlsConfig = &tls.Config{
-			MinVersion:               tls.VersionTLS10,
-			Certificates:             certificates,
-			PreferServerCipherSuites: true,
-			CipherSuites: []uint16{
-				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
-				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
-			},
+		server.tlsConfig.Store(configs)
+
+		// SIGHUP 时替换  按 SNI 使用 TLSPolicies
+		tlsConfig = configs.base.Clone()
+		tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			return server.tlsConfig.Load().(*tlsConfigs).get(hello.ServerName), nil
 		}
-		tlsConfig.BuildNameToCertificate()
 	}
 
 	logWriter := server.Logger.Get().Writer()
 	defer logWriter.Close()
 
-	handler := serverHandler{}
-	for _, val := range server.Handlers {
-		for _, host := range val.Hosts {
-			if val.Get() != nil {
-				handler[host] = val.Get()
-			}
-		}
-	}
-
 	server.httpServer = &http.Server{
 		Addr:              server.Addr,
-		Handler:           handler,
+		Handler:           server.getServerHandler(),
 		TLSConfig:         tlsConfig,
 		ReadTimeout:       server.ReadTimeout,
 		ReadHeaderTimeout: server.ReadHeaderTimeout,
@@ -204,17 +283,196 @@ func (server *Server) GetHttpServer() *http.Server {
 		MaxHeaderBytes:    4096,
 		ErrorLog:          log.New(logWriter, "", 0),
 	}
-
+	conns := server.getServerHandler().conns
+	if server.Metrics != nil {
+		conns.enableMetrics(metrics.Default)
+	}
+	server.httpServer.ConnState = conns.connState
+	if slow := server.getServerHandler().slow; slow != nil {
+		server.httpServer.ConnState = func(conn net.Conn, state http.ConnState) {
+			conns.connState(conn, state)
+			slow.connState(conn, state)
+		}
+	}
+	if server.KeepAlive != nil && server.KeepAlive.Disabled {
+		server.httpServer.SetKeepAlivesEnabled(false)
+	}
+	if tlsConfig != nil || server.listenersTLS() {
+		if err := server.configureHTTP2(server.httpServer); err != nil {
+			panic(err)
+		}
+	}
 	return server.httpServer
 }
 
+func (server *Server) getServerHandler() *serverHandler {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.serverHandler != nil {
+		return server.serverHandler
+	}
+	handler := newServerHandler()
+	handler.trustedProxies = server.trustedProxies
+	handler.builtins = server.Builtins
+	handler.acme = server.ACME
+	handler.health = server.Health
+	handler.switcher = server.Switch
+	handler.keepAlive = server.KeepAlive
+	handler.normalize = server.Normalize
+	if len(server.TLSPolicies) != 0 {
+		handler.tlsPolicies = append(handler.tlsPolicies, tlsPolicyHosts(server.TLSPolicies))
+	}
+	for _, val := range server.Listeners {
+		if len(val.TLSPolicies) != 0 {
+			handler.tlsPolicies = append(handler.tlsPolicies, tlsPolicyHosts(val.TLSPolicies))
+		}
+	}
+	if server.Slowloris != nil {
+		handler.slow = newSlowListener(server.Slowloris, server.Logger.Get())
+	}
+	if server.GRPC != nil {
+		handler.grpc = server.GRPC
+	}
+	for host, target := range server.Redirects {
+		handler.redirect(host, target)
+	}
+	for _, val := range server.Handlers {
+		if val.Get() == nil {
+			continue
+		}
+		for _, host := range val.Hosts {
+			if len(val.Prefixes) == 0 {
+				handler.add(host, val.http(server))
+			}
+			for _, prefix := range val.Prefixes {
+				handler.add(host+"/"+strings.TrimPrefix(prefix, "/"), val.http(server))
+			}
+			handler.setBuiltins(host, val.Builtins)
+		}
+	}
+	server.serverHandler = handler
+	return handler
+}
+
+// Add 运行时添加 host 或 host/prefix  已存在则替换
+func (server *Server) Add(host string, handler http.Handler) {
+	server.getServerHandler().add(host, handler)
+}
+
+// Remove 运行时删除 host 或 host/prefix
+func (server *Server) Remove(host string) {
+	server.getServerHandler().remove(host)
+}
+
+// ConnStates 各状态的连接数  例如 active idle
+func (server *Server) ConnStates() map[string]int {
+	return server.getServerHandler().conns.counts()
+}
+
+// Active 正在处理的请求数
+func (server *Server) Active() int64 {
+	return atomic.LoadInt64(&server.getServerHandler().active)
+}
+
+// drain readyz 返回 503 => 等待 DrainDelay => 停止接受连接 等待请求完成 => 截止前关闭 websocket sse
+func (server *Server) drain(httpServer *http.Server) {
+	if server.Health != nil {
+		health.Default.SetDraining(true)
+	}
+	if server.DrainDelay > 0 {
+		time.Sleep(server.DrainDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), server.ShutdownTimeout)
+	defer cancel()
+
+	// 长连接不会自己结束  留出时间发送 close frame
+	closeLongLived := func() {
+		websocket.Shutdown()
+		sse.Shutdown()
+	}
+	timer := time.AfterFunc(server.ShutdownTimeout-server.ShutdownTimeout/10, closeLongLived)
+	defer timer.Stop()
+
+	if server.GRPC != nil {
+		server.stopGRPC(ctx)
+	}
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logrus.Error("Server Shutdown:", err, " active:", server.Active())
+	}
+
+	// websocket 已 hijack  Shutdown 不会等待
+	for websocket.Count() != 0 && ctx.Err() == nil {
+		time.Sleep(time.Millisecond * 100)
+	}
+	closeLongLived()
+}
+
 func (server *Server) Start() {
 
 	httpServer := server.GetHttpServer()
+
+	if server.Metrics != nil {
+		server.Metrics.startPools(server)
+	}
+
+	if server.Connections != nil && server.Connections.IdleWarning > 0 {
+		server.getServerHandler().conns.watch(server.Connections, server.Logger.Get())
+	}
+
+	// 多个实例只有一个执行  其他等待完成
+	if server.Migrate != nil && server.Migrate.Auto {
+		ctx, cancel := context.WithTimeout(context.Background(), server.Migrate.Timeout)
+		_, err := server.MigrateUp(ctx, 0, false)
+		cancel()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// 开发 测试环境的数据
+	if server.Seed != nil && server.Seed.enabled(server.ENV) {
+		ctx, cancel := context.WithTimeout(context.Background(), server.Seed.Timeout)
+		err := server.RunSeeds(ctx, nil)
+		cancel()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if server.Jobs != nil && !server.Jobs.Disabled {
+		server.Jobs.Get().Start()
+	}
+	if server.Scheduler != nil && !server.Scheduler.Disabled {
+		server.Scheduler.Get().Start()
+	}
+	if server.Outbox != nil {
+		server.Outbox.start(server)
+	}
+	if server.Streams != nil && !server.Streams.Disabled {
+		server.Streams.Get().Start()
+	}
+
+	// 模块在开始监听之前启动
+	if err := server.startModules(context.Background()); err != nil {
+		panic(err)
+	}
+
+	server.waitInitializers()
+
+	// 依赖已连接  预热完成前 readyz 返回 503
+	server.warmup()
+
 	// 执行
 	go func() {
 		var err error
-		if httpServer.TLSConfig == nil {
+		if len(server.Listeners) != 0 {
+			err = server.serveListeners(httpServer)
+		} else if server.GRPC != nil && httpServer.TLSConfig == nil {
+			err = server.serveMux(httpServer)
+		} else if server.Slowloris != nil || server.ProxyProtocol != nil {
+			err = server.serve(httpServer)
+		} else if httpServer.TLSConfig == nil {
 			err = httpServer.ListenAndServe()
 		} else {
 			err = httpServer.ListenAndServeTLS("", "")
@@ -223,21 +481,102 @@ func (server *Server) Start() {
 			panic(err)
 		}
 	}()
-
 	// Wait for interrupt signal to gracefully shutdown the server with
-	quit := make(chan os.Signal, 1)
+	quit := server.quitChan()
 	// kill (no param) default send syscanll.SIGTERM
 	// kill -2 is syscall.SIGINT
 	// kill -9 is syscall. SIGKILL but can"t be catch, so don't need add it
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	shutdownSignals, _ := parseSignals(server.Signals)
+	if !server.testing() {
+		signal.Notify(quit, append(shutdownSignals, syscall.SIGHUP, syscall.SIGQUIT)...)
+	}
+	for sig := range quit {
+		if containsSignal(shutdownSignals, sig) {
+			break
+		}
+		switch sig {
+		case syscall.SIGHUP:
+			server.Reload()
+		case syscall.SIGQUIT:
+			dumpStacks()
+		}
+	}
+	signal.Stop(quit)
 	logrus.Println("Shutdown Server ...")
-	//
+
+	server.drain(httpServer)
+	server.getServerHandler().conns.close()
+
 	ctx, cancel := context.WithTimeout(context.Background(), server.ShutdownTimeout)
 	defer cancel()
-	if err := httpServer.Shutdown(ctx); err != nil {
-		logrus.Error("Server Shutdown:", err)
+	if server.Scheduler != nil && !server.Scheduler.Disabled {
+		if err := server.Scheduler.Get().Stop(ctx); err != nil {
+			logrus.Error("Scheduler Stop:", err)
+		}
+	}
+	if server.Jobs != nil && !server.Jobs.Disabled {
+		if err := server.Jobs.Get().Stop(ctx); err != nil {
+			logrus.Error("Jobs Stop:", err)
+		}
+	}
+	if server.Streams != nil && !server.Streams.Disabled {
+		if err := server.Streams.Get().Stop(ctx); err != nil {
+			logrus.Error("Streams Stop:", err)
+		}
+	}
+	if server.Outbox != nil {
+		server.Outbox.Get().Stop()
+	}
+	server.stopModules(ctx)
+	if server.Mail != nil {
+		server.Mail.Get().Close()
+	}
+	if err := events.Default.Close(ctx); err != nil {
+		logrus.Error("Events Close:", err)
+	}
+	for _, val := range server.Handlers {
+		if val.Tracing != nil && val.Tracing != server.Tracing {
+			if err := val.Tracing.Get().Shutdown(ctx); err != nil {
+				logrus.Error("Tracing Shutdown:", err)
+			}
+		}
+	}
+	if server.Tracing != nil {
+		if err := server.Tracing.Get().Shutdown(ctx); err != nil {
+			logrus.Error("Tracing Shutdown:", err)
+		}
+	}
+	if server.Metrics != nil {
+		server.Metrics.close()
+	}
+	if server.Alerts != nil {
+		server.Alerts.Get().Stop()
+	}
+	for _, val := range server.CDN {
+		val.Get().Stop()
+	}
+	for _, val := range server.Handlers {
+		if val.Proxy != nil {
+			val.Proxy.close()
+		}
+	}
+	for _, val := range server.Handlers {
+		if val.Redis != nil && val.Redis != server.Redis {
+			val.Redis.Close()
+		}
+		if val.Mongo != nil && val.Mongo != server.Mongo {
+			val.Mongo.Close()
+		}
 	}
 
 	logrus.Println("Server exiting")
+
+	for _, val := range server.Handlers {
+		if val.Logger != nil && val.Logger != server.Logger {
+			val.Logger.close(time.Second * 5)
+		}
+	}
+
+	// mongo redis logger
+	server.stopBuiltins(ctx)
 }
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"time"
+)
+
+type (
+	Sessions struct {
+		// redis 或 cookie 或 memory
+		Store string `json:"store,omitempty"`
+
+		CookieName string        `json:"cookie_name,omitempty"`
+		Domain     string        `json:"domain,omitempty"`
+		Secure     bool          `json:"secure,omitempty"`
+		MaxAge     time.Duration `json:"max_age,omitempty"`
+
+		// cookie store 第一个签名 其余用于轮换验证
+		Keys    []string `json:"keys,omitempty"`
+		Encrypt bool     `json:"encrypt,omitempty"`
+	}
+)
+
+func (config *Sessions) init(server *Server, handler *Handler) {
+	if config.Store == "" {
+		config.Store = "redis"
+	}
+	if config.MaxAge == 0 {
+		config.MaxAge = time.Hour * 24 * 30
+	}
+	if config.Store == "cookie" && len(config.Keys) == 0 {
+		panic("Sessions: cookie store keys is empty")
+	}
+	if server != nil && len(server.Certificates) != 0 {
+		config.Secure = true
+	}
+}
+
+func (config *Sessions) keys() (keys [][]byte) {
+	for _, val := range config.Keys {
+		keys = append(keys, []byte(val))
+	}
+	return
+}
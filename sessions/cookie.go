@@ -0,0 +1,155 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/clock"
+)
+
+type (
+	// CookieStore 数据全部保存在 cookie 中 不需要 redis
+	CookieStore struct {
+		MaxAge  time.Duration
+		Encrypt bool
+		keys    []cookieKey
+	}
+
+	cookieKey struct {
+		sign    []byte
+		encrypt cipher.AEAD
+	}
+
+	cookiePayload struct {
+		Values    map[string]interface{} `json:"v"`
+		ExpiresAt int64                  `json:"e"`
+	}
+)
+
+// 浏览器 cookie 限制 4096
+const cookieMaxLength = 4000
+
+var ErrCookieTooLarge = errors.New("sessions: cookie too large")
+var ErrCookieInvalid = errors.New("sessions: cookie invalid")
+
+func NewCookieStore(keys [][]byte, encrypt bool, maxAge time.Duration) *CookieStore {
+	if len(keys) == 0 {
+		panic("sessions: cookie store keys is empty")
+	}
+	store := &CookieStore{
+		MaxAge:  maxAge,
+		Encrypt: encrypt,
+	}
+	for _, key := range keys {
+		signKey := sha256.Sum256(append([]byte("sign."), key...))
+		encryptKey := sha256.Sum256(append([]byte("encrypt."), key...))
+		block, err := aes.NewCipher(encryptKey[:])
+		if err != nil {
+			panic(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+		store.keys = append(store.keys, cookieKey{
+			sign:    signKey[:],
+			encrypt: aead,
+		})
+	}
+	return store
+}
+
+func (store *CookieStore) Load(ctx *gin.Context, value string) (id string, values map[string]interface{}, err error) {
+	index := strings.LastIndex(value, ".")
+	if index == -1 {
+		err = ErrCookieInvalid
+		return
+	}
+	var data []byte
+	if data, err = base64.RawURLEncoding.DecodeString(value[:index]); err != nil {
+		return
+	}
+
+	// 轮换 key 任意一个验证通过即可
+	var key *cookieKey
+	for i := range store.keys {
+		if hmac.Equal([]byte(value[index+1:]), []byte(store.sign(store.keys[i], value[:index]))) {
+			key = &store.keys[i]
+			break
+		}
+	}
+	if key == nil {
+		err = ErrCookieInvalid
+		return
+	}
+
+	if store.Encrypt {
+		size := key.encrypt.NonceSize()
+		if len(data) < size {
+			err = ErrCookieInvalid
+			return
+		}
+		if data, err = key.encrypt.Open(nil, data[:size], data[size:], nil); err != nil {
+			return
+		}
+	}
+
+	payload := &cookiePayload{}
+	if err = json.Unmarshal(data, payload); err != nil {
+		return
+	}
+	if payload.ExpiresAt != 0 && payload.ExpiresAt < clock.Now().Unix() {
+		return
+	}
+	values = payload.Values
+	return
+}
+
+func (store *CookieStore) Save(ctx *gin.Context, session *Session) (value string, err error) {
+	payload := &cookiePayload{
+		Values: session.Values,
+	}
+	if store.MaxAge > 0 {
+		payload.ExpiresAt = clock.Now().Add(store.MaxAge).Unix()
+	}
+	var data []byte
+	if data, err = json.Marshal(payload); err != nil {
+		return
+	}
+
+	key := store.keys[0]
+	if store.Encrypt {
+		nonce := make([]byte, key.encrypt.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			return
+		}
+		data = key.encrypt.Seal(nonce, nonce, data, nil)
+	}
+
+	value = base64.RawURLEncoding.EncodeToString(data)
+	value += "." + store.sign(key, value)
+	if len(value) > cookieMaxLength {
+		value = ""
+		err = ErrCookieTooLarge
+	}
+	return
+}
+
+func (store *CookieStore) Delete(ctx *gin.Context, session *Session) error {
+	return nil
+}
+
+func (store *CookieStore) sign(key cookieKey, value string) string {
+	mac := hmac.New(sha256.New, key.sign)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,86 @@
+package sessions
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/clock"
+)
+
+type (
+	// MemoryStore 单实例使用  redis 不可用时 RedisStore 的降级
+	MemoryStore struct {
+		MaxAge time.Duration
+
+		mutex    sync.Mutex
+		sessions map[string]*memorySession
+		cleaned  time.Time
+	}
+
+	memorySession struct {
+		data      []byte
+		expiresAt time.Time
+	}
+)
+
+func NewMemoryStore(maxAge time.Duration) *MemoryStore {
+	return &MemoryStore{
+		MaxAge:   maxAge,
+		sessions: map[string]*memorySession{},
+	}
+}
+
+func (store *MemoryStore) Load(ctx *gin.Context, value string) (id string, values map[string]interface{}, err error) {
+	store.mutex.Lock()
+	val, ok := store.sessions[value]
+	store.mutex.Unlock()
+	if !ok || clock.Now().After(val.expiresAt) {
+		return
+	}
+	if err = json.Unmarshal(val.data, &values); err != nil {
+		values = nil
+		return
+	}
+	id = value
+	return
+}
+
+func (store *MemoryStore) Save(ctx *gin.Context, session *Session) (value string, err error) {
+	if session.ID == "" {
+		session.ID = randomID()
+	}
+	var data []byte
+	if data, err = json.Marshal(session.Values); err != nil {
+		return
+	}
+	now := clock.Now()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.sessions == nil {
+		store.sessions = map[string]*memorySession{}
+	}
+	// 每分钟最多清理一次过期的
+	if now.Sub(store.cleaned) > time.Minute {
+		store.cleaned = now
+		for key, val := range store.sessions {
+			if now.After(val.expiresAt) {
+				delete(store.sessions, key)
+			}
+		}
+	}
+	store.sessions[session.ID] = &memorySession{
+		data:      data,
+		expiresAt: now.Add(store.MaxAge),
+	}
+	value = session.ID
+	return
+}
+
+func (store *MemoryStore) Delete(ctx *gin.Context, session *Session) error {
+	store.mutex.Lock()
+	delete(store.sessions, session.ID)
+	store.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,94 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+)
+
+type (
+	RedisStore struct {
+		Prefix string
+		MaxAge time.Duration
+
+		// redis 不可用或者没有 redis 中间件时使用  为空时返回错误
+		Fallback Store
+	}
+)
+
+var ErrRedis = errors.New("sessions: redis middleware is required")
+
+func (store *RedisStore) key(ctx *gin.Context, id string) string {
+	prefix := store.Prefix
+	if prefix == "" {
+		prefix = "session"
+	}
+	return redisMiddleware.Key(ctx, prefix+"."+id)
+}
+
+func (store *RedisStore) Load(ctx *gin.Context, value string) (id string, values map[string]interface{}, err error) {
+	if store.degraded(ctx) {
+		return store.Fallback.Load(ctx, value)
+	}
+	if !redisMiddleware.Available(ctx) {
+		err = ErrRedis
+		return
+	}
+	client := ctx.MustGet(redisMiddleware.CONTEXT).(*redis.Client)
+	var data []byte
+	if data, err = client.Get(store.key(ctx, value)).Bytes(); err != nil {
+		if err == redis.Nil {
+			err = nil
+		}
+		return
+	}
+	if err = json.Unmarshal(data, &values); err != nil {
+		values = nil
+		return
+	}
+	id = value
+	return
+}
+
+func (store *RedisStore) Save(ctx *gin.Context, session *Session) (value string, err error) {
+	if store.degraded(ctx) {
+		return store.Fallback.Save(ctx, session)
+	}
+	if !redisMiddleware.Available(ctx) {
+		err = ErrRedis
+		return
+	}
+	if session.ID == "" {
+		session.ID = randomID()
+	}
+	var data []byte
+	if data, err = json.Marshal(session.Values); err != nil {
+		return
+	}
+	client := ctx.MustGet(redisMiddleware.CONTEXT).(*redis.Client)
+	if err = client.Set(store.key(ctx, session.ID), data, store.MaxAge).Err(); err != nil {
+		return
+	}
+	value = session.ID
+	return
+}
+
+func (store *RedisStore) Delete(ctx *gin.Context, session *Session) error {
+	if store.degraded(ctx) {
+		return store.Fallback.Delete(ctx, session)
+	}
+	if !redisMiddleware.Available(ctx) {
+		return ErrRedis
+	}
+	client := ctx.MustGet(redisMiddleware.CONTEXT).(*redis.Client)
+	return client.Del(store.key(ctx, session.ID)).Err()
+}
+
+// degraded redis 不可用或者没有 redis 中间件时使用 Fallback
+func (store *RedisStore) degraded(ctx *gin.Context) bool {
+	return store.Fallback != nil && (redisMiddleware.Degraded(ctx) || !redisMiddleware.Available(ctx))
+}
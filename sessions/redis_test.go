@@ -0,0 +1,38 @@
+package sessions
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRedisStoreWithoutRedis(t *testing.T) {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	session := &Session{Values: map[string]interface{}{"a": "b"}}
+
+	store := &RedisStore{MaxAge: time.Hour}
+	if _, err := store.Save(ctx, session); err != ErrRedis {
+		t.Fatalf("Save without fallback: %v", err)
+	}
+	if _, _, err := store.Load(ctx, "id"); err != ErrRedis {
+		t.Fatalf("Load without fallback: %v", err)
+	}
+	if err := store.Delete(ctx, session); err != ErrRedis {
+		t.Fatalf("Delete without fallback: %v", err)
+	}
+
+	store.Fallback = NewMemoryStore(time.Hour)
+	value, err := store.Save(ctx, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, values, err := store.Load(ctx, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["a"] != "b" {
+		t.Fatalf("values %v", values)
+	}
+}
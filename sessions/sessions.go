@@ -0,0 +1,243 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	Config struct {
+		// redis 或 cookie 或 memory
+		Store string
+		// 自定义 store 优先
+		Custom Store
+
+		CookieName string
+		Path       string
+		Domain     string
+		Secure     bool
+		MaxAge     time.Duration
+		SameSite   http.SameSite
+
+		// cookie store 签名 第一个用于签名 全部用于验证
+		Keys [][]byte
+		// cookie store 是否加密
+		Encrypt bool
+	}
+
+	Store interface {
+		Load(ctx *gin.Context, value string) (id string, values map[string]interface{}, err error)
+		Save(ctx *gin.Context, session *Session) (value string, err error)
+		Delete(ctx *gin.Context, session *Session) error
+	}
+
+	Session struct {
+		ID     string
+		Values map[string]interface{}
+
+		changed   bool
+		destroyed bool
+		renewed   bool
+		old       *Session
+	}
+
+	sessionWriter struct {
+		gin.ResponseWriter
+		save func()
+	}
+)
+
+var CONTEXT = "GIN.SERVER.SESSIONS"
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.CookieName == "" {
+		c.CookieName = "session"
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = time.Hour * 24 * 30
+	}
+	if c.SameSite == 0 {
+		c.SameSite = http.SameSiteLaxMode
+	}
+
+	store := c.Custom
+	if store == nil {
+		switch c.Store {
+		case "", "redis":
+			store = &RedisStore{
+				MaxAge:   c.MaxAge,
+				Fallback: NewMemoryStore(c.MaxAge),
+			}
+		case "cookie":
+			store = NewCookieStore(c.Keys, c.Encrypt, c.MaxAge)
+		case "memory":
+			store = NewMemoryStore(c.MaxAge)
+		default:
+			panic("sessions: unknown store " + c.Store)
+		}
+	}
+
+	return func(ctx *gin.Context) {
+		session := &Session{}
+		if value, _ := ctx.Cookie(c.CookieName); value != "" {
+			if id, values, err := store.Load(ctx, value); err == nil && values != nil {
+				session.ID = id
+				session.Values = values
+			}
+		}
+		if session.Values == nil {
+			session.Values = map[string]interface{}{}
+		}
+		ctx.Set(CONTEXT, session)
+
+		saved := false
+		save := func() {
+			if saved {
+				return
+			}
+			saved = true
+			c.save(ctx, store, session)
+		}
+
+		ctx.Writer = &sessionWriter{
+			ResponseWriter: ctx.Writer,
+			save:           save,
+		}
+		ctx.Next()
+
+		if !ctx.Writer.Written() {
+			save()
+		}
+	}
+}
+
+func Get(ctx *gin.Context) *Session {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Session)
+	}
+	return nil
+}
+
+func (c Config) save(ctx *gin.Context, store Store, session *Session) {
+	if session.old != nil && session.old.ID != "" {
+		if err := store.Delete(ctx, session.old); err != nil {
+			ctx.Error(err)
+		}
+	}
+
+	if session.destroyed {
+		if session.ID != "" {
+			if err := store.Delete(ctx, session); err != nil {
+				ctx.Error(err)
+			}
+		}
+		c.setCookie(ctx, "", -1)
+		return
+	}
+	if !session.changed {
+		return
+	}
+
+	value, err := store.Save(ctx, session)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	c.setCookie(ctx, value, int(c.MaxAge/time.Second))
+}
+
+func (c Config) setCookie(ctx *gin.Context, value string, maxAge int) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     c.CookieName,
+		Value:    value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		MaxAge:   maxAge,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	})
+}
+
+func (session *Session) Get(key string) interface{} {
+	return session.Values[key]
+}
+
+func (session *Session) GetString(key string) (value string) {
+	value, _ = session.Values[key].(string)
+	return
+}
+
+func (session *Session) Set(key string, value interface{}) {
+	session.Values[key] = value
+	session.changed = true
+}
+
+func (session *Session) Delete(key string) {
+	if _, ok := session.Values[key]; ok {
+		delete(session.Values, key)
+		session.changed = true
+	}
+}
+
+func (session *Session) Clear() {
+	session.Values = map[string]interface{}{}
+	session.changed = true
+}
+
+// Destroy 删除 session 和 cookie
+func (session *Session) Destroy() {
+	session.Values = map[string]interface{}{}
+	session.destroyed = true
+}
+
+// Renew 登录后更换 id 防止 session fixation
+func (session *Session) Renew() {
+	if !session.renewed && session.ID != "" {
+		session.old = &Session{ID: session.ID}
+	}
+	session.ID = ""
+	session.renewed = true
+	session.destroyed = false
+	session.changed = true
+}
+
+func (writer *sessionWriter) WriteHeader(code int) {
+	writer.save()
+	writer.ResponseWriter.WriteHeader(code)
+}
+
+func (writer *sessionWriter) WriteHeaderNow() {
+	writer.save()
+	writer.ResponseWriter.WriteHeaderNow()
+}
+
+func (writer *sessionWriter) Write(data []byte) (int, error) {
+	writer.save()
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *sessionWriter) WriteString(s string) (int, error) {
+	writer.save()
+	return writer.ResponseWriter.WriteString(s)
+}
+
+func (writer *sessionWriter) Flush() {
+	writer.save()
+	writer.ResponseWriter.Flush()
+}
+
+func randomID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
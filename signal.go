@@ -0,0 +1,134 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+var signals = map[string]os.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGHUP":  syscall.SIGHUP,
+}
+
+// parseSignals 允许省略 SIG 前缀
+func parseSignals(names []string) (list []os.Signal, err error) {
+	for _, name := range names {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if !strings.HasPrefix(name, "SIG") {
+			name = "SIG" + name
+		}
+		sig, ok := signals[name]
+		if !ok {
+			return nil, errors.New("Server: unknown signal " + name)
+		}
+		list = append(list, sig)
+	}
+	return
+}
+
+func containsSignal(list []os.Signal, sig os.Signal) bool {
+	for _, val := range list {
+		if val == sig {
+			return true
+		}
+	}
+	return false
+}
+
+// OnReload SIGHUP 或 Reload 时执行
+func (server *Server) OnReload(fn func() error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.reloads = append(server.reloads, fn)
+}
+
+// Reload 重新打开日志 重新读取证书 执行 OnReload
+func (server *Server) Reload() (err error) {
+	logrus.Println("Reload Server ...")
+	if e := server.Logger.reopen(); e != nil {
+		err = e
+		logrus.Error("Logger reopen:", e)
+	}
+	for _, val := range server.Handlers {
+		if val.Logger != nil && val.Logger != server.Logger {
+			if e := val.Logger.reopen(); e != nil {
+				err = e
+				logrus.Error("Logger reopen:", e)
+			}
+		}
+	}
+
+	if server.tlsConfig.Load() != nil {
+		if configs, e := server.loadTLSConfigs(server.Certificates, server.TLSPolicies); e != nil {
+			err = e
+			logrus.Error("TLS reload:", e)
+		} else {
+			server.tlsConfig.Store(configs)
+		}
+	}
+	for _, val := range server.Listeners {
+		if e := val.reloadTLS(server); e != nil {
+			err = e
+			logrus.Error("TLS reload "+val.Addr+":", e)
+		}
+	}
+
+	server.mutex.Lock()
+	reloads := append([]func() error(nil), server.reloads...)
+	server.mutex.Unlock()
+	for _, fn := range reloads {
+		if e := fn(); e != nil {
+			err = e
+			logrus.Error("Reload:", e)
+		}
+	}
+	return
+}
+
+// dumpStacks SIGQUIT 输出所有 goroutine  不退出
+func dumpStacks() {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+	logrus.Warnf("SIGQUIT goroutines %d\n%s", runtime.NumGoroutine(), buf)
+}
+
+func (server *Server) getTLSConfig(list []Certificate) (*tls.Config, error) {
+	var certificates []tls.Certificate
+	for _, val := range list {
+		certificate, err := val.load()
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:               tls.VersionTLS10,
+		Certificates:             certificates,
+		PreferServerCipherSuites: true,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		},
+	}
+	tlsConfig.BuildNameToCertificate()
+	return tlsConfig, nil
+}
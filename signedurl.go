@@ -0,0 +1,43 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/signedurl"
+)
+
+type (
+	// SignedURL 带签名和过期时间的链接  例如私有下载 邮件确认
+	SignedURL struct {
+		// 第一个签名 其余用于轮换验证  为空时使用 sessions 的 keys
+		Keys []string `json:"keys,omitempty"`
+
+		// 默认有效期
+		TTL time.Duration `json:"ttl,omitempty"`
+
+		// 必须有签名的路由名 type.action 或 type
+		Routes []string `json:"routes,omitempty"`
+	}
+)
+
+func (config *SignedURL) init(server *Server, handler *Handler) {
+	if config.TTL == 0 {
+		config.TTL = time.Hour * 24
+	}
+}
+
+func (config *SignedURL) middleware(sessions *Sessions) gin.HandlerFunc {
+	keys := config.Keys
+	if len(keys) == 0 && sessions != nil {
+		keys = sessions.Keys
+	}
+	c := signedurl.Config{
+		TTL:    config.TTL,
+		Routes: config.Routes,
+	}
+	for _, val := range keys {
+		c.Keys = append(c.Keys, []byte(val))
+	}
+	return signedurl.Middleware(signedurl.New(c))
+}
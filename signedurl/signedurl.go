@@ -0,0 +1,220 @@
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	Config struct {
+		// 第一个签名  其余用于轮换验证
+		Keys [][]byte
+
+		// 默认有效期
+		TTL time.Duration
+
+		// 需要签名的路由名 type.action 或 type
+		Routes []string
+
+		// 查询参数名  默认 signature expires
+		SignatureParam string
+		ExpiresParam   string
+	}
+
+	Signer struct {
+		config Config
+		routes map[string]bool
+	}
+)
+
+var CONTEXT = "GIN.SERVER.SIGNEDURL"
+
+// CONTEXT_VERIFIED 当前请求的签名已验证
+var CONTEXT_VERIFIED = "GIN.SERVER.SIGNEDURL.VERIFIED"
+
+var (
+	ErrInvalid = &errs.Error{
+		Message:    "Invalid signature",
+		Type:       "signed_url",
+		StatusCode: http.StatusForbidden,
+	}
+	ErrExpired = &errs.Error{
+		Message:    "Link has expired",
+		Type:       "signed_url",
+		StatusCode: http.StatusForbidden,
+		Params: map[string]interface{}{
+			"expired": true,
+		},
+	}
+
+	ErrNoSigner = errors.New("Signed URL: middleware is not used")
+)
+
+func New(c Config) *Signer {
+	if len(c.Keys) == 0 {
+		panic("Signed URL: keys is empty")
+	}
+	if c.TTL == 0 {
+		c.TTL = time.Hour * 24
+	}
+	if c.SignatureParam == "" {
+		c.SignatureParam = "signature"
+	}
+	if c.ExpiresParam == "" {
+		c.ExpiresParam = "expires"
+	}
+	routes := map[string]bool{}
+	for _, name := range c.Routes {
+		routes[name] = true
+	}
+	return &Signer{
+		config: c,
+		routes: routes,
+	}
+}
+
+// Middleware 有签名时验证  Routes 中的路由必须有签名
+func Middleware(signer *Signer) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, signer)
+		if ctx.Query(signer.config.SignatureParam) != "" {
+			if err := signer.Verify(ctx.Request.URL); err != nil {
+				ctx.Error(err)
+				ctx.Abort()
+				return
+			}
+			ctx.Set(CONTEXT_VERIFIED, true)
+			ctx.Next()
+			return
+		}
+		if len(signer.routes) == 0 {
+			ctx.Next()
+			return
+		}
+		val, ok := ctx.Get(ginResource.CONTEXT)
+		if !ok || val == nil {
+			ctx.Next()
+			return
+		}
+		resource := val.(*ginResource.Resource)
+		if signer.routes[resource.Name()] || signer.routes[resource.Type] {
+			ctx.Error(ErrInvalid)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// Required 路由上使用  在 Middleware 之后
+func Required() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !Verified(ctx) {
+			ctx.Error(ErrInvalid)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Signer {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Signer)
+	}
+	return nil
+}
+
+func Verified(ctx *gin.Context) bool {
+	return ctx.GetBool(CONTEXT_VERIFIED)
+}
+
+// URLFor 反向路由并签名  ttl 为 0 时使用默认有效期
+func URLFor(ctx *gin.Context, ttl time.Duration, name string, params ...interface{}) (string, error) {
+	signer := Get(ctx)
+	if signer == nil {
+		return "", ErrNoSigner
+	}
+	rawURL, err := ginResource.URLFor(ctx, name, params...)
+	if err != nil {
+		return "", err
+	}
+	return signer.Sign(rawURL, ttl)
+}
+
+// Sign 可以是完整的 url 或只有路径  已有的查询参数也会签名
+func Sign(ctx *gin.Context, rawURL string, ttl time.Duration) (string, error) {
+	signer := Get(ctx)
+	if signer == nil {
+		return "", ErrNoSigner
+	}
+	return signer.Sign(rawURL, ttl)
+}
+
+func (signer *Signer) Sign(rawURL string, ttl time.Duration) (string, error) {
+	if ttl == 0 {
+		ttl = signer.config.TTL
+	}
+	return signer.SignExpires(rawURL, time.Now().Add(ttl))
+}
+
+// SignExpires 签名的是路径和查询参数  scheme host 不签名  经过反向代理也有效
+func (signer *Signer) SignExpires(rawURL string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Del(signer.config.SignatureParam)
+	query.Set(signer.config.ExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(signer.config.SignatureParam, signer.sign(signer.config.Keys[0], u.EscapedPath(), query))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Verify 签名错误 ErrInvalid  过期 ErrExpired
+func (signer *Signer) Verify(u *url.URL) error {
+	query := u.Query()
+	signature := query.Get(signer.config.SignatureParam)
+	if signature == "" {
+		return ErrInvalid
+	}
+	expires, err := strconv.ParseInt(query.Get(signer.config.ExpiresParam), 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	query.Del(signer.config.SignatureParam)
+
+	valid := false
+	for _, key := range signer.config.Keys {
+		if hmac.Equal([]byte(signature), []byte(signer.sign(key, u.EscapedPath(), query))) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return ErrInvalid
+	}
+	// 签名正确后再判断过期
+	if time.Now().Unix() > expires {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (signer *Signer) sign(key []byte, path string, query url.Values) string {
+	mac := hmac.New(sha256.New, key)
+	// Encode 按 key 排序
+	mac.Write([]byte(path + "?" + query.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
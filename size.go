@@ -0,0 +1,41 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/size"
+)
+
+type (
+	Size struct {
+		Limit int64 `json:"limit,omitempty"`
+
+		// 路由名 type.action 或 type  例如上传  0 不限制
+		Routes map[string]int64 `json:"routes,omitempty"`
+
+		// 请求体超过后写入临时文件  0 不缓冲
+		Memory  int64  `json:"memory,omitempty"`
+		TempDir string `json:"temp_dir,omitempty"`
+
+		// 响应内容限制  0 不限制
+		ResponseLimit int64 `json:"response_limit,omitempty"`
+
+		// 路由名 type.action 或 type  0 不限制
+		ResponseRoutes map[string]int64 `json:"response_routes,omitempty"`
+	}
+)
+
+func (config *Size) init(server *Server, handler *Handler) {
+	if config.Limit == 0 {
+		config.Limit = 1024 * 512
+	}
+}
+
+// middleware 请求体大小
+func (config *Size) middleware() gin.HandlerFunc {
+	return size.RequestMiddleware(size.RequestConfig{
+		Limit:   config.Limit,
+		Routes:  config.Routes,
+		Memory:  config.Memory,
+		TempDir: config.TempDir,
+	})
+}
@@ -0,0 +1,136 @@
+package size
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	RequestConfig struct {
+		// 默认限制
+		Limit int64
+
+		// 路由名 type.action 或 type  例如上传  0 不限制
+		Routes map[string]int64
+
+		// 超过后写入临时文件  0 不缓冲  直接读取请求体
+		Memory int64
+
+		// 临时文件目录  默认 os.TempDir()
+		TempDir string
+	}
+
+	// spillFile 请求结束后删除
+	spillFile struct {
+		*os.File
+	}
+)
+
+var (
+	ErrRequestTooLarge = &errs.Error{
+		Message:    "Request is too large",
+		Type:       "size",
+		StatusCode: http.StatusRequestEntityTooLarge,
+	}
+	ErrRequestBody = &errs.Error{
+		Message:    "Request body read error",
+		Type:       "size",
+		StatusCode: http.StatusBadRequest,
+	}
+)
+
+// RequestMiddleware 按路由限制请求体  超过 Memory 的先完整读取到临时文件  handler 读取时不占用内存
+func RequestMiddleware(c RequestConfig) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		limit := c.Limit
+		if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+			resource := val.(*ginResource.Resource)
+			if val, ok := c.Routes[resource.Name()]; ok {
+				limit = val
+			} else if val, ok := c.Routes[resource.Type]; ok {
+				limit = val
+			}
+		}
+
+		req := ctx.Request
+		if limit > 0 && req.ContentLength > limit {
+			ctx.Header("Connection", "close")
+			ctx.Error(ErrRequestTooLarge)
+			ctx.Abort()
+			return
+		}
+		if c.Memory <= 0 || req.Body == nil || req.Body == http.NoBody || (req.ContentLength >= 0 && req.ContentLength <= c.Memory) {
+			if limit > 0 {
+				req.Body = &Reader{
+					ctx:       ctx,
+					rdr:       req.Body,
+					Remaining: limit,
+				}
+			}
+			ctx.Next()
+			return
+		}
+
+		body, length, err := spill(req.Body, limit, c.Memory, c.TempDir)
+		if err != nil {
+			if err == ErrRequestTooLarge {
+				ctx.Header("Connection", "close")
+			}
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		defer body.Close()
+		req.Body.Close()
+		req.Body = body
+		req.ContentLength = length
+		ctx.Next()
+	}
+}
+
+// spill 不超过 memory 时留在内存
+func spill(body io.Reader, limit int64, memory int64, dir string) (io.ReadCloser, int64, error) {
+	if limit > 0 {
+		body = io.LimitReader(body, limit+1)
+	}
+	buf := &bytes.Buffer{}
+	n, err := io.CopyN(buf, body, memory+1)
+	if err == io.EOF {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), n, nil
+	}
+	if err != nil {
+		return nil, 0, ErrRequestBody
+	}
+
+	file, err := ioutil.TempFile(dir, "gin-body-")
+	if err != nil {
+		return nil, 0, err
+	}
+	f := &spillFile{File: file}
+	if n, err = io.Copy(file, io.MultiReader(buf, body)); err != nil {
+		f.Close()
+		return nil, 0, ErrRequestBody
+	}
+	if limit > 0 && n > limit {
+		f.Close()
+		return nil, 0, ErrRequestTooLarge
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, n, nil
+}
+
+func (f *spillFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.File.Name())
+	return err
+}
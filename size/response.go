@@ -0,0 +1,108 @@
+package size
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	ResponseConfig struct {
+		// 默认限制  0 不限制
+		Limit int64
+
+		// 路由名 type.action 或 type  0 不限制
+		Routes map[string]int64
+
+		Logger *logrus.Logger
+	}
+
+	responseWriter struct {
+		gin.ResponseWriter
+		ctx      *gin.Context
+		config   *ResponseConfig
+		limit    int64
+		size     int64
+		exceeded bool
+	}
+)
+
+var ErrResponseTooLarge = &errs.Error{
+	Message:    "Response is too large",
+	Type:       "size",
+	StatusCode: http.StatusInternalServerError,
+}
+
+// ResponseMiddleware 响应内容超过限制时  未输出返回 500  已经输出中断连接
+func ResponseMiddleware(c ResponseConfig) gin.HandlerFunc {
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	return func(ctx *gin.Context) {
+		limit := c.Limit
+		if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+			resource := val.(*ginResource.Resource)
+			if val, ok := c.Routes[resource.Name()]; ok {
+				limit = val
+			} else if val, ok := c.Routes[resource.Type]; ok {
+				limit = val
+			}
+		}
+		if limit <= 0 {
+			ctx.Next()
+			return
+		}
+
+		writer := &responseWriter{
+			ResponseWriter: ctx.Writer,
+			ctx:            ctx,
+			config:         &c,
+			limit:          limit,
+		}
+		ctx.Writer = writer
+		ctx.Next()
+		// errs 中间件输出错误不受限制
+		ctx.Writer = writer.ResponseWriter
+	}
+}
+
+func (w *responseWriter) WriteString(data string) (int, error) {
+	return w.Write([]byte(data))
+}
+
+func (w *responseWriter) Write(data []byte) (n int, err error) {
+	if w.exceeded {
+		return 0, ErrResponseTooLarge
+	}
+	if w.size+int64(len(data)) > w.limit {
+		w.exceed(int64(len(data)))
+		return 0, ErrResponseTooLarge
+	}
+	n, err = w.ResponseWriter.Write(data)
+	w.size += int64(n)
+	return
+}
+
+func (w *responseWriter) exceed(length int64) {
+	w.exceeded = true
+	written := w.ResponseWriter.Written()
+	w.config.Logger.WithFields(logrus.Fields{
+		"method":  w.ctx.Request.Method,
+		"path":    w.ctx.Request.URL.Path,
+		"route":   ginResource.RoutePath(w.ctx),
+		"limit":   w.limit,
+		"size":    w.size + length,
+		"written": written,
+	}).Error("Response is too large")
+
+	if !written {
+		w.ctx.Error(ErrResponseTooLarge)
+		w.ctx.Abort()
+		return
+	}
+	// 已经输出响应头  中断连接 避免客户端把截断的内容当成完整的
+	panic(http.ErrAbortHandler)
+}
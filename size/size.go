@@ -21,8 +21,11 @@ func (mbr *Reader) tooLarge() (n int, err error) {
 	if !mbr.wasAborted {
 		mbr.wasAborted = true
 		ctx := mbr.ctx
-		ctx.Header("connection", "close")
-		ctx.Status(http.StatusRequestEntityTooLarge)
+		// 流式输出 已经写入响应头
+		if !ctx.Writer.Written() {
+			ctx.Header("connection", "close")
+			ctx.Status(http.StatusRequestEntityTooLarge)
+		}
 	}
 	return
 }
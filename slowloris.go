@@ -0,0 +1,344 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// Slowloris 限制读取请求头 请求体的最低速度  超过 ReadHeaderTimeout ReadTimeout 之外的保护
+	Slowloris struct {
+		// 最低速度 bytes/s
+		MinRate int64 `json:"min_rate,omitempty"`
+
+		// 开始计算速度之前的时间  包括 TLS 握手
+		Grace time.Duration `json:"grace,omitempty"`
+
+		// 太慢的 IP 拒绝连接的时间  0 不拒绝
+		Ban time.Duration `json:"ban,omitempty"`
+
+		// 太慢时调用  例如加入防火墙
+		OnSlow func(ip string) `json:"-"`
+	}
+
+	slowListener struct {
+		config *Slowloris
+		logger *logrus.Logger
+		mutex  sync.Mutex
+		conns  map[string]*slowConn
+		bans   map[string]time.Time
+	}
+
+	// slowAccept 每个监听地址一个  共用 slowListener 的连接和 ban
+	slowAccept struct {
+		net.Listener
+		slow *slowListener
+	}
+
+	// slowConn 读取请求头 请求体时检查速度  处理请求 和 keep-alive 空闲时不检查
+	slowConn struct {
+		net.Conn
+		listener *slowListener
+		mutex    sync.Mutex
+		phase    int
+		disabled bool
+		start    time.Time
+		bytes    int64
+		deadline time.Time
+	}
+
+	slowBody struct {
+		io.ReadCloser
+		conn *slowConn
+	}
+)
+
+const (
+	slowOff = iota
+	// keep-alive 空闲  收到数据后开始检查
+	slowWaiting
+	slowReading
+)
+
+func (config *Slowloris) init(server *Server, handler *Handler) {
+	if config.MinRate == 0 {
+		config.MinRate = 512
+	}
+	if config.Grace == 0 {
+		config.Grace = time.Second * 10
+	}
+}
+
+func newSlowListener(config *Slowloris, logger *logrus.Logger) *slowListener {
+	return &slowListener{
+		config: config,
+		logger: logger,
+		conns:  map[string]*slowConn{},
+		bans:   map[string]time.Time{},
+	}
+}
+
+func (listener *slowListener) wrap(l net.Listener) net.Listener {
+	return &slowAccept{
+		Listener: l,
+		slow:     listener,
+	}
+}
+
+func (accept *slowAccept) Accept() (net.Conn, error) {
+	listener := accept.slow
+	for {
+		conn, err := accept.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if listener.banned(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+		// 和 ListenAndServe 相同
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetKeepAlive(true)
+			tcpConn.SetKeepAlivePeriod(time.Minute * 3)
+		}
+		c := &slowConn{
+			Conn:     conn,
+			listener: listener,
+			phase:    slowReading,
+			start:    time.Now(),
+		}
+		listener.mutex.Lock()
+		listener.conns[conn.RemoteAddr().String()] = c
+		listener.mutex.Unlock()
+		return c, nil
+	}
+}
+
+func (listener *slowListener) banned(addr net.Addr) bool {
+	if listener.config.Ban <= 0 {
+		return false
+	}
+	ip := remoteIP(addr.String())
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	until, ok := listener.bans[ip]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(listener.bans, ip)
+		return false
+	}
+	return true
+}
+
+func (listener *slowListener) get(remoteAddr string) *slowConn {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	return listener.conns[remoteAddr]
+}
+
+// connState Active 时请求头已经读取完  Idle 等待下一个请求
+func (listener *slowListener) connState(conn net.Conn, state http.ConnState) {
+	key := conn.RemoteAddr().String()
+	switch state {
+	case http.StateHijacked, http.StateClosed:
+		listener.mutex.Lock()
+		c := listener.conns[key]
+		delete(listener.conns, key)
+		listener.mutex.Unlock()
+		if c != nil {
+			c.disable()
+		}
+	case http.StateActive:
+		if c := listener.get(key); c != nil {
+			c.setPhase(slowOff)
+		}
+	case http.StateIdle:
+		if c := listener.get(key); c != nil {
+			c.setPhase(slowWaiting)
+		}
+	}
+}
+
+// request 有请求体时开始检查  http2 多路复用不检查
+func (listener *slowListener) request(req *http.Request) {
+	conn := listener.get(req.RemoteAddr)
+	if conn == nil {
+		return
+	}
+	if req.ProtoMajor != 1 {
+		conn.disable()
+		return
+	}
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0 {
+		return
+	}
+	conn.setPhase(slowReading)
+	req.Body = &slowBody{ReadCloser: req.Body, conn: conn}
+}
+
+func (listener *slowListener) slow(conn *slowConn, bytes int64, duration time.Duration) {
+	ip := remoteIP(conn.RemoteAddr().String())
+	listener.logger.WithFields(logrus.Fields{
+		"remote_addr": conn.RemoteAddr().String(),
+		"bytes":       bytes,
+		"duration":    duration.String(),
+	}).Warn("Slow connection closed")
+
+	if listener.config.Ban > 0 {
+		now := time.Now()
+		listener.mutex.Lock()
+		for key, until := range listener.bans {
+			if now.After(until) {
+				delete(listener.bans, key)
+			}
+		}
+		listener.bans[ip] = now.Add(listener.config.Ban)
+		listener.mutex.Unlock()
+	}
+	if listener.config.OnSlow != nil {
+		listener.config.OnSlow(ip)
+	}
+}
+
+func (conn *slowConn) Read(p []byte) (n int, err error) {
+	conn.mutex.Lock()
+	reading := conn.phase == slowReading
+	var limit time.Time
+	if reading {
+		limit = conn.limit()
+		deadline := limit
+		if !conn.deadline.IsZero() && conn.deadline.Before(deadline) {
+			deadline = conn.deadline
+		}
+		conn.Conn.SetReadDeadline(deadline)
+	}
+	conn.mutex.Unlock()
+
+	n, err = conn.Conn.Read(p)
+
+	conn.mutex.Lock()
+	switch conn.phase {
+	case slowReading:
+		conn.bytes += int64(n)
+	case slowWaiting:
+		// 下一个请求开始
+		if n > 0 {
+			conn.phase = slowReading
+			conn.start = time.Now()
+			conn.bytes = int64(n)
+		}
+	}
+	slow := reading && conn.phase == slowReading && err != nil && isTimeout(err) && !time.Now().Before(limit) && (conn.deadline.IsZero() || conn.deadline.After(limit))
+	bytes, duration := conn.bytes, time.Since(conn.start)
+	conn.mutex.Unlock()
+
+	if slow {
+		conn.listener.slow(conn, bytes, duration)
+		conn.Conn.Close()
+	}
+	return
+}
+
+func (conn *slowConn) SetDeadline(t time.Time) error {
+	conn.mutex.Lock()
+	conn.deadline = t
+	conn.mutex.Unlock()
+	return conn.Conn.SetDeadline(t)
+}
+
+func (conn *slowConn) SetReadDeadline(t time.Time) error {
+	conn.mutex.Lock()
+	conn.deadline = t
+	conn.mutex.Unlock()
+	return conn.Conn.SetReadDeadline(t)
+}
+
+// limit 按最低速度 下一个字节最晚的时间
+func (conn *slowConn) limit() time.Time {
+	rate := conn.listener.config.MinRate
+	return conn.start.Add(conn.listener.config.Grace + time.Duration((conn.bytes+1)*int64(time.Second)/rate))
+}
+
+func (conn *slowConn) setPhase(phase int) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.disabled {
+		return
+	}
+	// 恢复 net/http 设置的 deadline
+	if conn.phase == slowReading && phase != slowReading {
+		conn.Conn.SetReadDeadline(conn.deadline)
+	}
+	conn.phase = phase
+	if phase == slowReading {
+		conn.start = time.Now()
+		conn.bytes = 0
+	}
+}
+
+func (conn *slowConn) disable() {
+	conn.setPhase(slowOff)
+	conn.mutex.Lock()
+	conn.disabled = true
+	conn.mutex.Unlock()
+}
+
+func (body *slowBody) Read(p []byte) (n int, err error) {
+	n, err = body.ReadCloser.Read(p)
+	if err != nil {
+		body.conn.setPhase(slowOff)
+	}
+	return
+}
+
+func (body *slowBody) Close() error {
+	body.conn.setPhase(slowOff)
+	return body.ReadCloser.Close()
+}
+
+func isTimeout(err error) bool {
+	if err, ok := err.(net.Error); ok {
+		return err.Timeout()
+	}
+	return false
+}
+
+func remoteIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// serve 使用 ProxyProtocol slowListener 代替 ListenAndServe ListenAndServeTLS
+func (server *Server) serve(httpServer *http.Server) (err error) {
+	addr := httpServer.Addr
+	if addr == "" {
+		if httpServer.TLSConfig == nil {
+			addr = ":http"
+		} else {
+			addr = ":https"
+		}
+	}
+	var listener net.Listener
+	if listener, err = net.Listen("tcp", addr); err != nil {
+		return
+	}
+	if server.ProxyProtocol != nil {
+		listener = server.ProxyProtocol.wrap(listener)
+	}
+	if slow := server.getServerHandler().slow; slow != nil {
+		listener = slow.wrap(listener)
+	}
+	if httpServer.TLSConfig == nil {
+		return httpServer.Serve(listener)
+	}
+	return httpServer.ServeTLS(listener, "", "")
+}
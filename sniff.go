@@ -0,0 +1,38 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/sniff"
+)
+
+type (
+	// Sniff 按内容检查 multipart 上传的文件类型
+	Sniff struct {
+		// 支持 image/*  为空只拒绝 Dangerous
+		Types []string `json:"types,omitempty"`
+
+		// 路由名 type.action 或 type
+		Routes map[string][]string `json:"routes,omitempty"`
+
+		// 不检查的路由名  例如使用 uploads 流式读取的路由
+		Skip []string `json:"skip,omitempty"`
+
+		// 为空使用 sniff.DefaultDangerous
+		Dangerous []string `json:"dangerous,omitempty"`
+
+		MaxMemory int64 `json:"max_memory,omitempty"`
+	}
+)
+
+func (config *Sniff) init(server *Server, handler *Handler) {
+}
+
+func (config *Sniff) middleware() gin.HandlerFunc {
+	return sniff.Middleware(sniff.Config{
+		Types:     config.Types,
+		Routes:    config.Routes,
+		Skip:      config.Skip,
+		Dangerous: config.Dangerous,
+		MaxMemory: config.MaxMemory,
+	})
+}
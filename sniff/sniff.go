@@ -0,0 +1,283 @@
+package sniff
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	Config struct {
+		// 默认允许的类型  支持 image/*  为空只拒绝 Dangerous  不检查声明的类型和内容是否一致
+		Types []string
+
+		// 路由名 type.action 或 type
+		Routes map[string][]string
+
+		// 不检查的路由名  例如使用 uploads 流式读取 multipart 的路由
+		Skip []string
+
+		// 默认 DefaultDangerous  即使在 Types 中也拒绝
+		Dangerous []string
+
+		// 默认 32 MB  超过的部分由 ParseMultipartForm 写入临时文件
+		MaxMemory int64
+	}
+)
+
+var CONTEXT = "GIN.SERVER.SNIFF"
+
+// DefaultDangerous 可执行文件 脚本  浏览器会执行的 html svg
+var DefaultDangerous = []string{
+	"application/x-msdownload",
+	"application/x-executable",
+	"application/x-mach-binary",
+	"application/x-sharedlib",
+	"application/java-archive",
+	"text/x-shellscript",
+	"text/html",
+	"image/svg+xml",
+}
+
+var aliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/x-png":                  "image/png",
+	"image/x-icon":                 "image/vnd.microsoft.icon",
+	"application/x-zip-compressed": "application/zip",
+	"application/x-pdf":            "application/pdf",
+	"audio/mp3":                    "audio/mpeg",
+	"text/xml":                     "application/xml",
+	"audio/x-wav":                  "audio/wave",
+	"audio/wav":                    "audio/wave",
+}
+
+var ErrType = &errs.Error{
+	Message:    "File type is not allowed",
+	Type:       "upload",
+	StatusCode: http.StatusUnsupportedMediaType,
+}
+
+var ErrMismatch = &errs.Error{
+	Message:    "File type does not match its content",
+	Type:       "upload",
+	StatusCode: http.StatusUnsupportedMediaType,
+}
+
+var ErrForm = &errs.Error{
+	Message:    "Invalid multipart form",
+	Type:       "upload",
+	StatusCode: http.StatusBadRequest,
+}
+
+var ErrDangerous = &errs.Error{
+	Message:    "File type is dangerous",
+	Type:       "upload",
+	StatusCode: http.StatusUnsupportedMediaType,
+}
+
+// Detect 按内容识别  补充 http.DetectContentType 不识别的可执行文件 脚本 svg
+func Detect(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("MZ")):
+		return "application/x-msdownload"
+	case bytes.HasPrefix(head, []byte("\x7fELF")):
+		return "application/x-executable"
+	case bytes.HasPrefix(head, []byte("\xfe\xed\xfa\xce")), bytes.HasPrefix(head, []byte("\xfe\xed\xfa\xcf")),
+		bytes.HasPrefix(head, []byte("\xce\xfa\xed\xfe")), bytes.HasPrefix(head, []byte("\xcf\xfa\xed\xfe")):
+		return "application/x-mach-binary"
+	case bytes.HasPrefix(head, []byte("#!")):
+		return "text/x-shellscript"
+	}
+	contentType := MediaType(http.DetectContentType(head))
+	if contentType == "text/xml" || contentType == "text/plain" {
+		trimmed := bytes.ToLower(bytes.TrimLeft(head, " \t\r\n\xef\xbb\xbf"))
+		if bytes.Contains(trimmed, []byte("<svg")) {
+			return "image/svg+xml"
+		}
+	}
+	return Normalize(contentType)
+}
+
+// MediaType 去掉参数  小写
+func MediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
+
+// Normalize 统一别名  image/jpg => image/jpeg
+func Normalize(contentType string) string {
+	contentType = MediaType(contentType)
+	if val, ok := aliases[contentType]; ok {
+		return val
+	}
+	return contentType
+}
+
+// Allowed types 为空时允许
+func Allowed(types []string, contentType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	mediatype := Normalize(contentType)
+	for _, typ := range types {
+		typ = Normalize(typ)
+		if typ == mediatype || typ == "*/*" || (strings.HasSuffix(typ, "/*") && strings.HasPrefix(mediatype, strings.TrimSuffix(typ, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mismatch 客户端声明的类型或扩展名和内容不一致  内容无法识别时不比较
+func Mismatch(declared string, filename string, sniffed string) bool {
+	sniffed = Normalize(sniffed)
+	if sniffed == "application/octet-stream" || sniffed == "text/plain" {
+		return false
+	}
+	if declared = Normalize(declared); declared != "" && declared != "application/octet-stream" && !compatible(declared, sniffed) {
+		return true
+	}
+	if ext := path.Ext(filename); ext != "" {
+		if byExt := Normalize(mime.TypeByExtension(strings.ToLower(ext))); byExt != "" && byExt != "application/octet-stream" && !compatible(byExt, sniffed) {
+			return true
+		}
+	}
+	return false
+}
+
+// compatible docx xlsx odt epub apk 等的内容识别为 application/zip
+func compatible(contentType string, sniffed string) bool {
+	if contentType == sniffed {
+		return true
+	}
+	return sniffed == "application/zip" && ZipContainer(contentType)
+}
+
+// ZipContainer 使用 zip 格式的类型
+func ZipContainer(contentType string) bool {
+	contentType = Normalize(contentType)
+	switch {
+	case strings.HasSuffix(contentType, "+zip"),
+		strings.HasPrefix(contentType, "application/vnd.openxmlformats-officedocument."),
+		strings.HasPrefix(contentType, "application/vnd.oasis.opendocument."):
+		return true
+	}
+	switch contentType {
+	case "application/vnd.android.package-archive",
+		"application/java-archive",
+		"application/vnd.ms-xpsdocument",
+		"application/x-xpinstall",
+		"application/vnd.apple.keynote",
+		"application/vnd.apple.pages",
+		"application/vnd.apple.numbers":
+		return true
+	}
+	return false
+}
+
+// Check 返回识别的类型  dangerous 为 nil 时使用 DefaultDangerous  types 为空时不检查 Mismatch
+func Check(head []byte, declared string, filename string, types []string, dangerous []string) (string, error) {
+	if dangerous == nil {
+		dangerous = DefaultDangerous
+	}
+	sniffed := Detect(head)
+	if Allowed(dangerous, sniffed) && len(dangerous) != 0 {
+		return sniffed, ErrDangerous
+	}
+	if ext := path.Ext(filename); ext != "" && len(dangerous) != 0 && Allowed(dangerous, mime.TypeByExtension(strings.ToLower(ext))) {
+		return sniffed, ErrDangerous
+	}
+	if len(types) != 0 && Mismatch(declared, filename, sniffed) {
+		return sniffed, ErrMismatch
+	}
+	if !Allowed(types, sniffed) {
+		return sniffed, ErrType
+	}
+	return sniffed, nil
+}
+
+// Middleware 解析 multipart  检查每个文件的内容  通过后文件的 Content-Type 改为识别的类型
+// 流式读取 multipart 的 uploads 不经过这里  使用 uploads.Config.Types
+func Middleware(c Config) gin.HandlerFunc {
+	if c.MaxMemory == 0 {
+		c.MaxMemory = 32 << 20
+	}
+	return func(ctx *gin.Context) {
+		if !strings.HasPrefix(MediaType(ctx.ContentType()), "multipart/") {
+			ctx.Next()
+			return
+		}
+		types := c.Types
+		if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+			resource := val.(*ginResource.Resource)
+			for _, name := range c.Skip {
+				if name == resource.Name() || name == resource.Type {
+					ctx.Next()
+					return
+				}
+			}
+			if val, ok := c.Routes[resource.Name()]; ok {
+				types = val
+			} else if val, ok := c.Routes[resource.Type]; ok {
+				types = val
+			}
+		}
+		if err := ctx.Request.ParseMultipartForm(c.MaxMemory); err != nil {
+			e := ErrForm.Clone()
+			// size 中间件的 Reader 超过限制时已经设置 413
+			if ctx.Writer.Status() == http.StatusRequestEntityTooLarge {
+				e.StatusCode = http.StatusRequestEntityTooLarge
+			}
+			ctx.Error(e)
+			ctx.Abort()
+			return
+		}
+		sniffed := map[string][]string{}
+		for field, headers := range ctx.Request.MultipartForm.File {
+			for _, header := range headers {
+				contentType, err := check(header, types, c.Dangerous)
+				if err != nil {
+					e := err.(*errs.Error).Clone()
+					e.Params = map[string]interface{}{"field": field, "type": contentType}
+					ctx.Error(e)
+					ctx.Abort()
+					return
+				}
+				header.Header.Set("Content-Type", contentType)
+				sniffed[field] = append(sniffed[field], contentType)
+			}
+		}
+		ctx.Set(CONTEXT, sniffed)
+		ctx.Next()
+	}
+}
+
+// Get 字段的识别结果  和 MultipartForm.File 的顺序相同
+func Get(ctx *gin.Context, field string) []string {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(map[string][]string)[field]
+	}
+	return nil
+}
+
+func check(header *multipart.FileHeader, types []string, dangerous []string) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", ErrType
+	}
+	defer file.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", ErrType
+	}
+	return Check(head[:n], header.Header.Get("Content-Type"), header.Filename, types, dangerous)
+}
@@ -0,0 +1,86 @@
+package sniff
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+)
+
+var (
+	zipHead = []byte("PK\x03\x04\x14\x00\x06\x00\x08\x00\x00\x00!\x00")
+	pngHead = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+)
+
+func TestMismatch(t *testing.T) {
+	tests := []struct {
+		declared string
+		sniffed  string
+		want     bool
+	}{
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/zip", false},
+		{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "application/zip", false},
+		{"application/vnd.oasis.opendocument.text", "application/zip", false},
+		{"application/epub+zip", "application/zip", false},
+		{"application/vnd.android.package-archive", "application/zip", false},
+		{"application/x-zip-compressed", "application/zip", false},
+		{"image/png", "application/zip", true},
+		{"application/pdf", "image/png", true},
+		{"image/jpg", "image/jpeg", false},
+		{"", "image/png", false},
+		{"image/png", "application/octet-stream", false},
+	}
+	for _, test := range tests {
+		if got := Mismatch(test.declared, "", test.sniffed); got != test.want {
+			t.Errorf("Mismatch(%q, %q) = %v, want %v", test.declared, test.sniffed, got, test.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	docx := "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	tests := []struct {
+		name     string
+		head     []byte
+		declared string
+		types    []string
+		want     error
+	}{
+		{"docx", zipHead, docx, []string{docx, "application/zip"}, nil},
+		{"mismatch", pngHead, "application/pdf", []string{"image/*", "application/pdf"}, ErrMismatch},
+		// 没有配置 Types 时不检查 Mismatch
+		{"no types", pngHead, "application/pdf", nil, nil},
+		{"not allowed", pngHead, "image/png", []string{"application/pdf"}, ErrType},
+		{"dangerous", []byte("MZ\x90\x00"), "", nil, ErrDangerous},
+	}
+	for _, test := range tests {
+		if _, err := Check(test.head, test.declared, "", test.types, nil); err != test.want {
+			t.Errorf("%s: Check() = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestMiddlewareInvalidForm(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(ctx *gin.Context) {
+		ctx.Next()
+		if len(ctx.Errors) != 0 {
+			ctx.Status(ctx.Errors.Last().Err.(*errs.Error).StatusCode)
+		}
+	})
+	engine.Use(Middleware(Config{}))
+	engine.POST("/", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("--x\r\nbroken"))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status %d", recorder.Code)
+	}
+}
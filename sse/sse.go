@@ -0,0 +1,184 @@
+package sse
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	Config struct {
+		// 注释行 保持连接
+		Heartbeat time.Duration
+		// 客户端重连间隔
+		Retry time.Duration
+		// 超过后结束  客户端带 Last-Event-ID 重连  应小于 server WriteTimeout
+		MaxDuration time.Duration
+	}
+
+	Event struct {
+		ID    string
+		Event string
+		Data  string
+	}
+
+	Stream struct {
+		ctx         *gin.Context
+		config      Config
+		lastEventID string
+
+		mutex  sync.Mutex
+		closed bool
+		done   chan struct{}
+	}
+)
+
+var ErrClosed = errors.New("sse: stream closed")
+
+var (
+	streamsMutex sync.Mutex
+	streams      = map[*Stream]struct{}{}
+)
+
+// New 写入响应头  使用完需要 Close
+func New(ctx *gin.Context, c Config) *Stream {
+	if c.Heartbeat == 0 {
+		c.Heartbeat = time.Second * 15
+	}
+	if c.Retry == 0 {
+		c.Retry = time.Second * 3
+	}
+
+	header := ctx.Writer.Header()
+	header.Set("Content-Type", "text/event-stream; charset=utf-8")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// nginx 不缓冲
+	header.Set("X-Accel-Buffering", "no")
+	header.Del("Content-Length")
+	ctx.Writer.WriteHeader(http.StatusOK)
+
+	stream := &Stream{
+		ctx:         ctx,
+		config:      c,
+		lastEventID: ctx.GetHeader("Last-Event-ID"),
+		done:        make(chan struct{}),
+	}
+	if stream.lastEventID == "" {
+		stream.lastEventID = ctx.Query("lastEventId")
+	}
+
+	streamsMutex.Lock()
+	streams[stream] = struct{}{}
+	streamsMutex.Unlock()
+
+	stream.write("retry: " + strconv.FormatInt(int64(c.Retry/time.Millisecond), 10) + "\n\n")
+	go stream.loop()
+	return stream
+}
+
+// Shutdown 结束全部 stream  server.Shutdown 时调用
+func Shutdown() {
+	streamsMutex.Lock()
+	list := make([]*Stream, 0, len(streams))
+	for stream := range streams {
+		list = append(list, stream)
+	}
+	streamsMutex.Unlock()
+	for _, stream := range list {
+		stream.Close()
+	}
+}
+
+// LastEventID 客户端重连时的最后一个 id
+func (stream *Stream) LastEventID() string {
+	return stream.lastEventID
+}
+
+// Done 客户端断开 超时 或关闭时
+func (stream *Stream) Done() <-chan struct{} {
+	return stream.done
+}
+
+func (stream *Stream) Send(event Event) error {
+	var builder strings.Builder
+	if event.ID != "" {
+		builder.WriteString("id: " + clean(event.ID) + "\n")
+	}
+	if event.Event != "" {
+		builder.WriteString("event: " + clean(event.Event) + "\n")
+	}
+	for _, line := range strings.Split(strings.Replace(event.Data, "\r\n", "\n", -1), "\n") {
+		builder.WriteString("data: " + line + "\n")
+	}
+	builder.WriteString("\n")
+	return stream.write(builder.String())
+}
+
+func (stream *Stream) SendData(data string) error {
+	return stream.Send(Event{Data: data})
+}
+
+func (stream *Stream) Close() {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	if stream.closed {
+		return
+	}
+	stream.closed = true
+	close(stream.done)
+
+	streamsMutex.Lock()
+	delete(streams, stream)
+	streamsMutex.Unlock()
+}
+
+func (stream *Stream) write(data string) (err error) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	if stream.closed {
+		return ErrClosed
+	}
+	if _, err = stream.ctx.Writer.WriteString(data); err != nil {
+		return
+	}
+	stream.ctx.Writer.Flush()
+	return
+}
+
+func (stream *Stream) loop() {
+	ticker := time.NewTicker(stream.config.Heartbeat)
+	defer ticker.Stop()
+	var deadline <-chan time.Time
+	if stream.config.MaxDuration > 0 {
+		timer := time.NewTimer(stream.config.MaxDuration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		select {
+		case <-stream.done:
+			return
+		case <-stream.ctx.Request.Context().Done():
+			stream.Close()
+			return
+		case <-deadline:
+			stream.Close()
+			return
+		case <-ticker.C:
+			if err := stream.write(": ping\n\n"); err != nil {
+				stream.Close()
+				return
+			}
+		}
+	}
+}
+
+func clean(value string) string {
+	return strings.NewReplacer("\n", "", "\r", "").Replace(value)
+}
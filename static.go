@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/static"
+)
+
+type (
+	// Static 有 FS 时 Root 为 FS 中的目录  否则为磁盘目录
+	Static struct {
+		Root   string `json:"root,omitempty"`
+		Prefix string `json:"prefix,omitempty"`
+
+		Index  []string `json:"index,omitempty"`
+		Browse bool     `json:"browse,omitempty"`
+
+		// 扩展名 => Cache-Control  "" 为默认
+		CacheControl map[string]string `json:"cache_control,omitempty"`
+
+		// 存在 .br .gz 文件时直接使用
+		Precompressed bool `json:"precompressed,omitempty"`
+	}
+)
+
+func (config *Static) init(server *Server, handler *Handler) {
+}
+
+func (config *Static) middleware(fs http.FileSystem) gin.HandlerFunc {
+	c := static.Config{
+		Prefix:        config.Prefix,
+		Index:         config.Index,
+		Browse:        config.Browse,
+		CacheControl:  config.CacheControl,
+		Precompressed: config.Precompressed,
+	}
+	if fs != nil {
+		c.FS = static.Sub(fs, config.Root)
+	} else {
+		c.Root = config.Root
+	}
+	return static.Middleware(c)
+}
@@ -0,0 +1,295 @@
+package static
+
+import (
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	Config struct {
+		// Root 和 FS 二选一  embed 等 fs.FS 使用 http.FS(fsys)
+		Root string
+		FS   http.FileSystem
+
+		// url 前缀
+		Prefix string
+
+		Index []string
+
+		// 目录列表
+		Browse bool
+
+		// 允许访问 . 开头的文件
+		Dotfiles bool
+
+		// 扩展名 => Cache-Control  "" 为默认
+		CacheControl map[string]string
+
+		// 存在 .br .gz 文件时直接使用
+		Precompressed bool
+	}
+
+	encodingFile struct {
+		encoding  string
+		extension string
+	}
+)
+
+var ErrForbidden = &errs.Error{
+	Message:    http.StatusText(http.StatusForbidden),
+	Type:       "static",
+	StatusCode: http.StatusForbidden,
+}
+
+var precompressed = []encodingFile{
+	{encoding: "br", extension: ".br"},
+	{encoding: "gzip", extension: ".gz"},
+}
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.FS == nil {
+		if c.Root == "" {
+			panic("static: root is empty")
+		}
+		c.FS = http.Dir(c.Root)
+	}
+	if c.Index == nil {
+		c.Index = []string{"index.html"}
+	}
+	c.Prefix = "/" + strings.Trim(c.Prefix, "/")
+
+	return func(ctx *gin.Context) {
+		if ctx.Request.Method != http.MethodGet && ctx.Request.Method != http.MethodHead {
+			ctx.Next()
+			return
+		}
+
+		urlPath := ctx.Request.URL.Path
+		if !strings.HasPrefix(urlPath, "/") {
+			urlPath = "/" + urlPath
+		}
+		if c.Prefix != "/" {
+			if urlPath != c.Prefix && !strings.HasPrefix(urlPath, c.Prefix+"/") {
+				ctx.Next()
+				return
+			}
+			urlPath = strings.TrimPrefix(urlPath, c.Prefix)
+			if urlPath == "" {
+				urlPath = "/"
+			}
+		}
+
+		if !c.Dotfiles && dotfile(urlPath) {
+			ctx.Error(ErrForbidden)
+			ctx.Abort()
+			return
+		}
+
+		name := path.Clean(urlPath)
+		file, err := c.FS.Open(name)
+		if err != nil {
+			ctx.Next()
+			return
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			ctx.Next()
+			return
+		}
+
+		if stat.IsDir() {
+			file.Close()
+			// 目录需要 / 结尾
+			if !strings.HasSuffix(ctx.Request.URL.Path, "/") {
+				location := redirectPath(ctx.Request.URL.Path)
+				if ctx.Request.URL.RawQuery != "" {
+					location += "?" + ctx.Request.URL.RawQuery
+				}
+				ctx.Redirect(http.StatusMovedPermanently, location)
+				ctx.Abort()
+				return
+			}
+			for _, index := range c.Index {
+				if c.serveFile(ctx, path.Join(name, index)) {
+					ctx.Abort()
+					return
+				}
+			}
+			if c.Browse {
+				c.browse(ctx, name)
+				ctx.Abort()
+				return
+			}
+			ctx.Next()
+			return
+		}
+		file.Close()
+
+		if c.serveFile(ctx, name) {
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func (c Config) serveFile(ctx *gin.Context, name string) bool {
+	file, err := c.FS.Open(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		return false
+	}
+
+	header := ctx.Writer.Header()
+	ext := path.Ext(name)
+	contentType := mime.TypeByExtension(ext)
+
+	if c.Precompressed {
+		header.Add("Vary", "Accept-Encoding")
+		accept := ctx.GetHeader("Accept-Encoding")
+		for _, val := range precompressed {
+			if !acceptEncoding(accept, val.encoding) {
+				continue
+			}
+			encodedFile, err := c.FS.Open(name + val.extension)
+			if err != nil {
+				continue
+			}
+			encodedStat, err := encodedFile.Stat()
+			if err != nil || encodedStat.IsDir() {
+				encodedFile.Close()
+				continue
+			}
+			defer encodedFile.Close()
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			header.Set("Content-Encoding", val.encoding)
+			file, stat = encodedFile, encodedStat
+			break
+		}
+	}
+
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if val, ok := c.CacheControl[ext]; ok {
+		header.Set("Cache-Control", val)
+	} else if val, ok := c.CacheControl[""]; ok {
+		header.Set("Cache-Control", val)
+	}
+	etag := "\"" + strconv.FormatInt(stat.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(stat.Size(), 36)
+	if encoding := header.Get("Content-Encoding"); encoding != "" {
+		etag += "-" + encoding
+	}
+	header.Set("ETag", etag+"\"")
+
+	http.ServeContent(ctx.Writer, ctx.Request, stat.Name(), stat.ModTime(), file)
+	return true
+}
+
+func (c Config) browse(ctx *gin.Context, name string) {
+	dir, err := c.FS.Open(name)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	defer dir.Close()
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	var builder strings.Builder
+	title := html.EscapeString(path.Join(c.Prefix, name))
+	fmt.Fprintf(&builder, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s</title></head><body>\n<h1>%s</h1>\n<ul>\n", title, title)
+	if name != "/" {
+		builder.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, info := range infos {
+		fileName := info.Name()
+		if !c.Dotfiles && strings.HasPrefix(fileName, ".") {
+			continue
+		}
+		if info.IsDir() {
+			fileName += "/"
+		}
+		href := (&url.URL{Path: fileName}).String()
+		fmt.Fprintf(&builder, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(fileName))
+	}
+	builder.WriteString("</ul>\n</body></html>\n")
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(builder.String()))
+}
+
+// redirectPath 目录加上 /  合并开头的 / 和 \  //evil.com 不能成为协议相对地址
+func redirectPath(urlPath string) string {
+	name := strings.TrimLeft(path.Clean("/"+urlPath), "/\\")
+	if name == "" {
+		return "/"
+	}
+	return "/" + name + "/"
+}
+
+func dotfile(urlPath string) bool {
+	for _, name := range strings.Split(urlPath, "/") {
+		if strings.HasPrefix(name, ".") && name != "." {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptEncoding(accept, encoding string) bool {
+	for _, val := range strings.Split(accept, ",") {
+		val = strings.TrimSpace(val)
+		if index := strings.Index(val, ";"); index != -1 {
+			if strings.TrimSpace(val[index+1:]) == "q=0" {
+				continue
+			}
+			val = strings.TrimSpace(val[:index])
+		}
+		if val == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+// Sub FS 中的子目录  例如 embed 的 assets 目录
+func Sub(fs http.FileSystem, dir string) http.FileSystem {
+	dir = "/" + strings.Trim(dir, "/")
+	if dir == "/" {
+		return fs
+	}
+	return &subFS{fs: fs, dir: dir}
+}
+
+type subFS struct {
+	fs  http.FileSystem
+	dir string
+}
+
+func (sub *subFS) Open(name string) (http.File, error) {
+	return sub.fs.Open(path.Join(sub.dir, path.Clean("/"+name)))
+}
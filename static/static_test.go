@@ -0,0 +1,21 @@
+package static
+
+import "testing"
+
+func TestRedirectPath(t *testing.T) {
+	tests := map[string]string{
+		"/docs":       "/docs/",
+		"/docs/api":   "/docs/api/",
+		"//evil.com":  "/evil.com/",
+		"///evil.com": "/evil.com/",
+		"/\\evil.com": "/evil.com/",
+		"/a/../b":     "/b/",
+		"/../../etc":  "/etc/",
+		"/./":         "/",
+	}
+	for urlPath, want := range tests {
+		if got := redirectPath(urlPath); got != want {
+			t.Errorf("redirectPath(%q) = %q, want %q", urlPath, got, want)
+		}
+	}
+}
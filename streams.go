@@ -0,0 +1,54 @@
+package server
+
+import (
+	"time"
+
+	"github.com/otamoe/gin-server/streams"
+)
+
+type (
+	// Streams redis stream 消费组
+	Streams struct {
+		Prefix     string        `json:"prefix,omitempty"`
+		Group      string        `json:"group,omitempty"`
+		Consumer   string        `json:"consumer,omitempty"`
+		Count      int64         `json:"count,omitempty"`
+		ClaimIdle  time.Duration `json:"claim_idle,omitempty"`
+		MaxRetries int64         `json:"max_retries,omitempty"`
+		MaxLen     int64         `json:"max_len,omitempty"`
+
+		// 只生产 不消费
+		Disabled bool `json:"disabled,omitempty"`
+
+		streams *streams.Streams
+	}
+)
+
+func (config *Streams) init(server *Server, handler *Handler) {
+	if config.streams != nil {
+		return
+	}
+	provider := server.redisProvider()
+	if provider == nil {
+		panic("Streams: redis is empty")
+	}
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = "streams"
+	}
+	config.streams = streams.New(streams.Config{
+		Client:     provider.Get(),
+		Prefix:     server.redisPrefix() + prefix,
+		Group:      config.Group,
+		Consumer:   config.Consumer,
+		Count:      config.Count,
+		ClaimIdle:  config.ClaimIdle,
+		MaxRetries: config.MaxRetries,
+		MaxLen:     config.MaxLen,
+		Logger:     server.Logger.Get(),
+	})
+}
+
+func (config *Streams) Get() *streams.Streams {
+	return config.streams
+}
@@ -0,0 +1,347 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	Config struct {
+		Client *redis.Client
+
+		// stream 的 key 前缀
+		Prefix string
+
+		// 消费组  同一个服务的实例使用相同的组
+		Group string
+
+		// 默认 hostname.pid
+		Consumer string
+
+		Block time.Duration
+		Count int64
+
+		// pending 超过 ClaimIdle 的消息由其他消费者接管
+		ClaimIdle     time.Duration
+		ClaimInterval time.Duration
+
+		// 超过后进入 Prefix.dead
+		MaxRetries int64
+
+		// 生产时的最大长度  近似  0 不限制
+		MaxLen int64
+
+		Logger *logrus.Logger
+	}
+
+	Handler func(ctx context.Context, message *Message) error
+
+	Message struct {
+		ID      string
+		Stream  string
+		Values  map[string]interface{}
+		Retries int64
+	}
+
+	Streams struct {
+		config   Config
+		handlers map[string]Handler
+		mutex    sync.RWMutex
+
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+
+		processed *metrics.Counter
+		duration  *metrics.Histogram
+	}
+)
+
+var CONTEXT = "GIN.SERVER.STREAMS"
+
+var ErrNoStreams = errors.New("Streams: middleware is not used")
+
+func New(c Config) *Streams {
+	if c.Client == nil {
+		panic("Streams: redis client is empty")
+	}
+	if c.Prefix == "" {
+		c.Prefix = "streams"
+	}
+	if c.Group == "" {
+		c.Group = "default"
+	}
+	if c.Consumer == "" {
+		hostname, _ := os.Hostname()
+		c.Consumer = hostname + "." + strconv.Itoa(os.Getpid())
+	}
+	if c.Block == 0 {
+		c.Block = time.Second * 5
+	}
+	if c.Count == 0 {
+		c.Count = 10
+	}
+	if c.ClaimIdle == 0 {
+		c.ClaimIdle = time.Minute
+	}
+	if c.ClaimInterval == 0 {
+		c.ClaimInterval = time.Second * 30
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 5
+	}
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	return &Streams{
+		config:    c,
+		handlers:  map[string]Handler{},
+		processed: metrics.Default.Counter("streams_processed_total", "Processed stream messages.", "stream", "status"),
+		duration:  metrics.Default.Histogram("streams_duration_seconds", "Stream message latency.", nil, "stream"),
+	}
+}
+
+func Middleware(streams *Streams) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, streams)
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Streams {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Streams)
+	}
+	return nil
+}
+
+// Add 在 handler 中使用
+func Add(ctx *gin.Context, stream string, values map[string]interface{}) (string, error) {
+	streams := Get(ctx)
+	if streams == nil {
+		return "", ErrNoStreams
+	}
+	return streams.Add(stream, values)
+}
+
+// Register 在 Start 之前注册
+func (streams *Streams) Register(stream string, handler Handler) {
+	streams.mutex.Lock()
+	defer streams.mutex.Unlock()
+	if _, ok := streams.handlers[stream]; ok {
+		panic("Streams: " + stream + " has exists")
+	}
+	streams.handlers[stream] = handler
+}
+
+// Add 生产  返回消息 id
+func (streams *Streams) Add(stream string, values map[string]interface{}) (string, error) {
+	return streams.config.Client.XAdd(&redis.XAddArgs{
+		Stream:       streams.Key(stream),
+		MaxLenApprox: streams.config.MaxLen,
+		Values:       values,
+	}).Result()
+}
+
+func (streams *Streams) Key(stream string) string {
+	return streams.config.Prefix + "." + stream
+}
+
+// Dead 死信  Values 中 stream id error 为原消息的信息
+func (streams *Streams) Dead(count int64) ([]redis.XMessage, error) {
+	messages, err := streams.config.Client.XRange(streams.config.Prefix+".dead", "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 && int64(len(messages)) > count {
+		messages = messages[:count]
+	}
+	return messages, nil
+}
+
+// Start 创建消费组  每个 stream 一个读取和一个接管
+func (streams *Streams) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	streams.cancel = cancel
+
+	streams.mutex.RLock()
+	defer streams.mutex.RUnlock()
+	for stream, handler := range streams.handlers {
+		key := streams.Key(stream)
+		if err := streams.config.Client.XGroupCreateMkStream(key, streams.config.Group, "$").Err(); err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			streams.config.Logger.Errorf("[STREAMS] %s group %s", stream, err)
+		}
+		streams.wg.Add(2)
+		go streams.read(ctx, stream, handler)
+		go streams.claim(ctx, stream, handler)
+	}
+}
+
+// Stop 等待正在处理的消息
+func (streams *Streams) Stop(ctx context.Context) error {
+	if streams.cancel == nil {
+		return nil
+	}
+	streams.cancel()
+	done := make(chan struct{})
+	go func() {
+		streams.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (streams *Streams) read(ctx context.Context, stream string, handler Handler) {
+	defer streams.wg.Done()
+	key := streams.Key(stream)
+
+	// 先处理一次自己未确认的  例如上次退出前
+	start := "0"
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		values, err := streams.config.Client.XReadGroup(&redis.XReadGroupArgs{
+			Group:    streams.config.Group,
+			Consumer: streams.config.Consumer,
+			Streams:  []string{key, start},
+			Count:    streams.config.Count,
+			Block:    streams.config.Block,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			streams.config.Logger.Warnf("[STREAMS] %s read %s", stream, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for _, value := range values {
+			for _, val := range value.Messages {
+				streams.process(stream, handler, &Message{
+					ID:     val.ID,
+					Stream: stream,
+					Values: val.Values,
+				})
+			}
+		}
+		// 失败的由 claim 重试
+		start = ">"
+	}
+}
+
+// claim 接管其他消费者超时未确认的  超过 MaxRetries 进入死信
+func (streams *Streams) claim(ctx context.Context, stream string, handler Handler) {
+	defer streams.wg.Done()
+	ticker := time.NewTicker(streams.config.ClaimInterval)
+	defer ticker.Stop()
+	key := streams.Key(stream)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pending, err := streams.config.Client.XPendingExt(&redis.XPendingExtArgs{
+			Stream: key,
+			Group:  streams.config.Group,
+			Start:  "-",
+			End:    "+",
+			Count:  100,
+		}).Result()
+		if err != nil {
+			continue
+		}
+		for _, val := range pending {
+			if val.Idle < streams.config.ClaimIdle {
+				continue
+			}
+			messages, err := streams.config.Client.XClaim(&redis.XClaimArgs{
+				Stream:   key,
+				Group:    streams.config.Group,
+				Consumer: streams.config.Consumer,
+				MinIdle:  streams.config.ClaimIdle,
+				Messages: []string{val.Id},
+			}).Result()
+			if err != nil {
+				continue
+			}
+			for _, message := range messages {
+				if val.RetryCount > streams.config.MaxRetries {
+					streams.dead(stream, message, "max retries exceeded")
+					continue
+				}
+				streams.process(stream, handler, &Message{
+					ID:      message.ID,
+					Stream:  stream,
+					Values:  message.Values,
+					Retries: val.RetryCount,
+				})
+			}
+		}
+	}
+}
+
+// process 成功时确认  失败时留在 pending 等待接管重试
+func (streams *Streams) process(stream string, handler Handler, message *Message) {
+	start := time.Now()
+	err := streams.run(handler, message)
+	streams.duration.Observe(time.Since(start).Seconds(), stream)
+	if err != nil {
+		streams.processed.Inc(stream, "error")
+		streams.config.Logger.Warnf("[STREAMS] %s %s %s", stream, message.ID, err)
+		return
+	}
+	streams.processed.Inc(stream, "success")
+	streams.config.Client.XAck(streams.Key(stream), streams.config.Group, message.ID)
+}
+
+func (streams *Streams) dead(stream string, message redis.XMessage, reason string) {
+	values := map[string]interface{}{
+		"stream": stream,
+		"id":     message.ID,
+		"error":  reason,
+	}
+	for key, val := range message.Values {
+		values["values."+key] = val
+	}
+	streams.processed.Inc(stream, "dead")
+	streams.config.Logger.Errorf("[STREAMS] %s %s dead", stream, message.ID)
+	if err := streams.config.Client.XAdd(&redis.XAddArgs{
+		Stream: streams.config.Prefix + ".dead",
+		Values: values,
+	}).Err(); err != nil {
+		return
+	}
+	streams.config.Client.XAck(streams.Key(stream), streams.config.Group, message.ID)
+}
+
+func (streams *Streams) run(handler Handler, message *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(context.Background(), message)
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/otamoe/gin-server/proxy"
+	"github.com/otamoe/gin-server/utils"
+)
+
+type (
+	// Switch 运行时切换 host 使用的 handler 或 upstream  蓝绿发布和回滚
+	Switch struct {
+		// 管理接口  所有 host 都响应
+		Path string `json:"path,omitempty"`
+
+		// Authorization: Bearer <key>
+		Keys []string `json:"keys,omitempty"`
+
+		// 允许的来源地址  为空不限制
+		Allow []string `json:"allow,omitempty"`
+
+		server *Server
+		allow  []*net.IPNet
+
+		mutex  sync.Mutex
+		states map[string]*SwitchState
+	}
+
+	SwitchState struct {
+		Host       string    `json:"host"`
+		Target     string    `json:"target"`
+		Previous   string    `json:"previous,omitempty"`
+		SwitchedAt time.Time `json:"switched_at"`
+	}
+
+	switchRequest struct {
+		Host   string `json:"host"`
+		Target string `json:"target"`
+	}
+)
+
+var ErrSwitchTarget = errors.New("Switch: target is not a handler or upstream")
+
+func (config *Switch) init(server *Server, handler *Handler) {
+	if config.Path == "" {
+		config.Path = "/_switch"
+	}
+	var err error
+	if config.allow, err = utils.ParseCIDRs(config.Allow); err != nil {
+		panic(err)
+	}
+	config.server = server
+	config.states = map[string]*SwitchState{}
+}
+
+// Swap host 或 host/prefix 改为 target  target 为 handler 名字或 upstream 地址
+func (config *Switch) Swap(host string, target string) error {
+	return config.swap(host, target, false)
+}
+
+// Rollback 切换回上一个
+func (config *Switch) Rollback(host string) error {
+	return config.swap(host, "", true)
+}
+
+func (config *Switch) States() []SwitchState {
+	return config.list()
+}
+
+func (config *Switch) swap(host string, target string, rollback bool) error {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	state, ok := config.states[host]
+	if !ok {
+		state = &SwitchState{Host: host, Target: config.current(host)}
+	}
+	if rollback {
+		if state.Previous == "" {
+			return errors.New("Switch: " + host + " has no previous target")
+		}
+		target = state.Previous
+	}
+
+	handler, builtins, err := config.resolve(target)
+	if err != nil {
+		return err
+	}
+	serverHandler := config.server.getServerHandler()
+	serverHandler.add(host, handler)
+	if builtins != nil && !strings.Contains(host, "/") {
+		serverHandler.setBuiltins(host, builtins)
+	}
+
+	state.Previous, state.Target = state.Target, target
+	state.SwitchedAt = time.Now()
+	config.states[host] = state
+	config.server.Logger.Get().Infof("[SWITCH] %s %s => %s", host, state.Previous, state.Target)
+	return nil
+}
+
+func (config *Switch) resolve(target string) (http.Handler, *Builtins, error) {
+	if strings.Contains(target, "://") {
+		if u, err := url.Parse(target); err != nil || u.Host == "" {
+			return nil, nil, ErrSwitchTarget
+		}
+		return proxy.New(proxy.Config{
+			Upstream:       target,
+			TrustedProxies: config.server.trustedProxies,
+			Retries:        2,
+		}), nil, nil
+	}
+	handler := config.server.Get(target, false)
+	if handler == nil {
+		return nil, nil, ErrSwitchTarget
+	}
+	return handler.http(config.server), handler.Builtins, nil
+}
+
+// current 配置中使用这个 host 的 handler
+func (config *Switch) current(host string) string {
+	for _, handler := range config.server.Handlers {
+		for _, val := range handler.Hosts {
+			if len(handler.Prefixes) == 0 && val == host {
+				return handler.Name
+			}
+			for _, prefix := range handler.Prefixes {
+				if val+"/"+strings.TrimPrefix(prefix, "/") == host {
+					return handler.Name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func (config *Switch) list() (states []SwitchState) {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	for _, state := range config.states {
+		states = append(states, *state)
+	}
+	return
+}
+
+func (config *Switch) match(urlPath string) bool {
+	return urlPath == config.Path || urlPath == config.Path+"/rollback"
+}
+
+// ServeHTTP GET 列表  POST {"host": "", "target": ""} 切换  POST /rollback {"host": ""} 回滚
+func (config *Switch) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	if !config.authorized(req) {
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if req.Method == http.MethodGet && req.URL.Path == config.Path {
+		writeJSON(writer, http.StatusOK, config.States())
+		return
+	}
+	if req.Method != http.MethodPost {
+		writer.Header().Set("Allow", "GET, POST")
+		http.Error(writer, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	body := &switchRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(writer, req.Body, 4096)).Decode(body); err != nil || body.Host == "" {
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	var err error
+	if req.URL.Path == config.Path+"/rollback" {
+		err = config.swap(body.Host, "", true)
+	} else {
+		err = config.swap(body.Host, body.Target, false)
+	}
+	if err != nil {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	config.mutex.Lock()
+	state := *config.states[body.Host]
+	config.mutex.Unlock()
+	writeJSON(writer, http.StatusOK, state)
+}
+
+func (config *Switch) authorized(req *http.Request) bool {
+	if len(config.allow) != 0 && !utils.ContainsIP(config.allow, utils.RemoteIP(req)) {
+		return false
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for _, key := range config.Keys {
+		if key != "" && subtle.ConstantTimeCompare(token, []byte(key)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(writer http.ResponseWriter, code int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(code)
+	json.NewEncoder(writer).Encode(value)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/static"
+	"github.com/otamoe/gin-server/templates"
+)
+
+type (
+	// Templates 有 FS 时 Dir 为 FS 中的目录  否则为磁盘目录
+	Templates struct {
+		Dir string `json:"dir,omitempty"`
+		Ext string `json:"ext,omitempty"`
+
+		// 默认 layout  为空时直接执行页面
+		Layout string `json:"layout,omitempty"`
+
+		// 每次渲染重新加载  默认 debug 模式开启
+		Reload *bool `json:"reload,omitempty"`
+	}
+)
+
+func (config *Templates) init(server *Server, handler *Handler) {
+}
+
+func (config *Templates) middleware(fs http.FileSystem, routes func() gin.RoutesInfo, funcs template.FuncMap) gin.HandlerFunc {
+	c := templates.Config{
+		Ext:    config.Ext,
+		Layout: config.Layout,
+		Reload: config.Reload,
+		Routes: routes,
+		Funcs:  funcs,
+	}
+	if fs != nil {
+		c.FS = static.Sub(fs, config.Dir)
+	} else {
+		c.Dir = config.Dir
+	}
+	return templates.Middleware(templates.New(c))
+}
@@ -0,0 +1,276 @@
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/i18n"
+	"github.com/otamoe/gin-server/resource"
+	"github.com/otamoe/gin-server/timezone"
+)
+
+type (
+	Config struct {
+		// Dir 和 FS 二选一  embed 等 fs.FS 使用 http.FS(fsys)
+		Dir string
+		FS  http.FileSystem
+
+		Ext string
+
+		// 目录中的模板会加入每个页面
+		Layouts  string
+		Partials string
+
+		// 默认 layout 为空时直接执行页面
+		Layout string
+
+		Funcs template.FuncMap
+
+		Delims []string
+
+		// 每次渲染重新加载 默认 debug 模式开启
+		Reload *bool
+
+		// url 函数使用
+		Routes func() gin.RoutesInfo
+	}
+
+	Templates struct {
+		config Config
+		reload bool
+
+		mutex sync.RWMutex
+		pages map[string]*template.Template
+	}
+)
+
+var CONTEXT = "GIN.SERVER.TEMPLATES"
+
+func New(c Config) *Templates {
+	if c.FS == nil {
+		if c.Dir == "" {
+			panic("templates: dir is empty")
+		}
+		c.FS = http.Dir(c.Dir)
+	}
+	if c.Ext == "" {
+		c.Ext = ".html"
+	}
+	if c.Layouts == "" {
+		c.Layouts = "layouts"
+	}
+	if c.Partials == "" {
+		c.Partials = "partials"
+	}
+	if len(c.Delims) != 2 {
+		c.Delims = []string{"{{", "}}"}
+	}
+
+	templates := &Templates{
+		config: c,
+		reload: gin.Mode() == gin.DebugMode,
+	}
+	if c.Reload != nil {
+		templates.reload = *c.Reload
+	}
+
+	// 生产环境启动时预编译 有错误直接 panic
+	pages, err := templates.load()
+	if err != nil {
+		panic(err)
+	}
+	templates.pages = pages
+	return templates
+}
+
+// Middleware 保存到 context  handler 中使用 templates.HTML
+func Middleware(templates *Templates) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, templates)
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Templates {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Templates)
+	}
+	return nil
+}
+
+// HTML 使用 context 中的 templates 渲染  data 为 gin.H 时加入 Locale TimeZone  {{ t .Locale "key" }}
+func HTML(ctx *gin.Context, code int, name string, data interface{}) {
+	templates := Get(ctx)
+	if templates == nil {
+		ctx.Error(errors.New("templates: middleware is not used"))
+		ctx.Abort()
+		return
+	}
+	if h, ok := data.(gin.H); ok && h != nil {
+		if _, ok := h["Locale"]; !ok {
+			if locale := i18n.Locale(ctx); locale != "" {
+				h["Locale"] = locale
+			}
+		}
+		if _, ok := h["TimeZone"]; !ok {
+			h["TimeZone"] = timezone.Name(ctx)
+		}
+	}
+	var buf bytes.Buffer
+	if err := templates.Render(&buf, name, templates.config.Layout, data); err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	ctx.Data(code, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// Render layout 为空时执行页面本身
+func (templates *Templates) Render(w io.Writer, name string, layout string, data interface{}) (err error) {
+	var pages map[string]*template.Template
+	if templates.reload {
+		if pages, err = templates.load(); err != nil {
+			return
+		}
+		templates.mutex.Lock()
+		templates.pages = pages
+		templates.mutex.Unlock()
+	} else {
+		templates.mutex.RLock()
+		pages = templates.pages
+		templates.mutex.RUnlock()
+	}
+
+	page, ok := pages[name]
+	if !ok {
+		return errors.New("templates: " + name + " not found")
+	}
+	if layout == "" {
+		return page.ExecuteTemplate(w, name, data)
+	}
+	return page.ExecuteTemplate(w, path.Join(templates.config.Layouts, layout), data)
+}
+
+func (templates *Templates) Names() (names []string) {
+	templates.mutex.RLock()
+	defer templates.mutex.RUnlock()
+	for name := range templates.pages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func (templates *Templates) funcs() template.FuncMap {
+	funcs := template.FuncMap{
+		"url": func(name string, params ...interface{}) (string, error) {
+			if templates.config.Routes == nil {
+				return "", errors.New("templates: routes is nil")
+			}
+			return resource.URL(templates.config.Routes(), name, params...)
+		},
+	}
+	for key, val := range templates.config.Funcs {
+		funcs[key] = val
+	}
+	return funcs
+}
+
+func (templates *Templates) load() (pages map[string]*template.Template, err error) {
+	var files []string
+	if files, err = templates.walk("/"); err != nil {
+		return
+	}
+
+	var shared []string
+	var names []string
+	for _, name := range files {
+		if strings.HasPrefix(name, templates.config.Layouts+"/") || strings.HasPrefix(name, templates.config.Partials+"/") {
+			shared = append(shared, name)
+		} else {
+			names = append(names, name)
+		}
+	}
+
+	contents := map[string]string{}
+	for _, name := range files {
+		if contents[name], err = templates.read(name + templates.config.Ext); err != nil {
+			return
+		}
+	}
+
+	// 每个页面单独一组 可以覆盖 layout 中的 block
+	base := template.New("").Delims(templates.config.Delims[0], templates.config.Delims[1]).Funcs(templates.funcs())
+	for _, name := range shared {
+		if _, err = base.New(name).Parse(contents[name]); err != nil {
+			return
+		}
+	}
+
+	pages = map[string]*template.Template{}
+	for _, name := range names {
+		var page *template.Template
+		if page, err = base.Clone(); err != nil {
+			return
+		}
+		if _, err = page.New(name).Parse(contents[name]); err != nil {
+			return
+		}
+		pages[name] = page
+	}
+	return
+}
+
+func (templates *Templates) walk(dir string) (names []string, err error) {
+	var file http.File
+	if file, err = templates.config.FS.Open(dir); err != nil {
+		return
+	}
+	defer file.Close()
+	infos, err := file.Readdir(-1)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		name := path.Join(dir, info.Name())
+		if info.IsDir() {
+			var children []string
+			if children, err = templates.walk(name); err != nil {
+				return
+			}
+			names = append(names, children...)
+			continue
+		}
+		if path.Ext(name) != templates.config.Ext {
+			continue
+		}
+		names = append(names, strings.TrimPrefix(strings.TrimSuffix(name, templates.config.Ext), "/"))
+	}
+	return
+}
+
+func (templates *Templates) read(name string) (content string, err error) {
+	var file http.File
+	if file, err = templates.config.FS.Open("/" + name); err != nil {
+		return
+	}
+	defer file.Close()
+	var data []byte
+	if data, err = ioutil.ReadAll(file); err != nil {
+		return
+	}
+	content = string(data)
+	return
+}
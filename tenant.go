@@ -0,0 +1,20 @@
+package server
+
+type (
+	// Tenant 根据 host 子域名 或 Header 确定 tenant  隔离 redis mongo logger
+	Tenant struct {
+		Hosts    map[string]string `json:"hosts,omitempty"`
+		Domain   string            `json:"domain,omitempty"`
+		Header   string            `json:"header,omitempty"`
+		Required bool              `json:"required,omitempty"`
+
+		// mongo 数据库  例如 app_{tenant}
+		Database string `json:"database,omitempty"`
+
+		// mongo 集合加上 tenant_ 前缀
+		Collections bool `json:"collections,omitempty"`
+	}
+)
+
+func (config *Tenant) init(server *Server, handler *Handler) {
+}
@@ -0,0 +1,160 @@
+package tenant
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/logger"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+)
+
+type (
+	Config struct {
+		// host => tenant  优先
+		Hosts map[string]string
+
+		// 子域名  例如 example.com  acme.example.com => acme
+		Domain string
+
+		// 例如 X-Tenant-ID  host 没有匹配时使用
+		Header string
+
+		// 没有 tenant 时返回 400
+		Required bool
+
+		// 返回 false 时 404  例如 tenant 不存在或已停用
+		Valid func(ctx *gin.Context, id string) bool
+
+		// mongo 数据库  {tenant} 替换为 tenant  例如 app_{tenant}
+		Database string
+
+		// mongo 集合加上 tenant_ 前缀
+		Collections bool
+	}
+)
+
+var CONTEXT = "GIN.SERVER.TENANT"
+
+var ErrRequired = &errs.Error{
+	Message:    "Tenant is required",
+	Type:       "tenant",
+	StatusCode: http.StatusBadRequest,
+}
+
+var ErrInvalid = &errs.Error{
+	Message:    "Tenant is invalid",
+	Type:       "tenant",
+	StatusCode: http.StatusBadRequest,
+}
+
+var ErrNotFound = &errs.Error{
+	Message:    "Tenant not found",
+	Type:       "tenant",
+	StatusCode: http.StatusNotFound,
+}
+
+// Middleware 在 Redis Mongo 之后  修改 key 前缀 数据库 和 logger
+func Middleware(c Config) gin.HandlerFunc {
+	hosts := map[string]string{}
+	for host, id := range c.Hosts {
+		hosts[hostName(host)] = id
+	}
+	domain := "." + strings.Trim(strings.ToLower(c.Domain), ".")
+
+	return func(ctx *gin.Context) {
+		id := Resolve(ctx.Request, hosts, domain, c.Header)
+		if id == "" {
+			if c.Required {
+				ctx.Error(ErrRequired)
+				ctx.Abort()
+				return
+			}
+			ctx.Next()
+			return
+		}
+		if !valid(id) {
+			ctx.Error(ErrInvalid)
+			ctx.Abort()
+			return
+		}
+		if c.Valid != nil && !c.Valid(ctx, id) {
+			ctx.Error(ErrNotFound)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Set(CONTEXT, id)
+
+		// redis key 前缀  rate sessions 等随之隔离
+		if _, ok := ctx.Get(redisMiddleware.CONTEXT); ok {
+			ctx.Set(redisMiddleware.CONTEXT_PREFIX, redisMiddleware.Prefix(ctx)+id+":")
+		}
+
+		// mongo
+		if c.Database != "" {
+			ctx.Set(mongoMiddleware.CONTEXT_DATABASE, strings.Replace(c.Database, "{tenant}", id, -1))
+		}
+		if c.Collections {
+			ctx.Set(mongoMiddleware.CONTEXT_PREFIX, mongoMiddleware.Collection(ctx, id+"_"))
+		}
+
+		// logger
+		if val, ok := ctx.Get(logger.CONTEXT); ok && val != nil {
+			if val, ok := val.(*logger.Logger); ok {
+				val.Fields["tenant"] = id
+			}
+		}
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) string {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(string)
+	}
+	return ""
+}
+
+// Resolve 顺序 Hosts => 子域名 => Header
+func Resolve(req *http.Request, hosts map[string]string, domain string, header string) string {
+	host := hostName(req.Host)
+	if id, ok := hosts[host]; ok {
+		return id
+	}
+	if domain != "." && strings.HasSuffix(host, domain) {
+		if id := strings.TrimSuffix(host, domain); id != "" && !strings.Contains(id, ".") {
+			return id
+		}
+	}
+	if header != "" {
+		return strings.TrimSpace(req.Header.Get(header))
+	}
+	return ""
+}
+
+func hostName(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// valid 用于 key 和数据库名  只允许 a-z 0-9 - _
+func valid(id string) bool {
+	if len(id) > 63 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch b := id[i]; {
+		case b >= 'a' && b <= 'z', b >= '0' && b <= '9', b == '-', b == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"sync"
+)
+
+type (
+	// LogBuffer test env 所有 logger 写入同一个  多个 logger 并发写入
+	LogBuffer struct {
+		buffer bytes.Buffer
+		mutex  sync.Mutex
+	}
+)
+
+// testing ENV == "test"  不连接网络  日志写入 LogBuffer  不处理信号
+// 证书仍然使用 crypto/rand  需要固定的证书时使用 enginetest.Certificate
+func (server *Server) testing() bool {
+	return server != nil && server.ENV == "test"
+}
+
+// initTesting Name 之前执行
+func (server *Server) initTesting() {
+	if !server.testing() {
+		return
+	}
+	if server.Name == "" {
+		server.Name = "test"
+	}
+	if server.logs == nil {
+		server.logs = &LogBuffer{}
+	}
+}
+
+// Logs test env 的日志  其他 env 为 nil
+func (server *Server) Logs() *LogBuffer {
+	return server.logs
+}
+
+// Stop 和收到关闭信号相同  Start 返回
+func (server *Server) Stop() {
+	select {
+	case server.quitChan() <- os.Interrupt:
+	default:
+	}
+}
+
+func (server *Server) quitChan() chan os.Signal {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.quit == nil {
+		server.quit = make(chan os.Signal, 1)
+	}
+	return server.quit
+}
+
+func (buffer *LogBuffer) Write(data []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.Write(data)
+}
+
+func (buffer *LogBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.String()
+}
+
+func (buffer *LogBuffer) Bytes() []byte {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return append([]byte(nil), buffer.buffer.Bytes()...)
+}
+
+func (buffer *LogBuffer) Len() int {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.Len()
+}
+
+func (buffer *LogBuffer) Reset() {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	buffer.buffer.Reset()
+}
@@ -0,0 +1,34 @@
+package server
+
+import "testing"
+
+// test env 也不能生成可预测的私钥
+func TestTestingCertificateRandom(t *testing.T) {
+	var certificates []string
+	for i := 0; i < 2; i++ {
+		srv := &Server{ENV: "test", Certificates: []Certificate{}}
+		srv.Init()
+		if len(srv.Certificates) != 1 {
+			t.Fatalf("certificates %d", len(srv.Certificates))
+		}
+		certificates = append(certificates, srv.Certificates[0].PrivateKey)
+	}
+	if certificates[0] == certificates[1] {
+		t.Fatal("test env generated the same private key twice")
+	}
+}
+
+func TestTestingDefaults(t *testing.T) {
+	srv := &Server{ENV: "test"}
+	srv.Init()
+	if srv.Name != "test" {
+		t.Fatalf("name %q", srv.Name)
+	}
+	if srv.Logs() == nil {
+		t.Fatal("Logs is nil in test env")
+	}
+	srv.Logger.Get().Info("hello")
+	if srv.Logs().Len() == 0 {
+		t.Fatal("log not written to Logs")
+	}
+}
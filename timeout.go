@@ -0,0 +1,29 @@
+package server
+
+import (
+	"time"
+)
+
+type (
+	// Timeout 请求的 deadline  Mongo Redis 使用剩余时间
+	Timeout struct {
+		// 0 不限制
+		Default time.Duration `json:"default,omitempty"`
+
+		// 路由名 type.action 或 type => 时长  例如 "report.generate": "120s"
+		Routes map[string]string `json:"routes,omitempty"`
+
+		routes map[string]time.Duration
+	}
+)
+
+func (config *Timeout) init(server *Server, handler *Handler) {
+	config.routes = map[string]time.Duration{}
+	for name, val := range config.Routes {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			panic(err)
+		}
+		config.routes[name] = d
+	}
+}
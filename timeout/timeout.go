@@ -0,0 +1,59 @@
+package timeout
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	Config struct {
+		// 0 不限制
+		Default time.Duration
+
+		// 路由名 type.action 或 type  优先于 Default
+		Routes map[string]time.Duration
+	}
+)
+
+var CONTEXT = "GIN.SERVER.TIMEOUT"
+
+// Middleware 设置 ctx.Request 的 deadline  Mongo Redis 中间件使用剩余时间
+func Middleware(c Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		timeout := c.Default
+		if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+			resource := val.(*ginResource.Resource)
+			if val, ok := c.Routes[resource.Name()]; ok {
+				timeout = val
+			} else if val, ok := c.Routes[resource.Type]; ok {
+				timeout = val
+			}
+		}
+		if timeout <= 0 {
+			ctx.Next()
+			return
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+		ctx.Request = ctx.Request.WithContext(reqCtx)
+		ctx.Set(CONTEXT, timeout)
+		ctx.Next()
+	}
+}
+
+// Remaining 剩余时间  没有 deadline 时 ok 为 false
+func Remaining(ctx context.Context) (remaining time.Duration, ok bool) {
+	var deadline time.Time
+	if deadline, ok = ctx.Deadline(); !ok {
+		return
+	}
+	if remaining = time.Until(deadline); remaining <= 0 {
+		// 已经超时  使用最小值让操作立即失败
+		remaining = time.Millisecond
+	}
+	return
+}
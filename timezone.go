@@ -0,0 +1,36 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/timezone"
+)
+
+type (
+	// TimeZone 请求的时区  timezone.Get timezone.Format
+	TimeZone struct {
+		Query   string `json:"query,omitempty"`
+		Header  string `json:"header,omitempty"`
+		Cookie  string `json:"cookie,omitempty"`
+		Default string `json:"default,omitempty"`
+
+		// 不使用 geoip 中间件的 time_zone
+		DisableGeoIP bool `json:"disable_geoip,omitempty"`
+
+		// 用户设置的时区  返回空时继续
+		Profile func(ctx *gin.Context) string `json:"-"`
+	}
+)
+
+func (config *TimeZone) init(server *Server, handler *Handler) {
+}
+
+func (config *TimeZone) middleware() gin.HandlerFunc {
+	return timezone.Middleware(timezone.Config{
+		Profile:      config.Profile,
+		Query:        config.Query,
+		Header:       config.Header,
+		Cookie:       config.Cookie,
+		DisableGeoIP: config.DisableGeoIP,
+		Default:      config.Default,
+	})
+}
@@ -0,0 +1,159 @@
+package timezone
+
+import (
+	"html/template"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/cachecontrol"
+	"github.com/otamoe/gin-server/geoip"
+)
+
+type (
+	Config struct {
+		// 用户设置的时区  返回空时继续
+		Profile func(ctx *gin.Context) string
+
+		// 默认 tz
+		Query string
+
+		// 默认 Time-Zone  值为 IANA 名字  例如 Asia/Shanghai
+		Header string
+
+		// 默认 tz
+		Cookie string
+
+		// geoip 中间件的 TimeZone  默认开启
+		DisableGeoIP bool
+
+		// 默认 UTC
+		Default string
+	}
+)
+
+var CONTEXT = "GIN.SERVER.TIMEZONE"
+
+var locations sync.Map
+
+// Load 缓存 time.LoadLocation  不接受 Local
+func Load(name string) (*time.Location, bool) {
+	if name == "" || name == "Local" || len(name) > 64 {
+		return nil, false
+	}
+	if val, ok := locations.Load(name); ok {
+		return val.(*time.Location), true
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, false
+	}
+	locations.Store(name, location)
+	return location, true
+}
+
+// Middleware 依次使用 Profile Query Header Cookie geoip Default
+func Middleware(c Config) gin.HandlerFunc {
+	if c.Query == "" {
+		c.Query = "tz"
+	}
+	if c.Header == "" {
+		c.Header = "Time-Zone"
+	}
+	if c.Cookie == "" {
+		c.Cookie = "tz"
+	}
+	fallback := time.UTC
+	if c.Default != "" {
+		var ok bool
+		if fallback, ok = Load(c.Default); !ok {
+			panic("timezone: invalid default " + c.Default)
+		}
+	}
+	return func(ctx *gin.Context) {
+		cachecontrol.AddVary(ctx, c.Header)
+		location := resolve(ctx, c)
+		if location == nil {
+			location = fallback
+		}
+		ctx.Set(CONTEXT, location)
+		ctx.Next()
+	}
+}
+
+func resolve(ctx *gin.Context, c Config) *time.Location {
+	if c.Profile != nil {
+		if location, ok := Load(c.Profile(ctx)); ok {
+			return location
+		}
+	}
+	if location, ok := Load(ctx.Query(c.Query)); ok {
+		return location
+	}
+	if location, ok := Load(ctx.GetHeader(c.Header)); ok {
+		return location
+	}
+	if val, err := ctx.Cookie(c.Cookie); err == nil {
+		if location, ok := Load(val); ok {
+			return location
+		}
+	}
+	if !c.DisableGeoIP {
+		if val, ok := ctx.Get(geoip.CONTEXT); ok && val != nil {
+			if location, ok := Load(val.(*geoip.Location).TimeZone); ok {
+				return location
+			}
+		}
+	}
+	return nil
+}
+
+// Get 没有 middleware 时为 UTC
+func Get(ctx *gin.Context) *time.Location {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*time.Location)
+	}
+	return time.UTC
+}
+
+// Name IANA 名字  templates.HTML 放入 .TimeZone
+func Name(ctx *gin.Context) string {
+	return Get(ctx).String()
+}
+
+func In(ctx *gin.Context, t time.Time) time.Time {
+	return t.In(Get(ctx))
+}
+
+func Now(ctx *gin.Context) time.Time {
+	return time.Now().In(Get(ctx))
+}
+
+func Format(ctx *gin.Context, t time.Time, layout string) string {
+	return t.In(Get(ctx)).Format(layout)
+}
+
+// StartOfDay 当地时间的 0 点  按天统计时使用
+func StartOfDay(ctx *gin.Context, t time.Time) time.Time {
+	t = t.In(Get(ctx))
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// FuncMap {{ tz .TimeZone .Time }}  {{ tzformat .TimeZone .Time "2006-01-02 15:04" }}
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"tz":       in,
+		"tzformat": format,
+	}
+}
+
+func in(name string, t time.Time) time.Time {
+	if location, ok := Load(name); ok {
+		return t.In(location)
+	}
+	return t.UTC()
+}
+
+func format(name string, t time.Time, layout string) string {
+	return in(name, t).Format(layout)
+}
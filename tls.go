@@ -0,0 +1,179 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+type (
+	// TLSPolicy 按 SNI host 覆盖 tls 参数  为空的字段使用默认
+	TLSPolicy struct {
+		// 1.0 1.1 1.2 1.3
+		MinVersion string `json:"min_version,omitempty"`
+
+		// request require verify_if_given require_and_verify
+		ClientAuth string `json:"client_auth,omitempty"`
+
+		// 验证客户端证书的 CA  pem
+		ClientCA     string `json:"client_ca,omitempty"`
+		ClientCAFile string `json:"client_ca_file,omitempty"`
+
+		// 例如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+		CipherSuites []string `json:"cipher_suites,omitempty"`
+
+		// ALPN  例如 ["http/1.1"] 禁用 http2
+		NextProtos []string `json:"next_protos,omitempty"`
+	}
+
+	tlsConfigs struct {
+		base  *tls.Config
+		hosts map[string]*tls.Config
+	}
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsClientAuths = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":          tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":        tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// loadTLSConfigs 读取证书 和 TLSPolicies  Listeners 有自己的证书时分别读取
+func (server *Server) loadTLSConfigs(certificates []Certificate, policies map[string]*TLSPolicy) (configs *tlsConfigs, err error) {
+	configs = &tlsConfigs{
+		hosts: map[string]*tls.Config{},
+	}
+	if configs.base, err = server.getTLSConfig(certificates); err != nil {
+		return
+	}
+	configs.base.NextProtos = server.nextProtos()
+	for host, policy := range policies {
+		var config *tls.Config
+		if config, err = policy.apply(configs.base.Clone()); err != nil {
+			err = errors.New("TLS policy " + host + ": " + err.Error())
+			return
+		}
+		configs.hosts[hostName(host)] = config
+	}
+	return
+}
+
+// get 按 SNI 匹配  支持 *.example.com
+func (configs *tlsConfigs) get(serverName string) *tls.Config {
+	if serverName != "" && len(configs.hosts) != 0 {
+		if key, ok := matchHost(serverName, func(key string) bool {
+			_, ok := configs.hosts[key]
+			return ok
+		}); ok {
+			return configs.hosts[key]
+		}
+	}
+	return configs.base
+}
+
+// tlsPolicyHosts host 和 loadTLSConfigs 相同  去掉端口 小写
+func tlsPolicyHosts(policies map[string]*TLSPolicy) map[string]*TLSPolicy {
+	hosts := map[string]*TLSPolicy{}
+	for host, policy := range policies {
+		hosts[hostName(host)] = policy
+	}
+	return hosts
+}
+
+// misdirected Host 匹配的 TLSPolicy 和 SNI 匹配的不是同一个  没有 SNI 时也是
+func (h *serverHandler) misdirected(serverName string, host string) bool {
+	for _, policies := range h.tlsPolicies {
+		has := func(key string) bool {
+			_, ok := policies[key]
+			return ok
+		}
+		hostKey, ok := matchHost(host, has)
+		if !ok {
+			continue
+		}
+		if nameKey, ok := matchHost(serverName, has); !ok || nameKey != hostKey {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *TLSPolicy) apply(config *tls.Config) (*tls.Config, error) {
+	if policy.MinVersion != "" {
+		version, ok := tlsVersions[policy.MinVersion]
+		if !ok {
+			return nil, errors.New("unknown min_version " + policy.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	clientAuth, ok := tlsClientAuths[policy.ClientAuth]
+	if !ok {
+		return nil, errors.New("unknown client_auth " + policy.ClientAuth)
+	}
+	config.ClientAuth = clientAuth
+
+	if policy.ClientCA != "" || policy.ClientCAFile != "" {
+		data := []byte(policy.ClientCA)
+		if policy.ClientCAFile != "" {
+			var err error
+			if data, err = ioutil.ReadFile(policy.ClientCAFile); err != nil {
+				return nil, err
+			}
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("invalid client_ca")
+		}
+		config.ClientCAs = pool
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		return nil, errors.New("client_auth " + policy.ClientAuth + " requires client_ca")
+	}
+
+	if len(policy.NextProtos) != 0 {
+		config.NextProtos = policy.NextProtos
+	}
+
+	if len(policy.CipherSuites) != 0 {
+		config.CipherSuites = nil
+		for _, name := range policy.CipherSuites {
+			id, ok := tlsCipherSuites[strings.ToUpper(name)]
+			if !ok {
+				return nil, errors.New("unknown cipher suite " + name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+	return config, nil
+}
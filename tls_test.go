@@ -0,0 +1,58 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMisdirected(t *testing.T) {
+	h := newServerHandler()
+	h.tlsPolicies = []map[string]*TLSPolicy{tlsPolicyHosts(map[string]*TLSPolicy{
+		"Partner.example.com":    {ClientAuth: "require_and_verify"},
+		"*.internal.example.com": {ClientAuth: "require_and_verify"},
+	})}
+	for _, val := range []struct {
+		serverName string
+		host       string
+		want       bool
+	}{
+		{"www.example.com", "www.example.com", false},
+		{"partner.example.com", "partner.example.com:443", false},
+		{"www.example.com", "partner.example.com", true},
+		{"", "partner.example.com", true},
+		{"a.internal.example.com", "b.internal.example.com", false},
+		{"www.example.com", "a.internal.example.com", true},
+		{"partner.example.com", "www.example.com", false},
+	} {
+		if got := h.misdirected(val.serverName, val.host); got != val.want {
+			t.Errorf("misdirected(%q, %q) = %v, want %v", val.serverName, val.host, got, val.want)
+		}
+	}
+}
+
+func TestServeHTTPMisdirected(t *testing.T) {
+	h := newServerHandler()
+	h.tlsPolicies = []map[string]*TLSPolicy{tlsPolicyHosts(map[string]*TLSPolicy{
+		"partner.example.com": {ClientAuth: "require_and_verify"},
+	})}
+	h.add("partner.example.com", http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "https://partner.example.com/", nil)
+	req.TLS = &tls.ConnectionState{ServerName: "www.example.com"}
+	writer := httptest.NewRecorder()
+	h.ServeHTTP(writer, req)
+	if writer.Code != http.StatusMisdirectedRequest {
+		t.Fatalf("status %d, want %d", writer.Code, http.StatusMisdirectedRequest)
+	}
+
+	req.TLS = &tls.ConnectionState{ServerName: "partner.example.com"}
+	writer = httptest.NewRecorder()
+	h.ServeHTTP(writer, req)
+	if writer.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", writer.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/totp"
+)
+
+type (
+	// TOTP 路由需要第二步验证  验证结果保存在 session
+	TOTP struct {
+		// 验证器中显示的名字
+		Issuer string `json:"issuer,omitempty"`
+
+		// 路由名 type.action 或 type
+		Routes []string `json:"routes,omitempty"`
+
+		// 验证之后多久需要重新验证  0 为 session 有效期内
+		MaxAge time.Duration `json:"max_age,omitempty"`
+	}
+)
+
+func (config *TOTP) init(server *Server, handler *Handler) {
+	if config.Issuer == "" && server != nil {
+		config.Issuer = server.Name
+	}
+}
+
+func (config *TOTP) middleware() gin.HandlerFunc {
+	return totp.Middleware(totp.Config{
+		Issuer: config.Issuer,
+		Routes: config.Routes,
+		MaxAge: config.MaxAge,
+	})
+}
@@ -0,0 +1,454 @@
+package totp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/audit"
+	"github.com/otamoe/gin-server/errs"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/otamoe/gin-server/sessions"
+	mgoModel "github.com/otamoe/mgo-model"
+)
+
+type (
+	Config struct {
+		Issuer string
+
+		// 需要第二步验证的路由名 type.action 或 type
+		Routes []string
+
+		// 验证之后多久需要重新验证  0 为 session 有效期内
+		MaxAge time.Duration
+
+		// 前后允许的周期  默认 1
+		Skew int
+
+		// 默认 audit.Actor
+		Subject func(ctx *gin.Context) string
+
+		// 连续失败多少次后锁定  默认 5  -1 不锁定
+		MaxAttempts int
+
+		// 锁定的时间  默认 15 分钟
+		Lockout time.Duration
+	}
+
+	// Factor 每个 subject 一个  恢复码只保存 sha256
+	Factor struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    string     `json:"_id" bson:"_id"`
+		Secret                string     `json:"-" bson:"secret"`
+		Enabled               bool       `json:"enabled" bson:"enabled"`
+		LastCounter           int64      `json:"-" bson:"last_counter"`
+		RecoveryCodes         []string   `json:"-" bson:"recovery_codes,omitempty"`
+		FailedAttempts        int        `json:"-" bson:"failed_attempts"`
+		LockedUntil           *time.Time `json:"-" bson:"locked_until,omitempty"`
+		CreatedAt             *time.Time `json:"created_at" bson:"created_at"`
+		EnabledAt             *time.Time `json:"enabled_at,omitempty" bson:"enabled_at,omitempty"`
+	}
+)
+
+var (
+	CONTEXT = "GIN.SERVER.TOTP"
+
+	// session 中验证的时间  unix
+	SESSION = "totp_verified_at"
+
+	// session 中验证的 subject  和当前的 subject 不同时需要重新验证
+	SESSION_SUBJECT = "totp_verified_subject"
+
+	// 恢复码的数量
+	RecoveryCodes = 10
+
+	Model = &mgoModel.Model{
+		Name:     "totp",
+		Document: &Factor{},
+	}
+
+	ErrRequired = &errs.Error{
+		Message:    "Two-factor authentication is required",
+		Type:       "totp",
+		StatusCode: http.StatusUnauthorized,
+		Params: map[string]interface{}{
+			"totp": true,
+		},
+	}
+	ErrInvalid = &errs.Error{
+		Message:    "Invalid two-factor authentication code",
+		Type:       "totp",
+		StatusCode: http.StatusUnauthorized,
+	}
+	ErrNotEnrolled = &errs.Error{
+		Message:    "Two-factor authentication is not enabled",
+		Type:       "totp",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrEnabled = &errs.Error{
+		Message:    "Two-factor authentication is already enabled",
+		Type:       "totp",
+		StatusCode: http.StatusConflict,
+	}
+	ErrLocked = &errs.Error{
+		Message:    "Too many failed two-factor authentication attempts",
+		Type:       "totp",
+		StatusCode: http.StatusTooManyRequests,
+	}
+	ErrSubject = &errs.Error{
+		Message:    "You are not logged in",
+		Type:       "totp",
+		StatusCode: http.StatusUnauthorized,
+	}
+	ErrSession = &errs.Error{
+		Message:    "Two-factor authentication requires sessions",
+		Type:       "totp",
+		StatusCode: http.StatusInternalServerError,
+	}
+)
+
+// Middleware Routes 中的路由需要 session 中已经验证
+func Middleware(c Config) gin.HandlerFunc {
+	c.defaults()
+	routes := map[string]bool{}
+	for _, name := range c.Routes {
+		routes[name] = true
+	}
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, c)
+		if len(routes) == 0 {
+			ctx.Next()
+			return
+		}
+		val, ok := ctx.Get(ginResource.CONTEXT)
+		if !ok || val == nil {
+			ctx.Next()
+			return
+		}
+		resource := val.(*ginResource.Resource)
+		if !routes[resource.Name()] && !routes[resource.Type] {
+			ctx.Next()
+			return
+		}
+		if !Verified(ctx) {
+			ctx.Error(ErrRequired)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func (c *Config) defaults() {
+	if c.Skew == 0 {
+		c.Skew = 1
+	}
+	if c.Subject == nil {
+		c.Subject = audit.Actor
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = 5
+	}
+	if c.Lockout == 0 {
+		c.Lockout = time.Minute * 15
+	}
+}
+
+func config(ctx *gin.Context) Config {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(Config)
+	}
+	c := Config{}
+	c.defaults()
+	return c
+}
+
+// Verified session 中当前 subject 已经验证并且没有超过 MaxAge
+func Verified(ctx *gin.Context) bool {
+	session := sessions.Get(ctx)
+	if session == nil {
+		return false
+	}
+	c := config(ctx)
+	subject := c.Subject(ctx)
+	if subject == "" || session.GetString(SESSION_SUBJECT) != subject {
+		return false
+	}
+	var at int64
+	switch val := session.Get(SESSION).(type) {
+	case int64:
+		at = val
+	case int:
+		at = int64(val)
+	case float64:
+		at = int64(val)
+	default:
+		return false
+	}
+	if maxAge := c.MaxAge; maxAge > 0 && time.Since(time.Unix(at, 0)) > maxAge {
+		return false
+	}
+	return true
+}
+
+// Enroll 生成新的 secret  确认之前不启用  已启用时需要先 Disable
+func Enroll(ctx *gin.Context) (secret string, uri string, err error) {
+	c := config(ctx)
+	subject := c.Subject(ctx)
+	if subject == "" {
+		err = ErrSubject
+		return
+	}
+	var enabled bool
+	if enabled, err = Enabled(ctx); err != nil {
+		return
+	}
+	if enabled {
+		err = ErrEnabled
+		return
+	}
+	if secret, err = GenerateSecret(); err != nil {
+		return
+	}
+	now := time.Now()
+	if _, err = mongoMiddleware.C(ctx, Model.Name).UpsertId(subject, &Factor{
+		ID:        subject,
+		Secret:    secret,
+		CreatedAt: &now,
+	}); err != nil {
+		return
+	}
+	uri = URI(c.Issuer, subject, secret)
+	return
+}
+
+// Confirm 第一次验证码正确后启用  返回明文恢复码  只显示一次
+func Confirm(ctx *gin.Context, value string) (codes []string, err error) {
+	c := config(ctx)
+	factor, err := find(ctx)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if c.locked(factor, now) {
+		err = ErrLocked
+		return
+	}
+	ok, matched, err := Validate(factor.Secret, value, now, c.Skew)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = c.fail(ctx, factor, now)
+		return
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return
+	}
+	if err = mongoMiddleware.C(ctx, Model.Name).UpdateId(factor.ID, bson.M{
+		"$set": bson.M{
+			"enabled":         true,
+			"enabled_at":      now,
+			"last_counter":    matched,
+			"recovery_codes":  hashes,
+			"failed_attempts": 0,
+		},
+		"$unset": bson.M{"locked_until": ""},
+	}); err != nil {
+		return
+	}
+	err = markVerified(ctx, factor.ID)
+	return
+}
+
+// Verify 验证码或恢复码  恢复码使用后删除  成功后写入 session
+// 连续失败 MaxAttempts 次后锁定 Lockout  锁定时不验证
+func Verify(ctx *gin.Context, value string) error {
+	c := config(ctx)
+	factor, err := find(ctx)
+	if err != nil {
+		return err
+	}
+	if !factor.Enabled {
+		return ErrNotEnrolled
+	}
+	now := time.Now()
+	if c.locked(factor, now) {
+		return ErrLocked
+	}
+	ok, matched, err := Validate(factor.Secret, value, now, c.Skew)
+	if err != nil {
+		return err
+	}
+	collection := mongoMiddleware.C(ctx, Model.Name)
+	reset := bson.M{"locked_until": ""}
+	if ok {
+		// 同一个验证码不能使用两次
+		if err = collection.Update(bson.M{
+			"_id":          factor.ID,
+			"last_counter": bson.M{"$lt": matched},
+		}, bson.M{
+			"$set":   bson.M{"last_counter": matched, "failed_attempts": 0},
+			"$unset": reset,
+		}); err != nil {
+			if err == mgo.ErrNotFound {
+				return c.fail(ctx, factor, now)
+			}
+			return err
+		}
+		return markVerified(ctx, factor.ID)
+	}
+
+	hash := hashRecoveryCode(value)
+	if err = collection.Update(bson.M{
+		"_id":            factor.ID,
+		"recovery_codes": hash,
+	}, bson.M{
+		"$pull":  bson.M{"recovery_codes": hash},
+		"$set":   bson.M{"failed_attempts": 0},
+		"$unset": reset,
+	}); err != nil {
+		if err == mgo.ErrNotFound {
+			return c.fail(ctx, factor, now)
+		}
+		return err
+	}
+	return markVerified(ctx, factor.ID)
+}
+
+// locked 锁定中
+func (c Config) locked(factor *Factor, now time.Time) bool {
+	return c.MaxAttempts > 0 && factor.LockedUntil != nil && now.Before(*factor.LockedUntil)
+}
+
+// fail 记录一次失败  达到 MaxAttempts 时锁定并重新计数  返回 ErrInvalid 或 ErrLocked
+func (c Config) fail(ctx *gin.Context, factor *Factor, now time.Time) error {
+	if c.MaxAttempts <= 0 {
+		return ErrInvalid
+	}
+	collection := mongoMiddleware.C(ctx, Model.Name)
+	result := &Factor{}
+	if _, err := collection.FindId(factor.ID).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"failed_attempts": 1}},
+		ReturnNew: true,
+	}, result); err != nil {
+		if err == mgo.ErrNotFound {
+			return ErrInvalid
+		}
+		return err
+	}
+	if result.FailedAttempts < c.MaxAttempts {
+		return ErrInvalid
+	}
+	if err := collection.UpdateId(factor.ID, bson.M{
+		"$set": bson.M{
+			"failed_attempts": 0,
+			"locked_until":    now.Add(c.Lockout),
+		},
+	}); err != nil {
+		return err
+	}
+	return ErrLocked
+}
+
+// RegenerateRecoveryCodes 旧的全部失效
+func RegenerateRecoveryCodes(ctx *gin.Context) (codes []string, err error) {
+	factor, err := find(ctx)
+	if err != nil {
+		return
+	}
+	if !factor.Enabled {
+		err = ErrNotEnrolled
+		return
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return
+	}
+	err = mongoMiddleware.C(ctx, Model.Name).UpdateId(factor.ID, bson.M{
+		"$set": bson.M{"recovery_codes": hashes},
+	})
+	return
+}
+
+// Disable 删除 secret 和恢复码
+func Disable(ctx *gin.Context) error {
+	subject := config(ctx).Subject(ctx)
+	if subject == "" {
+		return ErrSubject
+	}
+	if err := mongoMiddleware.C(ctx, Model.Name).RemoveId(subject); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	if session := sessions.Get(ctx); session != nil {
+		session.Delete(SESSION)
+		session.Delete(SESSION_SUBJECT)
+	}
+	return nil
+}
+
+// Enabled 当前 subject 是否启用
+func Enabled(ctx *gin.Context) (bool, error) {
+	factor, err := find(ctx)
+	if err == ErrNotEnrolled {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return factor.Enabled, nil
+}
+
+func find(ctx *gin.Context) (*Factor, error) {
+	subject := config(ctx).Subject(ctx)
+	if subject == "" {
+		return nil, ErrSubject
+	}
+	factor := &Factor{}
+	if err := mongoMiddleware.C(ctx, Model.Name).FindId(subject).One(factor); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrNotEnrolled
+		}
+		return nil, err
+	}
+	return factor, nil
+}
+
+func markVerified(ctx *gin.Context, subject string) error {
+	session := sessions.Get(ctx)
+	if session == nil {
+		return ErrSession
+	}
+	session.Set(SESSION, time.Now().Unix())
+	session.Set(SESSION_SUBJECT, subject)
+	return nil
+}
+
+// generateRecoveryCodes xxxxx-xxxxx
+func generateRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < RecoveryCodes; i++ {
+		b := make([]byte, 5)
+		if _, err = rand.Read(b); err != nil {
+			return
+		}
+		value := hex.EncodeToString(b)
+		code := value[:5] + "-" + value[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
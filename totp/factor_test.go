@@ -0,0 +1,86 @@
+package totp
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/sessions"
+)
+
+func testContext(subject string, values map[string]interface{}) *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c := Config{
+		Subject: func(ctx *gin.Context) string {
+			return subject
+		},
+	}
+	c.defaults()
+	ctx.Set(CONTEXT, c)
+	ctx.Set(sessions.CONTEXT, &sessions.Session{Values: values})
+	return ctx
+}
+
+func TestVerifiedSubject(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name    string
+		subject string
+		values  map[string]interface{}
+		want    bool
+	}{
+		{"same subject", "alice", map[string]interface{}{SESSION: now, SESSION_SUBJECT: "alice"}, true},
+		{"other subject", "bob", map[string]interface{}{SESSION: now, SESSION_SUBJECT: "alice"}, false},
+		{"no subject in session", "alice", map[string]interface{}{SESSION: now}, false},
+		{"logged out", "", map[string]interface{}{SESSION: now, SESSION_SUBJECT: ""}, false},
+		{"not verified", "alice", map[string]interface{}{}, false},
+	}
+	for _, test := range tests {
+		if got := Verified(testContext(test.subject, test.values)); got != test.want {
+			t.Errorf("%s: Verified() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMarkVerified(t *testing.T) {
+	ctx := testContext("alice", map[string]interface{}{})
+	if err := markVerified(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if !Verified(ctx) {
+		t.Fatal("not verified after markVerified")
+	}
+	ctx.Set(CONTEXT, Config{
+		Subject: func(ctx *gin.Context) string {
+			return "bob"
+		},
+	})
+	if Verified(ctx) {
+		t.Fatal("verified for another subject")
+	}
+}
+
+func TestLocked(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Minute)
+	past := now.Add(-time.Minute)
+	c := Config{}
+	c.defaults()
+	if c.MaxAttempts != 5 || c.Lockout != time.Minute*15 {
+		t.Fatalf("defaults %d %s", c.MaxAttempts, c.Lockout)
+	}
+	if !c.locked(&Factor{LockedUntil: &future}, now) {
+		t.Error("not locked before LockedUntil")
+	}
+	if c.locked(&Factor{LockedUntil: &past}, now) {
+		t.Error("locked after LockedUntil")
+	}
+	if c.locked(&Factor{}, now) {
+		t.Error("locked without LockedUntil")
+	}
+	c.MaxAttempts = -1
+	if c.locked(&Factor{LockedUntil: &future}, now) {
+		t.Error("locked with MaxAttempts -1")
+	}
+}
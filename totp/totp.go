@@ -0,0 +1,100 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RFC 6238  SHA1  和 Google Authenticator 兼容
+const (
+	Digits = 6
+	Period = 30
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret 20 字节  base32 无填充
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URI otpauth://totp/issuer:account  生成二维码的内容
+func URI(issuer string, account string, secret string) string {
+	label := url.PathEscape(account)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+	query := url.Values{}
+	query.Set("secret", secret)
+	if issuer != "" {
+		query.Set("issuer", issuer)
+	}
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(Digits))
+	query.Set("period", strconv.Itoa(Period))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Code t 时的验证码
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, counter(t)), nil
+}
+
+// Validate 允许前后 skew 个周期  返回匹配的计数  用于防止重放
+func Validate(secret string, value string, t time.Time, skew int) (ok bool, matched int64, err error) {
+	key, err := decode(secret)
+	if err != nil {
+		return
+	}
+	value = strings.Replace(strings.TrimSpace(value), " ", "", -1)
+	if len(value) != Digits {
+		return
+	}
+	current := counter(t)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, current+i)), []byte(value)) == 1 {
+			return true, current + i, nil
+		}
+	}
+	return
+}
+
+func decode(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(strings.TrimSpace(secret), " ", "", -1))
+	return encoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+func counter(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+func code(key []byte, counter int64) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(b)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	s := strconv.FormatUint(uint64(value%1000000), 10)
+	for len(s) < Digits {
+		s = "0" + s
+	}
+	return s
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"time"
+
+	"github.com/otamoe/gin-server/tracing"
+)
+
+type (
+	Tracing struct {
+		// zipkin jaeger otlp
+		Exporter string            `json:"exporter,omitempty"`
+		Endpoint string            `json:"endpoint,omitempty"`
+		Headers  map[string]string `json:"headers,omitempty"`
+
+		// 0 - 1  默认 1
+		Sampler       float64       `json:"sampler,omitempty"`
+		FlushInterval time.Duration `json:"flush_interval,omitempty"`
+
+		tracer *tracing.Tracer
+	}
+)
+
+func (config *Tracing) init(server *Server, handler *Handler) {
+	if config.Sampler == 0 {
+		config.Sampler = 1
+	}
+	if config.tracer != nil {
+		return
+	}
+	exporter, err := tracing.NewExporter(config.Exporter, config.Endpoint, config.Headers)
+	if err != nil {
+		panic(err)
+	}
+	config.tracer = tracing.New(tracing.Config{
+		Service:       server.Name,
+		Exporter:      exporter,
+		Sampler:       config.Sampler,
+		FlushInterval: config.FlushInterval,
+	})
+}
+
+func (config *Tracing) Get() *tracing.Tracer {
+	return config.tracer
+}
@@ -0,0 +1,163 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Zipkin v2 json  jaeger collector 也支持 /api/v2/spans
+	Zipkin struct {
+		Endpoint string
+		Headers  map[string]string
+		Client   *http.Client
+	}
+
+	// OTLP http json /v1/traces
+	OTLP struct {
+		Endpoint string
+		Headers  map[string]string
+		Client   *http.Client
+	}
+)
+
+// NewExporter 根据名字创建
+func NewExporter(name string, endpoint string, headers map[string]string) (Exporter, error) {
+	switch strings.ToLower(name) {
+	case "zipkin":
+		if endpoint == "" {
+			endpoint = "http://127.0.0.1:9411/api/v2/spans"
+		}
+		return &Zipkin{Endpoint: endpoint, Headers: headers}, nil
+	case "jaeger":
+		if endpoint == "" {
+			endpoint = "http://127.0.0.1:9411/api/v2/spans"
+		}
+		return &Zipkin{Endpoint: endpoint, Headers: headers}, nil
+	case "otlp":
+		if endpoint == "" {
+			endpoint = "http://127.0.0.1:4318/v1/traces"
+		}
+		return &OTLP{Endpoint: endpoint, Headers: headers}, nil
+	}
+	return nil, errors.New("Tracing: unknown exporter " + name)
+}
+
+func (exporter *Zipkin) Export(ctx context.Context, service string, spans []*Span) error {
+	var body []map[string]interface{}
+	for _, span := range spans {
+		tags := map[string]string{}
+		for key, val := range span.Attributes {
+			tags[key] = val
+		}
+		if span.Error != "" {
+			tags["error"] = span.Error
+		}
+		item := map[string]interface{}{
+			"traceId":       span.TraceIDHex(),
+			"id":            span.SpanIDHex(),
+			"name":          span.Name,
+			"kind":          span.Kind,
+			"timestamp":     span.Start.UnixNano() / int64(time.Microsecond),
+			"duration":      int64(span.End.Sub(span.Start) / time.Microsecond),
+			"localEndpoint": map[string]string{"serviceName": service},
+			"tags":          tags,
+		}
+		if parentID := span.ParentIDHex(); parentID != "" {
+			item["parentId"] = parentID
+		}
+		body = append(body, item)
+	}
+	return post(ctx, exporter.Client, exporter.Endpoint, exporter.Headers, body)
+}
+
+func (exporter *OTLP) Export(ctx context.Context, service string, spans []*Span) error {
+	var items []map[string]interface{}
+	for _, span := range spans {
+		var attributes []map[string]interface{}
+		for key, val := range span.Attributes {
+			attributes = append(attributes, map[string]interface{}{
+				"key":   key,
+				"value": map[string]string{"stringValue": val},
+			})
+		}
+		kind := 2
+		if span.Kind == KindClient {
+			kind = 3
+		}
+		status := map[string]interface{}{}
+		if span.Error != "" {
+			status["code"] = 2
+			status["message"] = span.Error
+		}
+		item := map[string]interface{}{
+			"traceId":           span.TraceIDHex(),
+			"spanId":            span.SpanIDHex(),
+			"name":              span.Name,
+			"kind":              kind,
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        attributes,
+			"status":            status,
+		}
+		if parentID := span.ParentIDHex(); parentID != "" {
+			item["parentSpanId"] = parentID
+		}
+		items = append(items, item)
+	}
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []interface{}{
+						map[string]interface{}{
+							"key":   "service.name",
+							"value": map[string]string{"stringValue": service},
+						},
+					},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/otamoe/gin-server/tracing"},
+						"spans": items,
+					},
+				},
+			},
+		},
+	}
+	return post(ctx, exporter.Client, exporter.Endpoint, exporter.Headers, body)
+}
+
+func post(ctx context.Context, client *http.Client, endpoint string, headers map[string]string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return errors.New("Tracing: export " + res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/logger"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+var CONTEXT = "GIN.SERVER.TRACING"
+
+func Middleware(tracer *Tracer) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		span := tracer.Start(Extract(ctx.Request.Header), ctx.Request.Method, KindServer)
+		ctx.Set(CONTEXT, span)
+		ctx.Set(errs.CONTEXT_REQUEST_ID, span.TraceIDHex())
+
+		if val, ok := ctx.Get(logger.CONTEXT); ok && val != nil {
+			if log, ok := val.(*logger.Logger); ok {
+				log.Fields["trace_id"] = span.TraceIDHex()
+			}
+		}
+
+		ctx.Next()
+
+		if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+			resource := val.(*ginResource.Resource)
+			resource.Pre()
+			if name := resource.Name(); name != "" {
+				span.Name = name
+			}
+		}
+		status := ctx.Writer.Status()
+		span.Set("http.method", ctx.Request.Method)
+		span.Set("http.host", ctx.Request.Host)
+		span.Set("http.target", ctx.Request.URL.Path)
+		span.Set("http.status_code", strconv.Itoa(status))
+		span.Set("http.client_ip", clientip.ClientIP(ctx))
+		if len(ctx.Errors) != 0 {
+			span.SetError(ctx.Errors.Last())
+		} else if status >= http.StatusInternalServerError {
+			span.Error = http.StatusText(status)
+		}
+		span.Finish()
+	}
+}
+
+func Get(ctx *gin.Context) *Span {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Span)
+	}
+	return nil
+}
+
+// StartSpan 子 span  没有 tracing 中间件时返回不会上报的 span
+func StartSpan(ctx *gin.Context, name string) *Span {
+	parent := Get(ctx)
+	if parent == nil || parent.tracer == nil {
+		return &Span{Name: name, Attributes: map[string]string{}}
+	}
+	return parent.tracer.Start(parent, name, KindClient)
+}
@@ -0,0 +1,282 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	Config struct {
+		Service string
+
+		// zipkin jaeger otlp
+		Exporter Exporter
+
+		// 0 - 1  有父 span 时跟随父的采样
+		Sampler float64
+
+		BatchSize     int
+		QueueSize     int
+		FlushInterval time.Duration
+	}
+
+	Exporter interface {
+		Export(ctx context.Context, service string, spans []*Span) error
+	}
+
+	Tracer struct {
+		config Config
+		queue  chan *Span
+
+		mutex  sync.Mutex
+		closed bool
+		flush  chan chan struct{}
+		done   chan struct{}
+	}
+
+	Span struct {
+		tracer *Tracer
+
+		TraceID    [16]byte
+		SpanID     [8]byte
+		ParentID   [8]byte
+		Sampled    bool
+		Name       string
+		Kind       string
+		Start      time.Time
+		End        time.Time
+		Error      string
+		Attributes map[string]string
+
+		mutex sync.Mutex
+		ended bool
+	}
+)
+
+const (
+	KindServer = "SERVER"
+	KindClient = "CLIENT"
+)
+
+var ErrClosed = errors.New("Tracing: tracer closed")
+
+func New(c Config) *Tracer {
+	if c.Exporter == nil {
+		panic("Tracing: exporter is empty")
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 512
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = 4096
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = time.Second * 5
+	}
+	tracer := &Tracer{
+		config: c,
+		queue:  make(chan *Span, c.QueueSize),
+		flush:  make(chan chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go tracer.run()
+	return tracer
+}
+
+// Start parent 可以为 nil
+func (tracer *Tracer) Start(parent *Span, name string, kind string) *Span {
+	span := &Span{
+		tracer:     tracer,
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: map[string]string{},
+	}
+	rand.Read(span.SpanID[:])
+	if parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		span.Sampled = parent.Sampled
+	} else {
+		rand.Read(span.TraceID[:])
+		span.Sampled = tracer.sample(span.TraceID)
+	}
+	return span
+}
+
+// Shutdown 发送剩余 span
+func (tracer *Tracer) Shutdown(ctx context.Context) error {
+	tracer.mutex.Lock()
+	if tracer.closed {
+		tracer.mutex.Unlock()
+		return ErrClosed
+	}
+	tracer.closed = true
+	close(tracer.queue)
+	tracer.mutex.Unlock()
+
+	select {
+	case <-tracer.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush 立即发送
+func (tracer *Tracer) Flush() {
+	wait := make(chan struct{})
+	select {
+	case tracer.flush <- wait:
+		<-wait
+	case <-tracer.done:
+	}
+}
+
+func (tracer *Tracer) sample(traceID [16]byte) bool {
+	switch {
+	case tracer.config.Sampler >= 1:
+		return true
+	case tracer.config.Sampler <= 0:
+		return false
+	}
+	// 根据 trace id 决定  多个服务结果一致
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>1) < tracer.config.Sampler*float64(uint64(1)<<63)
+}
+
+func (tracer *Tracer) enqueue(span *Span) {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	if tracer.closed {
+		return
+	}
+	select {
+	case tracer.queue <- span:
+	default:
+		// 队列满 丢弃
+	}
+}
+
+func (tracer *Tracer) run() {
+	defer close(tracer.done)
+	ticker := time.NewTicker(tracer.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		tracer.config.Exporter.Export(ctx, tracer.config.Service, batch)
+		cancel()
+		batch = nil
+	}
+	for {
+		select {
+		case span, ok := <-tracer.queue:
+			if !ok {
+				export()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= tracer.config.BatchSize {
+				export()
+			}
+		case wait := <-tracer.flush:
+			for len(tracer.queue) != 0 {
+				batch = append(batch, <-tracer.queue)
+			}
+			export()
+			close(wait)
+		case <-ticker.C:
+			export()
+		}
+	}
+}
+
+func (span *Span) Set(key string, value string) {
+	span.mutex.Lock()
+	span.Attributes[key] = value
+	span.mutex.Unlock()
+}
+
+func (span *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	span.mutex.Lock()
+	span.Error = err.Error()
+	span.mutex.Unlock()
+}
+
+func (span *Span) Finish() {
+	span.mutex.Lock()
+	if span.ended {
+		span.mutex.Unlock()
+		return
+	}
+	span.ended = true
+	span.End = time.Now()
+	span.mutex.Unlock()
+	if span.Sampled && span.tracer != nil {
+		span.tracer.enqueue(span)
+	}
+}
+
+func (span *Span) TraceIDHex() string {
+	return hex.EncodeToString(span.TraceID[:])
+}
+
+func (span *Span) SpanIDHex() string {
+	return hex.EncodeToString(span.SpanID[:])
+}
+
+func (span *Span) ParentIDHex() string {
+	if span.ParentID == [8]byte{} {
+		return ""
+	}
+	return hex.EncodeToString(span.ParentID[:])
+}
+
+// Traceparent w3c trace context
+func (span *Span) Traceparent() string {
+	flags := "00"
+	if span.Sampled {
+		flags = "01"
+	}
+	return "00-" + span.TraceIDHex() + "-" + span.SpanIDHex() + "-" + flags
+}
+
+// Inject 写入请求头 用于调用下游
+func (span *Span) Inject(header http.Header) {
+	header.Set("traceparent", span.Traceparent())
+}
+
+// Extract 解析 traceparent  失败返回 nil
+func Extract(header http.Header) *Span {
+	parts := strings.Split(strings.TrimSpace(header.Get("traceparent")), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return nil
+	}
+	span := &Span{}
+	if _, err := hex.Decode(span.TraceID[:], []byte(parts[1])); err != nil || span.TraceID == [16]byte{} {
+		return nil
+	}
+	if _, err := hex.Decode(span.SpanID[:], []byte(parts[2])); err != nil || span.SpanID == [8]byte{} {
+		return nil
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return nil
+	}
+	span.Sampled = flags[0]&1 == 1
+	return span
+}
@@ -0,0 +1,182 @@
+package ttl
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/migrate"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+)
+
+type (
+	// Collection 临时数据  例如验证码  临时 token  文档在 expires_at 之后由 mongo 删除
+	Collection struct {
+		Name string
+		TTL  time.Duration
+	}
+
+	Document struct {
+		Key       string      `bson:"_id"`
+		Value     interface{} `bson:"value"`
+		ExpiresAt time.Time   `bson:"expires_at"`
+		CreatedAt time.Time   `bson:"created_at"`
+	}
+
+	raw struct {
+		Value bson.Raw `bson:"value"`
+	}
+)
+
+var ErrNotFound = &errs.Error{
+	Message:    "Not found or expired",
+	Type:       "ttl",
+	StatusCode: http.StatusNotFound,
+}
+
+var (
+	mutex       sync.Mutex
+	collections = map[string]*Collection{}
+)
+
+// Declare 注册集合  ttl 索引由 migrate 在 version 创建
+func Declare(name string, version int64, ttl time.Duration) *Collection {
+	if ttl <= 0 {
+		panic("TTL: " + name + " ttl must be greater than 0")
+	}
+	mutex.Lock()
+	if _, ok := collections[name]; ok {
+		mutex.Unlock()
+		panic("TTL: " + name + " has exists")
+	}
+	collection := &Collection{
+		Name: name,
+		TTL:  ttl,
+	}
+	collections[name] = collection
+	mutex.Unlock()
+
+	migrate.Register(&migrate.Migration{
+		Version: version,
+		Name:    "ttl " + name,
+		Up: func(ctx context.Context, env *migrate.Env) error {
+			return Index(env.Mongo.DB("").C(env.CollectionPrefix + name))
+		},
+		Down: func(ctx context.Context, env *migrate.Env) error {
+			return env.Mongo.DB("").C(env.CollectionPrefix + name).DropIndexName("expires_at_ttl")
+		},
+	})
+	return collection
+}
+
+// Collections 已注册的
+func Collections() (values []*Collection) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, val := range collections {
+		values = append(values, val)
+	}
+	return
+}
+
+// Index expireAfterSeconds 为 0  按每个文档的 expires_at 过期
+func Index(collection *mgo.Collection) error {
+	return collection.Database.Run(bson.D{
+		{Name: "createIndexes", Value: collection.Name},
+		{Name: "indexes", Value: []bson.M{
+			bson.M{
+				"key":                bson.M{"expires_at": 1},
+				"name":               "expires_at_ttl",
+				"expireAfterSeconds": 0,
+				"background":         true,
+			},
+		}},
+	}, nil)
+}
+
+// C 当前请求的集合  有前缀
+func (collection *Collection) C(ctx *gin.Context) *mgo.Collection {
+	return mongoMiddleware.C(ctx, collection.Name)
+}
+
+// Set 覆盖  ttl 为 0 时使用 TTL
+func (collection *Collection) Set(ctx *gin.Context, key string, value interface{}, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = collection.TTL
+	}
+	now := time.Now()
+	_, err := collection.C(ctx).UpsertId(key, &Document{
+		Key:       key,
+		Value:     value,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	return err
+}
+
+// Get 解析到 value  mongo 每分钟清理一次  所以需要检查 expires_at
+func (collection *Collection) Get(ctx *gin.Context, key string, value interface{}) error {
+	document := &raw{}
+	err := collection.C(ctx).Find(bson.M{
+		"_id":        key,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).One(document)
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return unmarshal(document, value)
+}
+
+// Take 读取并删除  用于一次性的验证码 token
+func (collection *Collection) Take(ctx *gin.Context, key string, value interface{}) error {
+	document := &raw{}
+	_, err := collection.C(ctx).Find(bson.M{
+		"_id":        key,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Apply(mgo.Change{Remove: true}, document)
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return unmarshal(document, value)
+}
+
+func (collection *Collection) Delete(ctx *gin.Context, key string) error {
+	err := collection.C(ctx).RemoveId(key)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// Touch 延长过期时间
+func (collection *Collection) Touch(ctx *gin.Context, key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = collection.TTL
+	}
+	err := collection.C(ctx).Update(bson.M{
+		"_id":        key,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, bson.M{"$set": bson.M{"expires_at": time.Now().Add(ttl)}})
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func unmarshal(document *raw, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	return document.Value.Unmarshal(value)
+}
@@ -0,0 +1,385 @@
+package uploads
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	// ImageConfig 上传图片后按 EXIF 方向旋转  去掉 EXIF  生成缩略图
+	ImageConfig struct {
+		// 名字 => 尺寸  例如 thumb small
+		Variants map[string]*Variant
+
+		// 原图也重新编码  去掉 EXIF GPS 等  gif 只保留第一帧
+		Strip bool
+
+		// jpeg 质量  默认 85
+		Quality int
+
+		// 像素上限  避免解压炸弹  默认 50000000
+		MaxPixels int
+	}
+
+	Variant struct {
+		// 只缩小  0 为按比例
+		Width  int
+		Height int
+
+		// contain 在范围内  cover 填满后居中裁剪  默认 contain
+		Fit string
+
+		// jpeg png  默认和原图相同  gif 使用 png
+		Format string
+	}
+)
+
+var ErrImage = &errs.Error{
+	Message:    "Image can not be decoded",
+	Type:       "upload",
+	StatusCode: http.StatusUnprocessableEntity,
+}
+
+var ErrImageTooLarge = &errs.Error{
+	Message:    "Image is too large",
+	Type:       "upload",
+	StatusCode: http.StatusRequestEntityTooLarge,
+}
+
+// processImage 失败时已保存的 variants 会删除  原图由调用者删除
+func processImage(c *ImageConfig, storage Storage, file *File) (err error) {
+	switch file.ContentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil
+	}
+	quality := c.Quality
+	if quality == 0 {
+		quality = 85
+	}
+	maxPixels := c.MaxPixels
+	if maxPixels == 0 {
+		maxPixels = 50000000
+	}
+
+	reader, err := storage.Open(file)
+	if err != nil {
+		return
+	}
+	data, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ErrImage
+	}
+	if config.Width*config.Height > maxPixels {
+		return ErrImageTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return ErrImage
+	}
+	if format == "jpeg" {
+		src = orient(src, jpegOrientation(data))
+	}
+	bounds := src.Bounds()
+
+	var saved []*File
+	defer func() {
+		if err != nil {
+			for _, val := range saved {
+				storage.Delete(val)
+			}
+		}
+	}()
+
+	if c.Strip {
+		var stripped *File
+		if stripped, err = saveImage(storage, file, "", src, format, quality); err != nil {
+			return
+		}
+		saved = append(saved, stripped)
+	}
+
+	variants := map[string]*File{}
+	for name, variant := range c.Variants {
+		width, height := variant.size(bounds.Dx(), bounds.Dy())
+		var dst image.Image = src
+		if width != bounds.Dx() || height != bounds.Dy() {
+			dst = resize(src, width, height, variant.Fit == "cover")
+		}
+		outFormat := variant.Format
+		if outFormat == "" {
+			outFormat = format
+		}
+		var val *File
+		if val, err = saveImage(storage, file, name, dst, outFormat, quality); err != nil {
+			return
+		}
+		saved = append(saved, val)
+		variants[name] = val
+	}
+
+	// 重新编码的代替原图
+	if c.Strip {
+		stripped := saved[0]
+		storage.Delete(file)
+		file.ID, file.Key, file.Size, file.SHA256, file.ContentType = stripped.ID, stripped.Key, stripped.Size, stripped.SHA256, stripped.ContentType
+	}
+	file.Width, file.Height = bounds.Dx(), bounds.Dy()
+	if len(variants) != 0 {
+		file.Variants = variants
+	}
+	return nil
+}
+
+// size contain 时在 Width Height 范围内  cover 时为 Width Height
+func (variant *Variant) size(width int, height int) (int, int) {
+	w, h := variant.Width, variant.Height
+	if w == 0 && h == 0 {
+		return width, height
+	}
+	if w == 0 {
+		w = width * h / height
+	}
+	if h == 0 {
+		h = height * w / width
+	}
+	if variant.Fit != "cover" {
+		if width*h > height*w {
+			h = height * w / width
+		} else {
+			w = width * h / height
+		}
+	}
+	if w > width || h > height {
+		// 不放大
+		if variant.Fit == "cover" {
+			scale := float64(width) / float64(w)
+			if s := float64(height) / float64(h); s < scale {
+				scale = s
+			}
+			w, h = int(float64(w)*scale), int(float64(h)*scale)
+		} else {
+			w, h = width, height
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+func saveImage(storage Storage, original *File, variant string, img image.Image, format string, quality int) (*File, error) {
+	var buf bytes.Buffer
+	var err error
+	contentType := "image/png"
+	switch format {
+	case "jpeg", "jpg":
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+		contentType = "image/gif"
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ext := ".png"
+	switch contentType {
+	case "image/jpeg":
+		ext = ".jpg"
+	case "image/gif":
+		ext = ".gif"
+	}
+	name := strings.TrimSuffix(original.Name, path.Ext(original.Name))
+	if variant != "" {
+		name += "_" + variant
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	now := time.Now()
+	bounds := img.Bounds()
+	file := &File{
+		ID:          bson.NewObjectId(),
+		Storage:     storage.Name(),
+		Field:       original.Field,
+		Name:        name + ext,
+		ContentType: contentType,
+		Size:        int64(buf.Len()),
+		SHA256:      hex.EncodeToString(sum[:]),
+		Scan:        original.Scan,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		CreatedAt:   &now,
+	}
+	if err = storage.Save(file, &buf); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// resize 区域平均  只用于缩小  cover 时先居中裁剪到目标比例
+func resize(src image.Image, width int, height int, cover bool) image.Image {
+	bounds := src.Bounds()
+	if cover {
+		sw, sh := bounds.Dx(), bounds.Dy()
+		if sw*height > sh*width {
+			cw := sh * width / height
+			bounds.Min.X += (sw - cw) / 2
+			bounds.Max.X = bounds.Min.X + cw
+		} else {
+			ch := sw * height / width
+			bounds.Min.Y += (sh - ch) / 2
+			bounds.Max.Y = bounds.Min.Y + ch
+		}
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	sw, sh := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, (y+1)*sh/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, (x+1)*sw/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				i := rgba.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(rgba.Pix[i])
+					g += uint64(rgba.Pix[i+1])
+					b += uint64(rgba.Pix[i+2])
+					a += uint64(rgba.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// orient EXIF Orientation 2 - 8
+func orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation APP1 Exif 中 IFD0 的 0x0112  没有时为 1
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return 1
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xda || length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
@@ -0,0 +1,323 @@
+package uploads
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/clientip"
+	"github.com/otamoe/gin-server/errs"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	mgoModel "github.com/otamoe/mgo-model"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// Scanner 扫描保存后的文件  threat 不为空表示感染
+	Scanner interface {
+		Name() string
+		Scan(ctx context.Context, file *File, reader io.Reader) (threat string, err error)
+	}
+
+	ScanConfig struct {
+		Scanner Scanner
+
+		// 扫描出错时仍然接受文件  File.Scan 为 error  默认拒绝
+		FailOpen bool
+
+		// 默认 1 分钟
+		Timeout time.Duration
+
+		// 感染的文件保留在 storage  默认删除
+		Keep bool
+
+		// 保存 Quarantine 到 mongo
+		Record bool
+
+		// 感染时调用  例如通知
+		OnThreat func(ctx *gin.Context, quarantine *Quarantine)
+
+		Logger *logrus.Logger
+	}
+
+	// Quarantine 感染的文件
+	Quarantine struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    bson.ObjectId `json:"_id" bson:"_id"`
+		File                  *File         `json:"file" bson:"file"`
+		Scanner               string        `json:"scanner" bson:"scanner"`
+		Threat                string        `json:"threat" bson:"threat"`
+		Kept                  bool          `json:"kept" bson:"kept"`
+		IP                    string        `json:"ip,omitempty" bson:"ip,omitempty"`
+		Path                  string        `json:"path,omitempty" bson:"path,omitempty"`
+		CreatedAt             *time.Time    `json:"created_at" bson:"created_at"`
+	}
+
+	// ClamAV clamd 的 INSTREAM  Addr 为 host:port 或 unix socket 路径
+	ClamAV struct {
+		Addr string
+
+		// 默认 2 MB  不超过 clamd StreamMaxLength
+		ChunkSize int
+	}
+
+	// ICAP RESPMOD  例如 c-icap squidclamav
+	ICAP struct {
+		// icap://host:1344/avscan
+		URL string
+	}
+)
+
+const (
+	ScanClean = "clean"
+	ScanError = "error"
+)
+
+var QuarantineModel = &mgoModel.Model{
+	Name:     "upload_quarantine",
+	Document: &Quarantine{},
+	Indexs: []mgo.Index{
+		mgo.Index{
+			Key:        []string{"created_at"},
+			Background: true,
+		},
+	},
+}
+
+var ErrInfected = &errs.Error{
+	Message:    "File is infected",
+	Type:       "upload",
+	StatusCode: http.StatusUnprocessableEntity,
+}
+
+var ErrScanUnavailable = &errs.Error{
+	Message:    "File scanning is unavailable",
+	Type:       "upload",
+	StatusCode: http.StatusServiceUnavailable,
+}
+
+// scan 感染时隔离  失败时按 FailOpen
+func scan(ctx *gin.Context, c *ScanConfig, storage Storage, file *File) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+
+	threat, err := func() (string, error) {
+		reader, err := storage.Open(file)
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		scanCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+		return c.Scanner.Scan(scanCtx, file, reader)
+	}()
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"scanner": c.Scanner.Name(),
+			"file":    file.ID.Hex(),
+		}).Errorf("[UPLOADS] scan %s", err)
+		if c.FailOpen {
+			file.Scan = ScanError
+			return nil
+		}
+		return ErrScanUnavailable
+	}
+	if threat == "" {
+		file.Scan = ScanClean
+		return nil
+	}
+
+	now := time.Now()
+	quarantine := &Quarantine{
+		ID:        bson.NewObjectId(),
+		File:      file,
+		Scanner:   c.Scanner.Name(),
+		Threat:    threat,
+		Kept:      c.Keep,
+		IP:        clientip.ClientIP(ctx),
+		Path:      ctx.Request.URL.Path,
+		CreatedAt: &now,
+	}
+	logger.WithFields(logrus.Fields{
+		"scanner": quarantine.Scanner,
+		"threat":  threat,
+		"file":    file.ID.Hex(),
+		"name":    file.Name,
+		"sha256":  file.SHA256,
+		"ip":      quarantine.IP,
+	}).Warn("[UPLOADS] infected file")
+	if !c.Keep {
+		storage.Delete(file)
+	}
+	if c.Record {
+		if err := mongoMiddleware.C(ctx, QuarantineModel.Name).Insert(quarantine); err != nil {
+			logger.Errorf("[UPLOADS] quarantine %s", err)
+		}
+	}
+	if c.OnThreat != nil {
+		c.OnThreat(ctx, quarantine)
+	}
+	e := ErrInfected.Clone()
+	e.Params = map[string]interface{}{"name": file.Name}
+	return e
+}
+
+func (scanner *ClamAV) Name() string {
+	return "clamav"
+}
+
+// Scan zINSTREAM  每块前 4 字节长度  0 结束
+func (scanner *ClamAV) Scan(ctx context.Context, file *File, reader io.Reader) (threat string, err error) {
+	network := "tcp"
+	if strings.HasPrefix(scanner.Addr, "/") {
+		network = "unix"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, scanner.Addr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return
+	}
+	chunkSize := scanner.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = 2 << 20
+	}
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, readErr := io.ReadFull(reader, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err = conn.Write(buf[:4+n]); err != nil {
+				return
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return "", readErr
+		}
+	}
+	if _, err = conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return
+	}
+
+	// stream: OK  stream: Eicar-Signature FOUND  INSTREAM size limit exceeded. ERROR
+	line, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return
+	}
+	line = strings.TrimSpace(strings.TrimRight(line, "\x00"))
+	switch {
+	case strings.HasSuffix(line, " OK"):
+		return "", nil
+	case strings.HasSuffix(line, " FOUND"):
+		return strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "stream:"), "FOUND")), nil
+	}
+	return "", errors.New("clamav: " + line)
+}
+
+func (scanner *ICAP) Name() string {
+	return "icap"
+}
+
+// Scan 204 为没有修改  X-Infection-Found X-Violations-Found X-Virus-ID 为感染
+func (scanner *ICAP) Scan(ctx context.Context, file *File, reader io.Reader) (threat string, err error) {
+	u, err := url.Parse(scanner.URL)
+	if err != nil {
+		return
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "1344")
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	resHeader := "HTTP/1.1 200 OK\r\nContent-Type: " + file.ContentType + "\r\nTransfer-Encoding: chunked\r\n\r\n"
+	writer := bufio.NewWriter(conn)
+	fmt.Fprintf(writer, "RESPMOD %s ICAP/1.0\r\nHost: %s\r\nAllow: 204\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n%s", u.String(), u.Host, len(resHeader), resHeader)
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := reader.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(writer, "%x\r\n", n)
+			writer.Write(buf[:n])
+			writer.WriteString("\r\n")
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return "", readErr
+		}
+	}
+	writer.WriteString("0\r\n\r\n")
+	if err = writer.Flush(); err != nil {
+		return
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	status, err := tp.ReadLine()
+	if err != nil {
+		return
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return
+	}
+	parts := strings.SplitN(status, " ", 3)
+	if len(parts) < 2 {
+		return "", errors.New("icap: " + status)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", errors.New("icap: " + status)
+	}
+	for _, name := range []string{"X-Infection-Found", "X-Violations-Found", "X-Virus-ID"} {
+		if val := header.Get(name); val != "" {
+			return val, nil
+		}
+	}
+	switch {
+	case code == 204:
+		return "", nil
+	case code == 200 || code == 403:
+		// 修改了响应  一般为拦截页面
+		return "blocked by icap", nil
+	}
+	return "", errors.New("icap: " + status)
+}
@@ -0,0 +1,95 @@
+package uploads
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/otamoe/gin-server/errs"
+)
+
+var ErrNotFound = &errs.Error{
+	Message:    "File not found",
+	Type:       "upload",
+	StatusCode: http.StatusNotFound,
+}
+
+// Serve 输出文件  支持 Range If-Range  返回 206 和 Content-Range
+// storage 返回的 reader 不支持 Seek 时输出全部内容
+func Serve(ctx *gin.Context, storage Storage, file *File) {
+	reader, err := storage.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) || err == mgo.ErrNotFound {
+			err = ErrNotFound
+		}
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	defer reader.Close()
+
+	header := ctx.Writer.Header()
+	if file.ContentType != "" {
+		header.Set("Content-Type", file.ContentType)
+	}
+	if file.Name != "" {
+		header.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": file.Name}))
+	}
+	// 内容不变  sha256 作为强 etag  If-Range 需要强 etag
+	if file.SHA256 != "" {
+		header.Set("ETag", "\""+file.SHA256+"\"")
+	}
+	var modtime time.Time
+	if file.CreatedAt != nil {
+		modtime = *file.CreatedAt
+	}
+
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(ctx.Writer, ctx.Request, file.Name, modtime, seeker)
+		return
+	}
+
+	header.Set("Accept-Ranges", "none")
+	if !modtime.IsZero() {
+		header.Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+	if file.Size != 0 {
+		header.Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	}
+	ctx.Status(http.StatusOK)
+	if ctx.Request.Method != http.MethodHead {
+		io.Copy(ctx.Writer, reader)
+	}
+}
+
+// Variant 没有时返回原图
+func (file *File) Variant(name string) *File {
+	if val, ok := file.Variants[name]; ok && val != nil {
+		return val
+	}
+	return file
+}
+
+// Handler find 返回 nil 时 404  ?variant=thumb 输出 variant
+func Handler(storage Storage, find func(ctx *gin.Context) (*File, error)) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		file, err := find(ctx)
+		if err == mgo.ErrNotFound || (err == nil && file == nil) {
+			err = ErrNotFound
+		}
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		if name := ctx.Query("variant"); name != "" {
+			file = file.Variant(name)
+		}
+		Serve(ctx, storage, file)
+	}
+}
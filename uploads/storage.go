@@ -0,0 +1,139 @@
+package uploads
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/globalsign/mgo"
+)
+
+type (
+	// Local 本地目录  按 id 前两位分目录
+	Local struct {
+		Dir string
+	}
+
+	// GridFS mongo gridfs  id 为文件 id
+	GridFS struct {
+		Session *mgo.Session
+		Prefix  string
+	}
+)
+
+func (storage *Local) Name() string {
+	return "local"
+}
+
+func (storage *Local) Save(file *File, reader io.Reader) (err error) {
+	id := file.ID.Hex()
+	file.Key = path.Join(id[len(id)-2:], id+path.Ext(file.Name))
+	name := filepath.Join(storage.Dir, filepath.FromSlash(file.Key))
+	if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return
+	}
+
+	// 先写临时文件
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".upload-")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (storage *Local) Open(file *File) (io.ReadCloser, error) {
+	if file.Key == "" {
+		return nil, errors.New("Uploads: key is empty")
+	}
+	return os.Open(filepath.Join(storage.Dir, filepath.FromSlash(path.Clean("/"+file.Key))))
+}
+
+func (storage *Local) Delete(file *File) error {
+	if file.Key == "" {
+		return nil
+	}
+	err := os.Remove(filepath.Join(storage.Dir, filepath.FromSlash(path.Clean("/"+file.Key))))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (storage *GridFS) Name() string {
+	return "gridfs"
+}
+
+func (storage *GridFS) Save(file *File, reader io.Reader) (err error) {
+	session := storage.Session.Copy()
+	defer session.Close()
+	gridFile, err := storage.gridFS(session).Create(file.Name)
+	if err != nil {
+		return
+	}
+	gridFile.SetId(file.ID)
+	gridFile.SetContentType(file.ContentType)
+	if _, err = io.Copy(gridFile, reader); err != nil {
+		gridFile.Abort()
+		gridFile.Close()
+		return
+	}
+	if err = gridFile.Close(); err != nil {
+		return
+	}
+	file.Key = file.ID.Hex()
+	return
+}
+
+func (storage *GridFS) Open(file *File) (io.ReadCloser, error) {
+	session := storage.Session.Copy()
+	gridFile, err := storage.gridFS(session).OpenId(file.ID)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &gridFSReader{GridFile: gridFile, session: session}, nil
+}
+
+func (storage *GridFS) Delete(file *File) error {
+	session := storage.Session.Copy()
+	defer session.Close()
+	err := storage.gridFS(session).RemoveId(file.ID)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (storage *GridFS) gridFS(session *mgo.Session) *mgo.GridFS {
+	prefix := storage.Prefix
+	if prefix == "" {
+		prefix = "fs"
+	}
+	return session.DB("").GridFS(prefix)
+}
+
+type gridFSReader struct {
+	*mgo.GridFile
+	session *mgo.Session
+}
+
+func (reader *gridFSReader) Close() error {
+	err := reader.GridFile.Close()
+	reader.session.Close()
+	return err
+}
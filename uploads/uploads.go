@@ -0,0 +1,305 @@
+package uploads
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/errs"
+	mongoMiddleware "github.com/otamoe/gin-server/mongo"
+	"github.com/otamoe/gin-server/sniff"
+	mgoModel "github.com/otamoe/mgo-model"
+)
+
+type (
+	Config struct {
+		Storage Storage
+
+		// 单个文件
+		MaxSize  int64
+		MaxFiles int
+
+		// 按内容识别的类型  支持 image/*  为空不限制
+		Types []string
+
+		// 默认 sniff.DefaultDangerous  即使在 Types 中也拒绝
+		Dangerous []string
+
+		// 只接受这些字段  为空不限制
+		Fields []string
+
+		// 保存 File 到 mongo
+		Record bool
+
+		// 保存后扫描  例如 ClamAV ICAP
+		Scan *ScanConfig
+
+		// 扫描后处理图片  生成 Variants
+		Images *ImageConfig
+	}
+
+	// Storage key 由 storage 生成
+	Storage interface {
+		Name() string
+		Save(file *File, reader io.Reader) error
+		Open(file *File) (io.ReadCloser, error)
+		Delete(file *File) error
+	}
+
+	File struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    bson.ObjectId    `json:"_id" bson:"_id"`
+		Storage               string           `json:"storage" bson:"storage"`
+		Key                   string           `json:"key" bson:"key"`
+		Field                 string           `json:"field,omitempty" bson:"field,omitempty"`
+		Name                  string           `json:"name" bson:"name"`
+		ContentType           string           `json:"content_type" bson:"content_type"`
+		Size                  int64            `json:"size" bson:"size"`
+		SHA256                string           `json:"sha256" bson:"sha256"`
+		Scan                  string           `json:"scan,omitempty" bson:"scan,omitempty"`
+		Width                 int              `json:"width,omitempty" bson:"width,omitempty"`
+		Height                int              `json:"height,omitempty" bson:"height,omitempty"`
+		Variants              map[string]*File `json:"variants,omitempty" bson:"variants,omitempty"`
+		CreatedAt             *time.Time       `json:"created_at" bson:"created_at"`
+	}
+
+	reader struct {
+		reader io.Reader
+		hash   hash.Hash
+		size   int64
+		limit  int64
+	}
+)
+
+var CONTEXT = "GIN.SERVER.UPLOADS"
+
+var Model = &mgoModel.Model{
+	Name:     "uploads",
+	Document: &File{},
+	Indexs: []mgo.Index{
+		mgo.Index{
+			Key:        []string{"sha256"},
+			Background: true,
+		},
+		mgo.Index{
+			Key:        []string{"created_at"},
+			Background: true,
+		},
+	},
+}
+
+var ErrMultipart = &errs.Error{
+	Message:    "Request is not multipart",
+	Type:       "upload",
+	StatusCode: http.StatusBadRequest,
+}
+
+var ErrTooLarge = &errs.Error{
+	Message:    "File is too large",
+	Type:       "upload",
+	StatusCode: http.StatusRequestEntityTooLarge,
+}
+
+var ErrTooMany = &errs.Error{
+	Message:    "Too many files",
+	Type:       "upload",
+	StatusCode: http.StatusRequestEntityTooLarge,
+}
+
+var ErrType = sniff.ErrType
+
+// Middleware 解析后的 []*File 放入 CONTEXT
+func Middleware(c Config) gin.HandlerFunc {
+	if c.Storage == nil {
+		panic("Uploads: storage is empty")
+	}
+	return func(ctx *gin.Context) {
+		files, err := Upload(ctx, c)
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		ctx.Set(CONTEXT, files)
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) []*File {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.([]*File)
+	}
+	return nil
+}
+
+// Delete 同时删除 variants
+func Delete(storage Storage, file *File) error {
+	for _, val := range file.Variants {
+		storage.Delete(val)
+	}
+	return storage.Delete(file)
+}
+
+// Upload 流式读取 multipart  失败时删除已保存的文件  非文件字段放入 PostForm
+func Upload(ctx *gin.Context, c Config) (files []*File, err error) {
+	multipartReader, err := ctx.Request.MultipartReader()
+	if err != nil {
+		return nil, ErrMultipart
+	}
+	if ctx.Request.PostForm == nil {
+		ctx.Request.PostForm = map[string][]string{}
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, file := range files {
+			Delete(c.Storage, file)
+		}
+		files = nil
+	}()
+
+	for {
+		var part *multipart.Part
+		if part, err = multipartReader.NextPart(); err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			err = ErrMultipart
+			return
+		}
+
+		if part.FileName() == "" {
+			var value bytes.Buffer
+			if _, err = io.Copy(&value, io.LimitReader(part, 1<<20)); err != nil {
+				return
+			}
+			ctx.Request.PostForm.Add(part.FormName(), value.String())
+			part.Close()
+			continue
+		}
+
+		if !allowField(c.Fields, part.FormName()) {
+			part.Close()
+			continue
+		}
+		if c.MaxFiles != 0 && len(files) >= c.MaxFiles {
+			err = ErrTooMany
+			return
+		}
+
+		var file *File
+		if file, err = save(c, part); err != nil {
+			return
+		}
+		if c.Scan != nil && c.Scan.Scanner != nil {
+			if err = scan(ctx, c.Scan, c.Storage, file); err != nil {
+				if err == ErrScanUnavailable {
+					c.Storage.Delete(file)
+				}
+				return
+			}
+		}
+		if c.Images != nil {
+			if err = processImage(c.Images, c.Storage, file); err != nil {
+				c.Storage.Delete(file)
+				return
+			}
+		}
+		files = append(files, file)
+	}
+
+	if c.Record && len(files) != 0 {
+		docs := make([]interface{}, len(files))
+		for i, file := range files {
+			docs[i] = file
+		}
+		err = mongoMiddleware.C(ctx, Model.Name).Insert(docs...)
+	}
+	return
+}
+
+func save(c Config, part *multipart.Part) (file *File, err error) {
+	defer part.Close()
+
+	// 识别类型
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return
+	}
+	head = head[:n]
+	contentType, err := sniff.Check(head, part.Header.Get("Content-Type"), part.FileName(), c.Types, c.Dangerous)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	file = &File{
+		ID:          bson.NewObjectId(),
+		Storage:     c.Storage.Name(),
+		Field:       part.FormName(),
+		Name:        path.Base(strings.Replace(part.FileName(), "\\", "/", -1)),
+		ContentType: contentType,
+		CreatedAt:   &now,
+	}
+	r := &reader{
+		reader: io.MultiReader(bytes.NewReader(head), part),
+		hash:   sha256.New(),
+		limit:  c.MaxSize,
+	}
+	if err = c.Storage.Save(file, r); err != nil {
+		if r.exceeded() {
+			err = ErrTooLarge
+		}
+		return
+	}
+	if r.exceeded() {
+		c.Storage.Delete(file)
+		err = ErrTooLarge
+		return
+	}
+	file.Size = r.size
+	file.SHA256 = hex.EncodeToString(r.hash.Sum(nil))
+	return
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if r.exceeded() {
+		return 0, ErrTooLarge
+	}
+	n, err = r.reader.Read(p)
+	r.size += int64(n)
+	r.hash.Write(p[:n])
+	if r.exceeded() {
+		err = ErrTooLarge
+	}
+	return
+}
+
+func (r *reader) exceeded() bool {
+	return r.limit != 0 && r.size > r.limit
+}
+
+func allowField(fields []string, name string) bool {
+	if len(fields) == 0 {
+		return true
+	}
+	for _, field := range fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package useragent
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/logger"
+)
+
+type (
+	Agent struct {
+		Raw            string `json:"-"`
+		Browser        string `json:"browser,omitempty"`
+		BrowserVersion string `json:"browser_version,omitempty"`
+		OS             string `json:"os,omitempty"`
+		// desktop mobile tablet bot
+		Device string `json:"device,omitempty"`
+		Bot    bool   `json:"bot,omitempty"`
+	}
+
+	rule struct {
+		token string
+		name  string
+	}
+)
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+)
+
+var CONTEXT = "GIN.SERVER.USERAGENT"
+
+var (
+	// 顺序有关 Edge Opera 包含 Chrome  Chrome 包含 Safari
+	browsers = []rule{
+		{"edg/", "Edge"},
+		{"edge/", "Edge"},
+		{"opr/", "Opera"},
+		{"opera/", "Opera"},
+		{"yabrowser/", "Yandex"},
+		{"samsungbrowser/", "Samsung Internet"},
+		{"ucbrowser/", "UC Browser"},
+		{"micromessenger/", "WeChat"},
+		{"firefox/", "Firefox"},
+		{"fxios/", "Firefox"},
+		{"crios/", "Chrome"},
+		{"chrome/", "Chrome"},
+		{"version/", "Safari"},
+		{"msie ", "Internet Explorer"},
+		{"trident/", "Internet Explorer"},
+	}
+	systems = []rule{
+		{"windows phone", "Windows Phone"},
+		{"windows", "Windows"},
+		{"iphone", "iOS"},
+		{"ipad", "iOS"},
+		{"ipod", "iOS"},
+		{"android", "Android"},
+		{"cros", "Chrome OS"},
+		{"mac os x", "macOS"},
+		{"macintosh", "macOS"},
+		{"linux", "Linux"},
+	}
+	bots = []string{
+		"bot", "crawler", "spider", "slurp", "crawling",
+		"facebookexternalhit", "bingpreview", "mediapartners-google",
+		"curl/", "wget/", "python-requests", "python-urllib", "go-http-client",
+		"java/", "okhttp", "headlesschrome", "phantomjs", "lighthouse",
+	}
+)
+
+func Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		agent := Parse(ctx.GetHeader("User-Agent"))
+		ctx.Set(CONTEXT, agent)
+
+		if val, ok := ctx.Get(logger.CONTEXT); ok && val != nil {
+			if log, ok := val.(*logger.Logger); ok {
+				log.Fields["device"] = agent.Device
+				if agent.Browser != "" {
+					log.Fields["browser"] = agent.Browser
+				}
+				if agent.OS != "" {
+					log.Fields["os"] = agent.OS
+				}
+			}
+		}
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Agent {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Agent)
+	}
+	return Parse(ctx.GetHeader("User-Agent"))
+}
+
+func IsBot(ctx *gin.Context) bool {
+	return Get(ctx).Bot
+}
+
+// Limit 用于 rate.Config.Limit  爬虫使用单独的限制
+func Limit(human int64, bot int64) func(ctx *gin.Context) int64 {
+	return func(ctx *gin.Context) int64 {
+		if IsBot(ctx) {
+			return bot
+		}
+		return human
+	}
+}
+
+func Parse(raw string) *Agent {
+	agent := &Agent{
+		Raw:    raw,
+		Device: DeviceDesktop,
+	}
+	ua := strings.ToLower(raw)
+
+	// 空 UA 当作爬虫
+	if strings.TrimSpace(ua) == "" {
+		agent.Bot = true
+		agent.Device = DeviceBot
+		return agent
+	}
+	for _, val := range bots {
+		if strings.Contains(ua, val) {
+			agent.Bot = true
+			agent.Device = DeviceBot
+			break
+		}
+	}
+
+	for _, val := range browsers {
+		if index := strings.Index(ua, val.token); index != -1 {
+			agent.Browser = val.name
+			agent.BrowserVersion = version(raw[index+len(val.token):])
+			break
+		}
+	}
+	for _, val := range systems {
+		if strings.Contains(ua, val.token) {
+			agent.OS = val.name
+			break
+		}
+	}
+
+	if agent.Bot {
+		return agent
+	}
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") || (strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+		agent.Device = DeviceTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod") || strings.Contains(ua, "windows phone"):
+		agent.Device = DeviceMobile
+	}
+	return agent
+}
+
+func version(value string) string {
+	end := strings.IndexFunc(value, func(r rune) bool {
+		return !(r == '.' || (r >= '0' && r <= '9'))
+	})
+	if end == -1 {
+		end = len(value)
+	}
+	return value[:end]
+}
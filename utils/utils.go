@@ -3,6 +3,7 @@ package utils
 import (
 	"crypto/rand"
 	"math/big"
+	"net"
 	"net/http"
 	"reflect"
 	"regexp"
@@ -60,6 +61,55 @@ func GetContextValue(ctx *gin.Context, keys []string) (value interface{}, ok boo
 	return
 }
 
+// ParseCIDRs 支持 CIDR 和单个 IP
+func ParseCIDRs(values []string) (nets []*net.IPNet, err error) {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				err = &net.ParseError{Type: "IP address", Text: value}
+				return
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		var ipNet *net.IPNet
+		if _, ipNet, err = net.ParseCIDR(value); err != nil {
+			return
+		}
+		nets = append(nets, ipNet)
+	}
+	return
+}
+
+func ContainsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteIP 去掉 RemoteAddr 的端口
+func RemoteIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(req.RemoteAddr))
+	if err != nil {
+		host = strings.TrimSpace(req.RemoteAddr)
+	}
+	return net.ParseIP(host)
+}
+
 func NameOfFunction(f interface{}) string {
 	return runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
 }
@@ -0,0 +1,1133 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/otamoe/gin-server/jsoncodec"
+	"github.com/otamoe/gin-server/kafka"
+	"github.com/otamoe/gin-server/loadshed"
+	ginLogger "github.com/otamoe/gin-server/logger"
+	"github.com/otamoe/gin-server/timezone"
+	"github.com/otamoe/gin-server/tracing"
+	"github.com/otamoe/gin-server/utils"
+	"github.com/sirupsen/logrus"
+	validator9 "gopkg.in/go-playground/validator.v9"
+)
+
+type (
+	// ConfigErrors 所有配置错误
+	ConfigErrors []error
+
+	validator struct {
+		errs ConfigErrors
+	}
+)
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return "Server: invalid config\n  " + strings.Join(lines, "\n  ")
+}
+
+// Validate 在 Init 之前检查配置  不连接 不监听  返回所有错误
+func (server *Server) Validate() error {
+	v := &validator{}
+
+	switch server.ENV {
+	case "", "dev", "development", "test", "production":
+	default:
+		v.add("env", "unknown env "+server.ENV)
+	}
+	if server.Addr != "" {
+		if _, port, err := net.SplitHostPort(server.Addr); err != nil {
+			v.add("addr", err.Error())
+		} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			v.add("addr", "invalid port "+port)
+		}
+	}
+	for i, val := range server.Listeners {
+		field := "listeners[" + strconv.Itoa(i) + "]"
+		if _, port, err := net.SplitHostPort(val.Addr); err != nil {
+			v.add(field+".addr", err.Error())
+		} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			v.add(field+".addr", "invalid port "+port)
+		}
+		switch val.Network {
+		case "", "tcp", "tcp4", "tcp6":
+		default:
+			v.add(field+".network", "unknown network "+val.Network)
+		}
+		for j, certificate := range val.Certificates {
+			if _, err := certificate.load(); err != nil {
+				v.add(field+".certificates["+strconv.Itoa(j)+"]", err.Error())
+			}
+		}
+	}
+	if _, err := sortInitSteps(server.steps()); err != nil {
+		v.add("init", err.Error())
+	}
+	for name := range server.Modules {
+		if server.Module(name) == nil {
+			v.add("modules."+name, "module "+name+" not registered")
+		}
+	}
+	for i, val := range server.Certificates {
+		if _, err := val.load(); err != nil {
+			v.add("certificates["+strconv.Itoa(i)+"]", err.Error())
+		}
+	}
+	for host, policy := range server.TLSPolicies {
+		if _, err := policy.apply(&tls.Config{}); err != nil {
+			v.add("tls_policies."+host, err.Error())
+		}
+	}
+	if server.HTTP2 != nil && server.HTTP2.Disabled && server.GRPC != nil && len(server.Certificates) != 0 {
+		v.add("http2.disabled", "grpc requires http2")
+	}
+	if server.HTTP2 != nil && server.HTTP2.MaxReadFrameSize != 0 && (server.HTTP2.MaxReadFrameSize < 16384 || server.HTTP2.MaxReadFrameSize > 16777215) {
+		v.add("http2.max_read_frame_size", "must be between 16384 and 16777215")
+	}
+	if len(server.TLSPolicies) != 0 && len(server.Certificates) == 0 {
+		v.add("tls_policies", "requires certificates")
+	}
+	if _, err := utils.ParseCIDRs(server.TrustedProxies); err != nil {
+		v.add("trusted_proxies", err.Error())
+	}
+	if _, ok := jsoncodec.Named(server.JSON); !ok {
+		v.add("json", "must be std or jsoniter")
+	}
+	for _, val := range server.RemoteIPHeaders {
+		if val == "" {
+			v.add("remote_ip_headers", "must not be empty")
+		}
+	}
+	v.duration("read_timeout", server.ReadTimeout)
+	v.duration("read_header_timeout", server.ReadHeaderTimeout)
+	v.duration("write_timeout", server.WriteTimeout)
+	v.duration("idle_timeout", server.IdleTimeout)
+	v.duration("shutdown_timeout", server.ShutdownTimeout)
+	v.duration("drain_delay", server.DrainDelay)
+	if server.Connections != nil {
+		v.duration("connections.idle_warning", server.Connections.IdleWarning)
+		v.duration("connections.interval", server.Connections.Interval)
+	}
+	for i, val := range server.CDN {
+		name := "cdn[" + strconv.Itoa(i) + "]"
+		if val.Provider != "" && val.Provider != "cloudflare" {
+			v.add(name+".provider", "unknown provider "+val.Provider)
+		}
+		if val.Provider == "" && (val.Header == "" || (len(val.Ranges) == 0 && len(val.URLs) == 0)) {
+			v.add(name, "requires header and ranges or urls")
+		}
+		if _, err := utils.ParseCIDRs(val.Ranges); err != nil {
+			v.add(name+".ranges", err.Error())
+		}
+		for _, rawURL := range val.URLs {
+			if u, err := url.Parse(rawURL); err != nil || u.Scheme == "" || u.Host == "" {
+				v.add(name+".urls", "invalid url "+rawURL)
+			}
+		}
+		v.duration(name+".refresh", val.Refresh)
+	}
+	if server.ProxyProtocol != nil {
+		v.duration("proxy_protocol.timeout", server.ProxyProtocol.Timeout)
+		if len(server.ProxyProtocol.Trusted) == 0 {
+			v.add("proxy_protocol.trusted", "is required")
+		} else if _, err := utils.ParseCIDRs(server.ProxyProtocol.Trusted); err != nil {
+			v.add("proxy_protocol.trusted", err.Error())
+		}
+	}
+	if server.Switch != nil {
+		if len(server.Switch.Keys) == 0 {
+			v.add("switch.keys", "is required")
+		}
+		if server.Switch.Path != "" && !strings.HasPrefix(server.Switch.Path, "/") {
+			v.add("switch.path", "must start with /")
+		}
+		if _, err := utils.ParseCIDRs(server.Switch.Allow); err != nil {
+			v.add("switch.allow", err.Error())
+		}
+	}
+	if server.Normalize != nil {
+		for _, val := range server.Normalize.SingleHeaders {
+			if val == "" {
+				v.add("normalize.single_headers", "must not be empty")
+			}
+		}
+	}
+	if server.Slowloris != nil {
+		v.duration("slowloris.grace", server.Slowloris.Grace)
+		v.duration("slowloris.ban", server.Slowloris.Ban)
+		if server.Slowloris.MinRate < 0 {
+			v.add("slowloris.min_rate", "must not be negative")
+		}
+	}
+	if server.KeepAlive != nil {
+		v.duration("keep_alive.max_age", server.KeepAlive.MaxAge)
+		if server.KeepAlive.MaxRequests < 0 {
+			v.add("keep_alive.max_requests", "must not be negative")
+		}
+	}
+	if server.ShutdownTimeout != 0 && server.DrainDelay >= server.ShutdownTimeout {
+		v.add("drain_delay", "must be less than shutdown_timeout")
+	}
+	if _, err := parseSignals(server.Signals); err != nil {
+		v.add("signals", err.Error())
+	}
+	for host, target := range server.Redirects {
+		if _, err := url.Parse(target); err != nil {
+			v.add("redirects."+host, err.Error())
+		}
+	}
+	for name, limit := range server.RateLimits {
+		if limit < 0 {
+			v.add("rate_limits."+name, "must not be negative")
+		}
+	}
+	if len(server.Features) != 0 && server.Flags == nil {
+		v.add("features", "flags is empty")
+	}
+	if server.Validator != nil && len(server.validations) != 0 {
+		if _, ok := server.Validator.(ValidationRegisterer); !ok {
+			if _, ok := server.Validator.Engine().(*validator9.Validate); !ok {
+				v.add("validator", "RegisterValidation requires validator.v9 or ValidationRegisterer")
+			}
+		}
+	}
+
+	server.validateConfigs(v, "", server.Compress, server.Logger, server.Redis, server.Mongo, server.Size, server.JWT, server.Sessions, server.Cors, server.Secure)
+
+	if server.Tracing != nil {
+		if _, err := tracing.NewExporter(server.Tracing.Exporter, server.Tracing.Endpoint, server.Tracing.Headers); err != nil {
+			v.add("tracing.exporter", err.Error())
+		}
+		if server.Tracing.Sampler < 0 || server.Tracing.Sampler > 1 {
+			v.add("tracing.sampler", "must be between 0 and 1")
+		}
+	}
+	if server.Jobs != nil && server.redisProvider() == nil {
+		v.add("jobs", "requires redis")
+	}
+	if server.Mail != nil {
+		if server.Mail.Host == "" {
+			v.add("mail.host", "is empty")
+		}
+		switch server.Mail.TLS {
+		case "", "starttls", "tls", "none":
+		default:
+			v.add("mail.tls", "must be starttls, tls or none")
+		}
+		if server.Mail.From != "" {
+			if _, err := mail.ParseAddress(server.Mail.From); err != nil {
+				v.add("mail.from", err.Error())
+			}
+		}
+		if server.Mail.Templates != "" {
+			if info, err := os.Stat(server.Mail.Templates); err != nil || !info.IsDir() {
+				v.add("mail.templates", "must be a directory")
+			}
+		}
+	}
+	if server.Notify != nil {
+		for name, val := range server.Notify.Providers {
+			switch {
+			case val == nil:
+				v.add("notify.providers."+name, "is null")
+			case val.Type == "webhook" || val.Type == "slack":
+				if u, err := url.Parse(val.URL); err != nil || u.Host == "" {
+					v.add("notify.providers."+name+".url", "invalid url")
+				}
+			case val.Type == "email":
+				if server.Mail == nil {
+					v.add("notify.providers."+name, "requires mail")
+				}
+				if len(val.To) == 0 {
+					v.add("notify.providers."+name+".to", "is empty")
+				}
+			default:
+				v.add("notify.providers."+name+".type", "must be webhook, slack or email")
+			}
+		}
+	}
+	if server.Webhooks != nil {
+		if server.Jobs == nil {
+			v.add("webhooks", "requires jobs")
+		}
+		if server.mongoProvider() == nil {
+			v.add("webhooks", "requires mongo")
+		}
+		if server.Webhooks.Timeout < 0 {
+			v.add("webhooks.timeout", "must be greater than or equal to 0")
+		}
+	}
+	if server.Streams != nil && server.redisProvider() == nil {
+		v.add("streams", "requires redis")
+	}
+	if server.Outbox != nil {
+		if server.redisProvider() == nil {
+			v.add("outbox", "requires redis")
+		}
+		if server.mongoProvider() == nil {
+			v.add("outbox", "requires mongo")
+		}
+		switch server.Outbox.Mode {
+		case "", "stream", "pubsub":
+		default:
+			v.add("outbox.mode", "must be stream or pubsub")
+		}
+	}
+	if server.Events != nil && server.Events.Redis && server.redisProvider() == nil {
+		v.add("events.redis", "requires redis")
+	}
+	if server.Alerts != nil {
+		if server.Alerts.ErrorRate < 0 || server.Alerts.ErrorRate > 1 {
+			v.add("alerts.error_rate", "must be between 0 and 1")
+		}
+		if server.Alerts.Percentile < 0 || server.Alerts.Percentile > 1 {
+			v.add("alerts.percentile", "must be between 0 and 1")
+		}
+		if server.Alerts.ErrorRate == 0 && server.Alerts.Latency == 0 {
+			v.add("alerts", "error_rate or latency is required")
+		}
+		for _, val := range server.Alerts.Webhooks {
+			if u, err := url.Parse(val); err != nil || u.Scheme == "" || u.Host == "" {
+				v.add("alerts.webhooks", "invalid url "+val)
+			}
+		}
+	}
+	if server.Capture != nil {
+		if server.Capture.Sample < 0 || server.Capture.Sample > 1 {
+			v.add("capture.sample", "must be between 0 and 1")
+		}
+		if server.Capture.File == "" && server.mongoProvider() == nil {
+			v.add("capture", "requires file or mongo")
+		}
+	}
+	server.validateHeaders(v, "", server.Headers)
+	server.validateConcurrent(v, "", server.Concurrent)
+	server.validateLoadShed(v, "", server.LoadShed)
+	server.validateAdmission(v, "", server.Admission)
+	server.validateCanonical(v, "", server.Canonical)
+	server.validateVersioning(v, "", server.Versioning)
+	server.validateDeprecation(v, "", server.Deprecation)
+	server.validateSniff(v, "", server.Sniff)
+	server.validateStatic(v, "", server.Static, server.FS)
+	server.validateTemplates(v, "", server.Templates, server.FS)
+	server.validateI18n(v, "", server.I18n, server.FS, false)
+	server.validateTimeZone(v, "", server.TimeZone)
+	server.validateAssets(v, "", server.Assets, server.FS)
+	server.validateACL(v, "", server.ACL)
+	server.validateTOTP(v, "", server.TOTP, server.Sessions)
+	server.validateSignedURL(v, "", server.SignedURL, server.Sessions)
+	server.validateOAuthServer(v, "", server.OAuthServer, server.Redis)
+	server.validateTimeout(v, "", server.Timeout)
+	server.validateTenant(v, "", server.Tenant)
+	server.validateOpenAPI(v, "", server.OpenAPI)
+	if server.Migrate != nil {
+		v.duration("migrate.lock_ttl", server.Migrate.LockTTL)
+		v.duration("migrate.timeout", server.Migrate.Timeout)
+		if server.mongoProvider() == nil {
+			v.add("migrate", "requires mongo")
+		}
+	}
+	if server.Backup != nil {
+		if server.Backup.Format != "" && server.Backup.Format != "bson" && server.Backup.Format != "json" {
+			v.add("backup.format", "must be bson or json")
+		}
+		if server.Backup.Dir == "" && server.Backup.Storage == nil {
+			v.add("backup.dir", "is empty")
+		}
+		if server.mongoProvider() == nil {
+			v.add("backup", "requires mongo")
+		}
+	}
+	if server.Seed != nil {
+		v.duration("seed.timeout", server.Seed.Timeout)
+	}
+	if server.Metrics != nil {
+		v.duration("metrics.pool_interval", server.Metrics.PoolInterval)
+	}
+	if server.Warmup != nil {
+		v.duration("warmup.timeout", server.Warmup.Timeout)
+	}
+	if server.Initializers != nil {
+		v.duration("initializers.timeout", server.Initializers.Timeout)
+	}
+	if server.Secrets != nil {
+		v.duration("secrets.timeout", server.Secrets.Timeout)
+	}
+	if server.Scheduler != nil && server.Scheduler.Location != "" {
+		if _, err := time.LoadLocation(server.Scheduler.Location); err != nil {
+			v.add("scheduler.location", err.Error())
+		}
+	}
+
+	// 同一个 host/prefix 只能有一个 handler
+	patterns := map[string]string{}
+	names := map[string]bool{}
+	for i, handler := range server.Handlers {
+		name := "handlers[" + strconv.Itoa(i) + "]"
+		if handler.Name != "" {
+			if names[handler.Name] {
+				v.add(name+".name", "duplicate name "+handler.Name)
+			}
+			names[handler.Name] = true
+			name = "handlers." + handler.Name
+		}
+		if len(handler.Hosts) == 0 {
+			v.add(name+".hosts", "is empty")
+		}
+		for _, host := range handler.Hosts {
+			prefixes := handler.Prefixes
+			if len(prefixes) == 0 {
+				prefixes = []string{"/"}
+			}
+			for _, prefix := range prefixes {
+				h, p := splitPattern(host + "/" + strings.TrimPrefix(prefix, "/"))
+				if other, ok := patterns[h+p]; ok {
+					v.add(name+".hosts", "conflicts with "+other+" on "+h+p)
+				}
+				patterns[h+p] = name
+			}
+		}
+		server.validateConfigs(v, name+".", handler.Compress, handler.Logger, handler.Redis, handler.Mongo, handler.Size, handler.JWT, handler.Sessions, handler.Cors, handler.Secure)
+		server.validateHeaders(v, name+".", handler.Headers)
+		server.validateConcurrent(v, name+".", handler.Concurrent)
+		server.validateLoadShed(v, name+".", handler.LoadShed)
+		server.validateAdmission(v, name+".", handler.Admission)
+		server.validateCanonical(v, name+".", handler.Canonical)
+		server.validateVersioning(v, name+".", handler.Versioning)
+		server.validateDeprecation(v, name+".", handler.Deprecation)
+		server.validateSniff(v, name+".", handler.Sniff)
+		fs := handler.FS
+		if fs == nil {
+			fs = server.FS
+		}
+		server.validateStatic(v, name+".", handler.Static, fs)
+		server.validateTemplates(v, name+".", handler.Templates, fs)
+		server.validateI18n(v, name+".", handler.I18n, fs, server.I18n != nil)
+		server.validateTimeZone(v, name+".", handler.TimeZone)
+		server.validateAssets(v, name+".", handler.Assets, fs)
+		server.validateACL(v, name+".", handler.ACL)
+		sessions := handler.Sessions
+		if sessions == nil {
+			sessions = server.Sessions
+		}
+		server.validateTOTP(v, name+".", handler.TOTP, sessions)
+		server.validateSignedURL(v, name+".", handler.SignedURL, sessions)
+		server.validateOAuthServer(v, name+".", handler.OAuthServer, handler.Redis)
+		server.validateTimeout(v, name+".", handler.Timeout)
+		server.validateTenant(v, name+".", handler.Tenant)
+		server.validateOpenAPI(v, name+".", handler.OpenAPI)
+		if handler.Canary != nil {
+			if handler.Canary.Percent < 0 || handler.Canary.Percent > 100 {
+				v.add(name+".canary.percent", "must be between 0 and 100")
+			}
+			if (handler.Canary.Handler == "") == (handler.Canary.Upstream == "") {
+				v.add(name+".canary", "handler or upstream is required")
+			} else if handler.Canary.Upstream != "" {
+				if u, err := url.Parse(handler.Canary.Upstream); err != nil || u.Scheme == "" || u.Host == "" {
+					v.add(name+".canary.upstream", "invalid url "+handler.Canary.Upstream)
+				}
+			} else if handler.Canary.Handler == handler.Name {
+				v.add(name+".canary.handler", "must not be itself")
+			} else {
+				found := false
+				for _, val := range server.Handlers {
+					found = found || val.Name == handler.Canary.Handler
+				}
+				if !found {
+					v.add(name+".canary.handler", "handler "+handler.Canary.Handler+" not found")
+				}
+			}
+		}
+		if handler.Proxy != nil {
+			if len(handler.Proxy.Upstreams) == 0 {
+				if u, err := url.Parse(handler.Proxy.Upstream); err != nil || u.Scheme == "" || u.Host == "" {
+					v.add(name+".proxy.upstream", "invalid url "+handler.Proxy.Upstream)
+				}
+			}
+			for _, val := range handler.Proxy.Upstreams {
+				if u, err := url.Parse(val); err != nil || u.Scheme == "" || u.Host == "" {
+					v.add(name+".proxy.upstreams", "invalid url "+val)
+				}
+			}
+			switch handler.Proxy.Balance {
+			case "", "round_robin", "least_conn":
+			default:
+				v.add(name+".proxy.balance", "unknown balance "+handler.Proxy.Balance)
+			}
+			if check := handler.Proxy.HealthCheck; check != nil {
+				if len(handler.Proxy.Upstreams) == 0 {
+					v.add(name+".proxy.health_check", "requires upstreams")
+				}
+				v.duration(name+".proxy.health_check.interval", check.Interval)
+				v.duration(name+".proxy.health_check.timeout", check.Timeout)
+			}
+			for key, val := range map[string]string{"ca_file": handler.Proxy.CAFile, "cert_file": handler.Proxy.CertFile, "key_file": handler.Proxy.KeyFile} {
+				v.file(name+".proxy."+key, val)
+			}
+			if cache := handler.Proxy.Cache; cache != nil {
+				switch cache.Store {
+				case "", "redis":
+					if handler.Redis == nil && handler.RedisProvider == nil && server.redisProvider() == nil {
+						v.add(name+".proxy.cache", "requires redis")
+					}
+				case "disk":
+					if cache.Dir == "" {
+						v.add(name+".proxy.cache.dir", "is empty")
+					}
+				default:
+					v.add(name+".proxy.cache.store", "unknown store "+cache.Store)
+				}
+				v.duration(name+".proxy.cache.stale", cache.Stale)
+			}
+			if (handler.Proxy.CertFile == "") != (handler.Proxy.KeyFile == "") {
+				v.add(name+".proxy", "cert_file and key_file must be set together")
+			}
+		}
+	}
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+func (server *Server) validateConfigs(v *validator, prefix string, compress *Compress, logger *Logger, redis *Redis, mongo *Mongo, size *Size, jwt *JWT, sessions *Sessions, cors *Cors, secure *Secure) {
+	if compress != nil {
+		if compress.GzipLevel < -2 || compress.GzipLevel > 9 {
+			v.add(prefix+"compress.gzip_level", "must be between -2 and 9")
+		}
+		if compress.BrQuality < 0 || compress.BrQuality > 11 {
+			v.add(prefix+"compress.br_quality", "must be between 0 and 11")
+		}
+		if compress.BrLGWin != 0 && (compress.BrLGWin < 10 || compress.BrLGWin > 24) {
+			v.add(prefix+"compress.br_lgwin", "must be between 10 and 24")
+		}
+		if compress.MinLength < 0 {
+			v.add(prefix+"compress.min_length", "must not be negative")
+		}
+		for mediatype, val := range compress.BrTypes {
+			if val == nil {
+				continue
+			}
+			if val.Quality < 0 || val.Quality > 11 {
+				v.add(prefix+"compress.br_types."+mediatype+".quality", "must be between 0 and 11")
+			}
+			if val.LGWin != 0 && (val.LGWin < 10 || val.LGWin > 24) {
+				v.add(prefix+"compress.br_types."+mediatype+".lgwin", "must be between 10 and 24")
+			}
+		}
+		v.file(prefix+"compress.br_dictionary", compress.BrDictionary)
+	}
+	if logger != nil {
+		if logger.Level != "" {
+			if _, err := logrus.ParseLevel(logger.Level); err != nil {
+				v.add(prefix+"logger.level", err.Error())
+			}
+		}
+		if logger.File != "" {
+			if info, err := os.Stat(filepath.Dir(logger.File)); err != nil || !info.IsDir() {
+				v.add(prefix+"logger.file", "directory does not exist "+filepath.Dir(logger.File))
+			}
+		}
+		if logger.AccessFile != "" {
+			if info, err := os.Stat(filepath.Dir(logger.AccessFile)); err != nil || !info.IsDir() {
+				v.add(prefix+"logger.access_file", "directory does not exist "+filepath.Dir(logger.AccessFile))
+			}
+		}
+		switch logger.Format {
+		case "", ginLogger.FormatCommon, ginLogger.FormatCombined, ginLogger.FormatECS:
+		default:
+			v.add(prefix+"logger.format", "unknown format "+logger.Format)
+		}
+		if logger.Anonymize != nil {
+			switch logger.Anonymize.IP {
+			case "", "mask", "hash":
+			default:
+				v.add(prefix+"logger.anonymize.ip", "unknown ip "+logger.Anonymize.IP)
+			}
+			if logger.Anonymize.UserAgent < 0 {
+				v.add(prefix+"logger.anonymize.user_agent", "must be >= 0")
+			}
+		}
+		if logger.Forward != nil {
+			if _, _, err := net.SplitHostPort(logger.Forward.Addr); err != nil {
+				v.add(prefix+"logger.forward.addr", err.Error())
+			}
+			switch logger.Forward.Protocol {
+			case "", ginLogger.ProtocolJSON, ginLogger.ProtocolForward:
+			default:
+				v.add(prefix+"logger.forward.protocol", "unknown protocol "+logger.Forward.Protocol)
+			}
+			switch logger.Forward.Network {
+			case "", "tcp", "udp":
+			default:
+				v.add(prefix+"logger.forward.network", "unknown network "+logger.Forward.Network)
+			}
+			if logger.Forward.Level != "" {
+				if _, err := logrus.ParseLevel(logger.Forward.Level); err != nil {
+					v.add(prefix+"logger.forward.level", err.Error())
+				}
+			}
+		}
+		if logger.Kafka != nil {
+			if len(logger.Kafka.Brokers) == 0 {
+				v.add(prefix+"logger.kafka.brokers", "is empty")
+			}
+			if logger.Kafka.Topic == "" {
+				v.add(prefix+"logger.kafka.topic", "is empty")
+			}
+			switch logger.Kafka.Compression {
+			case "", "none", "gzip", "snappy", "lz4", "zstd":
+			default:
+				v.add(prefix+"logger.kafka.compression", "unknown compression "+logger.Kafka.Compression)
+			}
+			switch logger.Kafka.Delivery {
+			case "", kafka.AtMostOnce, kafka.AtLeastOnce:
+			default:
+				v.add(prefix+"logger.kafka.delivery", "unknown delivery "+logger.Kafka.Delivery)
+			}
+			if logger.Kafka.Level != "" {
+				if _, err := logrus.ParseLevel(logger.Kafka.Level); err != nil {
+					v.add(prefix+"logger.kafka.level", err.Error())
+				}
+			}
+		}
+	}
+	if redis != nil {
+		for _, val := range redis.URLs {
+			if _, _, err := net.SplitHostPort(val); err != nil {
+				v.add(prefix+"redis.urls", err.Error())
+			}
+		}
+		if redis.PoolLimit < 0 {
+			v.add(prefix+"redis.pool_limit", "must not be negative")
+		}
+		v.duration(prefix+"redis.pool_timeout", redis.PoolTimeout)
+		v.duration(prefix+"redis.dial_timeout", redis.DialTimeout)
+		v.duration(prefix+"redis.socket_timeout", redis.SocketTimeout)
+		v.duration(prefix+"redis.dial_retry", redis.DialRetry)
+		v.duration(prefix+"redis.check_interval", redis.CheckInterval)
+	}
+	if mongo != nil {
+		if len(mongo.URLs) != 0 {
+			if _, err := mgo.ParseURL(strings.Join(mongo.URLs, ",")); err != nil {
+				v.add(prefix+"mongo.urls", err.Error())
+			}
+		}
+		if mongo.PoolLimit < 0 {
+			v.add(prefix+"mongo.pool_limit", "must not be negative")
+		}
+		v.duration(prefix+"mongo.pool_timeout", mongo.PoolTimeout)
+		v.duration(prefix+"mongo.dial_timeout", mongo.DialTimeout)
+		v.duration(prefix+"mongo.socket_timeout", mongo.SocketTimeout)
+		v.duration(prefix+"mongo.dial_retry", mongo.DialRetry)
+		if strings.ContainsAny(mongo.Prefix, "/\\. \"$") {
+			v.add(prefix+"mongo.prefix", "must not contain /\\. \"$")
+		}
+	}
+	if size != nil {
+		if size.Limit < 0 {
+			v.add(prefix+"size.limit", "must not be negative")
+		}
+		if size.ResponseLimit < 0 {
+			v.add(prefix+"size.response_limit", "must not be negative")
+		}
+		if size.Memory < 0 {
+			v.add(prefix+"size.memory", "must not be negative")
+		}
+		for name, val := range size.Routes {
+			if val < 0 {
+				v.add(prefix+"size.routes."+name, "must not be negative")
+			}
+		}
+		if size.TempDir != "" {
+			if info, err := os.Stat(size.TempDir); err != nil || !info.IsDir() {
+				v.add(prefix+"size.temp_dir", "must be a directory")
+			}
+		}
+	}
+	if jwt != nil {
+		for kid, val := range jwt.Keys {
+			block, _ := pem.Decode([]byte(val))
+			if block == nil {
+				v.add(prefix+"jwt.keys."+kid, "is not PEM encoded")
+			} else if _, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+				v.add(prefix+"jwt.keys."+kid, err.Error())
+			}
+		}
+		if jwt.Secret == "" && len(jwt.Keys) == 0 && jwt.JWKSURL == "" {
+			v.add(prefix+"jwt", "secret, keys or jwks_url is required")
+		}
+		if jwt.JWKSURL != "" {
+			if u, err := url.Parse(jwt.JWKSURL); err != nil || u.Scheme == "" || u.Host == "" {
+				v.add(prefix+"jwt.jwks_url", "invalid url "+jwt.JWKSURL)
+			}
+		}
+	}
+	if sessions != nil {
+		switch sessions.Store {
+		case "", "redis":
+			if redis == nil && server.redisProvider() == nil {
+				v.add(prefix+"sessions.store", "redis store requires redis")
+			}
+		case "cookie":
+			if len(sessions.Keys) == 0 {
+				v.add(prefix+"sessions.keys", "cookie store keys is empty")
+			}
+		case "memory":
+		default:
+			v.add(prefix+"sessions.store", "unknown store "+sessions.Store)
+		}
+	}
+	if cors != nil {
+		if cors.Credentials {
+			for _, origin := range cors.Origins {
+				if strings.TrimSpace(origin) == "*" {
+					v.add(prefix+"cors.origins", "* can not be used with credentials")
+				}
+			}
+		}
+		if cors.MaxAge < 0 {
+			v.add(prefix+"cors.max_age", "must not be negative")
+		}
+	}
+	if secure != nil && secure.HSTSMaxAge < 0 {
+		v.add(prefix+"secure.hsts_max_age", "must not be negative")
+	}
+}
+
+func (server *Server) validateHeaders(v *validator, prefix string, headers *RequestHeaders) {
+	if headers == nil {
+		return
+	}
+	if headers.MaxCount < 0 {
+		v.add(prefix+"headers.max_count", "must not be negative")
+	}
+	if headers.MaxSize < 0 {
+		v.add(prefix+"headers.max_size", "must not be negative")
+	}
+	for _, name := range headers.Required {
+		if name == "" {
+			v.add(prefix+"headers.required", "must not contain empty name")
+		}
+	}
+}
+
+func (server *Server) validateConcurrent(v *validator, prefix string, concurrent *ConcurrentLimit) {
+	if concurrent == nil {
+		return
+	}
+	if concurrent.Requests < 0 {
+		v.add(prefix+"concurrent.requests", "must not be negative")
+	}
+	if concurrent.Connections < 0 {
+		v.add(prefix+"concurrent.connections", "must not be negative")
+	}
+	if concurrent.IPv4Prefix < 0 || concurrent.IPv4Prefix > 32 {
+		v.add(prefix+"concurrent.ipv4_prefix", "must be between 0 and 32")
+	}
+	if concurrent.IPv6Prefix < 0 || concurrent.IPv6Prefix > 128 {
+		v.add(prefix+"concurrent.ipv6_prefix", "must be between 0 and 128")
+	}
+}
+
+func (server *Server) validateLoadShed(v *validator, prefix string, config *LoadShed) {
+	if config == nil {
+		return
+	}
+	if config.MaxInFlight < 0 {
+		v.add(prefix+"load_shed.max_in_flight", "must not be negative")
+	}
+	v.duration(prefix+"load_shed.target_latency", config.TargetLatency)
+	v.duration(prefix+"load_shed.max_gc_pause", config.MaxGCPause)
+	v.duration(prefix+"load_shed.interval", config.Interval)
+	v.duration(prefix+"load_shed.retry_after", config.RetryAfter)
+	if config.MaxGCCPU < 0 || config.MaxGCCPU > 1 {
+		v.add(prefix+"load_shed.max_gc_cpu", "must be between 0 and 1")
+	}
+	if config.Step < 0 || config.Step > 1 {
+		v.add(prefix+"load_shed.step", "must be between 0 and 1")
+	}
+	if config.MaxInFlight == 0 && config.TargetLatency == 0 && config.MaxGCPause == 0 && config.MaxGCCPU == 0 {
+		v.add(prefix+"load_shed", "requires max_in_flight, target_latency, max_gc_pause or max_gc_cpu")
+	}
+	if _, err := loadshed.ParsePriority(config.Default); err != nil {
+		v.add(prefix+"load_shed.default", err.Error())
+	}
+	for name, val := range config.Routes {
+		if _, err := loadshed.ParsePriority(val); err != nil {
+			v.add(prefix+"load_shed.routes."+name, err.Error())
+		}
+	}
+}
+
+func (server *Server) validateAdmission(v *validator, prefix string, config *Admission) {
+	if config == nil {
+		return
+	}
+	v.duration(prefix+"admission.retry_after", config.RetryAfter)
+	if len(config.Classes) == 0 {
+		v.add(prefix+"admission.classes", "is empty")
+	}
+	for name, class := range config.Classes {
+		if class == nil {
+			v.add(prefix+"admission.classes."+name, "is empty")
+			continue
+		}
+		if class.Concurrency < 0 {
+			v.add(prefix+"admission.classes."+name+".concurrency", "must not be negative")
+		}
+		if class.Queue < 0 {
+			v.add(prefix+"admission.classes."+name+".queue", "must not be negative")
+		}
+		v.duration(prefix+"admission.classes."+name+".queue_timeout", class.QueueTimeout)
+	}
+	if _, ok := config.Classes[config.Default]; config.Default != "" && !ok {
+		v.add(prefix+"admission.default", "class "+config.Default+" not found")
+	}
+	for route, name := range config.Routes {
+		if _, ok := config.Classes[name]; !ok {
+			v.add(prefix+"admission.routes."+route, "class "+name+" not found")
+		}
+	}
+}
+
+func (server *Server) validateCanonical(v *validator, prefix string, canonical *Canonical) {
+	if canonical == nil {
+		return
+	}
+	switch canonical.TrailingSlash {
+	case "", "strip", "add":
+	default:
+		v.add(prefix+"canonical.trailing_slash", "must be strip or add")
+	}
+	for _, val := range canonical.Skip {
+		if !strings.HasPrefix(val, "/") {
+			v.add(prefix+"canonical.skip", "must start with / "+val)
+		}
+	}
+}
+
+func (server *Server) validateVersioning(v *validator, prefix string, config *Versioning) {
+	if config == nil {
+		return
+	}
+	pattern := regexp.MustCompile(`^v?\d+(\.\d+)*$`)
+	for _, val := range config.Supported {
+		if !pattern.MatchString(val) {
+			v.add(prefix+"versioning.supported", "invalid version "+val)
+		}
+	}
+	if config.Default != "" && !pattern.MatchString(config.Default) {
+		v.add(prefix+"versioning.default", "invalid version "+config.Default)
+	}
+}
+
+func (server *Server) validateDeprecation(v *validator, prefix string, deprecation *Deprecation) {
+	if deprecation == nil {
+		return
+	}
+	for name, val := range deprecation.Routes {
+		if val == nil {
+			v.add(prefix+"deprecation.routes."+name, "is null")
+			continue
+		}
+		if !val.Date.IsZero() && !val.Sunset.IsZero() && val.Sunset.Before(val.Date) {
+			v.add(prefix+"deprecation.routes."+name+".sunset", "must be after date")
+		}
+		if val.Gone && val.Sunset.IsZero() {
+			v.add(prefix+"deprecation.routes."+name+".gone", "requires sunset")
+		}
+	}
+}
+
+func (server *Server) validateSniff(v *validator, prefix string, sniff *Sniff) {
+	if sniff == nil {
+		return
+	}
+	if sniff.MaxMemory < 0 {
+		v.add(prefix+"sniff.max_memory", "must not be negative")
+	}
+	check := func(name string, types []string) {
+		for _, val := range types {
+			if parts := strings.Split(val, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				v.add(prefix+"sniff."+name, "invalid type "+val)
+			}
+		}
+	}
+	check("types", sniff.Types)
+	check("dangerous", sniff.Dangerous)
+	for name, types := range sniff.Routes {
+		check("routes."+name, types)
+	}
+}
+
+func (server *Server) validateStatic(v *validator, prefix string, static *Static, fs http.FileSystem) {
+	if static == nil {
+		return
+	}
+	if fs != nil {
+		if file, err := fs.Open("/" + strings.Trim(static.Root, "/")); err != nil {
+			v.add(prefix+"static.root", err.Error())
+		} else {
+			file.Close()
+		}
+		return
+	}
+	if static.Root == "" {
+		v.add(prefix+"static.root", "is empty")
+		return
+	}
+	v.file(prefix+"static.root", static.Root)
+}
+
+func (server *Server) validateTemplates(v *validator, prefix string, templates *Templates, fs http.FileSystem) {
+	if templates == nil {
+		return
+	}
+	if fs != nil {
+		if file, err := fs.Open("/" + strings.Trim(templates.Dir, "/")); err != nil {
+			v.add(prefix+"templates.dir", err.Error())
+		} else {
+			file.Close()
+		}
+		return
+	}
+	if templates.Dir == "" {
+		v.add(prefix+"templates.dir", "is empty")
+		return
+	}
+	v.file(prefix+"templates.dir", templates.Dir)
+}
+
+// validateI18n inherit 时 dir 可以为空
+func (server *Server) validateI18n(v *validator, prefix string, i18n *I18n, fs http.FileSystem, inherit bool) {
+	if i18n == nil {
+		return
+	}
+	pattern := regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z]{4})?(-[A-Z]{2}|-\d{3})?$`)
+	if i18n.Default != "" && !pattern.MatchString(i18n.Default) {
+		v.add(prefix+"i18n.default", "invalid locale "+i18n.Default)
+	}
+	if i18n.Dir == "" && inherit {
+		return
+	}
+	if fs != nil {
+		if file, err := fs.Open("/" + strings.Trim(i18n.Dir, "/")); err != nil {
+			v.add(prefix+"i18n.dir", err.Error())
+		} else {
+			file.Close()
+		}
+		return
+	}
+	if i18n.Dir == "" {
+		v.add(prefix+"i18n.dir", "is empty")
+		return
+	}
+	v.file(prefix+"i18n.dir", i18n.Dir)
+}
+
+func (server *Server) validateTimeZone(v *validator, prefix string, timeZone *TimeZone) {
+	if timeZone == nil || timeZone.Default == "" {
+		return
+	}
+	if _, ok := timezone.Load(timeZone.Default); !ok {
+		v.add(prefix+"time_zone.default", "invalid time zone "+timeZone.Default)
+	}
+}
+
+func (server *Server) validateAssets(v *validator, prefix string, assets *Assets, fs http.FileSystem) {
+	if assets == nil {
+		return
+	}
+	if fs != nil {
+		if file, err := fs.Open("/" + strings.Trim(assets.Root, "/")); err != nil {
+			v.add(prefix+"assets.root", err.Error())
+		} else {
+			file.Close()
+		}
+		return
+	}
+	if assets.Root == "" {
+		v.add(prefix+"assets.root", "is empty")
+		return
+	}
+	v.file(prefix+"assets.root", assets.Root)
+}
+
+func (server *Server) validateACL(v *validator, prefix string, acl *ACL) {
+	if acl == nil {
+		return
+	}
+	for name, values := range acl.Rules {
+		if name == "" {
+			v.add(prefix+"acl.rules", "empty route name")
+		}
+		for _, val := range values {
+			if strings.TrimSpace(val) == "" {
+				v.add(prefix+"acl.rules."+name, "empty role or scope")
+			}
+		}
+	}
+}
+
+func (server *Server) validateTOTP(v *validator, prefix string, totp *TOTP, sessions *Sessions) {
+	if totp == nil {
+		return
+	}
+	if sessions == nil {
+		v.add(prefix+"totp", "requires sessions")
+	}
+	if totp.MaxAge < 0 {
+		v.add(prefix+"totp.max_age", "must not be negative")
+	}
+}
+
+func (server *Server) validateSignedURL(v *validator, prefix string, signedURL *SignedURL, sessions *Sessions) {
+	if signedURL == nil {
+		return
+	}
+	if len(signedURL.Keys) == 0 && (sessions == nil || len(sessions.Keys) == 0) {
+		v.add(prefix+"signed_url.keys", "is empty and sessions has no keys")
+	}
+	for _, val := range signedURL.Keys {
+		if len(val) < 16 {
+			v.add(prefix+"signed_url.keys", "must be at least 16 bytes")
+			break
+		}
+	}
+	if signedURL.TTL < 0 {
+		v.add(prefix+"signed_url.ttl", "must not be negative")
+	}
+}
+
+func (server *Server) validateOAuthServer(v *validator, prefix string, oauthServer *OAuthServer, redis *Redis) {
+	if oauthServer == nil {
+		return
+	}
+	if oauthServer.Issuer == "" {
+		v.add(prefix+"oauth_server.issuer", "is empty")
+	}
+	if oauthServer.PrivateKey != "" {
+		if block, _ := pem.Decode([]byte(oauthServer.PrivateKey)); block == nil {
+			v.add(prefix+"oauth_server.private_key", "is not PEM encoded")
+		}
+	} else if oauthServer.Secret == "" {
+		v.add(prefix+"oauth_server", "secret or private_key is required")
+	}
+	if redis == nil && server.redisProvider() == nil {
+		v.add(prefix+"oauth_server", "requires redis")
+	}
+	switch oauthServer.RefreshStore {
+	case "", "redis":
+	case "mongo":
+		if server.mongoProvider() == nil {
+			v.add(prefix+"oauth_server.refresh_store", "mongo store requires mongo")
+		}
+	default:
+		v.add(prefix+"oauth_server.refresh_store", "unknown store "+oauthServer.RefreshStore)
+	}
+	for id, client := range oauthServer.Clients {
+		if client == nil {
+			v.add(prefix+"oauth_server.clients."+id, "is null")
+			continue
+		}
+		if client.Secret == "" {
+			v.add(prefix+"oauth_server.clients."+id+".secret", "is empty")
+		}
+		for _, grant := range client.Grants {
+			switch grant {
+			case "client_credentials", "refresh_token":
+			case "password":
+				if oauthServer.Authenticate == nil {
+					v.add(prefix+"oauth_server.clients."+id+".grants", "password requires authenticate")
+				}
+			default:
+				v.add(prefix+"oauth_server.clients."+id+".grants", "unknown grant "+grant)
+			}
+		}
+	}
+}
+
+func (server *Server) validateTimeout(v *validator, prefix string, timeout *Timeout) {
+	if timeout == nil {
+		return
+	}
+	v.duration(prefix+"timeout.default", timeout.Default)
+	for name, val := range timeout.Routes {
+		if d, err := time.ParseDuration(val); err != nil {
+			v.add(prefix+"timeout.routes."+name, err.Error())
+		} else {
+			v.duration(prefix+"timeout.routes."+name, d)
+		}
+	}
+}
+
+func (server *Server) validateTenant(v *validator, prefix string, tenant *Tenant) {
+	if tenant == nil {
+		return
+	}
+	if len(tenant.Hosts) == 0 && tenant.Domain == "" && tenant.Header == "" {
+		v.add(prefix+"tenant", "requires hosts, domain or header")
+	}
+	if tenant.Database != "" && !strings.Contains(tenant.Database, "{tenant}") {
+		v.add(prefix+"tenant.database", "must contain {tenant}")
+	}
+}
+
+func (server *Server) validateOpenAPI(v *validator, prefix string, openapi *OpenAPI) {
+	if openapi == nil {
+		return
+	}
+	if openapi.Path != "" && !strings.HasPrefix(openapi.Path, "/") {
+		v.add(prefix+"openapi.path", "must start with /")
+	}
+	if openapi.UIPath != "" && !strings.HasPrefix(openapi.UIPath, "/") {
+		v.add(prefix+"openapi.ui_path", "must start with /")
+	}
+	if openapi.UIPath != "" && openapi.UIPath == openapi.Path {
+		v.add(prefix+"openapi.ui_path", "must not equal path")
+	}
+	if openapi.Spec != "" {
+		v.file(prefix+"openapi.spec", openapi.Spec)
+	}
+}
+
+func (v *validator) add(field string, message string) {
+	v.errs = append(v.errs, errors.New(field+": "+message))
+}
+
+func (v *validator) duration(field string, value time.Duration) {
+	if value < 0 {
+		v.add(field, fmt.Sprintf("must not be negative %s", value))
+	}
+}
+
+func (v *validator) file(field string, name string) {
+	if name == "" {
+		return
+	}
+	if _, err := os.Stat(name); err != nil {
+		v.add(field, err.Error())
+	}
+}
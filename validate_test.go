@@ -0,0 +1,39 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCors(t *testing.T) {
+	tests := []struct {
+		name string
+		cors *Cors
+		err  string
+	}{
+		{"wildcard", &Cors{Origins: []string{"*"}}, ""},
+		{"wildcard credentials", &Cors{Origins: []string{" * "}, Credentials: true}, "cors.origins"},
+		{"origin credentials", &Cors{Origins: []string{"https://app.example.com"}, Credentials: true}, ""},
+	}
+	for _, test := range tests {
+		srv := &Server{ENV: "test", Cors: test.cors}
+		err := srv.Validate()
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error %v, want %s", test.name, err, test.err)
+		}
+	}
+}
+
+func TestValidateProxyProtocolTrusted(t *testing.T) {
+	srv := &Server{ENV: "test", ProxyProtocol: &ProxyProtocol{}}
+	if err := srv.Validate(); err == nil || !strings.Contains(err.Error(), "proxy_protocol.trusted") {
+		t.Fatalf("empty trusted: %v", err)
+	}
+	srv.ProxyProtocol.Trusted = []string{"10.0.0.0/8"}
+	if err := srv.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
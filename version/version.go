@@ -0,0 +1,80 @@
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/logger"
+)
+
+type (
+	Info struct {
+		Version   string `json:"version,omitempty"`
+		Commit    string `json:"commit,omitempty"`
+		BuildTime string `json:"build_time,omitempty"`
+		GoVersion string `json:"go_version"`
+	}
+)
+
+// 通过 ldflags 设置
+// go build -ldflags "-X github.com/otamoe/gin-server/version.Version=1.0.0 -X github.com/otamoe/gin-server/version.Commit=$(git rev-parse HEAD) -X github.com/otamoe/gin-server/version.BuildTime=$(date -u +%FT%TZ)"
+var (
+	Version   string
+	Commit    string
+	BuildTime string
+)
+
+var CONTEXT = "GIN.SERVER.VERSION"
+
+// Get ldflags 未设置时使用 module 版本
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+	if info.Version == "" {
+		if buildInfo, ok := debug.ReadBuildInfo(); ok && buildInfo.Main.Version != "" && buildInfo.Main.Version != "(devel)" {
+			info.Version = buildInfo.Main.Version
+		}
+	}
+	return info
+}
+
+// Middleware X-App-Version 响应头  日志字段
+func Middleware() gin.HandlerFunc {
+	info := Get()
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, info)
+		if info.Version != "" {
+			ctx.Header("X-App-Version", info.Version)
+		}
+		if val, ok := ctx.Get(logger.CONTEXT); ok && val != nil {
+			if log, ok := val.(*logger.Logger); ok {
+				if info.Version != "" {
+					log.Fields["version"] = info.Version
+				}
+				if info.Commit != "" {
+					log.Fields["commit"] = info.Commit
+				}
+			}
+		}
+		ctx.Next()
+	}
+}
+
+func Handler() http.Handler {
+	info := Get()
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		writer.Header().Set("Cache-Control", "no-store")
+		writer.WriteHeader(http.StatusOK)
+		if req.Method != http.MethodHead {
+			json.NewEncoder(writer).Encode(info)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/versioning"
+)
+
+type (
+	// Versioning 路径 /v2  Accept 参数  Api-Version 请求头
+	Versioning struct {
+		Default   string   `json:"default,omitempty"`
+		Supported []string `json:"supported,omitempty"`
+		Header    string   `json:"header,omitempty"`
+		Path      bool     `json:"path,omitempty"`
+	}
+)
+
+func (config *Versioning) init(server *Server, handler *Handler) {
+	if config.Header == "" {
+		config.Header = "Api-Version"
+	}
+}
+
+func (config *Versioning) config() versioning.Config {
+	return versioning.Config{
+		Default:   config.Default,
+		Supported: config.Supported,
+		Header:    config.Header,
+		Path:      config.Path,
+	}
+}
+
+func (config *Versioning) wrap(next http.Handler) http.Handler {
+	return versioning.Handler(config.config(), next)
+}
+
+func (config *Versioning) middleware() gin.HandlerFunc {
+	return versioning.Middleware(config.config())
+}
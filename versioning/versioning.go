@@ -0,0 +1,191 @@
+package versioning
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/cachecontrol"
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	Config struct {
+		// 没有指定版本时使用
+		Default string
+
+		// 为空时不检查
+		Supported []string
+
+		// 请求头  默认 Api-Version  为 - 时不使用
+		Header string
+
+		// Accept 的参数  application/json; version=2  或 application/vnd.xxx.v2+json
+		MediaParam string
+
+		// /v2/users 去掉前缀后路由  需要 Handler 在路由之前处理
+		Path bool
+	}
+
+	// Handlers 版本 => handler  没有的版本使用更低的最近版本
+	Handlers map[string]gin.HandlerFunc
+
+	contextKey struct{}
+)
+
+var CONTEXT = "GIN.SERVER.VERSIONING"
+
+var ErrVersion = &errs.Error{
+	Message:    "Unsupported API version",
+	Type:       "versioning",
+	Path:       "version",
+	StatusCode: http.StatusBadRequest,
+}
+
+var (
+	pathPattern  = regexp.MustCompile(`^/v(\d+(?:\.\d+)*)(/|$)`)
+	mediaPattern = regexp.MustCompile(`\.v(\d+(?:\.\d+)*)\+`)
+)
+
+// Handler Path 时去掉 /v2 前缀  版本保存在 request context
+func Handler(c Config, next http.Handler) http.Handler {
+	if !c.Path {
+		return next
+	}
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if matches := pathPattern.FindStringSubmatch(req.URL.Path); matches != nil {
+			u := *req.URL
+			u.Path = "/" + strings.TrimPrefix(req.URL.Path[len("/v"+matches[1]):], "/")
+			u.RawPath = ""
+			req2 := req.WithContext(context.WithValue(req.Context(), contextKey{}, matches[1]))
+			req2.URL = &u
+			req = req2
+		}
+		next.ServeHTTP(writer, req)
+	})
+}
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.Header == "" {
+		c.Header = "Api-Version"
+	}
+	if c.MediaParam == "" {
+		c.MediaParam = "version"
+	}
+	return func(ctx *gin.Context) {
+		version := Resolve(c, ctx.Request)
+		if version == "" {
+			version = Normalize(c.Default)
+		}
+		if version != "" && len(c.Supported) != 0 && !contains(c.Supported, version) {
+			e := ErrVersion.Clone()
+			e.Value = version
+			e.Params = map[string]interface{}{"supported": c.Supported}
+			ctx.Error(e)
+			ctx.Abort()
+			return
+		}
+		ctx.Set(CONTEXT, version)
+		if version != "" {
+			ctx.Header("Api-Version", version)
+		}
+		if c.Header != "-" {
+			cachecontrol.AddVary(ctx, c.Header)
+		}
+		cachecontrol.AddVary(ctx, "Accept")
+		ctx.Next()
+	}
+}
+
+// Resolve 路径  Accept  请求头  没有时为空
+func Resolve(c Config, req *http.Request) string {
+	if val, ok := req.Context().Value(contextKey{}).(string); ok && val != "" {
+		return Normalize(val)
+	}
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if val := params[c.MediaParam]; c.MediaParam != "" && val != "" {
+			return Normalize(val)
+		}
+		if matches := mediaPattern.FindStringSubmatch(mediaType); matches != nil {
+			return Normalize(matches[1])
+		}
+	}
+	if c.Header != "" && c.Header != "-" {
+		return Normalize(req.Header.Get(c.Header))
+	}
+	return ""
+}
+
+func Get(ctx *gin.Context) string {
+	return ctx.GetString(CONTEXT)
+}
+
+// Switch 按版本选择  没有完全匹配时使用不大于请求版本的最高版本  都没有时 404
+func Switch(handlers Handlers) gin.HandlerFunc {
+	var versions []string
+	normalized := map[string]gin.HandlerFunc{}
+	for version, handler := range handlers {
+		version = Normalize(version)
+		versions = append(versions, version)
+		normalized[version] = handler
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return Compare(versions[i], versions[j]) > 0
+	})
+	return func(ctx *gin.Context) {
+		version := Get(ctx)
+		for _, val := range versions {
+			if version == "" || Compare(val, version) <= 0 {
+				normalized[val](ctx)
+				return
+			}
+		}
+		ctx.AbortWithStatus(http.StatusNotFound)
+	}
+}
+
+// Normalize 去掉 v 前缀  v2 => 2
+func Normalize(version string) string {
+	version = strings.TrimSpace(version)
+	return strings.TrimPrefix(strings.TrimPrefix(version, "v"), "V")
+}
+
+// Compare 按 . 分段比较数字
+func Compare(a string, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func contains(versions []string, version string) bool {
+	for _, val := range versions {
+		if Compare(Normalize(val), version) == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/otamoe/gin-server/health"
+	"github.com/otamoe/gin-server/warmup"
+)
+
+type (
+	// Warmup 开始监听后执行 warmup.Register 注册的函数  完成前 readyz 返回 503
+	Warmup struct {
+		// 只执行这些  为空执行全部
+		Names []string `json:"names,omitempty"`
+
+		// 超时后不再等待  readyz 变为就绪
+		Timeout time.Duration `json:"timeout,omitempty"`
+
+		Disabled bool `json:"disabled,omitempty"`
+	}
+)
+
+func (config *Warmup) init(server *Server, handler *Handler) {
+	if config.Timeout == 0 {
+		config.Timeout = time.Minute
+	}
+}
+
+// RunWarmup names 为空时使用配置的 Names  返回失败的
+func (server *Server) RunWarmup(ctx context.Context, names []string) map[string]error {
+	c := warmup.Config{
+		Names: names,
+	}
+	if server.Warmup != nil && len(names) == 0 {
+		c.Names = server.Warmup.Names
+	}
+	c.Logger = server.Logger.Get()
+	c.RedisPrefix = server.redisPrefix()
+	c.MongoPrefix = server.mongoPrefix()
+	if server.mongoProvider() != nil {
+		// 连接失败时 Mongo 为空  需要 mongo 的 warmer 失败
+		if session, err := server.mongoSession(); err != nil {
+			c.Logger.Warnf("[WARMUP] mongo %s", err)
+		} else {
+			c.Mongo = session
+			defer c.Mongo.Close()
+		}
+	}
+	if provider := server.redisProvider(); provider != nil {
+		c.Redis = provider.Get()
+		defer c.Redis.Close()
+	}
+	return warmup.Run(ctx, c)
+}
+
+// warmup 后台执行  失败只记录日志
+func (server *Server) warmup() {
+	if server.Warmup == nil || server.Warmup.Disabled {
+		return
+	}
+	health.Default.SetWarming(true)
+	go func() {
+		defer health.Default.SetWarming(false)
+		ctx, cancel := context.WithTimeout(context.Background(), server.Warmup.Timeout)
+		defer cancel()
+		start := time.Now()
+		errs := server.RunWarmup(ctx, nil)
+		server.Logger.Get().Infof("[WARMUP] done %s, %d failed", time.Since(start), len(errs))
+	}()
+}
@@ -0,0 +1,144 @@
+package warmup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// Warmer 预加载 redis key  填充内存缓存  编译模板等  失败只影响首批请求的延迟
+	Warmer func(ctx context.Context, env *Env) error
+
+	Env struct {
+		Mongo  *mgo.Session
+		Redis  *redis.Client
+		Logger *logrus.Logger
+
+		// redis key 前缀  mongo 集合前缀
+		RedisPrefix string
+		MongoPrefix string
+	}
+
+	Config struct {
+		Env
+
+		// 只执行这些  为空执行全部
+		Names []string
+	}
+
+	warmer struct {
+		name  string
+		order int
+		run   Warmer
+	}
+)
+
+var (
+	mutex    sync.Mutex
+	registry = map[string]*warmer{}
+)
+
+// Register order 小的先执行  相同 order 并发执行
+func Register(name string, order int, run Warmer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("Warmup: " + name + " has exists")
+	}
+	registry[name] = &warmer{
+		name:  name,
+		order: order,
+		run:   run,
+	}
+}
+
+// Names 按执行顺序
+func Names() (names []string) {
+	for _, val := range sorted() {
+		names = append(names, val.name)
+	}
+	return
+}
+
+// Run 全部执行  返回失败的  ctx 结束时未开始的不再执行
+func Run(ctx context.Context, c Config) (errs map[string]error) {
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	only := map[string]bool{}
+	for _, name := range c.Names {
+		only[name] = true
+	}
+	errs = map[string]error{}
+	var stage []*warmer
+	for _, val := range sorted() {
+		if len(only) != 0 && !only[val.name] {
+			continue
+		}
+		if len(stage) != 0 && stage[0].order != val.order {
+			run(ctx, &c, stage, errs)
+			stage = nil
+		}
+		stage = append(stage, val)
+	}
+	run(ctx, &c, stage, errs)
+	return
+}
+
+func run(ctx context.Context, c *Config, stage []*warmer, errs map[string]error) {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, val := range stage {
+		if err := ctx.Err(); err != nil {
+			errs[val.name] = err
+			continue
+		}
+		wg.Add(1)
+		go func(val *warmer) {
+			defer wg.Done()
+			start := time.Now()
+			err := call(ctx, &c.Env, val)
+			if err != nil {
+				c.Logger.Errorf("[WARMUP] %s: %s", val.name, err)
+				mutex.Lock()
+				errs[val.name] = err
+				mutex.Unlock()
+				return
+			}
+			c.Logger.Infof("[WARMUP] %s %s", val.name, time.Since(start))
+		}(val)
+	}
+	wg.Wait()
+}
+
+// call panic 不影响启动
+func call(ctx context.Context, env *Env, val *warmer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return val.run(ctx, env)
+}
+
+func sorted() (values []*warmer) {
+	mutex.Lock()
+	for _, val := range registry {
+		values = append(values, val)
+	}
+	mutex.Unlock()
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].order != values[j].order {
+			return values[i].order < values[j].order
+		}
+		return values[i].name < values[j].name
+	})
+	return
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/otamoe/gin-server/webhooks"
+)
+
+type (
+	// Webhooks 通过 jobs 投递  endpoint 和投递记录保存在 mongo
+	Webhooks struct {
+		Timeout    time.Duration `json:"timeout,omitempty"`
+		MaxRetries int           `json:"max_retries,omitempty"`
+
+		// jobs 的队列
+		Queue string `json:"queue,omitempty"`
+
+		// 允许投递到 loopback 内网地址  只用于开发和测试
+		AllowPrivate bool `json:"allow_private,omitempty"`
+
+		// 每次投递之后
+		OnDelivery func(delivery *webhooks.Delivery) `json:"-"`
+
+		webhooks *webhooks.Webhooks
+	}
+)
+
+func (config *Webhooks) init(server *Server, handler *Handler) {
+	if config.webhooks != nil {
+		return
+	}
+	if server.Jobs == nil {
+		panic("Webhooks: jobs is empty")
+	}
+	provider := server.mongoProvider()
+	if provider == nil {
+		panic("Webhooks: mongo is empty")
+	}
+	config.webhooks = webhooks.New(webhooks.Config{
+		Queue: server.Jobs.Get(),
+		Session: func() *mgo.Session {
+			return provider.Get()
+		},
+		Prefix:       server.mongoPrefix(),
+		Timeout:      config.Timeout,
+		MaxRetries:   config.MaxRetries,
+		JobQueue:     config.Queue,
+		AllowPrivate: config.AllowPrivate,
+		Logger:       server.Logger.Get(),
+		OnDelivery:   config.OnDelivery,
+	})
+}
+
+func (config *Webhooks) Get() *webhooks.Webhooks {
+	return config.webhooks
+}
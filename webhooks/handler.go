@@ -0,0 +1,141 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+var CONTEXT = "GIN.SERVER.WEBHOOKS"
+
+var ErrNoWebhooks = errors.New("Webhooks: middleware is not used")
+
+func Middleware(webhooks *Webhooks) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT, webhooks)
+		ctx.Next()
+	}
+}
+
+func Get(ctx *gin.Context) *Webhooks {
+	if val, ok := ctx.Get(CONTEXT); ok && val != nil {
+		return val.(*Webhooks)
+	}
+	return nil
+}
+
+// Publish 在 handler 中使用
+func Publish(ctx *gin.Context, event string, payload interface{}) ([]*Delivery, error) {
+	webhooks := Get(ctx)
+	if webhooks == nil {
+		return nil, ErrNoWebhooks
+	}
+	return webhooks.Publish(event, payload)
+}
+
+// Handler 管理 endpoint 和投递记录  需要自己加权限中间件
+func Handler(group gin.IRoutes, webhooks *Webhooks) {
+	abort := func(ctx *gin.Context, err error) {
+		ctx.Error(err)
+		ctx.Abort()
+	}
+
+	group.GET("/events", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, Events())
+	})
+
+	group.GET("/endpoints", func(ctx *gin.Context) {
+		session := webhooks.config.Session()
+		defer session.Close()
+		var endpoints []*Endpoint
+		if err := session.DB("").C(webhooks.collection(EndpointModel)).Find(nil).Sort("-_id").Select(bson.M{"secret": 0}).All(&endpoints); err != nil {
+			abort(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, endpoints)
+	})
+
+	// 只有创建时返回 secret
+	group.POST("/endpoints", func(ctx *gin.Context) {
+		endpoint := &Endpoint{}
+		if err := ctx.ShouldBindJSON(endpoint); err != nil {
+			abort(ctx, err)
+			return
+		}
+		for _, event := range endpoint.Events {
+			if event != "*" && !registered(event) {
+				e := ErrEvent.Clone()
+				e.Value = event
+				abort(ctx, e)
+				return
+			}
+		}
+		endpoint.ID = bson.NewObjectId()
+		endpoint.Secret = NewSecret()
+		endpoint.CreatedAt = time.Now()
+
+		session := webhooks.config.Session()
+		defer session.Close()
+		if err := session.DB("").C(webhooks.collection(EndpointModel)).Insert(endpoint); err != nil {
+			abort(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusCreated, endpoint)
+	})
+
+	group.DELETE("/endpoints/:id", func(ctx *gin.Context) {
+		id := ctx.Param("id")
+		if !bson.IsObjectIdHex(id) {
+			abort(ctx, ErrNotFound)
+			return
+		}
+		session := webhooks.config.Session()
+		defer session.Close()
+		err := session.DB("").C(webhooks.collection(EndpointModel)).RemoveId(bson.ObjectIdHex(id))
+		if err == mgo.ErrNotFound {
+			err = ErrNotFound
+		}
+		if err != nil {
+			abort(ctx, err)
+			return
+		}
+		ctx.Status(http.StatusNoContent)
+	})
+
+	// ?endpoint=&status=&limit=
+	group.GET("/deliveries", func(ctx *gin.Context) {
+		query := bson.M{}
+		if id := ctx.Query("endpoint"); bson.IsObjectIdHex(id) {
+			query["endpoint"] = bson.ObjectIdHex(id)
+		}
+		if status := ctx.Query("status"); status != "" {
+			query["status"] = status
+		}
+		limit, _ := strconv.Atoi(ctx.Query("limit"))
+		if limit <= 0 || limit > 100 {
+			limit = 100
+		}
+		session := webhooks.config.Session()
+		defer session.Close()
+		var deliveries []*Delivery
+		if err := session.DB("").C(webhooks.collection(DeliveryModel)).Find(query).Sort("-created_at").Limit(limit).All(&deliveries); err != nil {
+			abort(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, deliveries)
+	})
+
+	group.POST("/deliveries/:id/redeliver", func(ctx *gin.Context) {
+		delivery, err := webhooks.Redeliver(ctx.Param("id"))
+		if err != nil {
+			abort(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusAccepted, delivery)
+	})
+}
@@ -0,0 +1,513 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/jobs"
+	"github.com/otamoe/gin-server/metrics"
+	mgoModel "github.com/otamoe/mgo-model"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	Config struct {
+		Queue *jobs.Queue
+
+		// 每次投递新建 session
+		Session func() *mgo.Session
+
+		// 集合前缀
+		Prefix string
+
+		// 每次请求的超时  默认 10s
+		Timeout time.Duration
+
+		// 默认 8  间隔由 jobs 的 Backoff 决定
+		MaxRetries int
+
+		// 为空时使用 jobs 的默认队列
+		JobQueue string
+
+		// 为空时使用的 client 不连接 loopback 内网 link-local 地址  设置后由调用方限制
+		Client *http.Client
+		Logger *logrus.Logger
+
+		// 允许默认 client 连接内网地址  只用于开发和测试
+		AllowPrivate bool
+
+		// 每次投递之后  用于推送到管理界面
+		OnDelivery func(delivery *Delivery)
+	}
+
+	Webhooks struct {
+		config Config
+
+		deliveries *metrics.Counter
+		duration   *metrics.Histogram
+	}
+
+	// Endpoint 订阅者  Events 为空或包含 * 时订阅全部
+	Endpoint struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    bson.ObjectId `json:"_id" bson:"_id"`
+		URL                   string        `json:"url" bson:"url" binding:"required,url"`
+		Secret                string        `json:"secret,omitempty" bson:"secret"`
+		Events                []string      `json:"events,omitempty" bson:"events,omitempty"`
+		Description           string        `json:"description,omitempty" bson:"description,omitempty"`
+		Disabled              bool          `json:"disabled,omitempty" bson:"disabled,omitempty"`
+		CreatedAt             time.Time     `json:"created_at" bson:"created_at"`
+	}
+
+	Delivery struct {
+		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
+		ID                    bson.ObjectId `json:"_id" bson:"_id"`
+		EndpointID            bson.ObjectId `json:"endpoint_id" bson:"endpoint"`
+		Event                 string        `json:"event" bson:"event"`
+		Payload               string        `json:"payload" bson:"payload"`
+		Status                string        `json:"status" bson:"status"`
+		Attempts              int           `json:"attempts" bson:"attempts"`
+		StatusCode            int           `json:"status_code,omitempty" bson:"status_code,omitempty"`
+		Response              string        `json:"response,omitempty" bson:"response,omitempty"`
+		Error                 string        `json:"error,omitempty" bson:"error,omitempty"`
+		CreatedAt             time.Time     `json:"created_at" bson:"created_at"`
+		UpdatedAt             time.Time     `json:"updated_at" bson:"updated_at"`
+	}
+
+	job struct {
+		DeliveryID string `json:"delivery_id"`
+	}
+)
+
+const (
+	StatusPending = "pending"
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+
+	// jobs 的名称
+	JOB = "webhooks.deliver"
+
+	// 响应最多保存
+	maxResponse = 1024
+)
+
+var (
+	EndpointModel = &mgoModel.Model{
+		Name:     "webhook_endpoints",
+		Document: &Endpoint{},
+		Indexs: []mgo.Index{
+			mgo.Index{
+				Key:        []string{"events"},
+				Background: true,
+			},
+		},
+	}
+	DeliveryModel = &mgoModel.Model{
+		Name:     "webhook_deliveries",
+		Document: &Delivery{},
+		Indexs: []mgo.Index{
+			mgo.Index{
+				Key:        []string{"endpoint", "-created_at"},
+				Background: true,
+			},
+		},
+	}
+
+	ErrNotFound = &errs.Error{
+		Message:    "Webhook not found",
+		Type:       "webhooks",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrEvent = &errs.Error{
+		Message:    "Unknown webhook event",
+		Type:       "webhooks",
+		Path:       "events",
+		StatusCode: http.StatusBadRequest,
+	}
+
+	// ErrGone 订阅者返回 410 时禁用  不再重试
+	ErrGone = errors.New("Webhooks: endpoint is gone")
+
+	// ErrScheme 只投递到 http https  不再重试
+	ErrScheme = errors.New("Webhooks: endpoint scheme must be http or https")
+
+	// ErrAddress 默认 client 拒绝连接的地址
+	ErrAddress = errors.New("Webhooks: endpoint address is not allowed")
+)
+
+var (
+	eventsMutex sync.RWMutex
+	events      = map[string]string{}
+)
+
+// RegisterEvent 注册事件类型  订阅时只能使用注册的事件
+func RegisterEvent(name string, description string) {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+	if _, ok := events[name]; ok {
+		panic("Webhooks: " + name + " has exists")
+	}
+	events[name] = description
+}
+
+// Events 事件名 => 说明
+func Events() map[string]string {
+	eventsMutex.RLock()
+	defer eventsMutex.RUnlock()
+	values := map[string]string{}
+	for name, description := range events {
+		values[name] = description
+	}
+	return values
+}
+
+func EventNames() (names []string) {
+	for name := range Events() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func registered(name string) bool {
+	eventsMutex.RLock()
+	defer eventsMutex.RUnlock()
+	_, ok := events[name]
+	return ok
+}
+
+// New 在 jobs 上注册投递的 handler
+func New(c Config) *Webhooks {
+	if c.Queue == nil {
+		panic("Webhooks: jobs queue is empty")
+	}
+	if c.Session == nil {
+		panic("Webhooks: mongo session is empty")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = time.Second * 10
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 8
+	}
+	if c.Client == nil {
+		c.Client = newClient(c.Timeout, c.AllowPrivate)
+	}
+	if c.Logger == nil {
+		c.Logger = logrus.StandardLogger()
+	}
+	webhooks := &Webhooks{
+		config:     c,
+		deliveries: metrics.Default.Counter("webhooks_deliveries_total", "Webhook delivery attempts.", "event", "status"),
+		duration:   metrics.Default.Histogram("webhooks_delivery_duration_seconds", "Webhook delivery latency.", nil, "event"),
+	}
+	c.Queue.Register(JOB, webhooks.deliver)
+	return webhooks
+}
+
+// newClient 连接时检查解析后的地址  重定向和 DNS rebinding 也经过检查  不使用环境变量的代理
+func newClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: time.Second * 30,
+	}
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || Blocked(ip) {
+				return ErrAddress
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConnsPerHost:   2,
+			IdleConnTimeout:       time.Second * 90,
+		},
+	}
+}
+
+// Blocked loopback 内网 link-local 多播和未指定的地址
+func Blocked(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		// 100.64.0.0/10 运营商 NAT  0.0.0.0/8
+		if (ip[0] == 100 && ip[1]&0xc0 == 64) || ip[0] == 0 {
+			return true
+		}
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// Sign hex(hmac-sha256(secret, id.timestamp.body))
+func Sign(secret string, id string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id + "." + strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 订阅者使用  tolerance 为 0 时不检查时间
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration) bool {
+	timestamp, err := strconv.ParseInt(header.Get("Webhook-Timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+	if tolerance > 0 {
+		d := time.Since(time.Unix(timestamp, 0))
+		if d > tolerance || d < -tolerance {
+			return false
+		}
+	}
+	signature := Sign(secret, header.Get("Webhook-Id"), timestamp, body)
+	return hmac.Equal([]byte(signature), []byte(header.Get("Webhook-Signature")))
+}
+
+// NewSecret 32 字节随机
+func NewSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Publish 给订阅的 endpoint 创建投递记录并入队
+func (webhooks *Webhooks) Publish(event string, payload interface{}) (deliveries []*Delivery, err error) {
+	if !registered(event) {
+		return nil, errors.New("Webhooks: event " + event + " is not registered")
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	session := webhooks.config.Session()
+	defer session.Close()
+	db := session.DB("")
+
+	var endpoints []*Endpoint
+	if err = db.C(webhooks.collection(EndpointModel)).Find(bson.M{
+		"disabled": bson.M{"$ne": true},
+		"$or": []bson.M{
+			bson.M{"events": bson.M{"$in": []string{event, "*"}}},
+			bson.M{"events": bson.M{"$exists": false}},
+			bson.M{"events": bson.M{"$size": 0}},
+		},
+	}).All(&endpoints); err != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, endpoint := range endpoints {
+		delivery := &Delivery{
+			ID:         bson.NewObjectId(),
+			EndpointID: endpoint.ID,
+			Event:      event,
+			Payload:    string(data),
+			Status:     StatusPending,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		if err = db.C(webhooks.collection(DeliveryModel)).Insert(delivery); err != nil {
+			return
+		}
+		if err = webhooks.enqueue(delivery); err != nil {
+			return
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return
+}
+
+// Redeliver 重新投递  不论之前的状态
+func (webhooks *Webhooks) Redeliver(id string) (delivery *Delivery, err error) {
+	session := webhooks.config.Session()
+	defer session.Close()
+	if delivery, err = webhooks.findDelivery(session.DB(""), id); err != nil {
+		return
+	}
+	delivery.Status = StatusPending
+	delivery.UpdatedAt = time.Now()
+	if err = session.DB("").C(webhooks.collection(DeliveryModel)).UpdateId(delivery.ID, bson.M{
+		"$set": bson.M{"status": delivery.Status, "updated_at": delivery.UpdatedAt},
+	}); err != nil {
+		return
+	}
+	err = webhooks.enqueue(delivery)
+	return
+}
+
+func (webhooks *Webhooks) enqueue(delivery *Delivery) error {
+	_, err := webhooks.config.Queue.Enqueue(JOB, &job{DeliveryID: delivery.ID.Hex()}, jobs.Options{
+		Queue:      webhooks.config.JobQueue,
+		MaxRetries: webhooks.config.MaxRetries,
+		Timeout:    webhooks.config.Timeout + time.Second*5,
+	})
+	return err
+}
+
+// deliver jobs 的 handler  返回错误时由 jobs 退避重试
+func (webhooks *Webhooks) deliver(ctx context.Context, j *jobs.Job) (err error) {
+	payload := &job{}
+	if err = j.Bind(payload); err != nil {
+		return
+	}
+
+	session := webhooks.config.Session()
+	defer session.Close()
+	db := session.DB("")
+
+	delivery, err := webhooks.findDelivery(db, payload.DeliveryID)
+	if err == ErrNotFound {
+		// 已删除
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	endpoint := &Endpoint{}
+	if err = db.C(webhooks.collection(EndpointModel)).FindId(delivery.EndpointID).One(endpoint); err == mgo.ErrNotFound || (err == nil && endpoint.Disabled) {
+		delivery.Error = "endpoint is disabled or removed"
+		webhooks.update(db, delivery, StatusFailed)
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	start := time.Now()
+	statusCode, response, err := webhooks.post(ctx, endpoint, delivery)
+	webhooks.duration.Observe(time.Since(start).Seconds(), delivery.Event)
+	delivery.Attempts++
+	delivery.StatusCode = statusCode
+	delivery.Response = response
+	delivery.Error = ""
+
+	switch {
+	case err == ErrScheme:
+		delivery.Error = err.Error()
+		webhooks.update(db, delivery, StatusFailed)
+		return nil
+	case err == ErrGone:
+		delivery.Error = err.Error()
+		db.C(webhooks.collection(EndpointModel)).UpdateId(endpoint.ID, bson.M{"$set": bson.M{"disabled": true}})
+		webhooks.config.Logger.Warnf("[WEBHOOKS] %s disabled %s", endpoint.ID.Hex(), endpoint.URL)
+		webhooks.update(db, delivery, StatusFailed)
+		return nil
+	case err != nil:
+		delivery.Error = err.Error()
+		status := StatusPending
+		if j.Attempts >= j.MaxRetries {
+			status = StatusFailed
+		}
+		webhooks.update(db, delivery, status)
+		return err
+	}
+	webhooks.update(db, delivery, StatusSuccess)
+	return nil
+}
+
+func (webhooks *Webhooks) post(ctx context.Context, endpoint *Endpoint, delivery *Delivery) (statusCode int, response string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, webhooks.config.Timeout)
+	defer cancel()
+
+	if u, e := url.Parse(endpoint.URL); e != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		err = ErrScheme
+		return
+	}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gin-server-webhooks")
+	req.Header.Set("Webhook-Id", delivery.ID.Hex())
+	req.Header.Set("Webhook-Event", delivery.Event)
+	req.Header.Set("Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("Webhook-Signature", Sign(endpoint.Secret, delivery.ID.Hex(), timestamp, body))
+
+	res, err := webhooks.config.Client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	data, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxResponse))
+	statusCode = res.StatusCode
+	response = string(data)
+
+	switch {
+	case statusCode == http.StatusGone:
+		err = ErrGone
+	case statusCode < 200 || statusCode >= 300:
+		err = errors.New("Webhooks: unexpected status " + strconv.Itoa(statusCode))
+	}
+	return
+}
+
+func (webhooks *Webhooks) update(db *mgo.Database, delivery *Delivery, status string) {
+	delivery.Status = status
+	delivery.UpdatedAt = time.Now()
+	webhooks.deliveries.Inc(delivery.Event, status)
+	if err := db.C(webhooks.collection(DeliveryModel)).UpdateId(delivery.ID, bson.M{
+		"$set": bson.M{
+			"status":      delivery.Status,
+			"attempts":    delivery.Attempts,
+			"status_code": delivery.StatusCode,
+			"response":    delivery.Response,
+			"error":       delivery.Error,
+			"updated_at":  delivery.UpdatedAt,
+		},
+	}); err != nil {
+		webhooks.config.Logger.Errorf("[WEBHOOKS] %s update %s", delivery.ID.Hex(), err)
+	}
+	if webhooks.config.OnDelivery != nil {
+		webhooks.config.OnDelivery(delivery)
+	}
+}
+
+func (webhooks *Webhooks) findDelivery(db *mgo.Database, id string) (delivery *Delivery, err error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrNotFound
+	}
+	delivery = &Delivery{}
+	if err = db.C(webhooks.collection(DeliveryModel)).FindId(bson.ObjectIdHex(id)).One(delivery); err == mgo.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return
+}
+
+func (webhooks *Webhooks) collection(model *mgoModel.Model) string {
+	return webhooks.config.Prefix + model.Name
+}
@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+type (
+	// Hub 按 room 广播  使用 redis 时多实例之间同步
+	Hub struct {
+		mutex sync.RWMutex
+		rooms map[string]map[*Conn]struct{}
+
+		redis  *redis.Client
+		prefix string
+		pubsub *redis.PubSub
+	}
+)
+
+func NewHub() *Hub {
+	return &Hub{
+		rooms: map[string]map[*Conn]struct{}{},
+	}
+}
+
+// UseRedis 通过 redis pub/sub 广播
+func (hub *Hub) UseRedis(client *redis.Client, prefix string) {
+	if prefix == "" {
+		prefix = "websocket"
+	}
+	hub.redis = client
+	hub.prefix = prefix
+	hub.pubsub = client.PSubscribe(prefix + ".*")
+	go func() {
+		for message := range hub.pubsub.Channel() {
+			if len(message.Payload) == 0 {
+				continue
+			}
+			room := strings.TrimPrefix(message.Channel, prefix+".")
+			hub.local(room, int(message.Payload[0]), []byte(message.Payload[1:]))
+		}
+	}()
+}
+
+func (hub *Hub) Join(room string, conn *Conn) {
+	hub.mutex.Lock()
+	conns, ok := hub.rooms[room]
+	if !ok {
+		conns = map[*Conn]struct{}{}
+		hub.rooms[room] = conns
+	}
+	_, exists := conns[conn]
+	conns[conn] = struct{}{}
+	hub.mutex.Unlock()
+
+	if !exists {
+		conn.OnClose(func(conn *Conn) {
+			hub.Leave(room, conn)
+		})
+	}
+}
+
+func (hub *Hub) Leave(room string, conn *Conn) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if conns, ok := hub.rooms[room]; ok {
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(hub.rooms, room)
+		}
+	}
+}
+
+func (hub *Hub) Broadcast(room string, messageType int, data []byte) error {
+	if hub.redis != nil {
+		payload := make([]byte, 0, len(data)+1)
+		payload = append(payload, byte(messageType))
+		payload = append(payload, data...)
+		return hub.redis.Publish(hub.prefix+"."+room, payload).Err()
+	}
+	hub.local(room, messageType, data)
+	return nil
+}
+
+func (hub *Hub) Count(room string) int {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return len(hub.rooms[room])
+}
+
+func (hub *Hub) Close() error {
+	if hub.pubsub != nil {
+		return hub.pubsub.Close()
+	}
+	return nil
+}
+
+func (hub *Hub) local(room string, messageType int, data []byte) {
+	hub.mutex.RLock()
+	conns := make([]*Conn, 0, len(hub.rooms[room]))
+	for conn := range hub.rooms[room] {
+		conns = append(conns, conn)
+	}
+	hub.mutex.RUnlock()
+	for _, conn := range conns {
+		conn.Send(messageType, data)
+	}
+}
@@ -0,0 +1,264 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type (
+	Config struct {
+		ReadBufferSize  int
+		WriteBufferSize int
+		// 单个消息最大长度
+		ReadLimit int64
+
+		// 超过没有收到 pong 断开
+		ReadTimeout  time.Duration
+		WriteTimeout time.Duration
+		PingInterval time.Duration
+
+		// 发送队列长度 满了断开
+		SendQueue int
+
+		// 允许的 Origin  为空只允许同 host
+		Origins      []string
+		Subprotocols []string
+		Compression  bool
+	}
+
+	Message struct {
+		Type int
+		Data []byte
+	}
+
+	Conn struct {
+		*websocket.Conn
+		config Config
+		send   chan Message
+
+		mutex   sync.Mutex
+		closed  bool
+		done    chan struct{}
+		onClose []func(conn *Conn)
+	}
+)
+
+const (
+	TextMessage   = websocket.TextMessage
+	BinaryMessage = websocket.BinaryMessage
+)
+
+var ErrClosed = errors.New("websocket: connection closed")
+var ErrQueueFull = errors.New("websocket: send queue full")
+
+var (
+	connsMutex sync.Mutex
+	conns      = map[*Conn]struct{}{}
+)
+
+// Upgrade 升级连接  返回后需要调用 conn.Read 读取消息
+func Upgrade(ctx *gin.Context, c Config) (conn *Conn, err error) {
+	if c.ReadBufferSize == 0 {
+		c.ReadBufferSize = 1024 * 4
+	}
+	if c.WriteBufferSize == 0 {
+		c.WriteBufferSize = 1024 * 4
+	}
+	if c.ReadLimit == 0 {
+		c.ReadLimit = 1024 * 64
+	}
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = time.Second * 60
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = time.Second * 10
+	}
+	if c.PingInterval == 0 {
+		c.PingInterval = c.ReadTimeout * 9 / 10
+	}
+	if c.SendQueue == 0 {
+		c.SendQueue = 256
+	}
+
+	upgrader := &websocket.Upgrader{
+		HandshakeTimeout:  c.WriteTimeout,
+		ReadBufferSize:    c.ReadBufferSize,
+		WriteBufferSize:   c.WriteBufferSize,
+		Subprotocols:      c.Subprotocols,
+		EnableCompression: c.Compression,
+		CheckOrigin:       c.checkOrigin,
+	}
+
+	// 不使用 compress 等中间件的 writer
+	var wsConn *websocket.Conn
+	if wsConn, err = upgrader.Upgrade(ctx.Writer, ctx.Request, nil); err != nil {
+		return
+	}
+
+	conn = &Conn{
+		Conn:   wsConn,
+		config: c,
+		send:   make(chan Message, c.SendQueue),
+		done:   make(chan struct{}),
+	}
+	conn.SetReadLimit(c.ReadLimit)
+	conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+	})
+
+	connsMutex.Lock()
+	conns[conn] = struct{}{}
+	connsMutex.Unlock()
+
+	go conn.writeLoop()
+	return
+}
+
+// Shutdown 关闭全部连接  server.Shutdown 时调用
+func Shutdown() {
+	connsMutex.Lock()
+	list := make([]*Conn, 0, len(conns))
+	for conn := range conns {
+		list = append(list, conn)
+	}
+	connsMutex.Unlock()
+
+	for _, conn := range list {
+		conn.CloseWith(websocket.CloseGoingAway, "server shutdown")
+	}
+}
+
+// Count 当前连接数
+func Count() int {
+	connsMutex.Lock()
+	defer connsMutex.Unlock()
+	return len(conns)
+}
+
+// Read 阻塞读取 直到连接关闭
+func (conn *Conn) Read(handler func(conn *Conn, message Message)) error {
+	defer conn.Close()
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return err
+			}
+			return nil
+		}
+		conn.SetReadDeadline(time.Now().Add(conn.config.ReadTimeout))
+		handler(conn, Message{Type: messageType, Data: data})
+	}
+}
+
+// Send 加入发送队列  不阻塞
+func (conn *Conn) Send(messageType int, data []byte) (err error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.closed {
+		return ErrClosed
+	}
+	select {
+	case conn.send <- Message{Type: messageType, Data: data}:
+	default:
+		// 客户端太慢
+		err = ErrQueueFull
+		go conn.CloseWith(websocket.CloseTryAgainLater, "send queue full")
+	}
+	return
+}
+
+func (conn *Conn) SendText(data string) error {
+	return conn.Send(websocket.TextMessage, []byte(data))
+}
+
+// OnClose 连接关闭时回调
+func (conn *Conn) OnClose(fn func(conn *Conn)) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.onClose = append(conn.onClose, fn)
+}
+
+func (conn *Conn) Close() error {
+	return conn.CloseWith(websocket.CloseNormalClosure, "")
+}
+
+func (conn *Conn) CloseWith(code int, text string) error {
+	conn.mutex.Lock()
+	if conn.closed {
+		conn.mutex.Unlock()
+		return nil
+	}
+	conn.closed = true
+	close(conn.send)
+	onClose := conn.onClose
+	conn.mutex.Unlock()
+
+	connsMutex.Lock()
+	delete(conns, conn)
+	connsMutex.Unlock()
+
+	// 等待队列发送完
+	select {
+	case <-conn.done:
+	case <-time.After(conn.config.WriteTimeout):
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(conn.config.WriteTimeout))
+	err := conn.Conn.Close()
+
+	for _, fn := range onClose {
+		fn(conn)
+	}
+	return err
+}
+
+func (conn *Conn) writeLoop() {
+	ticker := time.NewTicker(conn.config.PingInterval)
+	defer func() {
+		ticker.Stop()
+		close(conn.done)
+	}()
+	for {
+		select {
+		case message, ok := <-conn.send:
+			if !ok {
+				return
+			}
+			// 每次写入重新设置 不受 http server WriteTimeout 影响
+			conn.SetWriteDeadline(time.Now().Add(conn.config.WriteTimeout))
+			if err := conn.WriteMessage(message.Type, message.Data); err != nil {
+				go conn.Conn.Close()
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(conn.config.WriteTimeout)); err != nil {
+				go conn.Conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c Config) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(c.Origins) == 0 {
+		index := strings.Index(origin, "://")
+		return index != -1 && strings.EqualFold(origin[index+3:], req.Host)
+	}
+	for _, val := range c.Origins {
+		if val == "*" || strings.EqualFold(val, origin) {
+			return true
+		}
+	}
+	return false
+}